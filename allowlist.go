@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// AllowlistEntry is a single guest exception. Either Username or Email
+// identifies the guest; Expires is optional (YYYY-MM-DD). Ticket is an
+// optional http(s) link to the approval record (e.g. Jira, ServiceNow).
+type AllowlistEntry struct {
+	Username      string `yaml:"username"`
+	Email         string `yaml:"email"`
+	Justification string `yaml:"justification"`
+	Expires       string `yaml:"expires"`
+	Ticket        string `yaml:"ticket"`
+
+	expiresAt *time.Time
+}
+
+// Allowlist holds guests that should be marked "Excepted" rather than flagged.
+type Allowlist struct {
+	Entries []AllowlistEntry `yaml:"exceptions"`
+}
+
+// LoadAllowlist reads and validates an allowlist file.
+func LoadAllowlist(path string) (*Allowlist, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAllowlist(data)
+}
+
+// ParseAllowlist parses allowlist YAML (or JSON) content.
+func ParseAllowlist(data []byte) (*Allowlist, error) {
+	var a Allowlist
+	if err := yaml.UnmarshalStrict(data, &a); err != nil {
+		return nil, err
+	}
+	for i := range a.Entries {
+		e := &a.Entries[i]
+		if e.Username == "" && e.Email == "" {
+			return nil, fmt.Errorf("entry %d: username or email is required", i+1)
+		}
+		if e.Expires != "" {
+			t, err := time.Parse("2006-01-02", e.Expires)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: invalid expires date %q (use YYYY-MM-DD)", i+1, e.Expires)
+			}
+			e.expiresAt = &t
+		}
+		if e.Ticket != "" {
+			u, err := url.Parse(e.Ticket)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("entry %d: invalid ticket URL %q (use an http or https link)", i+1, e.Ticket)
+			}
+		}
+	}
+	return &a, nil
+}
+
+// Match returns the entry matching the given username or email
+// (case-insensitive), or nil if there is none. A nil Allowlist matches nothing.
+func (a *Allowlist) Match(username, email string) *AllowlistEntry {
+	if a == nil {
+		return nil
+	}
+	for i := range a.Entries {
+		e := &a.Entries[i]
+		if e.Username != "" && strings.EqualFold(e.Username, username) {
+			return e
+		}
+		if e.Email != "" && strings.EqualFold(e.Email, email) {
+			return e
+		}
+	}
+	return nil
+}
+
+// ExpiresAt returns the end of the exception's validity, or nil if it never expires.
+func (e *AllowlistEntry) ExpiresAt() *time.Time {
+	return e.expiresAt
+}
+
+// ExpiredAt reports whether the exception has lapsed at the given time.
+// An entry is valid through the whole of its expiry date.
+func (e *AllowlistEntry) ExpiredAt(now time.Time) bool {
+	if e.expiresAt == nil {
+		return false
+	}
+	return !now.Before(e.expiresAt.AddDate(0, 0, 1))
+}
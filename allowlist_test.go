@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const sampleAllowlist = `
+exceptions:
+  - username: jane.contractor
+    justification: Long-term contractor, approved by CISO
+  - email: Vendor@Partner.com
+    justification: Quarterly vendor review
+    expires: 2025-06-30
+`
+
+func TestParseAllowlist(t *testing.T) {
+	a, err := ParseAllowlist([]byte(sampleAllowlist))
+	if err != nil {
+		t.Fatalf("ParseAllowlist error: %v", err)
+	}
+	if len(a.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(a.Entries))
+	}
+	if a.Entries[0].ExpiresAt() != nil {
+		t.Error("first entry should not expire")
+	}
+	want := time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)
+	if got := a.Entries[1].ExpiresAt(); got == nil || !got.Equal(want) {
+		t.Errorf("expires = %v, want %v", got, want)
+	}
+}
+
+func TestParseAllowlist_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"missing identity", "exceptions:\n  - justification: nobody\n"},
+		{"bad date", "exceptions:\n  - username: jane\n    expires: 30/06/2025\n"},
+		{"unknown field", "exceptions:\n  - username: jane\n    expiry: 2025-06-30\n"},
+		{"ticket without scheme", "exceptions:\n  - username: jane\n    ticket: jira.example.com/browse/SEC-1\n"},
+		{"ticket not http", "exceptions:\n  - username: jane\n    ticket: ftp://tickets.example.com/1\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseAllowlist([]byte(tt.input)); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestAllowlistMatch(t *testing.T) {
+	a, err := ParseAllowlist([]byte(sampleAllowlist))
+	if err != nil {
+		t.Fatalf("ParseAllowlist error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		username  string
+		email     string
+		wantMatch bool
+	}{
+		{"username match", "jane.contractor", "jane@external.com", true},
+		{"username case-insensitive", "Jane.Contractor", "", true},
+		{"email case-insensitive", "v.ndor", "vendor@partner.com", true},
+		{"no match", "bob.smith", "bob@example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := a.Match(tt.username, tt.email) != nil
+			if got != tt.wantMatch {
+				t.Errorf("Match(%q, %q) = %v, want %v", tt.username, tt.email, got, tt.wantMatch)
+			}
+		})
+	}
+
+	var nilList *Allowlist
+	if nilList.Match("jane.contractor", "") != nil {
+		t.Error("nil allowlist should match nothing")
+	}
+}
+
+func TestAllowlistEntryExpiredAt(t *testing.T) {
+	a, err := ParseAllowlist([]byte(sampleAllowlist))
+	if err != nil {
+		t.Fatalf("ParseAllowlist error: %v", err)
+	}
+	entry := &a.Entries[1]
+
+	tests := []struct {
+		name        string
+		now         time.Time
+		wantExpired bool
+	}{
+		{"before expiry", time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC), false},
+		{"last moment of expiry date", time.Date(2025, 6, 30, 23, 59, 59, 0, time.UTC), false},
+		{"day after expiry", time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := entry.ExpiredAt(tt.now); got != tt.wantExpired {
+				t.Errorf("ExpiredAt(%v) = %v, want %v", tt.now, got, tt.wantExpired)
+			}
+		})
+	}
+
+	if a.Entries[0].ExpiredAt(time.Now()) {
+		t.Error("entry without expiry should never expire")
+	}
+}
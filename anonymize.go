@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Anonymizer replaces identifying values in a report with stable
+// pseudonyms, so a redacted report keeps its real structure: the same team
+// is the same pseudonym everywhere it appears. The mapping is also used to
+// redact the run's log output.
+type Anonymizer struct {
+	pseudonyms map[string]map[string]string // kind → original → pseudonym
+	counts     map[string]int
+	originals  map[string]string // every original → its pseudonym, for Redact
+}
+
+// NewAnonymizer returns an Anonymizer with no mappings yet.
+func NewAnonymizer() *Anonymizer {
+	return &Anonymizer{
+		pseudonyms: make(map[string]map[string]string),
+		counts:     make(map[string]int),
+		originals:  make(map[string]string),
+	}
+}
+
+// pseudonym returns the pseudonym of original as a kind, numbering new ones
+// in order of first appearance with format. Empty values stay empty.
+func (a *Anonymizer) pseudonym(kind, format, original string) string {
+	if original == "" {
+		return ""
+	}
+	if p, ok := a.pseudonyms[kind][original]; ok {
+		return p
+	}
+	a.counts[kind]++
+	p := fmt.Sprintf(format, a.counts[kind])
+	a.assign(kind, original, p)
+	return p
+}
+
+// assign records p as the pseudonym of original as a kind.
+func (a *Anonymizer) assign(kind, original, p string) {
+	if original == "" {
+		return
+	}
+	if a.pseudonyms[kind] == nil {
+		a.pseudonyms[kind] = make(map[string]string)
+	}
+	a.pseudonyms[kind][original] = p
+	a.originals[original] = p
+}
+
+func (a *Anonymizer) username(s string) string { return a.pseudonym("user", "guest-%03d", s) }
+func (a *Anonymizer) email(s string) string {
+	return a.pseudonym("email", "previous-%03d@example.invalid", strings.ToLower(s))
+}
+func (a *Anonymizer) team(s string) string    { return a.pseudonym("team", "Team %02d", s) }
+func (a *Anonymizer) channel(s string) string { return a.pseudonym("channel", "Channel %03d", s) }
+func (a *Anonymizer) ip(s string) string      { return a.pseudonym("ip", "192.0.2.%d", s) }
+func (a *Anonymizer) owner(s string) string   { return a.pseudonym("owner", "Owner %02d", s) }
+
+// AddServer registers the server URL and host name for redaction.
+func (a *Anonymizer) AddServer(serverURL string) {
+	if serverURL == "" {
+		return
+	}
+	a.originals[NormalizeURL(serverURL)] = "https://mattermost.example.com"
+	if u, err := url.Parse(NormalizeURL(serverURL)); err == nil && u.Hostname() != "" {
+		a.originals[u.Hostname()] = "mattermost.example.com"
+	}
+}
+
+// AddNames registers values the user typed, such as --team and --channel,
+// for redaction. They may appear in logs without appearing in the report,
+// and may be a name rather than the display name the report uses, so they
+// get a placeholder rather than a pseudonym.
+func (a *Anonymizer) AddNames(teams, channels []string) {
+	for _, team := range teams {
+		if team != "" {
+			a.originals[team] = "<team>"
+		}
+	}
+	for _, channel := range channels {
+		if channel != "" {
+			a.originals[channel] = "<channel>"
+		}
+	}
+}
+
+// Result anonymizes result in place. Dates, statuses, counts and channel
+// types are kept; names, emails, IDs, IP addresses and free text are
+// replaced. Guests are numbered in report order.
+func (a *Anonymizer) Result(result *AuditResult) {
+	// Number every guest first, so a guest's previous username or email
+	// that is another guest's current one maps to the same pseudonym. A
+	// guest's display name, nickname and email share their username's number.
+	for _, g := range result.Guests {
+		p := a.username(g.Username)
+		n := strings.TrimPrefix(p, "guest-")
+		a.assign("email", strings.ToLower(g.Email), p+"@example.invalid")
+		a.assign("display", g.DisplayName, "Guest "+n)
+		a.assign("nickname", g.Nickname, "Nickname "+n)
+	}
+	// Guests removed since the last run are numbered after the current ones
+	if result.Changes != nil {
+		for _, r := range result.Changes.Removed {
+			p := a.username(r.Username)
+			a.assign("email", strings.ToLower(r.Email), p+"@example.invalid")
+			a.assign("display", r.DisplayName, "Guest "+strings.TrimPrefix(p, "guest-"))
+		}
+	}
+	for i := range result.Guests {
+		g := &result.Guests[i]
+		g.Username = a.username(g.Username)
+		g.Email = a.email(g.Email)
+		g.DisplayName = a.pseudonyms["display"][g.DisplayName]
+		g.Nickname = a.pseudonyms["nickname"][g.Nickname]
+		g.UserID = ""
+		for j := range g.Teams {
+			g.Teams[j] = TeamInfo{DisplayName: a.team(g.Teams[j].DisplayName), Archived: g.Teams[j].Archived}
+		}
+		for j := range g.Channels {
+			ch := &g.Channels[j]
+			ch.ID = ""
+			ch.TeamName = a.team(ch.TeamName)
+			ch.ChannelName = a.channel(ch.ChannelName)
+		}
+		for j, u := range g.PreviousUsernames {
+			g.PreviousUsernames[j] = a.username(u)
+		}
+		for j, e := range g.PreviousEmails {
+			g.PreviousEmails[j] = a.email(e)
+		}
+		for j := range g.Boards {
+			g.Boards[j] = ResourceInfo{TeamName: a.team(g.Boards[j].TeamName), Name: a.pseudonym("board", "Board %02d", g.Boards[j].Name)}
+		}
+		for j := range g.Playbooks {
+			g.Playbooks[j] = ResourceInfo{TeamName: a.team(g.Playbooks[j].TeamName), Name: a.pseudonym("playbook", "Playbook %02d", g.Playbooks[j].Name)}
+		}
+		for j := range g.GuestOnlyChannels {
+			g.GuestOnlyChannels[j] = ResourceInfo{TeamName: a.team(g.GuestOnlyChannels[j].TeamName), Name: a.channel(g.GuestOnlyChannels[j].Name)}
+		}
+		for j := range g.Groups {
+			g.Groups[j] = a.pseudonym("group", "Group %02d", g.Groups[j])
+		}
+		for j := range g.DirectChannels {
+			g.DirectChannels[j] = ResourceInfo{TeamName: a.team(g.DirectChannels[j].TeamName), Name: a.channel(g.DirectChannels[j].Name)}
+		}
+		for j := range g.ElevatedRoles {
+			r := &g.ElevatedRoles[j]
+			r.TeamName = a.team(r.TeamName)
+			if r.ChannelName != "" {
+				r.ChannelName = a.channel(r.ChannelName)
+			}
+		}
+		for j, ip := range g.SharedSessionIPs {
+			g.SharedSessionIPs[j] = a.ip(ip)
+		}
+		// An unnamed audited action is a request path, which may hold IDs
+		if strings.HasPrefix(g.LastAuditedAction, "/") {
+			g.LastAuditedAction = "other"
+		}
+		if g.ExceptionJustification != "" {
+			g.ExceptionJustification = "[redacted]"
+		}
+		if g.ExceptionTicket != "" {
+			g.ExceptionTicket = "[redacted]"
+		}
+		if g.Position != "" {
+			g.Position = "[redacted]"
+		}
+		for name, value := range g.ProfileFields {
+			if value == "" {
+				continue
+			}
+			// The owner is replaced consistently, so guests stay grouped
+			if result.OwnerField != "" && strings.EqualFold(name, result.OwnerField) {
+				g.ProfileFields[name] = a.owner(strings.TrimSpace(value))
+			} else {
+				g.ProfileFields[name] = "[redacted]"
+			}
+		}
+	}
+
+	if result.Changes != nil {
+		for i, name := range result.Changes.New {
+			result.Changes.New[i] = a.username(name)
+		}
+		for i := range result.Changes.Removed {
+			r := &result.Changes.Removed[i]
+			r.Username = a.username(r.Username)
+			r.Email = a.email(r.Email)
+			r.DisplayName = a.pseudonyms["display"][r.DisplayName]
+			for j, t := range r.Teams {
+				r.Teams[j] = a.team(t)
+			}
+		}
+	}
+
+	// Free text last, once every name it may mention is known
+	for i := range result.Guests {
+		g := &result.Guests[i]
+		for j := range g.Errors {
+			e := &g.Errors[j]
+			if e.Team != "" {
+				e.Team = a.team(e.Team)
+			}
+			e.Message = a.Redact(e.Message)
+		}
+		g.Checksum = GuestChecksum(*g)
+	}
+
+	byTeam := make(map[string]*TeamSummary, len(result.Summary.ByTeam))
+	for name, ts := range result.Summary.ByTeam {
+		byTeam[a.team(name)] = ts
+	}
+	result.Summary.ByTeam = byTeam
+	if r := result.Summary.Owners; r != nil {
+		byOwner := make(map[string]*TeamSummary, len(r.ByOwner))
+		for name, ts := range r.ByOwner {
+			byOwner[a.owner(name)] = ts
+		}
+		r.ByOwner = byOwner
+	}
+
+	for i := range result.ExtraFields {
+		result.ExtraFields[i].Value = "[redacted]"
+	}
+
+	if m := result.Metadata; m != nil {
+		redacted := *m
+		redacted.ServerURL = a.Redact(m.ServerURL)
+		if m.RunBy != "" {
+			redacted.RunBy = "[redacted]"
+		}
+		redacted.Filters = make([]Filter, len(m.Filters))
+		for i, f := range m.Filters {
+			redacted.Filters[i] = Filter{f.Name, a.Redact(f.Value)}
+		}
+		result.Metadata = &redacted
+	}
+}
+
+var (
+	redactEmail = regexp.MustCompile(`[\w.+-]+@[\w-]+(\.[\w-]+)+`)
+	redactIPv4  = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`)
+	redactID    = regexp.MustCompile(`\b[a-z0-9]{26}\b`) // Mattermost IDs
+)
+
+// Redact replaces every known original in s with its pseudonym, then any
+// remaining email address, IPv4 address or Mattermost ID with a placeholder.
+// Values shorter than three characters are left alone, as they would match
+// inside ordinary words.
+func (a *Anonymizer) Redact(s string) string {
+	if s == "" {
+		return s
+	}
+	originals := make([]string, 0, len(a.originals))
+	for o := range a.originals {
+		if len(o) >= 3 {
+			originals = append(originals, o)
+		}
+	}
+	// Longest first, so "Engineering EU" is not half-replaced as "Engineering"
+	sort.Slice(originals, func(i, j int) bool {
+		if len(originals[i]) != len(originals[j]) {
+			return len(originals[i]) > len(originals[j])
+		}
+		return originals[i] < originals[j]
+	})
+	pairs := make([]string, 0, 2*len(originals))
+	for _, o := range originals {
+		pairs = append(pairs, o, a.originals[o])
+	}
+	s = strings.NewReplacer(pairs...).Replace(s)
+
+	s = redactEmail.ReplaceAllStringFunc(s, func(m string) string {
+		if strings.HasSuffix(m, "@example.invalid") {
+			return m
+		}
+		return "<email>"
+	})
+	s = redactIPv4.ReplaceAllStringFunc(s, func(m string) string {
+		if strings.HasPrefix(m, "192.0.2.") {
+			return m
+		}
+		return "<ip>"
+	})
+	return redactID.ReplaceAllString(s, "<id>")
+}
+
+// captureStderr sends everything written to os.Stderr into a buffer until
+// the returned function is called. That function restores os.Stderr and
+// writes the captured output through redact. Logs are held back rather than
+// streamed because names are only known, and redactable, once the report is
+// complete.
+func captureStderr(redact func(string) string) (func(), error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	orig := os.Stderr
+	os.Stderr = w
+
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&buf, r)
+		close(done)
+	}()
+
+	return func() {
+		os.Stderr = orig
+		w.Close()
+		<-done
+		r.Close()
+		io.WriteString(orig, redact(buf.String()))
+	}, nil
+}
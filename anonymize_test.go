@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func anonymizeResult() *AuditResult {
+	result := &AuditResult{
+		Guests: []GuestRecord{
+			{
+				Username: "john.contractor", DisplayName: "John Smith", Nickname: "JS", Email: "John@Partner.com", UserID: "abc",
+				Teams:    []TeamInfo{{ID: "t1", DisplayName: "Engineering"}},
+				Channels: []ChannelInfo{{ID: "c1", TeamName: "Engineering", ChannelName: "Project Apollo", Type: ChannelTypePrivate}},
+				Boards:   []ResourceInfo{{TeamName: "Engineering", Name: "Apollo roadmap"}},
+
+				PreviousUsernames: []string{"jane.partner"},
+				PreviousEmails:    []string{"john@old-partner.com"},
+				SharedSessionIPs:  []string{"203.0.113.7", "198.51.100.20"},
+				LastAuditedAction: "/api/v4/users/abc/preferences",
+
+				Excepted: true, ExceptionJustification: "Apollo audit until March", ExceptionTicket: "SEC-1234",
+			},
+			{
+				Username: "jane.partner", DisplayName: "Jane Doe", Email: "jane@partner.com",
+				Teams:  []TeamInfo{{ID: "t1", DisplayName: "Engineering"}, {ID: "t2", DisplayName: "Sales"}},
+				Failed: true,
+				Errors: []LookupError{{Stage: LookupChannels, Team: "Sales", Message: "jane@partner.com forbidden", HTTPStatus: 403}},
+			},
+		},
+		Summary:     AuditSummary{ByTeam: map[string]*TeamSummary{"Engineering": {TotalGuests: 2}, "Sales": {TotalGuests: 1}}},
+		ExtraFields: []ExtraField{{Name: "instance", Value: "acme-prod"}},
+		Changes: &GuestChanges{
+			New:     []string{"jane.partner"},
+			Removed: []RemovedGuest{{Username: "old.partner", DisplayName: "Old Partner", Email: "old@partner.com", Teams: []string{"Sales"}}},
+		},
+	}
+	return result
+}
+
+func TestAnonymizer_Result(t *testing.T) {
+	result := anonymizeResult()
+	before := result.Guests[0].Checksum
+	NewAnonymizer().Result(result)
+
+	g := result.Guests[0]
+	if g.Username != "guest-001" || g.DisplayName != "Guest 001" || g.Nickname != "Nickname 001" || g.Email != "guest-001@example.invalid" || g.UserID != "" {
+		t.Errorf("unexpected identity: %+v", g)
+	}
+	if g.Teams[0] != (TeamInfo{DisplayName: "Team 01"}) || g.Channels[0].TeamName != "Team 01" || g.Channels[0].ChannelName != "Channel 001" || g.Channels[0].ID != "" {
+		t.Errorf("unexpected teams/channels: %+v %+v", g.Teams, g.Channels)
+	}
+	if g.Channels[0].Type != ChannelTypePrivate || !g.Excepted {
+		t.Error("structure (channel type, status) should be kept")
+	}
+	// A previous username that is another guest's current one keeps that guest's pseudonym
+	if g.PreviousUsernames[0] != "guest-002" || g.PreviousEmails[0] != "previous-001@example.invalid" {
+		t.Errorf("unexpected previous identities: %v %v", g.PreviousUsernames, g.PreviousEmails)
+	}
+	if g.Boards[0] != (ResourceInfo{TeamName: "Team 01", Name: "Board 01"}) {
+		t.Errorf("unexpected board: %+v", g.Boards[0])
+	}
+	if strings.Join(g.SharedSessionIPs, ",") != "192.0.2.1,192.0.2.2" {
+		t.Errorf("unexpected IPs: %v", g.SharedSessionIPs)
+	}
+	if g.LastAuditedAction != "other" {
+		t.Errorf("audited request path kept: %q", g.LastAuditedAction)
+	}
+	if g.ExceptionJustification != "[redacted]" || g.ExceptionTicket != "[redacted]" {
+		t.Errorf("exception text not redacted: %+v", g)
+	}
+	if g.Checksum == before || g.Checksum != GuestChecksum(g) {
+		t.Error("checksum should be recomputed")
+	}
+
+	if got := result.Guests[1].Errors[0]; got != (LookupError{Stage: LookupChannels, Team: "Team 02", Message: "guest-002@example.invalid forbidden", HTTPStatus: 403}) {
+		t.Errorf("error not redacted: %+v", got)
+	}
+	if _, ok := result.Summary.ByTeam["Team 02"]; !ok || len(result.Summary.ByTeam) != 2 {
+		t.Errorf("summary teams not renamed: %v", result.Summary.ByTeam)
+	}
+	if r := result.Changes.Removed[0]; r.Username != "guest-003" || r.DisplayName != "Guest 003" || r.Email != "guest-003@example.invalid" || r.Teams[0] != "Team 02" || result.Changes.New[0] != "guest-002" {
+		t.Errorf("changes not anonymized: %+v", result.Changes)
+	}
+	if result.ExtraFields[0].Value != "[redacted]" {
+		t.Errorf("extra field not redacted: %+v", result.ExtraFields)
+	}
+
+	// Nothing identifying survives in the JSON report
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"john", "John", "jane", "Partner", "partner", "Engineering", "Sales", "Apollo", "SEC-1234", "203.0.113.7", "acme"} {
+		if strings.Contains(buf.String(), s) {
+			t.Errorf("JSON report still contains %q", s)
+		}
+	}
+}
+
+func TestAnonymizer_Redact(t *testing.T) {
+	a := NewAnonymizer()
+	a.AddServer("https://chat.acme.example/")
+	a.AddNames([]string{"eng"}, nil)
+	a.Result(anonymizeResult())
+
+	tests := []struct {
+		in, want string
+	}{
+		{`Warning: failed to process guest "john.contractor": timeout`, `Warning: failed to process guest "guest-001": timeout`},
+		{"Connecting to https://chat.acme.example ...", "Connecting to https://mattermost.example.com ..."},
+		{`error: team "eng" not found`, `error: team "<team>" not found`},
+		{"Engineering and Engineering EU", "Team 01 and Team 01 EU"},
+		{"mail someone@else.org from 10.1.2.3", "mail <email> from <ip>"},
+		{"user abcdefghijklmnopqrstuvwxyz not found", "user <id> not found"},
+		{"JS", "JS"}, // too short to redact safely
+	}
+	for _, tt := range tests {
+		if got := a.Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCaptureStderr(t *testing.T) {
+	orig := os.Stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stderr = w
+	defer func() { os.Stderr = orig }()
+
+	flush, err := captureStderr(strings.ToUpper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(os.Stderr, "held back")
+	flush()
+	if os.Stderr != w {
+		t.Error("os.Stderr was not restored")
+	}
+	w.Close()
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	if buf.String() != "HELD BACK\n" {
+		t.Errorf("flushed %q, want redacted log", buf.String())
+	}
+}
+
+func TestRunAudit_Sample(t *testing.T) {
+	client := &mockClient{guests: sampleGuests(5)}
+
+	result, exitCode := RunAudit(client, AuditOptions{Sample: 2})
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	if len(result.Guests) != 2 || result.Summary.TotalGuests != 2 {
+		t.Errorf("expected a sample of 2 guests, got %d", len(result.Guests))
+	}
+
+	offline, _ := RunOffline(result, AuditOptions{Sample: 1})
+	if len(offline.Guests) != 1 {
+		t.Errorf("expected an offline sample of 1 guest, got %d", len(offline.Guests))
+	}
+
+	// The output round-trips as JSON, so a redacted sample can be loaded with --from-file
+	var buf bytes.Buffer
+	NewAnonymizer().Result(result)
+	if err := writeJSON(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	var out jsonOutput
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil || len(out.Guests) != 2 {
+		t.Errorf("sample report does not parse: %v", err)
+	}
+}
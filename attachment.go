@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// FormatMattermost writes the audit summary as a Mattermost message
+// attachment, in the shape a slash command returns.
+const FormatMattermost = "mattermost"
+
+// Attachment colours by severity: nothing to act on, inactive guests or
+// incomplete data to look at, and violations or failed lookups.
+const (
+	SeverityGood    = "#3db887"
+	SeverityWarning = "#ffbc1f"
+	SeverityDanger  = "#d24b4e"
+)
+
+// SlashResponse is a slash command response, which an incoming webhook
+// also accepts as its request body.
+type SlashResponse struct {
+	ResponseType string              `json:"response_type"` // ephemeral: only the caller sees it
+	Attachments  []MessageAttachment `json:"attachments"`
+}
+
+// MessageAttachment is a Mattermost message attachment.
+type MessageAttachment struct {
+	Fallback  string            `json:"fallback"`
+	Color     string            `json:"color"`
+	Title     string            `json:"title"`
+	TitleLink string            `json:"title_link,omitempty"`
+	Text      string            `json:"text,omitempty"`
+	Fields    []AttachmentField `json:"fields"`
+	Footer    string            `json:"footer"`
+}
+
+// AttachmentField is one field of a MessageAttachment; short fields are
+// shown side by side.
+type AttachmentField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// Severity is the attachment colour for a summary.
+func Severity(s AuditSummary) string {
+	switch {
+	case Violations(s) > 0 || s.FailedLookups > 0:
+		return SeverityDanger
+	case s.InactiveGuests > 0 || s.IncompleteGuests > 0 || s.PurgeCandidates > 0:
+		return SeverityWarning
+	}
+	return SeverityGood
+}
+
+// AuditAttachment builds the summary of result as a slash command response:
+// one attachment with the guest counts as fields, the findings that are
+// present, the longest inactive guests, and a link to reportURL if given.
+// It holds no per-guest data beyond those usernames.
+func AuditAttachment(result *AuditResult, reportURL string) SlashResponse {
+	s := result.Summary
+	title, lines := webhookText(result, "")
+	short := func(title string, n int) AttachmentField {
+		return AttachmentField{Title: title, Value: strconv.Itoa(n), Short: true}
+	}
+	fields := []AttachmentField{
+		short("Guests", s.TotalGuests),
+		short("Active", s.ActiveGuests),
+		short("Inactive", s.InactiveGuests),
+		short("Deactivated", s.DeactivatedGuests),
+		short("Excepted", s.ExceptedGuests),
+	}
+	if n := Violations(s); n > 0 {
+		fields = append(fields, AttachmentField{
+			Title: "Violations",
+			Value: fmt.Sprintf("%d (%d with elevated roles, %d possibly shared, %d expired, %d with expired passwords, %d member(s) that should be guests)", n, s.ElevatedRoleGuests, s.SharedAccountGuests, s.ExpiredGuests, s.ExpiredPasswords, s.MembersShouldBeGuests),
+		})
+	}
+	for _, f := range []struct {
+		title string
+		n     int
+	}{
+		{"Failed lookups", s.FailedLookups},
+		{"Incomplete", s.IncompleteGuests},
+		{"Purge candidates", s.PurgeCandidates},
+		{"Never logged in", s.NeverLoggedInGuests},
+		{"Automated", s.BotGuests},
+	} {
+		if f.n > 0 {
+			fields = append(fields, short(f.title, f.n))
+		}
+	}
+
+	a := MessageAttachment{
+		Fallback:  title + ": " + lines[0],
+		Color:     Severity(s),
+		Title:     title,
+		TitleLink: reportURL,
+		Fields:    fields,
+		Footer:    "mm-guest-audit " + Version,
+	}
+	for _, line := range lines[1:] {
+		if name, ok := strings.CutPrefix(line, "Longest inactive: "); ok {
+			a.Fields = append(a.Fields, AttachmentField{Title: "Longest inactive", Value: name})
+			continue
+		}
+		a.Text = line
+	}
+	return SlashResponse{ResponseType: "ephemeral", Attachments: []MessageAttachment{a}}
+}
+
+func writeMattermost(w io.Writer, result *AuditResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(AuditAttachment(result, ""))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSeverity(t *testing.T) {
+	tests := []struct {
+		summary AuditSummary
+		want    string
+	}{
+		{AuditSummary{TotalGuests: 3, ActiveGuests: 3}, SeverityGood},
+		{AuditSummary{InactiveGuests: 1}, SeverityWarning},
+		{AuditSummary{IncompleteGuests: 1}, SeverityWarning},
+		{AuditSummary{InactiveGuests: 1, ElevatedRoleGuests: 1}, SeverityDanger},
+		{AuditSummary{FailedLookups: 1}, SeverityDanger},
+	}
+	for _, tt := range tests {
+		if got := Severity(tt.summary); got != tt.want {
+			t.Errorf("Severity(%+v) = %s, want %s", tt.summary, got, tt.want)
+		}
+	}
+}
+
+func TestAuditAttachment(t *testing.T) {
+	result := webhookResult()
+	result.InactiveDays = 90
+	result.Summary.ExpiredGuests = 2
+
+	resp := AuditAttachment(result, "")
+	if resp.ResponseType != "ephemeral" || len(resp.Attachments) != 1 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	a := resp.Attachments[0]
+	if a.Color != SeverityDanger || a.Title != "Guest audit of chat.example.com" || a.TitleLink != "" || a.Text != "Inactive means no activity in the last 90 days." {
+		t.Errorf("unexpected attachment: %+v", a)
+	}
+	if !strings.HasPrefix(a.Fallback, "Guest audit of chat.example.com: 5 guest(s)") {
+		t.Errorf("fallback = %q", a.Fallback)
+	}
+	fields := make(map[string]AttachmentField)
+	for _, f := range a.Fields {
+		fields[f.Title] = f
+	}
+	if f := fields["Inactive"]; f.Value != "2" || !f.Short {
+		t.Errorf("Inactive field = %+v", f)
+	}
+	if f := fields["Violations"]; !strings.HasPrefix(f.Value, "2 (0 with elevated roles, 0 possibly shared, 2 expired") || f.Short {
+		t.Errorf("Violations field = %+v", f)
+	}
+	if f := fields["Longest inactive"]; f.Value != "bob.contractor (never active), amy.old (last active 2024-05-01)" {
+		t.Errorf("Longest inactive field = %+v", f)
+	}
+	// Findings that are not there get no field
+	if _, ok := fields["Failed lookups"]; ok {
+		t.Errorf("unexpected Failed lookups field: %+v", a.Fields)
+	}
+
+	var buf strings.Builder
+	if err := writeMattermost(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	var got SlashResponse
+	if err := json.Unmarshal([]byte(buf.String()), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if len(got.Attachments) != 1 || got.Attachments[0].Color != SeverityDanger {
+		t.Errorf("unexpected output: %s", buf.String())
+	}
+}
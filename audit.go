@@ -1,8 +1,13 @@
 package main
 
 import (
+	"cmp"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
@@ -12,27 +17,369 @@ import (
 type TeamInfo struct {
 	ID          string `json:"id"`
 	DisplayName string `json:"display_name"`
+	Archived    bool   `json:"archived,omitempty"` // only listed with --include-archived-teams
 }
 
 // ChannelInfo represents a channel a guest can access.
 type ChannelInfo struct {
-	TeamName    string `json:"team"`
-	ChannelName string `json:"channel"`
+	ID              string `json:"-"`
+	TeamName        string `json:"team"`
+	ChannelName     string `json:"channel"`
+	Type            string `json:"type,omitempty"` // one of the ChannelType* constants
+	RetentionPolicy bool   `json:"retention_policy,omitempty"`
+	RetentionDays   int64  `json:"retention_days,omitempty"` // -1 means posts are kept indefinitely
+	Default         bool   `json:"-"`                        // the team's default channel (town-square)
+	Archived        bool   `json:"archived,omitempty"`       // only listed with --include-archived
+
+	// JoinedAt is when the guest joined the channel, found only with
+	// --audit-log and only for joins in the guest's audit records (see
+	// ChannelJoinTimes). Channel memberships carry no join time.
+	JoinedAt *time.Time `json:"joined_at,omitempty"`
+}
+
+// Channel types reported in ChannelInfo.Type.
+const (
+	ChannelTypePublic  = "public"
+	ChannelTypePrivate = "private"
+	ChannelTypeDirect  = "direct"
+	ChannelTypeGroup   = "group"
+)
+
+// channelTypeName maps a Mattermost channel type to its ChannelType* name.
+func channelTypeName(t model.ChannelType) string {
+	switch t {
+	case model.ChannelTypeOpen:
+		return ChannelTypePublic
+	case model.ChannelTypePrivate:
+		return ChannelTypePrivate
+	case model.ChannelTypeDirect:
+		return ChannelTypeDirect
+	case model.ChannelTypeGroup:
+		return ChannelTypeGroup
+	}
+	return string(t)
+}
+
+// countChannels returns how many of channels are team channels, leaving out
+// direct and group messages. Channels with no type, from older snapshots,
+// are counted.
+func countChannels(channels []ChannelInfo) int {
+	n := 0
+	for _, ch := range channels {
+		if ch.Type != ChannelTypeDirect && ch.Type != ChannelTypeGroup {
+			n++
+		}
+	}
+	return n
+}
+
+// liveChannels returns channels without the archived ones.
+func liveChannels(channels []ChannelInfo) []ChannelInfo {
+	var out []ChannelInfo
+	for _, ch := range channels {
+		if !ch.Archived {
+			out = append(out, ch)
+		}
+	}
+	return out
+}
+
+// liveTeams returns the teams that are not archived.
+func liveTeams(teams []TeamInfo) []TeamInfo {
+	var live []TeamInfo
+	for _, t := range teams {
+		if !t.Archived {
+			live = append(live, t)
+		}
+	}
+	return live
+}
+
+// sortChannels orders channels by team and then channel name, ignoring
+// case, so a guest's channel list reads the same from run to run. Each
+// team's channels come before the DMs and group messages listed with it.
+func sortChannels(channels []ChannelInfo) {
+	rank := func(ch ChannelInfo) int {
+		if ch.Type == ChannelTypeDirect || ch.Type == ChannelTypeGroup {
+			return 1
+		}
+		return 0
+	}
+	slices.SortStableFunc(channels, func(a, b ChannelInfo) int {
+		return cmp.Or(
+			cmp.Compare(strings.ToLower(a.TeamName), strings.ToLower(b.TeamName)),
+			cmp.Compare(rank(a), rank(b)),
+			cmp.Compare(strings.ToLower(a.ChannelName), strings.ToLower(b.ChannelName)),
+		)
+	})
+}
+
+// ChannelCountInRange reports whether count is at least min and, if max is
+// set, at most max.
+func ChannelCountInRange(count, min int, max *int) bool {
+	return count >= min && (max == nil || count <= *max)
+}
+
+// countPrivateChannels returns how many of channels are private.
+func countPrivateChannels(channels []ChannelInfo) int {
+	n := 0
+	for _, ch := range channels {
+		if ch.Type == ChannelTypePrivate {
+			n++
+		}
+	}
+	return n
 }
 
 // GuestRecord holds all audit information for a single guest user.
 type GuestRecord struct {
-	Username    string        `json:"username"`
-	DisplayName string        `json:"display_name"`
-	Email       string        `json:"email"`
-	CreatedAt   *time.Time    `json:"created_at"`
-	LastLogin   *time.Time    `json:"last_login"`
-	LastPost    *time.Time    `json:"last_post"`
+	// Server is the name of the server the guest was audited on, set only
+	// with --servers.
+	Server      string     `json:"server,omitempty"`
+	Username    string     `json:"username"`
+	DisplayName string     `json:"display_name"`
+	Nickname    string     `json:"nickname"`
+	Email       string     `json:"email"`
+	Position    string     `json:"position"`
+	AuthMethod  string     `json:"auth_method"` // see AuthMethodName
+	Locale      string     `json:"locale"`      // language setting, e.g. "en"; picks notification templates
+	Timezone    string     `json:"timezone"`    // IANA name, from User.GetPreferredTimezone
+	CreatedAt   *time.Time `json:"created_at"`
+	LastLogin   *time.Time `json:"last_login"`
+	LastPost    *time.Time `json:"last_post"`
+	// LastViewed is the newest time the guest viewed one of their reported
+	// channels, set only with --last-viewed.
+	LastViewed *time.Time `json:"last_viewed"`
+	// ViewUnknown is set when --last-viewed could not read every team, so a
+	// nil LastViewed does not mean never viewed.
+	ViewUnknown bool          `json:"last_viewed_unknown,omitempty"`
 	Teams       []TeamInfo    `json:"teams"`
 	Channels    []ChannelInfo `json:"channels"`
 	Active      bool          `json:"active"`
 	Inactive    bool          `json:"inactive"`
-	Error       string        `json:"error,omitempty"`
+	Excepted    bool          `json:"excepted"`
+	// Failed is set when a lookup failed the whole guest; Errors names it.
+	// The record then holds only the account fields.
+	Failed bool `json:"failed,omitempty"`
+
+	// LastAuditedAction and LastAuditedAt describe the newest audit record
+	// of the guest acting, such as a login or channel join, set only with
+	// --audit-log (see LastAuditedAction).
+	LastAuditedAction string     `json:"last_audited_action,omitempty"`
+	LastAuditedAt     *time.Time `json:"last_audited_at"`
+
+	// NotifiedAt is when --notify-guests warned the guest of pending
+	// deactivation, kept in the state file until they are active again.
+	NotifiedAt *time.Time `json:"notified_at,omitempty"`
+
+	// New marks a guest who was not in the state file the run compared
+	// with, so became a guest since the previous run (see GuestChanges).
+	New bool `json:"-"`
+
+	// Orphaned is set when the guest belongs to no team, or only to
+	// archived ones. The account still exists, and still holds a license
+	// while active.
+	Orphaned bool `json:"orphaned"`
+
+	// ShouldBeGuest marks a full member, not a guest, whose email is on one
+	// of the --include-members-with-domain domains.
+	ShouldBeGuest bool `json:"should_be_guest"`
+
+	// EmailVerified is false until the guest confirms their address, which
+	// an invitation never taken up leaves unconfirmed.
+	EmailVerified bool `json:"email_verified"`
+
+	// DeactivatedAt is when the account was deactivated; nil while active.
+	DeactivatedAt *time.Time `json:"deactivated_at"`
+
+	// PurgeCandidate marks a guest deactivated more than
+	// --deactivated-older-than days ago, whose account could be permanently
+	// deleted (see --purge).
+	PurgeCandidate bool `json:"purge_candidate"`
+
+	// AgeDays is the number of whole days since the account was created.
+	AgeDays *int `json:"age_days"`
+	// DaysSinceLastActivity is the number of whole days since the later of
+	// LastLogin and LastPost; nil for a guest with neither.
+	DaysSinceLastActivity *int `json:"days_since_last_activity"`
+	// Expired marks an active guest whose account is older than
+	// --max-guest-age days, whatever their activity (see
+	// --deactivate-expired).
+	Expired bool `json:"expired"`
+
+	// PasswordUpdatedAt is when the guest last set their password, and
+	// PasswordAgeDays the whole days since; both nil unless the guest signs
+	// in with email, as Mattermost holds no password for SSO, LDAP or SAML.
+	PasswordUpdatedAt *time.Time `json:"password_updated_at"`
+	PasswordAgeDays   *int       `json:"password_age_days"`
+	// PasswordExpired marks an active guest whose password is older than
+	// --max-password-age days.
+	PasswordExpired bool `json:"password_expired"`
+
+	// Bot is BotAccount or BotUsername when the guest looks automated
+	// rather than a person, and empty otherwise.
+	Bot string `json:"bot,omitempty"`
+
+	// Exception details, set when the guest matches a valid allowlist entry.
+	ExceptionJustification string     `json:"exception_justification,omitempty"`
+	ExceptionExpires       *time.Time `json:"exception_expires,omitempty"`
+	ExceptionTicket        string     `json:"exception_ticket,omitempty"`
+
+	// RetentionChannels counts the guest's channels that fall under a custom
+	// data retention policy.
+	RetentionChannels int `json:"retention_channels"`
+
+	// PrivateChannels counts the guest's private channels.
+	PrivateChannels int `json:"private_channels"`
+
+	// ChannelCount counts the guest's public and private channels; direct
+	// and group messages are not counted.
+	ChannelCount int `json:"channel_count"`
+
+	// Identities seen in the guest's audit records that differ from the
+	// current ones (only with --identity-history).
+	PreviousUsernames []string `json:"previous_usernames,omitempty"`
+	PreviousEmails    []string `json:"previous_emails,omitempty"`
+
+	// File activity, set only with --file-activity.
+	LastFileUpload *time.Time `json:"last_file_upload"`
+	FileCount      *int       `json:"file_count"`
+
+	// PostCount is the number of posts in the guest's team channels since
+	// --since, set only with --post-count.
+	PostCount *int `json:"post_count"`
+
+	// LastMention is the most recent @-mention of the guest by someone else
+	// within the searched window, set only when mentions were searched
+	// (--mention-count, or --mention-days for guests who would be flagged).
+	LastMention *time.Time `json:"last_mention"`
+	// MentionCount counts mentions by internal users, set only with --mention-count.
+	MentionCount *int `json:"mention_count"`
+
+	// GuestOnlyChannels lists the guest's channels with no member other than
+	// guests (only with --guest-only-channels).
+	GuestOnlyChannels []ResourceInfo `json:"guest_only_channels,omitempty"`
+
+	// Boards and playbooks the guest is a member of (only with --plugin-access).
+	Boards    []ResourceInfo `json:"boards,omitempty"`
+	Playbooks []ResourceInfo `json:"playbooks,omitempty"`
+
+	// SharedAccount is set only with --shared-sessions: true when the guest
+	// had concurrent sessions from different networks (see SharedSessionIPs),
+	// which are listed in SharedSessionIPs.
+	SharedAccount    *bool    `json:"possible_shared_account"`
+	SharedSessionIPs []string `json:"shared_session_ips,omitempty"`
+
+	// ElevatedRoles lists the team and channel roles the guest holds beyond
+	// the guest role (only with --check-roles).
+	ElevatedRoles []RoleGrant `json:"elevated_roles,omitempty"`
+
+	// Groups lists the Mattermost groups the guest belongs to, and
+	// DirectChannels the guest's team channels that none of their LDAP
+	// groups is synced to, so someone added the guest by hand (only with
+	// --groups).
+	Groups         []string       `json:"groups,omitempty"`
+	DirectChannels []ResourceInfo `json:"direct_channels,omitempty"`
+
+	// PermissionMissing names the fields that could not be collected for
+	// this guest because the token lacks a permission. Those fields are left
+	// empty or null rather than failing the guest.
+	PermissionMissing []string `json:"permission_missing,omitempty"`
+
+	// ProfileFields holds the custom profile attributes named with
+	// --profile-fields, by the server's field name. Fields the guest has not
+	// filled in are empty; nil when they were not looked up.
+	ProfileFields map[string]string `json:"profile_fields,omitempty"`
+
+	// Errors lists the lookups that failed for this guest after their
+	// retries. Unless Failed is set, the record is reported without the data
+	// those lookups would have filled; with Failed set it holds the lookup
+	// that failed the whole guest.
+	Errors []LookupError `json:"errors,omitempty"`
+
+	// Checksum is GuestChecksum of the final record, for change detection.
+	Checksum string `json:"checksum"`
+
+	// The account state the record was built from, for reusing it in the
+	// next run (see AuditOptions.Previous). Not part of the report.
+	UserID     string `json:"-"`
+	UpdateAt   int64  `json:"-"`
+	ActivityAt int64  `json:"-"`
+}
+
+// Per-guest lookups named in LookupError.Stage. Optional enrichments use
+// their Enrich* names.
+const (
+	LookupTeams    = "teams"
+	LookupChannels = "channels"
+	LookupLastPost = "last_post"
+)
+
+// LookupError is a per-guest lookup that still failed after its retries.
+type LookupError struct {
+	Stage   string `json:"stage"`          // the Lookup* or Enrich* name
+	Team    string `json:"team,omitempty"` // for lookups made per team
+	Message string `json:"message"`
+	// HTTPStatus is the status the server answered with; 0 (omitted) when
+	// there was no answer, e.g. a connection failure or timeout.
+	HTTPStatus int `json:"http_status,omitempty"`
+}
+
+// newLookupError records err as a failure of the given stage.
+func newLookupError(stage, team string, err error) LookupError {
+	e := LookupError{Stage: stage, Team: team, Message: err.Error()}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		e.HTTPStatus = apiErr.StatusCode
+	}
+	return e
+}
+
+// UnmarshalJSON also accepts the "lookup" key that reports before schema
+// version 3 used for Stage, so --from-file can read them.
+func (e *LookupError) UnmarshalJSON(data []byte) error {
+	type plain LookupError
+	var v struct {
+		plain
+		Lookup string `json:"lookup"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*e = LookupError(v.plain)
+	if e.Stage == "" {
+		e.Stage = v.Lookup
+	}
+	return nil
+}
+
+// String renders the error as stage: message, or stage (team): message.
+func (e LookupError) String() string {
+	if e.Team == "" {
+		return e.Stage + ": " + e.Message
+	}
+	return e.Stage + " (" + e.Team + "): " + e.Message
+}
+
+// lookupFailure is a lookup error that fails the whole guest, for RunAudit
+// to record in the placeholder record.
+type lookupFailure struct {
+	lookup LookupError
+	err    error
+}
+
+func (f *lookupFailure) Error() string { return f.err.Error() }
+func (f *lookupFailure) Unwrap() error { return f.err }
+
+// failLookup wraps err, from the given lookup, as the error failing a guest.
+func failLookup(lookup, team string, err error, msg string) error {
+	return &lookupFailure{newLookupError(lookup, team, err), fmt.Errorf("%s: %w", msg, err)}
+}
+
+// ResourceInfo names a plugin resource (a board or playbook) and its team.
+type ResourceInfo struct {
+	TeamName string `json:"team"`
+	Name     string `json:"name"`
 }
 
 // AuditSummary holds aggregate counts for the audit.
@@ -41,229 +388,2326 @@ type AuditSummary struct {
 	ActiveGuests      int `json:"active_guests"`
 	InactiveGuests    int `json:"inactive_guests"`
 	DeactivatedGuests int `json:"deactivated_guests"`
+	ExceptedGuests    int `json:"excepted_guests"`
 	FailedLookups     int `json:"failed_lookups"`
+	RetentionGuests   int `json:"retention_policy_guests"`
+	// IncompleteGuests counts guests reported with some lookups failed
+	// (see GuestRecord.Errors). FailedLookups counts those not reported.
+	IncompleteGuests int `json:"incomplete_guests"`
+	// FailuresByStage counts the failed lookups of every guest, failed or
+	// incomplete, by LookupError.Stage.
+	FailuresByStage map[string]int `json:"failures_by_stage"`
+	// SharedAccountGuests counts guests flagged as possibly shared accounts.
+	SharedAccountGuests int `json:"possible_shared_accounts"`
+	// OrphanedGuests counts guests with no team membership.
+	OrphanedGuests int `json:"orphaned_guests"`
+	// NeverLoggedInGuests counts guests with no recorded login: invited
+	// but never activated.
+	NeverLoggedInGuests int `json:"never_logged_in_guests"`
+	// UnverifiedGuests counts guests who have not verified their email.
+	UnverifiedGuests int `json:"unverified_guests"`
+	// GuestOnlyChannels counts the channels with only guests as members
+	// (only with --guest-only-channels). Each channel is counted once.
+	GuestOnlyChannels int `json:"guest_only_channels"`
+	// PurgeCandidates counts guests deactivated for longer than
+	// --deactivated-older-than.
+	PurgeCandidates int `json:"purge_candidates"`
+	// ExpiredGuests counts active guests older than --max-guest-age.
+	ExpiredGuests int `json:"expired_guests"`
+	// ExpiredPasswords counts active guests whose password is older than
+	// --max-password-age.
+	ExpiredPasswords int `json:"expired_passwords"`
+	// BotGuests counts guests that look automated (GuestRecord.Bot).
+	BotGuests int `json:"bot_guests"`
+	// ElevatedRoleGuests counts guests holding a team or channel role
+	// beyond the guest role (only with --check-roles).
+	ElevatedRoleGuests int `json:"elevated_role_guests"`
+	// DirectMemberGuests counts guests in channels none of their groups
+	// grants (only with --groups).
+	DirectMemberGuests int `json:"direct_member_guests"`
+	// MembersShouldBeGuests counts the members listed with
+	// --include-members-with-domain. They are not counted as guests.
+	MembersShouldBeGuests int `json:"members_should_be_guests"`
+
+	// ByTeam breaks the counts down by team display name. A guest in several
+	// teams is counted in each.
+	ByTeam map[string]*TeamSummary `json:"by_team"`
+
+	// Owners breaks the counts down by owner (only with --group-by owner).
+	Owners *OwnerRollup `json:"owners,omitempty"`
+
+	// AgeBuckets counts active guests by days since their last activity.
+	AgeBuckets []AgeBucket `json:"age_buckets"`
+
+	// License estimates the license seats guests take up.
+	License LicenseSeats `json:"license"`
+}
+
+// LicenseSeats compares the seats held by guest accounts with the server's
+// license. Every guest account that is not deactivated holds a seat.
+type LicenseSeats struct {
+	LicensedSeats *int `json:"licensed_seats"` // null when the server reported no seat count
+	GuestSeats    int  `json:"guest_seats"`
+	// FreeableSeats is the number of seats deactivating the inactive
+	// (and not excepted) guests would free.
+	FreeableSeats int `json:"freeable_seats"`
+}
+
+// DefaultAgeBuckets are the bucket boundaries (in days since last activity)
+// used unless the config file sets age_buckets.
+var DefaultAgeBuckets = []int{30, 90, 180}
+
+// AgeBucket counts guests whose last activity was between MinDays
+// (inclusive) and MaxDays (exclusive) ago.
+type AgeBucket struct {
+	Label   string `json:"label"`
+	MinDays int    `json:"min_days"`
+	MaxDays *int   `json:"max_days"` // nil for the open-ended last bucket
+	Guests  int    `json:"guests"`
+}
+
+// NewAgeBuckets builds empty buckets from ascending boundaries, e.g.
+// [30 90 180] → <30d, 30-90d, 90-180d, >180d.
+func NewAgeBuckets(bounds []int) []AgeBucket {
+	buckets := make([]AgeBucket, 0, len(bounds)+1)
+	lower := 0
+	for _, b := range bounds {
+		upper := b
+		label := fmt.Sprintf("%d-%dd", lower, upper)
+		if lower == 0 {
+			label = fmt.Sprintf("<%dd", upper)
+		}
+		buckets = append(buckets, AgeBucket{Label: label, MinDays: lower, MaxDays: &upper})
+		lower = b
+	}
+	return append(buckets, AgeBucket{Label: fmt.Sprintf(">%dd", lower), MinDays: lower})
+}
+
+// addToAgeBucket counts a guest by days since lastActivity. Guests who were
+// never active fall in the last bucket.
+func addToAgeBucket(buckets []AgeBucket, lastActivity *time.Time, now time.Time) {
+	if len(buckets) == 0 {
+		return
+	}
+	if lastActivity == nil {
+		buckets[len(buckets)-1].Guests++
+		return
+	}
+	days := int(now.Sub(*lastActivity).Hours() / 24)
+	for i := range buckets {
+		if buckets[i].MaxDays == nil || days < *buckets[i].MaxDays {
+			buckets[i].Guests++
+			return
+		}
+	}
+}
+
+// LastActivity returns the more recent of the last login and last post.
+func LastActivity(lastLogin, lastPost *time.Time) *time.Time {
+	if lastLogin == nil || (lastPost != nil && lastPost.After(*lastLogin)) {
+		return lastPost
+	}
+	return lastLogin
+}
+
+// TeamSummary holds guest counts for a single team.
+type TeamSummary struct {
+	TotalGuests       int `json:"total_guests"`
+	ActiveGuests      int `json:"active_guests"`
+	InactiveGuests    int `json:"inactive_guests"`
+	DeactivatedGuests int `json:"deactivated_guests"`
+	ExceptedGuests    int `json:"excepted_guests"`
+}
+
+// add counts a guest under the status precedence used for the overall summary.
+func (t *TeamSummary) add(g GuestRecord) {
+	t.TotalGuests++
+	if !g.Active {
+		t.DeactivatedGuests++
+	} else if g.Excepted {
+		t.ExceptedGuests++
+	} else if g.Inactive {
+		t.InactiveGuests++
+	} else {
+		t.ActiveGuests++
+	}
+}
+
+// AuditResult holds the complete audit output.
+type AuditResult struct {
+	Guests       []GuestRecord `json:"guests"`
+	Summary      AuditSummary  `json:"summary"`
+	InactiveDays int           `json:"inactive_days"`
+	GuestRoles   []string      `json:"guest_roles"`
+	// MaxGuestAge is --max-guest-age, or 0 when accounts were not checked
+	// for age.
+	MaxGuestAge int `json:"max_guest_age"`
+	// MaxPasswordAge is --max-password-age, or 0 when passwords were not
+	// checked for age.
+	MaxPasswordAge int `json:"max_password_age"`
+
+	InactivityMetric InactivityMetric `json:"inactivity_metric"`
+	// Deployment is DeploymentCloud or DeploymentSelfHosted.
+	Deployment string `json:"deployment"`
+	// UnavailableEnrichment lists enrichments the server did not support,
+	// so consumers know which fields could not be collected.
+	UnavailableEnrichment []string `json:"unavailable_enrichment,omitempty"`
+	// PermissionMissing lists the unavailable enrichments the token lacked
+	// permission for.
+	PermissionMissing []string `json:"permission_missing,omitempty"`
+
+	// TimeFormat controls how table and CSV output render dates.
+	TimeFormat TimeFormat `json:"-"`
+	// ExtraFields are appended to every CSV and JSON record.
+	ExtraFields []ExtraField `json:"-"`
+	// ProfileFields names the --profile-fields columns, in order.
+	ProfileFields []string `json:"-"`
+	// OwnerField is the profile field guests are grouped by with
+	// --group-by owner; Summary.Owners is counted from it.
+	OwnerField string `json:"-"`
+	// Metadata records where, when and how the report was produced.
+	Metadata *RunMetadata `json:"-"`
+	// Servers lists the servers of a --servers report in the order of the
+	// servers file, each with its own metadata. Metadata then covers them
+	// all.
+	Servers []AuditedServer `json:"-"`
+	// Stats records the load the audit put on the server; set with
+	// AuditOptions.Stats.
+	Stats *RunStats `json:"-"`
+	// Changes compares the guests with the previous run's state file; nil
+	// when no state file was compared.
+	Changes *GuestChanges `json:"-"`
+	// LicensedSeats is the user seat count of the server's license, or 0
+	// if unknown. Summary.License is derived from it.
+	LicensedSeats int `json:"-"`
+}
+
+// Deployment types reported in AuditResult.Deployment.
+const (
+	DeploymentCloud      = "cloud"
+	DeploymentSelfHosted = "self-hosted"
+)
+
+// Optional enrichments that may be unavailable on some servers.
+const (
+	EnrichRetention       = "retention_policies"
+	EnrichFileActivity    = "file_activity"
+	EnrichIdentityHistory = "identity_history"
+	EnrichBoards          = "boards"
+	EnrichPlaybooks       = "playbooks"
+	EnrichMentions        = "mentions"
+	EnrichPostCount       = "post_count"
+	EnrichSessions        = "sessions"
+	EnrichRoles           = "roles"
+	EnrichGuestOnly       = "guest_only_channels"
+	EnrichLastViewed      = "last_viewed"
+	EnrichProfileFields   = "profile_fields"
+	EnrichAuditLog        = "audit_log"
+	EnrichGroups          = "groups"
+)
+
+// enrichmentState tracks which optional enrichments can run against this
+// server. An enrichment rejected as unsupported (see IsUnsupported) is
+// switched off for the remaining guests rather than failing once per guest.
+type enrichmentState struct {
+	checkRetention bool
+	unavailable    []string
+	// denied lists the unavailable enrichments that failed for lack of a
+	// permission rather than server support.
+	denied []string
+
+	timings *StepTimings
+
+	// teamAccess caches plugin membership per enrichment and team:
+	// "boards:teamID" → userID → resource names.
+	teamAccess map[string]map[string][]string
+
+	// profileFields are the server's fields named with --profile-fields.
+	profileFields []ProfileField
+
+	// guestIDs holds every listed guest, to tell internal users' posts apart.
+	guestIDs map[string]bool
+
+	// shouldBeGuest holds the members listed with MemberDomains.
+	shouldBeGuest map[string]bool
+
+	// channelPosts caches post counts per channel: channelID → userID →
+	// posts. A nil entry marks a channel that could not be read.
+	channelPosts map[string]map[string]int
+
+	// guestOnly caches whether each channel has only guests as members.
+	guestOnly map[string]bool
+
+	// groupChannels caches the channels each LDAP group is synced to:
+	// groupID → channel IDs.
+	groupChannels map[string][]string
+
+	// teamChannels caches channel memberships per team for BulkChannels:
+	// teamID → userID → channels. A nil entry marks a team that could not be
+	// loaded, whose guests fall back to per-guest lookups.
+	teamChannels map[string]map[string][]*model.Channel
+}
+
+// channelsForTeam returns the user's channels in a team from the team's bulk
+// membership, loading it on first use. It reports false if the team could
+// not be loaded, so the caller can look the user up directly.
+func (s *enrichmentState) channelsForTeam(client MattermostClient, teamID, userID string, verbose bool) ([]*model.Channel, bool) {
+	members, ok := s.teamChannels[teamID]
+	if ok {
+		s.timings.CacheHit(StepChannels)
+	} else {
+		if s.teamChannels == nil {
+			s.teamChannels = make(map[string]map[string][]*model.Channel)
+		}
+		var err error
+		members, err = client.GetTeamChannelMembers(teamID)
+		if err != nil {
+			if verbose {
+				logger.Printf("Warning: could not load channel members for team %s, looking up each guest instead: %v", teamID, err)
+			}
+			members = nil
+		}
+		s.teamChannels[teamID] = members
+	}
+	if members == nil {
+		return nil, false
+	}
+	return members[userID], true
+}
+
+// postCount sums the user's posts across their team channels (DMs and group
+// messages are skipped). Each channel is read once per run, since guests
+// often share channels. It returns nil if any channel could not be read,
+// rather than an undercount.
+func (s *enrichmentState) postCount(client MattermostClient, userID string, channels []ChannelInfo, since *time.Time, verbose bool) *int {
+	if s.channelPosts == nil {
+		s.channelPosts = make(map[string]map[string]int)
+	}
+	total := 0
+	for _, ch := range channels {
+		if ch.Type == ChannelTypeDirect || ch.Type == ChannelTypeGroup {
+			continue
+		}
+		counts, ok := s.channelPosts[ch.ID]
+		if ok {
+			s.timings.CacheHit(StepPostCount)
+		} else {
+			var err error
+			counts, err = client.GetPostCountsForChannel(ch.ID, since)
+			if err != nil {
+				if !s.disableIfUnsupported(EnrichPostCount, err, verbose) && verbose {
+					logger.Printf("Warning: could not read posts in %s/%s: %v", ch.TeamName, ch.ChannelName, err)
+				}
+				counts = nil
+			}
+			s.channelPosts[ch.ID] = counts
+		}
+		if counts == nil {
+			return nil
+		}
+		total += counts[userID]
+	}
+	return &total
+}
+
+// guestOnlyChannels returns the guest's team channels whose members are all
+// guests, including members listed with --include-members-with-domain as
+// internal. Each channel's members are read once per run. A channel that
+// cannot be read is not listed.
+func (s *enrichmentState) guestOnlyChannels(client MattermostClient, channels []ChannelInfo, verbose bool) []ResourceInfo {
+	if s.guestOnly == nil {
+		s.guestOnly = make(map[string]bool)
+	}
+	var out []ResourceInfo
+	for _, ch := range channels {
+		if ch.Type == ChannelTypeDirect || ch.Type == ChannelTypeGroup || !s.enabled(EnrichGuestOnly) {
+			continue
+		}
+		guestOnly, ok := s.guestOnly[ch.ID]
+		if ok {
+			s.timings.CacheHit(StepGuestOnly)
+		} else {
+			memberIDs, err := client.GetChannelMemberIDs(ch.ID)
+			if err != nil {
+				if !s.disableIfUnsupported(EnrichGuestOnly, err, verbose) && verbose {
+					logger.Printf("Warning: could not read members of %s/%s: %v", ch.TeamName, ch.ChannelName, err)
+				}
+			} else {
+				guestOnly = !slices.ContainsFunc(memberIDs, func(id string) bool { return !s.guestIDs[id] })
+			}
+			s.guestOnly[ch.ID] = guestOnly
+		}
+		if guestOnly {
+			out = append(out, ResourceInfo{TeamName: ch.TeamName, Name: ch.ChannelName})
+		}
+	}
+	return out
+}
+
+// groupMemberships returns the display names of the user's groups, and the
+// user's team channels that none of their LDAP groups is synced to. Each
+// group's channels are read once per run. Custom groups are listed but
+// never synced to channels. Direct and group messages, default channels,
+// which come with the team, and archived channels are not listed.
+func (s *enrichmentState) groupMemberships(client MattermostClient, userID string, channels []ChannelInfo) ([]string, []ResourceInfo, error) {
+	groups, err := client.GetGroupsForUser(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var names []string
+	synced := make(map[string]bool)
+	for _, g := range groups {
+		names = append(names, g.DisplayName)
+		if g.Source != model.GroupSourceLdap {
+			continue
+		}
+		ids, ok := s.groupChannels[g.Id]
+		if ok {
+			s.timings.CacheHit(StepGroups)
+		} else {
+			ids, err = client.GetGroupChannelIDs(g.Id)
+			if err != nil {
+				return nil, nil, err
+			}
+			if s.groupChannels == nil {
+				s.groupChannels = make(map[string][]string)
+			}
+			s.groupChannels[g.Id] = ids
+		}
+		for _, id := range ids {
+			synced[id] = true
+		}
+	}
+	slices.Sort(names)
+
+	var direct []ResourceInfo
+	for _, ch := range channels {
+		if ch.Type == ChannelTypeDirect || ch.Type == ChannelTypeGroup || ch.Default || ch.Archived || synced[ch.ID] {
+			continue
+		}
+		direct = append(direct, ResourceInfo{TeamName: ch.TeamName, Name: ch.ChannelName})
+	}
+	return names, direct, nil
+}
+
+// membersForTeam returns the plugin membership map for a team, loading it on
+// first use. Boards and playbooks are listed per team rather than per user,
+// so each team is fetched once per run. A failed load is cached as empty.
+func (s *enrichmentState) membersForTeam(name, teamID string, load func(teamID string) (map[string][]string, error), verbose bool) map[string][]string {
+	key := name + ":" + teamID
+	if members, ok := s.teamAccess[key]; ok {
+		s.timings.CacheHit(StepPlugins)
+		return members
+	}
+	if s.teamAccess == nil {
+		s.teamAccess = make(map[string]map[string][]string)
+	}
+	members, err := load(teamID)
+	if err != nil {
+		if !s.disableIfUnsupported(name, err, verbose) && verbose {
+			logger.Printf("Warning: could not retrieve %s for team %s: %v", name, teamID, err)
+		}
+		members = nil
+	}
+	s.teamAccess[key] = members
+	return members
+}
+
+func (s *enrichmentState) enabled(name string) bool {
+	return !slices.Contains(s.unavailable, name)
+}
+
+// disableIfUnsupported switches off the enrichment when err shows the server
+// does not support it, and reports whether it did so.
+func (s *enrichmentState) disableIfUnsupported(name string, err error, verbose bool) bool {
+	if !IsUnsupported(err) {
+		return false
+	}
+	if s.enabled(name) {
+		s.unavailable = append(s.unavailable, name)
+		if IsPermissionDenied(err) {
+			s.denied = append(s.denied, name)
+		}
+		if verbose {
+			logger.Printf("Warning: %s not available with this server or token, skipping for remaining guests: %v", name, err)
+		}
+	}
+	return true
+}
+
+// enrichmentFields lists the report fields each enrichment fills, for
+// GuestRecord.PermissionMissing.
+var enrichmentFields = map[string][]string{
+	EnrichRetention:       {"retention_channels"},
+	EnrichFileActivity:    {"last_file_upload", "file_count"},
+	EnrichIdentityHistory: {"previous_usernames", "previous_emails"},
+	EnrichBoards:          {"boards"},
+	EnrichPlaybooks:       {"playbooks"},
+	EnrichMentions:        {"last_mention", "mention_count"},
+	EnrichPostCount:       {"post_count"},
+	EnrichSessions:        {"possible_shared_account", "shared_session_ips"},
+	EnrichRoles:           {"elevated_roles"},
+	EnrichGuestOnly:       {"guest_only_channels"},
+	EnrichLastViewed:      {"last_viewed"},
+	EnrichProfileFields:   {"profile_fields"},
+	EnrichAuditLog:        {"last_audited_action", "last_audited_at", "channel_joined_at"},
+	EnrichGroups:          {"groups", "direct_channels"},
+}
+
+// addMissing appends fields to missing, skipping any already listed.
+func addMissing(missing []string, fields ...string) []string {
+	for _, f := range fields {
+		if !slices.Contains(missing, f) {
+			missing = append(missing, f)
+		}
+	}
+	return missing
+}
+
+// AuditOptions controls the scope and flagging behaviour of an audit.
+type AuditOptions struct {
+	TeamFilter    string
+	ChannelFilter string
+	// ChannelTeam is the team ChannelFilter is looked up in without a
+	// TeamFilter. Without either, channels are looked up in every team.
+	ChannelTeam string
+	PrivateOnly bool // skip guests who are not in any private channel
+	// IncludeArchived lists archived channels in each guest's channels,
+	// marked Archived. They are left out by default.
+	IncludeArchived bool
+	// IncludeArchivedTeams lists archived teams in each guest's teams,
+	// marked Archived. They are left out by default.
+	IncludeArchivedTeams bool
+	OrphansOnly          bool // skip guests who belong to any team
+	NeverLoggedIn        bool // skip guests who have ever logged in
+	UnverifiedOnly       bool // skip guests who have verified their email
+	InactiveDays         int
+	// MinChannels and MaxChannels bound ChannelCount; a nil MaxChannels
+	// means no upper bound, as 0 finds guests with no channels.
+	MinChannels int
+	MaxChannels *int
+	// MinAgeDays skips guests whose accounts are younger than this many
+	// days, and MinIdleDays guests active within this many days; 0 disables
+	// either.
+	MinAgeDays  int
+	MinIdleDays int
+	// DeactivatedDays marks guests deactivated more than this many days ago
+	// as purge candidates; 0 disables the check.
+	DeactivatedDays int
+	// MaxGuestAge marks active guests created more than this many days ago
+	// as expired; 0 disables the check.
+	MaxGuestAge int
+	// MaxPasswordAge marks active email guests whose password was set more
+	// than this many days ago; 0 disables the check.
+	MaxPasswordAge int
+	// InactivityMetric selects the activity signal(s) used for flagging; defaults to MetricLogin.
+	InactivityMetric InactivityMetric
+	Allowlist        *Allowlist
+	GuestRoles       []string // defaults to DefaultGuestRoles
+	// AuthMethods, when set, limits the audit to guests signing in with one
+	// of these methods (AuthMethodName values).
+	AuthMethods []string
+	// Match, when set, limits the audit to guests whose username, email or
+	// display name matches it.
+	Match *regexp.Regexp
+	// MemberDomains, when set, also audits full members whose email is on
+	// one of these domains, marking them ShouldBeGuest.
+	MemberDomains []string
+	// MentionDays exempts guests from inactivity if someone @-mentioned
+	// them within this many days; 0 disables the check.
+	MentionDays int
+	// MentionCountDays reports how many times internal (non-guest) users
+	// mentioned each guest in this many days; 0 disables the count.
+	MentionCountDays int
+	// Since bounds PostCount to posts created at or after this time; nil counts all posts.
+	Since *time.Time
+	// CreatedAfter and CreatedBefore bound the guests' account creation date: [after, before).
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// Optional enrichments, each costing extra API calls per guest.
+	IdentityHistory bool
+	AuditLog        bool // report the guest's last audited action
+	FileActivity    bool
+	LastViewed      bool // look up when the guest last viewed each channel; implied by MetricView
+	PluginAccess    bool
+	PostCount       bool
+	SharedSessions  bool
+	CheckRoles      bool
+	GuestOnly       bool     // list the guest's channels with only guest members
+	Groups          bool     // list the guest's groups and the channels no group grants
+	ProfileFields   []string // custom profile attributes to report, matched to the server's by name
+	OwnerField      string   // the profile field naming each guest's owner, for --group-by owner
+	Sort            SortSpec
+	AgeBuckets      []int // defaults to DefaultAgeBuckets
+	ExtraFields     []ExtraField
+	BulkChannels    bool // load channel memberships per team, not per guest; omits DMs and group messages
+	Sample          int  // stop after this many guests are in the report; 0 means all
+	Retry           RetryPolicy
+	Progress        *Progress
+	Verbose         bool
+	Stats           bool // fill AuditResult.Stats and print it to stderr
+	// Stream, when set, receives each guest as it completes instead of
+	// AuditResult.Guests, which is left empty; only the summary is kept.
+	Stream *GuestStream
+
+	// Previous is the last result of a repeated audit (--watch, serve) run
+	// with the same options. Guests whose UpdateAt and LastActivityAt are
+	// unchanged since then keep their previous record instead of being
+	// enriched again. Nil, or FullEnrichment, enriches every guest.
+	Previous       *AuditResult
+	FullEnrichment bool
+}
+
+// RunAudit performs the guest audit against the Mattermost instance.
+func RunAudit(client MattermostClient, opts AuditOptions) (*AuditResult, int) {
+	teamFilter := opts.TeamFilter
+	channelFilter := opts.ChannelFilter
+	verbose := opts.Verbose
+	started := time.Now()
+	callsBefore := client.ServerInfo().APICalls
+	bytesBefore := client.ServerInfo().APIBytes
+
+	var filterTeam *model.Team
+	var filterTeamID string
+	var filterTeamName string
+	var inChannels channelScope
+
+	// Resolve team filter if set
+	if teamFilter != "" {
+		team, err := ResolveTeam(client, teamFilter)
+		if err != nil {
+			logger.Printf("%v", err)
+			return nil, ExitConfigError
+		}
+		filterTeam = team
+		filterTeamID = team.Id
+		filterTeamName = team.DisplayName
+		if verbose {
+			logger.Printf("Scoping to team: %s (ID: %s)", filterTeamName, filterTeamID)
+		}
+	}
+
+	// Resolve channel filter if set, in the --team or --channel-team team,
+	// or failing both in every team
+	var channelTeamName string
+	if channelFilter != "" {
+		var teams []*model.Team
+		var err error
+		switch {
+		case filterTeam != nil:
+			teams = []*model.Team{filterTeam}
+		case opts.ChannelTeam != "":
+			var team *model.Team
+			if team, err = ResolveTeam(client, opts.ChannelTeam); err == nil {
+				teams = []*model.Team{team}
+				channelTeamName = team.DisplayName
+			}
+		default:
+			teams, err = client.GetAllTeams()
+		}
+		if err == nil {
+			inChannels, err = ResolveChannels(client, teams, ParseChannelFilter(channelFilter))
+		}
+		if err != nil {
+			logger.Printf("%v", err)
+			return nil, ExitConfigError
+		}
+		if verbose {
+			logger.Printf("Scoping to channel(s): %s", strings.Join(inChannels.names, ", "))
+		}
+	}
+
+	deployment := DeploymentSelfHosted
+	if client.IsCloud() {
+		deployment = DeploymentCloud
+	}
+
+	// Switch off what the server is too old for before calling it, then
+	// only look up per-guest retention policies when the server has any
+	state := &enrichmentState{timings: NewStepTimings()}
+	state.timings.usage = func() (int64, int64) {
+		info := client.ServerInfo()
+		return info.APICalls, info.APIBytes
+	}
+	state.disableForVersion(client.ServerInfo().Version, opts, logger)
+	if state.enabled(EnrichRetention) {
+		policyCount, err := client.GetDataRetentionPoliciesCount()
+		if err != nil {
+			if !state.disableIfUnsupported(EnrichRetention, err, verbose) && verbose {
+				logger.Printf("Data retention policies unavailable, skipping retention check: %v", err)
+			}
+		} else {
+			state.checkRetention = policyCount > 0
+			if verbose {
+				logger.Printf("Found %d custom data retention policy(ies)", policyCount)
+			}
+		}
+	}
+
+	// Look the --profile-fields names up once; a name the server does not
+	// define is a mistake in the command, not a gap in the report
+	profileColumns := opts.ProfileFields
+	if len(opts.ProfileFields) > 0 && state.enabled(EnrichProfileFields) {
+		fields, err := client.GetProfileFields()
+		if err != nil {
+			if !state.disableIfUnsupported(EnrichProfileFields, err, verbose) {
+				logger.Printf("%v", err)
+				return nil, ExitAPIError
+			}
+			logger.Printf("Warning: custom profile attributes are not available with this server or token; --profile-fields columns are left empty: %v", err)
+		} else {
+			state.profileFields, err = SelectProfileFields(fields, opts.ProfileFields)
+			if err != nil {
+				logger.Printf("%v", err)
+				return nil, ExitConfigError
+			}
+			profileColumns = make([]string, len(state.profileFields))
+			for i, f := range state.profileFields {
+				profileColumns[i] = f.Name
+			}
+		}
+	}
+
+	// Paginate through all guest users
+	guestRoles := opts.GuestRoles
+	if len(guestRoles) == 0 {
+		guestRoles = DefaultGuestRoles
+	}
+	if verbose {
+		logger.Printf("Retrieving guest users (roles: %s)...", strings.Join(guestRoles, ", "))
+	}
+	listing := StageStats{Stage: StepListing}
+	listingStarted := time.Now()
+	listingCalls, listingBytes := state.timings.used()
+	progress := opts.Progress
+	expectedGuests := 0
+	if progress != nil {
+		count, err := client.GetGuestCount(guestRoles, filterTeamID)
+		if err != nil {
+			if verbose {
+				logger.Printf("Warning: could not retrieve guest count: %v", err)
+			}
+		} else {
+			expectedGuests = int(count)
+		}
+	}
+	progress.Start("Listing guests", expectedGuests)
+	var allGuests []*model.User
+	page := 0
+	perPage := 200
+	for {
+		// Transient failures are retried for this page only, so listing
+		// resumes where it left off rather than starting again. A team-scoped
+		// audit lists only that team's guests rather than every guest on the
+		// instance.
+		var users []*model.User
+		err := opts.Retry.Do(fmt.Sprintf("listing guests (page %d)", page), verbose, func() error {
+			var err error
+			users, err = client.GetGuestUsers(guestRoles, filterTeamID, page, perPage)
+			return err
+		})
+		if err != nil {
+			logger.Printf("%v", err)
+			return nil, ExitAPIError
+		}
+		allGuests = append(allGuests, users...)
+		progress.Update(len(allGuests))
+		if len(users) < perPage {
+			break
+		}
+		page++
+	}
+	progress.Finish()
+
+	if verbose {
+		logger.Printf("Found %d guest user(s)", len(allGuests))
+	}
+
+	// Mentions by anyone outside the guest list count as internal
+	state.guestIDs = make(map[string]bool, len(allGuests))
+	for _, u := range allGuests {
+		state.guestIDs[u.Id] = true
+	}
+
+	// Members on external domains are audited alongside the guests, but
+	// stay internal users for mention counts
+	state.shouldBeGuest = make(map[string]bool)
+	if len(opts.MemberDomains) > 0 {
+		for page := 0; ; page++ {
+			var users []*model.User
+			err := opts.Retry.Do(fmt.Sprintf("listing members (page %d)", page), verbose, func() error {
+				var err error
+				users, err = client.GetGuestUsers([]string{model.SystemUserRoleId}, filterTeamID, page, perPage)
+				return err
+			})
+			if err != nil {
+				logger.Printf("%v", err)
+				return nil, ExitAPIError
+			}
+			for _, u := range users {
+				if !u.IsBot && !state.guestIDs[u.Id] && !state.shouldBeGuest[u.Id] && EmailDomainMatches(u.Email, opts.MemberDomains) {
+					state.shouldBeGuest[u.Id] = true
+					allGuests = append(allGuests, u)
+				}
+			}
+			if len(users) < perPage {
+				break
+			}
+		}
+		if verbose {
+			logger.Printf("Found %d member(s) with email on %s", len(state.shouldBeGuest), strings.Join(opts.MemberDomains, ", "))
+		}
+	}
+	listing.Guests = len(allGuests)
+	listing.Elapsed = time.Since(listingStarted)
+	usedCalls, usedBytes := state.timings.used()
+	listing.APICalls, listing.BytesReceived = usedCalls-listingCalls, usedBytes-listingBytes
+
+	// Created-date filters need no enrichment, so apply them first
+	if opts.CreatedAfter != nil || opts.CreatedBefore != nil {
+		var kept []*model.User
+		for _, u := range allGuests {
+			if CreatedInRange(MillisToTime(u.CreateAt), opts.CreatedAfter, opts.CreatedBefore) {
+				kept = append(kept, u)
+			}
+		}
+		if verbose {
+			logger.Printf("%d guest(s) within the created-date range", len(kept))
+		}
+		allGuests = kept
+	}
+
+	// So does the auth method filter
+	if len(opts.AuthMethods) > 0 {
+		var kept []*model.User
+		for _, u := range allGuests {
+			if slices.Contains(opts.AuthMethods, AuthMethodName(u.AuthService)) {
+				kept = append(kept, u)
+			}
+		}
+		if verbose {
+			logger.Printf("%d guest(s) using auth method %s", len(kept), strings.Join(opts.AuthMethods, " or "))
+		}
+		allGuests = kept
+	}
+
+	// And --match
+	if opts.Match != nil {
+		var kept []*model.User
+		for _, u := range allGuests {
+			if MatchesGuest(opts.Match, u.Username, u.Email, BuildDisplayName(u.FirstName, u.LastName)) {
+				kept = append(kept, u)
+			}
+		}
+		if verbose {
+			logger.Printf("%d guest(s) matching %s", len(kept), opts.Match)
+		}
+		allGuests = kept
+	}
+
+	// Never-logged-in guests are known from the listing too, whatever
+	// --inactive-days says
+	if opts.NeverLoggedIn {
+		var kept []*model.User
+		for _, u := range allGuests {
+			if u.LastActivityAt == 0 {
+				kept = append(kept, u)
+			}
+		}
+		if verbose {
+			logger.Printf("%d guest(s) never logged in", len(kept))
+		}
+		allGuests = kept
+	}
+	// Account age is known from the listing, and so is a recent login, which
+	// rules a guest out of --min-idle-days before their last post is looked up
+	if opts.MinAgeDays > 0 || opts.MinIdleDays > 0 {
+		now := time.Now()
+		var kept []*model.User
+		for _, u := range allGuests {
+			if !OldEnough(AccountAgeDays(MillisToTime(u.CreateAt), now), opts.MinAgeDays) {
+				continue
+			}
+			if opts.MinIdleDays > 0 && !IdleEnough(DaysSinceActivity(MillisToTime(u.LastActivityAt), nil, now), opts.MinIdleDays) {
+				continue
+			}
+			kept = append(kept, u)
+		}
+		if verbose {
+			logger.Printf("%d guest(s) within the age and idle bounds", len(kept))
+		}
+		allGuests = kept
+	}
+	if opts.UnverifiedOnly {
+		var kept []*model.User
+		for _, u := range allGuests {
+			if !u.EmailVerified {
+				kept = append(kept, u)
+			}
+		}
+		if verbose {
+			logger.Printf("%d guest(s) with an unverified email", len(kept))
+		}
+		allGuests = kept
+	}
+
+	// Process each guest
+	if opts.InactivityMetric == "" {
+		opts.InactivityMetric = MetricLogin
+	}
+	result := &AuditResult{
+		InactiveDays: opts.InactiveDays,
+		GuestRoles:   guestRoles,
+		MaxGuestAge:  opts.MaxGuestAge,
+
+		MaxPasswordAge: opts.MaxPasswordAge,
+
+		InactivityMetric: opts.InactivityMetric,
+		Deployment:       deployment,
+		ExtraFields:      opts.ExtraFields,
+		ProfileFields:    profileColumns,
+	}
+	exitCode := ExitSuccess
+	now := time.Now()
+	unchanged := reusableRecords(opts)
+	reused := 0
+
+	// A streamed guest is written and counted, then dropped
+	reported := 0
+	counter := newSummaryCounter(opts.AgeBuckets, now)
+	report := func(g GuestRecord) error {
+		reported++
+		if opts.Stream == nil {
+			result.Guests = append(result.Guests, g)
+			return nil
+		}
+		counter.add(g)
+		return opts.Stream.Write(g)
+	}
+	if opts.Stream != nil {
+		if err := opts.Stream.Begin(result); err != nil {
+			logger.Printf("error: failed to write output: %v", err)
+			return nil, ExitOutputError
+		}
+	}
+
+	progress.Start("Enriching guests", len(allGuests))
+	for i, u := range allGuests {
+		if opts.Sample > 0 && reported >= opts.Sample {
+			if verbose {
+				logger.Printf("Sample of %d guest(s) reached, skipping the remaining %d", opts.Sample, len(allGuests)-i)
+			}
+			break
+		}
+		progress.Update(i)
+		if prev, ok := unchanged[u.Id]; ok && prev.UpdateAt == u.UpdateAt && prev.ActivityAt == u.LastActivityAt {
+			refreshReused(&prev, opts, now)
+			applyAllowlist(&prev, opts.Allowlist, now, verbose)
+			prev.Checksum = GuestChecksum(prev)
+			if err := report(prev); err != nil {
+				logger.Printf("error: failed to write output: %v", err)
+				return nil, ExitOutputError
+			}
+			reused++
+			continue
+		}
+		record, err := processGuest(client, u, filterTeamID, inChannels, opts, state)
+		if err != nil {
+			if verbose {
+				logger.Guest(u.Username).Printf("Warning: failed to process guest: %v", err)
+			}
+			var failure *lookupFailure
+			var lookupErrs []LookupError
+			if errors.As(err, &failure) {
+				lookupErrs = []LookupError{failure.lookup}
+			}
+			record = &GuestRecord{
+				Username:    u.Username,
+				DisplayName: BuildDisplayName(u.FirstName, u.LastName),
+				Nickname:    u.Nickname,
+				Email:       u.Email,
+				Position:    u.Position,
+				AuthMethod:  AuthMethodName(u.AuthService),
+				Locale:      u.Locale,
+				Timezone:    u.GetPreferredTimezone(),
+				CreatedAt:   MillisToTime(u.CreateAt),
+				Active:      u.DeleteAt == 0,
+				Failed:      true,
+				Errors:      lookupErrs,
+
+				ShouldBeGuest: state.shouldBeGuest[u.Id],
+				EmailVerified: u.EmailVerified,
+				DeactivatedAt: MillisToTime(u.DeleteAt),
+
+				PasswordUpdatedAt: PasswordUpdatedAt(u),
+				Bot:               BotSignal(u),
+			}
+			record.PurgeCandidate = IsPurgeCandidate(record.DeactivatedAt, opts.DeactivatedDays, time.Now())
+			record.AgeDays = AccountAgeDays(record.CreatedAt, time.Now())
+			record.Expired = IsExpired(record.CreatedAt, record.Active, opts.MaxGuestAge, time.Now())
+			setPasswordAge(record, opts.MaxPasswordAge, time.Now())
+			exitCode = ExitPartialFailure
+		}
+		// A guest missing some channels is still reported, but its channel
+		// list, which --remove-from-channels acts on, is incomplete. Other
+		// failed lookups leave optional fields empty, as before.
+		if record != nil && slices.ContainsFunc(record.Errors, func(e LookupError) bool { return e.Stage == LookupChannels }) {
+			exitCode = ExitPartialFailure
+		}
+
+		// Skip guests not in the filtered team (processGuest returns nil)
+		if record == nil {
+			continue
+		}
+
+		applyAllowlist(record, opts.Allowlist, now, verbose)
+		record.Checksum = GuestChecksum(*record)
+
+		if err := report(*record); err != nil {
+			logger.Printf("error: failed to write output: %v", err)
+			return nil, ExitOutputError
+		}
+	}
+	progress.Update(len(allGuests))
+	progress.Finish()
+
+	result.UnavailableEnrichment = state.unavailable
+	result.PermissionMissing = state.denied
+	if reused > 0 {
+		// Reused records were built when these enrichments were unavailable
+		for _, name := range opts.Previous.UnavailableEnrichment {
+			if !slices.Contains(result.UnavailableEnrichment, name) {
+				result.UnavailableEnrichment = append(result.UnavailableEnrichment, name)
+			}
+		}
+		for _, name := range opts.Previous.PermissionMissing {
+			if !slices.Contains(result.PermissionMissing, name) {
+				result.PermissionMissing = append(result.PermissionMissing, name)
+			}
+		}
+	}
+	if verbose {
+		if unchanged != nil {
+			logger.Printf("Reused %d unchanged guest record(s) from the previous run", reused)
+		}
+		state.timings.Write(logger)
+	}
+
+	SortGuests(result.Guests, opts.Sort)
+	GroupGuestsByOwner(result.Guests, opts.OwnerField)
+
+	result.OwnerField = opts.OwnerField
+	result.LicensedSeats = client.ServerInfo().LicensedSeats
+	if opts.Stream != nil {
+		result.Summary = counter.finish(result.LicensedSeats)
+	} else {
+		summarize(result, opts.AgeBuckets, now)
+	}
+
+	// Record the team and channels as resolved, not as typed
+	scope := opts
+	scope.TeamFilter = filterTeamName
+	scope.ChannelTeam = channelTeamName
+	if inChannels.active() {
+		scope.ChannelFilter = strings.Join(inChannels.names, "|")
+	}
+	result.Metadata = newRunMetadata(client, scope, started, callsBefore)
+
+	if opts.Stats {
+		info := client.ServerInfo()
+		result.Stats = &RunStats{
+			Elapsed:       time.Since(started),
+			APICalls:      info.APICalls - callsBefore,
+			BytesReceived: info.APIBytes - bytesBefore,
+			ReusedGuests:  reused,
+			Stages:        append([]StageStats{listing}, state.timings.Stages()...),
+		}
+		for _, st := range result.Stats.Stages {
+			result.Stats.CacheHits += st.CacheHits
+		}
+		result.Stats.Write(logger)
+	}
+
+	return result, exitCode
+}
+
+// reusableRecords returns the previous run's records that may be reused,
+// keyed by user ID. Failed or incomplete lookups and records with missing
+// fields are always enriched again. Mentions, post counts and guest-only channels change with other users'
+// activity rather than the guest's, so nothing is reused when they are
+// requested. Nor with --last-viewed or --audit-log: viewing or joining a
+// channel changes neither UpdateAt nor, reliably, LastActivityAt. Nor with --profile-fields, whose
+// values are stored apart from the user and leave UpdateAt alone, or
+// --groups, whose memberships change with group sync.
+func reusableRecords(opts AuditOptions) map[string]GuestRecord {
+	if opts.Previous == nil || opts.FullEnrichment || opts.MentionDays > 0 || opts.MentionCountDays > 0 || opts.PostCount || opts.GuestOnly || opts.LastViewed || opts.AuditLog || opts.Groups || len(opts.ProfileFields) > 0 {
+		return nil
+	}
+	records := make(map[string]GuestRecord, len(opts.Previous.Guests))
+	for _, g := range opts.Previous.Guests {
+		if g.UserID == "" || g.Failed || len(g.Errors) > 0 || len(g.PermissionMissing) > 0 {
+			continue
+		}
+		records[g.UserID] = g
+	}
+	return records
+}
+
+// refreshReused recomputes the parts of a reused record that depend on the
+// current time, and clears its exception so the allowlist is applied afresh.
+func refreshReused(g *GuestRecord, opts AuditOptions, now time.Time) {
+	g.Inactive = IsInactiveByMetric(opts.InactivityMetric, g.LastLogin, g.LastPost, g.LastViewed, viewSignal(opts.LastViewed, g.ViewUnknown), opts.InactiveDays, now)
+	g.PurgeCandidate = IsPurgeCandidate(g.DeactivatedAt, opts.DeactivatedDays, now)
+	g.AgeDays = AccountAgeDays(g.CreatedAt, now)
+	g.DaysSinceLastActivity = DaysSinceActivity(g.LastLogin, g.LastPost, now)
+	g.Expired = IsExpired(g.CreatedAt, g.Active, opts.MaxGuestAge, now)
+	setPasswordAge(g, opts.MaxPasswordAge, now)
+	g.Excepted = false
+	g.ExceptionJustification = ""
+	g.ExceptionExpires = nil
+	g.ExceptionTicket = ""
+}
+
+// summarize recalculates the overall, per-team and age-bucket counts from
+// the guest records.
+func summarize(result *AuditResult, ageBuckets []int, now time.Time) {
+	c := newSummaryCounter(ageBuckets, now)
+	for _, g := range result.Guests {
+		c.add(g)
+	}
+	result.Summary = c.finish(result.LicensedSeats)
+	if result.OwnerField != "" {
+		result.Summary.Owners = rollupOwners(result.Guests, result.OwnerField)
+	}
+}
+
+// summaryCounter builds an AuditSummary one guest at a time, so --stream
+// can count guests it no longer holds.
+type summaryCounter struct {
+	summary   AuditSummary
+	now       time.Time
+	guests    int
+	members   int
+	guestOnly map[ResourceInfo]bool
+}
+
+func newSummaryCounter(ageBuckets []int, now time.Time) *summaryCounter {
+	if len(ageBuckets) == 0 {
+		ageBuckets = DefaultAgeBuckets
+	}
+	return &summaryCounter{
+		summary: AuditSummary{
+			FailuresByStage: make(map[string]int),
+			ByTeam:          make(map[string]*TeamSummary),
+			AgeBuckets:      NewAgeBuckets(ageBuckets),
+		},
+		now:       now,
+		guestOnly: make(map[ResourceInfo]bool),
+	}
+}
+
+// add counts one guest record.
+func (c *summaryCounter) add(g GuestRecord) {
+	s := &c.summary
+	c.guests++
+	for _, e := range g.Errors {
+		s.FailuresByStage[e.Stage]++
+	}
+	if g.ShouldBeGuest {
+		// Listed for review only: a member is not a guest and holds a
+		// member seat whatever happens to it
+		c.members++
+		if g.Failed {
+			s.FailedLookups++
+		} else if len(g.Errors) > 0 {
+			s.IncompleteGuests++
+		}
+		return
+	}
+	if g.Active {
+		s.License.GuestSeats++
+	}
+	if g.Bot != "" {
+		s.BotGuests++
+	}
+	if g.Failed {
+		s.FailedLookups++
+		return
+	}
+	if len(g.Errors) > 0 {
+		s.IncompleteGuests++
+	}
+	if g.RetentionChannels > 0 {
+		s.RetentionGuests++
+	}
+	if g.SharedAccount != nil && *g.SharedAccount {
+		s.SharedAccountGuests++
+	}
+	if len(g.ElevatedRoles) > 0 {
+		s.ElevatedRoleGuests++
+	}
+	if len(g.DirectChannels) > 0 {
+		s.DirectMemberGuests++
+	}
+	if g.Orphaned {
+		s.OrphanedGuests++
+	}
+	if g.LastLogin == nil {
+		s.NeverLoggedInGuests++
+	}
+	if !g.EmailVerified {
+		s.UnverifiedGuests++
+	}
+	for _, ch := range g.GuestOnlyChannels {
+		c.guestOnly[ch] = true
+	}
+	for _, t := range g.Teams {
+		ts, ok := s.ByTeam[t.DisplayName]
+		if !ok {
+			ts = &TeamSummary{}
+			s.ByTeam[t.DisplayName] = ts
+		}
+		ts.add(g)
+	}
+	if g.Active {
+		addToAgeBucket(s.AgeBuckets, LastActivity(g.LastLogin, g.LastPost), c.now)
+	}
+	if g.PurgeCandidate {
+		s.PurgeCandidates++
+	}
+	if g.Expired {
+		s.ExpiredGuests++
+	}
+	if g.PasswordExpired {
+		s.ExpiredPasswords++
+	}
+	if !g.Active {
+		s.DeactivatedGuests++
+	} else if g.Excepted {
+		s.ExceptedGuests++
+	} else if g.Inactive {
+		s.InactiveGuests++
+		s.License.FreeableSeats++
+	} else {
+		s.ActiveGuests++
+	}
+}
+
+// finish returns the summary of the guests added so far.
+func (c *summaryCounter) finish(licensedSeats int) AuditSummary {
+	s := c.summary
+	if licensedSeats > 0 {
+		s.License.LicensedSeats = &licensedSeats
+	}
+	s.MembersShouldBeGuests = c.members
+	s.GuestOnlyChannels = len(c.guestOnly)
+	s.TotalGuests = c.guests - c.members
+	return s
+}
+
+// processGuest enriches a single guest user with team, channel, and activity data.
+func processGuest(client MattermostClient, u *model.User, filterTeamID string, inChannels channelScope, opts AuditOptions, state *enrichmentState) (*GuestRecord, error) {
+	verbose := opts.Verbose
+	var missing []string
+
+	// Transient failures are retried per lookup. A lookup that still fails
+	// is recorded in lookupErrs and the guest reported without its data,
+	// unless the guest cannot be reported correctly without it.
+	var lookupErrs []LookupError
+	log := logger.Guest(u.Username)
+	guestRetry := opts.Retry
+	guestRetry.Log = log
+	retry := func(op string, fn func() error) error {
+		return guestRetry.Do(op, verbose, fn)
+	}
+	noteFailure := func(lookup, team string, err error) {
+		lookupErrs = append(lookupErrs, newLookupError(lookup, team, err))
+	}
+
+	// Get teams for this user. Without permission the guest is still
+	// reported, unless a team filter or --orphans-only needs their teams to
+	// decide.
+	stop := state.timings.Start(StepTeams)
+	var teams []*model.Team
+	err := retry("getting teams", func() (err error) {
+		teams, err = client.GetTeamsForUser(u.Id)
+		return err
+	})
+	stop()
+	if err != nil {
+		if !IsPermissionDenied(err) || filterTeamID != "" || opts.OrphansOnly {
+			return nil, failLookup(LookupTeams, "", err, "failed to get teams")
+		}
+		missing = addMissing(missing, "teams", "channels")
+	}
+	// An archived team keeps its members, but they can no longer use it, so
+	// a guest left with only archived teams is as orphaned as one with none
+	orphaned := err == nil && !slices.ContainsFunc(teams, func(t *model.Team) bool { return t.DeleteAt == 0 })
+	if opts.OrphansOnly && !orphaned {
+		return nil, nil
+	}
+
+	// Filter teams if team scoping is active
+	var teamInfos []TeamInfo
+	for _, t := range teams {
+		if filterTeamID != "" && t.Id != filterTeamID {
+			continue
+		}
+		if t.DeleteAt != 0 && !opts.IncludeArchivedTeams {
+			continue
+		}
+		teamInfos = append(teamInfos, TeamInfo{
+			ID:          t.Id,
+			DisplayName: t.DisplayName,
+			Archived:    t.DeleteAt != 0,
+		})
+	}
+
+	// If team filter is active and this guest is not in that team, skip
+	if filterTeamID != "" && len(teamInfos) == 0 {
+		return nil, nil
+	}
+
+	// Get channels per team. DMs and group messages come back with every
+	// team, so each channel is listed once, under the first team.
+	var channels []ChannelInfo
+	seenChannels := make(map[string]bool)
+	var teamIDs []string
+	stop = state.timings.Start(StepChannels)
+	for _, ti := range teamInfos {
+		if ti.Archived {
+			// Listed for the record; its channels are out of reach
+			continue
+		}
+		teamIDs = append(teamIDs, ti.ID)
+		if inChannels.active() && !inChannels.teams[ti.ID] {
+			// None of the --channel channels are in this team
+			continue
+		}
+		var chs []*model.Channel
+		var err error
+		bulk := false
+		if opts.BulkChannels {
+			chs, bulk = state.channelsForTeam(client, ti.ID, u.Id, verbose)
+		}
+		if !bulk {
+			err = retry(fmt.Sprintf("getting channels in %q", ti.DisplayName), func() (err error) {
+				chs, err = client.GetChannelsForTeamForUser(ti.ID, u.Id)
+				return err
+			})
+		}
+		if err != nil {
+			// The guest's other teams are still reported, unless a
+			// --channel filter needs this team's channels to decide
+			if !inChannels.active() {
+				if IsPermissionDenied(err) {
+					missing = addMissing(missing, "channels")
+				} else {
+					noteFailure(LookupChannels, ti.DisplayName, err)
+					if verbose {
+						log.Printf("Warning: could not retrieve channels in %q: %v", ti.DisplayName, err)
+					}
+				}
+				continue
+			}
+			stop()
+			return nil, failLookup(LookupChannels, ti.DisplayName, err, fmt.Sprintf("failed to get channels for team %q", ti.DisplayName))
+		}
+		for _, ch := range chs {
+			if inChannels.active() && !inChannels.channels[ch.Id] {
+				continue
+			}
+			if seenChannels[ch.Id] || (ch.DeleteAt != 0 && !opts.IncludeArchived) {
+				continue
+			}
+			seenChannels[ch.Id] = true
+			channels = append(channels, ChannelInfo{
+				ID:          ch.Id,
+				TeamName:    ti.DisplayName,
+				ChannelName: ch.DisplayName,
+				Type:        channelTypeName(ch.Type),
+				Default:     ch.Name == model.DefaultChannelName,
+				Archived:    ch.DeleteAt != 0,
+			})
+		}
+	}
+	sortChannels(channels)
+	stop()
+
+	// If channel filter is active and this guest has no matching channel, skip
+	if inChannels.active() && len(channels) == 0 {
+		return nil, nil
+	}
+
+	// With --private-only, skip guests without private channel access before
+	// spending API calls on them
+	privateChannels := countPrivateChannels(channels)
+	if opts.PrivateOnly && privateChannels == 0 {
+		return nil, nil
+	}
+	channelCount := countChannels(channels)
+	if !ChannelCountInRange(channelCount, opts.MinChannels, opts.MaxChannels) {
+		return nil, nil
+	}
+
+	// Flag channels under a custom data retention policy
+	retentionChannels := 0
+	if state.checkRetention && state.enabled(EnrichRetention) && len(channels) > 0 {
+		stop := state.timings.Start(StepRetention)
+		var policies map[string]int64
+		err := retry("getting retention policies", func() (err error) {
+			policies, err = getChannelPolicies(client, u.Id)
+			return err
+		})
+		stop()
+		if err != nil {
+			if IsTimeout(err) {
+				return nil, failLookup(EnrichRetention, "", err, "failed to get retention policies")
+			}
+			if !state.disableIfUnsupported(EnrichRetention, err, verbose) {
+				noteFailure(EnrichRetention, "", err)
+				if verbose {
+					log.Printf("Warning: could not retrieve retention policies: %v", err)
+				}
+			}
+			// Non-fatal — continue without retention data
+		} else {
+			for i := range channels {
+				if days, ok := policies[channels[i].ID]; ok {
+					channels[i].RetentionPolicy = true
+					channels[i].RetentionDays = days
+					retentionChannels++
+				}
+			}
+		}
+	}
+
+	// Get last post date
+	var lastPost *time.Time
+	if len(teamIDs) > 0 {
+		stop := state.timings.Start(StepPosts)
+		err = retry("getting last post date", func() (err error) {
+			lastPost, err = client.GetLastPostDateForUser(u.Id, u.Username, teamIDs)
+			return err
+		})
+		stop()
+		if err != nil {
+			// A search that timed out says nothing about activity; fail the
+			// guest rather than report them inactive
+			if IsTimeout(err) {
+				return nil, failLookup(LookupLastPost, "", err, "failed to get last post date")
+			}
+			if IsPermissionDenied(err) {
+				missing = addMissing(missing, "last_post")
+			} else {
+				noteFailure(LookupLastPost, "", err)
+			}
+			if verbose {
+				log.Printf("Warning: could not retrieve last post date: %v", err)
+			}
+			// Non-fatal — continue without last post date
+		}
+	}
+
+	// Count posts in the guest's channels
+	var postCount *int
+	if opts.PostCount && state.enabled(EnrichPostCount) {
+		stop := state.timings.Start(StepPostCount)
+		postCount = state.postCount(client, u.Id, channels, opts.Since, verbose)
+		stop()
+	}
+
+	// Find the guest's channels with no internal member
+	var guestOnly []ResourceInfo
+	if opts.GuestOnly && state.enabled(EnrichGuestOnly) {
+		stop := state.timings.Start(StepGuestOnly)
+		guestOnly = state.guestOnlyChannels(client, channels, verbose)
+		stop()
+	}
+
+	// Get file upload activity
+	var lastFileUpload *time.Time
+	var fileCount *int
+	fileActivity := opts.FileActivity && state.enabled(EnrichFileActivity)
+	if fileActivity && len(teamIDs) > 0 {
+		stop := state.timings.Start(StepFiles)
+		var count int
+		var last *time.Time
+		err := retry("getting file activity", func() (err error) {
+			count, last, err = client.GetFileActivityForUser(u.Username, teamIDs)
+			return err
+		})
+		stop()
+		if err != nil {
+			if IsTimeout(err) {
+				return nil, failLookup(EnrichFileActivity, "", err, "failed to get file activity")
+			}
+			if !state.disableIfUnsupported(EnrichFileActivity, err, verbose) {
+				noteFailure(EnrichFileActivity, "", err)
+				if verbose {
+					log.Printf("Warning: could not retrieve file activity: %v", err)
+				}
+			}
+			// Non-fatal — continue without file activity
+		} else {
+			lastFileUpload = last
+			fileCount = &count
+		}
+	} else if fileActivity {
+		zero := 0
+		fileCount = &zero
+	}
+
+	// Previous usernames/emails from the audit log
+	var prevUsernames, prevEmails []string
+	var audits []model.Audit
+	auditsLoaded := false
+	if opts.IdentityHistory && state.enabled(EnrichIdentityHistory) {
+		stop := state.timings.Start(StepAudits)
+		err := retry("getting audit records", func() (err error) {
+			audits, err = getUserAudits(client, u.Id)
+			return err
+		})
+		stop()
+		if err != nil {
+			if IsTimeout(err) {
+				return nil, failLookup(EnrichIdentityHistory, "", err, "failed to get audit records")
+			}
+			if !state.disableIfUnsupported(EnrichIdentityHistory, err, verbose) {
+				noteFailure(EnrichIdentityHistory, "", err)
+				if verbose {
+					log.Printf("Warning: could not retrieve audit records: %v", err)
+				}
+			}
+			// Non-fatal — continue without identity history
+		} else {
+			auditsLoaded = true
+			prevUsernames, prevEmails = ExtractIdentityHistory(audits, u.Username, u.Email)
+		}
+	}
+
+	// The last audited action, from the same records
+	var auditedAction string
+	var auditedAt *time.Time
+	if opts.AuditLog && state.enabled(EnrichAuditLog) {
+		var err error
+		if !auditsLoaded {
+			stop := state.timings.Start(StepAudits)
+			err = retry("getting audit records", func() (err error) {
+				audits, err = getUserAudits(client, u.Id)
+				return err
+			})
+			stop()
+		}
+		if err != nil {
+			if IsTimeout(err) {
+				return nil, failLookup(EnrichAuditLog, "", err, "failed to get audit records")
+			}
+			if !state.disableIfUnsupported(EnrichAuditLog, err, verbose) {
+				noteFailure(EnrichAuditLog, "", err)
+				if verbose {
+					log.Printf("Warning: could not retrieve audit records: %v", err)
+				}
+			}
+			// Non-fatal — continue without the audited action
+		} else {
+			auditsLoaded = true
+			auditedAction, auditedAt = LastAuditedAction(audits)
+			joins := ChannelJoinTimes(audits)
+			for i, ch := range channels {
+				if t, ok := joins[ch.ID]; ok {
+					channels[i].JoinedAt = &t
+				}
+			}
+		}
+	}
+
+	// Concurrent sessions from different networks. Session IPs come from the
+	// audit records, which are reused if identity history loaded them.
+	var sharedAccount *bool
+	var sharedIPs []string
+	if opts.SharedSessions && state.enabled(EnrichSessions) {
+		stop := state.timings.Start(StepSessions)
+		var sessions []*model.Session
+		err := retry("getting sessions", func() (err error) {
+			sessions, err = client.GetSessions(u.Id)
+			return err
+		})
+		if err == nil && !auditsLoaded {
+			err = retry("getting audit records", func() (err error) {
+				audits, err = getUserAudits(client, u.Id)
+				return err
+			})
+		}
+		stop()
+		if err != nil {
+			if IsTimeout(err) {
+				return nil, failLookup(EnrichSessions, "", err, "failed to get sessions")
+			}
+			if !state.disableIfUnsupported(EnrichSessions, err, verbose) {
+				noteFailure(EnrichSessions, "", err)
+				if verbose {
+					log.Printf("Warning: could not retrieve sessions: %v", err)
+				}
+			}
+			// Non-fatal — continue without session analysis
+		} else {
+			sharedIPs = SharedSessionIPs(sessions, audits, time.Now())
+			shared := len(sharedIPs) > 0
+			sharedAccount = &shared
+		}
+	}
+
+	// Team and channel roles beyond the guest role. Members listed with
+	// MemberDomains hold member roles by definition, so are not checked.
+	var elevated []RoleGrant
+	if opts.CheckRoles && state.enabled(EnrichRoles) && !state.shouldBeGuest[u.Id] && len(teamInfos) > 0 {
+		stop := state.timings.Start(StepRoles)
+		err := retry("getting roles", func() (err error) {
+			elevated, err = getElevatedRoles(client, u.Id, teamInfos, channels)
+			return err
+		})
+		stop()
+		if err != nil {
+			if IsTimeout(err) {
+				return nil, failLookup(EnrichRoles, "", err, "failed to get roles")
+			}
+			if !state.disableIfUnsupported(EnrichRoles, err, verbose) {
+				noteFailure(EnrichRoles, "", err)
+				if verbose {
+					log.Printf("Warning: could not retrieve roles: %v", err)
+				}
+			}
+			// Non-fatal — continue without role checks
+		}
+	}
+
+	// The guest's groups, and the channels none of them is synced to
+	var groups []string
+	var directChannels []ResourceInfo
+	if opts.Groups && state.enabled(EnrichGroups) {
+		stop := state.timings.Start(StepGroups)
+		err := retry("getting groups", func() (err error) {
+			groups, directChannels, err = state.groupMemberships(client, u.Id, channels)
+			return err
+		})
+		stop()
+		if err != nil {
+			if IsTimeout(err) {
+				return nil, failLookup(EnrichGroups, "", err, "failed to get groups")
+			}
+			if !state.disableIfUnsupported(EnrichGroups, err, verbose) {
+				noteFailure(EnrichGroups, "", err)
+				if verbose {
+					log.Printf("Warning: could not retrieve groups: %v", err)
+				}
+			}
+			// Non-fatal — continue without group memberships
+		}
+	}
+
+	// Boards and playbooks the guest can access
+	var boards, playbooks []ResourceInfo
+	if opts.PluginAccess {
+		stop := state.timings.Start(StepPlugins)
+		for _, ti := range teamInfos {
+			if ti.Archived {
+				continue
+			}
+			if state.enabled(EnrichBoards) {
+				for _, name := range state.membersForTeam(EnrichBoards, ti.ID, client.GetBoardMembers, verbose)[u.Id] {
+					boards = append(boards, ResourceInfo{TeamName: ti.DisplayName, Name: name})
+				}
+			}
+			if state.enabled(EnrichPlaybooks) {
+				for _, name := range state.membersForTeam(EnrichPlaybooks, ti.ID, client.GetPlaybookMembers, verbose)[u.Id] {
+					playbooks = append(playbooks, ResourceInfo{TeamName: ti.DisplayName, Name: name})
+				}
+			}
+		}
+		stop()
+	}
+
+	// When the guest last viewed one of their reported channels: a read-only
+	// signal for guests who never post. A partial answer would understate
+	// it, so any team that cannot be read leaves it unknown, and an unknown
+	// view never makes the guest inactive.
+	var lastViewed *time.Time
+	viewUnknown := opts.LastViewed && len(channels) > 0
+	if viewUnknown && state.enabled(EnrichLastViewed) {
+		viewUnknown = false
+		stop := state.timings.Start(StepLastViewed)
+		reported := make(map[string]bool, len(channels))
+		for _, ch := range channels {
+			reported[ch.ID] = true
+		}
+		for _, ti := range teamInfos {
+			if ti.Archived || inChannels.active() && !inChannels.teams[ti.ID] {
+				continue
+			}
+			var members []model.ChannelMember
+			err := retry(fmt.Sprintf("getting channel views in %q", ti.DisplayName), func() (err error) {
+				members, err = client.GetChannelMembersForUser(u.Id, ti.ID)
+				return err
+			})
+			if err != nil {
+				if IsTimeout(err) {
+					stop()
+					return nil, failLookup(EnrichLastViewed, ti.DisplayName, err, fmt.Sprintf("failed to get channel views in %q", ti.DisplayName))
+				}
+				if !state.disableIfUnsupported(EnrichLastViewed, err, verbose) {
+					noteFailure(EnrichLastViewed, ti.DisplayName, err)
+					if verbose {
+						log.Printf("Warning: could not retrieve channel views in %q: %v", ti.DisplayName, err)
+					}
+				}
+				lastViewed, viewUnknown = nil, true
+				break
+			}
+			lastViewed = latestView(members, reported, lastViewed)
+		}
+		stop()
+	}
+
+	lastLogin := MillisToTime(u.LastActivityAt)
+	idleDays := DaysSinceActivity(lastLogin, lastPost, time.Now())
+	if !IdleEnough(idleDays, opts.MinIdleDays) {
+		return nil, nil
+	}
+	active := u.DeleteAt == 0
+	inactive := IsInactiveByMetric(opts.InactivityMetric, lastLogin, lastPost, lastViewed, viewSignal(opts.LastViewed, viewUnknown), opts.InactiveDays, time.Now())
+
+	// Mentions by others: counted for every guest with --mention-count, and
+	// exempting an otherwise inactive guest with --mention-days. One search
+	// covers both, over the longer of the two windows.
+	var lastMention *time.Time
+	var mentionCount *int
+	exemptDays := 0
+	if inactive {
+		exemptDays = opts.MentionDays
+	}
+	if days := max(exemptDays, opts.MentionCountDays); days > 0 && state.enabled(EnrichMentions) && len(teamIDs) > 0 {
+		now := time.Now()
+		stop := state.timings.Start(StepMentions)
+		var mentions []*model.Post
+		err := retry("searching mentions", func() (err error) {
+			mentions, err = client.GetMentionsOfUser(u.Id, u.Username, teamIDs, now.AddDate(0, 0, -days))
+			return err
+		})
+		stop()
+		if err != nil {
+			if IsTimeout(err) {
+				return nil, failLookup(EnrichMentions, "", err, "failed to search mentions")
+			}
+			if !state.disableIfUnsupported(EnrichMentions, err, verbose) {
+				noteFailure(EnrichMentions, "", err)
+				if verbose {
+					log.Printf("Warning: could not search mentions: %v", err)
+				}
+			}
+			// Non-fatal — the guest stays flagged and the count unknown
+		} else {
+			lastMention = latestPost(mentions)
+			if exemptDays > 0 {
+				inactive = !MentionedWithin(lastMention, exemptDays, now)
+			}
+			if opts.MentionCountDays > 0 {
+				n := countInternalMentions(mentions, state.guestIDs, now.AddDate(0, 0, -opts.MentionCountDays))
+				mentionCount = &n
+			}
+		}
+	}
+
+	// The selected custom profile attributes
+	var profileFields map[string]string
+	if len(state.profileFields) > 0 && state.enabled(EnrichProfileFields) {
+		stop := state.timings.Start(StepProfile)
+		var attrs map[string]json.RawMessage
+		err := retry("getting profile attributes", func() (err error) {
+			attrs, err = client.GetProfileAttributes(u.Id)
+			return err
+		})
+		stop()
+		if err != nil {
+			if IsTimeout(err) {
+				return nil, failLookup(EnrichProfileFields, "", err, "failed to get profile attributes")
+			}
+			if !state.disableIfUnsupported(EnrichProfileFields, err, verbose) {
+				noteFailure(EnrichProfileFields, "", err)
+				if verbose {
+					log.Printf("Warning: could not retrieve profile attributes: %v", err)
+				}
+			}
+			// Non-fatal — continue without profile attributes
+		} else {
+			profileFields = profileValues(state.profileFields, attrs)
+		}
+	}
+
+	// Mark the fields of requested enrichments the token was denied, on this
+	// guest or an earlier one
+	requested := map[string]bool{
+		EnrichRetention:       len(channels) > 0,
+		EnrichFileActivity:    opts.FileActivity,
+		EnrichIdentityHistory: opts.IdentityHistory,
+		EnrichBoards:          opts.PluginAccess,
+		EnrichPlaybooks:       opts.PluginAccess,
+		EnrichMentions:        max(exemptDays, opts.MentionCountDays) > 0,
+		EnrichPostCount:       opts.PostCount,
+		EnrichSessions:        opts.SharedSessions,
+		EnrichRoles:           opts.CheckRoles,
+		EnrichGuestOnly:       opts.GuestOnly,
+		EnrichLastViewed:      opts.LastViewed && len(channels) > 0,
+		EnrichProfileFields:   len(opts.ProfileFields) > 0,
+		EnrichAuditLog:        opts.AuditLog,
+		EnrichGroups:          opts.Groups,
+	}
+	for _, name := range state.denied {
+		if requested[name] {
+			missing = addMissing(missing, enrichmentFields[name]...)
+		}
+	}
+
+	record := &GuestRecord{
+		Username:    u.Username,
+		DisplayName: BuildDisplayName(u.FirstName, u.LastName),
+		Nickname:    u.Nickname,
+		Email:       u.Email,
+		Position:    u.Position,
+		AuthMethod:  AuthMethodName(u.AuthService),
+		Locale:      u.Locale,
+		Timezone:    u.GetPreferredTimezone(),
+		CreatedAt:   MillisToTime(u.CreateAt),
+		LastLogin:   lastLogin,
+		LastPost:    lastPost,
+		LastViewed:  lastViewed,
+		ViewUnknown: viewUnknown,
+		Teams:       teamInfos,
+
+		LastAuditedAction: auditedAction,
+		LastAuditedAt:     auditedAt,
+		Channels:          channels,
+		Active:            active,
+		Inactive:          inactive,
+		Orphaned:          orphaned,
+
+		RetentionChannels: retentionChannels,
+		PrivateChannels:   privateChannels,
+		ChannelCount:      channelCount,
+		PreviousUsernames: prevUsernames,
+		PreviousEmails:    prevEmails,
+		LastFileUpload:    lastFileUpload,
+		FileCount:         fileCount,
+		LastMention:       lastMention,
+		MentionCount:      mentionCount,
+		PostCount:         postCount,
+		GuestOnlyChannels: guestOnly,
+		Boards:            boards,
+		Playbooks:         playbooks,
+		PermissionMissing: missing,
+		SharedAccount:     sharedAccount,
+		SharedSessionIPs:  sharedIPs,
+		ElevatedRoles:     elevated,
+		Groups:            groups,
+		DirectChannels:    directChannels,
+		ShouldBeGuest:     state.shouldBeGuest[u.Id],
+		EmailVerified:     u.EmailVerified,
+		DeactivatedAt:     MillisToTime(u.DeleteAt),
+		PasswordUpdatedAt: PasswordUpdatedAt(u),
+		Bot:               BotSignal(u),
+		ProfileFields:     profileFields,
+		Errors:            lookupErrs,
+
+		UserID:     u.Id,
+		UpdateAt:   u.UpdateAt,
+		ActivityAt: u.LastActivityAt,
+	}
+	record.PurgeCandidate = IsPurgeCandidate(record.DeactivatedAt, opts.DeactivatedDays, time.Now())
+	record.AgeDays = AccountAgeDays(record.CreatedAt, time.Now())
+	record.DaysSinceLastActivity = idleDays
+	record.Expired = IsExpired(record.CreatedAt, record.Active, opts.MaxGuestAge, time.Now())
+	setPasswordAge(record, opts.MaxPasswordAge, time.Now())
+
+	return record, nil
+}
+
+// getElevatedRoles checks the guest's team memberships, then their channel
+// memberships in each team, for roles beyond the guest role. Only the
+// channels in channels (those already reported) are considered.
+func getElevatedRoles(client MattermostClient, userID string, teams []TeamInfo, channels []ChannelInfo) ([]RoleGrant, error) {
+	members, err := client.GetTeamMembersForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	teamNames := make(map[string]string, len(teams))
+	for _, t := range teams {
+		teamNames[t.ID] = t.DisplayName
+	}
+	grants := ElevatedTeamRoles(members, teamNames)
+	for _, t := range teams {
+		chMembers, err := client.GetChannelMembersForUser(userID, t.ID)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, ElevatedChannelRoles(chMembers, channels)...)
+	}
+	return grants, nil
+}
+
+// latestView returns the newest LastViewedAt among members of the channels
+// in reported, or latest if none is newer.
+func latestView(members []model.ChannelMember, reported map[string]bool, latest *time.Time) *time.Time {
+	for _, m := range members {
+		if !reported[m.ChannelId] {
+			continue
+		}
+		t := MillisToTime(m.LastViewedAt)
+		if t != nil && (latest == nil || t.After(*latest)) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// latestPost returns the creation time of the newest post, or nil if there are none.
+func latestPost(posts []*model.Post) *time.Time {
+	var latest *time.Time
+	for _, p := range posts {
+		t := MillisToTime(p.CreateAt)
+		if t != nil && (latest == nil || t.After(*latest)) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// countInternalMentions counts mentions since the given time whose author is
+// not a guest.
+func countInternalMentions(mentions []*model.Post, guestIDs map[string]bool, since time.Time) int {
+	n := 0
+	for _, p := range mentions {
+		if !guestIDs[p.UserId] && p.CreateAt >= since.UnixMilli() {
+			n++
+		}
+	}
+	return n
 }
 
-// AuditResult holds the complete audit output.
-type AuditResult struct {
-	Guests       []GuestRecord `json:"guests"`
-	Summary      AuditSummary  `json:"summary"`
-	InactiveDays int           `json:"inactive_days"`
+// MentionedWithin reports whether lastMention falls within the last days days.
+func MentionedWithin(lastMention *time.Time, days int, now time.Time) bool {
+	return lastMention != nil && days > 0 && now.Sub(*lastMention) < time.Duration(days)*24*time.Hour
 }
 
-// RunAudit performs the guest audit against the Mattermost instance.
-func RunAudit(client MattermostClient, teamFilter string, channelFilter string, inactiveDays int, verbose bool) (*AuditResult, int) {
-	var filterTeamID string
-	var filterTeamName string
-	var filterChannelID string
+// maxAuditRecords caps how many of a guest's most recent audit records are scanned.
+const maxAuditRecords = 1000
 
-	// Resolve team filter if set
-	if teamFilter != "" {
-		team, err := client.GetTeamByName(teamFilter)
+func getUserAudits(client MattermostClient, userID string) ([]model.Audit, error) {
+	var all []model.Audit
+	page := 0
+	perPage := 200
+	for len(all) < maxAuditRecords {
+		audits, err := client.GetUserAudits(userID, page, perPage)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-			return nil, ExitConfigError
+			return nil, err
 		}
-		filterTeamID = team.Id
-		filterTeamName = team.DisplayName
-		if verbose {
-			fmt.Fprintf(os.Stderr, "Scoping to team: %s (ID: %s)\n", filterTeamName, filterTeamID)
+		all = append(all, audits...)
+		if len(audits) < perPage {
+			break
 		}
+		page++
 	}
+	return all, nil
+}
 
-	// Resolve channel filter if set
-	if channelFilter != "" {
-		ch, err := client.GetChannelByName(filterTeamID, channelFilter)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: channel %q not found in team %q. Please check the name and try again.\n", channelFilter, teamFilter)
-			return nil, ExitConfigError
-		}
-		filterChannelID = ch.Id
-		if verbose {
-			fmt.Fprintf(os.Stderr, "Scoping to channel: %s (ID: %s)\n", ch.DisplayName, filterChannelID)
+// ExtractIdentityHistory collects usernames and emails recorded in audit
+// ExtraInfo (e.g. "attempt - login_id=jane@old.com", "old_username=jdoe")
+// that differ from the current ones. This is best-effort: Mattermost only
+// records these values for some actions.
+func ExtractIdentityHistory(audits []model.Audit, username, email string) (usernames, emails []string) {
+	seen := make(map[string]bool)
+	for _, a := range audits {
+		for _, field := range strings.Fields(a.ExtraInfo) {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok || value == "" {
+				continue
+			}
+			switch key {
+			case "login_id", "username", "old_username", "email", "old_email":
+			default:
+				continue
+			}
+			isEmail := strings.Contains(value, "@")
+			if (isEmail && strings.EqualFold(value, email)) || (!isEmail && strings.EqualFold(value, username)) {
+				continue
+			}
+			if seen[strings.ToLower(value)] {
+				continue
+			}
+			seen[strings.ToLower(value)] = true
+			if isEmail {
+				emails = append(emails, value)
+			} else {
+				usernames = append(usernames, value)
+			}
 		}
 	}
+	return usernames, emails
+}
 
-	// Paginate through all guest users
-	if verbose {
-		fmt.Fprintln(os.Stderr, "Retrieving guest users...")
-	}
-	var allGuests []*model.User
+// getChannelPolicies returns channel ID → post duration (days) for every
+// channel the user belongs to that has a custom retention policy.
+func getChannelPolicies(client MattermostClient, userID string) (map[string]int64, error) {
+	policies := make(map[string]int64)
 	page := 0
 	perPage := 200
 	for {
-		users, err := client.GetGuestUsers(page, perPage)
+		items, err := client.GetChannelPoliciesForUser(userID, page, perPage)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-			return nil, ExitAPIError
+			return nil, err
 		}
-		allGuests = append(allGuests, users...)
-		if len(users) < perPage {
+		for _, p := range items {
+			policies[p.ChannelID] = p.PostDurationDays
+		}
+		if len(items) < perPage {
 			break
 		}
 		page++
 	}
+	return policies, nil
+}
 
-	if verbose {
-		fmt.Fprintf(os.Stderr, "Found %d guest user(s)\n", len(allGuests))
+// applyAllowlist marks the record as excepted if it matches an unexpired
+// allowlist entry.
+func applyAllowlist(record *GuestRecord, allowlist *Allowlist, now time.Time, verbose bool) {
+	entry := allowlist.Match(record.Username, record.Email)
+	if entry == nil {
+		return
 	}
+	if entry.ExpiredAt(now) {
+		if verbose {
+			logger.Guest(record.Username).Printf("Allowlist exception expired on %s — ignoring", entry.Expires)
+		}
+		return
+	}
+	record.Excepted = true
+	record.ExceptionJustification = entry.Justification
+	record.ExceptionExpires = entry.ExpiresAt()
+	record.ExceptionTicket = entry.Ticket
+}
 
-	// Process each guest
-	result := &AuditResult{
-		InactiveDays: inactiveDays,
+// IsInactive determines whether a guest should be flagged as inactive.
+// A guest is inactive if inactiveDays > 0 and their last login is more than
+// inactiveDays ago (or they have never logged in).
+func IsInactive(lastLogin *time.Time, inactiveDays int) bool {
+	if inactiveDays <= 0 {
+		return false
 	}
-	exitCode := ExitSuccess
+	if lastLogin == nil {
+		return true // never logged in
+	}
+	cutoff := time.Now().AddDate(0, 0, -inactiveDays)
+	return lastLogin.Before(cutoff)
+}
 
-	for _, u := range allGuests {
-		record, err := processGuest(client, u, filterTeamID, filterChannelID, inactiveDays, verbose)
-		if err != nil {
-			if verbose {
-				fmt.Fprintf(os.Stderr, "Warning: failed to process guest %q: %v\n", u.Username, err)
-			}
-			record = &GuestRecord{
-				Username:    u.Username,
-				DisplayName: BuildDisplayName(u.FirstName, u.LastName),
-				Email:       u.Email,
-				CreatedAt:   MillisToTime(u.CreateAt),
-				Active:      u.DeleteAt == 0,
-				Error:       err.Error(),
-			}
-			result.Summary.FailedLookups++
-			exitCode = ExitPartialFailure
-		}
+// IsInactiveAt is a testable version of IsInactive that accepts a reference time.
+func IsInactiveAt(lastLogin *time.Time, inactiveDays int, now time.Time) bool {
+	if inactiveDays <= 0 {
+		return false
+	}
+	if lastLogin == nil {
+		return true
+	}
+	cutoff := now.AddDate(0, 0, -inactiveDays)
+	return lastLogin.Before(cutoff)
+}
 
-		// Skip guests not in the filtered team (processGuest returns nil)
-		if record == nil {
-			continue
-		}
+// IsPurgeCandidate reports whether a guest deactivated at deactivatedAt was
+// deactivated more than days days before now. Active guests (nil) and days
+// <= 0 never qualify.
+func IsPurgeCandidate(deactivatedAt *time.Time, days int, now time.Time) bool {
+	if days <= 0 || deactivatedAt == nil {
+		return false
+	}
+	return deactivatedAt.Before(now.AddDate(0, 0, -days))
+}
 
-		result.Guests = append(result.Guests, *record)
+// AccountAgeDays returns the whole days between createdAt and now, or nil
+// if the creation date is unknown.
+func AccountAgeDays(createdAt *time.Time, now time.Time) *int {
+	if createdAt == nil {
+		return nil
 	}
+	days := int(now.Sub(*createdAt).Hours() / 24)
+	return &days
+}
 
-	// Calculate summary
-	for _, g := range result.Guests {
-		if g.Error != "" {
-			continue
-		}
-		if !g.Active {
-			result.Summary.DeactivatedGuests++
-		} else if g.Inactive {
-			result.Summary.InactiveGuests++
-		} else {
-			result.Summary.ActiveGuests++
-		}
+// DaysSinceActivity returns the whole days since the later of lastLogin and
+// lastPost, or nil if the guest has neither.
+func DaysSinceActivity(lastLogin, lastPost *time.Time, now time.Time) *int {
+	return AccountAgeDays(LastActivity(lastLogin, lastPost), now)
+}
+
+// OldEnough reports whether an account ageDays old passes --min-age-days.
+// An unknown age passes only when there is no minimum.
+func OldEnough(ageDays *int, minDays int) bool {
+	if minDays <= 0 {
+		return true
 	}
-	result.Summary.TotalGuests = len(result.Guests)
+	return ageDays != nil && *ageDays >= minDays
+}
 
-	return result, exitCode
+// IdleEnough reports whether a guest idle for idleDays passes
+// --min-idle-days. A guest who has never logged in or posted always passes.
+func IdleEnough(idleDays *int, minDays int) bool {
+	return minDays <= 0 || idleDays == nil || *idleDays >= minDays
 }
 
-// processGuest enriches a single guest user with team, channel, and activity data.
-func processGuest(client MattermostClient, u *model.User, filterTeamID string, filterChannelID string, inactiveDays int, verbose bool) (*GuestRecord, error) {
-	// Get teams for this user
-	teams, err := client.GetTeamsForUser(u.Id)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get teams: %w", err)
+// IsExpired reports whether an active account created at createdAt was
+// created more than maxAge days before now. Deactivated accounts, unknown
+// creation dates and maxAge <= 0 never qualify.
+func IsExpired(createdAt *time.Time, active bool, maxAge int, now time.Time) bool {
+	if maxAge <= 0 || createdAt == nil || !active {
+		return false
 	}
+	return createdAt.Before(now.AddDate(0, 0, -maxAge))
+}
 
-	// Filter teams if team scoping is active
-	var teamInfos []TeamInfo
-	for _, t := range teams {
-		if filterTeamID != "" && t.Id != filterTeamID {
-			continue
-		}
-		teamInfos = append(teamInfos, TeamInfo{
-			ID:          t.Id,
-			DisplayName: t.DisplayName,
-		})
+// PasswordUpdatedAt returns when u last set their password, or nil for an
+// account that does not sign in with email and has no password to age.
+func PasswordUpdatedAt(u *model.User) *time.Time {
+	if AuthMethodName(u.AuthService) != AuthMethodEmail {
+		return nil
 	}
+	return MillisToTime(u.LastPasswordUpdate)
+}
 
-	// If team filter is active and this guest is not in that team, skip
-	if filterTeamID != "" && len(teamInfos) == 0 {
-		return nil, nil
+// setPasswordAge fills in the password age of g and whether it is older
+// than maxAge days, from PasswordUpdatedAt.
+func setPasswordAge(g *GuestRecord, maxAge int, now time.Time) {
+	g.PasswordAgeDays = AccountAgeDays(g.PasswordUpdatedAt, now)
+	g.PasswordExpired = IsExpired(g.PasswordUpdatedAt, g.Active, maxAge, now)
+}
+
+// InactivityMetric selects which activity timestamps decide whether a guest is inactive.
+type InactivityMetric string
+
+const (
+	MetricLogin InactivityMetric = "login" // last login only
+	MetricPost  InactivityMetric = "post"  // last post only
+	MetricAny   InactivityMetric = "any"   // active if login, post (or view, with LastViewed) is recent
+	MetricAll   InactivityMetric = "all"   // active only if login, post (and view, with LastViewed) are all recent
+	MetricView  InactivityMetric = "view"  // last channel view only; needs LastViewed
+)
+
+// ParseInactivityMetric validates an --inactivity-metric value.
+func ParseInactivityMetric(s string) (InactivityMetric, error) {
+	switch m := InactivityMetric(s); m {
+	case MetricLogin, MetricPost, MetricAny, MetricAll, MetricView:
+		return m, nil
+	default:
+		return "", fmt.Errorf("error: invalid inactivity metric %q. Use login, post, any, all, or view", s)
 	}
+}
 
-	// Get channels per team
-	var channels []ChannelInfo
-	var teamIDs []string
-	for _, ti := range teamInfos {
-		teamIDs = append(teamIDs, ti.ID)
-		chs, err := client.GetChannelsForTeamForUser(ti.ID, u.Id)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get channels for team %q: %w", ti.DisplayName, err)
+// Reasons a guest is taken for an automated account, in GuestRecord.Bot.
+const (
+	BotAccount  = "account"  // a Mattermost bot account (User.IsBot)
+	BotUsername = "username" // a person's account named like a service account
+)
+
+// botUsername matches the usual names of service accounts: "bot", "svc",
+// "service", "automation", "integration" or "noreply" as a word of the
+// username, set off by a dot, dash or underscore or at either end, and
+// optionally numbered ("jira-bot", "svc.deploy", "ci_bot2"). A name that
+// merely contains the letters, such as "abbott", does not match.
+var botUsername = regexp.MustCompile(`(^|[._-])(bots?|svc|service|automation|integration|noreply|no-reply)([._-]|[0-9]*$)`)
+
+// BotSignal returns why u looks automated, or "" for an account that looks
+// like a person's.
+func BotSignal(u *model.User) string {
+	switch {
+	case u.IsBot:
+		return BotAccount
+	case botUsername.MatchString(strings.ToLower(u.Username)):
+		return BotUsername
+	}
+	return ""
+}
+
+// Auth methods reported in GuestRecord.AuthMethod. Apart from AuthMethodEmail
+// (password sign-in) they are Mattermost's User.AuthService values.
+const (
+	AuthMethodEmail     = "email"
+	AuthMethodLDAP      = "ldap"
+	AuthMethodSAML      = "saml"
+	AuthMethodGitLab    = "gitlab"
+	AuthMethodGoogle    = "google"
+	AuthMethodOffice365 = "office365"
+	AuthMethodOpenID    = "openid"
+)
+
+var authMethods = []string{AuthMethodEmail, AuthMethodLDAP, AuthMethodSAML, AuthMethodGitLab, AuthMethodGoogle, AuthMethodOffice365, AuthMethodOpenID}
+
+// AuthMethodName returns how a user signs in, given their User.AuthService.
+// An empty service means email and password.
+func AuthMethodName(authService string) string {
+	if authService == "" {
+		return AuthMethodEmail
+	}
+	return authService
+}
+
+// ParseAuthMethods validates a comma-separated --auth-method value. An empty
+// value yields nil (no filter).
+func ParseAuthMethods(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var methods []string
+	for _, m := range strings.Split(s, ",") {
+		m = strings.ToLower(strings.TrimSpace(m))
+		if !slices.Contains(authMethods, m) {
+			return nil, fmt.Errorf("error: invalid auth method %q. Use one or more of: %s", m, strings.Join(authMethods, ", "))
 		}
-		for _, ch := range chs {
-			if filterChannelID != "" && ch.Id != filterChannelID {
-				continue
-			}
-			channels = append(channels, ChannelInfo{
-				TeamName:    ti.DisplayName,
-				ChannelName: ch.DisplayName,
-			})
+		if !slices.Contains(methods, m) {
+			methods = append(methods, m)
 		}
 	}
+	return methods, nil
+}
 
-	// If channel filter is active and this guest has no matching channel, skip
-	if filterChannelID != "" && len(channels) == 0 {
+// ParseMatch compiles a --match regular expression. An empty value yields
+// nil (no filter).
+func ParseMatch(s string) (*regexp.Regexp, error) {
+	if s == "" {
 		return nil, nil
 	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid --match pattern: %v", err)
+	}
+	return re, nil
+}
 
-	// Get last post date
-	var lastPost *time.Time
-	if len(teamIDs) > 0 {
-		lastPost, err = client.GetLastPostDateForUser(u.Id, u.Username, teamIDs)
-		if err != nil {
-			if verbose {
-				fmt.Fprintf(os.Stderr, "Warning: could not retrieve last post date for %q: %v\n", u.Username, err)
-			}
-			// Non-fatal — continue without last post date
-		}
+// MatchesGuest reports whether re matches the guest's username, email or
+// display name. A nil re matches every guest.
+func MatchesGuest(re *regexp.Regexp, username, email, displayName string) bool {
+	if re == nil {
+		return true
 	}
+	return re.MatchString(username) || re.MatchString(email) || (displayName != "" && re.MatchString(displayName))
+}
 
-	lastLogin := MillisToTime(u.LastActivityAt)
-	active := u.DeleteAt == 0
-	inactive := IsInactive(lastLogin, inactiveDays)
+// ParseMemberDomains validates a comma-separated
+// --include-members-with-domain value. Domains are lower-cased, and a
+// leading "@" is dropped. An empty value yields nil.
+func ParseMemberDomains(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var domains []string
+	for _, d := range strings.Split(s, ",") {
+		d = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(d)), "@")
+		if d == "" || strings.ContainsAny(d, "@ ") || !strings.Contains(d, ".") || strings.HasPrefix(d, ".") || strings.HasSuffix(d, ".") {
+			return nil, fmt.Errorf("error: invalid domain %q in --include-members-with-domain. Use domain names such as partner.com", d)
+		}
+		if !slices.Contains(domains, d) {
+			domains = append(domains, d)
+		}
+	}
+	return domains, nil
+}
 
-	record := &GuestRecord{
-		Username:    u.Username,
-		DisplayName: BuildDisplayName(u.FirstName, u.LastName),
-		Email:       u.Email,
-		CreatedAt:   MillisToTime(u.CreateAt),
-		LastLogin:   lastLogin,
-		LastPost:    lastPost,
-		Teams:       teamInfos,
-		Channels:    channels,
-		Active:      active,
-		Inactive:    inactive,
+// EmailDomainMatches reports whether email is on one of domains or a
+// subdomain of one, so partner.com also matches eu.partner.com.
+func EmailDomainMatches(email string, domains []string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	host := strings.ToLower(email[at+1:])
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
 	}
+	return false
+}
 
-	return record, nil
+// ParseDateFlag parses a YYYY-MM-DD flag value as midnight UTC. An empty
+// value yields nil.
+func ParseDateFlag(name, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid %s date %q. Use YYYY-MM-DD", name, value)
+	}
+	return &t, nil
 }
 
-// IsInactive determines whether a guest should be flagged as inactive.
-// A guest is inactive if inactiveDays > 0 and their last login is more than
-// inactiveDays ago (or they have never logged in).
-func IsInactive(lastLogin *time.Time, inactiveDays int) bool {
-	if inactiveDays <= 0 {
+// CreatedInRange reports whether created falls in [after, before). A nil
+// bound is open; a guest with no creation date only matches when both are nil.
+func CreatedInRange(created, after, before *time.Time) bool {
+	if after == nil && before == nil {
+		return true
+	}
+	if created == nil {
 		return false
 	}
-	if lastLogin == nil {
-		return true // never logged in
+	if after != nil && created.Before(*after) {
+		return false
 	}
-	cutoff := time.Now().AddDate(0, 0, -inactiveDays)
-	return lastLogin.Before(cutoff)
+	if before != nil && !created.Before(*before) {
+		return false
+	}
+	return true
 }
 
-// IsInactiveAt is a testable version of IsInactive that accepts a reference time.
-func IsInactiveAt(lastLogin *time.Time, inactiveDays int, now time.Time) bool {
-	if inactiveDays <= 0 {
-		return false
+// ViewSignal says whether the last channel view was looked up for a guest,
+// and whether the lookup answered.
+type ViewSignal int
+
+const (
+	ViewOff     ViewSignal = iota // not looked up (no --last-viewed)
+	ViewKnown                     // looked up; a nil LastViewed means never viewed
+	ViewUnknown                   // looked up, but some team could not be read
+)
+
+// viewSignal returns the ViewSignal of a guest whose views were looked up
+// when lookedUp, with unknown from GuestRecord.ViewUnknown.
+func viewSignal(lookedUp, unknown bool) ViewSignal {
+	switch {
+	case !lookedUp:
+		return ViewOff
+	case unknown:
+		return ViewUnknown
+	default:
+		return ViewKnown
 	}
-	if lastLogin == nil {
-		return true
+}
+
+// IsInactiveByMetric applies the inactivity threshold to the signal(s) chosen
+// by metric. When views were looked up, any and all weigh the last view as
+// a third signal. An unknown view never counts as stale: under view and any
+// it leaves the guest undecided, so not inactive, and all decides on login
+// and post alone.
+func IsInactiveByMetric(metric InactivityMetric, lastLogin, lastPost, lastViewed *time.Time, view ViewSignal, inactiveDays int, now time.Time) bool {
+	viewStale := view == ViewKnown && IsInactiveAt(lastViewed, inactiveDays, now)
+	switch metric {
+	case MetricPost:
+		return IsInactiveAt(lastPost, inactiveDays, now)
+	case MetricView:
+		return viewStale
+	case MetricAny:
+		return IsInactiveAt(lastLogin, inactiveDays, now) && IsInactiveAt(lastPost, inactiveDays, now) &&
+			(view == ViewOff || viewStale)
+	case MetricAll:
+		return IsInactiveAt(lastLogin, inactiveDays, now) || IsInactiveAt(lastPost, inactiveDays, now) || viewStale
+	default:
+		return IsInactiveAt(lastLogin, inactiveDays, now)
 	}
-	cutoff := now.AddDate(0, 0, -inactiveDays)
-	return lastLogin.Before(cutoff)
 }
 
 // BuildDisplayName combines first and last name into a display name.
@@ -305,3 +2749,75 @@ func FormatTimeDisplay(t *time.Time) string {
 	}
 	return t.UTC().Format("2006-01-02 15:04")
 }
+
+// Named --date-format layouts.
+var dateFormatPresets = map[string]string{
+	"rfc3339":  time.RFC3339,
+	"date":     "2006-01-02",
+	"datetime": "2006-01-02 15:04",
+	"us":       "01/02/2006 15:04",
+	"eu":       "02/01/2006 15:04",
+}
+
+// TimeFormat controls how dates appear in table and CSV output. The zero
+// value renders UTC in the default layouts. CSV dates are always ISO 8601, so
+// only the timezone applies to them; the layout is for the table.
+type TimeFormat struct {
+	Location *time.Location // nil means UTC
+	Layout   string         // Go reference layout; empty means the default
+}
+
+// ParseTimeFormat builds a TimeFormat from --timezone (an IANA name such as
+// Europe/London) and --date-format (a preset name or a Go layout).
+func ParseTimeFormat(timezone, layout string) (TimeFormat, error) {
+	var f TimeFormat
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return f, fmt.Errorf("error: unknown timezone %q. Use an IANA name such as Europe/London", timezone)
+		}
+		f.Location = loc
+	}
+	if layout != "" {
+		if preset, ok := dateFormatPresets[strings.ToLower(layout)]; ok {
+			layout = preset
+		} else if time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC).Format(layout) == layout {
+			return f, fmt.Errorf("error: invalid date format %q. Use rfc3339, date, datetime, us, eu, or a Go layout such as \"02 Jan 2006 15:04\"", layout)
+		}
+		f.Layout = layout
+	}
+	return f, nil
+}
+
+func (f TimeFormat) in(t *time.Time) time.Time {
+	if f.Location == nil {
+		return t.UTC()
+	}
+	return t.In(f.Location)
+}
+
+// ISO formats t in ISO 8601 for CSV output, with the UTC offset outside UTC,
+// or returns an empty string if nil. The layout is ignored, as CSV consumers
+// parse these dates.
+func (f TimeFormat) ISO(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return f.in(t).Format(time.RFC3339)
+}
+
+// Display formats t for table output, or returns "Never" if nil. Outside UTC
+// the default layout includes the zone abbreviation.
+func (f TimeFormat) Display(t *time.Time) string {
+	if t == nil {
+		return "Never"
+	}
+	switch {
+	case f.Layout != "":
+		return f.in(t).Format(f.Layout)
+	case f.Location != nil:
+		return f.in(t).Format("2006-01-02 15:04 MST")
+	default:
+		return FormatTimeDisplay(t)
+	}
+}
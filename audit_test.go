@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,31 +19,140 @@ import (
 type mockClient struct {
 	guests           []*model.User
 	guestsErr        error
+	members          []*model.User            // listed for the system_user role
+	guestRoles       []string                 // roles passed to the last GetGuestUsers call
+	guestTeamID      string                   // team passed to the last GetGuestUsers call
+	guestPageFails   map[int]int              // page → transient failures before success
+	guestPageCalls   map[int]int              // page → GetGuestUsers calls
 	teams            map[string][]*model.Team // userID → teams
 	teamsErr         map[string]error
 	teamByName       map[string]*model.Team
 	teamByNameErr    map[string]error
+	allTeams         []*model.Team
+	allTeamsErr      error
 	channelByName    map[string]*model.Channel // teamID+channelName → channel
 	channelByNameErr map[string]error
 	channels         map[string][]*model.Channel // teamID+userID → channels
 	channelsErr      map[string]error
+	channelsFlaky    map[string]int   // "teamID:userID" → transient failures before success
+	channelCalls     int              // GetChannelsForTeamForUser calls
+	teamChannelsErr  map[string]error // teamID → GetTeamChannelMembers error
+	teamChannelCalls int
 	lastPostDate     map[string]*time.Time // userID → last post
 	lastPostDateErr  map[string]error
+	policyCount      int64
+	policyCountErr   error
+	channelPolicies  map[string][]*model.RetentionPolicyForChannel // userID → policies
+	userAudits       map[string][]model.Audit                      // userID → audit records
+	fileCounts       map[string]int                                // username → files uploaded
+	lastFileUpload   map[string]*time.Time                         // username → last upload
+	fileActivityErr  map[string]error
+	mentions         map[string][]*model.Post // username → posts mentioning them
+	mentionsErr      error
+	mentionCalls     int
+	channelPosts     map[string]map[string]int // channelID → userID → posts
+	channelPostsErr  map[string]error
+	channelPostCalls int
+	memberCalls      int              // GetChannelMemberIDs calls
+	membersErr       map[string]error // channelID → GetChannelMemberIDs error
+	userAuditsErr    error
+	userAuditCalls   int
+	sessions         map[string][]*model.Session // userID → sessions
+	sessionsErr      error
+	teamMembers      map[string][]*model.TeamMember   // userID → team memberships
+	channelMembers   map[string][]model.ChannelMember // "teamID:userID" → channel memberships
+	rolesErr         error
+	rolesCalls       int
+	cloud            bool
+	boardMembers     map[string]map[string][]string // teamID → userID → board titles
+	playbookMembers  map[string]map[string][]string // teamID → userID → playbook titles
+	playbooksErr     error
+	pluginCalls      int
+	profileFields    []ProfileField
+	profileFieldsErr error
+	profileAttrs     map[string]map[string]json.RawMessage // userID → field ID → value
+	profileAttrsErr  error
+	removed          []string         // "channelID:userID" of each RemoveUserFromChannel call
+	removeErr        map[string]error // channelID → RemoveUserFromChannel error
+	added            []string         // "channelID:userID" of each AddUserToChannel call
+	addErr           map[string]error // channelID → AddUserToChannel error
+	deleted          []string         // userID of each successful PermanentDeleteUser call
+	deleteCalls      int
+	deleteErr        map[string]error // userID → PermanentDeleteUser error
+	deactivated      []string         // userID of each successful DeactivateUser call
+	deactivateErr    map[string]error // userID → DeactivateUser error
+	reactivated      []string         // userID of each successful ReactivateUser call
+	reactivateErr    map[string]error // userID → ReactivateUser error
+	joinedTeams      []string         // "teamID:userID" of each AddUserToTeam call
+	joinTeamErr      map[string]error // teamID → AddUserToTeam error
+	leftTeams        []string         // "teamID:userID" of each RemoveUserFromTeam call
+	leaveTeamErr     map[string]error // teamID → RemoveUserFromTeam error
+	messages         []string         // "userID:message" of each SendDirectMessage call
+	messageErr       map[string]error // userID → SendDirectMessage error
+	serverInfo       ServerInfo
+
+	channelAdmins    map[string][]*model.User // channelID → admins
+	channelAdminsErr map[string]error         // channelID → GetChannelAdmins error
+	adminCalls       int
+
+	groups        map[string][]*model.Group // userID → groups
+	groupsErr     error
+	groupChannels map[string][]string // groupID → synced channel IDs
+	syncCalls     int                 // GetGroupChannelIDs calls
 }
 
-func (m *mockClient) GetGuestUsers(page, perPage int) ([]*model.User, error) {
+func (m *mockClient) GetGuestUsers(roles []string, teamID string, page, perPage int) ([]*model.User, error) {
+	if slices.Equal(roles, []string{model.SystemUserRoleId}) {
+		return pageOf(m.members, page, perPage), nil
+	}
+	m.guestRoles = roles
+	m.guestTeamID = teamID
+	if m.guestPageCalls == nil {
+		m.guestPageCalls = make(map[int]int)
+	}
+	m.guestPageCalls[page]++
 	if m.guestsErr != nil {
 		return nil, m.guestsErr
 	}
+	if m.guestPageFails[page] > 0 {
+		m.guestPageFails[page]--
+		return nil, &APIError{StatusCode: 503, Message: "error: the Mattermost server returned an unexpected error (HTTP 503). Check server logs for details"}
+	}
+	return pageOf(m.guestsInTeam(teamID), page, perPage), nil
+}
+
+func pageOf(users []*model.User, page, perPage int) []*model.User {
 	start := page * perPage
-	if start >= len(m.guests) {
-		return []*model.User{}, nil
+	if start >= len(users) {
+		return []*model.User{}
 	}
 	end := start + perPage
-	if end > len(m.guests) {
-		end = len(m.guests)
+	if end > len(users) {
+		end = len(users)
+	}
+	return users[start:end]
+}
+
+func (m *mockClient) GetGuestCount(roles []string, teamID string) (int64, error) {
+	return int64(len(m.guestsInTeam(teamID))), nil
+}
+
+// guestsInTeam returns the guests that are members of teamID, or all guests
+// when teamID is empty.
+func (m *mockClient) guestsInTeam(teamID string) []*model.User {
+	if teamID == "" {
+		return m.guests
+	}
+	var guests []*model.User
+	for _, u := range m.guests {
+		for _, t := range m.teams[u.Id] {
+			if t.Id == teamID {
+				guests = append(guests, u)
+				break
+			}
+		}
 	}
-	return m.guests[start:end], nil
+	return guests
 }
 
 func (m *mockClient) GetTeamByName(name string) (*model.Team, error) {
@@ -49,7 +164,14 @@ func (m *mockClient) GetTeamByName(name string) (*model.Team, error) {
 	if t, ok := m.teamByName[name]; ok {
 		return t, nil
 	}
-	return nil, fmt.Errorf("error: team %q not found. Please check the name and try again", name)
+	return nil, &APIError{StatusCode: 404, Message: fmt.Sprintf("error: team %q not found. Please check the name and try again", name)}
+}
+
+func (m *mockClient) GetAllTeams() ([]*model.Team, error) {
+	if m.allTeamsErr != nil {
+		return nil, m.allTeamsErr
+	}
+	return m.allTeams, nil
 }
 
 func (m *mockClient) GetChannelByName(teamID, channelName string) (*model.Channel, error) {
@@ -62,7 +184,147 @@ func (m *mockClient) GetChannelByName(teamID, channelName string) (*model.Channe
 	if ch, ok := m.channelByName[key]; ok {
 		return ch, nil
 	}
-	return nil, fmt.Errorf("error: channel %q not found. Please check the name and try again", channelName)
+	return nil, &APIError{StatusCode: 404, Message: fmt.Sprintf("error: channel %q not found. Please check the name and try again", channelName)}
+}
+
+// GetTeamChannels lists the team's channels from channels, in ID order.
+func (m *mockClient) GetTeamChannels(teamID string) ([]*model.Channel, error) {
+	if err, ok := m.teamChannelsErr[teamID]; ok {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var list []*model.Channel
+	for key, chs := range m.channels {
+		team, _, _ := strings.Cut(key, ":")
+		if team != teamID {
+			continue
+		}
+		for _, ch := range chs {
+			if ch.Type != model.ChannelTypeDirect && ch.Type != model.ChannelTypeGroup && !seen[ch.Id] {
+				seen[ch.Id] = true
+				list = append(list, ch)
+			}
+		}
+	}
+	slices.SortFunc(list, func(a, b *model.Channel) int { return strings.Compare(a.Id, b.Id) })
+	return list, nil
+}
+
+// GetChannelMemberIDs lists the users whose channels include channelID.
+func (m *mockClient) GetChannelAdmins(channelID string) ([]*model.User, error) {
+	m.adminCalls++
+	if err, ok := m.channelAdminsErr[channelID]; ok {
+		return nil, err
+	}
+	return m.channelAdmins[channelID], nil
+}
+
+func (m *mockClient) GetGroupsForUser(userID string) ([]*model.Group, error) {
+	if m.groupsErr != nil {
+		return nil, m.groupsErr
+	}
+	return m.groups[userID], nil
+}
+
+func (m *mockClient) GetGroupChannelIDs(groupID string) ([]string, error) {
+	m.syncCalls++
+	return m.groupChannels[groupID], nil
+}
+
+func (m *mockClient) GetChannelMemberIDs(channelID string) ([]string, error) {
+	m.memberCalls++
+	if err, ok := m.membersErr[channelID]; ok {
+		return nil, err
+	}
+	var ids []string
+	for key, chs := range m.channels {
+		_, userID, _ := strings.Cut(key, ":")
+		if slices.ContainsFunc(chs, func(ch *model.Channel) bool { return ch.Id == channelID }) && !slices.Contains(ids, userID) {
+			ids = append(ids, userID)
+		}
+	}
+	return ids, nil
+}
+
+func (m *mockClient) RemoveUserFromChannel(channelID, userID string) error {
+	if err, ok := m.removeErr[channelID]; ok {
+		return err
+	}
+	m.removed = append(m.removed, channelID+":"+userID)
+	return nil
+}
+
+func (m *mockClient) PermanentDeleteUser(userID string) error {
+	m.deleteCalls++
+	if err, ok := m.deleteErr[userID]; ok {
+		return err
+	}
+	m.deleted = append(m.deleted, userID)
+	return nil
+}
+
+func (m *mockClient) DeactivateUser(userID string) error {
+	if err, ok := m.deactivateErr[userID]; ok {
+		return err
+	}
+	m.deactivated = append(m.deactivated, userID)
+	return nil
+}
+
+func (m *mockClient) ReactivateUser(userID string) error {
+	if err, ok := m.reactivateErr[userID]; ok {
+		return err
+	}
+	m.reactivated = append(m.reactivated, userID)
+	return nil
+}
+
+func (m *mockClient) GetTeamMembersForUser(userID string) ([]*model.TeamMember, error) {
+	m.rolesCalls++
+	if m.rolesErr != nil {
+		return nil, m.rolesErr
+	}
+	return m.teamMembers[userID], nil
+}
+
+func (m *mockClient) GetChannelMembersForUser(userID, teamID string) ([]model.ChannelMember, error) {
+	m.rolesCalls++
+	if m.rolesErr != nil {
+		return nil, m.rolesErr
+	}
+	return m.channelMembers[teamID+":"+userID], nil
+}
+
+func (m *mockClient) AddUserToChannel(channelID, userID string) error {
+	if err, ok := m.addErr[channelID]; ok {
+		return err
+	}
+	m.added = append(m.added, channelID+":"+userID)
+	return nil
+}
+
+func (m *mockClient) AddUserToTeam(teamID, userID string) error {
+	if err, ok := m.joinTeamErr[teamID]; ok {
+		return err
+	}
+	m.joinedTeams = append(m.joinedTeams, teamID+":"+userID)
+	return nil
+}
+
+func (m *mockClient) RemoveUserFromTeam(teamID, userID string) error {
+	if err, ok := m.leaveTeamErr[teamID]; ok {
+		return err
+	}
+	m.leftTeams = append(m.leftTeams, teamID+":"+userID)
+	return nil
+}
+
+func (m *mockClient) SendDirectMessage(userID, message string) error {
+	if err, ok := m.messageErr[userID]; ok {
+		return err
+	}
+	m.messages = append(m.messages, userID+":"+message)
+	return nil
 }
 
 func (m *mockClient) GetTeamsForUser(userID string) ([]*model.Team, error) {
@@ -75,7 +337,12 @@ func (m *mockClient) GetTeamsForUser(userID string) ([]*model.Team, error) {
 }
 
 func (m *mockClient) GetChannelsForTeamForUser(teamID, userID string) ([]*model.Channel, error) {
+	m.channelCalls++
 	key := teamID + ":" + userID
+	if m.channelsFlaky[key] > 0 {
+		m.channelsFlaky[key]--
+		return nil, &APIError{StatusCode: 502, Message: "bad gateway"}
+	}
 	if m.channelsErr != nil {
 		if err, ok := m.channelsErr[key]; ok {
 			return nil, err
@@ -84,6 +351,28 @@ func (m *mockClient) GetChannelsForTeamForUser(teamID, userID string) ([]*model.
 	return m.channels[key], nil
 }
 
+// GetTeamChannelMembers builds the team's membership from the per-user
+// channels fixture, leaving out DMs and group messages like the real client.
+func (m *mockClient) GetTeamChannelMembers(teamID string) (map[string][]*model.Channel, error) {
+	m.teamChannelCalls++
+	if err, ok := m.teamChannelsErr[teamID]; ok {
+		return nil, err
+	}
+	members := make(map[string][]*model.Channel)
+	for key, chs := range m.channels {
+		team, userID, _ := strings.Cut(key, ":")
+		if team != teamID {
+			continue
+		}
+		for _, ch := range chs {
+			if ch.Type != model.ChannelTypeDirect && ch.Type != model.ChannelTypeGroup {
+				members[userID] = append(members[userID], ch)
+			}
+		}
+	}
+	return members, nil
+}
+
 func (m *mockClient) GetLastPostDateForUser(userID, username string, teamIDs []string) (*time.Time, error) {
 	if m.lastPostDateErr != nil {
 		if err, ok := m.lastPostDateErr[userID]; ok {
@@ -93,6 +382,118 @@ func (m *mockClient) GetLastPostDateForUser(userID, username string, teamIDs []s
 	return m.lastPostDate[userID], nil
 }
 
+func (m *mockClient) GetFileActivityForUser(username string, teamIDs []string) (int, *time.Time, error) {
+	if err, ok := m.fileActivityErr[username]; ok {
+		return 0, nil, err
+	}
+	return m.fileCounts[username], m.lastFileUpload[username], nil
+}
+
+func (m *mockClient) GetMentionsOfUser(userID, username string, teamIDs []string, since time.Time) ([]*model.Post, error) {
+	m.mentionCalls++
+	if m.mentionsErr != nil {
+		return nil, m.mentionsErr
+	}
+	var out []*model.Post
+	for _, p := range m.mentions[username] {
+		if p.UserId != userID && p.CreateAt >= since.UnixMilli() {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockClient) GetPostCountsForChannel(channelID string, since *time.Time) (map[string]int, error) {
+	m.channelPostCalls++
+	if err, ok := m.channelPostsErr[channelID]; ok {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for userID, n := range m.channelPosts[channelID] {
+		counts[userID] = n
+	}
+	return counts, nil
+}
+
+func (m *mockClient) GetBoardMembers(teamID string) (map[string][]string, error) {
+	m.pluginCalls++
+	return m.boardMembers[teamID], nil
+}
+
+func (m *mockClient) GetPlaybookMembers(teamID string) (map[string][]string, error) {
+	m.pluginCalls++
+	if m.playbooksErr != nil {
+		return nil, m.playbooksErr
+	}
+	return m.playbookMembers[teamID], nil
+}
+
+func (m *mockClient) GetProfileFields() ([]ProfileField, error) {
+	if m.profileFieldsErr != nil {
+		return nil, m.profileFieldsErr
+	}
+	return m.profileFields, nil
+}
+
+func (m *mockClient) GetProfileAttributes(userID string) (map[string]json.RawMessage, error) {
+	if m.profileAttrsErr != nil {
+		return nil, m.profileAttrsErr
+	}
+	return m.profileAttrs[userID], nil
+}
+
+func (m *mockClient) ServerInfo() ServerInfo {
+	return m.serverInfo
+}
+
+func (m *mockClient) IsCloud() bool {
+	return m.cloud
+}
+
+func (m *mockClient) GetDataRetentionPoliciesCount() (int64, error) {
+	if m.policyCountErr != nil {
+		return 0, m.policyCountErr
+	}
+	return m.policyCount, nil
+}
+
+func (m *mockClient) GetChannelPoliciesForUser(userID string, page, perPage int) ([]*model.RetentionPolicyForChannel, error) {
+	policies := m.channelPolicies[userID]
+	start := page * perPage
+	if start >= len(policies) {
+		return []*model.RetentionPolicyForChannel{}, nil
+	}
+	end := start + perPage
+	if end > len(policies) {
+		end = len(policies)
+	}
+	return policies[start:end], nil
+}
+
+func (m *mockClient) GetSessions(userID string) ([]*model.Session, error) {
+	if m.sessionsErr != nil {
+		return nil, m.sessionsErr
+	}
+	return m.sessions[userID], nil
+}
+
+func (m *mockClient) GetUserAudits(userID string, page, perPage int) ([]model.Audit, error) {
+	m.userAuditCalls++
+	if m.userAuditsErr != nil {
+		return nil, m.userAuditsErr
+	}
+	audits := m.userAudits[userID]
+	start := page * perPage
+	if start >= len(audits) {
+		return []model.Audit{}, nil
+	}
+	end := start + perPage
+	if end > len(audits) {
+		end = len(audits)
+	}
+	return audits[start:end], nil
+}
+
 // --- Tests ---
 
 func TestIsInactive(t *testing.T) {
@@ -125,406 +526,2558 @@ func TestIsInactive(t *testing.T) {
 	}
 }
 
-func TestBuildDisplayName(t *testing.T) {
+func TestIsInactiveByMetric(t *testing.T) {
+	now := time.Now()
+	recent := timePtr(now.AddDate(0, 0, -5))
+	stale := timePtr(now.AddDate(0, 0, -45))
+
 	tests := []struct {
-		name      string
-		firstName string
-		lastName  string
-		expected  string
+		name           string
+		metric         InactivityMetric
+		lastLogin      *time.Time
+		lastPost       *time.Time
+		lastViewed     *time.Time
+		view           ViewSignal
+		expectInactive bool
 	}{
-		{"both names", "Jane", "Doe", "Jane Doe"},
-		{"first only", "Jane", "", "Jane"},
-		{"last only", "", "Doe", "Doe"},
-		{"neither", "", "", ""},
+		{"login: recent login, no posts", MetricLogin, recent, nil, nil, ViewOff, false},
+		{"login: stale login, recent post", MetricLogin, stale, recent, nil, ViewOff, true},
+		{"post: recent login, no posts", MetricPost, recent, nil, nil, ViewOff, true},
+		{"post: stale login, recent post", MetricPost, stale, recent, nil, ViewOff, false},
+		{"any: recent login only", MetricAny, recent, stale, nil, ViewOff, false},
+		{"any: recent post only", MetricAny, stale, recent, nil, ViewOff, false},
+		{"any: both stale", MetricAny, stale, nil, nil, ViewOff, true},
+		{"any: both stale, recent view", MetricAny, stale, nil, recent, ViewKnown, false},
+		{"any: all three stale", MetricAny, stale, nil, stale, ViewKnown, true},
+		{"any: both stale, view unknown", MetricAny, stale, nil, nil, ViewUnknown, false},
+		{"all: both recent", MetricAll, recent, recent, nil, ViewOff, false},
+		{"all: post stale", MetricAll, recent, stale, nil, ViewOff, true},
+		{"all: never posted", MetricAll, recent, nil, nil, ViewOff, true},
+		{"all: both recent, view stale", MetricAll, recent, recent, stale, ViewKnown, true},
+		{"all: both recent, view unknown", MetricAll, recent, recent, nil, ViewUnknown, false},
+		{"all: post stale, view unknown", MetricAll, recent, stale, nil, ViewUnknown, true},
+		{"login: recent view ignored", MetricLogin, stale, nil, recent, ViewKnown, true},
+		{"view: recent view, no posts", MetricView, stale, nil, recent, ViewKnown, false},
+		{"view: stale view, recent login", MetricView, recent, nil, stale, ViewKnown, true},
+		{"view: never viewed", MetricView, recent, recent, nil, ViewKnown, true},
+		{"view: unknown", MetricView, stale, nil, nil, ViewUnknown, false},
+		{"view: not looked up", MetricView, stale, nil, nil, ViewOff, false},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := BuildDisplayName(tt.firstName, tt.lastName)
-			if result != tt.expected {
-				t.Errorf("BuildDisplayName(%q, %q) = %q, want %q",
-					tt.firstName, tt.lastName, result, tt.expected)
+			result := IsInactiveByMetric(tt.metric, tt.lastLogin, tt.lastPost, tt.lastViewed, tt.view, 30, now)
+			if result != tt.expectInactive {
+				t.Errorf("IsInactiveByMetric(%s) = %v, want %v", tt.metric, result, tt.expectInactive)
 			}
 		})
 	}
 }
 
-func TestMillisToTime(t *testing.T) {
-	tests := []struct {
-		name     string
-		millis   int64
-		expected *time.Time
-	}{
-		{"zero returns nil", 0, nil},
-		{"valid timestamp", 1700000000000, timePtr(time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC))},
+func TestParseInactivityMetric(t *testing.T) {
+	for _, valid := range []string{"login", "post", "any", "all", "view"} {
+		if _, err := ParseInactivityMetric(valid); err != nil {
+			t.Errorf("ParseInactivityMetric(%q) returned error: %v", valid, err)
+		}
 	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := MillisToTime(tt.millis)
-			if tt.expected == nil {
-				if result != nil {
-					t.Errorf("MillisToTime(%d) = %v, want nil", tt.millis, result)
-				}
-				return
-			}
-			if result == nil {
-				t.Fatalf("MillisToTime(%d) = nil, want %v", tt.millis, tt.expected)
-			}
-			if !result.Equal(*tt.expected) {
-				t.Errorf("MillisToTime(%d) = %v, want %v", tt.millis, result, tt.expected)
-			}
-		})
+	if _, err := ParseInactivityMetric("sessions"); err == nil {
+		t.Error("expected error for invalid metric")
 	}
 }
 
-func TestFormatTimeISO(t *testing.T) {
-	tests := []struct {
-		name     string
-		input    *time.Time
-		expected string
-	}{
-		{"nil returns empty", nil, ""},
-		{"valid time", timePtr(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)), "2024-03-01T10:00:00Z"},
+func TestExtractIdentityHistory(t *testing.T) {
+	audits := []model.Audit{
+		{Action: "/api/v4/users/login", ExtraInfo: "attempt - login_id=jane.doe"},
+		{Action: "/api/v4/users/login", ExtraInfo: "attempt - login_id=jdoe"},
+		{Action: "/api/v4/users/login", ExtraInfo: "attempt - login_id=jane@old-partner.com"},
+		{Action: "/api/v4/users/login", ExtraInfo: "attempt - login_id=JDOE"},
+		{Action: "/api/v4/users/patch", ExtraInfo: "old_email=Jane.Doe@External.com"},
+		{Action: "/api/v4/users/logout", ExtraInfo: "session_id=abc123"},
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := FormatTimeISO(tt.input)
-			if result != tt.expected {
-				t.Errorf("FormatTimeISO(%v) = %q, want %q", tt.input, result, tt.expected)
-			}
-		})
+	usernames, emails := ExtractIdentityHistory(audits, "jane.doe", "jane.doe@external.com")
+
+	if strings.Join(usernames, ",") != "jdoe" {
+		t.Errorf("usernames = %v, want [jdoe]", usernames)
+	}
+	if strings.Join(emails, ",") != "jane@old-partner.com" {
+		t.Errorf("emails = %v, want [jane@old-partner.com]", emails)
 	}
 }
 
-func TestRunAudit_BasicScenario(t *testing.T) {
-	now := time.Now()
-	loginTime := now.AddDate(0, 0, -5)
-
+func TestRunAudit_IdentityHistory(t *testing.T) {
 	client := &mockClient{
 		guests: []*model.User{
-			{Id: "user1", Username: "jane.doe", FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
-			{Id: "user2", Username: "bob.smith", FirstName: "Bob", LastName: "Smith", Email: "bob@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
-			{Id: "user3", Username: "alice.jones", FirstName: "Alice", LastName: "Jones", Email: "alice@example.com", CreateAt: 1709280000000, DeleteAt: 1710000000000},
-		},
-		teams: map[string][]*model.Team{
-			"user1": {{Id: "team1", DisplayName: "Engineering"}},
-			"user2": {{Id: "team1", DisplayName: "Engineering"}, {Id: "team2", DisplayName: "Sales"}},
-			"user3": {{Id: "team1", DisplayName: "Engineering"}},
-		},
-		channels: map[string][]*model.Channel{
-			"team1:user1": {{Id: "ch1", DisplayName: "General"}},
-			"team1:user2": {{Id: "ch1", DisplayName: "General"}, {Id: "ch2", DisplayName: "Dev Backend"}},
-			"team2:user2": {{Id: "ch3", DisplayName: "Partner Updates"}},
-			"team1:user3": {{Id: "ch1", DisplayName: "General"}},
+			{Id: "user1", Username: "jane.doe", Nickname: "JD (Acme)", Email: "jane.doe@external.com", CreateAt: 1709280000000},
 		},
-		lastPostDate: map[string]*time.Time{
-			"user1": timePtr(now.AddDate(0, 0, -2)),
-			"user2": timePtr(now.AddDate(0, 0, -10)),
+		userAudits: map[string][]model.Audit{
+			"user1": {{ExtraInfo: "attempt - login_id=jdoe"}},
 		},
 	}
 
-	result, exitCode := RunAudit(client, "", "", 0, false)
-
-	if exitCode != ExitSuccess {
-		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
-	}
-	if result == nil {
-		t.Fatal("result is nil")
+	result, _ := RunAudit(client, AuditOptions{})
+	if result.Guests[0].Nickname != "JD (Acme)" {
+		t.Errorf("nickname = %q, want 'JD (Acme)'", result.Guests[0].Nickname)
 	}
-	if len(result.Guests) != 3 {
-		t.Fatalf("expected 3 guests, got %d", len(result.Guests))
+	if len(result.Guests[0].PreviousUsernames) != 0 {
+		t.Error("identity history should not be collected unless enabled")
 	}
 
-	// Verify first guest
-	g := result.Guests[0]
-	if g.Username != "jane.doe" {
-		t.Errorf("expected username jane.doe, got %s", g.Username)
+	result, _ = RunAudit(client, AuditOptions{IdentityHistory: true})
+	if strings.Join(result.Guests[0].PreviousUsernames, ",") != "jdoe" {
+		t.Errorf("previous usernames = %v, want [jdoe]", result.Guests[0].PreviousUsernames)
 	}
-	if g.DisplayName != "Jane Doe" {
-		t.Errorf("expected display name 'Jane Doe', got %q", g.DisplayName)
+}
+
+func TestRunAudit_AuditLog(t *testing.T) {
+	login := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	client := &mockClient{
+		guests: sampleGuests(2),
+		teams:  map[string][]*model.Team{"user0": {{Id: "team1", DisplayName: "Engineering"}}},
+		channels: map[string][]*model.Channel{
+			"team1:user0": {{Id: "ch1", DisplayName: "General", Type: model.ChannelTypeOpen}, {Id: "ch2", DisplayName: "Partners", Type: model.ChannelTypePrivate}},
+		},
+		userAudits: map[string][]model.Audit{
+			"user0": {
+				{Action: "/api/v4/channels/ch2/members", ExtraInfo: "name=partners user_id=user0", CreateAt: login.Add(-time.Hour).UnixMilli()},
+				{Action: "/api/v4/users/login", ExtraInfo: "success", CreateAt: login.UnixMilli()},
+				{Action: "/api/v4/users/login", ExtraInfo: "attempt - login_id=jdoe", CreateAt: login.Add(time.Hour).UnixMilli()},
+			},
+		},
 	}
-	if len(g.Teams) != 1 {
-		t.Errorf("expected 1 team, got %d", len(g.Teams))
+
+	result, _ := RunAudit(client, AuditOptions{AuditLog: true, IdentityHistory: true})
+	if g := result.Guests[0]; g.LastAuditedAction != "login" || g.LastAuditedAt == nil || !g.LastAuditedAt.Equal(login) {
+		t.Errorf("guest0: got %q at %v", g.LastAuditedAction, g.LastAuditedAt)
 	}
-	if len(g.Channels) != 1 {
-		t.Errorf("expected 1 channel, got %d", len(g.Channels))
+	// Only the channel the guest joined themselves has a join time
+	if chs := result.Guests[0].Channels; len(chs) != 2 || chs[0].JoinedAt != nil || chs[1].JoinedAt == nil || !chs[1].JoinedAt.Equal(login.Add(-time.Hour)) {
+		t.Errorf("guest0 channels: %+v", chs)
 	}
-	if !g.Active {
-		t.Error("expected guest to be active")
+	if g := result.Guests[1]; g.LastAuditedAction != "" || g.LastAuditedAt != nil {
+		t.Errorf("guest1 has no audit records: got %q at %v", g.LastAuditedAction, g.LastAuditedAt)
 	}
-
-	// Verify deactivated guest
-	g3 := result.Guests[2]
-	if g3.Active {
-		t.Error("expected guest to be deactivated")
+	// The records read for identity history are used again
+	if client.userAuditCalls != 2 {
+		t.Errorf("expected 1 audit call per guest, got %d", client.userAuditCalls)
 	}
 
-	// Verify summary
-	if result.Summary.TotalGuests != 3 {
-		t.Errorf("expected total 3, got %d", result.Summary.TotalGuests)
-	}
-	if result.Summary.ActiveGuests != 2 {
-		t.Errorf("expected 2 active, got %d", result.Summary.ActiveGuests)
+	// Without the Enterprise audit log the guests are reported without it
+	client = &mockClient{guests: sampleGuests(2), userAuditsErr: &APIError{StatusCode: 501, Message: "not licensed"}}
+	result, exitCode := RunAudit(client, AuditOptions{AuditLog: true})
+	if exitCode != ExitSuccess || result.Summary.FailedLookups != 0 {
+		t.Errorf("exit code = %d, failed lookups = %d", exitCode, result.Summary.FailedLookups)
 	}
-	if result.Summary.DeactivatedGuests != 1 {
-		t.Errorf("expected 1 deactivated, got %d", result.Summary.DeactivatedGuests)
+	if fmt.Sprint(result.UnavailableEnrichment) != "[audit_log]" || client.userAuditCalls != 1 {
+		t.Errorf("unavailable = %v after %d call(s)", result.UnavailableEnrichment, client.userAuditCalls)
 	}
 }
 
-func TestRunAudit_TeamFilter(t *testing.T) {
-	now := time.Now()
-	loginTime := now.AddDate(0, 0, -5)
-
+func TestRunAudit_BulkChannels(t *testing.T) {
 	client := &mockClient{
-		guests: []*model.User{
-			{Id: "user1", Username: "jane.doe", FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
-			{Id: "user2", Username: "bob.smith", FirstName: "Bob", LastName: "Smith", Email: "bob@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
-		},
-		teamByName: map[string]*model.Team{
-			"Sales": {Id: "team2", DisplayName: "Sales"},
-		},
+		guests: sampleGuests(3),
 		teams: map[string][]*model.Team{
+			"user0": {{Id: "team1", DisplayName: "Engineering"}},
 			"user1": {{Id: "team1", DisplayName: "Engineering"}},
 			"user2": {{Id: "team1", DisplayName: "Engineering"}, {Id: "team2", DisplayName: "Sales"}},
 		},
 		channels: map[string][]*model.Channel{
-			"team2:user2": {{Id: "ch3", DisplayName: "Partner Updates"}},
-		},
-		lastPostDate: map[string]*time.Time{
-			"user2": timePtr(now.AddDate(0, 0, -3)),
+			"team1:user0": {{Id: "ch1", DisplayName: "General", Type: model.ChannelTypeOpen}, {Id: "dm1", DisplayName: "dm", Type: model.ChannelTypeDirect}},
+			"team1:user1": {{Id: "ch1", DisplayName: "General", Type: model.ChannelTypeOpen}},
+			"team1:user2": {{Id: "ch2", DisplayName: "Partners", Type: model.ChannelTypePrivate}},
+			"team2:user2": {{Id: "ch3", DisplayName: "Deals", Type: model.ChannelTypeOpen}},
 		},
+		teamChannelsErr: map[string]error{"team2": &APIError{StatusCode: 403, Message: "forbidden"}},
 	}
 
-	result, exitCode := RunAudit(client, "Sales", "", 0, false)
-
+	result, exitCode := RunAudit(client, AuditOptions{BulkChannels: true})
 	if exitCode != ExitSuccess {
 		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
 	}
+	// Each team is loaded once; team2 could not be, so guest2 is looked up there directly
+	if client.teamChannelCalls != 2 || client.channelCalls != 1 {
+		t.Errorf("team loads = %d, per-guest calls = %d; want 2 and 1", client.teamChannelCalls, client.channelCalls)
+	}
+	want := []string{"Engineering/General", "Engineering/General", "Engineering/Partners|Sales/Deals"}
+	for i, g := range result.Guests {
+		if got := formatChannelNamesCSV(g.Channels); got != want[i] {
+			t.Errorf("%s channels = %q, want %q", g.Username, got, want[i])
+		}
+	}
+	if result.Guests[2].PrivateChannels != 1 {
+		t.Errorf("guest2 private channels = %d, want 1", result.Guests[2].PrivateChannels)
+	}
+}
+
+func TestRunAudit_ArchivedChannels(t *testing.T) {
+	dm := &model.Channel{Id: "dm1", DisplayName: "guest0, jane", Type: model.ChannelTypeDirect}
+	client := &mockClient{
+		guests: sampleGuests(1),
+		teams:  map[string][]*model.Team{"user0": {{Id: "team1", DisplayName: "Sales"}, {Id: "team2", DisplayName: "Engineering"}}},
+		channels: map[string][]*model.Channel{
+			"team1:user0": {
+				{Id: "ch1", DisplayName: "Partners", Type: model.ChannelTypePrivate},
+				dm,
+				{Id: "ch2", DisplayName: "Old Deals", Type: model.ChannelTypePrivate, DeleteAt: 1},
+				{Id: "ch3", DisplayName: "Deals", Type: model.ChannelTypeOpen},
+			},
+			// The server lists DMs with every team
+			"team2:user0": {dm, {Id: "ch4", DisplayName: "General", Type: model.ChannelTypeOpen}},
+		},
+	}
+
+	result, _ := RunAudit(client, AuditOptions{})
+	g := result.Guests[0]
+	if got, want := formatChannelNamesCSV(g.Channels), "Engineering/General|Sales/Deals|Sales/Partners|Sales/guest0, jane"; got != want {
+		t.Errorf("channels = %q, want %q", got, want)
+	}
+	if g.PrivateChannels != 1 {
+		t.Errorf("private channels = %d, want 1", g.PrivateChannels)
+	}
+
+	result, _ = RunAudit(client, AuditOptions{IncludeArchived: true})
+	g = result.Guests[0]
+	if got, want := formatChannelNamesCSV(g.Channels), "Engineering/General|Sales/Deals|Sales/Old Deals [archived]|Sales/Partners|Sales/guest0, jane"; got != want {
+		t.Errorf("with IncludeArchived: channels = %q, want %q", got, want)
+	}
+	if !g.Channels[2].Archived || g.Channels[1].Archived {
+		t.Errorf("archived = %v, %v; want false, true", g.Channels[1].Archived, g.Channels[2].Archived)
+	}
+	if got := formatChannelNamesTable(g.Channels[2:]); got != "Old Deals [archived], Partners (+1 more)" {
+		t.Errorf("table channels = %q", got)
+	}
+}
+
+func TestRunAudit_ArchivedTeams(t *testing.T) {
+	client := &mockClient{
+		guests: sampleGuests(2),
+		teams: map[string][]*model.Team{
+			"user0": {{Id: "team1", DisplayName: "Sales"}, {Id: "team2", DisplayName: "Old Project", DeleteAt: 1}},
+			"user1": {{Id: "team2", DisplayName: "Old Project", DeleteAt: 1}},
+		},
+		channels: map[string][]*model.Channel{
+			"team1:user0": {{Id: "ch1", DisplayName: "Deals", Type: model.ChannelTypeOpen}},
+			"team2:user0": {{Id: "ch2", DisplayName: "Kickoff", Type: model.ChannelTypeOpen}},
+			"team2:user1": {{Id: "ch2", DisplayName: "Kickoff", Type: model.ChannelTypeOpen}},
+		},
+	}
+
+	result, _ := RunAudit(client, AuditOptions{})
+	if got := formatTeamNamesCSV(result.Guests[0].Teams); got != "Sales" {
+		t.Errorf("teams = %q, want Sales", got)
+	}
+	if result.Guests[0].Orphaned || !result.Guests[1].Orphaned {
+		t.Errorf("orphaned = %v, %v; want false, true", result.Guests[0].Orphaned, result.Guests[1].Orphaned)
+	}
+
+	// Listed, but its channels stay out of the report
+	result, _ = RunAudit(client, AuditOptions{IncludeArchivedTeams: true})
+	g := result.Guests[0]
+	if got := formatTeamNamesCSV(g.Teams); got != "Sales|Old Project [archived]" {
+		t.Errorf("with IncludeArchivedTeams: teams = %q", got)
+	}
+	if got := formatChannelNamesCSV(g.Channels); got != "Sales/Deals" {
+		t.Errorf("channels = %q, want Sales/Deals", got)
+	}
+	if !result.Guests[1].Orphaned || len(result.Guests[1].Teams) != 1 {
+		t.Errorf("guest left only in an archived team: %+v", result.Guests[1])
+	}
+	if jg := toJSONGuest(g, nil); len(jg.ArchivedTeams) != 1 || jg.ArchivedTeams[0] != "Old Project" {
+		t.Errorf("archived_teams = %v", jg.ArchivedTeams)
+	}
+}
+
+func TestRunAudit_ReusesUnchangedGuests(t *testing.T) {
+	guests := sampleGuests(3)
+	client := &mockClient{
+		guests: guests,
+		teams: map[string][]*model.Team{
+			"user0": {{Id: "team1", DisplayName: "Engineering"}},
+			"user1": {{Id: "team1", DisplayName: "Engineering"}},
+			"user2": {{Id: "team1", DisplayName: "Engineering"}},
+		},
+		channels: map[string][]*model.Channel{
+			"team1:user0": {{Id: "ch1", DisplayName: "General", Type: model.ChannelTypeOpen}},
+		},
+	}
+
+	first, _ := RunAudit(client, AuditOptions{InactiveDays: 90})
+	if client.channelCalls != 3 {
+		t.Fatalf("first run channel calls = %d, want 3", client.channelCalls)
+	}
+
+	// guest1 was edited and guest2 signed in; guest0 is unchanged, so its
+	// record is kept even though its channels changed meanwhile
+	guests[1].UpdateAt = 1730000000000
+	guests[2].LastActivityAt = time.Now().UnixMilli()
+	client.channels["team1:user0"] = nil
+	client.channelCalls = 0
+
+	second, exitCode := RunAudit(client, AuditOptions{InactiveDays: 90, Previous: first})
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	if client.channelCalls != 2 {
+		t.Errorf("second run channel calls = %d, want 2", client.channelCalls)
+	}
+	if len(second.Guests) != 3 || len(second.Guests[0].Channels) != 1 {
+		t.Fatalf("guest0 record was not reused: %+v", second.Guests)
+	}
+	if second.Guests[0].Checksum != first.Guests[0].Checksum {
+		t.Error("reused record checksum changed")
+	}
+	if !second.Guests[1].Inactive || second.Guests[2].Inactive {
+		t.Errorf("inactive = %t, %t; want true, false", second.Guests[1].Inactive, second.Guests[2].Inactive)
+	}
+
+	client.channelCalls = 0
+	RunAudit(client, AuditOptions{InactiveDays: 90, Previous: second, FullEnrichment: true})
+	if client.channelCalls != 3 {
+		t.Errorf("full enrichment channel calls = %d, want 3", client.channelCalls)
+	}
+
+	// Post counts depend on other users' posts, so nothing is reused
+	client.channelCalls = 0
+	RunAudit(client, AuditOptions{InactiveDays: 90, Previous: second, PostCount: true})
+	if client.channelCalls != 3 {
+		t.Errorf("post count channel calls = %d, want 3", client.channelCalls)
+	}
+}
+
+func TestRunAudit_Orphans(t *testing.T) {
+	client := &mockClient{
+		guests: sampleGuests(3),
+		teams: map[string][]*model.Team{
+			"user0": {{Id: "team1", DisplayName: "Engineering"}},
+		},
+		teamsErr: map[string]error{"user2": &APIError{StatusCode: 403, Message: "forbidden"}},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{})
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	// guest2's teams could not be read, so they are not known to be orphaned
+	for i, want := range []bool{false, true, false} {
+		if result.Guests[i].Orphaned != want {
+			t.Errorf("%s orphaned = %t, want %t", result.Guests[i].Username, result.Guests[i].Orphaned, want)
+		}
+	}
+	if result.Summary.OrphanedGuests != 1 {
+		t.Errorf("orphaned guests = %d, want 1", result.Summary.OrphanedGuests)
+	}
+
+	result, exitCode = RunAudit(client, AuditOptions{OrphansOnly: true})
+	if len(result.Guests) != 2 || result.Guests[0].Username != "guest1" || !result.Guests[1].Failed {
+		t.Fatalf("got %+v, want guest1 and a failed lookup for guest2", result.Guests)
+	}
+	if exitCode != ExitPartialFailure {
+		t.Errorf("exit code = %d, want %d", exitCode, ExitPartialFailure)
+	}
+}
+
+func TestRunAudit_CheckRoles(t *testing.T) {
+	engineering := &model.Team{Id: "team1", DisplayName: "Engineering"}
+	client := &mockClient{
+		guests: sampleGuests(2),
+		teams:  map[string][]*model.Team{"user0": {engineering}, "user1": {engineering}},
+		channels: map[string][]*model.Channel{
+			"team1:user0": {{Id: "ch1", DisplayName: "Partners", Type: model.ChannelTypePrivate}},
+			"team1:user1": {{Id: "ch1", DisplayName: "Partners", Type: model.ChannelTypePrivate}},
+		},
+		teamMembers: map[string][]*model.TeamMember{
+			"user0": {{TeamId: "team1", Roles: "team_guest", SchemeGuest: true}},
+			"user1": {{TeamId: "team1", Roles: "team_guest", SchemeGuest: true}},
+		},
+		channelMembers: map[string][]model.ChannelMember{
+			"team1:user0": {{ChannelId: "ch1", Roles: "channel_guest channel_admin", SchemeGuest: true, SchemeAdmin: true}},
+			"team1:user1": {{ChannelId: "ch1", Roles: "channel_guest", SchemeGuest: true}},
+		},
+	}
+
+	result, _ := RunAudit(client, AuditOptions{})
+	if client.rolesCalls != 0 || result.Summary.ElevatedRoleGuests != 0 {
+		t.Errorf("roles should not be checked unless enabled (%d calls)", client.rolesCalls)
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{CheckRoles: true})
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	if got := formatRoleGrants(result.Guests[0].ElevatedRoles, "|"); got != "Engineering/Partners:channel_admin" {
+		t.Errorf("guest0 elevated roles = %q", got)
+	}
+	if len(result.Guests[1].ElevatedRoles) != 0 {
+		t.Errorf("guest1 should hold no elevated roles, got %v", result.Guests[1].ElevatedRoles)
+	}
+	if result.Summary.ElevatedRoleGuests != 1 {
+		t.Errorf("elevated role guests = %d, want 1", result.Summary.ElevatedRoleGuests)
+	}
+
+	// A token that cannot read memberships marks the field instead
+	client.rolesErr = &APIError{StatusCode: 403, Message: "forbidden"}
+	result, exitCode = RunAudit(client, AuditOptions{CheckRoles: true})
+	if exitCode != ExitSuccess {
+		t.Errorf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	if got := strings.Join(result.Guests[1].PermissionMissing, ","); got != "elevated_roles" {
+		t.Errorf("permission missing = %q, want elevated_roles", got)
+	}
+}
+
+func TestRunAudit_LastViewed(t *testing.T) {
+	now := time.Now()
+	viewed := func(days int) int64 { return now.AddDate(0, 0, -days).UnixMilli() }
+	engineering := &model.Team{Id: "team1", DisplayName: "Engineering"}
+	client := &mockClient{
+		guests: sampleGuests(2),
+		teams:  map[string][]*model.Team{"user0": {engineering}, "user1": {engineering}},
+		channels: map[string][]*model.Channel{
+			"team1:user0": {{Id: "ch1", DisplayName: "Partners", Type: model.ChannelTypePrivate}, {Id: "ch2", DisplayName: "General", Type: model.ChannelTypeOpen}},
+			"team1:user1": {{Id: "ch1", DisplayName: "Partners", Type: model.ChannelTypePrivate}},
+		},
+		channelMembers: map[string][]model.ChannelMember{
+			"team1:user0": {{ChannelId: "ch1", LastViewedAt: viewed(40)}, {ChannelId: "ch2", LastViewedAt: viewed(5)}},
+			// ch9 is not among the reported channels, e.g. archived
+			"team1:user1": {{ChannelId: "ch1", LastViewedAt: viewed(60)}, {ChannelId: "ch9", LastViewedAt: viewed(1)}},
+		},
+	}
+
+	result, _ := RunAudit(client, AuditOptions{})
+	if client.rolesCalls != 0 || result.Guests[0].LastViewed != nil {
+		t.Errorf("channel views should not be looked up unless enabled (%d calls)", client.rolesCalls)
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{LastViewed: true, InactiveDays: 30, InactivityMetric: MetricView})
+	if exitCode != ExitSuccess {
+		t.Fatalf("exit code = %d, want %d", exitCode, ExitSuccess)
+	}
+	for i, days := range []int{5, 60} {
+		g := result.Guests[i]
+		if g.LastViewed == nil || g.LastViewed.UnixMilli() != viewed(days) {
+			t.Errorf("%s: last viewed = %v, want %d days ago", g.Username, g.LastViewed, days)
+		}
+	}
+	if result.Guests[0].Inactive || !result.Guests[1].Inactive {
+		t.Errorf("inactive = %v, %v; want false, true", result.Guests[0].Inactive, result.Guests[1].Inactive)
+	}
+
+	// A token that cannot read memberships marks the field instead
+	client.rolesErr = &APIError{StatusCode: 403, Message: "forbidden"}
+	result, _ = RunAudit(client, AuditOptions{LastViewed: true})
+	if g := result.Guests[1]; g.LastViewed != nil || strings.Join(g.PermissionMissing, ",") != "last_viewed" {
+		t.Errorf("last viewed = %v, permission missing = %v; want nil and last_viewed", g.LastViewed, g.PermissionMissing)
+	}
+}
+
+func TestRunAudit_LastViewedUnknown(t *testing.T) {
+	engineering := &model.Team{Id: "team1", DisplayName: "Engineering"}
+	client := &mockClient{
+		guests:   sampleGuests(2),
+		teams:    map[string][]*model.Team{"user0": {engineering}, "user1": {engineering}},
+		channels: map[string][]*model.Channel{"team1:user0": {{Id: "ch1", DisplayName: "Partners", Type: model.ChannelTypePrivate}}, "team1:user1": {{Id: "ch1", DisplayName: "Partners", Type: model.ChannelTypePrivate}}},
+	}
+
+	// A failed lookup leaves the view unknown, which is not "never viewed"
+	for _, tt := range []struct {
+		err  error
+		want string
+	}{
+		{&APIError{StatusCode: 500, Message: "internal error"}, ""},
+		{&APIError{StatusCode: 403, Message: "forbidden"}, "last_viewed"},
+	} {
+		client.rolesErr = tt.err
+		result, exitCode := RunAudit(client, AuditOptions{LastViewed: true, InactiveDays: 30, InactivityMetric: MetricView})
+		if exitCode == ExitConfigError {
+			t.Fatalf("exit code = %d", exitCode)
+		}
+		for _, g := range result.Guests {
+			if !g.ViewUnknown || g.Inactive || strings.Join(g.PermissionMissing, ",") != tt.want {
+				t.Errorf("%v: %s unknown = %v, inactive = %v, permission missing = %v", tt.err, g.Username, g.ViewUnknown, g.Inactive, g.PermissionMissing)
+			}
+		}
+		if plans := PlanChannelRemovals(result); len(plans) != 0 {
+			t.Errorf("%v: planned removals for guests with an unknown view: %+v", tt.err, plans)
+		}
+	}
+}
+
+func TestRunAudit_ProfileFields(t *testing.T) {
+	guests := sampleGuests(2)
+	guests[0].Position = "Consultant"
+	client := &mockClient{
+		guests: guests,
+		profileFields: []ProfileField{
+			{ID: "f1", Name: "Department"},
+			{ID: "f2", Name: "Company", Options: map[string]string{"o1": "Acme Ltd"}},
+			{ID: "f3", Name: "Phone"},
+		},
+		profileAttrs: map[string]map[string]json.RawMessage{
+			"user0": {"f1": json.RawMessage(`"Delivery"`), "f2": json.RawMessage(`"o1"`), "f3": json.RawMessage(`"555"`)},
+		},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{ProfileFields: []string{"company", "department"}})
+	if exitCode != ExitSuccess {
+		t.Fatalf("exit code = %d, want %d", exitCode, ExitSuccess)
+	}
+	if !slices.Equal(result.ProfileFields, []string{"Company", "Department"}) {
+		t.Errorf("profile columns = %v, want the server's names in the order asked", result.ProfileFields)
+	}
+	if g := result.Guests[0]; g.Position != "Consultant" || !maps.Equal(g.ProfileFields, map[string]string{"Company": "Acme Ltd", "Department": "Delivery"}) {
+		t.Errorf("guest0: position %q, profile fields %v", g.Position, g.ProfileFields)
+	}
+	if g := result.Guests[1]; !maps.Equal(g.ProfileFields, map[string]string{"Company": "", "Department": ""}) {
+		t.Errorf("guest1: profile fields %v, want both empty", g.ProfileFields)
+	}
+
+	// A name the server does not define stops the audit
+	if _, exitCode := RunAudit(client, AuditOptions{ProfileFields: []string{"Employer"}}); exitCode != ExitConfigError {
+		t.Errorf("unknown field: exit code = %d, want %d", exitCode, ExitConfigError)
+	}
+
+	// A server without custom profile attributes leaves the columns empty
+	client.profileFieldsErr = &APIError{StatusCode: 404, Message: "not found"}
+	result, exitCode = RunAudit(client, AuditOptions{ProfileFields: []string{"company"}})
+	if exitCode != ExitSuccess || !slices.Contains(result.UnavailableEnrichment, EnrichProfileFields) || result.Guests[0].ProfileFields != nil {
+		t.Errorf("exit code %d, unavailable %v, profile fields %v", exitCode, result.UnavailableEnrichment, result.Guests[0].ProfileFields)
+	}
+	if !slices.Equal(result.ProfileFields, []string{"company"}) {
+		t.Errorf("profile columns = %v, want the names as given", result.ProfileFields)
+	}
+}
+
+func TestRunAudit_SharedSessions(t *testing.T) {
+	now := time.Now()
+	live := func(id string) *model.Session {
+		return &model.Session{Id: id, CreateAt: now.Add(-2 * time.Hour).UnixMilli(), LastActivityAt: now.UnixMilli()}
+	}
+	client := &mockClient{
+		guests: sampleGuests(2),
+		sessions: map[string][]*model.Session{
+			"user0": {live("s1"), live("s2")},
+			"user1": {live("s3")},
+		},
+		userAudits: map[string][]model.Audit{
+			"user0": {{SessionId: "s1", IpAddress: "203.0.113.10"}, {SessionId: "s2", IpAddress: "198.51.100.7"}},
+			"user1": {{SessionId: "s3", IpAddress: "203.0.113.11"}},
+		},
+	}
+
+	result, _ := RunAudit(client, AuditOptions{})
+	if result.Guests[0].SharedAccount != nil {
+		t.Error("sessions should not be checked unless enabled")
+	}
+
+	// Audit records loaded for identity history are reused, not fetched again
+	client.userAuditCalls = 0
+	result, exitCode := RunAudit(client, AuditOptions{SharedSessions: true, IdentityHistory: true})
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	if client.userAuditCalls != 2 {
+		t.Errorf("audit record calls = %d, want 2", client.userAuditCalls)
+	}
+	if g := result.Guests[0]; g.SharedAccount == nil || !*g.SharedAccount || strings.Join(g.SharedSessionIPs, ",") != "198.51.100.7,203.0.113.10" {
+		t.Errorf("guest0 shared = %v, IPs %v; want true with both IPs", g.SharedAccount, g.SharedSessionIPs)
+	}
+	if g := result.Guests[1]; g.SharedAccount == nil || *g.SharedAccount {
+		t.Errorf("guest1 shared = %v, want false", g.SharedAccount)
+	}
+	if result.Summary.SharedAccountGuests != 1 {
+		t.Errorf("shared account guests = %d, want 1", result.Summary.SharedAccountGuests)
+	}
+
+	// A token that cannot read sessions marks the fields instead
+	client.sessionsErr = &APIError{StatusCode: 403, Message: "forbidden"}
+	result, _ = RunAudit(client, AuditOptions{SharedSessions: true})
+	if got := strings.Join(result.Guests[1].PermissionMissing, ","); got != "possible_shared_account,shared_session_ips" {
+		t.Errorf("permission missing = %q", got)
+	}
+	if result.Guests[0].SharedAccount != nil {
+		t.Error("shared account should be null when sessions cannot be read")
+	}
+}
+
+func TestBuildDisplayName(t *testing.T) {
+	tests := []struct {
+		name      string
+		firstName string
+		lastName  string
+		expected  string
+	}{
+		{"both names", "Jane", "Doe", "Jane Doe"},
+		{"first only", "Jane", "", "Jane"},
+		{"last only", "", "Doe", "Doe"},
+		{"neither", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := BuildDisplayName(tt.firstName, tt.lastName)
+			if result != tt.expected {
+				t.Errorf("BuildDisplayName(%q, %q) = %q, want %q",
+					tt.firstName, tt.lastName, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMillisToTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		millis   int64
+		expected *time.Time
+	}{
+		{"zero returns nil", 0, nil},
+		{"valid timestamp", 1700000000000, timePtr(time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := MillisToTime(tt.millis)
+			if tt.expected == nil {
+				if result != nil {
+					t.Errorf("MillisToTime(%d) = %v, want nil", tt.millis, result)
+				}
+				return
+			}
+			if result == nil {
+				t.Fatalf("MillisToTime(%d) = nil, want %v", tt.millis, tt.expected)
+			}
+			if !result.Equal(*tt.expected) {
+				t.Errorf("MillisToTime(%d) = %v, want %v", tt.millis, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatTimeISO(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    *time.Time
+		expected string
+	}{
+		{"nil returns empty", nil, ""},
+		{"valid time", timePtr(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)), "2024-03-01T10:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := FormatTimeISO(tt.input)
+			if result != tt.expected {
+				t.Errorf("FormatTimeISO(%v) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRunAudit_BasicScenario(t *testing.T) {
+	now := time.Now()
+	loginTime := now.AddDate(0, 0, -5)
+
+	client := &mockClient{
+		guests: []*model.User{
+			{Id: "user1", Username: "jane.doe", FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
+			{Id: "user2", Username: "bob.smith", FirstName: "Bob", LastName: "Smith", Email: "bob@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
+			{Id: "user3", Username: "alice.jones", FirstName: "Alice", LastName: "Jones", Email: "alice@example.com", CreateAt: 1709280000000, DeleteAt: 1710000000000},
+		},
+		teams: map[string][]*model.Team{
+			"user1": {{Id: "team1", DisplayName: "Engineering"}},
+			"user2": {{Id: "team1", DisplayName: "Engineering"}, {Id: "team2", DisplayName: "Sales"}},
+			"user3": {{Id: "team1", DisplayName: "Engineering"}},
+		},
+		channels: map[string][]*model.Channel{
+			"team1:user1": {{Id: "ch1", DisplayName: "General"}},
+			"team1:user2": {{Id: "ch1", DisplayName: "General"}, {Id: "ch2", DisplayName: "Dev Backend"}},
+			"team2:user2": {{Id: "ch3", DisplayName: "Partner Updates"}},
+			"team1:user3": {{Id: "ch1", DisplayName: "General"}},
+		},
+		lastPostDate: map[string]*time.Time{
+			"user1": timePtr(now.AddDate(0, 0, -2)),
+			"user2": timePtr(now.AddDate(0, 0, -10)),
+		},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{})
+
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	if result == nil {
+		t.Fatal("result is nil")
+	}
+	if len(result.Guests) != 3 {
+		t.Fatalf("expected 3 guests, got %d", len(result.Guests))
+	}
+
+	// Verify first guest
+	g := result.Guests[0]
+	if g.Username != "jane.doe" {
+		t.Errorf("expected username jane.doe, got %s", g.Username)
+	}
+	if g.DisplayName != "Jane Doe" {
+		t.Errorf("expected display name 'Jane Doe', got %q", g.DisplayName)
+	}
+	if len(g.Teams) != 1 {
+		t.Errorf("expected 1 team, got %d", len(g.Teams))
+	}
+	if len(g.Channels) != 1 {
+		t.Errorf("expected 1 channel, got %d", len(g.Channels))
+	}
+	if !g.Active {
+		t.Error("expected guest to be active")
+	}
+
+	// Verify deactivated guest
+	g3 := result.Guests[2]
+	if g3.Active {
+		t.Error("expected guest to be deactivated")
+	}
+
+	// Verify summary
+	if result.Summary.TotalGuests != 3 {
+		t.Errorf("expected total 3, got %d", result.Summary.TotalGuests)
+	}
+	if result.Summary.ActiveGuests != 2 {
+		t.Errorf("expected 2 active, got %d", result.Summary.ActiveGuests)
+	}
+	if result.Summary.DeactivatedGuests != 1 {
+		t.Errorf("expected 1 deactivated, got %d", result.Summary.DeactivatedGuests)
+	}
+}
+
+func TestRunAudit_TeamFilter(t *testing.T) {
+	now := time.Now()
+	loginTime := now.AddDate(0, 0, -5)
+
+	client := &mockClient{
+		guests: []*model.User{
+			{Id: "user1", Username: "jane.doe", FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
+			{Id: "user2", Username: "bob.smith", FirstName: "Bob", LastName: "Smith", Email: "bob@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
+		},
+		teamByName: map[string]*model.Team{
+			"Sales": {Id: "team2", DisplayName: "Sales"},
+		},
+		teams: map[string][]*model.Team{
+			"user1": {{Id: "team1", DisplayName: "Engineering"}},
+			"user2": {{Id: "team1", DisplayName: "Engineering"}, {Id: "team2", DisplayName: "Sales"}},
+		},
+		channels: map[string][]*model.Channel{
+			"team2:user2": {{Id: "ch3", DisplayName: "Partner Updates"}},
+		},
+		lastPostDate: map[string]*time.Time{
+			"user2": timePtr(now.AddDate(0, 0, -3)),
+		},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{TeamFilter: "Sales"})
+
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	if len(result.Guests) != 1 {
+		t.Fatalf("expected 1 guest in Sales team, got %d", len(result.Guests))
+	}
+	if result.Guests[0].Username != "bob.smith" {
+		t.Errorf("expected bob.smith, got %s", result.Guests[0].Username)
+	}
+	// Only the team's guests are listed, not every guest on the instance
+	if client.guestTeamID != "team2" {
+		t.Errorf("GetGuestUsers team = %q, want team2", client.guestTeamID)
+	}
+}
+
+func TestRunAudit_TeamFilterNotFound(t *testing.T) {
+	client := &mockClient{
+		teamByName: map[string]*model.Team{},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{TeamFilter: "NonExistent"})
+
+	if exitCode != ExitConfigError {
+		t.Errorf("expected exit code %d, got %d", ExitConfigError, exitCode)
+	}
+	if result != nil {
+		t.Error("expected nil result for unknown team")
+	}
+}
+
+func TestRunAudit_Pagination(t *testing.T) {
+	// Create 250 guests (page 0: 200, page 1: 50)
+	guests := make([]*model.User, 250)
+	teams := make(map[string][]*model.Team)
+	channels := make(map[string][]*model.Channel)
+	for i := 0; i < 250; i++ {
+		id := fmt.Sprintf("user%d", i)
+		guests[i] = &model.User{
+			Id:       id,
+			Username: fmt.Sprintf("guest%d", i),
+			Email:    fmt.Sprintf("guest%d@example.com", i),
+			CreateAt: 1709280000000,
+		}
+		teams[id] = []*model.Team{{Id: "team1", DisplayName: "Engineering"}}
+		channels["team1:"+id] = []*model.Channel{{Id: "ch1", DisplayName: "General"}}
+	}
+
+	client := &mockClient{
+		guests:   guests,
+		teams:    teams,
+		channels: channels,
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{})
+
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	if len(result.Guests) != 250 {
+		t.Errorf("expected 250 guests, got %d", len(result.Guests))
+	}
+}
+
+func TestRunAudit_PaginationRetry(t *testing.T) {
+	client := &mockClient{
+		guests:         sampleGuests(250),
+		guestPageFails: map[int]int{1: 2},
+	}
+	retry := RetryPolicy{MaxRetries: 3, sleep: func(time.Duration) {}}
+
+	result, exitCode := RunAudit(client, AuditOptions{Retry: retry})
+
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	if len(result.Guests) != 250 {
+		t.Errorf("expected 250 guests, got %d", len(result.Guests))
+	}
+	// Page 0 must not be re-fetched when page 1 fails
+	if client.guestPageCalls[0] != 1 {
+		t.Errorf("expected page 0 fetched once, got %d", client.guestPageCalls[0])
+	}
+	if client.guestPageCalls[1] != 3 {
+		t.Errorf("expected page 1 fetched 3 times, got %d", client.guestPageCalls[1])
+	}
+}
+
+func TestRunAudit_PaginationRetryExhausted(t *testing.T) {
+	client := &mockClient{
+		guests:         sampleGuests(250),
+		guestPageFails: map[int]int{1: 5},
+	}
+	retry := RetryPolicy{MaxRetries: 2, sleep: func(time.Duration) {}}
+
+	result, exitCode := RunAudit(client, AuditOptions{Retry: retry})
+
+	if exitCode != ExitAPIError {
+		t.Errorf("expected exit code %d, got %d", ExitAPIError, exitCode)
+	}
+	if result != nil {
+		t.Error("expected nil result when listing fails")
+	}
+}
+
+func TestRunAudit_EmptyResult(t *testing.T) {
+	client := &mockClient{
+		guests: []*model.User{},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{})
+
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	if len(result.Guests) != 0 {
+		t.Errorf("expected 0 guests, got %d", len(result.Guests))
+	}
+}
+
+func TestRunAudit_GuestRoles(t *testing.T) {
+	tests := []struct {
+		name      string
+		roles     []string
+		wantRoles string
+	}{
+		{"default roles", nil, "system_guest"},
+		{"custom roles", []string{"system_guest", "external_contractor"}, "system_guest,external_contractor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockClient{guests: []*model.User{}}
+			result, _ := RunAudit(client, AuditOptions{GuestRoles: tt.roles})
+			if got := strings.Join(client.guestRoles, ","); got != tt.wantRoles {
+				t.Errorf("GetGuestUsers roles = %q, want %q", got, tt.wantRoles)
+			}
+			if got := strings.Join(result.GuestRoles, ","); got != tt.wantRoles {
+				t.Errorf("result.GuestRoles = %q, want %q", got, tt.wantRoles)
+			}
+		})
+	}
+}
+
+func TestRunAudit_PartialFailure(t *testing.T) {
+	now := time.Now()
+	loginTime := now.AddDate(0, 0, -5)
+
+	client := &mockClient{
+		guests: []*model.User{
+			{Id: "user1", Username: "jane.doe", FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
+			{Id: "user2", Username: "bob.smith", FirstName: "Bob", LastName: "Smith", Email: "bob@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
+		},
+		teams: map[string][]*model.Team{
+			"user1": {{Id: "team1", DisplayName: "Engineering"}},
+		},
+		teamsErr: map[string]error{
+			"user2": fmt.Errorf("error: API request failed (HTTP 500)"),
+		},
+		channels: map[string][]*model.Channel{
+			"team1:user1": {{Id: "ch1", DisplayName: "General"}},
+		},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{})
+
+	if exitCode != ExitPartialFailure {
+		t.Errorf("expected exit code %d, got %d", ExitPartialFailure, exitCode)
+	}
+	if len(result.Guests) != 2 {
+		t.Fatalf("expected 2 guests, got %d", len(result.Guests))
+	}
+	if result.Summary.FailedLookups != 1 {
+		t.Errorf("expected 1 failed lookup, got %d", result.Summary.FailedLookups)
+	}
+	if !result.Guests[1].Failed {
+		t.Error("expected error message on failed guest")
+	}
+}
+
+func TestRunAudit_PartialRecords(t *testing.T) {
+	serverErr := &APIError{StatusCode: 500, Message: "error: API request failed (HTTP 500)"}
+	client := &mockClient{
+		guests: sampleGuests(3),
+		teams: map[string][]*model.Team{
+			"user0": {{Id: "team1", DisplayName: "Engineering"}, {Id: "team2", DisplayName: "Sales"}},
+			"user1": {{Id: "team1", DisplayName: "Engineering"}},
+		},
+		teamsErr: map[string]error{"user2": serverErr},
+		channels: map[string][]*model.Channel{
+			"team1:user0": {{Id: "ch1", DisplayName: "General", Type: model.ChannelTypeOpen}},
+			"team1:user1": {{Id: "ch1", DisplayName: "General", Type: model.ChannelTypeOpen}},
+		},
+		channelsErr:   map[string]error{"team2:user0": serverErr},
+		channelsFlaky: map[string]int{"team1:user1": 1},
+	}
+	retry := RetryPolicy{MaxRetries: 2, sleep: func(time.Duration) {}}
+
+	result, exitCode := RunAudit(client, AuditOptions{Retry: retry})
+	if exitCode != ExitPartialFailure {
+		t.Errorf("expected exit code %d, got %d", ExitPartialFailure, exitCode)
+	}
+	got := map[string]GuestRecord{}
+	for _, g := range result.Guests {
+		got[g.Username] = g
+	}
+
+	// Channels failing in one team keep the guest's other teams and channels
+	g := got["guest0"]
+	if g.Failed || len(g.Teams) != 2 || len(g.Channels) != 1 {
+		t.Errorf("expected a partial record with both teams and one channel, got %+v", g)
+	}
+	want := []LookupError{{Stage: LookupChannels, Team: "Sales", Message: serverErr.Error(), HTTPStatus: 500}}
+	if !slices.Equal(g.Errors, want) {
+		t.Errorf("errors = %+v, want %+v", g.Errors, want)
+	}
+
+	// A transient failure is retried
+	if g := got["guest1"]; len(g.Errors) != 0 || len(g.Channels) != 1 {
+		t.Errorf("expected the flaky lookup to succeed on retry, got %+v", g)
+	}
+
+	// A guest whose teams cannot be read still fails, naming the lookup
+	g = got["guest2"]
+	if !g.Failed || len(g.Errors) != 1 || g.Errors[0].Stage != LookupTeams || g.Errors[0].HTTPStatus != 500 {
+		t.Errorf("expected a failed guest with a teams error, got %+v", g)
+	}
+
+	if result.Summary.FailedLookups != 1 || result.Summary.IncompleteGuests != 1 {
+		t.Errorf("failed, incomplete = %d, %d; want 1, 1", result.Summary.FailedLookups, result.Summary.IncompleteGuests)
+	}
+	if want := map[string]int{LookupTeams: 1, LookupChannels: 1}; !maps.Equal(result.Summary.FailuresByStage, want) {
+		t.Errorf("failures by stage = %v, want %v", result.Summary.FailuresByStage, want)
+	}
+}
+
+func TestRunAudit_InactivityFlagging(t *testing.T) {
+	now := time.Now()
+
+	client := &mockClient{
+		guests: []*model.User{
+			{Id: "user1", Username: "active.user", Email: "a@example.com", CreateAt: 1709280000000, LastActivityAt: now.AddDate(0, 0, -5).UnixMilli()},
+			{Id: "user2", Username: "inactive.user", Email: "b@example.com", CreateAt: 1709280000000, LastActivityAt: now.AddDate(0, 0, -45).UnixMilli()},
+			{Id: "user3", Username: "never.login", Email: "c@example.com", CreateAt: 1709280000000, LastActivityAt: 0},
+		},
+		teams: map[string][]*model.Team{
+			"user1": {{Id: "team1", DisplayName: "Engineering"}},
+			"user2": {{Id: "team1", DisplayName: "Engineering"}},
+			"user3": {{Id: "team1", DisplayName: "Engineering"}},
+		},
+		channels: map[string][]*model.Channel{
+			"team1:user1": {{Id: "ch1", DisplayName: "General"}},
+			"team1:user2": {{Id: "ch1", DisplayName: "General"}},
+			"team1:user3": {{Id: "ch1", DisplayName: "General"}},
+		},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{InactiveDays: 30})
+
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+
+	// Active user — not inactive
+	if result.Guests[0].Inactive {
+		t.Error("user1 should not be inactive")
+	}
+	// Inactive user — 45 days > 30 threshold
+	if !result.Guests[1].Inactive {
+		t.Error("user2 should be inactive")
+	}
+	// Never logged in — should be inactive
+	if !result.Guests[2].Inactive {
+		t.Error("user3 (never logged in) should be inactive")
+	}
+
+	if result.Summary.ActiveGuests != 1 {
+		t.Errorf("expected 1 active guest, got %d", result.Summary.ActiveGuests)
+	}
+	if result.Summary.InactiveGuests != 2 {
+		t.Errorf("expected 2 inactive guests, got %d", result.Summary.InactiveGuests)
+	}
+	if result.InactivityMetric != MetricLogin {
+		t.Errorf("expected default metric %q, got %q", MetricLogin, result.InactivityMetric)
+	}
+}
+
+func TestRunAudit_InactivityMetricPost(t *testing.T) {
+	now := time.Now()
+
+	client := &mockClient{
+		guests: []*model.User{
+			{Id: "user1", Username: "reader.only", Email: "a@example.com", CreateAt: 1709280000000, LastActivityAt: now.AddDate(0, 0, -1).UnixMilli()},
+			{Id: "user2", Username: "poster", Email: "b@example.com", CreateAt: 1709280000000, LastActivityAt: now.AddDate(0, 0, -1).UnixMilli()},
+		},
+		teams: map[string][]*model.Team{
+			"user1": {{Id: "team1", DisplayName: "Engineering"}},
+			"user2": {{Id: "team1", DisplayName: "Engineering"}},
+		},
+		lastPostDate: map[string]*time.Time{
+			"user2": timePtr(now.AddDate(0, 0, -3)),
+		},
+	}
+
+	result, _ := RunAudit(client, AuditOptions{InactiveDays: 30, InactivityMetric: MetricPost})
+
+	if !result.Guests[0].Inactive {
+		t.Error("reader.only has never posted and should be inactive under the post metric")
+	}
+	if result.Guests[1].Inactive {
+		t.Error("poster should not be inactive")
+	}
+	if result.InactivityMetric != MetricPost {
+		t.Errorf("expected metric %q recorded, got %q", MetricPost, result.InactivityMetric)
+	}
+}
+
+func TestRunAudit_ChannelFilter(t *testing.T) {
+	now := time.Now()
+	loginTime := now.AddDate(0, 0, -5)
+
+	client := &mockClient{
+		guests: []*model.User{
+			{Id: "user1", Username: "jane.doe", FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
+			{Id: "user2", Username: "bob.smith", FirstName: "Bob", LastName: "Smith", Email: "bob@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
+		},
+		teamByName: map[string]*model.Team{
+			"Engineering": {Id: "team1", DisplayName: "Engineering"},
+		},
+		channelByName: map[string]*model.Channel{
+			"team1:dev-backend": {Id: "ch2", DisplayName: "Dev Backend", TeamId: "team1"},
+		},
+		teams: map[string][]*model.Team{
+			"user1": {{Id: "team1", DisplayName: "Engineering"}},
+			"user2": {{Id: "team1", DisplayName: "Engineering"}},
+		},
+		channels: map[string][]*model.Channel{
+			"team1:user1": {{Id: "ch1", DisplayName: "General"}, {Id: "ch2", DisplayName: "Dev Backend"}},
+			"team1:user2": {{Id: "ch1", DisplayName: "General"}},
+		},
+		lastPostDate: map[string]*time.Time{
+			"user1": timePtr(now.AddDate(0, 0, -2)),
+		},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{TeamFilter: "Engineering", ChannelFilter: "dev-backend"})
+
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	// Only jane.doe is in dev-backend
 	if len(result.Guests) != 1 {
-		t.Fatalf("expected 1 guest in Sales team, got %d", len(result.Guests))
+		t.Fatalf("expected 1 guest in dev-backend channel, got %d", len(result.Guests))
+	}
+	if result.Guests[0].Username != "jane.doe" {
+		t.Errorf("expected jane.doe, got %s", result.Guests[0].Username)
+	}
+	// Only the filtered channel should appear
+	if len(result.Guests[0].Channels) != 1 {
+		t.Fatalf("expected 1 channel, got %d", len(result.Guests[0].Channels))
+	}
+	if result.Guests[0].Channels[0].ChannelName != "Dev Backend" {
+		t.Errorf("expected channel 'Dev Backend', got %q", result.Guests[0].Channels[0].ChannelName)
+	}
+}
+
+func TestRunAudit_PrivateChannels(t *testing.T) {
+	loginTime := time.Now().AddDate(0, 0, -5)
+
+	newClient := func() *mockClient {
+		return &mockClient{
+			guests: []*model.User{
+				{Id: "user1", Username: "jane.doe", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
+				{Id: "user2", Username: "bob.smith", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
+			},
+			teams: map[string][]*model.Team{
+				"user1": {{Id: "team1", DisplayName: "Engineering"}},
+				"user2": {{Id: "team1", DisplayName: "Engineering"}},
+			},
+			channels: map[string][]*model.Channel{
+				"team1:user1": {
+					{Id: "ch1", DisplayName: "General", Type: model.ChannelTypeOpen},
+					{Id: "ch2", DisplayName: "Incident Room", Type: model.ChannelTypePrivate},
+					{Id: "ch3", DisplayName: "", Type: model.ChannelTypeDirect},
+				},
+				"team1:user2": {{Id: "ch1", DisplayName: "General", Type: model.ChannelTypeOpen}},
+			},
+		}
+	}
+
+	result, exitCode := RunAudit(newClient(), AuditOptions{})
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	jane := result.Guests[0]
+	wantTypes := []string{ChannelTypePublic, ChannelTypePrivate, ChannelTypeDirect}
+	for i, ch := range jane.Channels {
+		if ch.Type != wantTypes[i] {
+			t.Errorf("channel %d type = %q, want %q", i, ch.Type, wantTypes[i])
+		}
+	}
+	if jane.PrivateChannels != 1 {
+		t.Errorf("jane.doe private channels = %d, want 1", jane.PrivateChannels)
+	}
+	if result.Guests[1].PrivateChannels != 0 {
+		t.Errorf("bob.smith private channels = %d, want 0", result.Guests[1].PrivateChannels)
+	}
+
+	result, _ = RunAudit(newClient(), AuditOptions{PrivateOnly: true})
+	if len(result.Guests) != 1 || result.Guests[0].Username != "jane.doe" {
+		t.Fatalf("--private-only guests = %v, want only jane.doe", result.Guests)
+	}
+	// Channel lists are not trimmed; only guests without private access are skipped
+	if len(result.Guests[0].Channels) != 3 {
+		t.Errorf("expected 3 channels, got %d", len(result.Guests[0].Channels))
+	}
+}
+
+func TestRunAudit_MentionExemption(t *testing.T) {
+	now := time.Now()
+	old := now.AddDate(0, 0, -90).UnixMilli()
+
+	client := &mockClient{
+		guests: []*model.User{
+			{Id: "user1", Username: "standby.consultant", CreateAt: 1709280000000, LastActivityAt: old},
+			{Id: "user2", Username: "forgotten.vendor", CreateAt: 1709280000000, LastActivityAt: old},
+			{Id: "user3", Username: "busy.contractor", CreateAt: 1709280000000, LastActivityAt: now.AddDate(0, 0, -1).UnixMilli()},
+		},
+		teams: map[string][]*model.Team{
+			"user1": {{Id: "team1", DisplayName: "Engineering"}},
+			"user2": {{Id: "team1", DisplayName: "Engineering"}},
+			"user3": {{Id: "team1", DisplayName: "Engineering"}},
+		},
+		mentions: map[string][]*model.Post{
+			"standby.consultant": {
+				{Id: "p1", UserId: "staff1", CreateAt: now.AddDate(0, 0, -3).UnixMilli()},
+			},
+			"forgotten.vendor": {
+				{Id: "p2", UserId: "staff1", CreateAt: now.AddDate(0, 0, -60).UnixMilli()},
+				{Id: "p3", UserId: "user2", CreateAt: now.AddDate(0, 0, -1).UnixMilli()}, // own post
+			},
+		},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{InactiveDays: 30, MentionDays: 14})
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+
+	standby := result.Guests[0]
+	if standby.Inactive {
+		t.Error("standby.consultant was mentioned 3 days ago and should not be inactive")
+	}
+	if standby.LastMention == nil {
+		t.Error("standby.consultant should have a last mention date")
+	}
+	if !result.Guests[1].Inactive {
+		t.Error("forgotten.vendor has no recent mention by others and should stay inactive")
+	}
+	if result.Guests[2].LastMention != nil {
+		t.Error("active guests should not be looked up")
+	}
+	if client.mentionCalls != 2 {
+		t.Errorf("mention searches = %d, want 2 (inactive guests only)", client.mentionCalls)
+	}
+	if result.Summary.InactiveGuests != 1 {
+		t.Errorf("expected 1 inactive guest, got %d", result.Summary.InactiveGuests)
+	}
+}
+
+func TestRunAudit_MentionSearchUnsupported(t *testing.T) {
+	old := time.Now().AddDate(0, 0, -90).UnixMilli()
+	client := &mockClient{
+		guests: []*model.User{
+			{Id: "user1", Username: "a.guest", CreateAt: 1709280000000, LastActivityAt: old},
+			{Id: "user2", Username: "b.guest", CreateAt: 1709280000000, LastActivityAt: old},
+		},
+		teams: map[string][]*model.Team{
+			"user1": {{Id: "team1", DisplayName: "Engineering"}},
+			"user2": {{Id: "team1", DisplayName: "Engineering"}},
+		},
+		mentionsErr: &APIError{StatusCode: 501, Message: "not implemented"},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{InactiveDays: 30, MentionDays: 14})
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	if client.mentionCalls != 1 {
+		t.Errorf("mention searches = %d, want 1 before disabling", client.mentionCalls)
+	}
+	if !slices.Contains(result.UnavailableEnrichment, EnrichMentions) {
+		t.Errorf("unavailable = %v, want %q listed", result.UnavailableEnrichment, EnrichMentions)
+	}
+	if result.Summary.InactiveGuests != 2 {
+		t.Errorf("expected both guests to stay inactive, got %d", result.Summary.InactiveGuests)
+	}
+}
+
+func TestRunAudit_MentionCount(t *testing.T) {
+	now := time.Now()
+	daysAgo := func(d int) int64 { return now.AddDate(0, 0, -d).UnixMilli() }
+
+	newClient := func() *mockClient {
+		return &mockClient{
+			guests: []*model.User{
+				{Id: "user1", Username: "jane.doe", CreateAt: 1709280000000, LastActivityAt: daysAgo(90)},
+				{Id: "user2", Username: "bob.smith", CreateAt: 1709280000000, LastActivityAt: daysAgo(1)},
+			},
+			teams: map[string][]*model.Team{
+				"user1": {{Id: "team1", DisplayName: "Engineering"}},
+				"user2": {{Id: "team1", DisplayName: "Engineering"}},
+			},
+			mentions: map[string][]*model.Post{
+				"jane.doe": {
+					{Id: "p1", UserId: "staff1", CreateAt: daysAgo(3)},
+					{Id: "p2", UserId: "user2", CreateAt: daysAgo(2)}, // by another guest
+					{Id: "p3", UserId: "staff2", CreateAt: daysAgo(40)},
+				},
+			},
+		}
+	}
+
+	client := newClient()
+	result, _ := RunAudit(client, AuditOptions{MentionCountDays: 30})
+	if got := result.Guests[0].MentionCount; got == nil || *got != 1 {
+		t.Errorf("jane.doe mention count = %v, want 1 (internal, within 30 days)", got)
+	}
+	if got := result.Guests[1].MentionCount; got == nil || *got != 0 {
+		t.Errorf("bob.smith mention count = %v, want 0", got)
+	}
+	if client.mentionCalls != 2 {
+		t.Errorf("mention searches = %d, want 2 (every guest)", client.mentionCalls)
+	}
+
+	// One search over the longer window serves both options; the count
+	// still leaves out the 40-day-old mention
+	client = newClient()
+	result, _ = RunAudit(client, AuditOptions{InactiveDays: 30, MentionDays: 60, MentionCountDays: 30})
+	jane := result.Guests[0]
+	if jane.Inactive {
+		t.Error("jane.doe was mentioned within 60 days and should not be inactive")
+	}
+	if jane.MentionCount == nil || *jane.MentionCount != 1 {
+		t.Errorf("jane.doe mention count = %v, want 1", jane.MentionCount)
+	}
+	if client.mentionCalls != 2 {
+		t.Errorf("mention searches = %d, want 2", client.mentionCalls)
+	}
+}
+
+func TestMentionedWithin(t *testing.T) {
+	now := time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		lastMention *time.Time
+		days        int
+		want        bool
+	}{
+		{"never mentioned", nil, 14, false},
+		{"recent", timePtr(now.AddDate(0, 0, -3)), 14, true},
+		{"too old", timePtr(now.AddDate(0, 0, -15)), 14, false},
+		{"disabled", timePtr(now.AddDate(0, 0, -1)), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MentionedWithin(tt.lastMention, tt.days, now); got != tt.want {
+				t.Errorf("MentionedWithin = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunAudit_PostCount(t *testing.T) {
+	loginTime := time.Now().AddDate(0, 0, -5)
+
+	newClient := func() *mockClient {
+		return &mockClient{
+			guests: []*model.User{
+				{Id: "user1", Username: "jane.doe", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
+				{Id: "user2", Username: "bob.smith", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
+			},
+			teams: map[string][]*model.Team{
+				"user1": {{Id: "team1", DisplayName: "Engineering"}},
+				"user2": {{Id: "team1", DisplayName: "Engineering"}},
+			},
+			channels: map[string][]*model.Channel{
+				"team1:user1": {
+					{Id: "ch1", DisplayName: "General", Type: model.ChannelTypeOpen},
+					{Id: "ch2", DisplayName: "Incident Room", Type: model.ChannelTypePrivate},
+					{Id: "dm1", Type: model.ChannelTypeDirect},
+				},
+				"team1:user2": {{Id: "ch1", DisplayName: "General", Type: model.ChannelTypeOpen}},
+			},
+			channelPosts: map[string]map[string]int{
+				"ch1": {"user1": 3, "user2": 1, "staff1": 10},
+				"ch2": {"user1": 2},
+				"dm1": {"user1": 50},
+			},
+		}
+	}
+
+	client := newClient()
+	result, exitCode := RunAudit(client, AuditOptions{PostCount: true})
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	if got := result.Guests[0].PostCount; got == nil || *got != 5 {
+		t.Errorf("jane.doe post count = %v, want 5 (DMs excluded)", got)
+	}
+	if got := result.Guests[1].PostCount; got == nil || *got != 1 {
+		t.Errorf("bob.smith post count = %v, want 1", got)
+	}
+	if client.channelPostCalls != 2 {
+		t.Errorf("channel reads = %d, want 2 (shared channel read once)", client.channelPostCalls)
+	}
+
+	// A channel that cannot be read leaves the count unknown rather than low
+	client = newClient()
+	client.channelPostsErr = map[string]error{"ch2": &APIError{StatusCode: 500, Message: "internal error"}}
+	result, _ = RunAudit(client, AuditOptions{PostCount: true})
+	if result.Guests[0].PostCount != nil {
+		t.Errorf("jane.doe post count = %d, want nil", *result.Guests[0].PostCount)
+	}
+	if got := result.Guests[1].PostCount; got == nil || *got != 1 {
+		t.Errorf("bob.smith post count = %v, want 1", got)
+	}
+
+	// Not collected unless requested
+	result, _ = RunAudit(newClient(), AuditOptions{})
+	if result.Guests[0].PostCount != nil {
+		t.Error("post count should be nil without PostCount")
+	}
+}
+
+func TestRunAudit_ChannelFilterNotFound(t *testing.T) {
+	client := &mockClient{
+		teamByName: map[string]*model.Team{
+			"Engineering": {Id: "team1", DisplayName: "Engineering"},
+		},
+		channelByName: map[string]*model.Channel{},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{TeamFilter: "Engineering", ChannelFilter: "nonexistent-channel"})
+
+	if exitCode != ExitConfigError {
+		t.Errorf("expected exit code %d, got %d", ExitConfigError, exitCode)
+	}
+	if result != nil {
+		t.Error("expected nil result for unknown channel")
+	}
+}
+
+func TestRunAudit_RetentionPolicies(t *testing.T) {
+	now := time.Now()
+	loginTime := now.AddDate(0, 0, -5)
+
+	newClient := func() *mockClient {
+		return &mockClient{
+			guests: []*model.User{
+				{Id: "user1", Username: "jane.doe", Email: "jane@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
+				{Id: "user2", Username: "bob.smith", Email: "bob@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
+			},
+			teams: map[string][]*model.Team{
+				"user1": {{Id: "team1", DisplayName: "Engineering"}},
+				"user2": {{Id: "team1", DisplayName: "Engineering"}},
+			},
+			channels: map[string][]*model.Channel{
+				"team1:user1": {{Id: "ch1", DisplayName: "General"}, {Id: "ch9", DisplayName: "Legal Hold"}},
+				"team1:user2": {{Id: "ch1", DisplayName: "General"}},
+			},
+			channelPolicies: map[string][]*model.RetentionPolicyForChannel{
+				"user1": {{ChannelID: "ch9", PostDurationDays: -1}},
+			},
+		}
+	}
+
+	t.Run("policies in use", func(t *testing.T) {
+		client := newClient()
+		client.policyCount = 1
+
+		result, exitCode := RunAudit(client, AuditOptions{})
+		if exitCode != ExitSuccess {
+			t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+		}
+		g := result.Guests[0]
+		if g.RetentionChannels != 1 {
+			t.Errorf("expected 1 retention channel, got %d", g.RetentionChannels)
+		}
+		if g.Channels[0].RetentionPolicy {
+			t.Error("General should not be under a retention policy")
+		}
+		if !g.Channels[1].RetentionPolicy || g.Channels[1].RetentionDays != -1 {
+			t.Errorf("Legal Hold = %+v, want retention policy with -1 days", g.Channels[1])
+		}
+		if result.Guests[1].RetentionChannels != 0 {
+			t.Errorf("expected 0 retention channels for bob.smith, got %d", result.Guests[1].RetentionChannels)
+		}
+		if result.Summary.RetentionGuests != 1 {
+			t.Errorf("expected 1 retention guest in summary, got %d", result.Summary.RetentionGuests)
+		}
+	})
+
+	t.Run("feature unavailable", func(t *testing.T) {
+		client := newClient()
+		client.policyCountErr = fmt.Errorf("error: the requested resource was not found")
+
+		result, exitCode := RunAudit(client, AuditOptions{})
+		if exitCode != ExitSuccess {
+			t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+		}
+		if result.Summary.RetentionGuests != 0 {
+			t.Errorf("expected 0 retention guests, got %d", result.Summary.RetentionGuests)
+		}
+	})
+}
+
+func TestRunAudit_Allowlist(t *testing.T) {
+	now := time.Now()
+
+	client := &mockClient{
+		guests: []*model.User{
+			{Id: "user1", Username: "jane.contractor", Email: "jane@contractor.io", CreateAt: 1709280000000, LastActivityAt: now.AddDate(0, 0, -90).UnixMilli()},
+			{Id: "user2", Username: "old.vendor", Email: "sales@vendor.com", CreateAt: 1709280000000, LastActivityAt: now.AddDate(0, 0, -90).UnixMilli()},
+			{Id: "user3", Username: "bob.smith", Email: "bob@example.com", CreateAt: 1709280000000, LastActivityAt: now.AddDate(0, 0, -2).UnixMilli()},
+		},
+		teams: map[string][]*model.Team{
+			"user1": {{Id: "team1", DisplayName: "Engineering"}},
+			"user2": {{Id: "team1", DisplayName: "Engineering"}},
+			"user3": {{Id: "team1", DisplayName: "Engineering"}},
+		},
+	}
+
+	allowlist, err := ParseAllowlist([]byte(`
+exceptions:
+  - username: jane.contractor
+    justification: Retained support contract
+    ticket: https://jira.example.com/browse/SEC-42
+  - email: sales@vendor.com
+    expires: 2020-01-31
+    ticket: https://servicenow.example.com/ritm0010042
+`))
+	if err != nil {
+		t.Fatalf("ParseAllowlist error: %v", err)
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{InactiveDays: 30, Allowlist: allowlist})
+
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+
+	jane := result.Guests[0]
+	if !jane.Excepted {
+		t.Error("jane.contractor should be excepted")
+	}
+	if jane.ExceptionJustification != "Retained support contract" {
+		t.Errorf("justification = %q, want 'Retained support contract'", jane.ExceptionJustification)
+	}
+	if jane.ExceptionTicket != "https://jira.example.com/browse/SEC-42" {
+		t.Errorf("ticket = %q, want the Jira link", jane.ExceptionTicket)
+	}
+	if guestStatus(jane) != "Excepted" {
+		t.Errorf("status = %q, want Excepted", guestStatus(jane))
+	}
+
+	// Expired exception is ignored
+	if result.Guests[1].Excepted {
+		t.Error("old.vendor exception has expired and should not apply")
+	}
+	if result.Guests[1].ExceptionTicket != "" {
+		t.Errorf("expired exception ticket = %q, want empty", result.Guests[1].ExceptionTicket)
+	}
+
+	if result.Summary.ExceptedGuests != 1 {
+		t.Errorf("expected 1 excepted guest, got %d", result.Summary.ExceptedGuests)
+	}
+	if result.Summary.InactiveGuests != 1 {
+		t.Errorf("expected 1 inactive guest, got %d", result.Summary.InactiveGuests)
+	}
+	if result.Summary.ActiveGuests != 1 {
+		t.Errorf("expected 1 active guest, got %d", result.Summary.ActiveGuests)
+	}
+}
+
+func TestRunAudit_FileActivity(t *testing.T) {
+	now := time.Now()
+	uploaded := now.AddDate(0, 0, -3)
+
+	client := &mockClient{
+		guests: []*model.User{
+			{Id: "user1", Username: "quiet.sharer", CreateAt: 1709280000000},
+			{Id: "user2", Username: "no.files", CreateAt: 1709280000000},
+			{Id: "user3", Username: "no.teams", CreateAt: 1709280000000},
+			{Id: "user4", Username: "search.fails", CreateAt: 1709280000000},
+		},
+		teams: map[string][]*model.Team{
+			"user1": {{Id: "team1", DisplayName: "Engineering"}},
+			"user2": {{Id: "team1", DisplayName: "Engineering"}},
+			"user4": {{Id: "team1", DisplayName: "Engineering"}},
+		},
+		fileCounts:      map[string]int{"quiet.sharer": 7},
+		lastFileUpload:  map[string]*time.Time{"quiet.sharer": &uploaded},
+		fileActivityErr: map[string]error{"search.fails": fmt.Errorf("boom")},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{
+		FileActivity: true,
+		Sort:         SortSpec{Field: "file_count", Desc: true},
+	})
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+
+	got := map[string]GuestRecord{}
+	for _, g := range result.Guests {
+		got[g.Username] = g
+	}
+
+	if g := got["quiet.sharer"]; g.FileCount == nil || *g.FileCount != 7 || g.LastFileUpload == nil || !g.LastFileUpload.Equal(uploaded) {
+		t.Errorf("quiet.sharer file activity = %v, %v; want 7, %v", g.FileCount, g.LastFileUpload, uploaded)
+	}
+	for _, name := range []string{"no.files", "no.teams"} {
+		if g := got[name]; g.FileCount == nil || *g.FileCount != 0 || g.LastFileUpload != nil {
+			t.Errorf("%s: expected zero files, got %v, %v", name, g.FileCount, g.LastFileUpload)
+		}
+	}
+	// A failed search leaves the fields unset rather than failing the guest
+	if g := got["search.fails"]; g.FileCount != nil || g.Failed {
+		t.Errorf("search.fails: expected unset file count and no failure, got %v, %+v", g.FileCount, g.Errors)
+	}
+
+	if result.Guests[0].Username != "quiet.sharer" {
+		t.Errorf("expected quiet.sharer first when sorted by -file_count, got %s", result.Guests[0].Username)
+	}
+}
+
+func TestRunAudit_FileActivityDisabled(t *testing.T) {
+	client := &mockClient{
+		guests:     sampleGuests(1),
+		teams:      map[string][]*model.Team{"user0": {{Id: "team1", DisplayName: "Engineering"}}},
+		fileCounts: map[string]int{"guest0": 3},
+	}
+
+	result, _ := RunAudit(client, AuditOptions{})
+	if g := result.Guests[0]; g.FileCount != nil || g.LastFileUpload != nil {
+		t.Errorf("expected no file activity without FileActivity, got %v, %v", g.FileCount, g.LastFileUpload)
+	}
+}
+
+func TestRunAudit_Checksum(t *testing.T) {
+	client := &mockClient{guests: sampleGuests(2)}
+
+	result, _ := RunAudit(client, AuditOptions{})
+	for _, g := range result.Guests {
+		if g.Checksum == "" || g.Checksum != GuestChecksum(g) {
+			t.Errorf("%s: checksum = %q, want GuestChecksum of the record", g.Username, g.Checksum)
+		}
+	}
+	if result.Guests[0].Checksum == result.Guests[1].Checksum {
+		t.Error("different guests should have different checksums")
+	}
+}
+
+func TestParseDateFlag(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    *time.Time
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"2024-01-01", timePtr(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)), false},
+		{"01/01/2024", nil, true},
+		{"2024-13-01", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseDateFlag("--created-after", tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDateFlag(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && !got.Equal(*tt.want)) {
+				t.Errorf("ParseDateFlag(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreatedInRange(t *testing.T) {
+	jan := timePtr(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	jun := timePtr(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name          string
+		created       *time.Time
+		after, before *time.Time
+		expected      bool
+	}{
+		{"no bounds", jan, nil, nil, true},
+		{"no bounds, no date", nil, nil, nil, true},
+		{"bounded, no date", nil, jan, nil, false},
+		{"after is inclusive", jan, jan, nil, true},
+		{"before is exclusive", jun, nil, jun, false},
+		{"just before", timePtr(jun.Add(-time.Second)), nil, jun, true},
+		{"inside range", timePtr(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)), jan, jun, true},
+		{"before range", timePtr(time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)), jan, jun, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CreatedInRange(tt.created, tt.after, tt.before); got != tt.expected {
+				t.Errorf("CreatedInRange() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRunAudit_CreatedFilters(t *testing.T) {
+	guests := sampleGuests(3)
+	guests[0].CreateAt = time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	guests[1].CreateAt = time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	guests[2].CreateAt = time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+
+	client := &mockClient{guests: guests}
+	result, _ := RunAudit(client, AuditOptions{
+		CreatedAfter:  timePtr(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+		CreatedBefore: timePtr(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)),
+	})
+
+	if len(result.Guests) != 1 || result.Guests[0].Username != "guest1" {
+		t.Fatalf("expected only guest1, got %+v", result.Guests)
+	}
+	if result.Summary.TotalGuests != 1 {
+		t.Errorf("expected total 1, got %d", result.Summary.TotalGuests)
+	}
+}
+
+func TestRunAudit_AuthMethod(t *testing.T) {
+	guests := sampleGuests(3)
+	guests[1].AuthService = model.UserAuthServiceSaml
+	guests[2].AuthService = model.UserAuthServiceLdap
+
+	result, _ := RunAudit(&mockClient{guests: guests}, AuditOptions{})
+	var got []string
+	for _, g := range result.Guests {
+		got = append(got, g.AuthMethod)
+	}
+	if want := []string{"email", "saml", "ldap"}; !slices.Equal(got, want) {
+		t.Errorf("auth methods = %v, want %v", got, want)
+	}
+
+	result, _ = RunAudit(&mockClient{guests: guests}, AuditOptions{AuthMethods: []string{AuthMethodEmail}})
+	if len(result.Guests) != 1 || result.Guests[0].Username != "guest0" {
+		t.Fatalf("expected only guest0, got %+v", result.Guests)
+	}
+}
+
+func TestRunAudit_Match(t *testing.T) {
+	guests := sampleGuests(4)
+	guests[1].Username = "acme.jsmith"
+	guests[2].Email = "pat@acme.example.com"
+	guests[3].FirstName, guests[3].LastName = "Alex", "Acme"
+
+	result, _ := RunAudit(&mockClient{guests: guests}, AuditOptions{Match: regexp.MustCompile(`(?i)acme`)})
+	var got []string
+	for _, g := range result.Guests {
+		got = append(got, g.Username)
+	}
+	if want := []string{"acme.jsmith", "guest2", "guest3"}; !slices.Equal(got, want) {
+		t.Errorf("matched %v, want %v", got, want)
+	}
+	if result.Summary.TotalGuests != 3 {
+		t.Errorf("total_guests = %d, want 3", result.Summary.TotalGuests)
+	}
+}
+
+func TestRunAudit_LocaleTimezone(t *testing.T) {
+	guests := sampleGuests(3)
+	guests[0].Locale = "fr"
+	guests[0].Timezone = model.StringMap{"useAutomaticTimezone": "true", "automaticTimezone": "Europe/Paris", "manualTimezone": "UTC"}
+	guests[1].Locale = "ja"
+	guests[1].Timezone = model.StringMap{"useAutomaticTimezone": "false", "automaticTimezone": "Europe/Paris", "manualTimezone": "Asia/Tokyo"}
+
+	result, _ := RunAudit(&mockClient{guests: guests}, AuditOptions{})
+	tests := []struct{ locale, timezone string }{
+		{"fr", "Europe/Paris"},
+		{"ja", "Asia/Tokyo"},
+		{"", ""}, // never set
+	}
+	for i, tt := range tests {
+		g := result.Guests[i]
+		if g.Locale != tt.locale || g.Timezone != tt.timezone {
+			t.Errorf("%s: locale %q, timezone %q; want %q, %q", g.Username, g.Locale, g.Timezone, tt.locale, tt.timezone)
+		}
+	}
+}
+
+func TestBotSignal(t *testing.T) {
+	tests := []struct {
+		user *model.User
+		want string
+	}{
+		{&model.User{Username: "deploy", IsBot: true}, BotAccount},
+		{&model.User{Username: "jira-bot"}, BotUsername},
+		{&model.User{Username: "Bot.Deploy"}, BotUsername},
+		{&model.User{Username: "svc_ci"}, BotUsername},
+		{&model.User{Username: "ci_bot2"}, BotUsername},
+		{&model.User{Username: "noreply"}, BotUsername},
+		{&model.User{Username: "abbott"}, ""},
+		{&model.User{Username: "robotics.jane"}, ""},
+		{&model.User{Username: "jane.doe"}, ""},
+	}
+	for _, tt := range tests {
+		if got := BotSignal(tt.user); got != tt.want {
+			t.Errorf("BotSignal(%q) = %q, want %q", tt.user.Username, got, tt.want)
+		}
+	}
+
+	guests := sampleGuests(3)
+	guests[1].IsBot = true
+	guests[2].Username = "partner-bot"
+	result, _ := RunAudit(&mockClient{guests: guests}, AuditOptions{})
+	if result.Summary.BotGuests != 2 || result.Guests[0].Bot != "" || result.Guests[1].Bot != BotAccount || result.Guests[2].Bot != BotUsername {
+		t.Errorf("bot_guests = %d, records %+v", result.Summary.BotGuests, result.Guests)
+	}
+	var buf strings.Builder
+	if err := writeTable(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "2 guest(s) look automated (1 bot account(s), 1 named like a service account)") {
+		t.Errorf("table missing the bot line:\n%s", buf.String())
+	}
+}
+
+func TestParseAuthMethods(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"email", "email", false},
+		{"Email, saml,email", "email,saml", false},
+		{"password", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseAuthMethods(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if s := strings.Join(got, ","); s != tt.want {
+				t.Errorf("ParseAuthMethods(%q) = %q, want %q", tt.input, s, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMatch(t *testing.T) {
+	re, err := ParseMatch("")
+	if re != nil || err != nil {
+		t.Errorf("ParseMatch(\"\") = %v, %v; want no filter", re, err)
+	}
+	if _, err := ParseMatch("^vendor-("); err == nil || !strings.Contains(err.Error(), "--match") {
+		t.Errorf("ParseMatch of an invalid pattern: err = %v", err)
+	}
+
+	re, err = ParseMatch(`^ext-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		username, email, displayName string
+		want                         bool
+	}{
+		{"ext-jane", "jane@example.com", "", true},
+		{"jane", "ext-jane@example.com", "", true},
+		{"jane", "jane@example.com", "ext-Jane", true},
+		{"jane.ext-", "jane@example.com", "Jane Doe", false},
+	}
+	for _, tt := range tests {
+		if got := MatchesGuest(re, tt.username, tt.email, tt.displayName); got != tt.want {
+			t.Errorf("MatchesGuest(%q, %q, %q) = %v, want %v", tt.username, tt.email, tt.displayName, got, tt.want)
+		}
+	}
+	if !MatchesGuest(nil, "jane", "", "") {
+		t.Error("a nil pattern should match every guest")
+	}
+}
+
+func TestParseMemberDomains(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"partner.com", "partner.com", false},
+		{"@Partner.com, vendor.io,partner.com", "partner.com,vendor.io", false},
+		{"partner", "", true},
+		{"jane@partner.com", "", true},
+		{"partner.com,", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseMemberDomains(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if s := strings.Join(got, ","); s != tt.want {
+				t.Errorf("ParseMemberDomains(%q) = %q, want %q", tt.input, s, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmailDomainMatches(t *testing.T) {
+	domains := []string{"partner.com"}
+	tests := map[string]bool{
+		"jane@partner.com":    true,
+		"jane@PARTNER.COM":    true,
+		"jane@eu.partner.com": true,
+		"jane@notpartner.com": false,
+		"jane@partner.com.au": false,
+		"partner.com":         false,
+	}
+	for email, want := range tests {
+		if got := EmailDomainMatches(email, domains); got != want {
+			t.Errorf("EmailDomainMatches(%q) = %v, want %v", email, got, want)
+		}
+	}
+}
+
+func TestNewAgeBuckets(t *testing.T) {
+	buckets := NewAgeBuckets([]int{30, 90, 180})
+	var labels []string
+	for _, b := range buckets {
+		labels = append(labels, b.Label)
+	}
+	if got := strings.Join(labels, ","); got != "<30d,30-90d,90-180d,>180d" {
+		t.Errorf("labels = %s", got)
+	}
+	if buckets[3].MaxDays != nil {
+		t.Error("last bucket should be open-ended")
+	}
+}
+
+func TestRunAudit_AgeBuckets(t *testing.T) {
+	now := time.Now()
+	guests := sampleGuests(6)
+	guests[0].LastActivityAt = now.AddDate(0, 0, -5).UnixMilli()
+	guests[1].LastActivityAt = now.AddDate(0, 0, -45).UnixMilli()
+	guests[2].LastActivityAt = now.AddDate(0, 0, -200).UnixMilli()
+	guests[3].LastActivityAt = now.AddDate(0, 0, -200).UnixMilli() // posted recently
+	// guests[4] never active
+	guests[5].DeleteAt = now.UnixMilli() // deactivated guests are not bucketed
+
+	client := &mockClient{
+		guests:       guests,
+		teams:        map[string][]*model.Team{"user3": {{Id: "team1", DisplayName: "Engineering"}}},
+		lastPostDate: map[string]*time.Time{"user3": timePtr(now.AddDate(0, 0, -10))},
+	}
+
+	result, _ := RunAudit(client, AuditOptions{})
+	var got []string
+	for _, b := range result.Summary.AgeBuckets {
+		got = append(got, fmt.Sprintf("%s=%d", b.Label, b.Guests))
+	}
+	if want := "<30d=2,30-90d=1,90-180d=0,>180d=2"; strings.Join(got, ",") != want {
+		t.Errorf("age buckets = %s, want %s", strings.Join(got, ","), want)
+	}
+
+	// Custom boundaries
+	result, _ = RunAudit(client, AuditOptions{AgeBuckets: []int{60}})
+	got = nil
+	for _, b := range result.Summary.AgeBuckets {
+		got = append(got, fmt.Sprintf("%s=%d", b.Label, b.Guests))
+	}
+	if want := "<60d=3,>60d=2"; strings.Join(got, ",") != want {
+		t.Errorf("age buckets = %s, want %s", strings.Join(got, ","), want)
+	}
+}
+
+func TestRunAudit_UnsupportedEnrichment(t *testing.T) {
+	client := &mockClient{
+		guests:         sampleGuests(3),
+		cloud:          true,
+		policyCountErr: &APIError{StatusCode: 501, Message: "not licensed"},
+		userAuditsErr:  &APIError{StatusCode: 403, Message: "forbidden"},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{IdentityHistory: true})
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	if result.Deployment != DeploymentCloud {
+		t.Errorf("deployment = %q, want %q", result.Deployment, DeploymentCloud)
+	}
+
+	want := []string{EnrichRetention, EnrichIdentityHistory}
+	if fmt.Sprint(result.UnavailableEnrichment) != fmt.Sprint(want) {
+		t.Errorf("unavailable enrichment = %v, want %v", result.UnavailableEnrichment, want)
+	}
+	// The audits endpoint is tried once, then skipped for the remaining guests
+	if client.userAuditCalls != 1 {
+		t.Errorf("expected 1 audit call, got %d", client.userAuditCalls)
+	}
+	for _, g := range result.Guests {
+		if g.Failed {
+			t.Errorf("guest %s should not fail: %+v", g.Username, g.Errors)
+		}
+	}
+}
+
+func TestRunAudit_PermissionMissing(t *testing.T) {
+	denied := &APIError{StatusCode: 403, Message: "error: permission denied"}
+	guests := sampleGuests(3)
+	client := &mockClient{
+		guests: guests,
+		teams: map[string][]*model.Team{
+			"user0": {{Id: "team1", DisplayName: "Engineering"}, {Id: "team2", DisplayName: "Sales"}},
+			"user1": {{Id: "team1", DisplayName: "Engineering"}},
+			"user2": {{Id: "team1", DisplayName: "Engineering"}}, // listed by team, unreadable via teamsErr
+		},
+		teamsErr: map[string]error{"user2": denied},
+		channels: map[string][]*model.Channel{
+			"team1:user0": {{Id: "ch1", DisplayName: "General"}},
+			"team1:user1": {{Id: "ch1", DisplayName: "General"}},
+		},
+		channelsErr:   map[string]error{"team2:user0": denied},
+		userAuditsErr: denied,
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{IdentityHistory: true})
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	if fmt.Sprint(result.PermissionMissing) != "[identity_history]" {
+		t.Errorf("permission missing = %v, want [identity_history]", result.PermissionMissing)
+	}
+
+	want := map[string]string{
+		"guest0": "channels,previous_usernames,previous_emails",
+		"guest1": "previous_usernames,previous_emails",
+		"guest2": "teams,channels,previous_usernames,previous_emails",
+	}
+	if len(result.Guests) != 3 {
+		t.Fatalf("expected 3 guests, got %d", len(result.Guests))
+	}
+	for _, g := range result.Guests {
+		if g.Failed {
+			t.Errorf("guest %s should not fail: %+v", g.Username, g.Errors)
+		}
+		if got := strings.Join(g.PermissionMissing, ","); got != want[g.Username] {
+			t.Errorf("%s permission missing = %q, want %q", g.Username, got, want[g.Username])
+		}
 	}
-	if result.Guests[0].Username != "bob.smith" {
-		t.Errorf("expected bob.smith, got %s", result.Guests[0].Username)
+	// The channel the token could read is still reported
+	if len(result.Guests[0].Channels) != 1 {
+		t.Errorf("guest0 channels = %+v, want General only", result.Guests[0].Channels)
+	}
+
+	// With a team filter, a guest whose teams cannot be read still fails
+	client.teamByName = map[string]*model.Team{"Engineering": {Id: "team1", DisplayName: "Engineering"}}
+	result, exitCode = RunAudit(client, AuditOptions{TeamFilter: "Engineering"})
+	if exitCode != ExitPartialFailure || result.Summary.FailedLookups != 1 {
+		t.Errorf("exit code = %d, failed lookups = %d; want %d, 1", exitCode, result.Summary.FailedLookups, ExitPartialFailure)
 	}
 }
 
-func TestRunAudit_TeamFilterNotFound(t *testing.T) {
+func TestRunAudit_TransientEnrichmentErrorNotDisabled(t *testing.T) {
 	client := &mockClient{
-		teamByName: map[string]*model.Team{},
+		guests:        sampleGuests(2),
+		userAuditsErr: &APIError{StatusCode: 503, Message: "unavailable"},
 	}
 
-	result, exitCode := RunAudit(client, "NonExistent", "", 0, false)
-
-	if exitCode != ExitConfigError {
-		t.Errorf("expected exit code %d, got %d", ExitConfigError, exitCode)
+	result, _ := RunAudit(client, AuditOptions{IdentityHistory: true})
+	if result.Deployment != DeploymentSelfHosted {
+		t.Errorf("deployment = %q, want %q", result.Deployment, DeploymentSelfHosted)
 	}
-	if result != nil {
-		t.Error("expected nil result for unknown team")
+	if len(result.UnavailableEnrichment) != 0 {
+		t.Errorf("expected no unavailable enrichment, got %v", result.UnavailableEnrichment)
 	}
-}
-
-func TestRunAudit_Pagination(t *testing.T) {
-	// Create 250 guests (page 0: 200, page 1: 50)
-	guests := make([]*model.User, 250)
-	teams := make(map[string][]*model.Team)
-	channels := make(map[string][]*model.Channel)
-	for i := 0; i < 250; i++ {
-		id := fmt.Sprintf("user%d", i)
-		guests[i] = &model.User{
-			Id:       id,
-			Username: fmt.Sprintf("guest%d", i),
-			Email:    fmt.Sprintf("guest%d@example.com", i),
-			CreateAt: 1709280000000,
-		}
-		teams[id] = []*model.Team{{Id: "team1", DisplayName: "Engineering"}}
-		channels["team1:"+id] = []*model.Channel{{Id: "ch1", DisplayName: "General"}}
+	if client.userAuditCalls != 2 {
+		t.Errorf("expected audits to be tried for each guest, got %d calls", client.userAuditCalls)
 	}
+}
 
+func TestRunAudit_TimeoutIsGuestError(t *testing.T) {
+	timeout := &APIError{Message: "error: API request timed out", Err: context.DeadlineExceeded}
 	client := &mockClient{
-		guests:   guests,
-		teams:    teams,
-		channels: channels,
+		guests:          sampleGuests(2),
+		teams:           map[string][]*model.Team{"user0": {{Id: "team1", DisplayName: "Engineering"}}, "user1": {{Id: "team1", DisplayName: "Engineering"}}},
+		lastPostDateErr: map[string]error{"user0": timeout},
 	}
 
-	result, exitCode := RunAudit(client, "", "", 0, false)
-
-	if exitCode != ExitSuccess {
-		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	result, exitCode := RunAudit(client, AuditOptions{InactiveDays: 30})
+	if exitCode != ExitPartialFailure {
+		t.Errorf("expected exit code %d, got %d", ExitPartialFailure, exitCode)
 	}
-	if len(result.Guests) != 250 {
-		t.Errorf("expected 250 guests, got %d", len(result.Guests))
+	if len(result.Guests) != 2 {
+		t.Fatalf("expected the audit to carry on past the timeout, got %d guests", len(result.Guests))
+	}
+	g := result.Guests[0]
+	if !g.Failed || len(g.Errors) != 1 || g.Errors[0].Stage != LookupLastPost || g.Errors[0].HTTPStatus != 0 || g.Inactive {
+		t.Errorf("timed-out guest should be failed, not inactive: %+v", g)
+	}
+	if result.Guests[1].Failed || result.Summary.FailedLookups != 1 {
+		t.Errorf("only the timed-out guest should fail: %+v", result.Summary)
 	}
 }
 
-func TestRunAudit_EmptyResult(t *testing.T) {
+func TestRunAudit_LicenseSeats(t *testing.T) {
+	guests := sampleGuests(4)
+	guests[0].LastActivityAt = model.GetMillis() // the others never logged in
+	guests[3].DeleteAt = 1                       // deactivated, holds no seat
 	client := &mockClient{
-		guests: []*model.User{},
+		guests:     guests,
+		serverInfo: ServerInfo{LicensedSeats: 500},
 	}
 
-	result, exitCode := RunAudit(client, "", "", 0, false)
+	result, _ := RunAudit(client, AuditOptions{InactiveDays: 30})
+	l := result.Summary.License
+	if l.LicensedSeats == nil || *l.LicensedSeats != 500 || l.GuestSeats != 3 || l.FreeableSeats != 2 {
+		t.Errorf("license = %+v, want 500 licensed, 3 guest, 2 freeable", l)
+	}
+
+	// Without a license the seat count is unknown, not zero
+	client.serverInfo = ServerInfo{}
+	result, _ = RunAudit(client, AuditOptions{InactiveDays: 30})
+	if result.Summary.License.LicensedSeats != nil || result.Summary.License.GuestSeats != 3 {
+		t.Errorf("license = %+v, want unknown licensed seats", result.Summary.License)
+	}
+}
 
+func TestRunAudit_NeverLoggedIn(t *testing.T) {
+	guests := sampleGuests(3)
+	guests[0].LastActivityAt = model.GetMillis()
+	client := &mockClient{guests: guests}
+
+	// Every guest is counted, whichever are listed
+	result, _ := RunAudit(client, AuditOptions{})
+	if result.Summary.NeverLoggedInGuests != 2 {
+		t.Errorf("never logged in = %d, want 2", result.Summary.NeverLoggedInGuests)
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{NeverLoggedIn: true})
 	if exitCode != ExitSuccess {
 		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
 	}
-	if len(result.Guests) != 0 {
-		t.Errorf("expected 0 guests, got %d", len(result.Guests))
+	if len(result.Guests) != 2 || result.Guests[0].Username != "guest1" || result.Guests[1].Username != "guest2" {
+		t.Errorf("expected guest1 and guest2, got %+v", result.Guests)
+	}
+	if result.Guests[0].Inactive {
+		t.Error("guests should not be flagged inactive without --inactive-days")
 	}
 }
 
-func TestRunAudit_PartialFailure(t *testing.T) {
-	now := time.Now()
-	loginTime := now.AddDate(0, 0, -5)
+func TestRunAudit_UnverifiedOnly(t *testing.T) {
+	guests := sampleGuests(3)
+	guests[0].EmailVerified = true
+	guests[2].EmailVerified = true
+	client := &mockClient{guests: guests}
+
+	result, _ := RunAudit(client, AuditOptions{})
+	if result.Summary.UnverifiedGuests != 1 {
+		t.Errorf("unverified = %d, want 1", result.Summary.UnverifiedGuests)
+	}
+	if !result.Guests[0].EmailVerified || result.Guests[1].EmailVerified {
+		t.Errorf("email_verified not copied from the user: %+v", result.Guests)
+	}
+
+	result, _ = RunAudit(client, AuditOptions{UnverifiedOnly: true})
+	if len(result.Guests) != 1 || result.Guests[0].Username != "guest1" {
+		t.Errorf("expected only guest1, got %+v", result.Guests)
+	}
+}
 
+func TestRunAudit_ChannelCountRange(t *testing.T) {
+	channel := func(id string, typ model.ChannelType) *model.Channel {
+		return &model.Channel{Id: id, Name: id, DisplayName: id, Type: typ}
+	}
 	client := &mockClient{
-		guests: []*model.User{
-			{Id: "user1", Username: "jane.doe", FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
-			{Id: "user2", Username: "bob.smith", FirstName: "Bob", LastName: "Smith", Email: "bob@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
-		},
+		guests: sampleGuests(3),
 		teams: map[string][]*model.Team{
-			"user1": {{Id: "team1", DisplayName: "Engineering"}},
-		},
-		teamsErr: map[string]error{
-			"user2": fmt.Errorf("error: API request failed (HTTP 500)"),
+			"user0": {{Id: "team1", Name: "engineering", DisplayName: "Engineering"}},
+			"user1": {{Id: "team1", Name: "engineering", DisplayName: "Engineering"}},
 		},
 		channels: map[string][]*model.Channel{
-			"team1:user1": {{Id: "ch1", DisplayName: "General"}},
+			// Direct and group messages are not counted
+			"team1:user0": {channel("ch1", model.ChannelTypeOpen), channel("ch2", model.ChannelTypePrivate), channel("dm1", model.ChannelTypeDirect)},
+			"team1:user1": {channel("gm1", model.ChannelTypeGroup)},
 		},
 	}
+	zero := 0
 
-	result, exitCode := RunAudit(client, "", "", 0, false)
+	tests := []struct {
+		name string
+		opts AuditOptions
+		want string
+	}{
+		{"no bounds", AuditOptions{}, "guest0,guest1,guest2"},
+		{"min", AuditOptions{MinChannels: 2}, "guest0"},
+		{"max zero", AuditOptions{MaxChannels: &zero}, "guest1,guest2"},
+		{"min above all", AuditOptions{MinChannels: 3}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, exitCode := RunAudit(client, tt.opts)
+			if exitCode != ExitSuccess {
+				t.Fatalf("exit code = %d, want %d", exitCode, ExitSuccess)
+			}
+			var names []string
+			for _, g := range result.Guests {
+				names = append(names, g.Username)
+			}
+			if got := strings.Join(names, ","); got != tt.want {
+				t.Errorf("guests = %s, want %s", got, tt.want)
+			}
+		})
+	}
 
-	if exitCode != ExitPartialFailure {
-		t.Errorf("expected exit code %d, got %d", ExitPartialFailure, exitCode)
+	result, _ := RunAudit(client, AuditOptions{})
+	if got := result.Guests[0].ChannelCount; got != 2 {
+		t.Errorf("guest0 channel_count = %d, want 2", got)
 	}
-	if len(result.Guests) != 2 {
-		t.Fatalf("expected 2 guests, got %d", len(result.Guests))
+}
+
+func TestRunAudit_GuestOnlyChannels(t *testing.T) {
+	eng := &model.Team{Id: "team1", Name: "engineering", DisplayName: "Engineering"}
+	partners := &model.Channel{Id: "ch1", DisplayName: "Partners", Type: model.ChannelTypePrivate}
+	general := &model.Channel{Id: "ch2", DisplayName: "General", Type: model.ChannelTypeOpen}
+	dm := &model.Channel{Id: "dm1", DisplayName: "guest0, guest1", Type: model.ChannelTypeDirect}
+	newClient := func() *mockClient {
+		return &mockClient{
+			guests: sampleGuests(2),
+			teams:  map[string][]*model.Team{"user0": {eng}, "user1": {eng}},
+			channels: map[string][]*model.Channel{
+				"team1:user0": {partners, general, dm},
+				"team1:user1": {partners, dm},
+				// An internal user, who is not listed as a guest
+				"team1:alice": {general},
+			},
+		}
 	}
-	if result.Summary.FailedLookups != 1 {
-		t.Errorf("expected 1 failed lookup, got %d", result.Summary.FailedLookups)
+
+	client := newClient()
+	result, exitCode := RunAudit(client, AuditOptions{GuestOnly: true})
+	if exitCode != ExitSuccess {
+		t.Fatalf("exit code = %d, want %d", exitCode, ExitSuccess)
 	}
-	if result.Guests[1].Error == "" {
-		t.Error("expected error message on failed guest")
+	want := []ResourceInfo{{TeamName: "Engineering", Name: "Partners"}}
+	for _, g := range result.Guests {
+		if !slices.Equal(g.GuestOnlyChannels, want) {
+			t.Errorf("%s: guest-only channels = %+v, want %+v", g.Username, g.GuestOnlyChannels, want)
+		}
+	}
+	if result.Summary.GuestOnlyChannels != 1 {
+		t.Errorf("summary guest-only channels = %d, want 1", result.Summary.GuestOnlyChannels)
+	}
+	// Each team channel is read once, and DMs not at all
+	if client.memberCalls != 2 {
+		t.Errorf("member lookups = %d, want 2", client.memberCalls)
+	}
+
+	// Not looked up unless asked for
+	client = newClient()
+	result, _ = RunAudit(client, AuditOptions{})
+	if client.memberCalls != 0 || result.Guests[0].GuestOnlyChannels != nil {
+		t.Errorf("without GuestOnly: %d lookups, channels %+v", client.memberCalls, result.Guests[0].GuestOnlyChannels)
+	}
+
+	// A server rejecting the call switches the check off
+	client = newClient()
+	client.membersErr = map[string]error{"ch2": &APIError{StatusCode: 403, Message: "forbidden"}}
+	result, _ = RunAudit(client, AuditOptions{GuestOnly: true})
+	if client.memberCalls != 1 || !slices.Contains(result.PermissionMissing, EnrichGuestOnly) {
+		t.Errorf("after a 403: %d lookups, permission_missing %v", client.memberCalls, result.PermissionMissing)
+	}
+	if !slices.Contains(result.Guests[1].PermissionMissing, "guest_only_channels") {
+		t.Errorf("guest1 permission_missing = %v, want guest_only_channels", result.Guests[1].PermissionMissing)
 	}
 }
 
-func TestRunAudit_InactivityFlagging(t *testing.T) {
-	now := time.Now()
+func TestRunAudit_Groups(t *testing.T) {
+	eng := &model.Team{Id: "team1", Name: "engineering", DisplayName: "Engineering"}
+	townSquare := &model.Channel{Id: "ch0", Name: model.DefaultChannelName, DisplayName: "Town Square", Type: model.ChannelTypeOpen}
+	partners := &model.Channel{Id: "ch1", DisplayName: "Partners", Type: model.ChannelTypePrivate}
+	general := &model.Channel{Id: "ch2", DisplayName: "General", Type: model.ChannelTypeOpen}
+	dm := &model.Channel{Id: "dm1", DisplayName: "guest0, guest1", Type: model.ChannelTypeDirect}
+	contractors := &model.Group{Id: "g1", DisplayName: "Contractors", Source: model.GroupSourceLdap}
+	vendors := &model.Group{Id: "g2", DisplayName: "Vendors", Source: model.GroupSourceLdap}
+	reviewers := &model.Group{Id: "g3", DisplayName: "Reviewers", Source: model.GroupSourceCustom}
+	newClient := func() *mockClient {
+		return &mockClient{
+			guests: sampleGuests(2),
+			teams:  map[string][]*model.Team{"user0": {eng}, "user1": {eng}},
+			channels: map[string][]*model.Channel{
+				"team1:user0": {townSquare, partners, general, dm},
+				"team1:user1": {partners, general},
+			},
+			groups: map[string][]*model.Group{
+				"user0": {vendors, contractors, reviewers},
+				"user1": {contractors},
+			},
+			groupChannels: map[string][]string{"g1": {"ch1"}, "g2": {"ch2"}},
+		}
+	}
 
+	client := newClient()
+	result, exitCode := RunAudit(client, AuditOptions{Groups: true})
+	if exitCode != ExitSuccess {
+		t.Fatalf("exit code = %d, want %d", exitCode, ExitSuccess)
+	}
+	g0, g1 := result.Guests[0], result.Guests[1]
+	if !slices.Equal(g0.Groups, []string{"Contractors", "Reviewers", "Vendors"}) || g0.DirectChannels != nil {
+		t.Errorf("guest0: groups %v, direct channels %+v", g0.Groups, g0.DirectChannels)
+	}
+	want := []ResourceInfo{{TeamName: "Engineering", Name: "General"}}
+	if !slices.Equal(g1.Groups, []string{"Contractors"}) || !slices.Equal(g1.DirectChannels, want) {
+		t.Errorf("guest1: groups %v, direct channels %+v, want %+v", g1.Groups, g1.DirectChannels, want)
+	}
+	if result.Summary.DirectMemberGuests != 1 {
+		t.Errorf("direct member guests = %d, want 1", result.Summary.DirectMemberGuests)
+	}
+	// Each LDAP group's channels are read once, custom groups' not at all
+	if client.syncCalls != 2 {
+		t.Errorf("group channel lookups = %d, want 2", client.syncCalls)
+	}
+
+	// A server without group support switches the check off
+	client = newClient()
+	client.groupsErr = &APIError{StatusCode: 501, Message: "not implemented"}
+	result, _ = RunAudit(client, AuditOptions{Groups: true})
+	if !slices.Contains(result.UnavailableEnrichment, EnrichGroups) || result.Guests[1].Groups != nil || result.Summary.DirectMemberGuests != 0 {
+		t.Errorf("after a 501: unavailable %v, guest1 %+v", result.UnavailableEnrichment, result.Guests[1])
+	}
+}
+
+func TestRunAudit_MembersWithDomain(t *testing.T) {
+	guests := sampleGuests(2)
+	guests[0].Email = "guest0@partner.com"
 	client := &mockClient{
-		guests: []*model.User{
-			{Id: "user1", Username: "active.user", Email: "a@example.com", CreateAt: 1709280000000, LastActivityAt: now.AddDate(0, 0, -5).UnixMilli()},
-			{Id: "user2", Username: "inactive.user", Email: "b@example.com", CreateAt: 1709280000000, LastActivityAt: now.AddDate(0, 0, -45).UnixMilli()},
-			{Id: "user3", Username: "never.login", Email: "c@example.com", CreateAt: 1709280000000, LastActivityAt: 0},
+		guests: guests,
+		members: []*model.User{
+			{Id: "member1", Username: "alice", Email: "alice@partner.com", Roles: "system_user"},
+			{Id: "member2", Username: "bob", Email: "bob@example.com", Roles: "system_user"},
+			{Id: "member3", Username: "carol", Email: "carol@eu.partner.com", Roles: "system_user", DeleteAt: 1},
+			{Id: "bot1", Username: "partner-bot", Email: "bot@partner.com", Roles: "system_user", IsBot: true},
+			guests[0], // a guest never appears twice
 		},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{MemberDomains: []string{"partner.com"}, InactiveDays: 30})
+	if exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	var members []string
+	for _, g := range result.Guests {
+		if g.ShouldBeGuest {
+			members = append(members, g.Username)
+		}
+	}
+	if strings.Join(members, ",") != "alice,carol" {
+		t.Errorf("should be guest = %v, want [alice carol]", members)
+	}
+	if len(result.Guests) != 4 {
+		t.Errorf("expected 2 guests and 2 members, got %d records", len(result.Guests))
+	}
+
+	// Members are counted on their own, not as guests or guest seats
+	s := result.Summary
+	if s.MembersShouldBeGuests != 2 || s.TotalGuests != 2 {
+		t.Errorf("members = %d, total guests = %d, want 2 and 2", s.MembersShouldBeGuests, s.TotalGuests)
+	}
+	if s.InactiveGuests != 2 || s.DeactivatedGuests != 0 || s.License.GuestSeats != 2 {
+		t.Errorf("guest counts include members: %+v", s)
+	}
+
+	// Without the flag no members are listed
+	result, _ = RunAudit(client, AuditOptions{})
+	if len(result.Guests) != 2 || result.Summary.MembersShouldBeGuests != 0 {
+		t.Errorf("expected only the 2 guests, got %d records", len(result.Guests))
+	}
+}
+
+func TestRunAudit_PluginAccess(t *testing.T) {
+	client := &mockClient{
+		guests: sampleGuests(3),
 		teams: map[string][]*model.Team{
+			"user0": {{Id: "team1", DisplayName: "Engineering"}, {Id: "team2", DisplayName: "Sales"}},
 			"user1": {{Id: "team1", DisplayName: "Engineering"}},
-			"user2": {{Id: "team1", DisplayName: "Engineering"}},
-			"user3": {{Id: "team1", DisplayName: "Engineering"}},
+			"user2": {{Id: "team2", DisplayName: "Sales"}},
 		},
-		channels: map[string][]*model.Channel{
-			"team1:user1": {{Id: "ch1", DisplayName: "General"}},
-			"team1:user2": {{Id: "ch1", DisplayName: "General"}},
-			"team1:user3": {{Id: "ch1", DisplayName: "General"}},
+		boardMembers: map[string]map[string][]string{
+			"team1": {"user0": {"Roadmap"}, "user1": {"Roadmap", "Bugs"}},
+			"team2": {"user0": {"Pipeline"}},
 		},
+		playbooksErr: &APIError{StatusCode: 404, Message: "plugin not found"},
 	}
 
-	result, exitCode := RunAudit(client, "", "", 30, false)
-
+	result, exitCode := RunAudit(client, AuditOptions{PluginAccess: true})
 	if exitCode != ExitSuccess {
 		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
 	}
 
-	// Active user — not inactive
-	if result.Guests[0].Inactive {
-		t.Error("user1 should not be inactive")
-	}
-	// Inactive user — 45 days > 30 threshold
-	if !result.Guests[1].Inactive {
-		t.Error("user2 should be inactive")
-	}
-	// Never logged in — should be inactive
-	if !result.Guests[2].Inactive {
-		t.Error("user3 (never logged in) should be inactive")
+	want := map[string][]ResourceInfo{
+		"guest0": {{TeamName: "Engineering", Name: "Roadmap"}, {TeamName: "Sales", Name: "Pipeline"}},
+		"guest1": {{TeamName: "Engineering", Name: "Roadmap"}, {TeamName: "Engineering", Name: "Bugs"}},
+		"guest2": nil,
+	}
+	for _, g := range result.Guests {
+		if fmt.Sprint(g.Boards) != fmt.Sprint(want[g.Username]) {
+			t.Errorf("%s boards = %v, want %v", g.Username, g.Boards, want[g.Username])
+		}
+		if len(g.Playbooks) != 0 {
+			t.Errorf("%s: expected no playbooks, got %v", g.Username, g.Playbooks)
+		}
 	}
 
-	if result.Summary.ActiveGuests != 1 {
-		t.Errorf("expected 1 active guest, got %d", result.Summary.ActiveGuests)
+	// Boards are fetched once per team; playbooks once before being disabled
+	if client.pluginCalls != 3 {
+		t.Errorf("expected 3 plugin calls, got %d", client.pluginCalls)
 	}
-	if result.Summary.InactiveGuests != 2 {
-		t.Errorf("expected 2 inactive guests, got %d", result.Summary.InactiveGuests)
+	if fmt.Sprint(result.UnavailableEnrichment) != fmt.Sprint([]string{EnrichPlaybooks}) {
+		t.Errorf("unavailable enrichment = %v, want [%s]", result.UnavailableEnrichment, EnrichPlaybooks)
 	}
 }
 
-func TestRunAudit_ChannelFilter(t *testing.T) {
+func TestRunAudit_SummaryByTeam(t *testing.T) {
 	now := time.Now()
-	loginTime := now.AddDate(0, 0, -5)
+	guests := sampleGuests(3)
+	guests[0].LastActivityAt = now.UnixMilli()
+	guests[2].DeleteAt = now.UnixMilli()
 
 	client := &mockClient{
-		guests: []*model.User{
-			{Id: "user1", Username: "jane.doe", FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
-			{Id: "user2", Username: "bob.smith", FirstName: "Bob", LastName: "Smith", Email: "bob@example.com", CreateAt: 1709280000000, LastActivityAt: loginTime.UnixMilli()},
-		},
-		teamByName: map[string]*model.Team{
-			"Engineering": {Id: "team1", DisplayName: "Engineering"},
-		},
-		channelByName: map[string]*model.Channel{
-			"team1:dev-backend": {Id: "ch2", DisplayName: "Dev Backend", TeamId: "team1"},
-		},
+		guests: guests,
 		teams: map[string][]*model.Team{
+			"user0": {{Id: "team1", DisplayName: "Engineering"}, {Id: "team2", DisplayName: "Sales"}},
 			"user1": {{Id: "team1", DisplayName: "Engineering"}},
-			"user2": {{Id: "team1", DisplayName: "Engineering"}},
-		},
-		channels: map[string][]*model.Channel{
-			"team1:user1": {{Id: "ch1", DisplayName: "General"}, {Id: "ch2", DisplayName: "Dev Backend"}},
-			"team1:user2": {{Id: "ch1", DisplayName: "General"}},
-		},
-		lastPostDate: map[string]*time.Time{
-			"user1": timePtr(now.AddDate(0, 0, -2)),
+			"user2": {{Id: "team2", DisplayName: "Sales"}},
 		},
 	}
 
-	result, exitCode := RunAudit(client, "Engineering", "dev-backend", 0, false)
+	result, _ := RunAudit(client, AuditOptions{InactiveDays: 30})
 
-	if exitCode != ExitSuccess {
-		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	want := map[string]TeamSummary{
+		"Engineering": {TotalGuests: 2, ActiveGuests: 1, InactiveGuests: 1},
+		"Sales":       {TotalGuests: 2, ActiveGuests: 1, DeactivatedGuests: 1},
 	}
-	// Only jane.doe is in dev-backend
-	if len(result.Guests) != 1 {
-		t.Fatalf("expected 1 guest in dev-backend channel, got %d", len(result.Guests))
+	if len(result.Summary.ByTeam) != len(want) {
+		t.Fatalf("expected %d teams, got %d", len(want), len(result.Summary.ByTeam))
 	}
-	if result.Guests[0].Username != "jane.doe" {
-		t.Errorf("expected jane.doe, got %s", result.Guests[0].Username)
+	for name, w := range want {
+		if got := result.Summary.ByTeam[name]; got == nil || *got != w {
+			t.Errorf("ByTeam[%s] = %+v, want %+v", name, got, w)
+		}
 	}
-	// Only the filtered channel should appear
-	if len(result.Guests[0].Channels) != 1 {
-		t.Fatalf("expected 1 channel, got %d", len(result.Guests[0].Channels))
+}
+
+// Helper functions
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+// sampleGuests returns n guest users with no team memberships.
+func sampleGuests(n int) []*model.User {
+	guests := make([]*model.User, n)
+	for i := range guests {
+		guests[i] = &model.User{
+			Id:       fmt.Sprintf("user%d", i),
+			Username: fmt.Sprintf("guest%d", i),
+			Email:    fmt.Sprintf("guest%d@partner.example.com", i),
+			CreateAt: 1709280000000,
+		}
 	}
-	if result.Guests[0].Channels[0].ChannelName != "Dev Backend" {
-		t.Errorf("expected channel 'Dev Backend', got %q", result.Guests[0].Channels[0].ChannelName)
+	return guests
+}
+
+func TestIsExpired(t *testing.T) {
+	now := time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(d int) *time.Time {
+		t := now.AddDate(0, 0, -d)
+		return &t
+	}
+	tests := []struct {
+		name      string
+		createdAt *time.Time
+		active    bool
+		maxAge    int
+		want      bool
+	}{
+		{"older than max age", daysAgo(400), true, 365, true},
+		{"within max age", daysAgo(200), true, 365, false},
+		{"deactivated", daysAgo(400), false, 365, false},
+		{"unknown creation date", nil, true, 365, false},
+		{"check off", daysAgo(400), true, 0, false},
+	}
+	for _, tt := range tests {
+		if got := IsExpired(tt.createdAt, tt.active, tt.maxAge, now); got != tt.want {
+			t.Errorf("%s: IsExpired = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if got := AccountAgeDays(daysAgo(400), now); got == nil || *got != 400 {
+		t.Errorf("AccountAgeDays = %v, want 400", got)
+	}
+	if got := AccountAgeDays(nil, now); got != nil {
+		t.Errorf("AccountAgeDays(nil) = %d, want nil", *got)
 	}
 }
 
-func TestRunAudit_ChannelFilterNotFound(t *testing.T) {
+func TestRunAudit_MinAgeAndIdleDays(t *testing.T) {
+	now := time.Now()
+	guests := sampleGuests(4)
+	guests[0].CreateAt = now.AddDate(0, 0, -10).UnixMilli()
+	guests[1].LastActivityAt = now.AddDate(0, 0, -3).UnixMilli()
+	guests[2].LastActivityAt = now.AddDate(0, 0, -100).UnixMilli()
+	guests[3].LastActivityAt = now.AddDate(0, 0, -100).UnixMilli()
+	teams := make(map[string][]*model.Team)
+	for _, u := range guests {
+		teams[u.Id] = []*model.Team{{Id: "team1", DisplayName: "Engineering"}}
+	}
 	client := &mockClient{
-		teamByName: map[string]*model.Team{
-			"Engineering": {Id: "team1", DisplayName: "Engineering"},
-		},
-		channelByName: map[string]*model.Channel{},
+		guests:       guests,
+		teams:        teams,
+		lastPostDate: map[string]*time.Time{"user3": timePtr(now.AddDate(0, 0, -20))},
 	}
 
-	result, exitCode := RunAudit(client, "Engineering", "nonexistent-channel", 0, false)
+	result, _ := RunAudit(client, AuditOptions{})
+	if d := result.Guests[3].DaysSinceLastActivity; d == nil || *d != 20 {
+		t.Errorf("days_since_last_activity = %v, want 20 from the last post", d)
+	}
+	if d := result.Guests[0].DaysSinceLastActivity; d != nil {
+		t.Errorf("never active: days_since_last_activity = %d, want nil", *d)
+	}
 
-	if exitCode != ExitConfigError {
-		t.Errorf("expected exit code %d, got %d", ExitConfigError, exitCode)
+	// guest0 is too new; guest1 logged in and guest3 posted too recently
+	result, _ = RunAudit(client, AuditOptions{MinAgeDays: 30, MinIdleDays: 60})
+	if len(result.Guests) != 1 || result.Guests[0].Username != "guest2" {
+		t.Errorf("expected only guest2, got %+v", result.Guests)
 	}
-	if result != nil {
-		t.Error("expected nil result for unknown channel")
+
+	// A guest who has never been active is as idle as can be
+	result, _ = RunAudit(client, AuditOptions{MinIdleDays: 60})
+	if len(result.Guests) != 2 || result.Guests[0].Username != "guest0" {
+		t.Errorf("expected guest0 and guest2, got %+v", result.Guests)
 	}
 }
 
-// Helper function
-func timePtr(t time.Time) *time.Time {
-	return &t
+func TestRunAudit_MaxGuestAge(t *testing.T) {
+	guests := sampleGuests(3)
+	guests[0].CreateAt = time.Now().AddDate(0, 0, -30).UnixMilli()
+	guests[1].CreateAt = time.Now().AddDate(-2, 0, 0).UnixMilli()
+	guests[2].CreateAt = time.Now().AddDate(-2, 0, 0).UnixMilli()
+	guests[2].DeleteAt = time.Now().UnixMilli()
+
+	result, exitCode := RunAudit(&mockClient{guests: guests}, AuditOptions{MaxGuestAge: 365})
+	if exitCode != ExitSuccess {
+		t.Fatalf("exit code = %d, want %d", exitCode, ExitSuccess)
+	}
+	for i, want := range []bool{false, true, false} {
+		if g := result.Guests[i]; g.Expired != want {
+			t.Errorf("%s: expired = %v, want %v", g.Username, g.Expired, want)
+		}
+	}
+	if age := result.Guests[0].AgeDays; age == nil || *age != 30 {
+		t.Errorf("age_days = %v, want 30", age)
+	}
+	if result.Summary.ExpiredGuests != 1 || result.MaxGuestAge != 365 {
+		t.Errorf("expired_guests = %d, max_guest_age = %d; want 1 and 365", result.Summary.ExpiredGuests, result.MaxGuestAge)
+	}
+
+	// The age is reported without --max-guest-age too
+	result, _ = RunAudit(&mockClient{guests: guests}, AuditOptions{})
+	if g := result.Guests[1]; g.AgeDays == nil || g.Expired {
+		t.Errorf("without --max-guest-age: age_days = %v, expired = %v", g.AgeDays, g.Expired)
+	}
+}
+
+func TestRunAudit_MaxPasswordAge(t *testing.T) {
+	guests := sampleGuests(4)
+	guests[0].LastPasswordUpdate = time.Now().AddDate(0, 0, -30).UnixMilli()
+	guests[1].LastPasswordUpdate = time.Now().AddDate(-1, 0, 0).UnixMilli()
+	guests[2].LastPasswordUpdate = time.Now().AddDate(-1, 0, 0).UnixMilli()
+	guests[2].AuthService = model.UserAuthServiceSaml
+	guests[3].LastPasswordUpdate = time.Now().AddDate(-1, 0, 0).UnixMilli()
+	guests[3].DeleteAt = time.Now().UnixMilli()
+
+	result, _ := RunAudit(&mockClient{guests: guests}, AuditOptions{MaxPasswordAge: 180})
+	for i, want := range []bool{false, true, false, false} {
+		if g := result.Guests[i]; g.PasswordExpired != want {
+			t.Errorf("%s: password_expired = %v, want %v", g.Username, g.PasswordExpired, want)
+		}
+	}
+	if age := result.Guests[0].PasswordAgeDays; age == nil || *age != 30 {
+		t.Errorf("password_age_days = %v, want 30", age)
+	}
+	// SAML guests have no password to age
+	if g := result.Guests[2]; g.PasswordUpdatedAt != nil || g.PasswordAgeDays != nil {
+		t.Errorf("SAML guest has a password age: %v", g.PasswordAgeDays)
+	}
+	if result.Summary.ExpiredPasswords != 1 || result.MaxPasswordAge != 180 {
+		t.Errorf("expired_passwords = %d, max_password_age = %d; want 1 and 180", result.Summary.ExpiredPasswords, result.MaxPasswordAge)
+	}
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// auditActionNames names the audited API calls that show a guest using
+// their account, matched on the end of the request path. Anything else is
+// reported by its path.
+var auditActionNames = []struct{ suffix, name string }{
+	{"/users/login", "login"},
+	{"/users/login/switch", "login"},
+	{"/users/logout", "logout"},
+	{"/password", "password change"},
+	{"/mfa", "mfa change"},
+}
+
+// LastAuditedAction returns the newest of a guest's audit records that
+// shows them acting, named by AuditActionName, and when it was made. Login
+// attempts and failures are skipped: anyone who knows the login ID can make
+// them. Both results are empty when no record qualifies.
+func LastAuditedAction(audits []model.Audit) (string, *time.Time) {
+	var newest *model.Audit
+	for i, a := range audits {
+		if a.Action == "" || !auditSucceeded(a.ExtraInfo) {
+			continue
+		}
+		if newest == nil || a.CreateAt > newest.CreateAt {
+			newest = &audits[i]
+		}
+	}
+	if newest == nil {
+		return "", nil
+	}
+	return AuditActionName(newest.Action), MillisToTime(newest.CreateAt)
+}
+
+// ChannelJoinTimes returns when the guest joined each channel, by channel
+// ID, from the channel joins among their audit records. The newest join is
+// kept, so a guest who left and came back is dated from their return.
+// Mattermost files an addition under the user who made it, so channels the
+// guest was added to by someone else are not found; nor are joins older
+// than the records the server keeps or the maxAuditRecords scanned.
+func ChannelJoinTimes(audits []model.Audit) map[string]time.Time {
+	joins := make(map[string]time.Time)
+	for _, a := range audits {
+		if AuditActionName(a.Action) != "channel join" || !auditSucceeded(a.ExtraInfo) {
+			continue
+		}
+		_, rest, _ := strings.Cut(strings.SplitN(a.Action, "?", 2)[0], "/channels/")
+		id, _, _ := strings.Cut(rest, "/")
+		at := MillisToTime(a.CreateAt)
+		if id == "" || at == nil {
+			continue
+		}
+		if prev, ok := joins[id]; !ok || at.After(prev) {
+			joins[id] = *at
+		}
+	}
+	return joins
+}
+
+// AuditActionName turns an audited request path such as
+// /api/v4/channels/<id>/members into a short name such as "channel join".
+func AuditActionName(action string) string {
+	path := strings.TrimRight(strings.SplitN(action, "?", 2)[0], "/")
+	if strings.HasSuffix(path, "/members") {
+		switch {
+		case strings.Contains(path, "/channels/"):
+			return "channel join"
+		case strings.Contains(path, "/teams/"):
+			return "team join"
+		}
+	}
+	for _, a := range auditActionNames {
+		if strings.HasSuffix(path, a.suffix) {
+			return a.name
+		}
+	}
+	return action
+}
+
+// auditSucceeded reports whether an audit record's extra info describes a
+// completed action rather than an attempt or a failure.
+func auditSucceeded(extraInfo string) bool {
+	info := strings.ToLower(extraInfo)
+	return !strings.HasPrefix(info, "attempt") && !strings.Contains(info, "fail")
+}
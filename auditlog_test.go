@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestLastAuditedAction(t *testing.T) {
+	login := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	join := login.Add(2 * time.Hour)
+	audits := []model.Audit{
+		{Action: "/api/v4/users/login", ExtraInfo: "success session_user=user0", CreateAt: login.UnixMilli()},
+		{Action: "/api/v4/channels/ch1/members", ExtraInfo: "name=deals user_id=user0", CreateAt: join.UnixMilli()},
+		// Anyone who knows the login ID can make these
+		{Action: "/api/v4/users/login", ExtraInfo: "attempt - login_id=jane", CreateAt: join.Add(time.Hour).UnixMilli()},
+		{Action: "/api/v4/users/login", ExtraInfo: "failure - login_id=jane", CreateAt: join.Add(time.Hour).UnixMilli()},
+	}
+	action, at := LastAuditedAction(audits)
+	if action != "channel join" || at == nil || !at.Equal(join) {
+		t.Errorf("got %q at %v, want channel join at %v", action, at, join)
+	}
+	if action, at := LastAuditedAction(audits[2:]); action != "" || at != nil {
+		t.Errorf("only attempts: got %q at %v", action, at)
+	}
+}
+
+func TestChannelJoinTimes(t *testing.T) {
+	first := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	back := first.AddDate(0, 3, 0)
+	audits := []model.Audit{
+		{Action: "/api/v4/channels/ch1/members", ExtraInfo: "name=deals user_id=user0", CreateAt: first.UnixMilli()},
+		{Action: "/api/v4/channels/ch1/members/user0", ExtraInfo: "name=deals user_id=user0", CreateAt: first.AddDate(0, 1, 0).UnixMilli()},
+		{Action: "/api/v4/channels/ch1/members", ExtraInfo: "name=deals user_id=user0", CreateAt: back.UnixMilli()},
+		{Action: "/api/v4/channels/ch2/members", ExtraInfo: "failure - name=secret", CreateAt: first.UnixMilli()},
+		{Action: "/api/v4/teams/team1/members", ExtraInfo: "", CreateAt: first.UnixMilli()},
+	}
+	joins := ChannelJoinTimes(audits)
+	if len(joins) != 1 || !joins["ch1"].Equal(back) {
+		t.Errorf("joins = %v, want ch1 dated from the rejoin at %v", joins, back)
+	}
+}
+
+func TestAuditActionName(t *testing.T) {
+	tests := map[string]string{
+		"/api/v4/users/login":             "login",
+		"/api/v4/users/logout":            "logout",
+		"/api/v4/teams/team1/members":     "team join",
+		"/api/v4/channels/ch1/members/":   "channel join",
+		"/api/v4/users/user0/password":    "password change",
+		"/api/v4/users/user0/mfa":         "mfa change",
+		"/api/v4/users/user0/preferences": "/api/v4/users/user0/preferences",
+	}
+	for action, want := range tests {
+		if got := AuditActionName(action); got != want {
+			t.Errorf("AuditActionName(%q) = %q, want %q", action, got, want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Badge is a one-line summary of a report in the shape of a shields.io
+// badge: a grey label on the left, a coloured message on the right.
+type Badge struct {
+	Label   string
+	Message string
+	Color   string // a shields.io colour name, see badgeColors
+}
+
+// badgeColors maps the colour names used by NewBadge to the hex values
+// shields.io renders them with.
+var badgeColors = map[string]string{
+	"brightgreen": "#4c1",
+	"yellow":      "#dfb317",
+	"red":         "#e05d44",
+}
+
+// NewBadge summarizes result as "guests: N / inactive: M". The badge is
+// green with no inactive guests, yellow with some, and red when lookups
+// failed, since the counts are then incomplete.
+func NewBadge(result *AuditResult) Badge {
+	s := result.Summary
+	color := "brightgreen"
+	switch {
+	case s.FailedLookups > 0:
+		color = "red"
+	case s.InactiveGuests > 0:
+		color = "yellow"
+	}
+	return Badge{
+		Label:   "guests",
+		Message: fmt.Sprintf("%d / inactive: %d", s.TotalGuests, s.InactiveGuests),
+		Color:   color,
+	}
+}
+
+// BadgeFormat returns the badge format for path, from its extension: "svg"
+// for an image, or "json" for a shields.io endpoint file.
+func BadgeFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".svg":
+		return "svg", nil
+	case ".json":
+		return "json", nil
+	}
+	return "", fmt.Errorf("error: invalid badge file %q: use a .svg or .json file name", path)
+}
+
+// WriteBadge writes the badge for result to path, in the format given by
+// its extension. The file is replaced atomically, so a dashboard fetching it
+// during a scheduled run never reads a partial badge. If it cannot be
+// written, the badge goes to stdout with a warning.
+func WriteBadge(result *AuditResult, path string) error {
+	format, err := BadgeFormat(path)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if format == "svg" {
+		err = writeBadgeSVG(&buf, NewBadge(result))
+	} else {
+		err = writeBadgeJSON(&buf, NewBadge(result))
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := replaceFile(path, buf.Bytes()); err != nil {
+		logger.Printf("Warning: unable to write to %q: %v — writing to stdout instead", path, err)
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return nil
+}
+
+// replaceFile writes data to a temporary file next to path and renames it
+// into place.
+func replaceFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeBadgeJSON writes the badge in the shields.io endpoint schema, for
+// https://img.shields.io/endpoint?url=... to render.
+func writeBadgeJSON(w io.Writer, b Badge) error {
+	data, err := json.MarshalIndent(struct {
+		SchemaVersion int    `json:"schemaVersion"`
+		Label         string `json:"label"`
+		Message       string `json:"message"`
+		Color         string `json:"color"`
+	}{1, b.Label, b.Message, b.Color}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// badgeTextWidth approximates the width in pixels of s in 11px Verdana,
+// which is all a badge needs: the text is centred in its half, so small
+// errors only change the padding.
+func badgeTextWidth(s string) int {
+	return len([]rune(s))*7 + 10
+}
+
+// writeBadgeSVG renders the badge in the shields.io "flat" style, so it can
+// be embedded without access to shields.io.
+func writeBadgeSVG(w io.Writer, b Badge) error {
+	lw, mw := badgeTextWidth(b.Label), badgeTextWidth(b.Message)
+	label, message := html.EscapeString(b.Label), html.EscapeString(b.Message)
+	_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+  <title>%[4]s: %[5]s</title>
+  <linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+  <clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+  <g clip-path="url(#r)">
+    <rect width="%[2]d" height="20" fill="#555"/>
+    <rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/>
+    <rect width="%[1]d" height="20" fill="url(#s)"/>
+  </g>
+  <g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+    <text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text>
+    <text x="%[7]d" y="14">%[4]s</text>
+    <text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[5]s</text>
+    <text x="%[8]d" y="14">%[5]s</text>
+  </g>
+</svg>
+`, lw+mw, lw, mw, label, message, badgeColors[b.Color], lw/2, lw+mw/2)
+	return err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewBadge(t *testing.T) {
+	tests := []struct {
+		name    string
+		summary AuditSummary
+		message string
+		color   string
+	}{
+		{"all active", AuditSummary{TotalGuests: 42}, "42 / inactive: 0", "brightgreen"},
+		{"inactive guests", AuditSummary{TotalGuests: 42, InactiveGuests: 7}, "42 / inactive: 7", "yellow"},
+		{"failed lookups", AuditSummary{TotalGuests: 42, InactiveGuests: 7, FailedLookups: 1}, "42 / inactive: 7", "red"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBadge(&AuditResult{Summary: tt.summary})
+			if b.Label != "guests" || b.Message != tt.message || b.Color != tt.color {
+				t.Errorf("badge = %+v, want guests: %s (%s)", b, tt.message, tt.color)
+			}
+		})
+	}
+}
+
+func TestBadgeFormat(t *testing.T) {
+	tests := map[string]string{"badge.svg": "svg", "wiki/GUESTS.SVG": "svg", "badge.json": "json", "badge.png": "", "badge": ""}
+	for path, want := range tests {
+		got, err := BadgeFormat(path)
+		if got != want || (want == "") != (err != nil) {
+			t.Errorf("BadgeFormat(%q) = %q, %v; want %q", path, got, err, want)
+		}
+	}
+}
+
+func TestWriteBadge(t *testing.T) {
+	dir := t.TempDir()
+	result := &AuditResult{Summary: AuditSummary{TotalGuests: 42, InactiveGuests: 7}}
+
+	jsonPath := filepath.Join(dir, "badge.json")
+	if err := WriteBadge(result, jsonPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var endpoint map[string]any
+	if err := json.Unmarshal(data, &endpoint); err != nil {
+		t.Fatalf("invalid endpoint JSON: %v", err)
+	}
+	if endpoint["schemaVersion"] != 1.0 || endpoint["label"] != "guests" || endpoint["message"] != "42 / inactive: 7" || endpoint["color"] != "yellow" {
+		t.Errorf("unexpected endpoint JSON: %s", data)
+	}
+
+	svgPath := filepath.Join(dir, "badge.svg")
+	if err := WriteBadge(result, svgPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(svgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xml.Unmarshal(data, new(struct{})); err != nil {
+		t.Errorf("badge is not well-formed SVG: %v", err)
+	}
+	for _, want := range []string{`aria-label="guests: 42 / inactive: 7"`, `fill="#dfb317"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("SVG missing %q:\n%s", want, data)
+		}
+	}
+
+	// Rewriting replaces the file and leaves no temporary files behind
+	result.Summary.InactiveGuests = 0
+	if err := WriteBadge(result, svgPath); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("expected only the two badges in %s, got %d entries", dir, len(entries))
+	}
+	data, _ = os.ReadFile(svgPath)
+	if !strings.Contains(string(data), "42 / inactive: 0") {
+		t.Error("badge was not rewritten")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// ParseChannelFilter splits a comma-separated --channel value into channel
+// names, dropping blanks and repeats.
+func ParseChannelFilter(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(n, name) }) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// channelScope is the set of channels a --channel audit is scoped to. The
+// zero value scopes nothing.
+type channelScope struct {
+	channels map[string]bool // channel IDs
+	teams    map[string]bool // IDs of the teams holding them
+	names    []string        // display names, in --channel order
+}
+
+func (s channelScope) active() bool { return len(s.channels) > 0 }
+
+// ResolveChannels finds the channels named in a --channel value among teams:
+// each by URL name, display name or ID. Names match case-insensitively. A
+// name found in more than one team is an error, resolved with --channel-team.
+func ResolveChannels(client MattermostClient, teams []*model.Team, names []string) (channelScope, error) {
+	scope := channelScope{channels: make(map[string]bool), teams: make(map[string]bool)}
+	listed := make(map[string][]*model.Channel)
+	for _, name := range names {
+		ch, team, err := resolveChannel(client, teams, name, listed)
+		if err != nil {
+			return channelScope{}, err
+		}
+		if !scope.channels[ch.Id] {
+			scope.names = append(scope.names, ch.DisplayName)
+		}
+		scope.channels[ch.Id] = true
+		scope.teams[team.Id] = true
+	}
+	return scope, nil
+}
+
+// resolveChannel finds one channel for ResolveChannels. URL names are tried
+// first, one call per team; display names and IDs need the teams' channel
+// lists, which are kept in listed for the other names.
+func resolveChannel(client MattermostClient, teams []*model.Team, value string, listed map[string][]*model.Channel) (*model.Channel, *model.Team, error) {
+	type match struct {
+		channel *model.Channel
+		team    *model.Team
+	}
+	var matches []match
+	for _, t := range teams {
+		ch, err := client.GetChannelByName(t.Id, value)
+		if err == nil {
+			matches = append(matches, match{ch, t})
+			continue
+		}
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != 404 {
+			return nil, nil, err
+		}
+	}
+	if len(matches) == 0 {
+		for _, t := range teams {
+			chs, ok := listed[t.Id]
+			if !ok {
+				var err error
+				if chs, err = client.GetTeamChannels(t.Id); err != nil {
+					return nil, nil, err
+				}
+				listed[t.Id] = chs
+			}
+			for _, ch := range chs {
+				if ch.Id == value || strings.EqualFold(ch.DisplayName, value) {
+					matches = append(matches, match{ch, t})
+				}
+			}
+		}
+	}
+
+	switch {
+	case len(matches) == 1:
+		return matches[0].channel, matches[0].team, nil
+	case len(matches) == 0 && len(teams) == 1:
+		return nil, nil, fmt.Errorf("error: channel %q not found in team %q. Use the channel's name, display name or ID", value, teams[0].DisplayName)
+	case len(matches) == 0:
+		return nil, nil, fmt.Errorf("error: channel %q not found in any team. Use the channel's name, display name or ID", value)
+	}
+
+	var teamNames, channelNames []string
+	for _, m := range matches {
+		if !slices.Contains(teamNames, m.team.DisplayName) {
+			teamNames = append(teamNames, m.team.DisplayName)
+		}
+		channelNames = append(channelNames, m.channel.Name)
+	}
+	if len(teamNames) > 1 {
+		return nil, nil, fmt.Errorf("error: channel %q is in %d teams: %s. Use --channel-team to pick one", value, len(teamNames), quoteJoin(teamNames, ", "))
+	}
+	return nil, nil, fmt.Errorf("error: %d channels in %q are displayed as %q. Use the channel name instead: %s", len(matches), teamNames[0], value, quoteJoin(channelNames, ", "))
+}
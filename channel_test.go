@@ -0,0 +1,140 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestParseChannelFilter(t *testing.T) {
+	got := ParseChannelFilter(" Dev Backend, town-square,,dev backend ")
+	if want := []string{"Dev Backend", "town-square"}; !slices.Equal(got, want) {
+		t.Errorf("ParseChannelFilter = %q, want %q", got, want)
+	}
+	if got := ParseChannelFilter(""); got != nil {
+		t.Errorf("empty value = %q, want nil", got)
+	}
+}
+
+func TestResolveChannels(t *testing.T) {
+	eng := &model.Team{Id: "team1", Name: "engineering", DisplayName: "Engineering"}
+	sales := &model.Team{Id: "team2", Name: "sales", DisplayName: "Sales"}
+	backend := &model.Channel{Id: "ch2", Name: "dev-backend", DisplayName: "Dev Backend", Type: model.ChannelTypePrivate}
+	client := &mockClient{
+		channelByName: map[string]*model.Channel{"team1:dev-backend": backend},
+		channels: map[string][]*model.Channel{
+			"team1:user1": {{Id: "ch1", Name: "town-square", DisplayName: "Town Square"}, backend},
+			"team2:user2": {{Id: "ch3", Name: "town-square", DisplayName: "Town Square"}, {Id: "ch4", Name: "partner-updates", DisplayName: "Partner Updates"}},
+			"team2:user3": {{Id: "ch5", Name: "partners-old", DisplayName: "Partner Updates"}},
+		},
+	}
+	both := []*model.Team{eng, sales}
+
+	tests := []struct {
+		name    string
+		teams   []*model.Team
+		value   string
+		want    string // channel IDs, or a substring of the error
+		wantErr bool
+	}{
+		{"URL name", both, "dev-backend", "ch2", false},
+		{"display name", both, "dev backend", "ch2", false},
+		{"ID", both, "ch4", "ch4", false},
+		{"several", both, "Dev Backend, ch4", "ch2,ch4", false},
+		{"in one team", []*model.Team{sales}, "Town Square", "ch3", false},
+		{"in two teams", both, "Town Square", `is in 2 teams: "Engineering", "Sales". Use --channel-team`, true},
+		{"two display names in a team", both, "Partner Updates", `Use the channel name instead: "partner-updates", "partners-old"`, true},
+		{"not in team", []*model.Team{sales}, "Dev Backend", `not found in team "Sales"`, true},
+		{"not in any team", both, "random", "not found in any team", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, err := ResolveChannels(client, tt.teams, ParseChannelFilter(tt.value))
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("error = %v, want it to contain %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var ids []string
+			for id := range scope.channels {
+				ids = append(ids, id)
+			}
+			slices.Sort(ids)
+			if got := strings.Join(ids, ","); got != tt.want {
+				t.Errorf("channels = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunAudit_ChannelFilterAcrossTeams(t *testing.T) {
+	login := time.Now().AddDate(0, 0, -5).UnixMilli()
+	eng := &model.Team{Id: "team1", Name: "engineering", DisplayName: "Engineering"}
+	sales := &model.Team{Id: "team2", Name: "sales", DisplayName: "Sales"}
+	newClient := func() *mockClient {
+		return &mockClient{
+			guests: []*model.User{
+				{Id: "user1", Username: "jane.doe", LastActivityAt: login},
+				{Id: "user2", Username: "bob.smith", LastActivityAt: login},
+				{Id: "user3", Username: "amy.lee", LastActivityAt: login},
+			},
+			allTeams:   []*model.Team{eng, sales},
+			teamByName: map[string]*model.Team{"sales": sales},
+			channelByName: map[string]*model.Channel{
+				"team1:town-square": {Id: "ch1", Name: "town-square", DisplayName: "Town Square"},
+				"team2:town-square": {Id: "ch3", Name: "town-square", DisplayName: "Town Square"},
+			},
+			teams: map[string][]*model.Team{
+				"user1": {eng, sales},
+				"user2": {eng},
+				"user3": {sales},
+			},
+			channels: map[string][]*model.Channel{
+				"team1:user1": {{Id: "ch1", Name: "town-square", DisplayName: "Town Square"}, {Id: "ch2", Name: "dev-backend", DisplayName: "Dev Backend"}},
+				"team1:user2": {{Id: "ch1", Name: "town-square", DisplayName: "Town Square"}},
+				"team2:user1": {{Id: "ch3", Name: "town-square", DisplayName: "Town Square"}},
+				"team2:user3": {{Id: "ch3", Name: "town-square", DisplayName: "Town Square"}, {Id: "ch4", Name: "partner-updates", DisplayName: "Partner Updates"}},
+			},
+		}
+	}
+
+	// Without --team, channels are found in any team, and guests keep all
+	// their teams
+	client := newClient()
+	result, exitCode := RunAudit(client, AuditOptions{ChannelFilter: "Dev Backend,Partner Updates"})
+	if exitCode != ExitSuccess {
+		t.Fatalf("exit code = %d, want %d", exitCode, ExitSuccess)
+	}
+	var names []string
+	for _, g := range result.Guests {
+		names = append(names, g.Username)
+		if len(g.Channels) != 1 {
+			t.Errorf("%s: channels = %+v, want only the named channel", g.Username, g.Channels)
+		}
+	}
+	if want := []string{"jane.doe", "amy.lee"}; !slices.Equal(names, want) {
+		t.Errorf("guests = %v, want %v", names, want)
+	}
+	if len(result.Guests[0].Teams) != 2 {
+		t.Errorf("jane.doe teams = %+v, want both", result.Guests[0].Teams)
+	}
+	if got := result.Metadata.Filters; len(got) != 1 || got[0].Value != "Dev Backend|Partner Updates" {
+		t.Errorf("filters = %+v, want the resolved channel names", got)
+	}
+
+	// A channel name used in several teams needs --channel-team
+	if _, exitCode := RunAudit(newClient(), AuditOptions{ChannelFilter: "town-square"}); exitCode != ExitConfigError {
+		t.Errorf("ambiguous channel: exit code = %d, want %d", exitCode, ExitConfigError)
+	}
+	result, _ = RunAudit(newClient(), AuditOptions{ChannelFilter: "town-square", ChannelTeam: "sales"})
+	if len(result.Guests) != 2 || result.Guests[0].Channels[0].ID != "ch3" {
+		t.Errorf("expected jane.doe and amy.lee in Sales' town-square, got %+v", result.Guests)
+	}
+}
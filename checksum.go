@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// checksumRecord is the normalized form of a guest that GuestChecksum hashes.
+// Field order is fixed by the struct, and lists are sorted, so the checksum
+// does not depend on the order the API returned teams or channels in.
+type checksumRecord struct {
+	Username          string   `json:"username"`
+	DisplayName       string   `json:"display_name"`
+	Nickname          string   `json:"nickname"`
+	Email             string   `json:"email"`
+	CreatedAt         string   `json:"created_at"`
+	LastLogin         string   `json:"last_login"`
+	LastPost          string   `json:"last_post"`
+	Teams             []string `json:"teams"`
+	Channels          []string `json:"channels"`
+	Active            bool     `json:"active"`
+	Inactive          bool     `json:"inactive"`
+	Excepted          bool     `json:"excepted"`
+	Justification     string   `json:"exception_justification"`
+	ExceptionExpires  string   `json:"exception_expires"`
+	PreviousUsernames []string `json:"previous_usernames"`
+	PreviousEmails    []string `json:"previous_emails"`
+	LastFileUpload    string   `json:"last_file_upload"`
+	FileCount         string   `json:"file_count"`
+	Boards            []string `json:"boards"`
+	Playbooks         []string `json:"playbooks"`
+	// Fields added later are omitted when empty so existing checksums did not change.
+	ExceptionTicket string `json:"exception_ticket,omitempty"`
+	LastMention     string `json:"last_mention,omitempty"`
+	PostCount       string `json:"post_count,omitempty"`
+	MentionCount    string `json:"mention_count,omitempty"`
+	AuthService     string `json:"auth_service,omitempty"`
+	LastViewed      string `json:"last_viewed,omitempty"`
+	LastAudited     string `json:"last_audited,omitempty"` // action@time
+	// Sorted, so the order enrichments were denied in does not matter
+	PermissionMissing []string `json:"permission_missing,omitempty"`
+	SharedAccount     string   `json:"possible_shared_account,omitempty"`
+	SharedSessionIPs  []string `json:"shared_session_ips,omitempty"`
+	ShouldBeGuest     bool     `json:"should_be_guest,omitempty"`
+	ElevatedRoles     []string `json:"elevated_roles,omitempty"`
+	Locale            string   `json:"locale,omitempty"`
+	Timezone          string   `json:"timezone,omitempty"`
+	// Set when unverified, so most guests kept their checksum
+	EmailUnverified   bool     `json:"email_unverified,omitempty"`
+	GuestOnlyChannels []string `json:"guest_only_channels,omitempty"`
+	PurgeCandidate    bool     `json:"purge_candidate,omitempty"`
+	// Not age_days or days_since_last_activity, which change every day;
+	// created_at, last_login and last_post cover them
+	Expired       bool     `json:"expired,omitempty"`
+	Position      string   `json:"position,omitempty"`
+	ProfileFields []string `json:"profile_fields,omitempty"` // name=value, sorted
+	// Likewise not password_age_days
+	PasswordExpired bool   `json:"password_expired,omitempty"`
+	Bot             string `json:"bot,omitempty"`
+
+	Groups         []string `json:"groups,omitempty"`
+	DirectChannels []string `json:"direct_channels,omitempty"`
+}
+
+// GuestChecksum returns a stable SHA-256 (hex) of the guest's normalized
+// record. Two runs produce the same checksum for a guest only if nothing
+// reported about them has changed.
+func GuestChecksum(g GuestRecord) string {
+	channels := make([]string, len(g.Channels))
+	for i, ch := range g.Channels {
+		channels[i] = ch.TeamName + "/" + ch.ChannelName
+		if ch.RetentionPolicy {
+			channels[i] += "#retention"
+		}
+		if ch.Type == ChannelTypePrivate {
+			channels[i] += "#private"
+		}
+		if ch.JoinedAt != nil {
+			channels[i] += "@" + FormatTimeISO(ch.JoinedAt)
+		}
+	}
+
+	teams := make([]string, len(g.Teams))
+	var lastAudited string
+	if g.LastAuditedAt != nil {
+		lastAudited = g.LastAuditedAction + "@" + FormatTimeISO(g.LastAuditedAt)
+	}
+	for i, t := range g.Teams {
+		teams[i] = t.DisplayName
+		if t.Archived {
+			teams[i] += "#archived"
+		}
+	}
+
+	// Hash the API's AuthService, which is empty for email sign-in, so
+	// email guests kept their checksum when auth methods were added
+	authService := g.AuthMethod
+	if authService == AuthMethodEmail {
+		authService = ""
+	}
+
+	data, _ := json.Marshal(checksumRecord{
+		Username:          strings.ToLower(g.Username),
+		DisplayName:       g.DisplayName,
+		Nickname:          g.Nickname,
+		Email:             strings.ToLower(g.Email),
+		CreatedAt:         FormatTimeISO(g.CreatedAt),
+		LastLogin:         FormatTimeISO(g.LastLogin),
+		LastPost:          FormatTimeISO(g.LastPost),
+		Teams:             sortedCopy(teams),
+		Channels:          sortedCopy(channels),
+		Active:            g.Active,
+		Inactive:          g.Inactive,
+		Excepted:          g.Excepted,
+		Justification:     g.ExceptionJustification,
+		ExceptionExpires:  FormatTimeISO(g.ExceptionExpires),
+		PreviousUsernames: sortedCopy(g.PreviousUsernames),
+		PreviousEmails:    sortedCopy(g.PreviousEmails),
+		LastFileUpload:    FormatTimeISO(g.LastFileUpload),
+		FileCount:         formatOptionalInt(g.FileCount),
+		Boards:            sortedCopy(resourceNames(g.Boards)),
+		Playbooks:         sortedCopy(resourceNames(g.Playbooks)),
+		ExceptionTicket:   g.ExceptionTicket,
+		LastMention:       FormatTimeISO(g.LastMention),
+		PostCount:         formatOptionalInt(g.PostCount),
+		MentionCount:      formatOptionalInt(g.MentionCount),
+		AuthService:       authService,
+		LastViewed:        FormatTimeISO(g.LastViewed),
+		LastAudited:       lastAudited,
+		PermissionMissing: sortedCopy(g.PermissionMissing),
+		SharedAccount:     formatOptionalBool(g.SharedAccount),
+		SharedSessionIPs:  sortedCopy(g.SharedSessionIPs),
+		ShouldBeGuest:     g.ShouldBeGuest,
+		ElevatedRoles:     sortedCopy(roleGrantNames(g.ElevatedRoles)),
+		Locale:            g.Locale,
+		Timezone:          g.Timezone,
+		EmailUnverified:   !g.EmailVerified,
+		GuestOnlyChannels: sortedCopy(resourceNames(g.GuestOnlyChannels)),
+		PurgeCandidate:    g.PurgeCandidate,
+		Expired:           g.Expired,
+		Position:          g.Position,
+		ProfileFields:     sortedCopy(profileFieldPairs(g.ProfileFields)),
+		PasswordExpired:   g.PasswordExpired,
+		Bot:               g.Bot,
+		Groups:            sortedCopy(g.Groups),
+		DirectChannels:    sortedCopy(resourceNames(g.DirectChannels)),
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// profileFieldPairs lists profile fields as name=value, skipping empty
+// values so a newly requested field the guest has not filled in leaves the
+// checksum unchanged.
+func profileFieldPairs(fields map[string]string) []string {
+	var pairs []string
+	for name, value := range fields {
+		if value != "" {
+			pairs = append(pairs, name+"="+value)
+		}
+	}
+	return pairs
+}
+
+func roleGrantNames(grants []RoleGrant) []string {
+	names := make([]string, len(grants))
+	for i, g := range grants {
+		names[i] = g.String()
+	}
+	return names
+}
+
+func resourceNames(resources []ResourceInfo) []string {
+	names := make([]string, len(resources))
+	for i, r := range resources {
+		names[i] = r.TeamName + "/" + r.Name
+	}
+	return names
+}
+
+func sortedCopy(s []string) []string {
+	out := append([]string{}, s...)
+	sort.Strings(out)
+	return out
+}
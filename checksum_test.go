@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGuestChecksum(t *testing.T) {
+	base := sampleResult().Guests[0]
+	sum := GuestChecksum(base)
+
+	if len(sum) != 64 {
+		t.Fatalf("expected 64 hex characters, got %q", sum)
+	}
+	if GuestChecksum(base) != sum {
+		t.Error("checksum is not deterministic")
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(g *GuestRecord)
+		changed bool
+	}{
+		{"teams reordered", func(g *GuestRecord) {
+			g.Teams = []TeamInfo{g.Teams[1], g.Teams[0]}
+		}, false},
+		{"channels reordered", func(g *GuestRecord) {
+			g.Channels = []ChannelInfo{g.Channels[2], g.Channels[0], g.Channels[1]}
+		}, false},
+		{"team IDs ignored", func(g *GuestRecord) {
+			g.Teams = []TeamInfo{{DisplayName: "Engineering"}, {DisplayName: "Sales"}}
+		}, false},
+		{"email case ignored", func(g *GuestRecord) {
+			g.Email = "Jane.Doe@External.com"
+		}, false},
+		{"previous checksum ignored", func(g *GuestRecord) {
+			g.Checksum = "stale"
+		}, false},
+		{"new login", func(g *GuestRecord) {
+			later := g.LastLogin.Add(time.Hour)
+			g.LastLogin = &later
+		}, true},
+		{"channel added", func(g *GuestRecord) {
+			g.Channels = append(g.Channels, ChannelInfo{TeamName: "Sales", ChannelName: "Pricing"})
+		}, true},
+		{"deactivated", func(g *GuestRecord) {
+			g.Active = false
+		}, true},
+		{"excepted", func(g *GuestRecord) {
+			g.Excepted = true
+		}, true},
+		{"channel put under retention", func(g *GuestRecord) {
+			g.Channels = append([]ChannelInfo{}, g.Channels...)
+			g.Channels[0].RetentionPolicy = true
+		}, true},
+		{"email auth method recorded", func(g *GuestRecord) {
+			g.AuthMethod = AuthMethodEmail
+		}, false},
+		{"moved to SSO", func(g *GuestRecord) {
+			g.AuthMethod = AuthMethodSAML
+		}, true},
+		{"public channel type recorded", func(g *GuestRecord) {
+			g.Channels = append([]ChannelInfo{}, g.Channels...)
+			g.Channels[0].Type = ChannelTypePublic
+		}, false},
+		{"channel made private", func(g *GuestRecord) {
+			g.Channels = append([]ChannelInfo{}, g.Channels...)
+			g.Channels[0].Type = ChannelTypePrivate
+		}, true},
+		{"exception ticket linked", func(g *GuestRecord) {
+			g.ExceptionTicket = "https://jira.example.com/browse/SEC-42"
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := sampleResult().Guests[0]
+			tt.modify(&g)
+			if changed := GuestChecksum(g) != sum; changed != tt.changed {
+				t.Errorf("checksum changed = %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
+
+func TestRunOffline_RecomputesChecksum(t *testing.T) {
+	result, _ := RunOffline(sampleResult(), AuditOptions{})
+	for _, g := range result.Guests {
+		if g.Checksum != GuestChecksum(g) {
+			t.Errorf("%s: checksum not set", g.Username)
+		}
+	}
+}
@@ -2,9 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
@@ -14,58 +21,270 @@ import (
 // MattermostClient abstracts the Mattermost API calls needed by mm-guest-audit.
 // This interface enables unit testing with mock implementations.
 type MattermostClient interface {
-	GetGuestUsers(page, perPage int) ([]*model.User, error)
+	GetGuestUsers(roles []string, teamID string, page, perPage int) ([]*model.User, error)
+	GetGuestCount(roles []string, teamID string) (int64, error)
 	GetTeamByName(name string) (*model.Team, error)
+	GetAllTeams() ([]*model.Team, error)
 	GetTeamsForUser(userID string) ([]*model.Team, error)
 	GetChannelByName(teamID, channelName string) (*model.Channel, error)
 	GetChannelsForTeamForUser(teamID, userID string) ([]*model.Channel, error)
+	GetTeamChannels(teamID string) ([]*model.Channel, error)
+	GetTeamChannelMembers(teamID string) (map[string][]*model.Channel, error)
+	GetChannelMemberIDs(channelID string) ([]string, error)
+	GetChannelAdmins(channelID string) ([]*model.User, error)
+	GetGroupsForUser(userID string) ([]*model.Group, error)
+	GetGroupChannelIDs(groupID string) ([]string, error)
 	GetLastPostDateForUser(userID, username string, teamIDs []string) (*time.Time, error)
+	GetFileActivityForUser(username string, teamIDs []string) (int, *time.Time, error)
+	GetMentionsOfUser(userID, username string, teamIDs []string, since time.Time) ([]*model.Post, error)
+	GetPostCountsForChannel(channelID string, since *time.Time) (map[string]int, error)
+	GetDataRetentionPoliciesCount() (int64, error)
+	GetChannelPoliciesForUser(userID string, page, perPage int) ([]*model.RetentionPolicyForChannel, error)
+	GetUserAudits(userID string, page, perPage int) ([]model.Audit, error)
+	GetSessions(userID string) ([]*model.Session, error)
+	GetTeamMembersForUser(userID string) ([]*model.TeamMember, error)
+	GetChannelMembersForUser(userID, teamID string) ([]model.ChannelMember, error)
+	GetBoardMembers(teamID string) (map[string][]string, error)
+	GetPlaybookMembers(teamID string) (map[string][]string, error)
+	GetProfileFields() ([]ProfileField, error)
+	GetProfileAttributes(userID string) (map[string]json.RawMessage, error)
+	RemoveUserFromChannel(channelID, userID string) error
+	AddUserToChannel(channelID, userID string) error
+	AddUserToTeam(teamID, userID string) error
+	RemoveUserFromTeam(teamID, userID string) error
+	PermanentDeleteUser(userID string) error
+	DeactivateUser(userID string) error
+	ReactivateUser(userID string) error
+	SendDirectMessage(userID, message string) error
+	IsCloud() bool
+	ServerInfo() ServerInfo
+}
+
+// ServerInfo identifies the server and account a client is connected as,
+// for the report's run metadata.
+type ServerInfo struct {
+	URL      string
+	Version  string // e.g. 9.11.0; empty if the server did not say
+	Username string // the authenticated user
+	UserID   string // the authenticated user's ID; empty in local mode
+	APICalls int64  // HTTP requests sent so far, including retries
+	APIBytes int64  // response body bytes received so far
+
+	LicensedSeats int // user seats in the server's license; 0 if unlicensed or unknown
+}
+
+// Operator is the account an action mode runs as, recorded in its report
+// and undo plan so a bulk change can be traced to a person rather than to
+// the tool.
+type Operator struct {
+	UserID   string `json:"user_id"` // empty in local mode
+	Username string `json:"username"`
+}
+
+// Operator returns the authenticated account as an Operator.
+func (i ServerInfo) Operator() Operator {
+	return Operator{UserID: i.UserID, Username: i.Username}
+}
+
+// String shows the operator as "username (user ID)".
+func (o Operator) String() string {
+	if o.UserID == "" {
+		return o.Username
+	}
+	return fmt.Sprintf("%s (%s)", o.Username, o.UserID)
 }
 
 // mmClient is the real implementation backed by model.Client4.
 type mmClient struct {
-	api *model.Client4
-	ctx context.Context
+	api   *model.Client4
+	ctx   context.Context
+	url   string
+	cloud bool
+
+	serverVersion string
+	username      string
+	userID        string
+	calls         *countingTransport
+	licensedSeats int
 }
 
+// CloudRateLimit is the default requests-per-second applied to Cloud
+// workspaces when --rate-limit is not set, keeping well under Cloud's
+// per-user API limits.
+const CloudRateLimit = 10
+
 // NormalizeURL strips trailing slashes from the server URL.
 func NormalizeURL(url string) string {
 	return strings.TrimRight(url, "/")
 }
 
+// ClientOptions tunes how the client talks to the server.
+type ClientOptions struct {
+	RateLimit float64           // maximum requests per second; 0 means unlimited
+	Window    *OperationsWindow // hold API calls outside this daily window; nil means always
+	Timeout   time.Duration     // give up on a single API call after this long; 0 means never
+	Verbose   bool
+	// Socket, when set, connects through the server's local mode socket at
+	// this path instead of the URL, without authenticating.
+	Socket string
+}
+
+// LocalModeURL is how a server reached through its local mode socket is
+// named in reports, state files and undo plans.
+func LocalModeURL(socketPath string) string {
+	return "unix://" + socketPath
+}
+
+// localModeUser stands in for the authenticated user in local mode, which
+// has none. Usernames cannot contain parentheses, so it is never a real one.
+const localModeUser = "(local mode)"
+
+// socketTransport sends every request over the unix socket at path,
+// whatever host the request names.
+func socketTransport(path string) http.RoundTripper {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+}
+
 // NewClient creates a new Mattermost API client and authenticates.
-func NewClient(url, token, username string, verbose bool) (MattermostClient, error) {
+func NewClient(url, token, username string, opts ClientOptions) (MattermostClient, error) {
 	url = NormalizeURL(url)
-	api := model.NewAPIv4Client(url)
+	apiURL := url
+	if opts.Socket != "" {
+		// The socket answers whatever the host, as in model.NewAPIv4SocketClient
+		apiURL = "http://_"
+	}
+	api := model.NewAPIv4Client(apiURL)
 	ctx := context.Background()
+	verbose := opts.Verbose
 
-	if token != "" {
+	// Every request is counted, including retries and authentication. The
+	// window gate sits below the rate limiter, so a paused run does not bank
+	// tokens for a burst when it resumes. The timeout is innermost, so time
+	// spent paused or rate limited does not count against a call.
+	var transport http.RoundTripper = http.DefaultTransport
+	if opts.Socket != "" {
+		transport = socketTransport(opts.Socket)
+	}
+	if opts.Timeout > 0 {
+		transport = &timeoutTransport{timeout: opts.Timeout, next: transport}
+	}
+	calls := &countingTransport{next: transport}
+	var base http.RoundTripper = calls
+	api.HTTPClient.Transport = base
+	if opts.Window != nil {
+		base = &windowTransport{gate: newWindowGate(opts.Window, logger), next: base}
+		api.HTTPClient.Transport = base
+		if verbose {
+			logger.Printf("API calls allowed only between %s local time", opts.Window)
+		}
+	}
+	if limiter := NewRateLimiter(opts.RateLimit); limiter != nil {
+		api.HTTPClient.Transport = &rateLimitedTransport{limiter: limiter, next: base}
+		if verbose {
+			logger.Printf("Rate limiting API calls to %g per second", opts.RateLimit)
+		}
+	}
+
+	authUser := &model.User{Username: localModeUser}
+	var authResp *model.Response
+	if opts.Socket != "" {
+		// Local mode trusts whoever can open the socket, so there is no
+		// login; a ping checks the socket answers
+		if verbose {
+			logger.Printf("Connecting through the local mode socket %s...", opts.Socket)
+		}
+		_, resp, err := api.GetPing(ctx)
+		if err != nil {
+			if resp == nil && !IsTimeout(err) {
+				return nil, &APIError{Message: fmt.Sprintf("error: unable to connect to the local mode socket %s. Check that ServiceSettings.EnableLocalMode is on and that you can read and write the socket", opts.Socket), Err: err}
+			}
+			return nil, classifyAPIError(url, resp, err)
+		}
+		authResp = resp
+	} else if token != "" {
 		api.SetToken(token)
 		if verbose {
-			fmt.Fprintln(os.Stderr, "Authenticating with personal access token...")
+			logger.Printf("Authenticating with personal access token...")
 		}
 		// Verify the token works
-		_, resp, err := api.GetMe(ctx, "")
+		me, resp, err := api.GetMe(ctx, "")
 		if err != nil {
 			return nil, classifyAPIError(url, resp, err)
 		}
+		authUser, authResp = me, resp
 	} else if username != "" {
 		password, err := obtainPassword()
 		if err != nil {
 			return nil, err
 		}
 		if verbose {
-			fmt.Fprintln(os.Stderr, "Authenticating with username and password...")
+			logger.Printf("Authenticating with username and password...")
 		}
-		_, resp, err := api.Login(ctx, username, password)
+		me, resp, err := api.Login(ctx, username, password)
 		if err != nil {
 			return nil, classifyAPIError(url, resp, err)
 		}
+		authUser, authResp = me, resp
 	} else {
-		return nil, fmt.Errorf("error: authentication required. Use --token (or MM_TOKEN) for token auth, or --username (or MM_USERNAME) for password auth")
+		return nil, fmt.Errorf("error: authentication required. Use --token (or MM_TOKEN) for token auth, --username (or MM_USERNAME) for password auth, or save a token with mm-guest-audit login")
 	}
 
-	return &mmClient{api: api, ctx: ctx}, nil
+	c := &mmClient{api: api, ctx: ctx, url: url, calls: calls, username: authUser.Username, userID: authUser.Id}
+	if authResp != nil {
+		c.serverVersion = shortServerVersion(authResp.ServerVersion)
+	}
+	if c.serverVersion == "" {
+		c.serverVersion = readServerVersion(ctx, api, verbose)
+	}
+	c.cloud, c.licensedSeats = readLicense(ctx, api, verbose)
+	if c.cloud && opts.RateLimit == 0 {
+		api.HTTPClient.Transport = &rateLimitedTransport{limiter: NewRateLimiter(CloudRateLimit), next: base}
+		if verbose {
+			logger.Printf("Rate limiting API calls to %d per second for Cloud (override with --rate-limit)", CloudRateLimit)
+		}
+	}
+	return c, nil
+}
+
+// readServerVersion asks the client config for the server version, for when
+// a proxy has stripped the X-Version-Id header from the login response. It
+// returns "" if the config cannot be read, and the audit then tries every
+// feature and skips those the server rejects.
+func readServerVersion(ctx context.Context, api *model.Client4, verbose bool) string {
+	config, _, err := api.GetOldClientConfig(ctx, "")
+	if err != nil {
+		if verbose {
+			logger.Printf("Warning: could not detect the server version: %v", err)
+		}
+		return ""
+	}
+	return shortServerVersion(config["Version"])
+}
+
+// readLicense reports whether the server is a Mattermost Cloud workspace,
+// and its licensed user seats, according to the client license. Failure to
+// read the license is treated as self-hosted with an unknown seat count.
+func readLicense(ctx context.Context, api *model.Client4, verbose bool) (cloud bool, seats int) {
+	license, _, err := api.GetOldClientLicense(ctx, "")
+	if err != nil {
+		if verbose {
+			logger.Printf("Warning: could not read license, assuming self-hosted: %v", err)
+		}
+		return false, 0
+	}
+	cloud = license["Cloud"] == "true"
+	if verbose && cloud {
+		logger.Printf("Detected Mattermost Cloud workspace")
+	}
+	if license["IsLicensed"] == "true" {
+		seats, _ = strconv.Atoi(license["Users"])
+	}
+	return cloud, seats
 }
 
 // obtainPassword gets the password from TTY prompt or MM_PASSWORD env var.
@@ -87,25 +306,149 @@ func obtainPassword() (string, error) {
 	return password, nil
 }
 
-func (c *mmClient) GetGuestUsers(page, perPage int) ([]*model.User, error) {
-	users, resp, err := c.api.GetUsersWithCustomQueryParameters(c.ctx, page, perPage, "role=system_guest", "")
+func (c *mmClient) IsCloud() bool {
+	return c.cloud
+}
+
+func (c *mmClient) ServerInfo() ServerInfo {
+	return ServerInfo{URL: c.url, Version: c.serverVersion, Username: c.username, UserID: c.userID, APICalls: c.calls.Count(), APIBytes: c.calls.Bytes(), LicensedSeats: c.licensedSeats}
+}
+
+// shortServerVersion reduces the X-Version-Id header, which also carries
+// build details (9.11.0.10574498245.2b3fd5b1.true), to the release number.
+func shortServerVersion(header string) string {
+	parts := strings.SplitN(header, ".", 4)
+	if len(parts) < 3 {
+		return header
+	}
+	return strings.Join(parts[:3], ".")
+}
+
+// countingTransport counts the HTTP requests sent through it and the
+// response body bytes read back.
+type countingTransport struct {
+	n     atomic.Int64
+	bytes atomic.Int64
+	next  http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.n.Add(1)
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &countingBody{ReadCloser: resp.Body, n: &t.bytes}
+	return resp, nil
+}
+
+// Count returns the number of requests sent so far.
+func (t *countingTransport) Count() int64 {
+	return t.n.Load()
+}
+
+// Bytes returns the number of response body bytes read so far.
+func (t *countingTransport) Bytes() int64 {
+	return t.bytes.Load()
+}
+
+// countingBody adds the bytes read from a response body to n.
+type countingBody struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// timeoutTransport cancels each request that has not completed, body
+// included, within timeout.
+type timeoutTransport struct {
+	timeout time.Duration
+	next    http.RoundTripper
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases a request's context once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// GetGuestUsers lists users holding any of the given system roles. A non-empty
+// teamID restricts the listing to that team's members, filtered by the server.
+func (c *mmClient) GetGuestUsers(roles []string, teamID string, page, perPage int) ([]*model.User, error) {
+	query := "roles=" + url.QueryEscape(strings.Join(roles, ","))
+	if teamID != "" {
+		query += "&in_team=" + url.QueryEscape(teamID)
+	}
+	users, resp, err := c.api.GetUsersWithCustomQueryParameters(c.ctx, page, perPage, query, "")
 	if err != nil {
 		return nil, classifyAPIError("", resp, err)
 	}
 	return users, nil
 }
 
+// GetGuestCount returns the number of users (including deactivated) holding any of the given roles,
+// optionally only those in team teamID.
+func (c *mmClient) GetGuestCount(roles []string, teamID string) (int64, error) {
+	stats, resp, err := c.api.GetFilteredUsersStats(c.ctx, &model.UserCountOptions{
+		IncludeDeleted:     true,
+		IncludeBotAccounts: true,
+		Roles:              roles,
+		TeamId:             teamID,
+	})
+	if err != nil {
+		return 0, classifyAPIError("", resp, err)
+	}
+	return stats.TotalUsersCount, nil
+}
+
 func (c *mmClient) GetTeamByName(name string) (*model.Team, error) {
 	team, resp, err := c.api.GetTeamByName(c.ctx, name, "")
 	if err != nil {
 		if resp != nil && resp.StatusCode == 404 {
-			return nil, fmt.Errorf("error: team %q not found. Please check the name and try again", name)
+			return nil, &APIError{StatusCode: 404, Message: fmt.Sprintf("error: team %q not found. Please check the name and try again", name), Err: err}
 		}
 		return nil, classifyAPIError("", resp, err)
 	}
 	return team, nil
 }
 
+// GetAllTeams lists every team on the server, including private teams.
+func (c *mmClient) GetAllTeams() ([]*model.Team, error) {
+	var all []*model.Team
+	for page := 0; ; page++ {
+		teams, resp, err := c.api.GetAllTeams(c.ctx, "", page, 200)
+		if err != nil {
+			return nil, classifyAPIError("", resp, err)
+		}
+		all = append(all, teams...)
+		if len(teams) < 200 {
+			return all, nil
+		}
+	}
+}
+
 func (c *mmClient) GetTeamsForUser(userID string) ([]*model.Team, error) {
 	teams, resp, err := c.api.GetTeamsForUser(c.ctx, userID, "")
 	if err != nil {
@@ -118,21 +461,249 @@ func (c *mmClient) GetChannelByName(teamID, channelName string) (*model.Channel,
 	channel, resp, err := c.api.GetChannelByName(c.ctx, channelName, teamID, "")
 	if err != nil {
 		if resp != nil && resp.StatusCode == 404 {
-			return nil, fmt.Errorf("error: channel %q not found. Please check the name and try again", channelName)
+			return nil, &APIError{StatusCode: 404, Message: fmt.Sprintf("error: channel %q not found. Please check the name and try again", channelName), Err: err}
 		}
 		return nil, classifyAPIError("", resp, err)
 	}
 	return channel, nil
 }
 
+// GetChannelsForTeamForUser lists the user's channels in the team, archived
+// ones included, plus their DMs and group messages.
 func (c *mmClient) GetChannelsForTeamForUser(teamID, userID string) ([]*model.Channel, error) {
-	channels, resp, err := c.api.GetChannelsForTeamForUser(c.ctx, teamID, userID, false, "")
+	channels, resp, err := c.api.GetChannelsForTeamForUser(c.ctx, teamID, userID, true, "")
 	if err != nil {
 		return nil, classifyAPIError("", resp, err)
 	}
 	return channels, nil
 }
 
+// RemoveUserFromChannel removes the user from the channel, used by
+// --remove-from-channels. The only calls that change anything on the server
+// are this one, AddUserToChannel, AddUserToTeam, RemoveUserFromTeam,
+// PermanentDeleteUser, DeactivateUser, ReactivateUser and
+// SendDirectMessage.
+func (c *mmClient) RemoveUserFromChannel(channelID, userID string) error {
+	resp, err := c.api.RemoveUserFromChannel(c.ctx, channelID, userID)
+	if err != nil {
+		return classifyAPIError("", resp, err)
+	}
+	return nil
+}
+
+// AddUserToChannel adds the user back to the channel, used by undo. Adding
+// an existing member succeeds.
+func (c *mmClient) AddUserToChannel(channelID, userID string) error {
+	_, resp, err := c.api.AddChannelMember(c.ctx, channelID, userID)
+	if err != nil {
+		return classifyAPIError("", resp, err)
+	}
+	return nil
+}
+
+// AddUserToTeam adds the user to the team, used by --quarantine-team and
+// its undo. Adding an existing member succeeds.
+func (c *mmClient) AddUserToTeam(teamID, userID string) error {
+	_, resp, err := c.api.AddTeamMember(c.ctx, teamID, userID)
+	if err != nil {
+		return classifyAPIError("", resp, err)
+	}
+	return nil
+}
+
+// RemoveUserFromTeam removes the user from the team and with it from the
+// team's channels, used by --quarantine-team and its undo.
+func (c *mmClient) RemoveUserFromTeam(teamID, userID string) error {
+	resp, err := c.api.RemoveTeamMember(c.ctx, teamID, userID)
+	if err != nil {
+		return classifyAPIError("", resp, err)
+	}
+	return nil
+}
+
+// PermanentDeleteUser deletes the user and everything they posted, used by
+// --purge. The server refuses unless ServiceSettings.EnableAPIUserDeletion
+// is on.
+func (c *mmClient) PermanentDeleteUser(userID string) error {
+	resp, err := c.api.PermanentDeleteUser(c.ctx, userID)
+	if err != nil {
+		return classifyAPIError("", resp, err)
+	}
+	return nil
+}
+
+// DeactivateUser deactivates the user, used by --deactivate-expired. This
+// revokes their sessions and frees the license seat; the account can be
+// reactivated later.
+func (c *mmClient) DeactivateUser(userID string) error {
+	resp, err := c.api.UpdateUserActive(c.ctx, userID, false)
+	if err != nil {
+		return classifyAPIError("", resp, err)
+	}
+	return nil
+}
+
+// ReactivateUser reactivates the user, used by undo of a --deactivate-expired
+// plan. Reactivating an active account succeeds.
+func (c *mmClient) ReactivateUser(userID string) error {
+	resp, err := c.api.UpdateUserActive(c.ctx, userID, true)
+	if err != nil {
+		return classifyAPIError("", resp, err)
+	}
+	return nil
+}
+
+// SendDirectMessage posts message in the direct channel between the
+// authenticated account and the user, creating the channel if needed, used
+// by --notify-guests with a bot token.
+func (c *mmClient) SendDirectMessage(userID, message string) error {
+	channel, resp, err := c.api.CreateDirectChannel(c.ctx, c.userID, userID)
+	if err != nil {
+		return classifyAPIError("", resp, err)
+	}
+	_, resp, err = c.api.CreatePost(c.ctx, &model.Post{ChannelId: channel.Id, Message: message})
+	if err != nil {
+		return classifyAPIError("", resp, err)
+	}
+	return nil
+}
+
+// GetTeamChannels lists the team's public and private channels, one call per
+// 200 channels. DMs and group messages are not part of a team and are not
+// included.
+func (c *mmClient) GetTeamChannels(teamID string) ([]*model.Channel, error) {
+	perPage := 200
+	var channels []*model.Channel
+	for _, list := range []func(context.Context, string, int, int, string) ([]*model.Channel, *model.Response, error){
+		c.api.GetPublicChannelsForTeam,
+		c.api.GetPrivateChannelsForTeam,
+	} {
+		for page := 0; ; page++ {
+			chs, resp, err := list(c.ctx, teamID, page, perPage, "")
+			if err != nil {
+				return nil, classifyAPIError("", resp, err)
+			}
+			channels = append(channels, chs...)
+			if len(chs) < perPage {
+				break
+			}
+		}
+	}
+	return channels, nil
+}
+
+// GetTeamChannelMembers lists the team's channels, archived ones included,
+// and their members, and returns user ID → channels. The cost is one call
+// per 200 channels and per 200 members of each channel, however many guests
+// there are.
+func (c *mmClient) GetTeamChannelMembers(teamID string) (map[string][]*model.Channel, error) {
+	channels, err := c.GetTeamChannels(teamID)
+	if err != nil {
+		return nil, err
+	}
+	perPage := 200
+	for page := 0; ; page++ {
+		chs, resp, err := c.api.GetDeletedChannelsForTeam(c.ctx, teamID, page, perPage, "")
+		if err != nil {
+			return nil, classifyAPIError("", resp, err)
+		}
+		channels = append(channels, chs...)
+		if len(chs) < perPage {
+			break
+		}
+	}
+
+	members := make(map[string][]*model.Channel)
+	for _, ch := range channels {
+		userIDs, err := c.GetChannelMemberIDs(ch.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range userIDs {
+			members[id] = append(members[id], ch)
+		}
+	}
+	return members, nil
+}
+
+// GetChannelMemberIDs returns the user IDs of every member of the channel.
+func (c *mmClient) GetChannelMemberIDs(channelID string) ([]string, error) {
+	perPage := 200
+	var ids []string
+	for page := 0; ; page++ {
+		ms, resp, err := c.api.GetChannelMembers(c.ctx, channelID, page, perPage, "")
+		if err != nil {
+			return nil, classifyAPIError("", resp, err)
+		}
+		for _, m := range ms {
+			ids = append(ids, m.UserId)
+		}
+		if len(ms) < perPage {
+			return ids, nil
+		}
+	}
+}
+
+// GetChannelAdmins lists the channel's admins, one call per 200 members and
+// one for their accounts. Deactivated accounts and bots are left out, as
+// nobody would read a message sent to them.
+func (c *mmClient) GetChannelAdmins(channelID string) ([]*model.User, error) {
+	perPage := 200
+	var ids []string
+	for page := 0; ; page++ {
+		ms, resp, err := c.api.GetChannelMembers(c.ctx, channelID, page, perPage, "")
+		if err != nil {
+			return nil, classifyAPIError("", resp, err)
+		}
+		for _, m := range ms {
+			if m.SchemeAdmin {
+				ids = append(ids, m.UserId)
+			}
+		}
+		if len(ms) < perPage {
+			break
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	users, resp, err := c.api.GetUsersByIds(c.ctx, ids)
+	if err != nil {
+		return nil, classifyAPIError("", resp, err)
+	}
+	admins := users[:0]
+	for _, u := range users {
+		if u.DeleteAt == 0 && !u.IsBot {
+			admins = append(admins, u)
+		}
+	}
+	return admins, nil
+}
+
+// GetGroupsForUser lists the groups the user belongs to, both groups synced
+// from LDAP or AD and custom groups.
+func (c *mmClient) GetGroupsForUser(userID string) ([]*model.Group, error) {
+	groups, resp, err := c.api.GetGroupsByUserId(c.ctx, userID)
+	if err != nil {
+		return nil, classifyAPIError("", resp, err)
+	}
+	return groups, nil
+}
+
+// GetGroupChannelIDs returns the IDs of the channels a synced group is
+// linked to, whose members group sync adds and removes.
+func (c *mmClient) GetGroupChannelIDs(groupID string) ([]string, error) {
+	syncables, resp, err := c.api.GetGroupSyncables(c.ctx, groupID, model.GroupSyncableTypeChannel, "")
+	if err != nil {
+		return nil, classifyAPIError("", resp, err)
+	}
+	ids := make([]string, 0, len(syncables))
+	for _, s := range syncables {
+		ids = append(ids, s.SyncableId)
+	}
+	return ids, nil
+}
+
 func (c *mmClient) GetLastPostDateForUser(userID, username string, teamIDs []string) (*time.Time, error) {
 	var latestTime *time.Time
 
@@ -158,32 +729,365 @@ func (c *mmClient) GetLastPostDateForUser(userID, username string, teamIDs []str
 	return latestTime, nil
 }
 
+func (c *mmClient) GetDataRetentionPoliciesCount() (int64, error) {
+	count, resp, err := c.api.GetDataRetentionPoliciesCount(c.ctx)
+	if err != nil {
+		return 0, classifyAPIError("", resp, err)
+	}
+	return count, nil
+}
+
+// GetChannelPoliciesForUser returns the custom retention policies applied to
+// channels the user belongs to. Client4 does not pass paging parameters for
+// this endpoint, so the request is built directly.
+func (c *mmClient) GetChannelPoliciesForUser(userID string, page, perPage int) ([]*model.RetentionPolicyForChannel, error) {
+	route := fmt.Sprintf("/users/%s/data_retention/channel_policies?page=%d&per_page=%d", userID, page, perPage)
+	r, err := c.api.DoAPIGet(c.ctx, route, "")
+	if err != nil {
+		return nil, classifyAPIError("", model.BuildResponse(r), err)
+	}
+	defer r.Body.Close()
+
+	var list model.RetentionPolicyForChannelList
+	if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("error: failed to decode retention policies: %w", err)
+	}
+	return list.Policies, nil
+}
+
+func (c *mmClient) GetUserAudits(userID string, page, perPage int) ([]model.Audit, error) {
+	audits, resp, err := c.api.GetUserAudits(c.ctx, userID, page, perPage, "")
+	if err != nil {
+		return nil, classifyAPIError("", resp, err)
+	}
+	return audits, nil
+}
+
+// GetSessions lists the user's sessions, including expired ones not yet cleaned up.
+func (c *mmClient) GetSessions(userID string) ([]*model.Session, error) {
+	sessions, resp, err := c.api.GetSessions(c.ctx, userID, "")
+	if err != nil {
+		return nil, classifyAPIError("", resp, err)
+	}
+	return sessions, nil
+}
+
+// GetTeamMembersForUser returns the user's team memberships, with roles.
+func (c *mmClient) GetTeamMembersForUser(userID string) ([]*model.TeamMember, error) {
+	members, resp, err := c.api.GetTeamMembersForUser(c.ctx, userID, "")
+	if err != nil {
+		return nil, classifyAPIError("", resp, err)
+	}
+	return members, nil
+}
+
+// GetChannelMembersForUser returns the user's channel memberships in a
+// team, with roles. One call covers all of the team's channels.
+func (c *mmClient) GetChannelMembersForUser(userID, teamID string) ([]model.ChannelMember, error) {
+	members, resp, err := c.api.GetChannelMembersForUser(c.ctx, userID, teamID, "")
+	if err != nil {
+		return nil, classifyAPIError("", resp, err)
+	}
+	return members, nil
+}
+
+// GetFileActivityForUser searches each team for files uploaded by the user and
+// returns the number of distinct files and the most recent upload time.
+func (c *mmClient) GetFileActivityForUser(username string, teamIDs []string) (int, *time.Time, error) {
+	seen := make(map[string]bool)
+	var latestTime *time.Time
+
+	for _, teamID := range teamIDs {
+		page := 0
+		perPage := 200
+		for {
+			terms := "from:" + username
+			isOrSearch := false
+			files, resp, err := c.api.SearchFilesWithParams(c.ctx, teamID, &model.SearchParameter{
+				Terms:      &terms,
+				IsOrSearch: &isOrSearch,
+				Page:       &page,
+				PerPage:    &perPage,
+			})
+			if err != nil {
+				if resp != nil && resp.StatusCode == 404 {
+					break
+				}
+				return 0, nil, classifyAPIError("", resp, err)
+			}
+			if files == nil {
+				break
+			}
+			for _, f := range files.FileInfos {
+				// Files in DMs/GMs appear in every team's results
+				if seen[f.Id] {
+					continue
+				}
+				seen[f.Id] = true
+				t := MillisToTime(f.CreateAt)
+				if t != nil && (latestTime == nil || t.After(*latestTime)) {
+					latestTime = t
+				}
+			}
+			if len(files.FileInfos) < perPage {
+				break
+			}
+			page++
+		}
+	}
+
+	return len(seen), latestTime, nil
+}
+
+// GetPostCountsForChannel counts the channel's posts per author (user ID),
+// excluding system messages. Pages are read newest first and reading stops at
+// the first post older than since; a nil since counts the whole history.
+func (c *mmClient) GetPostCountsForChannel(channelID string, since *time.Time) (map[string]int, error) {
+	counts := make(map[string]int)
+	page := 0
+	perPage := 200
+	for {
+		posts, resp, err := c.api.GetPostsForChannel(c.ctx, channelID, page, perPage, "", false, false)
+		if err != nil {
+			return nil, classifyAPIError("", resp, err)
+		}
+		if posts == nil {
+			break
+		}
+		for _, id := range posts.Order {
+			post := posts.Posts[id]
+			if post == nil || post.IsSystemMessage() {
+				continue
+			}
+			if since != nil && post.CreateAt < since.UnixMilli() {
+				return counts, nil
+			}
+			counts[post.UserId]++
+		}
+		if len(posts.Order) < perPage {
+			break
+		}
+		page++
+	}
+	return counts, nil
+}
+
+// GetMentionsOfUser searches each team for posts since the given time that
+// @-mention the user, excluding the user's own posts.
+func (c *mmClient) GetMentionsOfUser(userID, username string, teamIDs []string, since time.Time) ([]*model.Post, error) {
+	seen := make(map[string]bool)
+	var mentions []*model.Post
+
+	// after: is exclusive, so search from the day before and filter exactly below
+	terms := fmt.Sprintf("\"@%s\" after:%s", username, since.UTC().AddDate(0, 0, -1).Format("2006-01-02"))
+	for _, teamID := range teamIDs {
+		page := 0
+		perPage := 200
+		for {
+			isOrSearch := false
+			posts, resp, err := c.api.SearchPostsWithParams(c.ctx, teamID, &model.SearchParameter{
+				Terms:      &terms,
+				IsOrSearch: &isOrSearch,
+				Page:       &page,
+				PerPage:    &perPage,
+			})
+			if err != nil {
+				if resp != nil && resp.StatusCode == 404 {
+					break
+				}
+				return nil, classifyAPIError("", resp, err)
+			}
+			if posts == nil {
+				break
+			}
+			for _, id := range posts.Order {
+				post := posts.Posts[id]
+				// Posts in DMs/GMs appear in every team's results
+				if post == nil || seen[id] || post.UserId == userID || post.CreateAt < since.UnixMilli() {
+					continue
+				}
+				seen[id] = true
+				mentions = append(mentions, post)
+			}
+			if len(posts.Order) < perPage {
+				break
+			}
+			page++
+		}
+	}
+
+	return mentions, nil
+}
+
+// getPluginJSON issues a GET against a plugin route (relative to the server
+// root, not /api/v4) and decodes the JSON response into v.
+func (c *mmClient) getPluginJSON(route string, v any) error {
+	r, err := c.api.DoAPIRequestWithHeaders(c.ctx, http.MethodGet, c.api.URL+route, "", map[string]string{
+		model.HeaderRequestedWith: model.HeaderRequestedWithXML,
+	})
+	if err != nil {
+		return classifyAPIError("", model.BuildResponse(r), err)
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("error: failed to decode %s: %w", route, err)
+	}
+	return nil
+}
+
+// GetBoardMembers lists the team's boards via the Boards plugin and returns
+// user ID → titles of boards the user is a member of. Only boards visible to
+// the authenticated user are included.
+func (c *mmClient) GetBoardMembers(teamID string) (map[string][]string, error) {
+	var boards []struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+	}
+	if err := c.getPluginJSON(fmt.Sprintf("/plugins/focalboard/api/v2/teams/%s/boards", teamID), &boards); err != nil {
+		return nil, err
+	}
+
+	members := make(map[string][]string)
+	for _, b := range boards {
+		var boardMembers []struct {
+			UserID string `json:"userId"`
+		}
+		if err := c.getPluginJSON(fmt.Sprintf("/plugins/focalboard/api/v2/boards/%s/members", b.ID), &boardMembers); err != nil {
+			return nil, err
+		}
+		for _, m := range boardMembers {
+			members[m.UserID] = append(members[m.UserID], b.Title)
+		}
+	}
+	return members, nil
+}
+
+// GetPlaybookMembers pages through the team's playbooks via the Playbooks
+// plugin and returns user ID → titles of playbooks the user is a member of.
+func (c *mmClient) GetPlaybookMembers(teamID string) (map[string][]string, error) {
+	members := make(map[string][]string)
+	page := 0
+	perPage := 200
+	for {
+		var list struct {
+			HasMore bool `json:"has_more"`
+			Items   []struct {
+				Title   string `json:"title"`
+				Members []struct {
+					UserID string `json:"user_id"`
+				} `json:"members"`
+			} `json:"items"`
+		}
+		route := fmt.Sprintf("/plugins/playbooks/api/v0/playbooks?team_id=%s&page=%d&per_page=%d", url.QueryEscape(teamID), page, perPage)
+		if err := c.getPluginJSON(route, &list); err != nil {
+			return nil, err
+		}
+		for _, p := range list.Items {
+			for _, m := range p.Members {
+				members[m.UserID] = append(members[m.UserID], p.Title)
+			}
+		}
+		if !list.HasMore {
+			break
+		}
+		page++
+	}
+	return members, nil
+}
+
+// GetProfileFields lists the server's custom profile attributes. Client4
+// in the model version used here has no call for them, so the request is
+// built directly.
+func (c *mmClient) GetProfileFields() ([]ProfileField, error) {
+	r, err := c.api.DoAPIGet(c.ctx, "/custom_profile_attributes/fields", "")
+	if err != nil {
+		return nil, classifyAPIError("", model.BuildResponse(r), err)
+	}
+	defer r.Body.Close()
+
+	var list []struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Attrs struct {
+			Options []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"options"`
+		} `json:"attrs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("error: failed to decode profile fields: %w", err)
+	}
+	fields := make([]ProfileField, 0, len(list))
+	for _, f := range list {
+		field := ProfileField{ID: f.ID, Name: f.Name}
+		if len(f.Attrs.Options) > 0 {
+			field.Options = make(map[string]string, len(f.Attrs.Options))
+			for _, o := range f.Attrs.Options {
+				field.Options[o.ID] = o.Name
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// GetProfileAttributes returns the user's custom profile attribute values,
+// keyed by field ID. Text fields hold a string, select fields an option ID
+// and multiselect fields a list of them.
+func (c *mmClient) GetProfileAttributes(userID string) (map[string]json.RawMessage, error) {
+	r, err := c.api.DoAPIGet(c.ctx, fmt.Sprintf("/users/%s/custom_profile_attributes", userID), "")
+	if err != nil {
+		return nil, classifyAPIError("", model.BuildResponse(r), err)
+	}
+	defer r.Body.Close()
+
+	var attrs map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&attrs); err != nil {
+		return nil, fmt.Errorf("error: failed to decode profile attributes: %w", err)
+	}
+	return attrs, nil
+}
+
 // ClassifyAPIError maps API response status codes to human-readable error messages.
 func ClassifyAPIError(url string, statusCode int) error {
 	return classifyAPIErrorFromStatus(url, statusCode)
 }
 
 func classifyAPIError(url string, resp *model.Response, err error) error {
+	// A timeout can strike while the body is read, after a 200
+	if IsTimeout(err) {
+		return &APIError{Message: "error: API request timed out. Raise --timeout if the server is slow to answer", Err: err}
+	}
 	if resp == nil {
 		if url != "" {
-			return fmt.Errorf("error: unable to connect to %s. Check the URL and network connectivity", url)
+			return &APIError{Message: fmt.Sprintf("error: unable to connect to %s. Check the URL and network connectivity", url), Err: err}
+		}
+		return &APIError{Message: fmt.Sprintf("error: API request failed: %v", err), Err: err}
+	}
+	apiErr := classifyAPIErrorFromStatus(url, resp.StatusCode)
+	apiErr.Err = err
+	if resp.Header != nil {
+		if secs, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && secs > 0 {
+			apiErr.RetryAfter = time.Duration(secs) * time.Second
 		}
-		return fmt.Errorf("error: API request failed: %w", err)
 	}
-	return classifyAPIErrorFromStatus(url, resp.StatusCode)
+	return apiErr
 }
 
-func classifyAPIErrorFromStatus(url string, statusCode int) error {
+func classifyAPIErrorFromStatus(url string, statusCode int) *APIError {
+	var msg string
 	switch {
 	case statusCode == 401:
-		return fmt.Errorf("error: authentication failed. Check your token or credentials")
+		msg = "error: authentication failed. Check your token or credentials"
 	case statusCode == 403:
-		return fmt.Errorf("error: permission denied. This operation requires a System Administrator account")
+		msg = "error: permission denied. This operation requires a System Administrator account"
 	case statusCode == 404:
-		return fmt.Errorf("error: the requested resource was not found")
+		msg = "error: the requested resource was not found"
 	case statusCode >= 500:
-		return fmt.Errorf("error: the Mattermost server returned an unexpected error (HTTP %d). Check server logs for details", statusCode)
+		msg = fmt.Sprintf("error: the Mattermost server returned an unexpected error (HTTP %d). Check server logs for details", statusCode)
 	default:
-		return fmt.Errorf("error: API request failed (HTTP %d)", statusCode)
+		msg = fmt.Sprintf("error: API request failed (HTTP %d)", statusCode)
 	}
+	return &APIError{StatusCode: statusCode, Message: msg}
 }
@@ -1,7 +1,19 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
 )
 
 func TestClassifyAPIError(t *testing.T) {
@@ -66,3 +78,138 @@ func containsStr(s, sub string) bool {
 	}
 	return false
 }
+
+func TestTimeoutTransport(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	client := &http.Client{Transport: &timeoutTransport{timeout: 50 * time.Millisecond, next: http.DefaultTransport}}
+
+	// A prompt call is unaffected, and its body can be read in full
+	resp, err := client.Get(srv.URL + "/fast")
+	if err != nil {
+		t.Fatalf("fast call: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "ok" {
+		t.Errorf("fast call body = %q, %v", body, err)
+	}
+
+	// A hung call is cut off, and classified as a timeout
+	start := time.Now()
+	_, err = client.Get(srv.URL + "/slow")
+	if !IsTimeout(err) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("timeout did not cut the call short")
+	}
+	apiErr := classifyAPIError("", &model.Response{StatusCode: 200}, err)
+	if !strings.Contains(apiErr.Error(), "timed out") || !IsTimeout(apiErr) || !IsTransient(apiErr) {
+		t.Errorf("classified as %v", apiErr)
+	}
+}
+
+func TestCountingTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Repeat("x", 1500))
+	}))
+	defer srv.Close()
+
+	calls := &countingTransport{next: http.DefaultTransport}
+	client := &http.Client{Transport: calls}
+	for range 2 {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if calls.Count() != 2 || calls.Bytes() != 3000 {
+		t.Errorf("count = %d, bytes = %d; want 2 and 3000", calls.Count(), calls.Bytes())
+	}
+}
+
+func TestIsTimeout(t *testing.T) {
+	if !IsTimeout(fmt.Errorf("search: %w", context.DeadlineExceeded)) {
+		t.Error("wrapped deadline should be a timeout")
+	}
+	if IsTimeout(context.Canceled) || IsTimeout(nil) {
+		t.Error("only deadlines are timeouts")
+	}
+}
+
+func TestNewClient_LocalMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Mattermost local mode uses a unix socket")
+	}
+	socket := filepath.Join(t.TempDir(), "mattermost_local.socket")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("%s sent credentials in local mode", r.URL.Path)
+		}
+		paths = append(paths, r.URL.Path)
+		w.Header().Set(model.HeaderVersionId, "9.11.0.10574498245.2b3fd5b1.true")
+		fmt.Fprint(w, `{"status": "OK"}`)
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	client, err := NewClient(LocalModeURL(socket), "", "", ClientOptions{Socket: socket})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	info := client.ServerInfo()
+	if info.URL != "unix://"+socket || info.Version != "9.11.0" || info.Username != localModeUser {
+		t.Errorf("server info = %+v", info)
+	}
+	if len(paths) == 0 || paths[0] != "/api/v4/system/ping" {
+		t.Errorf("requests = %v, want a ping first", paths)
+	}
+
+	_, err = NewClient(LocalModeURL(socket+".missing"), "", "", ClientOptions{Socket: socket + ".missing"})
+	if err == nil || !strings.Contains(err.Error(), "EnableLocalMode") {
+		t.Errorf("missing socket: err = %v", err)
+	}
+}
+
+func TestNewClient_Operator(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(model.HeaderVersionId, "9.11.0.10574498245.2b3fd5b1.true")
+		if r.URL.Path == "/api/v4/users/me" {
+			fmt.Fprint(w, `{"id": "admin1", "username": "admin"}`)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(srv.URL, "token", "", ClientOptions{})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	op := client.ServerInfo().Operator()
+	if op != (Operator{UserID: "admin1", Username: "admin"}) || op.String() != "admin (admin1)" {
+		t.Errorf("operator = %+v (%s)", op, op)
+	}
+	if got := (Operator{Username: localModeUser}).String(); got != localModeUser {
+		t.Errorf("local mode operator = %q, want %q", got, localModeUser)
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// subcommands are the words accepted before the flags.
+var subcommands = []string{"serve", "undo", "review", "trend", "login", "logout", "completion", "docs"}
+
+// completionShells are the shells `completion` can generate a script for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// flagEnv maps the flags that default to an environment variable to that
+// variable. Their current default is the variable's value, which may be a
+// secret, so generated files name the variable instead.
+var flagEnv = map[string]string{
+	"url":            "MM_URL",
+	"token":          "MM_TOKEN",
+	"username":       "MM_USERNAME",
+	"serve-token":    "MM_SERVE_TOKEN",
+	"notify-webhook": "MM_NOTIFY_WEBHOOK",
+}
+
+// flagChoices lists the values completed for flags that take one of a fixed set.
+var flagChoices = map[string][]string{
+	"format":            {"table", "csv", "json", FormatNDJSON, "sqlite", FormatDOT, FormatGraphML, FormatMattermost, FormatJUnit},
+	"inactivity-metric": {"login", "post", "any", "all", "view"},
+	"auth-method":       authMethods,
+	"date-format":       {"rfc3339", "date", "datetime", "us", "eu"},
+	"split-by":          {SplitByTeam, SplitByServer},
+	"group-by":          {GroupByOwner},
+	"compress":          {CompressGzip},
+	"notify-format":     webhookFormats,
+	"redact":            redactFields,
+}
+
+// fileFlags and dirFlags take a path, completed as a file or a directory.
+var (
+	fileFlags = map[string]bool{"local": true, "from-file": true, "servers": true, "config": true, "allowlist": true, "output": true, "status-file": true, "badge": true, "undo-file": true, "plan": true, "state-file": true}
+	dirFlags  = map[string]bool{"templates": true, "output-dir": true}
+)
+
+// flagDoc is one flag as shown in completion scripts and the man page.
+type flagDoc struct {
+	Name     string // long name, without dashes
+	Short    string // single-letter alias, if any
+	Type     string // value placeholder; empty for boolean flags
+	Default  string
+	Env      string
+	Usage    string
+	Choices  []string
+	File     bool
+	Dir      bool
+	TakesArg bool
+}
+
+// collectFlags describes the flags in fs, sorted by name. Single-letter flags
+// sharing the usage text of a longer flag are folded into it as its alias.
+func collectFlags(fs *flag.FlagSet) []flagDoc {
+	usageOf := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		if len(f.Name) > 1 {
+			usageOf[f.Usage] = f.Name
+		}
+	})
+	shorts := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		if long, ok := usageOf[f.Usage]; ok && len(f.Name) == 1 {
+			shorts[long] = f.Name
+		}
+	})
+
+	var docs []flagDoc
+	fs.VisitAll(func(f *flag.Flag) {
+		if len(f.Name) == 1 && shorts[usageOf[f.Usage]] == f.Name {
+			return
+		}
+		typ, usage := flag.UnquoteUsage(f)
+		d := flagDoc{
+			Name:     f.Name,
+			Short:    shorts[f.Name],
+			Type:     typ,
+			Env:      flagEnv[f.Name],
+			Usage:    usage,
+			Choices:  flagChoices[f.Name],
+			File:     fileFlags[f.Name],
+			Dir:      dirFlags[f.Name],
+			TakesArg: !isBoolFlag(f),
+		}
+		if d.Env == "" && f.DefValue != "" && f.DefValue != "0" && f.DefValue != "0s" && f.DefValue != "false" {
+			d.Default = f.DefValue
+		}
+		docs = append(docs, d)
+	})
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Name < docs[j].Name })
+	return docs
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// WriteCompletion writes a completion script for shell, generated from fs.
+func WriteCompletion(w io.Writer, shell string, fs *flag.FlagSet) error {
+	flags := collectFlags(fs)
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, flags)
+	case "zsh":
+		writeZshCompletion(w, flags)
+	case "fish":
+		writeFishCompletion(w, flags)
+	default:
+		return fmt.Errorf("error: unsupported shell %q. Use %s", shell, strings.Join(completionShells, ", "))
+	}
+	return nil
+}
+
+func writeBashCompletion(w io.Writer, flags []flagDoc) {
+	var names, files, dirs []string
+	fmt.Fprintln(w, "# bash completion for mm-guest-audit")
+	fmt.Fprintln(w, "_mm_guest_audit() {")
+	fmt.Fprintln(w, "    local cur prev")
+	fmt.Fprintln(w, `    cur="${COMP_WORDS[COMP_CWORD]}"`)
+	fmt.Fprintln(w, `    prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+	fmt.Fprintln(w, `    case "$prev" in`)
+	for _, f := range flags {
+		names = append(names, "--"+f.Name)
+		if f.Short != "" {
+			names = append(names, "-"+f.Short)
+		}
+		switch {
+		case len(f.Choices) > 0:
+			fmt.Fprintf(w, "        --%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", f.Name, strings.Join(f.Choices, " "))
+		case f.File:
+			files = append(files, "--"+f.Name)
+		case f.Dir:
+			dirs = append(dirs, "--"+f.Name)
+		}
+	}
+	if len(files) > 0 {
+		fmt.Fprintf(w, "        %s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", strings.Join(files, "|"))
+	}
+	if len(dirs) > 0 {
+		fmt.Fprintf(w, "        %s) COMPREPLY=($(compgen -d -- \"$cur\")); return ;;\n", strings.Join(dirs, "|"))
+	}
+	fmt.Fprintf(w, "        completion) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", strings.Join(completionShells, " "))
+	fmt.Fprintln(w, `        docs) COMPREPLY=($(compgen -W "man" -- "$cur")); return ;;`)
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, `    if [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then`)
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(subcommands, " "))
+	fmt.Fprintln(w, "        return")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintf(w, "    COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -F _mm_guest_audit mm-guest-audit")
+}
+
+func writeZshCompletion(w io.Writer, flags []flagDoc) {
+	fmt.Fprintln(w, "#compdef mm-guest-audit")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "_mm_guest_audit() {")
+	fmt.Fprintln(w, "    local state")
+	fmt.Fprintln(w, "    _arguments -s \\")
+	for _, f := range flags {
+		action := ""
+		if f.TakesArg {
+			switch {
+			case len(f.Choices) > 0:
+				action = fmt.Sprintf(":%s:(%s)", f.Type, strings.Join(f.Choices, " "))
+			case f.File:
+				action = ":file:_files"
+			case f.Dir:
+				action = ":directory:_files -/"
+			default:
+				action = fmt.Sprintf(":%s: ", f.Type)
+			}
+		}
+		spec := fmt.Sprintf("[%s]%s", zshEscape(f.Usage), action)
+		if f.Short != "" {
+			fmt.Fprintf(w, "        '(-%s --%s)'{-%s,--%s}'%s' \\\n", f.Short, f.Name, f.Short, f.Name, spec)
+		} else {
+			fmt.Fprintf(w, "        '--%s%s' \\\n", f.Name, spec)
+		}
+	}
+	fmt.Fprintf(w, "        '1:command:(%s)' \\\n", strings.Join(subcommands, " "))
+	fmt.Fprintln(w, "        '2:argument:->argument'")
+	fmt.Fprintln(w, `    case $state in`)
+	fmt.Fprintln(w, "    argument)")
+	fmt.Fprintln(w, "        case $words[2] in")
+	fmt.Fprintf(w, "        completion) _values shell %s ;;\n", strings.Join(completionShells, " "))
+	fmt.Fprintln(w, "        docs) _values page man ;;")
+	fmt.Fprintln(w, "        esac")
+	fmt.Fprintln(w, "        ;;")
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `_mm_guest_audit "$@"`)
+}
+
+// zshEscape makes s safe inside a single-quoted _arguments description.
+func zshEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
+
+func writeFishCompletion(w io.Writer, flags []flagDoc) {
+	const c = "complete -c mm-guest-audit"
+	fmt.Fprintln(w, "# fish completion for mm-guest-audit")
+	fmt.Fprintf(w, "%s -f\n", c)
+	fmt.Fprintf(w, "%s -n __fish_use_subcommand -a '%s'\n", c, strings.Join(subcommands, " "))
+	fmt.Fprintf(w, "%s -n '__fish_seen_subcommand_from completion' -a '%s'\n", c, strings.Join(completionShells, " "))
+	fmt.Fprintf(w, "%s -n '__fish_seen_subcommand_from docs' -a man\n", c)
+	for _, f := range flags {
+		line := fmt.Sprintf("%s -l %s", c, f.Name)
+		if f.Short != "" {
+			line += " -s " + f.Short
+		}
+		line += fmt.Sprintf(" -d '%s'", fishEscape(f.Usage))
+		switch {
+		case len(f.Choices) > 0:
+			line += fmt.Sprintf(" -x -a '%s'", strings.Join(f.Choices, " "))
+		case f.File, f.Dir:
+			line += " -r -F"
+		case f.TakesArg:
+			line += " -x"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// fishEscape makes s safe inside a single-quoted fish string.
+func fishEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s)
+}
+
+// WriteManPage writes a mm-guest-audit(1) man page in roff, generated from fs.
+func WriteManPage(w io.Writer, fs *flag.FlagSet, version string) {
+	flags := collectFlags(fs)
+	fmt.Fprintf(w, ".TH MM\\-GUEST\\-AUDIT 1 \"\" \"mm\\-guest\\-audit %s\" \"User Commands\"\n", roffEscape(version))
+	fmt.Fprintln(w, ".SH NAME")
+	fmt.Fprintln(w, `mm\-guest\-audit \- audit guest users on a Mattermost instance`)
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	fmt.Fprintln(w, `.B mm\-guest\-audit`)
+	fmt.Fprintln(w, `[\fIflags\fR]`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B mm\-guest\-audit serve`)
+	fmt.Fprintln(w, `[\fIflags\fR]`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B mm\-guest\-audit undo \-\-plan`)
+	fmt.Fprintln(w, `\fIfile\fR [\fIflags\fR]`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B mm\-guest\-audit trend \-\-dir`)
+	fmt.Fprintln(w, `\fIdirectory\fR [\fIflags\fR]`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B mm\-guest\-audit login`)
+	fmt.Fprintln(w, `|`)
+	fmt.Fprintln(w, `.B logout`)
+	fmt.Fprintln(w, `[\fIflags\fR]`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B mm\-guest\-audit completion`)
+	fmt.Fprintln(w, `\fBbash\fR|\fBzsh\fR|\fBfish\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B mm\-guest\-audit docs man`)
+	fmt.Fprintln(w, ".SH DESCRIPTION")
+	fmt.Fprintln(w, "Reports every guest user on a Mattermost instance with the teams and channels")
+	fmt.Fprintln(w, "they can access, when they last logged in and posted, and whether their account")
+	fmt.Fprintln(w, "is active. Guests inactive beyond a threshold can be flagged for access reviews.")
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, ".B serve")
+	fmt.Fprintln(w, "runs the audit behind an HTTP API.")
+	fmt.Fprintln(w, ".B undo")
+	fmt.Fprintln(w, "adds back the channel memberships a")
+	fmt.Fprintln(w, `\fB\-\-remove\-from\-channels\fR`)
+	fmt.Fprintln(w, "run removed, from its undo plan.")
+	fmt.Fprintln(w, ".B trend")
+	fmt.Fprintln(w, "summarizes a directory of saved JSON reports as monthly guest counts.")
+	fmt.Fprintln(w, ".B login")
+	fmt.Fprintln(w, "saves a personal access token for")
+	fmt.Fprintln(w, `\fB\-\-url\fR`)
+	fmt.Fprintln(w, "in the OS credential store, used by later runs without")
+	fmt.Fprintln(w, `\fB\-\-token\fR;`)
+	fmt.Fprintln(w, ".B logout")
+	fmt.Fprintln(w, "removes it.")
+	fmt.Fprintln(w, ".B completion")
+	fmt.Fprintln(w, "and")
+	fmt.Fprintln(w, ".B docs man")
+	fmt.Fprintln(w, "print a shell completion script and this page.")
+	fmt.Fprintln(w, ".SH OPTIONS")
+	for _, f := range flags {
+		fmt.Fprintln(w, ".TP")
+		name := `\fB\-\-` + roffEscape(f.Name) + `\fR`
+		if f.Short != "" {
+			name = `\fB\-` + roffEscape(f.Short) + `\fR, ` + name
+		}
+		if f.Type != "" {
+			name += ` \fI` + roffEscape(f.Type) + `\fR`
+		}
+		fmt.Fprintln(w, name)
+		text := roffEscape(f.Usage)
+		switch {
+		case f.Env != "":
+			text += ` (environment: \fB` + roffEscape(f.Env) + `\fR)`
+		case f.Default != "":
+			text += ` (default: ` + roffEscape(f.Default) + `)`
+		}
+		fmt.Fprintln(w, text)
+	}
+	fmt.Fprintln(w, ".SH ENVIRONMENT")
+	envs := []string{"MM_PASSWORD"}
+	for _, env := range flagEnv {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	for _, env := range envs {
+		fmt.Fprintln(w, ".TP")
+		fmt.Fprintf(w, ".B %s\n", roffEscape(env))
+		if env == "MM_PASSWORD" {
+			fmt.Fprintln(w, `Password for \fB\-\-username\fR authentication. There is no password flag.`)
+			continue
+		}
+		for name, e := range flagEnv {
+			if e == env {
+				fmt.Fprintf(w, "Default for \\fB\\-\\-%s\\fR.\n", roffEscape(name))
+			}
+		}
+	}
+	fmt.Fprintln(w, ".SH EXIT STATUS")
+	for _, e := range []struct {
+		code int
+		text string
+	}{
+		{ExitSuccess, "Successful execution"},
+		{ExitConfigError, "Missing flags, invalid input, auth failure"},
+		{ExitAPIError, "Connection failure, unexpected API response"},
+		{ExitPartialFailure, "Audit completed, but some guests could not be looked up"},
+		{ExitOutputError, "Unable to write output file"},
+	} {
+		fmt.Fprintln(w, ".TP")
+		fmt.Fprintf(w, ".B %d\n", e.code)
+		fmt.Fprintln(w, roffEscape(e.text))
+	}
+}
+
+// roffEscape escapes backslashes and hyphens, and stops a leading dot or
+// quote from being read as a roff request.
+func roffEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// runGenerate handles `completion <shell>` and `docs man`, writing to w.
+func runGenerate(w io.Writer, args []string, fs *flag.FlagSet) int {
+	switch {
+	case args[0] == "completion" && len(args) == 2:
+		if err := WriteCompletion(w, args[1], fs); err != nil {
+			logger.Printf("%v", err)
+			return ExitConfigError
+		}
+		return ExitSuccess
+	case args[0] == "docs" && len(args) == 2 && args[1] == "man":
+		WriteManPage(w, fs, Version)
+		return ExitSuccess
+	case args[0] == "completion":
+		logger.Printf("error: usage: mm-guest-audit completion %s", strings.Join(completionShells, "|"))
+	default:
+		logger.Printf("error: usage: mm-guest-audit docs man")
+	}
+	return ExitConfigError
+}
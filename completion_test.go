@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func testFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("mm-guest-audit", flag.ContinueOnError)
+	fs.String("token", "secret-from-env", "Personal Access Token")
+	fs.String("format", "table", "Output format: table, csv, json, ndjson, sqlite, dot, graphml")
+	fs.String("output-dir", "", "Write output files into this directory")
+	fs.Int("inactive-days", 0, "Flag guests with no activity in the last N days")
+	verbose := fs.Bool("verbose", false, "Enable verbose logging to stderr")
+	fs.BoolVar(verbose, "v", false, "Enable verbose logging to stderr")
+	return fs
+}
+
+func TestCollectFlags(t *testing.T) {
+	flags := collectFlags(testFlagSet())
+
+	var names []string
+	for _, f := range flags {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, ","); got != "format,inactive-days,output-dir,token,verbose" {
+		t.Fatalf("flags = %s", got)
+	}
+	byName := make(map[string]flagDoc)
+	for _, f := range flags {
+		byName[f.Name] = f
+	}
+	if f := byName["verbose"]; f.Short != "v" || f.TakesArg {
+		t.Errorf("verbose = %+v, want short alias v and no argument", f)
+	}
+	if f := byName["token"]; f.Default != "" || f.Env != "MM_TOKEN" {
+		t.Errorf("token = %+v, want no default and env MM_TOKEN", f)
+	}
+	if f := byName["format"]; f.Default != "table" || len(f.Choices) != 9 {
+		t.Errorf("format = %+v, want default table and 9 choices", f)
+	}
+	if f := byName["inactive-days"]; f.Default != "" || f.Type != "int" {
+		t.Errorf("inactive-days = %+v, want no default and type int", f)
+	}
+}
+
+func TestWriteCompletion(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  []string
+	}{
+		{"bash", []string{
+			"complete -F _mm_guest_audit mm-guest-audit",
+			`--format) COMPREPLY=($(compgen -W "table csv json ndjson sqlite dot graphml mattermost junit" -- "$cur"))`,
+			`--output-dir) COMPREPLY=($(compgen -d -- "$cur"))`,
+			"--verbose -v",
+		}},
+		{"zsh", []string{
+			"#compdef mm-guest-audit",
+			"'--format[Output format\\: table, csv, json, ndjson, sqlite, dot, graphml]:string:(table csv json ndjson sqlite dot graphml mattermost junit)'",
+			"'(-v --verbose)'{-v,--verbose}'[Enable verbose logging to stderr]'",
+		}},
+		{"fish", []string{
+			"complete -c mm-guest-audit -l format -d 'Output format: table, csv, json, ndjson, sqlite, dot, graphml' -x -a 'table csv json ndjson sqlite dot graphml mattermost junit'",
+			"complete -c mm-guest-audit -l verbose -s v -d 'Enable verbose logging to stderr'\n",
+			"complete -c mm-guest-audit -l output-dir -d 'Write output files into this directory' -r -F",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.shell, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteCompletion(&buf, tt.shell, testFlagSet()); err != nil {
+				t.Fatalf("WriteCompletion error: %v", err)
+			}
+			out := buf.String()
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("missing %q in:\n%s", want, out)
+				}
+			}
+			if strings.Contains(out, "secret-from-env") {
+				t.Error("completion script contains an environment default")
+			}
+		})
+	}
+
+	if err := WriteCompletion(&bytes.Buffer{}, "tcsh", testFlagSet()); err == nil {
+		t.Error("expected error for unsupported shell")
+	}
+}
+
+func TestWriteManPage(t *testing.T) {
+	var buf bytes.Buffer
+	WriteManPage(&buf, testFlagSet(), "1.2.3")
+	out := buf.String()
+
+	for _, want := range []string{
+		`.TH MM\-GUEST\-AUDIT 1 "" "mm\-guest\-audit 1.2.3"`,
+		"\\fB\\-v\\fR, \\fB\\-\\-verbose\\fR\n",
+		"\\fB\\-\\-format\\fR \\fIstring\\fR\nOutput format: table, csv, json, ndjson, sqlite, dot, graphml (default: table)\n",
+		"Personal Access Token (environment: \\fBMM_TOKEN\\fR)",
+		".SH EXIT STATUS",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "secret-from-env") {
+		t.Error("man page contains an environment default")
+	}
+}
+
+func TestRoffEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"--output-dir", `\-\-output\-dir`},
+		{`C:\reports`, `C:\ereports`},
+		{".hidden", `\&.hidden`},
+		{"'quoted'", `\&'quoted'`},
+	}
+	for _, tt := range tests {
+		if got := roffEscape(tt.in); got != tt.want {
+			t.Errorf("roffEscape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
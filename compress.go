@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// --compress values.
+const (
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+// outputCompression is --compress, applied to every report file and to
+// standard output by openOutput. It is set once in main before anything
+// is written.
+var outputCompression string
+
+// ParseCompress validates the --compress flag value. zstd is recognised but
+// refused: the standard library has no zstd encoder, and the tool keeps to
+// it rather than taking on a compression dependency.
+func ParseCompress(s string) (string, error) {
+	switch s {
+	case "", CompressGzip:
+		return s, nil
+	case CompressZstd:
+		return "", fmt.Errorf("error: --compress zstd is not supported; use --compress gzip, or pipe the report through zstd")
+	}
+	return "", fmt.Errorf("error: invalid --compress %q: use gzip", s)
+}
+
+// compressedName returns name with the extension of outputCompression
+// added, unless it already ends with it, so audit.csv is written as
+// audit.csv.gz.
+func compressedName(name string) string {
+	if outputCompression != CompressGzip || name == "" || strings.HasSuffix(strings.ToLower(name), ".gz") {
+		return name
+	}
+	return name + ".gz"
+}
+
+// compressWriter wraps w in the outputCompression encoder. The returned
+// function flushes the encoder; it must be called before w is closed.
+func compressWriter(w io.Writer) (io.Writer, func() error) {
+	if outputCompression != CompressGzip {
+		return w, func() error { return nil }
+	}
+	gz := gzip.NewWriter(w)
+	return gz, gz.Close
+}
+
+// readReportFile reads the file at path, decompressing it if it was
+// written with --compress gzip, whatever its name.
+func readReportFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		return data, err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
+}
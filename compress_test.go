@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseCompress(t *testing.T) {
+	for _, s := range []string{"", "gzip"} {
+		if _, err := ParseCompress(s); err != nil {
+			t.Errorf("ParseCompress(%q): %v", s, err)
+		}
+	}
+	if _, err := ParseCompress("zstd"); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("zstd: %v", err)
+	}
+	if _, err := ParseCompress("bzip2"); err == nil {
+		t.Error("expected an error for bzip2")
+	}
+}
+
+// compressOutput turns on --compress gzip for the rest of the test.
+func compressOutput(t *testing.T) {
+	t.Helper()
+	outputCompression = CompressGzip
+	t.Cleanup(func() { outputCompression = "" })
+}
+
+func TestCompressedOutput(t *testing.T) {
+	if got := compressedName("audit.json"); got != "audit.json" {
+		t.Errorf("uncompressed name = %q", got)
+	}
+	compressOutput(t)
+	for name, want := range map[string]string{"audit.csv": "audit.csv.gz", "audit.csv.GZ": "audit.csv.GZ", "": ""} {
+		if got := compressedName(name); got != want {
+			t.Errorf("compressedName(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	// Every file of an output directory is compressed and named for it
+	dir := t.TempDir()
+	if err := WriteOutputDir(sampleResult(), "csv", dir); err != nil {
+		t.Fatal(err)
+	}
+	files := OutputDirFiles("csv", dir)
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(f, ".csv.gz") || !slices.Equal(b[:2], []byte{0x1f, 0x8b}) {
+			t.Errorf("%s is not gzipped", f)
+		}
+	}
+	guests, err := readReportFile(files[0])
+	if err != nil || !strings.Contains(string(guests), "jane.doe") {
+		t.Errorf("guests.csv.gz holds %.40q, %v", guests, err)
+	}
+
+	// A compressed JSON report reads back like any other
+	path := filepath.Join(dir, compressedName("audit.json"))
+	if err := WriteOutput(sampleResult(), "json", path); err != nil {
+		t.Fatal(err)
+	}
+	result, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	if len(result.Guests) != len(sampleResult().Guests) {
+		t.Errorf("read back %d guests", len(result.Guests))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultGuestRoles is the system role that identifies guests on a standard installation.
+var DefaultGuestRoles = []string{"system_guest"}
+
+// Config holds settings loaded from the --config file.
+type Config struct {
+	// GuestRoles replaces the default list of system roles that identify guests.
+	GuestRoles []string `yaml:"guest_roles"`
+	// AdditionalGuestRoles are appended to GuestRoles, typically custom roles
+	// that external users carry under a custom permission scheme.
+	AdditionalGuestRoles []string `yaml:"additional_guest_roles"`
+	// AgeBuckets are ascending day boundaries for the summary's activity-age
+	// buckets, e.g. [30, 90, 180].
+	AgeBuckets []int `yaml:"age_buckets"`
+	// ExtraFields are constant columns, e.g. environment: prod, appended to
+	// every CSV and JSON record so reports from several runners can be combined.
+	ExtraFields map[string]string `yaml:"extra_fields"`
+}
+
+// ExtraField is a constant name/value pair added to every report record.
+type ExtraField struct {
+	Name  string
+	Value string
+}
+
+// LoadConfig reads and validates a config file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig parses config YAML (or JSON) content.
+func ParseConfig(data []byte) (*Config, error) {
+	var c Config
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, err
+	}
+	for _, r := range append(c.GuestRoles, c.AdditionalGuestRoles...) {
+		if strings.TrimSpace(r) == "" || strings.ContainsAny(r, ", ") {
+			return nil, fmt.Errorf("invalid guest role %q", r)
+		}
+	}
+	for i, b := range c.AgeBuckets {
+		if b <= 0 || (i > 0 && b <= c.AgeBuckets[i-1]) {
+			return nil, fmt.Errorf("age_buckets must be positive and ascending, got %v", c.AgeBuckets)
+		}
+	}
+	for name := range c.ExtraFields {
+		if strings.TrimSpace(name) == "" || name != strings.TrimSpace(name) {
+			return nil, fmt.Errorf("invalid extra field name %q", name)
+		}
+		if slices.Contains(csvHeader, name) || name == csvServerColumn || strings.HasPrefix(name, csvProfilePrefix) {
+			return nil, fmt.Errorf("extra field %q clashes with a built-in column", name)
+		}
+	}
+	return &c, nil
+}
+
+// ResolveExtraFields returns the configured extra fields sorted by name, so
+// column order is stable between runs. A nil Config yields none.
+func (c *Config) ResolveExtraFields() []ExtraField {
+	if c == nil || len(c.ExtraFields) == 0 {
+		return nil
+	}
+	fields := make([]ExtraField, 0, len(c.ExtraFields))
+	for name, value := range c.ExtraFields {
+		fields = append(fields, ExtraField{Name: name, Value: value})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+// ResolveAgeBuckets returns the configured age bucket boundaries, or
+// DefaultAgeBuckets. A nil Config yields the default.
+func (c *Config) ResolveAgeBuckets() []int {
+	if c == nil || len(c.AgeBuckets) == 0 {
+		return DefaultAgeBuckets
+	}
+	return c.AgeBuckets
+}
+
+// ResolveGuestRoles returns the de-duplicated list of roles that identify a
+// guest. A nil Config yields DefaultGuestRoles.
+func (c *Config) ResolveGuestRoles() []string {
+	base := DefaultGuestRoles
+	var extra []string
+	if c != nil {
+		if len(c.GuestRoles) > 0 {
+			base = c.GuestRoles
+		}
+		extra = c.AdditionalGuestRoles
+	}
+
+	seen := make(map[string]bool)
+	var roles []string
+	for _, r := range append(append([]string{}, base...), extra...) {
+		if !seen[r] {
+			seen[r] = true
+			roles = append(roles, r)
+		}
+	}
+	return roles
+}
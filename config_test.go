@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"unknown key", "guest_role: [system_guest]\n"},
+		{"empty role", "guest_roles: [\"\"]\n"},
+		{"comma in role", "additional_guest_roles: [\"a,b\"]\n"},
+		{"age buckets not ascending", "age_buckets: [90, 30]\n"},
+		{"age bucket zero", "age_buckets: [0, 30]\n"},
+		{"extra field clashes with column", "extra_fields: {username: x}\n"},
+		{"blank extra field name", "extra_fields: {\" \": x}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseConfig([]byte(tt.input)); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestResolveGuestRoles(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"empty config uses default", "", "system_guest"},
+		{"additional roles appended", "additional_guest_roles: [external_contractor]\n", "system_guest,external_contractor"},
+		{"roles replaced", "guest_roles: [partner_user]\n", "partner_user"},
+		{"duplicates removed", "guest_roles: [system_guest]\nadditional_guest_roles: [system_guest, vendor]\n", "system_guest,vendor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseConfig([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("ParseConfig error: %v", err)
+			}
+			if got := strings.Join(c.ResolveGuestRoles(), ","); got != tt.expected {
+				t.Errorf("ResolveGuestRoles() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+
+	var nilConfig *Config
+	if got := strings.Join(nilConfig.ResolveGuestRoles(), ","); got != "system_guest" {
+		t.Errorf("nil config ResolveGuestRoles() = %q, want system_guest", got)
+	}
+}
+
+func TestResolveAgeBuckets(t *testing.T) {
+	c, err := ParseConfig([]byte("age_buckets: [14, 60]\n"))
+	if err != nil {
+		t.Fatalf("ParseConfig error: %v", err)
+	}
+	if got := fmt.Sprint(c.ResolveAgeBuckets()); got != "[14 60]" {
+		t.Errorf("ResolveAgeBuckets() = %s, want [14 60]", got)
+	}
+
+	var nilConfig *Config
+	if got := fmt.Sprint(nilConfig.ResolveAgeBuckets()); got != "[30 90 180]" {
+		t.Errorf("nil config ResolveAgeBuckets() = %s, want [30 90 180]", got)
+	}
+}
+
+func TestResolveExtraFields(t *testing.T) {
+	c, err := ParseConfig([]byte("extra_fields:\n  region: eu\n  environment: prod\n  shard: 3\n"))
+	if err != nil {
+		t.Fatalf("ParseConfig error: %v", err)
+	}
+	want := "[{environment prod} {region eu} {shard 3}]"
+	if got := fmt.Sprint(c.ResolveExtraFields()); got != want {
+		t.Errorf("ResolveExtraFields() = %s, want %s", got, want)
+	}
+
+	var nilConfig *Config
+	if got := nilConfig.ResolveExtraFields(); got != nil {
+		t.Errorf("nil config ResolveExtraFields() = %v, want nil", got)
+	}
+}
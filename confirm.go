@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Confirmation decides whether an action that changes the server may go
+// ahead. Each batch of changes is shown with its counts and confirmed on
+// its own; --yes confirms them all without asking. When standard input is
+// not a terminal nobody can answer, so the action is refused rather than
+// read from whatever is piped in.
+type Confirmation struct {
+	In          io.Reader
+	Out         io.Writer
+	Interactive bool // In is a terminal
+	Yes         bool // --yes
+}
+
+// Proceed shows plan, a description of the batch, and asks "Proceed? [y/N]";
+// only y or yes goes ahead.
+func (c Confirmation) Proceed(plan string) bool {
+	return c.ask(plan, func(r io.Reader, w io.Writer) bool {
+		fmt.Fprint(w, "Proceed? [y/N] ")
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		fmt.Fprintln(w)
+		answer := strings.ToLower(strings.TrimSpace(line))
+		return answer == "y" || answer == "yes"
+	})
+}
+
+// Typed asks with confirm, one of the prompts that want a word typed, such
+// as ConfirmPurge, for the actions too large or final for a y.
+func (c Confirmation) Typed(confirm func(r io.Reader, w io.Writer) bool) bool {
+	return c.ask("", confirm)
+}
+
+func (c Confirmation) ask(plan string, confirm func(r io.Reader, w io.Writer) bool) bool {
+	if c.Yes {
+		return true
+	}
+	if plan != "" {
+		fmt.Fprintln(c.Out, plan)
+	}
+	if !c.Interactive {
+		fmt.Fprintln(c.Out, "Standard input is not a terminal, so the changes cannot be confirmed; rerun with --yes to make them.")
+		return false
+	}
+	return confirm(c.In, c.Out)
+}
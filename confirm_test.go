@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestConfirmation_Proceed(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		interactive bool
+		yes         bool
+		want        bool
+	}{
+		{"y", "y\n", true, false, true},
+		{"yes in capitals", "YES\n", true, false, true},
+		{"no", "n\n", true, false, false},
+		{"no answer", "", true, false, false},
+		{"piped", "y\n", false, false, false}, // nobody at a terminal to answer
+		{"--yes", "", false, true, true},
+	}
+	for _, tt := range tests {
+		var prompt strings.Builder
+		c := Confirmation{In: strings.NewReader(tt.input), Out: &prompt, Interactive: tt.interactive, Yes: tt.yes}
+		if got := c.Proceed("About to remove 2 channel membership(s)"); got != tt.want {
+			t.Errorf("%s: Proceed = %v, want %v", tt.name, got, tt.want)
+		}
+		out := prompt.String()
+		switch {
+		case tt.yes:
+			if out != "" {
+				t.Errorf("%s: asked with --yes: %q", tt.name, out)
+			}
+		case !tt.interactive:
+			if !strings.Contains(out, "About to remove") || !strings.Contains(out, "rerun with --yes") || strings.Contains(out, "Proceed?") {
+				t.Errorf("%s: prompt = %q", tt.name, out)
+			}
+		default:
+			if !strings.HasPrefix(out, "About to remove 2 channel membership(s)\nProceed? [y/N] ") {
+				t.Errorf("%s: prompt = %q", tt.name, out)
+			}
+		}
+	}
+}
+
+func TestConfirmation_Typed(t *testing.T) {
+	asked := 0
+	confirm := func(r io.Reader, w io.Writer) bool {
+		asked++
+		return ConfirmPurge(r, w, 2, 180)
+	}
+	var prompt strings.Builder
+	if !(Confirmation{In: strings.NewReader("purge\n"), Out: &prompt, Interactive: true}).Typed(confirm) || asked != 1 {
+		t.Errorf("typed purge not confirmed, asked %d time(s)", asked)
+	}
+	// A piped answer is no longer enough; --yes is
+	if (Confirmation{In: strings.NewReader("purge\n"), Out: &prompt, Interactive: false}).Typed(confirm) || asked != 1 {
+		t.Errorf("piped purge confirmed, asked %d time(s)", asked)
+	}
+	if !(Confirmation{Out: &prompt, Yes: true}).Typed(confirm) || asked != 1 {
+		t.Errorf("--yes not confirmed, asked %d time(s)", asked)
+	}
+}
+
+func TestDescribePlans(t *testing.T) {
+	if got := DescribeRemovals(PlanChannelRemovals(removalResult())); !strings.HasPrefix(got, "About to remove 1 channel membership(s) of 1 guest(s)") {
+		t.Errorf("removals: %q", got)
+	}
+	got := DescribeQuarantine(PlanQuarantine(quarantineResult(), testQuarantineTeam), testQuarantineTeam)
+	if !strings.HasPrefix(got, "About to quarantine 1 guest(s) in Guest Quarantine: 1 team join(s) and 2 team leave(s), removing them from 3 channel(s).") {
+		t.Errorf("quarantine: %q", got)
+	}
+}
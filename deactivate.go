@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Status of a GuestDeactivation.
+const (
+	DeactivationPlanned     = "planned" // --dry-run: would be deactivated
+	DeactivationDeactivated = "deactivated"
+	DeactivationFailed      = "failed"
+	DeactivationSkipped     = "skipped" // not deactivated, see Reason
+)
+
+// GuestDeactivation is one expired guest account that --deactivate-expired
+// deactivates, or would deactivate with --dry-run.
+type GuestDeactivation struct {
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	CreatedAt string `json:"created_at"` // ISO 8601
+	AgeDays   int    `json:"age_days"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"` // why the deactivation was skipped or failed
+
+	userID        string
+	priorDeleteAt int64 // DeleteAt before the run, for the undo plan
+}
+
+// DeactivationSummary counts deactivations by status.
+type DeactivationSummary struct {
+	Planned     int `json:"planned"`
+	Deactivated int `json:"deactivated"`
+	Failed      int `json:"failed"`
+	Skipped     int `json:"skipped"`
+}
+
+// PlanDeactivations lists the expired guests: active accounts older than
+// --max-guest-age. Allowlisted guests are listed as skipped, so an exception
+// also extends an account's lifetime. Full members listed by
+// --include-members-with-domain are never planned.
+func PlanDeactivations(result *AuditResult) []GuestDeactivation {
+	var plans []GuestDeactivation
+	for _, g := range result.Guests {
+		if g.Failed || !g.Expired || g.ShouldBeGuest {
+			continue
+		}
+		d := planGuestDeactivation(g)
+		if g.Excepted {
+			d.Status = DeactivationSkipped
+			d.Reason = "allowlist exception"
+		}
+		plans = append(plans, d)
+	}
+	return plans
+}
+
+// planGuestDeactivation returns the planned deactivation of one guest,
+// recording the account's state for the undo plan.
+func planGuestDeactivation(g GuestRecord) GuestDeactivation {
+	d := GuestDeactivation{
+		Username:  g.Username,
+		Email:     g.Email,
+		CreatedAt: FormatTimeISO(g.CreatedAt),
+		Status:    DeactivationPlanned,
+		userID:    g.UserID,
+	}
+	if g.DeactivatedAt != nil {
+		d.priorDeleteAt = g.DeactivatedAt.UnixMilli()
+	}
+	if g.AgeDays != nil {
+		d.AgeDays = *g.AgeDays
+	}
+	return d
+}
+
+// deactivateConfirmation is what must be typed to go ahead with
+// --deactivate-expired.
+const deactivateConfirmation = "deactivate"
+
+// ConfirmDeactivation asks on w for the deactivation of n accounts to be
+// confirmed by typing deactivateConfirmation, and reads the answer from r,
+// like ConfirmPurge. A deactivation can be undone, but signing every
+// expired guest out at once is not something to do by accident.
+func ConfirmDeactivation(r io.Reader, w io.Writer, n, maxAge int) bool {
+	fmt.Fprintf(w, "About to deactivate %d guest account(s) created more than %d day(s) ago, signing them out everywhere.\n", n, maxAge)
+	fmt.Fprintf(w, "An undo plan will be written; run with --dry-run first to list them.\n")
+	fmt.Fprintf(w, "Type %q to continue: ", deactivateConfirmation)
+	line, _ := bufio.NewReader(r).ReadString('\n')
+	fmt.Fprintln(w)
+	return strings.TrimSpace(line) == deactivateConfirmation
+}
+
+// ApplyDeactivations deactivates each planned account. A failed
+// deactivation is recorded and the rest carry on; the exit code is
+// ExitPartialFailure if any failed.
+func ApplyDeactivations(client MattermostClient, deactivations []GuestDeactivation, retry RetryPolicy, verbose bool) int {
+	exitCode := ExitSuccess
+	for i := range deactivations {
+		d := &deactivations[i]
+		if d.Status != DeactivationPlanned {
+			continue
+		}
+		op := fmt.Sprintf("deactivating %q", d.Username)
+		err := retry.Do(op, verbose, func() error {
+			return client.DeactivateUser(d.userID)
+		})
+		if err != nil {
+			d.Status = DeactivationFailed
+			d.Reason = err.Error()
+			exitCode = ExitPartialFailure
+			if verbose {
+				logger.Printf("Warning: %s failed: %v", op, err)
+			}
+			continue
+		}
+		d.Status = DeactivationDeactivated
+		if verbose {
+			logger.Printf("Deactivated %q", d.Username)
+		}
+	}
+	return exitCode
+}
+
+// SummarizeDeactivations counts deactivations by status.
+func SummarizeDeactivations(deactivations []GuestDeactivation) DeactivationSummary {
+	var s DeactivationSummary
+	for _, d := range deactivations {
+		switch d.Status {
+		case DeactivationPlanned:
+			s.Planned++
+		case DeactivationDeactivated:
+			s.Deactivated++
+		case DeactivationFailed:
+			s.Failed++
+		case DeactivationSkipped:
+			s.Skipped++
+		}
+	}
+	return s
+}
+
+// WriteDeactivations writes the deactivation plan or outcome in the given
+// format, with the usual stdout fallback when the output file cannot be
+// written.
+func WriteDeactivations(deactivations []GuestDeactivation, dryRun bool, op Operator, format, outputPath string) error {
+	w, closeOutput := openOutput(outputPath)
+	defer closeOutput()
+
+	switch format {
+	case "csv":
+		return writeDeactivationsCSV(w, deactivations, op)
+	case "json":
+		return writeDeactivationsJSON(w, deactivations, dryRun, op)
+	default:
+		return writeDeactivationsTable(w, deactivations, dryRun, op)
+	}
+}
+
+func writeDeactivationsTable(w io.Writer, deactivations []GuestDeactivation, dryRun bool, op Operator) error {
+	if dryRun {
+		fmt.Fprintln(w, "⚠  DRY RUN — no changes have been made to your Mattermost instance.")
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Operator: %s\n\n", op)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "USERNAME\tEMAIL\tCREATED\tAGE (DAYS)\tSTATUS\tREASON")
+	for _, d := range deactivations {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", d.Username, d.Email, d.CreatedAt, d.AgeDays, d.Status, d.Reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	s := SummarizeDeactivations(deactivations)
+	fmt.Fprintln(w)
+	var err error
+	if dryRun {
+		_, err = fmt.Fprintf(w, "%d account(s) would be deactivated, %d skipped\n", s.Planned, s.Skipped)
+	} else {
+		_, err = fmt.Fprintf(w, "%d account(s) deactivated, %d failed, %d skipped\n", s.Deactivated, s.Failed, s.Skipped)
+	}
+	return err
+}
+
+func writeDeactivationsCSV(w io.Writer, deactivations []GuestDeactivation, op Operator) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	if err := cw.Write([]string{"username", "email", "created_at", "age_days", "status", "reason", "operator_id", "operator"}); err != nil {
+		return err
+	}
+	for _, d := range deactivations {
+		if err := cw.Write([]string{d.Username, d.Email, d.CreatedAt, strconv.Itoa(d.AgeDays), d.Status, d.Reason, op.UserID, op.Username}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeDeactivationsJSON(w io.Writer, deactivations []GuestDeactivation, dryRun bool, op Operator) error {
+	if deactivations == nil {
+		deactivations = []GuestDeactivation{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		DryRun        bool                `json:"dry_run"`
+		Operator      Operator            `json:"operator"`
+		Summary       DeactivationSummary `json:"summary"`
+		Deactivations []GuestDeactivation `json:"deactivations"`
+	}{dryRun, op, SummarizeDeactivations(deactivations), deactivations})
+}
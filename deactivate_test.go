@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func deactivationResult() *AuditResult {
+	created := time.Date(2023, 5, 2, 9, 30, 0, 0, time.UTC)
+	age := 790
+	return &AuditResult{Guests: []GuestRecord{
+		{Username: "old", Email: "old@example.com", UserID: "user0", CreatedAt: &created, AgeDays: &age, Active: true, Expired: true},
+		{Username: "new", UserID: "user1", Active: true},
+		{Username: "excepted", UserID: "user2", Active: true, Expired: true, Excepted: true},
+		{Username: "member", UserID: "user3", Active: true, Expired: true, ShouldBeGuest: true},
+		{Username: "other", UserID: "user4", Active: true, Expired: true},
+		{Username: "failed", Failed: true, Expired: true},
+	}}
+}
+
+func TestPlanDeactivations(t *testing.T) {
+	plans := PlanDeactivations(deactivationResult())
+	if len(plans) != 3 {
+		t.Fatalf("expected 3 deactivations, got %d: %+v", len(plans), plans)
+	}
+	if d := plans[0]; d.Username != "old" || d.Status != DeactivationPlanned || d.userID != "user0" || d.CreatedAt != "2023-05-02T09:30:00Z" || d.AgeDays != 790 {
+		t.Errorf("unexpected deactivation: %+v", d)
+	}
+	if d := plans[1]; d.Username != "excepted" || d.Status != DeactivationSkipped || d.Reason != "allowlist exception" {
+		t.Errorf("excepted guest should be skipped: %+v", d)
+	}
+}
+
+func TestApplyDeactivations(t *testing.T) {
+	client := &mockClient{deactivateErr: map[string]error{"user4": &APIError{StatusCode: 403, Message: "forbidden"}}}
+	deactivations := PlanDeactivations(deactivationResult())
+	if exitCode := ApplyDeactivations(client, deactivations, RetryPolicy{}, false); exitCode != ExitPartialFailure {
+		t.Errorf("exit code = %d, want %d", exitCode, ExitPartialFailure)
+	}
+	if len(client.deactivated) != 1 || client.deactivated[0] != "user0" {
+		t.Errorf("deactivated = %v, want [user0]", client.deactivated)
+	}
+	want := DeactivationSummary{Deactivated: 1, Failed: 1, Skipped: 1}
+	if got := SummarizeDeactivations(deactivations); got != want {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+	if deactivations[2].Reason != "forbidden" {
+		t.Errorf("failed deactivation reason = %q", deactivations[2].Reason)
+	}
+}
+
+func TestConfirmDeactivation(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"deactivate\n", true},
+		{"deactivate", true}, // piped without a newline
+		{"yes\n", false},
+		{"purge\n", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		var prompt bytes.Buffer
+		if got := ConfirmDeactivation(strings.NewReader(tt.input), &prompt, 3, 365); got != tt.want {
+			t.Errorf("ConfirmDeactivation(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+		if !strings.Contains(prompt.String(), "deactivate 3 guest account(s) created more than 365 day(s) ago") {
+			t.Errorf("prompt = %q", prompt.String())
+		}
+	}
+}
+
+func TestWriteDeactivations(t *testing.T) {
+	deactivations := PlanDeactivations(deactivationResult())
+
+	var buf bytes.Buffer
+	if err := writeDeactivationsTable(&buf, deactivations, true, testOperator); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "⚠  DRY RUN — no changes have been made to your Mattermost instance.") {
+		t.Errorf("dry run table missing the banner:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "Operator: admin (admin1)") || !strings.Contains(buf.String(), "2 account(s) would be deactivated, 1 skipped") {
+		t.Errorf("dry run table missing the summary:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := writeDeactivationsCSV(&buf, deactivations[:1], testOperator); err != nil {
+		t.Fatal(err)
+	}
+	want := "username,email,created_at,age_days,status,reason,operator_id,operator\nold,old@example.com,2023-05-02T09:30:00Z,790,planned,,admin1,admin\n"
+	if buf.String() != want {
+		t.Errorf("CSV =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := writeDeactivationsJSON(&buf, nil, true, testOperator); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		DryRun        bool                `json:"dry_run"`
+		Operator      Operator            `json:"operator"`
+		Deactivations []GuestDeactivation `json:"deactivations"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if !out.DryRun || out.Operator != testOperator || out.Deactivations == nil || !strings.Contains(buf.String(), `"deactivations": []`) {
+		t.Errorf("JSON = %s, want dry_run true and an empty deactivations list", buf.String())
+	}
+}
@@ -1,5 +1,11 @@
 package main
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
 // Exit codes — consistent with the Mattermost Admin Utilities family (CLAUDE.md).
 const (
 	ExitSuccess        = 0 // Successful execution
@@ -7,4 +13,92 @@ const (
 	ExitAPIError       = 2 // Connection failure, unexpected API response
 	ExitPartialFailure = 3 // Operation completed but with some failures
 	ExitOutputError    = 4 // Unable to write output file
+
+	// Audit findings over the --fail-threshold, with --fail-on-inactive or
+	// --fail-on-violations
+	ExitInactiveGuests   = 5
+	ExitPolicyViolations = 6
 )
+
+// ExitCodeName returns a short name for an exit code, for machine-readable
+// status output.
+func ExitCodeName(code int) string {
+	switch code {
+	case ExitSuccess:
+		return "success"
+	case ExitConfigError:
+		return "config_error"
+	case ExitAPIError:
+		return "api_error"
+	case ExitPartialFailure:
+		return "partial_failure"
+	case ExitOutputError:
+		return "output_error"
+	case ExitInactiveGuests:
+		return "inactive_guests"
+	case ExitPolicyViolations:
+		return "policy_violations"
+	default:
+		return "unknown"
+	}
+}
+
+// APIError is a failed Mattermost API call with a human-readable message.
+// StatusCode is zero when the server could not be reached.
+type APIError struct {
+	StatusCode int
+	RetryAfter time.Duration // from the Retry-After header, if any
+	Message    string
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// IsTransient reports whether err is worth retrying: connection failures,
+// rate limiting (429) and server errors (5xx).
+func IsTransient(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == 0 || apiErr.StatusCode == 429 || apiErr.StatusCode >= 500
+}
+
+// IsTimeout reports whether err is a call cut off by --timeout.
+func IsTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// IsPermissionDenied reports whether err is a 403: the token is valid but
+// lacks a permission the request needs.
+func IsPermissionDenied(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == 403
+}
+
+// IsNotFound reports whether err is a 404.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == 404
+}
+
+// IsUnsupported reports whether err means the server does not offer the
+// requested feature: forbidden (403), not found (404) or not implemented (501).
+// This is typical of Cloud workspaces and unlicensed installations.
+func IsUnsupported(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case 403, 404, 501:
+		return true
+	}
+	return false
+}
@@ -5,6 +5,7 @@ go 1.22.1
 require (
 	github.com/mattermost/mattermost/server/public v0.1.9
 	golang.org/x/term v0.27.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -45,5 +46,4 @@ require (
 	google.golang.org/grpc v1.65.0 // indirect
 	google.golang.org/protobuf v1.34.2 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
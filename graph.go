@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Graph formats for --format, written by writeDOT and writeGraphML.
+const (
+	FormatDOT     = "dot"
+	FormatGraphML = "graphml"
+)
+
+// IsGraphFormat reports whether format is one of the graph formats, which
+// hold guests and channels only: no summary, metadata or per-guest fields.
+func IsGraphFormat(format string) bool {
+	return format == FormatDOT || format == FormatGraphML
+}
+
+// teamPalette colours channels and memberships by team, in team name order.
+// Past the end of the palette, colours repeat.
+var teamPalette = []string{"#4e79a7", "#f28e2b", "#59a14f", "#e15759", "#76b7b2", "#edc948", "#b07aa1", "#ff9da7", "#9c755f", "#bab0ac"}
+
+// guestColors fills guest nodes by status, so inactive access stands out.
+var guestColors = map[string]string{
+	"Active":                  "#ffffff",
+	"Inactive":                "#f4a6a6",
+	"Deactivated":             "#d9d9d9",
+	"Excepted":                "#c6e5b1",
+	"Member, should be guest": "#ffe08a",
+	"Member (deactivated)":    "#d9d9d9",
+	"Lookup failed":           "#d9d9d9",
+}
+
+// accessGraph is the bipartite graph of guests and the channels they can
+// read, shared by the DOT and GraphML writers.
+type accessGraph struct {
+	Guests   []graphGuest
+	Channels []graphChannel
+	Edges    []graphEdge
+}
+
+type graphGuest struct {
+	ID       string
+	Username string
+	Status   string
+}
+
+type graphChannel struct {
+	ID      string
+	Team    string
+	Channel string
+	Type    string
+	Color   string
+}
+
+type graphEdge struct {
+	Guest, Channel string // node IDs
+	Team           string
+	Color          string
+}
+
+// buildAccessGraph builds the graph for result. Channels are matched by
+// team and channel name, since reports loaded with --from-file carry no
+// channel IDs. Guests with no channels are kept as unconnected nodes.
+func buildAccessGraph(result *AuditResult) accessGraph {
+	teamNames := make(map[string]bool)
+	for _, g := range result.Guests {
+		for _, ch := range g.Channels {
+			teamNames[ch.TeamName] = true
+		}
+	}
+	sorted := make([]string, 0, len(teamNames))
+	for name := range teamNames {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	colors := make(map[string]string, len(sorted))
+	for i, name := range sorted {
+		colors[name] = teamPalette[i%len(teamPalette)]
+	}
+
+	var graph accessGraph
+	channelIDs := make(map[string]string)
+	for i, g := range result.Guests {
+		guestID := fmt.Sprintf("g%d", i)
+		status := guestStatus(g)
+		if g.Failed {
+			status = "Lookup failed"
+		}
+		graph.Guests = append(graph.Guests, graphGuest{ID: guestID, Username: g.Username, Status: status})
+		for _, ch := range g.Channels {
+			key := ch.TeamName + "\x00" + ch.ChannelName
+			id, ok := channelIDs[key]
+			if !ok {
+				id = fmt.Sprintf("c%d", len(graph.Channels))
+				channelIDs[key] = id
+				graph.Channels = append(graph.Channels, graphChannel{ID: id, Team: ch.TeamName, Channel: ch.ChannelName, Type: ch.Type, Color: colors[ch.TeamName]})
+			}
+			graph.Edges = append(graph.Edges, graphEdge{Guest: guestID, Channel: id, Team: ch.TeamName, Color: colors[ch.TeamName]})
+		}
+	}
+	return graph
+}
+
+// writeDOT writes the guest-channel graph in Graphviz DOT, e.g. for
+// `dot -Tsvg` or `sfdp -Tsvg` on larger graphs. Guests are ellipses filled by
+// status; channels are boxes filled by team, with a bold border if private.
+func writeDOT(w io.Writer, result *AuditResult) error {
+	graph := buildAccessGraph(result)
+	var b strings.Builder
+	b.WriteString("graph guest_access {\n")
+	fmt.Fprintf(&b, "  label=%s;\n", dotQuote(graphTitle(result)))
+	b.WriteString("  labelloc=t;\n  overlap=false;\n  node [fontname=\"Helvetica\", style=filled];\n")
+	for _, g := range graph.Guests {
+		fmt.Fprintf(&b, "  %s [label=%s, shape=ellipse, fillcolor=%s, tooltip=%s];\n", g.ID, dotQuote(g.Username), dotQuote(guestColors[g.Status]), dotQuote(g.Status))
+	}
+	for _, ch := range graph.Channels {
+		penwidth := 1
+		if ch.Type == ChannelTypePrivate {
+			penwidth = 3
+		}
+		fmt.Fprintf(&b, "  %s [label=%s, shape=box, fillcolor=%s, penwidth=%d, tooltip=%s];\n", ch.ID, dotQuote(ch.Channel), dotQuote(ch.Color), penwidth, dotQuote(ch.Team+"/"+ch.Channel))
+	}
+	for _, e := range graph.Edges {
+		fmt.Fprintf(&b, "  %s -- %s [color=%s];\n", e.Guest, e.Channel, dotQuote(e.Color))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// dotQuote quotes s as a DOT string.
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// graphTitle labels the graph with the server and the number of guests.
+func graphTitle(result *AuditResult) string {
+	title := fmt.Sprintf("Guest channel access: %d guest(s)", result.Summary.TotalGuests)
+	if result.Metadata != nil && result.Metadata.ServerURL != "" {
+		title += " on " + result.Metadata.ServerURL
+	}
+	return title
+}
+
+// GraphML document, in the subset Gephi, yEd and networkx read: typed keys
+// and <data> values on nodes and edges.
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// graphMLKeys declares the node and edge attributes. kind is guest or
+// channel, for partitioning the bipartite graph; team is what Gephi's
+// partition colouring is most useful on.
+var graphMLKeys = []graphMLKey{
+	{ID: "kind", For: "node", Name: "kind", Type: "string"},
+	{ID: "label", For: "node", Name: "label", Type: "string"},
+	{ID: "status", For: "node", Name: "status", Type: "string"},
+	{ID: "team", For: "node", Name: "team", Type: "string"},
+	{ID: "channel_type", For: "node", Name: "channel_type", Type: "string"},
+	{ID: "color", For: "node", Name: "color", Type: "string"},
+	{ID: "edge_team", For: "edge", Name: "team", Type: "string"},
+	{ID: "edge_color", For: "edge", Name: "color", Type: "string"},
+}
+
+// writeGraphML writes the guest-channel graph in GraphML, e.g. for Gephi.
+func writeGraphML(w io.Writer, result *AuditResult) error {
+	graph := buildAccessGraph(result)
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys:  graphMLKeys,
+		Graph: graphMLGraph{ID: "guest_access", EdgeDefault: "undirected"},
+	}
+	for _, g := range graph.Guests {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: g.ID, Data: []graphMLData{
+			{"kind", "guest"}, {"label", g.Username}, {"status", g.Status}, {"color", guestColors[g.Status]},
+		}})
+	}
+	for _, ch := range graph.Channels {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: ch.ID, Data: []graphMLData{
+			{"kind", "channel"}, {"label", ch.Channel}, {"team", ch.Team}, {"channel_type", ch.Type}, {"color", ch.Color},
+		}})
+	}
+	for i, e := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{ID: fmt.Sprintf("e%d", i), Source: e.Guest, Target: e.Channel, Data: []graphMLData{
+			{"edge_team", e.Team}, {"edge_color", e.Color},
+		}})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
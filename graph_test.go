@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestBuildAccessGraph(t *testing.T) {
+	result := sampleResult()
+	result.Guests[1].Channels = append(result.Guests[1].Channels, ChannelInfo{TeamName: "Engineering", ChannelName: "General"})
+	graph := buildAccessGraph(result)
+
+	if len(graph.Guests) != 2 {
+		t.Fatalf("expected 2 guest nodes, got %d", len(graph.Guests))
+	}
+	// Channels shared by several guests are one node
+	seen := make(map[string]int)
+	for _, ch := range graph.Channels {
+		seen[ch.Team+"/"+ch.Channel]++
+	}
+	for name, n := range seen {
+		if n != 1 {
+			t.Errorf("channel %s has %d nodes", name, n)
+		}
+	}
+	edges := 0
+	for _, g := range result.Guests {
+		edges += len(g.Channels)
+	}
+	if len(graph.Edges) != edges {
+		t.Errorf("expected %d edges, got %d", edges, len(graph.Edges))
+	}
+
+	// Each team has its own colour
+	colors := make(map[string]string)
+	for _, ch := range graph.Channels {
+		if c, ok := colors[ch.Team]; ok && c != ch.Color {
+			t.Errorf("team %s has two colours", ch.Team)
+		}
+		colors[ch.Team] = ch.Color
+	}
+	if colors["Engineering"] == colors["Sales"] {
+		t.Error("expected Engineering and Sales in different colours")
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	result := sampleResult()
+	result.Guests[0].Username = `jane "jd" doe`
+
+	var buf bytes.Buffer
+	if err := writeDOT(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"graph guest_access {\n",
+		`g0 [label="jane \"jd\" doe", shape=ellipse`,
+		`g1 [label="bob.contractor", shape=ellipse, fillcolor="#f4a6a6"`,
+		"shape=box",
+		"g0 -- c0 [color=",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("DOT output missing %q:\n%s", want, out)
+		}
+	}
+	if !strings.HasSuffix(out, "}\n") {
+		t.Errorf("DOT output not closed:\n%s", out)
+	}
+}
+
+func TestWriteGraphML(t *testing.T) {
+	result := sampleResult()
+	result.Guests[0].Channels[0].ChannelName = "R&D <core>"
+
+	var buf bytes.Buffer
+	if err := writeGraphML(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	var doc graphML
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid GraphML: %v\n%s", err, buf.String())
+	}
+	graph := buildAccessGraph(result)
+	if len(doc.Graph.Nodes) != len(graph.Guests)+len(graph.Channels) || len(doc.Graph.Edges) != len(graph.Edges) {
+		t.Errorf("got %d nodes and %d edges, want %d and %d", len(doc.Graph.Nodes), len(doc.Graph.Edges), len(graph.Guests)+len(graph.Channels), len(graph.Edges))
+	}
+	if doc.Graph.EdgeDefault != "undirected" || len(doc.Keys) != len(graphMLKeys) {
+		t.Errorf("unexpected graph header: %+v", doc.Graph)
+	}
+	if !strings.Contains(buf.String(), "R&amp;D &lt;core&gt;") {
+		t.Errorf("channel name not escaped:\n%s", buf.String())
+	}
+}
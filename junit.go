@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// FormatJUnit writes the audit as JUnit XML, so CI servers such as Jenkins
+// and GitLab show guest hygiene on their test dashboards.
+const FormatJUnit = "junit"
+
+// The two suites of a JUnit report: one test case per policy rule, and one
+// per guest for inactivity.
+const (
+	junitPolicySuite   = "Guest policy"
+	junitActivitySuite = "Guest activity"
+)
+
+// junitRule is a guest policy rule reported as a test case. finding
+// describes how g breaks the rule, or is empty when it does not. checked
+// reports whether the run checked the rule at all; unchecked rules are
+// skipped rather than passed.
+type junitRule struct {
+	name    string
+	kind    string // the failure type, one per rule
+	checked func(*AuditResult) bool
+	finding func(result *AuditResult, g GuestRecord) string
+}
+
+// junitRules are the violations of --fail-on-violations, in the order
+// Violations counts them. Members who should be guests are the only
+// findings made on members rather than guests.
+var junitRules = []junitRule{
+	{
+		name: "no guest holds an elevated team or channel role",
+		kind: "ElevatedRole",
+		finding: func(result *AuditResult, g GuestRecord) string {
+			if g.ShouldBeGuest || len(g.ElevatedRoles) == 0 {
+				return ""
+			}
+			roles := make([]string, len(g.ElevatedRoles))
+			for i, r := range g.ElevatedRoles {
+				roles[i] = r.String()
+			}
+			return strings.Join(roles, ", ")
+		},
+	},
+	{
+		name: "no guest account is shared",
+		kind: "SharedAccount",
+		checked: func(result *AuditResult) bool {
+			for _, g := range result.Guests {
+				if g.SharedAccount != nil {
+					return true
+				}
+			}
+			return false
+		},
+		finding: func(result *AuditResult, g GuestRecord) string {
+			if g.ShouldBeGuest || g.SharedAccount == nil || !*g.SharedAccount {
+				return ""
+			}
+			return "concurrent sessions from " + strings.Join(g.SharedSessionIPs, ", ")
+		},
+	},
+	{
+		name:    "no active guest is older than the maximum guest age",
+		kind:    "ExpiredGuest",
+		checked: func(result *AuditResult) bool { return result.MaxGuestAge > 0 },
+		finding: func(result *AuditResult, g GuestRecord) string {
+			if g.ShouldBeGuest || !g.Expired {
+				return ""
+			}
+			return fmt.Sprintf("account older than %d days", result.MaxGuestAge)
+		},
+	},
+	{
+		name:    "no active guest has a password older than the maximum password age",
+		kind:    "ExpiredPassword",
+		checked: func(result *AuditResult) bool { return result.MaxPasswordAge > 0 },
+		finding: func(result *AuditResult, g GuestRecord) string {
+			if g.ShouldBeGuest || !g.PasswordExpired {
+				return ""
+			}
+			return fmt.Sprintf("password older than %d days", result.MaxPasswordAge)
+		},
+	},
+	{
+		name: "no member should be a guest",
+		kind: "MemberShouldBeGuest",
+		finding: func(result *AuditResult, g GuestRecord) string {
+			if !g.ShouldBeGuest {
+				return ""
+			}
+			return "member with email " + g.Email
+		},
+	},
+}
+
+// JUnit XML document, in the subset Jenkins, GitLab and Azure DevOps all
+// read.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Errors     int              `xml:"errors,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Timestamp  string           `xml:"timestamp,attr,omitempty"`
+	Hostname   string           `xml:"hostname,attr,omitempty"`
+	Properties *junitProperties `xml:"properties"`
+	Cases      []junitTestCase  `xml:"testcase"`
+}
+
+type junitProperties struct {
+	Property []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitProblem `xml:"failure"`
+	Error     *junitProblem `xml:"error"`
+	Skipped   *junitProblem `xml:"skipped"`
+}
+
+// junitProblem is a failure, error or skipped element: a one-line message
+// and, for failures and errors, the details as text.
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// add appends c to the suite and counts its outcome.
+func (s *junitTestSuite) add(c junitTestCase) {
+	s.Cases = append(s.Cases, c)
+	s.Tests++
+	switch {
+	case c.Failure != nil:
+		s.Failures++
+	case c.Error != nil:
+		s.Errors++
+	case c.Skipped != nil:
+		s.Skipped++
+	}
+}
+
+// JUnitReport builds the JUnit document for result. Policy rules fail with
+// every guest that breaks them listed, whatever --fail-threshold allows, so
+// the dashboard shows each regression; the exit code still follows the exit
+// policy. Each guest passes unless inactive, is skipped when deactivated or
+// excepted, and is an error when its lookup failed.
+func JUnitReport(result *AuditResult) junitTestSuites {
+	var stamp, host string
+	var props *junitProperties
+	addProp := func(name, value string) {
+		if props == nil {
+			props = &junitProperties{}
+		}
+		props.Property = append(props.Property, junitProperty{Name: name, Value: value})
+	}
+	if m := result.Metadata; m != nil {
+		if !m.StartedAt.IsZero() {
+			stamp = m.StartedAt.UTC().Format("2006-01-02T15:04:05")
+		}
+		host = strings.TrimPrefix(strings.TrimPrefix(m.ServerURL, "https://"), "http://")
+		for _, f := range m.Filters {
+			addProp(f.Name, f.Value)
+		}
+	}
+	if result.InactiveDays > 0 {
+		addProp("inactive_days", fmt.Sprint(result.InactiveDays))
+	}
+
+	policy := junitTestSuite{Name: junitPolicySuite, Timestamp: stamp, Hostname: host, Properties: props}
+	for _, rule := range junitRules {
+		c := junitTestCase{Name: rule.name, ClassName: "mm-guest-audit.policy"}
+		if rule.checked != nil && !rule.checked(result) {
+			c.Skipped = &junitProblem{Message: "not checked in this run"}
+			policy.add(c)
+			continue
+		}
+		var found []string
+		for _, g := range result.Guests {
+			if g.Failed {
+				continue
+			}
+			if f := rule.finding(result, g); f != "" {
+				found = append(found, junitGuestName(g)+": "+f)
+			}
+		}
+		if len(found) > 0 {
+			c.Failure = &junitProblem{
+				Message: fmt.Sprintf("%d account(s) found", len(found)),
+				Type:    rule.kind,
+				Text:    strings.Join(found, "\n"),
+			}
+		}
+		policy.add(c)
+	}
+
+	activity := junitTestSuite{Name: junitActivitySuite, Timestamp: stamp, Hostname: host}
+	for _, g := range result.Guests {
+		if g.ShouldBeGuest {
+			continue
+		}
+		c := junitTestCase{Name: junitGuestName(g), ClassName: "mm-guest-audit.activity"}
+		switch {
+		case g.Failed:
+			msgs := make([]string, len(g.Errors))
+			for i, e := range g.Errors {
+				msgs[i] = e.Stage + ": " + e.Message
+			}
+			c.Error = &junitProblem{Message: "lookup failed", Type: "LookupFailed", Text: strings.Join(msgs, "\n")}
+		case !g.Active:
+			c.Skipped = &junitProblem{Message: "deactivated"}
+		case g.Excepted:
+			c.Skipped = &junitProblem{Message: strings.TrimSuffix("excepted: "+g.ExceptionJustification, ": ")}
+		case g.Inactive:
+			c.Failure = &junitProblem{Message: junitInactivity(result, g), Type: "Inactive"}
+		}
+		activity.add(c)
+	}
+
+	doc := junitTestSuites{Name: "mm-guest-audit", Suites: []junitTestSuite{policy, activity}}
+	for _, s := range doc.Suites {
+		doc.Tests += s.Tests
+		doc.Failures += s.Failures
+		doc.Errors += s.Errors
+		doc.Skipped += s.Skipped
+	}
+	return doc
+}
+
+// junitGuestName names a guest's test case: the username, after the server
+// name with --servers, since usernames repeat across servers.
+func junitGuestName(g GuestRecord) string {
+	if g.Server != "" {
+		return g.Server + "/" + g.Username
+	}
+	return g.Username
+}
+
+// junitInactivity is the failure message for an inactive guest.
+func junitInactivity(result *AuditResult, g GuestRecord) string {
+	msg := "inactive"
+	if result.InactiveDays > 0 {
+		msg = fmt.Sprintf("no activity in the last %d days", result.InactiveDays)
+	}
+	if last := LastActivity(g.LastLogin, g.LastPost); last != nil {
+		return msg + fmt.Sprintf(" (last active %s)", last.UTC().Format(time.DateOnly))
+	}
+	return msg + " (never active)"
+}
+
+func writeJUnit(w io.Writer, result *AuditResult) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(JUnitReport(result)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJUnitReport(t *testing.T) {
+	result := webhookResult()
+	result.MaxGuestAge = 365
+	result.Guests[0].ElevatedRoles = []RoleGrant{{TeamName: "Engineering", Role: "team_admin"}}
+	result.Guests[0].Expired = true
+	result.Guests = append(result.Guests,
+		GuestRecord{Username: "broken", Active: true, Failed: true, Expired: true, Errors: []LookupError{{Stage: LookupChannels, Message: "timeout"}}},
+		GuestRecord{Username: "member", Email: "member@partner.com", Active: true, ShouldBeGuest: true},
+	)
+	result.Metadata.StartedAt = time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+
+	doc := JUnitReport(result)
+	if len(doc.Suites) != 2 {
+		t.Fatalf("suites = %+v", doc.Suites)
+	}
+	policy, activity := doc.Suites[0], doc.Suites[1]
+	if policy.Hostname != "chat.example.com" || policy.Timestamp != "2026-10-16T09:00:00" {
+		t.Errorf("policy suite = %s at %s", policy.Hostname, policy.Timestamp)
+	}
+
+	cases := make(map[string]junitTestCase)
+	for _, c := range append(policy.Cases, activity.Cases...) {
+		cases[c.Name] = c
+	}
+	// The failed guest is not held against the rules
+	if c := cases["no active guest is older than the maximum guest age"]; c.Failure == nil || c.Failure.Text != "jane.doe: account older than 365 days" {
+		t.Errorf("expired rule = %+v", c.Failure)
+	}
+	if c := cases["no guest holds an elevated team or channel role"]; c.Failure == nil || c.Failure.Type != "ElevatedRole" || !strings.Contains(c.Failure.Text, "team_admin") {
+		t.Errorf("elevated rule = %+v", c.Failure)
+	}
+	if c := cases["no member should be a guest"]; c.Failure == nil || c.Failure.Text != "member: member with email member@partner.com" {
+		t.Errorf("member rule = %+v", c.Failure)
+	}
+	// Checks this run did not make are skipped, not passed
+	for _, name := range []string{"no guest account is shared", "no active guest has a password older than the maximum password age"} {
+		if cases[name].Skipped == nil {
+			t.Errorf("%q not skipped", name)
+		}
+	}
+
+	if c := cases["bob.contractor"]; c.Failure == nil || c.Failure.Message != "no activity in the last 30 days (never active)" {
+		t.Errorf("bob.contractor = %+v", c.Failure)
+	}
+	if c := cases["amy.old"]; c.Failure == nil || !strings.HasSuffix(c.Failure.Message, "(last active 2024-05-01)") {
+		t.Errorf("amy.old = %+v", c.Failure)
+	}
+	if c := cases["jane.doe"]; c.Failure != nil || c.Skipped != nil || c.Error != nil {
+		t.Errorf("active jane.doe = %+v", c)
+	}
+	if cases["ex.cepted"].Skipped == nil || cases["gone"].Skipped == nil {
+		t.Error("excepted and deactivated guests should be skipped")
+	}
+	if c := cases["broken"]; c.Error == nil || c.Error.Text != LookupChannels+": timeout" {
+		t.Errorf("broken = %+v", c.Error)
+	}
+	if activity.Tests != 6 || activity.Failures != 2 || activity.Errors != 1 || activity.Skipped != 2 {
+		t.Errorf("activity counts = %d tests, %d failures, %d errors, %d skipped", activity.Tests, activity.Failures, activity.Errors, activity.Skipped)
+	}
+	if doc.Tests != policy.Tests+activity.Tests || doc.Failures != 5 {
+		t.Errorf("totals = %d tests, %d failures", doc.Tests, doc.Failures)
+	}
+
+	var buf strings.Builder
+	if err := writeJUnit(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), xml.Header+"<testsuites name=\"mm-guest-audit\"") {
+		t.Errorf("unexpected output:\n%.200s", buf.String())
+	}
+	var got junitTestSuites
+	if err := xml.Unmarshal([]byte(buf.String()), &got); err != nil {
+		t.Fatalf("invalid XML: %v", err)
+	}
+	if got.Tests != doc.Tests || got.Suites[1].Cases[1].Failure == nil {
+		t.Errorf("read back %+v", got)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// keyringService names the tool's entries in the OS credential store. Each
+// entry holds one personal access token, keyed by the server URL.
+const keyringService = "mm-guest-audit"
+
+// keyringNotFound is the exit status the macOS security tool uses for a
+// missing item; the Windows script below uses it too.
+const keyringNotFound = 44
+
+// errNoStoredToken is returned by KeyringGet when no token is stored for
+// the server.
+var errNoStoredToken = errors.New("no token stored")
+
+// keyringRun runs a credential store program, returning its output and
+// exit status; tests replace it.
+var keyringRun = func(program string, args []string, stdin string) (string, int, error) {
+	cmd := exec.Command(program, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return stdout.String(), exitErr.ExitCode(), err
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", -1, fmt.Errorf("%s is not installed", program)
+	}
+	return stdout.String(), 0, err
+}
+
+// keyringStoreName describes the credential store used on goos, for messages.
+func keyringStoreName(goos string) string {
+	switch goos {
+	case "darwin":
+		return "macOS keychain"
+	case "windows":
+		return "Windows Credential Manager"
+	default:
+		return "Secret Service keyring (secret-tool)"
+	}
+}
+
+// keyringCall returns the program, arguments and standard input that carry
+// out op ("get", "set" or "delete") for url in goos's credential store. The
+// token only ever travels on standard input, never in the arguments, where
+// other users could see it in the process list.
+func keyringCall(goos, op, url, token string) (program string, args []string, stdin string) {
+	switch goos {
+	case "darwin":
+		switch op {
+		case "get":
+			return "security", []string{"find-generic-password", "-s", keyringService, "-a", url, "-w"}, ""
+		case "set":
+			// security -i reads its commands from standard input
+			return "security", []string{"-i"}, fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+				securityQuote(keyringService), securityQuote(url), securityQuote(token))
+		default:
+			return "security", []string{"delete-generic-password", "-s", keyringService, "-a", url}, ""
+		}
+	case "windows":
+		vault := "[void][Windows.Security.Credentials.PasswordVault,Windows.Security.Credentials,ContentType=WindowsRuntime]\n" +
+			"$vault = New-Object Windows.Security.Credentials.PasswordVault\n"
+		resource, user := powershellQuote(keyringService), powershellQuote(url)
+		var script string
+		switch op {
+		case "get":
+			script = fmt.Sprintf("try { $c = $vault.Retrieve(%s, %s) } catch { exit %d }\n$c.RetrievePassword()\n$c.Password\n", resource, user, keyringNotFound)
+		case "set":
+			script = fmt.Sprintf("$vault.Add((New-Object Windows.Security.Credentials.PasswordCredential(%s, %s, %s)))\n", resource, user, powershellQuote(token))
+		default:
+			script = fmt.Sprintf("try { $vault.Remove($vault.Retrieve(%s, %s)) } catch { exit %d }\n", resource, user, keyringNotFound)
+		}
+		return "powershell", []string{"-NoProfile", "-NonInteractive", "-Command", "-"}, vault + script
+	default:
+		attrs := []string{"service", keyringService, "server", url}
+		switch op {
+		case "get":
+			return "secret-tool", append([]string{"lookup"}, attrs...), ""
+		case "set":
+			return "secret-tool", append([]string{"store", "--label", "mm-guest-audit token for " + url}, attrs...), token
+		default:
+			return "secret-tool", append([]string{"clear"}, attrs...), ""
+		}
+	}
+}
+
+// keyringMissing reports whether a get or delete failed only because nothing
+// is stored. secret-tool lookup exits 1 with no output for a missing item.
+func keyringMissing(goos string, code int, stdout string) bool {
+	if goos == "darwin" || goos == "windows" {
+		return code == keyringNotFound
+	}
+	return code == 1 && stdout == ""
+}
+
+// securityQuote quotes s for a security -i command line.
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// powershellQuote quotes s as a PowerShell literal string.
+func powershellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// KeyringGet returns the token stored for url by `mm-guest-audit login`,
+// or errNoStoredToken.
+func KeyringGet(url string) (string, error) {
+	program, args, stdin := keyringCall(runtime.GOOS, "get", NormalizeURL(url), "")
+	out, code, err := keyringRun(program, args, stdin)
+	if err != nil {
+		if keyringMissing(runtime.GOOS, code, out) {
+			return "", errNoStoredToken
+		}
+		return "", fmt.Errorf("unable to read the %s: %w", keyringStoreName(runtime.GOOS), err)
+	}
+	token := strings.TrimSpace(out)
+	if token == "" {
+		return "", errNoStoredToken
+	}
+	return token, nil
+}
+
+// KeyringSet stores token for url, replacing any token already stored.
+func KeyringSet(url, token string) error {
+	program, args, stdin := keyringCall(runtime.GOOS, "set", NormalizeURL(url), token)
+	if _, _, err := keyringRun(program, args, stdin); err != nil {
+		return fmt.Errorf("unable to save to the %s: %w", keyringStoreName(runtime.GOOS), err)
+	}
+	return nil
+}
+
+// KeyringDelete removes the token stored for url, or returns
+// errNoStoredToken if there is none.
+func KeyringDelete(url string) error {
+	if _, err := KeyringGet(url); err != nil {
+		return err
+	}
+	program, args, stdin := keyringCall(runtime.GOOS, "delete", NormalizeURL(url), "")
+	out, code, err := keyringRun(program, args, stdin)
+	if err != nil {
+		if keyringMissing(runtime.GOOS, code, out) {
+			return errNoStoredToken
+		}
+		return fmt.Errorf("unable to remove from the %s: %w", keyringStoreName(runtime.GOOS), err)
+	}
+	return nil
+}
+
+// readToken reads a token for login: from a hidden prompt on a terminal,
+// or else the first line of standard input.
+func readToken(stdin *os.File) (string, error) {
+	if term.IsTerminal(int(stdin.Fd())) {
+		fmt.Fprint(os.Stderr, "Personal access token: ")
+		b, err := term.ReadPassword(int(stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("error: failed to read token: %w", err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("error: failed to read token: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// runLogin verifies token against the server and stores it for later runs.
+// Without a token it is read with readToken, so it stays out of the shell
+// history.
+func runLogin(url, token string, opts ClientOptions) int {
+	if token == "" {
+		var err error
+		if token, err = readToken(os.Stdin); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return ExitConfigError
+		}
+		if token == "" {
+			fmt.Fprintln(os.Stderr, "error: no token given. Paste a personal access token at the prompt, or pipe it to standard input.")
+			return ExitConfigError
+		}
+	}
+	client, err := NewClient(url, token, "", opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return ExitConfigError
+	}
+	if err := KeyringSet(url, token); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return ExitConfigError
+	}
+	fmt.Fprintf(os.Stderr, "Token for %s (user %s) saved in the %s. Later runs against this URL use it when --token and MM_TOKEN are not set.\n",
+		NormalizeURL(url), client.ServerInfo().Username, keyringStoreName(runtime.GOOS))
+	return ExitSuccess
+}
+
+// runLogout removes the token stored for url.
+func runLogout(url string) int {
+	err := KeyringDelete(url)
+	switch {
+	case errors.Is(err, errNoStoredToken):
+		fmt.Fprintf(os.Stderr, "No token is stored for %s.\n", NormalizeURL(url))
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return ExitConfigError
+	default:
+		fmt.Fprintf(os.Stderr, "Token for %s removed from the %s.\n", NormalizeURL(url), keyringStoreName(runtime.GOOS))
+	}
+	return ExitSuccess
+}
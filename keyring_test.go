@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestKeyringCall(t *testing.T) {
+	const url, token = "https://mm.example.com", "s3cr3t-token"
+	for _, goos := range []string{"darwin", "linux", "windows"} {
+		for _, op := range []string{"get", "set", "delete"} {
+			program, args, stdin := keyringCall(goos, op, url, token)
+			if program == "" {
+				t.Errorf("%s %s: no program", goos, op)
+			}
+			// The token must never be visible in the process list
+			if strings.Contains(strings.Join(args, " "), token) {
+				t.Errorf("%s %s: token in arguments %q", goos, op, args)
+			}
+			if got := strings.Contains(stdin, token); got != (op == "set") {
+				t.Errorf("%s %s: token on stdin = %v, want %v", goos, op, got, op == "set")
+			}
+			if !strings.Contains(strings.Join(args, " ")+stdin, url) {
+				t.Errorf("%s %s: server URL missing from %q %q", goos, op, args, stdin)
+			}
+		}
+	}
+
+	_, _, stdin := keyringCall("darwin", "set", `https://mm.example.com/"x`, token)
+	if !strings.Contains(stdin, `-a "https://mm.example.com/\"x"`) {
+		t.Errorf("darwin set did not quote the URL: %q", stdin)
+	}
+	_, _, stdin = keyringCall("windows", "set", "https://o'brien.example.com", token)
+	if !strings.Contains(stdin, "'https://o''brien.example.com'") {
+		t.Errorf("windows set did not quote the URL: %q", stdin)
+	}
+}
+
+func TestKeyringGetSet(t *testing.T) {
+	// A fake store that recognises each call by what keyringCall builds
+	stored := map[string]string{}
+	defer func(orig func(string, []string, string) (string, int, error)) { keyringRun = orig }(keyringRun)
+	keyringRun = func(program string, args []string, stdin string) (string, int, error) {
+		for _, url := range []string{"https://mm.example.com", "https://other.example.com"} {
+			for _, op := range []string{"get", "set", "delete"} {
+				p, a, in := keyringCall(runtime.GOOS, op, url, map[string]string{"set": "TOKEN"}[op])
+				if p != program || !slices.Equal(a, args) || in != stdin {
+					continue
+				}
+				token, ok := stored[url]
+				switch {
+				case op == "set":
+					stored[url] = "TOKEN"
+				case !ok && (runtime.GOOS == "darwin" || runtime.GOOS == "windows"):
+					return "", keyringNotFound, errors.New("exit status 44")
+				case !ok:
+					return "", 1, errors.New("exit status 1")
+				case op == "get":
+					return token + "\n", 0, nil
+				default:
+					delete(stored, url)
+				}
+				return "", 0, nil
+			}
+		}
+		t.Fatalf("unexpected call: %s %q %q", program, args, stdin)
+		return "", 0, nil
+	}
+
+	if _, err := KeyringGet("https://mm.example.com"); !errors.Is(err, errNoStoredToken) {
+		t.Fatalf("empty store: err = %v, want errNoStoredToken", err)
+	}
+	if err := KeyringSet("https://mm.example.com/", "TOKEN"); err != nil {
+		t.Fatalf("KeyringSet error: %v", err)
+	}
+	// Trailing slashes are ignored, as for --url
+	token, err := KeyringGet("https://mm.example.com")
+	if err != nil || token != "TOKEN" {
+		t.Errorf("KeyringGet = %q, %v; want the stored token", token, err)
+	}
+	if _, err := KeyringGet("https://other.example.com"); !errors.Is(err, errNoStoredToken) {
+		t.Errorf("other server: err = %v, want errNoStoredToken", err)
+	}
+	if err := KeyringDelete("https://mm.example.com"); err != nil {
+		t.Errorf("KeyringDelete error: %v", err)
+	}
+	if err := KeyringDelete("https://mm.example.com"); !errors.Is(err, errNoStoredToken) {
+		t.Errorf("second delete: err = %v, want errNoStoredToken", err)
+	}
+}
+
+func TestKeyringGet_StoreUnavailable(t *testing.T) {
+	defer func(orig func(string, []string, string) (string, int, error)) { keyringRun = orig }(keyringRun)
+	keyringRun = func(program string, args []string, stdin string) (string, int, error) {
+		return "", -1, errors.New(program + " is not installed")
+	}
+	_, err := KeyringGet("https://mm.example.com")
+	if err == nil || errors.Is(err, errNoStoredToken) || !strings.Contains(err.Error(), "is not installed") {
+		t.Errorf("err = %v, want the store's own error", err)
+	}
+}
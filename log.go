@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// logMu serializes writes to stderr, so lines logged while several guests
+// or servers are being worked on never run into each other.
+var logMu sync.Mutex
+
+// logger is the logger for messages that are not about a single guest.
+var logger Logger
+
+// Logger writes progress and warning messages to stderr, each message as a
+// single write. The zero value writes them as they are; Guest returns a
+// logger that starts every line with the guest's username. os.Stderr is
+// looked up on each write, so --log-file and --anonymize, which replace
+// it, see every message.
+type Logger struct {
+	prefix string
+}
+
+// Guest returns a logger for messages about one guest, prefixed [username].
+func (l Logger) Guest(username string) Logger {
+	return Logger{prefix: l.prefix + "[" + username + "] "}
+}
+
+// Printf formats a message, adds the prefix to each of its lines and a
+// final newline if missing, and writes it in one piece.
+func (l Logger) Printf(format string, args ...any) {
+	msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	if l.prefix != "" {
+		msg = l.prefix + strings.ReplaceAll(msg, "\n", "\n"+l.prefix)
+	}
+	l.Write([]byte(msg + "\n"))
+}
+
+// Write writes p to stderr as is, holding the lock, so Logger can be given
+// to writers such as Progress that format their own output.
+func (l Logger) Write(p []byte) (int, error) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return os.Stderr.Write(p)
+}
+
+// teeStderr copies everything written to os.Stderr into the file at path,
+// appending to it, until the returned function is called. That function
+// restores os.Stderr and closes the file once the last write is copied.
+func teeStderr(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	orig := os.Stderr
+	os.Stderr = w
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.MultiWriter(orig, f), r)
+		close(done)
+	}()
+
+	return func() {
+		os.Stderr = orig
+		w.Close()
+		<-done
+		r.Close()
+		if err := f.Close(); err != nil {
+			fmt.Fprintf(orig, "Warning: unable to write log file %q: %v\n", path, err)
+		}
+	}, nil
+}
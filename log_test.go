@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readStderr runs fn with os.Stderr sent to a pipe and returns what fn
+// wrote to it.
+func readStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	orig := os.Stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stderr = w
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		buf.ReadFrom(r)
+		close(done)
+	}()
+	fn()
+	os.Stderr = orig
+	w.Close()
+	<-done
+	return buf.String()
+}
+
+func TestLogger(t *testing.T) {
+	out := readStderr(t, func() {
+		logger.Printf("Found %d guest user(s)", 2)
+		logger.Guest("ann").Printf("Warning: could not retrieve roles: %v\n", "timeout")
+		logger.Guest("bob").Printf("first\nsecond")
+	})
+	want := "Found 2 guest user(s)\n[ann] Warning: could not retrieve roles: timeout\n[bob] first\n[bob] second\n"
+	if out != want {
+		t.Errorf("logged %q, want %q", out, want)
+	}
+
+	// Retries in an enrichment carry the guest's prefix
+	p := RetryPolicy{MaxRetries: 1, Log: logger.Guest("ann"), sleep: func(time.Duration) {}}
+	out = readStderr(t, func() {
+		p.Do("getting teams", true, func() error { return &APIError{StatusCode: 503, Message: "unavailable"} })
+	})
+	if !strings.HasPrefix(out, "[ann] Warning: getting teams failed (attempt 1 of 2)") {
+		t.Errorf("retry logged %q", out)
+	}
+}
+
+func TestLogger_Concurrent(t *testing.T) {
+	line := strings.Repeat("x", 3000)
+	out := readStderr(t, func() {
+		var wg sync.WaitGroup
+		for _, name := range []string{"ann", "bob", "cat", "dan"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 50 {
+					logger.Guest(name).Printf("%s\n%s", line, line)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 400 {
+		t.Fatalf("%d lines, want 400", len(lines))
+	}
+	// Both lines of a message stay together, each whole
+	for i := 0; i < len(lines); i += 2 {
+		prefix := lines[i][:6]
+		if lines[i] != prefix+line || lines[i+1] != prefix+line {
+			t.Fatalf("lines %d and %d are interleaved: %.20q, %.20q", i, i+1, lines[i], lines[i+1])
+		}
+	}
+}
+
+func TestTeeStderr(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(path, []byte("earlier run\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var before *os.File
+	out := readStderr(t, func() {
+		before = os.Stderr
+		closeLog, err := teeStderr(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logger.Printf("Found %d guest user(s)", 3)
+		closeLog()
+		if os.Stderr != before {
+			t.Error("os.Stderr was not restored")
+		}
+	})
+	if out != "Found 3 guest user(s)\n" {
+		t.Errorf("stderr got %q", out)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "earlier run\nFound 3 guest user(s)\n" {
+		t.Errorf("log file holds %q", b)
+	}
+
+	if _, err := teeStderr(filepath.Join(path, "missing", "audit.log")); err == nil {
+		t.Error("expected an error for an unwritable log file")
+	}
+}
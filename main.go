@@ -1,9 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata" // --timezone works on hosts without a zoneinfo database
+
+	"golang.org/x/term"
 )
 
 var Version = "dev"
@@ -12,78 +22,1422 @@ func main() {
 	os.Exit(run())
 }
 
-func run() int {
+func run() (code int) {
 	// Connection flags
 	url := flag.String("url", envOrDefault("MM_URL", ""), "Mattermost server URL")
 	token := flag.String("token", envOrDefault("MM_TOKEN", ""), "Personal Access Token")
 	username := flag.String("username", envOrDefault("MM_USERNAME", ""), "Username for password auth")
+	local := flag.String("local", "", "Connect through the server's local mode socket at this path, without authenticating, e.g. /var/tmp/mattermost_local.socket")
+	serversPath := flag.String("servers", "", "YAML file of servers (name, url, token or token_env) to audit in one run, with a server column in the report")
+	fromFile := flag.String("from-file", "", "Re-evaluate a previously saved JSON report instead of querying the server")
+	configPath := flag.String("config", "", "YAML config file (e.g. custom guest roles)")
 
 	// Operational flags
 	team := flag.String("team", "", "Scope report to a single named team")
-	channel := flag.String("channel", "", "Scope report to a single named channel (requires --team)")
+	channel := flag.String("channel", "", "Scope report to guests in these channels (comma-separated names or display names)")
+	channelTeam := flag.String("channel-team", "", "Team to look up --channel in, when not scoping to a --team (default: every team)")
+	createdAfter := flag.String("created-after", "", "Only audit guests created on or after this date (YYYY-MM-DD)")
+	createdBefore := flag.String("created-before", "", "Only audit guests created before this date (YYYY-MM-DD)")
 	inactiveDays := flag.Int("inactive-days", 0, "Flag guests with no activity in the last N days")
-	format := flag.String("format", "table", "Output format: table, csv, json")
+	inactivityMetric := flag.String("inactivity-metric", "login", "Activity used for --inactive-days: login, post, any, all, view (any and all also weigh the last view with --last-viewed)")
+	maxGuestAge := flag.Int("max-guest-age", 0, "Flag active guests whose account was created more than N days ago, whatever their activity")
+	maxPasswordAge := flag.Int("max-password-age", 0, "Flag active guests signing in with email whose password was last set more than N days ago")
+	deactivatedDays := flag.Int("deactivated-older-than", 0, "Flag guests deactivated more than N days ago as candidates for permanent deletion")
+	match := flag.String("match", "", "Only audit guests whose username, email or display name matches this regular expression")
+	authMethod := flag.String("auth-method", "", "Only audit guests signing in with these methods (comma-separated): email, ldap, saml, gitlab, google, office365, openid")
+	memberDomains := flag.String("include-members-with-domain", "", "Also audit full members whose email is on these domains (comma-separated), flagged as should be guest")
+	minChannels := flag.Int("min-channels", 0, "Only report guests in at least N channels (public and private)")
+	maxChannels := flag.Int("max-channels", -1, "Only report guests in at most N channels; 0 finds guests with no channels (-1: no limit)")
+	minAgeDays := flag.Int("min-age-days", 0, "Only report guests whose accounts were created at least N days ago")
+	minIdleDays := flag.Int("min-idle-days", 0, "Only report guests with no login or post in the last N days (guests who never logged in or posted always match)")
+	privateOnly := flag.Bool("private-only", false, "Only report guests who are members of at least one private channel")
+	includeArchived := flag.Bool("include-archived", false, "List archived channels in each guest's channels, marked [archived]")
+	includeArchivedTeams := flag.Bool("include-archived-teams", false, "List archived teams in each guest's teams, marked [archived]")
+	neverLoggedIn := flag.Bool("never-logged-in", false, "Only report guests who have never logged in, whatever --inactive-days says")
+	unverifiedOnly := flag.Bool("unverified-only", false, "Only report guests who have not verified their email address")
+	orphansOnly := flag.Bool("orphans-only", false, "Only report guests who belong to no team")
+	mentionCount := flag.Int("mention-count", 0, "Report how many times internal users @-mentioned each guest in the last N days")
+	mentionDays := flag.Int("mention-days", 0, "Don't flag guests as inactive if someone @-mentioned them in the last N days")
+	guestOnly := flag.Bool("guest-only-channels", false, "List each guest's channels that have no members other than guests")
+	postCount := flag.Bool("post-count", false, "Report each guest's number of posts in their team channels")
+	since := flag.String("since", "", "Only count posts created on or after this date (YYYY-MM-DD); requires --post-count")
+	fileActivity := flag.Bool("file-activity", false, "Report each guest's file upload count and last upload date")
+	lastViewed := flag.Bool("last-viewed", false, "Report when each guest last viewed one of their channels (implied by --inactivity-metric view)")
+	pluginAccess := flag.Bool("plugin-access", false, "Report each guest's Boards and Playbooks memberships")
+	profileFieldsFlag := flag.String("profile-fields", "", "Report these custom profile attributes per guest (comma-separated field names, e.g. department,company)")
+	bulkChannels := flag.Bool("bulk-channels", false, "Load channel memberships once per team instead of once per guest (faster on large instances; omits DMs and group messages)")
+	fullEnrichment := flag.Bool("full-enrichment", false, "With --watch, serve or --since-last-run, enrich every guest on each run, even those unchanged since the previous run")
+	sinceLastRun := flag.Bool("since-last-run", false, "Only re-audit guests whose account changed since the previous run, reusing the other records from --state-file")
+	stateFile := flag.String("state-file", "", "State file read at the start and rewritten at the end of each run, reporting the guests new or removed since the last run; also kept by --since-last-run and --notify-guests (default "+DefaultStateFile+")")
+	checkRoles := flag.Bool("check-roles", false, "Flag guests holding team or channel roles beyond the guest role, such as channel admin")
+	groupsFlag := flag.Bool("groups", false, "List each guest's groups and flag their channel memberships that no LDAP group sync grants")
+	sharedSessions := flag.Bool("shared-sessions", false, "Flag guests with concurrent sessions from different networks as possible shared accounts")
+	templatesDir := flag.String("templates", "", "Directory of notification templates (<name>.<locale>.tmpl)")
+	notifyGuests := flag.Bool("notify-guests", false, "Send each flagged inactive guest a direct message from --bot-token warning of deactivation after --grace-days, recording notified_at in the report and state file")
+	notifyTemplate := flag.String("notify-template", "", "Template file for the --notify-guests message")
+	notifyOwners := flag.Bool("notify-owners", false, "Send the admins of each flagged inactive guest's channels one direct message from --bot-token listing their guests, asking them to confirm continued access")
+	botToken := flag.String("bot-token", envOrDefault("MM_BOT_TOKEN", ""), "Bot access token --notify-guests and --notify-owners send their messages as")
+	graceDays := flag.Int("grace-days", DefaultGraceDays, "Days before deactivation announced by --notify-guests, available to the template as .GraceDays and .DeactivateOn")
+	preview := flag.Bool("preview", false, "Write the notifications that would be sent, with rendered bodies, instead of the report")
+	removeFromChannels := flag.Bool("remove-from-channels", false, "Remove flagged inactive guests from their team channels (or the --channel channels), keeping their accounts; writes the removals instead of the report")
+	purge := flag.Bool("purge", false, "Permanently delete the guests flagged by --deactivated-older-than, after typed confirmation; writes the deletions instead of the report")
+	deactivateExpired := flag.Bool("deactivate-expired", false, "Deactivate the guests flagged by --max-guest-age; writes the deactivations instead of the report")
+	quarantine := flag.String("quarantine-team", "", "Move flagged inactive guests into this team, removing them from every other team and its channels; writes the changes instead of the report")
+	dryRun := flag.Bool("dry-run", false, "With --remove-from-channels, --purge, --deactivate-expired, --quarantine-team or undo, list what would change without changing it")
+	yes := flag.Bool("yes", false, "Make the changes of --remove-from-channels, --purge, --deactivate-expired, --quarantine-team or review apply without asking; required when stdin is not a terminal")
+	undoFile := flag.String("undo-file", "", "With --remove-from-channels, --deactivate-expired or --quarantine-team, write the undo plan to this file (default undo-<time>.json)")
+	undoPlan := flag.String("plan", "", "Undo plan for the undo subcommand to replay")
+	trendDir := flag.String("dir", "", "Directory of saved JSON reports for the trend subcommand")
+	sample := flag.Int("sample", 0, "Stop after N guests, for a small report (e.g. to attach to an issue with --anonymize)")
+	anonymize := flag.Bool("anonymize", false, "Replace names, emails, IDs and IP addresses in the report and logs with pseudonyms")
+	redact := flag.String("redact", "", "Mask these fields in the report, keeping usernames and IDs (comma-separated): email, display_name, nickname, ip")
+	sortBy := flag.String("sort", "", "Sort guests by field (prefix with - for descending), e.g. -last_file_upload")
+	groupBy := flag.String("group-by", "", "Group the report's guests and add per-group counts: owner (the --owner-field profile attribute)")
+	ownerField := flag.String("owner-field", "", "Custom profile attribute naming each guest's owner or sponsor, for --group-by owner; also reported as a --profile-fields column")
+	identityHistory := flag.Bool("identity-history", false, "Report previous usernames/emails found in each guest's audit records")
+	auditLog := flag.Bool("audit-log", false, "Report each guest's last audited action (e.g. login, channel join) and its time from the server's audit records")
+	allowlistPath := flag.String("allowlist", "", "YAML file of guests to mark as Excepted instead of flagging")
+	rateLimit := flag.Float64("rate-limit", 0, "Maximum API requests per second (0 = unlimited)")
+	pauseOutside := flag.String("pause-outside", "", "Only call the API inside this daily local-time window, e.g. 08:00-18:00; pause outside it")
+	timeout := flag.Duration("timeout", 0, "Give up on a single API call after this long, e.g. 30s, reporting the guest as failed (0 = no limit)")
+	maxRetries := flag.Int("max-retries", 3, "Retry transient API failures (429, 5xx, connection errors) up to N times")
+	format := flag.String("format", "table", "Output format: table, csv, json, ndjson, sqlite, dot, graphml, mattermost, junit")
 	output := flag.String("output", "", "Write output to this file path")
+	compress := flag.String("compress", "", "Compress the report files, or standard output, adding .gz to their names: gzip")
+	stream := flag.Bool("stream", false, "Write each guest to the csv or ndjson report as soon as it is audited, keeping only the summary in memory")
+	timezone := flag.String("timezone", "", "Show table and CSV dates in this IANA timezone, e.g. Europe/London (default UTC)")
+	dateFormat := flag.String("date-format", "", "Table date layout: rfc3339, date, datetime, us, eu, or a Go layout (CSV dates stay ISO 8601)")
+	outputDir := flag.String("output-dir", "", "Write output files into this directory (CSV adds teams.csv)")
+	splitBy := flag.String("split-by", "", "With --output-dir, write one report per team or per --servers server plus an index: team, server")
+	badge := flag.String("badge", "", "Also write a guests/inactive summary badge to this .svg or .json (shields.io endpoint) file")
+	notifyWebhook := flag.String("notify-webhook", envOrDefault("MM_NOTIFY_WEBHOOK", ""), "Post a short summary to this chat webhook URL when the audit finishes")
+	notifyFormat := flag.String("notify-format", WebhookSlack, "Webhook payload for --notify-webhook: slack (also Mattermost), teams, generic, mattermost (message attachment)")
+	notifyReportURL := flag.String("notify-report-url", "", "Link to the full report to include in the webhook summary")
+	uploadURL := flag.String("upload", "", "Upload the report files, with any checksums and signatures, to S3 after writing them: s3://bucket/prefix/")
+	checksum := flag.Bool("checksum", false, "Write a SHA-256 sum file (<file>.sha256) alongside each report file")
+	signKey := flag.String("sign", "", "Write a detached GPG signature (<file>.asc) of each report file using this key ID")
+	listen := flag.String("listen", ":8080", "Address for the serve subcommand to listen on")
+	serveToken := flag.String("serve-token", envOrDefault("MM_SERVE_TOKEN", ""), "Bearer token required by the serve subcommand's /audit and /metrics")
+	watch := flag.Duration("watch", 0, "Keep running and repeat the audit at this interval (e.g. 24h), writing each run under --output-dir")
 	verbose := flag.Bool("verbose", false, "Enable verbose logging to stderr")
+	logFile := flag.String("log-file", "", "Also append everything written to stderr to this file, e.g. audit.log")
+	showProgress := flag.Bool("progress", false, "Show phase progress (listing, enrichment, output) on stderr")
+	stats := flag.Bool("stats", false, "Print the API calls, data received, cache hits and time per stage on stderr, and add them to JSON output")
+	statusFile := flag.String("status-file", "", "Write the run's exit code, counts, report path and duration to this file as JSON")
+	failOnInactive := flag.Bool("fail-on-inactive", false, "Exit with code 5 if more than --fail-threshold guests are inactive")
+	failOnViolations := flag.Bool("fail-on-violations", false, "Exit with code 6 if more than --fail-threshold guests have elevated roles, possibly shared or expired accounts, or should be guests")
+	failThreshold := flag.Int("fail-threshold", 0, "Number of findings tolerated by --fail-on-inactive and --fail-on-violations")
 	showVersion := flag.Bool("version", false, "Print version and exit")
+	printSchema := flag.Bool("print-schema", false, "Print the JSON Schema of the --format json report and exit")
 
 	// Short flag aliases
 	flag.BoolVar(verbose, "v", false, "Enable verbose logging to stderr")
 
-	flag.Parse()
+	// `mm-guest-audit serve [flags]` runs the HTTP API instead of a single audit
+	args := os.Args[1:]
+	// `completion <shell>` and `docs man` print files generated from the flags above
+	if len(args) > 0 && (args[0] == "completion" || args[0] == "docs") {
+		return runGenerate(os.Stdout, args, flag.CommandLine)
+	}
+	serve := len(args) > 0 && args[0] == "serve"
+	// `mm-guest-audit undo --plan undo.json` adds back removed memberships
+	undo := len(args) > 0 && args[0] == "undo"
+	// `mm-guest-audit login` saves a token in the OS credential store, and
+	// `logout` removes it
+	login := len(args) > 0 && args[0] == "login"
+	logout := len(args) > 0 && args[0] == "logout"
+	// `mm-guest-audit trend --dir snapshots/` summarizes saved reports by month
+	trend := len(args) > 0 && args[0] == "trend"
+	// `mm-guest-audit review export` writes an access review sheet, and
+	// `review apply decisions.csv` acts on the completed one
+	review := len(args) > 0 && args[0] == "review"
+	if serve || undo || login || logout || trend || review {
+		args = args[1:]
+	}
+	var reviewAction, reviewPath string
+	if review {
+		var err error
+		reviewAction, reviewPath, args, err = ParseReviewArgs(args)
+		if err != nil {
+			logger.Printf("%v", err)
+			return ExitConfigError
+		}
+	}
+	flag.CommandLine.Parse(args)
 
 	if *showVersion {
 		fmt.Printf("mm-guest-audit %s\n", Version)
 		return ExitSuccess
 	}
+	if *printSchema {
+		if err := WriteSchema(os.Stdout); err != nil {
+			logger.Printf("error: unable to write schema: %v", err)
+			return ExitOutputError
+		}
+		return ExitSuccess
+	}
+
+	// The log file captures everything from here on, validation errors
+	// included, as it reached stderr
+	if *logFile != "" {
+		closeLog, err := teeStderr(*logFile)
+		if err != nil {
+			logger.Printf("error: unable to open log file %q: %v", *logFile, err)
+			return ExitConfigError
+		}
+		defer closeLog()
+	}
+
+	// The status file records every outcome from here on, including
+	// validation errors
+	status := &RunStatus{Format: *format}
+	if *statusFile != "" {
+		started := time.Now()
+		defer func() {
+			status.Finish(code, started, time.Now())
+			if err := WriteStatusFile(*statusFile, status); err != nil {
+				logger.Printf("Warning: unable to write status file %q: %v", *statusFile, err)
+			}
+		}()
+	}
+
+	// Validate trend, which reads only saved reports
+	if trend {
+		switch {
+		case *trendDir == "":
+			logger.Printf("error: trend requires --dir, a directory of reports saved with --format json.")
+			return ExitConfigError
+		case *fromFile != "" || *outputDir != "":
+			logger.Printf("error: trend cannot be used with --from-file or --output-dir.")
+			return ExitConfigError
+		case *format != "table" && *format != "csv" && *format != "json":
+			logger.Printf("error: trend writes table, csv or json; --format sqlite, dot, graphml, mattermost and junit are not supported.")
+			return ExitConfigError
+		}
+		return runTrend(*trendDir, *format, *output, *verbose)
+	} else if *trendDir != "" {
+		logger.Printf("error: --dir is only used by the trend subcommand.")
+		return ExitConfigError
+	}
+
+	// Local mode replaces the URL and credentials
+	if *local != "" {
+		switch {
+		case *url != "" || *token != "" || *username != "":
+			logger.Printf("error: --local connects without authenticating; do not set --url, --token or --username (or MM_URL, MM_TOKEN, MM_USERNAME).")
+			return ExitConfigError
+		case *fromFile != "" || login || logout:
+			logger.Printf("error: --local cannot be used with --from-file, login or logout.")
+			return ExitConfigError
+		}
+		*url = LocalModeURL(*local)
+	}
+
+	// --servers replaces the URL and credentials with a list of servers
+	var servers []ServerEntry
+	if *serversPath != "" {
+		switch {
+		case *url != "" || *token != "" || *username != "" || *local != "":
+			logger.Printf("error: --servers names each server's URL and token; do not set --url, --token, --username or --local (or MM_URL, MM_TOKEN, MM_USERNAME).")
+			return ExitConfigError
+		case login || logout:
+			logger.Printf("error: --servers cannot be used with login or logout.")
+			return ExitConfigError
+		}
+		var err error
+		servers, err = LoadServers(*serversPath)
+		if err != nil {
+			logger.Printf("error: failed to load servers %q: %v", *serversPath, err)
+			return ExitConfigError
+		}
+	}
 
 	// Validate URL
-	if *url == "" {
-		fmt.Fprintln(os.Stderr, "error: server URL is required. Use --url or set the MM_URL environment variable.")
+	if *url == "" && *fromFile == "" && servers == nil {
+		logger.Printf("error: server URL is required. Use --url or set the MM_URL environment variable.")
 		return ExitConfigError
 	}
 
+	if login || logout {
+		if *fromFile != "" || *username != "" {
+			logger.Printf("error: login and logout store a personal access token for --url; --from-file and --username are not supported.")
+			return ExitConfigError
+		}
+		if *url == "" {
+			logger.Printf("error: server URL is required. Use --url or set the MM_URL environment variable.")
+			return ExitConfigError
+		}
+		if logout {
+			return runLogout(*url)
+		}
+		return runLogin(*url, *token, ClientOptions{Timeout: *timeout, Verbose: *verbose})
+	}
+
 	// Validate format
 	switch *format {
-	case "table", "csv", "json":
+	case "table", "csv", "json", FormatNDJSON, FormatDOT, FormatGraphML, FormatMattermost, FormatJUnit:
 		// valid
+	case "sqlite":
+		if *output == "" {
+			logger.Printf("error: --format sqlite requires --output to name the database file.")
+			return ExitConfigError
+		}
 	default:
-		fmt.Fprintf(os.Stderr, "error: invalid format %q. Use table, csv, or json.\n", *format)
+		logger.Printf("error: invalid format %q. Use table, csv, json, ndjson, sqlite, dot, graphml, mattermost, or junit.", *format)
+		return ExitConfigError
+	}
+	if *outputDir != "" && *output != "" {
+		logger.Printf("error: --output and --output-dir cannot be used together.")
+		return ExitConfigError
+	}
+
+	if _, err := ParseSplitBy(*splitBy); err != nil {
+		logger.Printf("%v", err)
+		return ExitConfigError
+	}
+	if *splitBy != "" && *outputDir == "" {
+		logger.Printf("error: --split-by requires --output-dir for the per-team files.")
+		return ExitConfigError
+	}
+	if *splitBy == SplitByServer && *serversPath == "" && *fromFile == "" {
+		logger.Printf("error: --split-by server requires --servers, or a --from-file report taken with it.")
+		return ExitConfigError
+	}
+	if *splitBy != "" && IsGraphFormat(*format) {
+		logger.Printf("error: --split-by cannot be used with --format dot or graphml. The graph already shows each team's channels in its own colour.")
+		return ExitConfigError
+	}
+	if *splitBy != "" && *format == FormatJUnit {
+		logger.Printf("error: --split-by cannot be used with --format junit. CI servers read the whole audit from one file.")
+		return ExitConfigError
+	}
+
+	seal := SealOptions{Checksum: *checksum, SignKey: *signKey}
+	if seal.Enabled() {
+		switch {
+		case *output == "" && *outputDir == "":
+			logger.Printf("error: --checksum and --sign need a report file. Use --output or --output-dir.")
+			return ExitConfigError
+		case *format == "sqlite" || *preview || serve:
+			logger.Printf("error: --checksum and --sign cannot be used with --format sqlite, --preview or serve.")
+			return ExitConfigError
+		}
+	}
+
+	if *badge != "" {
+		if _, err := BadgeFormat(*badge); err != nil {
+			logger.Printf("%v", err)
+			return ExitConfigError
+		}
+	}
+
+	// Validate --compress
+	compression, err := ParseCompress(*compress)
+	if err != nil {
+		logger.Printf("%v", err)
+		return ExitConfigError
+	}
+	if compression != "" && (*format == "sqlite" || serve) {
+		logger.Printf("error: --compress cannot be used with --format sqlite or serve.")
+		return ExitConfigError
+	}
+	outputCompression = compression
+	*output = compressedName(*output)
+
+	webhookFormat, err := ParseWebhookFormat(*notifyFormat)
+	if err != nil {
+		logger.Printf("%v", err)
+		return ExitConfigError
+	}
+	webhook := Webhook{URL: *notifyWebhook, Format: webhookFormat, ReportURL: *notifyReportURL, Retry: DefaultRetryPolicy(*maxRetries)}
+	if webhook.Enabled() {
+		if err := ValidateWebhookURL("--notify-webhook", webhook.URL); err != nil {
+			logger.Printf("%v", err)
+			return ExitConfigError
+		}
+	}
+	if webhook.ReportURL != "" {
+		if !webhook.Enabled() {
+			logger.Printf("error: --notify-report-url requires --notify-webhook.")
+			return ExitConfigError
+		}
+		if err := ValidateWebhookURL("--notify-report-url", webhook.ReportURL); err != nil {
+			logger.Printf("%v", err)
+			return ExitConfigError
+		}
+	}
+
+	upload, err := ParseUpload(*uploadURL)
+	if err != nil {
+		logger.Printf("%v", err)
+		return ExitConfigError
+	}
+	upload.Retry = DefaultRetryPolicy(*maxRetries)
+	if upload.Enabled() && *output == "" && *outputDir == "" {
+		logger.Printf("error: --upload requires --output or --output-dir.")
+		return ExitConfigError
+	}
+
+	// Validate inactivity metric
+	metric, err := ParseInactivityMetric(*inactivityMetric)
+	if err != nil {
+		logger.Printf("%v", err)
+		return ExitConfigError
+	}
+
+	if *orphansOnly && (*team != "" || *channel != "" || *privateOnly) {
+		logger.Printf("error: --orphans-only cannot be used with --team, --channel or --private-only, which need team membership.")
+		return ExitConfigError
+	}
+
+	authMethods, err := ParseAuthMethods(*authMethod)
+	if err != nil {
+		logger.Printf("%v", err)
+		return ExitConfigError
+	}
+
+	var profileFields []string
+	if *profileFieldsFlag != "" {
+		if profileFields, err = ParseProfileFields(*profileFieldsFlag); err != nil {
+			logger.Printf("%v", err)
+			return ExitConfigError
+		}
+	}
+
+	// Validate --group-by
+	if _, err := ParseGroupBy(*groupBy); err != nil {
+		logger.Printf("%v", err)
 		return ExitConfigError
 	}
+	switch {
+	case *groupBy == GroupByOwner && *ownerField == "":
+		logger.Printf("error: --group-by owner requires --owner-field, the custom profile attribute naming each guest's owner. Mattermost does not record who invited a guest.")
+		return ExitConfigError
+	case *ownerField != "" && *groupBy != GroupByOwner:
+		logger.Printf("error: --owner-field requires --group-by owner.")
+		return ExitConfigError
+	}
+	profileFields = WithOwnerField(profileFields, *ownerField)
 
-	// Validate --channel requires --team
-	if *channel != "" && *team == "" {
-		fmt.Fprintln(os.Stderr, "error: --channel requires --team to be specified.")
+	matchPattern, err := ParseMatch(*match)
+	if err != nil {
+		logger.Printf("%v", err)
 		return ExitConfigError
 	}
 
-	// Authenticate
-	client, err := NewClient(*url, *token, *username, *verbose)
+	includeDomains, err := ParseMemberDomains(*memberDomains)
+	if err != nil {
+		logger.Printf("%v", err)
+		return ExitConfigError
+	}
+
+	if *mentionDays < 0 || *mentionCount < 0 {
+		logger.Printf("error: --mention-days and --mention-count cannot be negative.")
+		return ExitConfigError
+	}
+	if *rateLimit < 0 {
+		logger.Printf("error: --rate-limit cannot be negative.")
+		return ExitConfigError
+	}
+	var window *OperationsWindow
+	if *pauseOutside != "" {
+		window, err = ParseWindow(*pauseOutside)
+		if err != nil {
+			logger.Printf("%v", err)
+			return ExitConfigError
+		}
+	}
+	sortSpec, err := ParseSort(*sortBy)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
+		logger.Printf("%v", err)
+		return ExitConfigError
+	}
+	if *timeout < 0 {
+		logger.Printf("error: --timeout cannot be negative.")
+		return ExitConfigError
+	}
+	if *maxRetries < 0 {
+		logger.Printf("error: --max-retries cannot be negative.")
+		return ExitConfigError
+	}
+
+	// Modes that cannot be combined, and modes that write a single file
+	active := map[string]bool{
+		ModeFromFile:           *fromFile != "",
+		ModePreview:            *preview,
+		ModeRemoveFromChannels: *removeFromChannels,
+		ModePurge:              *purge,
+		ModeDeactivateExpired:  *deactivateExpired,
+		ModeQuarantine:         *quarantine != "",
+		ModeNotifyGuests:       *notifyGuests,
+		ModeNotifyOwners:       *notifyOwners,
+		ModeWatch:              *watch > 0,
+		ModeServe:              serve,
+		ModeUndo:               undo,
+		ModeAnonymize:          *anonymize,
+		ModeRedact:             *redact != "",
+		ModeBadge:              *badge != "",
+		ModeNotifyWebhook:      webhook.Enabled(),
+		ModeMemberDomains:      includeDomains != nil,
+		ModeExitPolicy:         *failOnInactive || *failOnViolations,
+		ModeSinceLastRun:       *sinceLastRun,
+		ModeStateFile:          *stateFile != "",
+		ModeStats:              *stats,
+		ModeServers:            *serversPath != "",
+		ModeReview:             review,
+		ModeStream:             *stream,
+		ModeGroupBy:            *groupBy != "",
+		ModeUpload:             upload.Enabled(),
+	}
+	if err := CheckModeConflicts(active); err != nil {
+		logger.Printf("%v", err)
+		return ExitConfigError
+	}
+	if err := CheckSingleFileOutput(active, *format, *outputDir); err != nil {
+		logger.Printf("%v", err)
+		return ExitConfigError
+	}
+
+	// Validate --stream
+	if *stream {
+		switch {
+		case *format != "csv" && *format != FormatNDJSON:
+			logger.Printf("error: --stream writes guests as csv rows or ndjson lines. Use --format csv or --format ndjson.")
+			return ExitConfigError
+		case *outputDir != "":
+			logger.Printf("error: --stream writes a single report; --output-dir and --split-by are not supported.")
+			return ExitConfigError
+		case *sortBy != "":
+			logger.Printf("error: --stream writes guests in server order; --sort needs every guest before the first is written.")
+			return ExitConfigError
+		}
+	}
+
+	// Validate --preview
+	if *preview && *templatesDir == "" {
+		logger.Printf("error: --preview requires --templates.")
+		return ExitConfigError
+	}
+
+	// Validate --remove-from-channels
+	reviewApply := reviewAction == ReviewApply
+	if *dryRun && !*removeFromChannels && !*purge && !*deactivateExpired && *quarantine == "" && !undo && !reviewApply {
+		logger.Printf("error: --dry-run requires --remove-from-channels, --purge, --deactivate-expired, --quarantine-team, undo or review apply.")
+		return ExitConfigError
+	}
+	if *yes && !*removeFromChannels && !*purge && !*deactivateExpired && *quarantine == "" && !reviewApply {
+		logger.Printf("error: --yes requires --remove-from-channels, --purge, --deactivate-expired, --quarantine-team or review apply.")
+		return ExitConfigError
+	}
+	if *undoFile != "" && (!*removeFromChannels && !*deactivateExpired && *quarantine == "" && !reviewApply || *dryRun) {
+		logger.Printf("error: --undo-file requires --remove-from-channels, --deactivate-expired, --quarantine-team or review apply without --dry-run.")
+		return ExitConfigError
+	}
+	if *removeFromChannels && *inactiveDays <= 0 {
+		logger.Printf("error: --remove-from-channels requires --inactive-days to decide which guests are flagged.")
 		return ExitConfigError
 	}
 
-	if *verbose {
-		fmt.Fprintln(os.Stderr, "Authentication successful.")
+	// Validate --quarantine-team
+	if *quarantine != "" && *inactiveDays <= 0 {
+		logger.Printf("error: --quarantine-team requires --inactive-days to decide which guests are flagged.")
+		return ExitConfigError
+	}
+	if *quarantine != "" && (*team != "" || *channel != "") {
+		logger.Printf("error: --quarantine-team removes guests from every team, so it needs all of them audited; --team and --channel are not supported.")
+		return ExitConfigError
 	}
 
-	// Run audit
-	result, exitCode := RunAudit(client, *team, *channel, *inactiveDays, *verbose)
+	// Validate --notify-guests and --notify-owners
+	if *graceDays < 0 {
+		logger.Printf("error: --grace-days cannot be negative.")
+		return ExitConfigError
+	}
+	if !*notifyGuests && (*notifyTemplate != "" || *graceDays != DefaultGraceDays) {
+		logger.Printf("error: --notify-template and --grace-days require --notify-guests.")
+		return ExitConfigError
+	}
+	if *notifyGuests || *notifyOwners {
+		notifyFlag := "--notify-guests"
+		if !*notifyGuests {
+			notifyFlag = "--notify-owners"
+		}
+		switch {
+		case *inactiveDays <= 0:
+			logger.Printf("error: %s requires --inactive-days to decide which guests are flagged.", notifyFlag)
+			return ExitConfigError
+		case *botToken == "":
+			logger.Printf("error: %s requires a bot token to send as. Use --bot-token or set the MM_BOT_TOKEN environment variable.", notifyFlag)
+			return ExitConfigError
+		case *local != "":
+			logger.Printf("error: %s sends as the bot over --url; it cannot be used with --local.", notifyFlag)
+			return ExitConfigError
+		}
+	}
+	var notifyTemplates *MessageTemplates
+	if *notifyGuests {
+		if *notifyTemplate == "" {
+			logger.Printf("error: --notify-guests requires --notify-template.")
+			return ExitConfigError
+		}
+		var err error
+		if notifyTemplates, err = LoadNotifyTemplate(*notifyTemplate); err != nil {
+			logger.Printf("error: failed to load --notify-template: %v", err)
+			return ExitConfigError
+		}
+	}
+
+	// Validate --deactivated-older-than and --purge
+	if *deactivatedDays < 0 {
+		logger.Printf("error: --deactivated-older-than cannot be negative.")
+		return ExitConfigError
+	}
+	if *purge && *deactivatedDays <= 0 {
+		logger.Printf("error: --purge requires --deactivated-older-than to decide which guests are deleted.")
+		return ExitConfigError
+	}
+
+	// Validate --max-guest-age and --deactivate-expired
+	if *maxGuestAge < 0 {
+		logger.Printf("error: --max-guest-age cannot be negative.")
+		return ExitConfigError
+	}
+	if *maxPasswordAge < 0 {
+		logger.Printf("error: --max-password-age cannot be negative.")
+		return ExitConfigError
+	}
+	if *deactivateExpired && *maxGuestAge <= 0 {
+		logger.Printf("error: --deactivate-expired requires --max-guest-age to decide which guests are deactivated.")
+		return ExitConfigError
+	}
+
+	// Validate the exit policy
+	exitPolicy := ExitPolicy{FailOnInactive: *failOnInactive, FailOnViolations: *failOnViolations, Threshold: *failThreshold}
+	switch {
+	case *failThreshold < 0:
+		logger.Printf("error: --fail-threshold cannot be negative.")
+		return ExitConfigError
+	case *failThreshold > 0 && !exitPolicy.Enabled():
+		logger.Printf("error: --fail-threshold requires --fail-on-inactive or --fail-on-violations.")
+		return ExitConfigError
+	case *failOnInactive && *inactiveDays <= 0 && *fromFile == "":
+		logger.Printf("error: --fail-on-inactive requires --inactive-days to decide which guests are inactive.")
+		return ExitConfigError
+	}
+
+	// Validate --sample and --anonymize
+	if *sample < 0 {
+		logger.Printf("error: --sample cannot be negative.")
+		return ExitConfigError
+	}
+	var redactor *Redactor
+	if *redact != "" {
+		fields, err := ParseRedactFields(*redact)
+		if err != nil {
+			logger.Printf("%v", err)
+			return ExitConfigError
+		}
+		redactor = NewRedactor(fields)
+	}
+
+	// Validate undo
+	var plan *UndoPlan
+	if undo {
+		if *undoPlan == "" {
+			logger.Printf("error: undo requires --plan, the undo plan written by --remove-from-channels or --deactivate-expired.")
+			return ExitConfigError
+		}
+		var err error
+		plan, err = LoadUndoPlan(*undoPlan)
+		if err != nil {
+			logger.Printf("error: failed to load undo plan %q: %v", *undoPlan, err)
+			return ExitConfigError
+		}
+		if err := CheckUndoServer(plan, *url); err != nil {
+			logger.Printf("%v", err)
+			return ExitConfigError
+		}
+	} else if *undoPlan != "" {
+		logger.Printf("error: --plan is only used by the undo subcommand.")
+		return ExitConfigError
+	}
+
+	// Validate review: the sheet is read before connecting, so a mistake in
+	// it changes nothing
+	var decisions []ReviewDecision
+	switch reviewAction {
+	case ReviewExport:
+		if *format != "table" && *format != "csv" {
+			logger.Printf("error: review export always writes a csv sheet; do not set --format.")
+			return ExitConfigError
+		}
+	case ReviewApply:
+		var err error
+		decisions, err = LoadReviewDecisions(reviewPath)
+		if err != nil {
+			logger.Printf("error: failed to load review decisions %q: %v", reviewPath, err)
+			return ExitConfigError
+		}
+	}
+
+	// Validate --watch
+	if *watch < 0 {
+		logger.Printf("error: --watch cannot be negative.")
+		return ExitConfigError
+	}
+	if *watch > 0 {
+		switch {
+		case *outputDir == "":
+			logger.Printf("error: --watch requires --output-dir for the timestamped reports.")
+			return ExitConfigError
+		case *format == "sqlite":
+			logger.Printf("error: --watch cannot be used with --format sqlite.")
+			return ExitConfigError
+		}
+	}
+
+	// A state file is kept when named, and by the modes that need one
+	keepState := *stateFile != "" || *sinceLastRun || *notifyGuests
+	if *stateFile == "" {
+		*stateFile = DefaultStateFile
+	}
+
+	// Validate serve
+	if serve {
+		if *serveToken == "" {
+			logger.Printf("error: serve requires a token for its clients. Use --serve-token or set the MM_SERVE_TOKEN environment variable.")
+			return ExitConfigError
+		}
+		if *fromFile != "" || *preview || *watch > 0 {
+			logger.Printf("error: serve cannot be used with --from-file, --preview or --watch.")
+			return ExitConfigError
+		}
+	}
+
+	// Validate date display options
+	timeFormat, err := ParseTimeFormat(*timezone, *dateFormat)
+	if err != nil {
+		logger.Printf("%v", err)
+		return ExitConfigError
+	}
+
+	// Validate created-date filters
+	after, err := ParseDateFlag("--created-after", *createdAfter)
+	if err != nil {
+		logger.Printf("%v", err)
+		return ExitConfigError
+	}
+	before, err := ParseDateFlag("--created-before", *createdBefore)
+	if err != nil {
+		logger.Printf("%v", err)
+		return ExitConfigError
+	}
+	sinceDate, err := ParseDateFlag("--since", *since)
+	if err != nil {
+		logger.Printf("%v", err)
+		return ExitConfigError
+	}
+	if sinceDate != nil && !*postCount {
+		logger.Printf("error: --since requires --post-count.")
+		return ExitConfigError
+	}
+	if after != nil && before != nil && !after.Before(*before) {
+		logger.Printf("error: --created-after must be earlier than --created-before.")
+		return ExitConfigError
+	}
+
+	// Validate --min-channels and --max-channels
+	var maxChannelCount *int
+	if *maxChannels >= 0 {
+		maxChannelCount = maxChannels
+	}
+	if *minChannels < 0 || *maxChannels < -1 {
+		logger.Printf("error: --min-channels and --max-channels cannot be negative.")
+		return ExitConfigError
+	}
+	if maxChannelCount != nil && *minChannels > *maxChannelCount {
+		logger.Printf("error: --min-channels cannot be more than --max-channels.")
+		return ExitConfigError
+	}
+
+	if *minAgeDays < 0 || *minIdleDays < 0 {
+		logger.Printf("error: --min-age-days and --min-idle-days cannot be negative.")
+		return ExitConfigError
+	}
+
+	// Validate --channel-team
+	if *channelTeam != "" && *channel == "" {
+		logger.Printf("error: --channel-team requires --channel.")
+		return ExitConfigError
+	}
+	if *channelTeam != "" && *team != "" {
+		logger.Printf("error: --channel-team cannot be used with --team; --channel is looked up in the --team team.")
+		return ExitConfigError
+	}
+
+	// Load config
+	var config *Config
+	if *configPath != "" {
+		config, err = LoadConfig(*configPath)
+		if err != nil {
+			logger.Printf("error: failed to load config %q: %v", *configPath, err)
+			return ExitConfigError
+		}
+	}
+
+	// Load allowlist
+	var allowlist *Allowlist
+	if *allowlistPath != "" {
+		allowlist, err = LoadAllowlist(*allowlistPath)
+		if err != nil {
+			logger.Printf("error: failed to load allowlist %q: %v", *allowlistPath, err)
+			return ExitConfigError
+		}
+	}
+
+	// Load notification templates
+	var templates *MessageTemplates
+	if *templatesDir != "" {
+		templates, err = LoadMessageTemplates(*templatesDir)
+		if err != nil {
+			logger.Printf("error: failed to load templates %q: %v", *templatesDir, err)
+			return ExitConfigError
+		}
+	}
+
+	var progress *Progress
+	if *showProgress {
+		progress = NewProgress(logger, term.IsTerminal(int(os.Stderr.Fd())))
+	}
+
+	opts := AuditOptions{
+		TeamFilter:           *team,
+		ChannelFilter:        *channel,
+		ChannelTeam:          *channelTeam,
+		CreatedAfter:         after,
+		CreatedBefore:        before,
+		InactiveDays:         *inactiveDays,
+		DeactivatedDays:      *deactivatedDays,
+		MaxGuestAge:          *maxGuestAge,
+		MaxPasswordAge:       *maxPasswordAge,
+		InactivityMetric:     metric,
+		MentionDays:          *mentionDays,
+		MentionCountDays:     *mentionCount,
+		Allowlist:            allowlist,
+		IdentityHistory:      *identityHistory,
+		AuditLog:             *auditLog,
+		FileActivity:         *fileActivity,
+		LastViewed:           *lastViewed || metric == MetricView,
+		PostCount:            *postCount,
+		GuestOnly:            *guestOnly,
+		Since:                sinceDate,
+		PrivateOnly:          *privateOnly,
+		IncludeArchived:      *includeArchived,
+		IncludeArchivedTeams: *includeArchivedTeams,
+		MinChannels:          *minChannels,
+		MinAgeDays:           *minAgeDays,
+		MinIdleDays:          *minIdleDays,
+		MaxChannels:          maxChannelCount,
+		OrphansOnly:          *orphansOnly,
+		NeverLoggedIn:        *neverLoggedIn,
+		UnverifiedOnly:       *unverifiedOnly,
+		AuthMethods:          authMethods,
+		Match:                matchPattern,
+		MemberDomains:        includeDomains,
+		PluginAccess:         *pluginAccess,
+		ProfileFields:        profileFields,
+		OwnerField:           *ownerField,
+		SharedSessions:       *sharedSessions,
+		CheckRoles:           *checkRoles,
+		Groups:               *groupsFlag,
+		BulkChannels:         *bulkChannels,
+		FullEnrichment:       *fullEnrichment,
+		Sample:               *sample,
+		Sort:                 sortSpec,
+		GuestRoles:           config.ResolveGuestRoles(),
+		AgeBuckets:           config.ResolveAgeBuckets(),
+		ExtraFields:          config.ResolveExtraFields(),
+		Retry:                DefaultRetryPolicy(*maxRetries),
+		Progress:             progress,
+		Verbose:              *verbose,
+		Stats:                *stats,
+	}
+
+	// --stream writes guests as they complete, in place of the report
+	if *stream {
+		opts.Stream = OpenGuestStream(*format, *output, timeFormat, redactor)
+		defer opts.Stream.Close()
+	}
+
+	var result *AuditResult
+	// Hold logs back until the report's names are known, then redact them
+	var anon *Anonymizer
+	if *anonymize {
+		anon = NewAnonymizer()
+		anon.AddServer(*url)
+		anon.AddNames([]string{*team, *channelTeam}, ParseChannelFilter(*channel))
+		flush, err := captureStderr(anon.Redact)
+		if err != nil {
+			logger.Printf("error: unable to capture logs for redaction: %v", err)
+			return ExitConfigError
+		}
+		defer flush()
+	}
+
+	var exitCode int
+	var client MattermostClient
+	var quarantineTeam *TeamInfo
+	if *fromFile != "" {
+		// Offline: re-evaluate a saved report without contacting the server
+		snapshot, err := LoadSnapshot(*fromFile)
+		if err != nil {
+			logger.Printf("error: failed to load snapshot %q: %v", *fromFile, err)
+			return ExitConfigError
+		}
+		result, exitCode = RunOffline(snapshot, opts)
+	} else if servers != nil {
+		// Each server is audited in turn and the reports merged
+		runs := RunServers(servers, opts, func(s ServerEntry) (MattermostClient, error) {
+			token, err := s.ResolveToken()
+			if err != nil {
+				return nil, err
+			}
+			return NewClient(s.URL, token, "", ClientOptions{
+				RateLimit: *rateLimit,
+				Window:    window,
+				Timeout:   *timeout,
+				Verbose:   *verbose,
+			})
+		})
+		result = MergeResults(runs, opts, time.Now())
+		exitCode = ServersExitCode(runs)
+	} else {
+		// Without --token or --username, use a token saved by login
+		if *token == "" && *username == "" && *local == "" {
+			stored, err := KeyringGet(*url)
+			switch {
+			case err == nil:
+				*token = stored
+				if *verbose {
+					logger.Printf("Using the token saved for %s by login", NormalizeURL(*url))
+				}
+			case !errors.Is(err, errNoStoredToken) && *verbose:
+				logger.Printf("Warning: %v", err)
+			}
+		}
+
+		// Authenticate
+		var err error
+		client, err = NewClient(*url, *token, *username, ClientOptions{
+			RateLimit: *rateLimit,
+			Window:    window,
+			Timeout:   *timeout,
+			Verbose:   *verbose,
+			Socket:    *local,
+		})
+		if err != nil {
+			logger.Printf("%v", err)
+			return ExitConfigError
+		}
+
+		if *verbose && *local != "" {
+			logger.Printf("Connected in local mode.")
+		} else if *verbose {
+			logger.Printf("Authentication successful.")
+		}
+
+		if serve {
+			return runServe(client, opts, *listen, *serveToken)
+		}
+		if undo {
+			return runUndo(client, plan, *dryRun, *format, *output, opts.Retry, *verbose)
+		}
+		if *watch > 0 {
+			return runWatch(client, opts, *watch, *format, *outputDir, *splitBy, *badge, timeFormat, seal, upload, webhook)
+		}
+
+		// Resolve the quarantine team first, so a typo fails before the audit
+		if *quarantine != "" {
+			team, err := ResolveTeam(client, *quarantine)
+			if err != nil {
+				logger.Printf("%v", err)
+				return ExitConfigError
+			}
+			quarantineTeam = &TeamInfo{ID: team.Id, DisplayName: team.DisplayName}
+		}
+
+		// Connect the bot first too, so a bad bot token fails before the audit
+		var bot MattermostClient
+		if *notifyGuests || *notifyOwners {
+			if bot, err = NewClient(*url, *botToken, "", ClientOptions{RateLimit: *rateLimit, Window: window, Timeout: *timeout, Verbose: *verbose}); err != nil {
+				logger.Printf("%v", err)
+				return ExitConfigError
+			}
+		}
+
+		// Run audit
+		if *sinceLastRun {
+			opts.Previous = loadPrevious(*stateFile, *url, opts)
+		}
+		result, exitCode = RunAudit(client, opts)
+		if *notifyGuests && result != nil {
+			// Guests warned by an earlier run are not messaged again
+			state, err := LoadAuditState(*stateFile)
+			if err != nil {
+				logger.Printf("Warning: unable to read state file %q: %v — guests notified before may be messaged again", *stateFile, err)
+			}
+			s, code, err := NotifyGuests(bot, result, notifyTemplates, *graceDays, PreviousNotices(state, *url), time.Now(), opts.Retry, *verbose)
+			if err != nil {
+				logger.Printf("error: %v. No messages were sent.", err)
+				return ExitConfigError
+			}
+			if code != ExitSuccess {
+				exitCode = code
+			}
+			logger.Printf("Notified %d guest(s), %d failed, %d already notified.", s.Sent, s.Failed, s.Notified)
+		}
+		if *notifyOwners && result != nil {
+			// Channel admins are looked up with the audit token, which can
+			// read every channel, and messaged from the bot
+			requests, s, code := PlanOwnerRequests(client, result, opts.Retry, *verbose)
+			if sendCode := SendOwnerRequests(bot, requests, *inactiveDays, &s, opts.Retry, *verbose); sendCode != ExitSuccess {
+				code = sendCode
+			}
+			if code != ExitSuccess {
+				exitCode = code
+			}
+			logger.Printf("Asked %d owner(s) about %d guest(s), %d failed; %d guest(s) have no channel admin to ask.", s.Sent, s.Guests, s.Failed, s.Unowned)
+		}
+		if keepState && result != nil {
+			result.Changes = loadChanges(*stateFile, *url, result, opts)
+			// Before --anonymize, so the next run can match records by ID
+			if err := WriteAuditState(NewAuditState(*url, result, opts, time.Now()), *stateFile); err != nil {
+				consequence := "the next run cannot report new or removed guests"
+				switch {
+				case *notifyGuests:
+					consequence = "guests notified now may be messaged again"
+				case *sinceLastRun:
+					consequence = "the next --since-last-run will be a full audit"
+				}
+				logger.Printf("Warning: unable to write state file %q: %v — %s", *stateFile, err, consequence)
+			}
+		}
+	}
 	if result == nil {
 		return exitCode
 	}
+	result.TimeFormat = timeFormat
+	status.SetResult(result)
+	if anon != nil {
+		anon.Result(result)
+	}
+	if redactor != nil {
+		redactor.Result(result)
+	}
+
+	// Preview replaces the report with the messages that would be sent
+	if *preview {
+		plans, err := PlanNotifications(result, templates)
+		if err != nil {
+			logger.Printf("error: %v", err)
+			return ExitConfigError
+		}
+		if err := WritePreview(plans, *format, *output); err != nil {
+			logger.Printf("error: failed to write output: %v", err)
+			return ExitOutputError
+		}
+		logger.Printf("Preview only: %d message(s) rendered, nothing was sent.", len(plans))
+		if *output != "" {
+			status.ReportFiles = []string{*output}
+		}
+		return exitCode
+	}
+
+	// Actions show each batch of changes and ask before making it
+	confirm := Confirmation{In: os.Stdin, Out: logger, Interactive: term.IsTerminal(int(os.Stdin.Fd())), Yes: *yes}
+
+	// Review replaces the report with the sheet, or the decisions carried out
+	if review {
+		if reviewAction == ReviewExport {
+			if err := WriteReviewSheet(result, *output); err != nil {
+				logger.Printf("error: failed to write output: %v", err)
+				return ExitOutputError
+			}
+			logger.Printf("Review sheet written for %d guest(s). Fill in decision (%s) and justification, then run mm-guest-audit review apply on it.", len(result.Guests), joinOr(reviewDecisions))
+		} else if code := runReviewApply(client, PlanReview(result, decisions), *url, *undoFile, *dryRun, confirm, *format, *output, opts.Retry, *verbose); code != ExitSuccess {
+			exitCode = code
+		}
+		if *output != "" {
+			status.ReportFiles = []string{*output}
+		}
+		return exitCode
+	}
+
+	// Action reports and undo plans name who made the change. The account
+	// was resolved by GetMe when the client connected.
+	operator := client.ServerInfo().Operator()
+
+	// Channel removal replaces the report with the memberships removed
+	if *removeFromChannels {
+		removals := PlanChannelRemovals(result)
+		if !*dryRun && SummarizeRemovals(removals).Planned > 0 {
+			if !confirm.Proceed(DescribeRemovals(removals)) {
+				logger.Printf("error: removal not confirmed. Nothing was removed.")
+				return ExitConfigError
+			}
+			// The undo plan is written before anything is removed and
+			// narrowed to what was removed afterwards
+			path := *undoFile
+			if path == "" {
+				path = DefaultUndoFile(time.Now())
+			}
+			if err := WriteUndoPlan(NewUndoPlan(*url, operator, removals, true, time.Now()), path); err != nil {
+				logger.Printf("error: unable to write undo plan %q: %v. Nothing was removed.", path, err)
+				return ExitOutputError
+			}
+			if code := ApplyChannelRemovals(client, removals, opts.Retry, *verbose); code != ExitSuccess {
+				exitCode = code
+			}
+			if err := WriteUndoPlan(NewUndoPlan(*url, operator, removals, false, time.Now()), path); err != nil {
+				logger.Printf("Warning: unable to update undo plan %q: %v — it still lists every planned removal", path, err)
+			}
+			logger.Printf("Undo plan written to %s. To add the memberships back: mm-guest-audit undo --plan %s", path, path)
+		}
+		if err := WriteRemovals(removals, *dryRun, operator, *format, *output); err != nil {
+			logger.Printf("error: failed to write output: %v", err)
+			return ExitOutputError
+		}
+		s := SummarizeRemovals(removals)
+		if *dryRun {
+			logger.Printf("Dry run: %d membership(s) would be removed, nothing was changed.", s.Planned)
+		} else {
+			logger.Printf("Removed %d membership(s), %d failed.", s.Removed, s.Failed)
+		}
+		if *output != "" {
+			status.ReportFiles = []string{*output}
+		}
+		return exitCode
+	}
+
+	// Quarantine replaces the report with the team and channel changes made
+	if quarantineTeam != nil {
+		changes := PlanQuarantine(result, *quarantineTeam)
+		if !*dryRun && SummarizeQuarantine(changes).Planned > 0 {
+			if !confirm.Proceed(DescribeQuarantine(changes, *quarantineTeam)) {
+				logger.Printf("error: quarantine not confirmed. Nothing was changed.")
+				return ExitConfigError
+			}
+			// As for removals, the undo plan is written before anything
+			// changes and narrowed to what changed afterwards
+			path := *undoFile
+			if path == "" {
+				path = DefaultUndoFile(time.Now())
+			}
+			if err := WriteUndoPlan(NewQuarantinePlan(*url, operator, changes, true, time.Now()), path); err != nil {
+				logger.Printf("error: unable to write undo plan %q: %v. Nothing was changed.", path, err)
+				return ExitOutputError
+			}
+			if code := ApplyQuarantine(client, changes, opts.Retry, *verbose); code != ExitSuccess {
+				exitCode = code
+			}
+			if err := WriteUndoPlan(NewQuarantinePlan(*url, operator, changes, false, time.Now()), path); err != nil {
+				logger.Printf("Warning: unable to update undo plan %q: %v — it still lists every planned change", path, err)
+			}
+			logger.Printf("Undo plan written to %s. To release the guests: mm-guest-audit undo --plan %s", path, path)
+		}
+		if err := WriteQuarantine(changes, *dryRun, operator, *format, *output); err != nil {
+			logger.Printf("error: failed to write output: %v", err)
+			return ExitOutputError
+		}
+		s := SummarizeQuarantine(changes)
+		if *dryRun {
+			logger.Printf("Dry run: %d change(s) would be made to quarantine guests in %s, nothing was changed.", s.Planned, quarantineTeam.DisplayName)
+		} else {
+			logger.Printf("Quarantined guests in %s: %d change(s) made, %d failed, %d skipped.", quarantineTeam.DisplayName, s.Done, s.Failed, s.Skipped)
+		}
+		if *output != "" {
+			status.ReportFiles = []string{*output}
+		}
+		return exitCode
+	}
+
+	// Purge replaces the report with the accounts deleted
+	if *purge {
+		purges := PlanPurges(result)
+		if n := SummarizePurges(purges).Planned; !*dryRun && n > 0 {
+			if !confirm.Typed(func(r io.Reader, w io.Writer) bool { return ConfirmPurge(r, w, n, *deactivatedDays) }) {
+				logger.Printf("error: purge not confirmed. Nothing was deleted.")
+				return ExitConfigError
+			}
+			if code := ApplyPurges(client, purges, opts.Retry, *verbose); code != ExitSuccess {
+				exitCode = code
+			}
+		}
+		if err := WritePurges(purges, *dryRun, operator, *format, *output); err != nil {
+			logger.Printf("error: failed to write output: %v", err)
+			return ExitOutputError
+		}
+		s := SummarizePurges(purges)
+		if *dryRun {
+			logger.Printf("Dry run: %d account(s) would be permanently deleted, nothing was changed.", s.Planned)
+		} else {
+			logger.Printf("Permanently deleted %d account(s), %d failed.", s.Deleted, s.Failed)
+		}
+		if *output != "" {
+			status.ReportFiles = []string{*output}
+		}
+		return exitCode
+	}
+
+	// Deactivation replaces the report with the accounts deactivated
+	if *deactivateExpired {
+		deactivations := PlanDeactivations(result)
+		if n := SummarizeDeactivations(deactivations).Planned; !*dryRun && n > 0 {
+			if !confirm.Typed(func(r io.Reader, w io.Writer) bool { return ConfirmDeactivation(r, w, n, *maxGuestAge) }) {
+				logger.Printf("error: deactivation not confirmed. Nothing was deactivated.")
+				return ExitConfigError
+			}
+			// As for removals, the undo plan is written before anything is
+			// deactivated and narrowed to what was deactivated afterwards
+			path := *undoFile
+			if path == "" {
+				path = DefaultUndoFile(time.Now())
+			}
+			if err := WriteUndoPlan(NewReactivationPlan(*url, operator, deactivations, true, time.Now()), path); err != nil {
+				logger.Printf("error: unable to write undo plan %q: %v. Nothing was deactivated.", path, err)
+				return ExitOutputError
+			}
+			if code := ApplyDeactivations(client, deactivations, opts.Retry, *verbose); code != ExitSuccess {
+				exitCode = code
+			}
+			if err := WriteUndoPlan(NewReactivationPlan(*url, operator, deactivations, false, time.Now()), path); err != nil {
+				logger.Printf("Warning: unable to update undo plan %q: %v — it still lists every planned deactivation", path, err)
+			}
+			logger.Printf("Undo plan written to %s. To reactivate the accounts: mm-guest-audit undo --plan %s", path, path)
+		}
+		if err := WriteDeactivations(deactivations, *dryRun, operator, *format, *output); err != nil {
+			logger.Printf("error: failed to write output: %v", err)
+			return ExitOutputError
+		}
+		s := SummarizeDeactivations(deactivations)
+		if *dryRun {
+			logger.Printf("Dry run: %d account(s) would be deactivated, nothing was changed.", s.Planned)
+		} else {
+			logger.Printf("Deactivated %d account(s), %d failed.", s.Deactivated, s.Failed)
+		}
+		if *output != "" {
+			status.ReportFiles = []string{*output}
+		}
+		return exitCode
+	}
 
 	// Write output
-	if err := WriteOutput(result, *format, *output); err != nil {
-		fmt.Fprintf(os.Stderr, "error: failed to write output: %v\n", err)
+	progress.Start("Writing output", len(result.Guests))
+	var writeErr error
+	reportFiles := []string{*output}
+	if opts.Stream != nil {
+		// The guests were written as they completed; only the counts remain
+		opts.Stream.Close()
+		s := result.Summary
+		logger.Printf("Streamed %d guest(s): %d active, %d inactive, %d excepted, %d deactivated, %d failed.", s.TotalGuests, s.ActiveGuests, s.InactiveGuests, s.ExceptedGuests, s.DeactivatedGuests, s.FailedLookups)
+	} else if *splitBy != "" {
+		writeErr = WriteSplitOutputDir(result, *splitBy, *format, *outputDir)
+		reportFiles = SplitOutputDirFiles(result, *splitBy, *format, *outputDir)
+	} else if *outputDir != "" {
+		writeErr = WriteOutputDir(result, *format, *outputDir)
+		reportFiles = OutputDirFiles(*format, *outputDir)
+	} else {
+		writeErr = WriteOutput(result, *format, *output)
+	}
+	if writeErr != nil {
+		logger.Printf("error: failed to write output: %v", writeErr)
 		return ExitOutputError
 	}
+	if seal.Enabled() {
+		if err := seal.Seal(reportFiles); err != nil {
+			logger.Printf("error: failed to seal output: %v", err)
+			return ExitOutputError
+		}
+	}
+	if *badge != "" {
+		if err := WriteBadge(result, *badge); err != nil {
+			logger.Printf("error: failed to write badge: %v", err)
+			return ExitOutputError
+		}
+	}
+	if *output != "" || *outputDir != "" {
+		status.ReportFiles = reportFiles
+	}
+	if upload.Enabled() {
+		if err := upload.Put(upload.Files(reportFiles, seal), *outputDir, *verbose); err != nil {
+			logger.Printf("%v", err)
+			return ExitOutputError
+		}
+	}
+	if webhook.Enabled() {
+		if err := webhook.Post(result, *verbose); err != nil {
+			logger.Printf("%v", err)
+			return ExitOutputError
+		}
+	}
+	progress.Update(len(result.Guests))
+	progress.Finish()
+
+	// A failed lookup already fails the run, and keeps its own exit code
+	if code, msg := exitPolicy.Check(result.Summary); code != ExitSuccess {
+		logger.Printf("Audit failed the exit policy: %s.", msg)
+		if exitCode == ExitSuccess {
+			exitCode = code
+		}
+	}
 
 	return exitCode
 }
 
+// loadPrevious reads the --since-last-run state file for RunAudit to reuse.
+// A missing, unreadable or mismatched state is not an error: every guest is
+// enriched, as in a full audit, and the state is rewritten afterwards.
+func loadPrevious(path, server string, opts AuditOptions) *AuditResult {
+	state, err := LoadAuditState(path)
+	if err != nil {
+		logger.Printf("Warning: unable to read state file %q: %v — running a full audit", path, err)
+		return nil
+	}
+	if state == nil {
+		if opts.Verbose {
+			logger.Printf("No state file %q yet; running a full audit", path)
+		}
+		return nil
+	}
+	prev, reason := state.Previous(server, opts)
+	if prev == nil {
+		logger.Printf("Warning: not reusing state file %q: %s — running a full audit", path, reason)
+	}
+	return prev
+}
+
+// loadChanges compares result with the guests in the state file before it
+// is rewritten, and reports the difference on stderr. A missing state is
+// the first run, and an unreadable or mismatched one only costs this run's
+// comparison, so neither is an error.
+func loadChanges(path, server string, result *AuditResult, opts AuditOptions) *GuestChanges {
+	state, err := LoadAuditState(path)
+	if err != nil {
+		logger.Printf("Warning: unable to read state file %q: %v — new and removed guests are not reported this run", path, err)
+		return nil
+	}
+	if state == nil {
+		logger.Printf("No state file %q yet; new and removed guests are reported from the next run", path)
+		return nil
+	}
+	changes, reason := state.Changes(server, result, opts)
+	if changes == nil {
+		logger.Printf("Warning: not comparing with state file %q: %s — new and removed guests are not reported this run", path, reason)
+		return nil
+	}
+	logger.Printf("Since the last run: %d new guest(s), %d removed.", len(changes.New), len(changes.Removed))
+	if opts.Verbose {
+		for _, name := range changes.New {
+			logger.Printf("  new: %s", name)
+		}
+		for _, r := range changes.Removed {
+			logger.Printf("  removed: %s", r.Username)
+		}
+	}
+	return changes
+}
+
+// runServe serves the audit API on addr until SIGINT or SIGTERM.
+func runServe(client MattermostClient, opts AuditOptions, addr, token string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opts.Progress = nil // progress bars would interleave between requests
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           NewServer(client, opts, token).Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	logger.Printf("Serving on %s (/audit, /healthz, /metrics)", addr)
+
+	select {
+	case err := <-errc:
+		logger.Printf("error: %v", err)
+		return ExitConfigError
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+	logger.Printf("Server stopped.")
+	return ExitSuccess
+}
+
+// runWatch repeats the audit every interval until interrupted, writing each
+// run to its own timestamped directory and logging what changed since the
+// previous run. Failed runs are logged and retried at the next interval. The
+// badge, if any, is rewritten in place after each run.
+func runWatch(client MattermostClient, opts AuditOptions, interval time.Duration, format, dir, splitBy, badge string, timeFormat TimeFormat, seal SealOptions, upload Upload, webhook Webhook) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Printf("Watching: auditing every %s, writing reports under %s", interval, dir)
+
+	var prev *AuditResult
+	Watch(ctx, interval, func(started time.Time) {
+		opts.Previous = prev
+		result, exitCode := RunAudit(client, opts)
+		if result == nil {
+			logger.Printf("Run at %s failed (exit code %d); next run in %s", FormatTimeISO(&started), exitCode, interval)
+			return
+		}
+		result.TimeFormat = timeFormat
+
+		runDir := WatchRunDir(dir, started)
+		write, files := WriteOutputDir, OutputDirFiles(format, runDir)
+		if splitBy != "" {
+			write = func(result *AuditResult, format, dir string) error {
+				return WriteSplitOutputDir(result, splitBy, format, dir)
+			}
+			files = SplitOutputDirFiles(result, splitBy, format, runDir)
+		}
+		if err := write(result, format, runDir); err != nil {
+			logger.Printf("error: failed to write output: %v", err)
+		} else if err := seal.Seal(files); err != nil {
+			logger.Printf("error: failed to seal output: %v", err)
+		} else if upload.Enabled() {
+			// Keyed under the run's directory name, as on disk
+			if err := upload.Put(upload.Files(files, seal), dir, opts.Verbose); err != nil {
+				logger.Printf("%v", err)
+			}
+		}
+		if badge != "" {
+			if err := WriteBadge(result, badge); err != nil {
+				logger.Printf("error: failed to write badge: %v", err)
+			}
+		}
+		if webhook.Enabled() {
+			if err := webhook.Post(result, opts.Verbose); err != nil {
+				logger.Printf("%v", err)
+			}
+		}
+
+		msg := fmt.Sprintf("Run at %s: %d guest(s) written to %s", FormatTimeISO(&started), result.Summary.TotalGuests, runDir)
+		if prev != nil {
+			msg += "; since previous run: " + formatDelta(DiffResults(prev, result), opts.Verbose)
+		}
+		logger.Printf("%s", msg)
+		prev = result
+	})
+
+	logger.Printf("Watch stopped.")
+	return ExitSuccess
+}
+
 func envOrDefault(key, defaultValue string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RunMetadata records the provenance of a report: which server it was taken
+// from, by whom, with which version of the tool, when, and how it was
+// scoped. Auditors need this on submitted evidence.
+type RunMetadata struct {
+	ServerURL     string
+	ServerVersion string
+	RunBy         string // the authenticated Mattermost user
+	ToolVersion   string
+	StartedAt     time.Time
+	FinishedAt    time.Time
+	APICalls      int64
+	Filters       []Filter // in a fixed order; empty when the whole instance was audited
+}
+
+// Filter is one option that narrowed the set of guests in the report.
+type Filter struct {
+	Name  string
+	Value string
+}
+
+// newRunMetadata fills in the metadata of an audit that started at started
+// with calls API requests already made by the client.
+func newRunMetadata(client MattermostClient, opts AuditOptions, started time.Time, calls int64) *RunMetadata {
+	info := client.ServerInfo()
+	return &RunMetadata{
+		ServerURL:     info.URL,
+		ServerVersion: info.Version,
+		RunBy:         info.Username,
+		ToolVersion:   Version,
+		StartedAt:     started,
+		FinishedAt:    time.Now(),
+		APICalls:      info.APICalls - calls,
+		Filters:       AppliedFilters(opts),
+	}
+}
+
+// appliedFilters lists the options that change which users are reported,
+// named after their flags, in the order AppliedFilters and loaded reports
+// list them. A filter with an empty value is not applied.
+var appliedFilters = []struct {
+	name  string
+	value func(opts AuditOptions) string
+}{
+	{"team", func(o AuditOptions) string { return o.TeamFilter }},
+	{"channel", func(o AuditOptions) string { return o.ChannelFilter }},
+	{"channel_team", func(o AuditOptions) string { return o.ChannelTeam }},
+	{"created_after", func(o AuditOptions) string { return formatFilterDate(o.CreatedAfter) }},
+	{"created_before", func(o AuditOptions) string { return formatFilterDate(o.CreatedBefore) }},
+	{"auth_method", func(o AuditOptions) string { return strings.Join(o.AuthMethods, "|") }},
+	{"match", func(o AuditOptions) string {
+		if o.Match == nil {
+			return ""
+		}
+		return o.Match.String()
+	}},
+	{"private_only", func(o AuditOptions) string { return formatFilterBool(o.PrivateOnly) }},
+	{"include_archived", func(o AuditOptions) string { return formatFilterBool(o.IncludeArchived) }},
+	{"include_archived_teams", func(o AuditOptions) string { return formatFilterBool(o.IncludeArchivedTeams) }},
+	{"orphans_only", func(o AuditOptions) string { return formatFilterBool(o.OrphansOnly) }},
+	{"never_logged_in", func(o AuditOptions) string { return formatFilterBool(o.NeverLoggedIn) }},
+	{"unverified_only", func(o AuditOptions) string { return formatFilterBool(o.UnverifiedOnly) }},
+	{"min_channels", func(o AuditOptions) string { return formatFilterInt(o.MinChannels) }},
+	{"max_channels", func(o AuditOptions) string {
+		if o.MaxChannels == nil {
+			return ""
+		}
+		return strconv.Itoa(*o.MaxChannels)
+	}},
+	{"min_age_days", func(o AuditOptions) string { return formatFilterInt(o.MinAgeDays) }},
+	{"min_idle_days", func(o AuditOptions) string { return formatFilterInt(o.MinIdleDays) }},
+	{"include_members_with_domain", func(o AuditOptions) string { return strings.Join(o.MemberDomains, "|") }},
+	{"sample", func(o AuditOptions) string { return formatFilterInt(o.Sample) }},
+}
+
+// AppliedFilters lists the options in opts that change which users are
+// reported, named after their flags.
+func AppliedFilters(opts AuditOptions) []Filter {
+	var filters []Filter
+	for _, f := range appliedFilters {
+		if v := f.value(opts); v != "" {
+			filters = append(filters, Filter{f.name, v})
+		}
+	}
+	return filters
+}
+
+// filterRank is the position of the named filter in AppliedFilters order.
+// Unknown names, from a newer version, rank last.
+func filterRank(name string) int {
+	for i, f := range appliedFilters {
+		if f.name == name {
+			return i
+		}
+	}
+	return len(appliedFilters)
+}
+
+func formatFilterDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+func formatFilterBool(b bool) string {
+	if !b {
+		return ""
+	}
+	return "true"
+}
+
+func formatFilterInt(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// FormatFilters joins filters as "name=value" pairs separated by sep.
+func FormatFilters(filters []Filter, sep string) string {
+	parts := make([]string, len(filters))
+	for i, f := range filters {
+		parts[i] = f.Name + "=" + f.Value
+	}
+	return strings.Join(parts, sep)
+}
+
+// Fields returns the metadata as ordered name/value pairs, with times in
+// ISO 8601, for the table header and metadata.csv.
+func (m *RunMetadata) Fields() [][2]string {
+	return [][2]string{
+		{"server_url", m.ServerURL},
+		{"server_version", m.ServerVersion},
+		{"run_by", m.RunBy},
+		{"tool_version", m.ToolVersion},
+		{"started_at", FormatTimeISO(&m.StartedAt)},
+		{"finished_at", FormatTimeISO(&m.FinishedAt)},
+		{"api_calls", fmt.Sprint(m.APICalls)},
+		{"filters", FormatFilters(m.Filters, "|")},
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func sampleMetadata() *RunMetadata {
+	return &RunMetadata{
+		ServerURL:     "https://chat.example.com",
+		ServerVersion: "9.11.0",
+		RunBy:         "admin",
+		ToolVersion:   "v1.2.0",
+		StartedAt:     time.Date(2024, 11, 20, 9, 0, 0, 0, time.UTC),
+		FinishedAt:    time.Date(2024, 11, 20, 9, 4, 30, 0, time.UTC),
+		APICalls:      1234,
+		Filters:       []Filter{{"team", "Engineering"}, {"private_only", "true"}},
+	}
+}
+
+func TestAppliedFilters(t *testing.T) {
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts := AuditOptions{
+		TeamFilter:   "Engineering",
+		CreatedAfter: &after,
+		AuthMethods:  []string{"saml", "ldap"},
+		Match:        regexp.MustCompile(`^acme-`),
+		OrphansOnly:  true,
+		Sample:       20,
+		InactiveDays: 90, // flags guests, does not filter them
+	}
+	got := FormatFilters(AppliedFilters(opts), ", ")
+	want := "team=Engineering, created_after=2024-01-01, auth_method=saml|ldap, match=^acme-, orphans_only=true, sample=20"
+	if got != want {
+		t.Errorf("filters = %q, want %q", got, want)
+	}
+	if len(AppliedFilters(AuditOptions{})) != 0 {
+		t.Error("expected no filters for a whole-instance audit")
+	}
+}
+
+func TestAppliedFilters_LoadedOrder(t *testing.T) {
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxChannels := 10
+	opts := AuditOptions{
+		TeamFilter: "Engineering", ChannelFilter: "apollo", ChannelTeam: "Sales",
+		CreatedAfter: &after, CreatedBefore: &before,
+		AuthMethods: []string{"saml"}, Match: regexp.MustCompile(`^acme-`),
+		PrivateOnly: true, IncludeArchived: true, IncludeArchivedTeams: true, OrphansOnly: true, NeverLoggedIn: true, UnverifiedOnly: true,
+		MinChannels: 1, MaxChannels: &maxChannels, MinAgeDays: 30, MinIdleDays: 60,
+		MemberDomains: []string{"partner.com"}, Sample: 20,
+	}
+	filters := AppliedFilters(opts)
+	if len(filters) != len(appliedFilters) {
+		t.Fatalf("expected every filter to be applied, got %v", filters)
+	}
+
+	// Every filter keeps its place when the report is loaded again
+	result := sampleResult()
+	result.Metadata = sampleMetadata()
+	result.Metadata.Filters = filters
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseSnapshot(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseSnapshot: %v", err)
+	}
+	if got, want := FormatFilters(parsed.Metadata.Filters, ", "), FormatFilters(filters, ", "); got != want {
+		t.Errorf("loaded filters = %q, want %q", got, want)
+	}
+}
+
+func TestShortServerVersion(t *testing.T) {
+	tests := map[string]string{
+		"9.11.0.10574498245.2b3fd5b1a0b5d3ad.true": "9.11.0",
+		"9.11.0": "9.11.0",
+		"dev":    "dev",
+		"":       "",
+	}
+	for in, want := range tests {
+		if got := shortServerVersion(in); got != want {
+			t.Errorf("shortServerVersion(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRunAudit_Metadata(t *testing.T) {
+	client := &mockClient{
+		guests:     sampleGuests(1),
+		teamByName: map[string]*model.Team{"engineering": {Id: "team1", DisplayName: "Engineering"}},
+		teams:      map[string][]*model.Team{"user0": {{Id: "team1", DisplayName: "Engineering"}}},
+		serverInfo: ServerInfo{URL: "https://chat.example.com", Version: "9.11.0", Username: "admin"},
+	}
+
+	result, _ := RunAudit(client, AuditOptions{TeamFilter: "engineering"})
+	m := result.Metadata
+	if m == nil {
+		t.Fatal("expected run metadata")
+	}
+	if m.ServerURL != "https://chat.example.com" || m.ServerVersion != "9.11.0" || m.RunBy != "admin" || m.ToolVersion != Version {
+		t.Errorf("unexpected metadata: %+v", m)
+	}
+	if m.FinishedAt.Before(m.StartedAt) {
+		t.Errorf("finished %v before started %v", m.FinishedAt, m.StartedAt)
+	}
+	// The team is recorded as resolved, not as typed
+	if got := FormatFilters(m.Filters, ","); got != "team=Engineering" {
+		t.Errorf("filters = %q, want team=Engineering", got)
+	}
+}
+
+func TestMetadata_JSONRoundTrip(t *testing.T) {
+	result := sampleResult()
+	result.Metadata = sampleMetadata()
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"server_version": "9.11.0"`) || !strings.Contains(buf.String(), `"started_at": "2024-11-20T09:00:00Z"`) {
+		t.Errorf("metadata missing from JSON:\n%s", buf.String())
+	}
+
+	parsed, err := ParseSnapshot(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseSnapshot: %v", err)
+	}
+	got, want := parsed.Metadata, sampleMetadata()
+	if got == nil || got.RunBy != want.RunBy || !got.StartedAt.Equal(want.StartedAt) || FormatFilters(got.Filters, ",") != FormatFilters(want.Filters, ",") {
+		t.Errorf("metadata after round trip = %+v, want %+v", got, want)
+	}
+
+	// Offline re-evaluation keeps the collecting run's provenance
+	offline, _ := RunOffline(parsed, AuditOptions{})
+	if offline.Metadata != parsed.Metadata {
+		t.Error("RunOffline dropped the snapshot's metadata")
+	}
+}
+
+func TestMetadata_TableHeader(t *testing.T) {
+	result := sampleResult()
+	result.Metadata = sampleMetadata()
+
+	var buf bytes.Buffer
+	if err := writeTable(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Server:       https://chat.example.com (version 9.11.0)\n",
+		"Run by:       admin\n",
+		"API calls:    1234\n",
+		"Filters:      team=Engineering, private_only=true\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("table missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestMetadata_OutputDirCSV(t *testing.T) {
+	result := sampleResult()
+	result.Metadata = sampleMetadata()
+	dir := t.TempDir()
+
+	if err := WriteOutputDir(result, "csv", dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "metadata.csv"))
+	if err != nil {
+		t.Fatalf("metadata.csv not written: %v", err)
+	}
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid metadata.csv: %v", err)
+	}
+	if len(records) != 9 || records[0][0] != "field" || records[5][1] != "2024-11-20T09:00:00Z" || records[8][1] != "team=Engineering|private_only=true" {
+		t.Errorf("unexpected metadata.csv: %v", records)
+	}
+}
+
+func TestMetadata_SQLite(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	dbPath := filepath.Join(t.TempDir(), "audit.db")
+	result := sampleResult()
+	result.Metadata = sampleMetadata()
+	if err := writeSQLite(dbPath, result); err != nil {
+		t.Fatal(err)
+	}
+	got := sqliteQuery(t, dbPath, "SELECT run_by, server_version, api_calls, filters FROM runs;")
+	if want := "admin|9.11.0|1234|team=Engineering|private_only=true"; got != want {
+		t.Errorf("runs metadata = %q, want %q", got, want)
+	}
+}
+
+func TestAnonymizer_Metadata(t *testing.T) {
+	result := anonymizeResult()
+	result.Metadata = sampleMetadata()
+	a := NewAnonymizer()
+	a.AddServer("https://chat.example.com")
+	a.Result(result)
+
+	m := result.Metadata
+	if m.ServerURL != "https://mattermost.example.com" || m.RunBy != "[redacted]" || m.Filters[0].Value != "Team 01" {
+		t.Errorf("metadata not redacted: %+v", m)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Modes checked by CheckModeConflicts, named as the user types them. The
+// flag names double as the keys of the active set built in main.
+const (
+	ModeFromFile           = "--from-file"
+	ModePreview            = "--preview"
+	ModeRemoveFromChannels = "--remove-from-channels"
+	ModePurge              = "--purge"
+	ModeDeactivateExpired  = "--deactivate-expired"
+	ModeQuarantine         = "--quarantine-team"
+	ModeNotifyGuests       = "--notify-guests"
+	ModeNotifyOwners       = "--notify-owners"
+	ModeWatch              = "--watch"
+	ModeServe              = "serve"
+	ModeUndo               = "undo"
+	ModeAnonymize          = "--anonymize"
+	ModeRedact             = "--redact"
+	ModeBadge              = "--badge"
+	ModeNotifyWebhook      = "--notify-webhook"
+	ModeMemberDomains      = "--include-members-with-domain"
+	ModeExitPolicy         = "--fail-on-inactive and --fail-on-violations"
+	ModeSinceLastRun       = "--since-last-run"
+	ModeStateFile          = "--state-file"
+	ModeStats              = "--stats"
+	ModeServers            = "--servers"
+	ModeReview             = "review"
+	ModeStream             = "--stream"
+	ModeGroupBy            = "--group-by"
+	ModeUpload             = "--upload"
+)
+
+// modeConflict is a mode that cannot be combined with any of excludes.
+// note, if set, follows the error to say why or what to do instead.
+type modeConflict struct {
+	mode     string
+	excludes []string
+	note     string
+}
+
+// modeConflicts lists every pair of modes that cannot run together, checked
+// in order, so the first matching row decides the error. A mode may have
+// several rows when the reasons differ.
+var modeConflicts = []modeConflict{
+	{ModeBadge, []string{ModePreview, ModeRemoveFromChannels, ModePurge, ModeDeactivateExpired, ModeQuarantine, ModeServe}, ""},
+	{ModeNotifyWebhook, []string{ModePreview, ModeRemoveFromChannels, ModePurge, ModeDeactivateExpired, ModeQuarantine, ModeServe, ModeUndo}, ""},
+	{ModeUpload, []string{ModePreview, ModeRemoveFromChannels, ModePurge, ModeDeactivateExpired, ModeQuarantine, ModeServe, ModeUndo, ModeReview}, ""},
+	{ModeMemberDomains, []string{ModeFromFile, ModePreview, ModeRemoveFromChannels, ModePurge, ModeDeactivateExpired, ModeQuarantine}, ""},
+	{ModeRemoveFromChannels, []string{ModeFromFile, ModePreview, ModeWatch, ModeServe}, ""},
+	{ModePurge, []string{ModeRemoveFromChannels}, "Run them separately."},
+	{ModePurge, []string{ModeFromFile, ModePreview, ModeWatch, ModeServe}, ""},
+	{ModeDeactivateExpired, []string{ModeRemoveFromChannels, ModePurge}, "Run them separately."},
+	{ModeDeactivateExpired, []string{ModeFromFile, ModePreview, ModeWatch, ModeServe}, ""},
+	{ModeQuarantine, []string{ModeRemoveFromChannels, ModePurge, ModeDeactivateExpired}, "Run them separately."},
+	{ModeQuarantine, []string{ModeFromFile, ModePreview, ModeWatch, ModeServe}, ""},
+	{ModeNotifyGuests, []string{ModeRemoveFromChannels, ModePurge, ModeDeactivateExpired, ModeQuarantine}, "Run them separately."},
+	{ModeNotifyGuests, []string{ModeFromFile, ModePreview, ModeWatch, ModeServe, ModeUndo, ModeServers, ModeStream, ModeReview}, ""},
+	{ModeNotifyOwners, []string{ModeRemoveFromChannels, ModePurge, ModeDeactivateExpired, ModeQuarantine}, "Run them separately."},
+	{ModeNotifyOwners, []string{ModeFromFile, ModePreview, ModeWatch, ModeServe, ModeUndo, ModeServers, ModeStream, ModeReview}, ""},
+	{ModeExitPolicy, []string{ModePreview, ModeRemoveFromChannels, ModePurge, ModeDeactivateExpired, ModeQuarantine, ModeWatch, ModeServe, ModeUndo}, ""},
+	{ModeAnonymize, []string{ModeRemoveFromChannels, ModePurge, ModeDeactivateExpired, ModeQuarantine, ModePreview, ModeWatch, ModeServe}, ""},
+	{ModeRedact, []string{ModeAnonymize}, "--anonymize already replaces those fields."},
+	{ModeRedact, []string{ModeWatch, ModeServe}, ""},
+	{ModeUndo, []string{ModeFromFile, ModePreview, ModeRemoveFromChannels, ModePurge, ModeDeactivateExpired, ModeQuarantine, ModeWatch, ModeAnonymize}, ""},
+	{ModeWatch, []string{ModeFromFile, ModePreview}, ""},
+	{ModeSinceLastRun, []string{ModeFromFile, ModeWatch, ModeServe, ModeUndo}, "--watch and serve already reuse unchanged guests between runs."},
+	{ModeStateFile, []string{ModeFromFile, ModeWatch, ModeServe, ModeUndo, ModeServers, ModeStream, ModeReview}, ""},
+	{ModeStats, []string{ModeFromFile}, "--from-file makes no API calls."},
+	{ModeServers, []string{ModeRemoveFromChannels, ModePurge, ModeDeactivateExpired, ModeQuarantine, ModeUndo}, "Run actions against one server at a time with --url."},
+	{ModeServers, []string{ModeFromFile, ModePreview, ModeWatch, ModeServe, ModeSinceLastRun, ModeAnonymize}, ""},
+	{ModeReview, []string{ModeFromFile}, "Saved reports have no user IDs to act on."},
+	{ModeStream, []string{ModeAnonymize, ModeSinceLastRun, ModeNotifyWebhook, ModeGroupBy}, "They need every guest record at the end of the run."},
+	{ModeStream, []string{ModeFromFile, ModePreview, ModeRemoveFromChannels, ModePurge, ModeDeactivateExpired, ModeQuarantine, ModeWatch, ModeServe, ModeUndo, ModeServers, ModeReview}, ""},
+	{ModeReview, []string{ModePreview, ModeRemoveFromChannels, ModePurge, ModeDeactivateExpired, ModeQuarantine, ModeWatch, ModeServe, ModeUndo, ModeServers, ModeAnonymize, ModeRedact, ModeBadge, ModeNotifyWebhook, ModeExitPolicy}, ""},
+}
+
+// singleFileModes write their own list instead of the report, to one table,
+// csv or json file.
+var singleFileModes = []string{ModePreview, ModeRemoveFromChannels, ModePurge, ModeDeactivateExpired, ModeQuarantine, ModeUndo, ModeReview}
+
+// CheckModeConflicts returns an error naming the first active mode that is
+// combined with one it excludes, or nil. active holds the modes in use.
+func CheckModeConflicts(active map[string]bool) error {
+	for _, c := range modeConflicts {
+		if !active[c.mode] {
+			continue
+		}
+		for _, ex := range c.excludes {
+			if !active[ex] {
+				continue
+			}
+			msg := fmt.Sprintf("error: %s cannot be used with %s.", c.mode, joinOr(c.excludes))
+			if c.note != "" {
+				msg += " " + c.note
+			}
+			return errors.New(msg)
+		}
+	}
+	return nil
+}
+
+// CheckSingleFileOutput returns an error if a single-file mode is active
+// with a format or --output-dir that writes anything else.
+func CheckSingleFileOutput(active map[string]bool, format, outputDir string) error {
+	if format != "sqlite" && !IsGraphFormat(format) && format != FormatMattermost && format != FormatJUnit && outputDir == "" {
+		return nil
+	}
+	for _, mode := range singleFileModes {
+		if active[mode] {
+			return fmt.Errorf("error: %s writes a single table, csv or json file; --format sqlite, dot, graphml, mattermost or junit and --output-dir are not supported.", mode)
+		}
+	}
+	return nil
+}
+
+// joinOr lists items as "a, b or c".
+func joinOr(items []string) string {
+	if len(items) < 2 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " or " + items[len(items)-1]
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckModeConflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		active  []string
+		wantErr string
+	}{
+		{"nothing", nil, ""},
+		{"report only", []string{ModeBadge, ModeNotifyWebhook, ModeExitPolicy, ModeRedact}, ""},
+		{"action with its own flags", []string{ModeDeactivateExpired, ModeRedact}, ""},
+		{"undo with webhook", []string{ModeUndo, ModeNotifyWebhook}, "error: --notify-webhook cannot be used with --preview, --remove-from-channels, --purge, --deactivate-expired, --quarantine-team, serve or undo."},
+		{"two actions", []string{ModePurge, ModeRemoveFromChannels}, "error: --purge cannot be used with --remove-from-channels. Run them separately."},
+		{"quarantine and deactivate", []string{ModeQuarantine, ModeDeactivateExpired}, "error: --quarantine-team cannot be used with --remove-from-channels, --purge or --deactivate-expired. Run them separately."},
+		{"notify guests offline", []string{ModeNotifyGuests, ModeFromFile}, "error: --notify-guests cannot be used with --from-file"},
+		{"notify owners and quarantine", []string{ModeNotifyOwners, ModeQuarantine}, "error: --notify-owners cannot be used with --remove-from-channels, --purge, --deactivate-expired or --quarantine-team. Run them separately."},
+		{"state file and serve", []string{ModeStateFile, ModeServe}, "error: --state-file cannot be used with --from-file, --watch, serve, undo, --servers, --stream or review."},
+		{"purge offline", []string{ModePurge, ModeFromFile}, "error: --purge cannot be used with --from-file, --preview, --watch or serve."},
+		{"redact and anonymize", []string{ModeRedact, ModeAnonymize}, "--anonymize already replaces those fields."},
+		{"exit policy in serve", []string{ModeExitPolicy, ModeServe}, "error: --fail-on-inactive and --fail-on-violations cannot be used with"},
+		{"stats offline", []string{ModeStats, ModeFromFile}, "error: --stats cannot be used with --from-file. --from-file makes no API calls."},
+		{"since last run in watch", []string{ModeSinceLastRun, ModeWatch}, "error: --since-last-run cannot be used with"},
+		{"review offline", []string{ModeReview, ModeFromFile}, "error: review cannot be used with --from-file. Saved reports have no user IDs to act on."},
+		{"review with badge", []string{ModeReview, ModeBadge}, "error: review cannot be used with"},
+		{"stream since last run", []string{ModeStream, ModeSinceLastRun}, "error: --stream cannot be used with --anonymize, --since-last-run, --notify-webhook or --group-by. They need every guest record at the end of the run."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			active := make(map[string]bool)
+			for _, m := range tt.active {
+				active[m] = true
+			}
+			err := CheckModeConflicts(active)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Every listed pair is rejected, and no mode excludes itself
+func TestModeConflicts_EveryPair(t *testing.T) {
+	for _, c := range modeConflicts {
+		for _, ex := range c.excludes {
+			if c.mode == ex {
+				t.Errorf("%s excludes itself", c.mode)
+			}
+			if err := CheckModeConflicts(map[string]bool{c.mode: true, ex: true}); err == nil {
+				t.Errorf("%s with %s not rejected", c.mode, ex)
+			}
+		}
+	}
+}
+
+func TestCheckSingleFileOutput(t *testing.T) {
+	tests := []struct {
+		mode      string
+		format    string
+		outputDir string
+		wantErr   bool
+	}{
+		{ModeUndo, "json", "", false},
+		{ModePurge, "sqlite", "", true},
+		{ModePreview, "dot", "", true},
+		{ModeReview, FormatMattermost, "", true},
+		{ModeRemoveFromChannels, FormatJUnit, "", true},
+		{ModeDeactivateExpired, "csv", "out", true},
+		{ModeWatch, "csv", "out", false}, // writes a report per run
+		{ModeFromFile, "sqlite", "", false},
+	}
+	for _, tt := range tests {
+		err := CheckSingleFileOutput(map[string]bool{tt.mode: true}, tt.format, tt.outputDir)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s with --format %s --output-dir %q: error = %v, wantErr %v", tt.mode, tt.format, tt.outputDir, err, tt.wantErr)
+		}
+		if err != nil && !strings.HasPrefix(err.Error(), "error: "+tt.mode+" writes a single table") {
+			t.Errorf("unexpected message: %v", err)
+		}
+	}
+}
+
+func TestJoinOr(t *testing.T) {
+	for in, want := range map[string]string{"": "", "a": "a", "a,b": "a or b", "a,b,c": "a, b or c"} {
+		var items []string
+		if in != "" {
+			items = strings.Split(in, ",")
+		}
+		if got := joinOr(items); got != want {
+			t.Errorf("joinOr(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
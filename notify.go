@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// TemplateInactive is the template used to warn inactive guests.
+const TemplateInactive = "inactive"
+
+// DefaultGraceDays is the grace period --notify-guests announces when
+// --grace-days is not set.
+const DefaultGraceDays = 14
+
+// PlannedNotification is a message that a notification run would send.
+type PlannedNotification struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Locale   string `json:"locale"`
+	Template string `json:"template"`
+	Subject  string `json:"subject"`
+	Body     string `json:"body"`
+
+	userID string
+}
+
+// PlanNotifications renders the messages a notification run would send:
+// one "inactive" message to each active, inactive guest who is not excepted.
+func PlanNotifications(result *AuditResult, mt *MessageTemplates) ([]PlannedNotification, error) {
+	return planMessages(result, mt, func(g GuestRecord) any { return g })
+}
+
+// planMessages renders the "inactive" message for each guest a
+// notification run selects, with the template data that data returns.
+func planMessages(result *AuditResult, mt *MessageTemplates, data func(GuestRecord) any) ([]PlannedNotification, error) {
+	var plans []PlannedNotification
+	for _, g := range result.Guests {
+		if !notifiable(g) {
+			continue
+		}
+		msg, locale, err := mt.Render(TemplateInactive, g.Locale, data(g))
+		if err != nil {
+			return nil, fmt.Errorf("rendering message for %q: %w", g.Username, err)
+		}
+		plans = append(plans, PlannedNotification{
+			Username: g.Username,
+			Email:    g.Email,
+			Locale:   locale,
+			Template: TemplateInactive,
+			Subject:  msg.Subject,
+			Body:     msg.Body,
+			userID:   g.UserID,
+		})
+	}
+	return plans, nil
+}
+
+// notifiable reports whether a notification run messages g: an active,
+// inactive guest who is not excepted.
+func notifiable(g GuestRecord) bool {
+	return !g.Failed && g.Active && !g.Excepted && g.Inactive
+}
+
+// WritePreview writes planned notifications in the given format, with the
+// usual stdout fallback when the output file cannot be written.
+func WritePreview(plans []PlannedNotification, format, outputPath string) error {
+	w, closeOutput := openOutput(outputPath)
+	defer closeOutput()
+
+	switch format {
+	case "csv":
+		return writePreviewCSV(w, plans)
+	case "json":
+		return writePreviewJSON(w, plans)
+	default:
+		return writePreviewText(w, plans)
+	}
+}
+
+func writePreviewText(w io.Writer, plans []PlannedNotification) error {
+	for _, p := range plans {
+		fmt.Fprintf(w, "To: %s <%s>\n", p.Username, p.Email)
+		fmt.Fprintf(w, "Template: %s (%s)\n", p.Template, p.Locale)
+		if p.Subject != "" {
+			fmt.Fprintf(w, "Subject: %s\n", p.Subject)
+		}
+		fmt.Fprintf(w, "\n%s\n", p.Body)
+		fmt.Fprintln(w, "----------------------------------------")
+	}
+	_, err := fmt.Fprintf(w, "%d message(s) would be sent\n", len(plans))
+	return err
+}
+
+func writePreviewCSV(w io.Writer, plans []PlannedNotification) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	if err := cw.Write([]string{"username", "email", "locale", "template", "subject", "body"}); err != nil {
+		return err
+	}
+	for _, p := range plans {
+		if err := cw.Write([]string{p.Username, p.Email, p.Locale, p.Template, p.Subject, p.Body}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writePreviewJSON(w io.Writer, plans []PlannedNotification) error {
+	if plans == nil {
+		plans = []PlannedNotification{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Messages []PlannedNotification `json:"messages"`
+	}{plans})
+}
+
+// GuestNotice is what a --notify-template is rendered with: the guest's
+// record, plus the grace period and the date it ends, when the guest may be
+// deactivated.
+type GuestNotice struct {
+	GuestRecord
+	GraceDays    int
+	DeactivateOn *time.Time
+}
+
+// NotifySummary counts the guests --notify-guests considered.
+type NotifySummary struct {
+	Sent     int
+	Failed   int
+	Notified int // already warned by an earlier run, not messaged again
+}
+
+// LoadNotifyTemplate reads the --notify-template file. It is registered as
+// the TemplateInactive template of the default locale, so every guest gets
+// it whatever their locale.
+func LoadNotifyTemplate(path string) (*MessageTemplates, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mt := &MessageTemplates{}
+	if err := mt.Add(TemplateInactive, DefaultLocale, string(data)); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return mt, nil
+}
+
+// PreviousNotices returns when each guest in state was warned by an earlier
+// --notify-guests run on server, keyed by user ID. A state file from
+// another server yields none.
+func PreviousNotices(state *AuditState, server string) map[string]time.Time {
+	notices := make(map[string]time.Time)
+	if state == nil || state.Server != normalizeServerURL(server) {
+		return notices
+	}
+	for _, g := range state.Guests {
+		if g.NotifiedAt != nil {
+			notices[g.UserID] = *g.NotifiedAt
+		}
+	}
+	return notices
+}
+
+// NotifyGuests sends the guests PlanNotifications would message the
+// rendered template as a direct message from client, which should be
+// authenticated as a bot, and sets NotifiedAt on those it reached. A guest
+// in previous keeps that date and is not messaged again, so a guest is
+// warned once for each spell of inactivity: one who becomes active drops
+// out of the state file's notices. Every message is rendered before any is
+// sent, so a template error sends nothing. A failed message is reported and
+// the rest carry on; the exit code is ExitPartialFailure if any failed.
+func NotifyGuests(client MattermostClient, result *AuditResult, mt *MessageTemplates, graceDays int, previous map[string]time.Time, now time.Time, retry RetryPolicy, verbose bool) (NotifySummary, int, error) {
+	var s NotifySummary
+	deactivateOn := now.AddDate(0, 0, graceDays)
+	byID := make(map[string]*GuestRecord)
+	pending := &AuditResult{}
+	for i := range result.Guests {
+		g := &result.Guests[i]
+		g.NotifiedAt = nil
+		if !notifiable(*g) {
+			continue
+		}
+		if at, ok := previous[g.UserID]; ok {
+			g.NotifiedAt = &at
+			s.Notified++
+			continue
+		}
+		byID[g.UserID] = g
+		pending.Guests = append(pending.Guests, *g)
+	}
+	plans, err := planMessages(pending, mt, func(g GuestRecord) any {
+		return GuestNotice{GuestRecord: g, GraceDays: graceDays, DeactivateOn: &deactivateOn}
+	})
+	if err != nil {
+		return s, ExitConfigError, err
+	}
+
+	exitCode := ExitSuccess
+	for _, p := range plans {
+		op := fmt.Sprintf("messaging %q", p.Username)
+		text := directMessageText(Message{Subject: p.Subject, Body: p.Body})
+		if err := retry.Do(op, verbose, func() error { return client.SendDirectMessage(p.userID, text) }); err != nil {
+			logger.Printf("Warning: %s failed: %v", op, err)
+			s.Failed++
+			exitCode = ExitPartialFailure
+			continue
+		}
+		sentAt := now
+		byID[p.userID].NotifiedAt = &sentAt
+		s.Sent++
+	}
+	return s, exitCode, nil
+}
+
+// directMessageText renders a message for a direct message, which has no
+// subject line: a subject becomes a bold first line.
+func directMessageText(msg Message) string {
+	if msg.Subject == "" {
+		return msg.Body
+	}
+	return "**" + msg.Subject + "**\n\n" + msg.Body
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func previewTemplates(t *testing.T) *MessageTemplates {
+	t.Helper()
+	mt := &MessageTemplates{}
+	if err := mt.Add(TemplateInactive, "en", "Subject: Guest access review\n\nHi {{.DisplayName}}, last login: {{date .LastLogin}}."); err != nil {
+		t.Fatal(err)
+	}
+	if err := mt.Add(TemplateInactive, "fr", "Bonjour {{.DisplayName}}."); err != nil {
+		t.Fatal(err)
+	}
+	return mt
+}
+
+func TestPlanNotifications(t *testing.T) {
+	result := &AuditResult{Guests: []GuestRecord{
+		{Username: "inactive.en", DisplayName: "Ann", Email: "ann@partner.com", Active: true, Inactive: true},
+		{Username: "inactive.fr", DisplayName: "Luc", Email: "luc@partner.fr", Locale: "fr", Active: true, Inactive: true},
+		{Username: "active", Active: true},
+		{Username: "excepted", Active: true, Inactive: true, Excepted: true},
+		{Username: "deactivated", Inactive: true},
+		{Username: "failed", Active: true, Inactive: true, Failed: true},
+	}}
+
+	plans, err := PlanNotifications(result, previewTemplates(t))
+	if err != nil {
+		t.Fatalf("PlanNotifications error: %v", err)
+	}
+	if len(plans) != 2 {
+		t.Fatalf("expected 2 messages, got %d: %+v", len(plans), plans)
+	}
+
+	if p := plans[0]; p.Username != "inactive.en" || p.Locale != "en" || p.Subject != "Guest access review" || p.Body != "Hi Ann, last login: Never." {
+		t.Errorf("unexpected English message: %+v", p)
+	}
+	if p := plans[1]; p.Email != "luc@partner.fr" || p.Locale != "fr" || p.Body != "Bonjour Luc." {
+		t.Errorf("unexpected French message: %+v", p)
+	}
+}
+
+func TestPlanNotifications_MissingTemplate(t *testing.T) {
+	mt := &MessageTemplates{}
+	if err := mt.Add("other", "en", "x"); err != nil {
+		t.Fatal(err)
+	}
+	result := &AuditResult{Guests: []GuestRecord{{Username: "g", Active: true, Inactive: true}}}
+
+	if _, err := PlanNotifications(result, mt); err == nil {
+		t.Error("expected error when the inactive template is missing")
+	}
+}
+
+func TestWritePreview(t *testing.T) {
+	plans := []PlannedNotification{{
+		Username: "ann", Email: "ann@partner.com", Locale: "en", Template: TemplateInactive,
+		Subject: "Guest access review", Body: "Hi Ann,\nplease log in.",
+	}}
+
+	var text bytes.Buffer
+	if err := writePreviewText(&text, plans); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"To: ann <ann@partner.com>", "Subject: Guest access review", "please log in.", "1 message(s) would be sent"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text preview missing %q:\n%s", want, text.String())
+		}
+	}
+
+	var csvBuf bytes.Buffer
+	if err := writePreviewCSV(&csvBuf, plans); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&csvBuf).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(records) != 2 || records[1][5] != "Hi Ann,\nplease log in." {
+		t.Errorf("unexpected CSV preview: %q", records)
+	}
+
+	var jsonBuf bytes.Buffer
+	if err := writePreviewJSON(&jsonBuf, nil); err != nil {
+		t.Fatal(err)
+	}
+	var parsed map[string][]PlannedNotification
+	if err := json.Unmarshal(jsonBuf.Bytes(), &parsed); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if msgs, ok := parsed["messages"]; !ok || msgs == nil {
+		t.Errorf("expected an empty messages array, got %s", jsonBuf.String())
+	}
+}
+
+func TestNotifyGuests(t *testing.T) {
+	earlier := time.Date(2025, 5, 1, 9, 0, 0, 0, time.UTC)
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	result := &AuditResult{Guests: []GuestRecord{
+		{UserID: "user0", Username: "ann", Active: true, Inactive: true},
+		{UserID: "user1", Username: "bob", Active: true, Inactive: true},
+		{UserID: "user2", Username: "carol", Active: true, Inactive: true},
+		{UserID: "user3", Username: "active", Active: true, NotifiedAt: &earlier},
+		{UserID: "user4", Username: "excepted", Active: true, Inactive: true, Excepted: true},
+	}}
+	mt := &MessageTemplates{}
+	if err := mt.Add(TemplateInactive, DefaultLocale, "Subject: Access review\n\nHi {{.Username}}, you have {{.GraceDays}} days, until {{date .DeactivateOn}}."); err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{messageErr: map[string]error{"user2": &APIError{StatusCode: 403, Message: "forbidden"}}}
+	previous := map[string]time.Time{"user1": earlier, "user3": earlier}
+
+	s, exitCode, err := NotifyGuests(client, result, mt, 14, previous, now, RetryPolicy{}, false)
+	if err != nil || exitCode != ExitPartialFailure {
+		t.Fatalf("exit code = %d, err = %v", exitCode, err)
+	}
+	if s != (NotifySummary{Sent: 1, Failed: 1, Notified: 1}) {
+		t.Errorf("summary = %+v", s)
+	}
+	want := "user0:**Access review**\n\nHi ann, you have 14 days, until 2025-06-15 09:00."
+	if len(client.messages) != 1 || client.messages[0] != want {
+		t.Errorf("messages = %q, want [%q]", client.messages, want)
+	}
+	for i, w := range []*time.Time{&now, &earlier, nil, nil, nil} {
+		if got := result.Guests[i].NotifiedAt; (got == nil) != (w == nil) || got != nil && !got.Equal(*w) {
+			t.Errorf("%s: notified_at = %v, want %v", result.Guests[i].Username, got, w)
+		}
+	}
+
+	// A template error sends nothing
+	client = &mockClient{}
+	bad := &MessageTemplates{}
+	bad.Add(TemplateInactive, DefaultLocale, "{{.Missing}}")
+	if _, _, err := NotifyGuests(client, result, bad, 14, nil, now, RetryPolicy{}, false); err == nil || len(client.messages) != 0 {
+		t.Errorf("template error: err = %v, messages %v", err, client.messages)
+	}
+}
+
+func TestPreviousNotices(t *testing.T) {
+	at := time.Date(2025, 5, 1, 9, 0, 0, 0, time.UTC)
+	result := &AuditResult{Guests: []GuestRecord{{UserID: "user0", NotifiedAt: &at}, {UserID: "user1"}}}
+	state := NewAuditState("https://chat.example.com/", result, AuditOptions{}, at)
+	if got := PreviousNotices(state, "https://chat.example.com"); len(got) != 1 || !got["user0"].Equal(at) {
+		t.Errorf("notices = %v", got)
+	}
+	if got := PreviousNotices(state, "https://other.example.com"); len(got) != 0 {
+		t.Errorf("another server's notices = %v", got)
+	}
+	if got := PreviousNotices(nil, "https://chat.example.com"); len(got) != 0 {
+		t.Errorf("no state: %v", got)
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -13,49 +16,217 @@ import (
 
 // WriteOutput writes the audit result in the specified format to the specified destination.
 func WriteOutput(result *AuditResult, format, outputPath string) error {
-	var w io.Writer = os.Stdout
-
-	if outputPath != "" {
-		f, err := os.Create(outputPath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: unable to write to %q: %v — writing to stdout instead\n", outputPath, err)
-		} else {
-			defer f.Close()
-			w = f
-		}
+	// SQLite appends to a database rather than writing a stream
+	if format == "sqlite" {
+		return writeSQLite(outputPath, result)
 	}
 
+	w, closeOutput := openOutput(outputPath)
+	defer closeOutput()
+
 	switch format {
 	case "csv":
 		return writeCSV(w, result)
 	case "json":
 		return writeJSON(w, result)
+	case FormatNDJSON:
+		return writeNDJSON(w, result)
+	case FormatDOT:
+		return writeDOT(w, result)
+	case FormatGraphML:
+		return writeGraphML(w, result)
+	case FormatMattermost:
+		return writeMattermost(w, result)
+	case FormatJUnit:
+		return writeJUnit(w, result)
 	default:
 		return writeTable(w, result)
 	}
 }
 
+// WriteOutputDir writes the report into dir as guests.<ext>. CSV reports also
+// get teams.csv with the per-team summary and metadata.csv with the run
+// metadata, since CSV has no room for them.
+func WriteOutputDir(result *AuditResult, format, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		logger.Printf("Warning: unable to create %q: %v — writing to stdout instead", dir, err)
+		return WriteOutput(result, format, "")
+	}
+
+	files := OutputDirFiles(format, dir)
+	if err := WriteOutput(result, format, files[0]); err != nil {
+		return err
+	}
+
+	if format == "csv" {
+		if err := writeFileWith(files[1], result, writeTeamSummaryCSV); err != nil {
+			return err
+		}
+		return writeFileWith(files[2], result, writeMetadataCSV)
+	}
+	return nil
+}
+
+// writeFileWith writes result to path with write, falling back to stdout.
+func writeFileWith(path string, result *AuditResult, write func(io.Writer, *AuditResult) error) error {
+	w, closeOutput := openOutput(path)
+	defer closeOutput()
+	return write(w, result)
+}
+
+// OutputDirFiles lists the files WriteOutputDir writes for format, with the
+// guest report first.
+func OutputDirFiles(format, dir string) []string {
+	files := []string{filepath.Join(dir, "guests."+outputExt(format))}
+	if format == "csv" {
+		files = append(files, filepath.Join(dir, compressedName("teams.csv")), filepath.Join(dir, compressedName("metadata.csv")))
+	}
+	return files
+}
+
+// outputExt is the file extension for reports in format, ending in .gz
+// with --compress gzip.
+func outputExt(format string) string {
+	switch format {
+	case "csv", "json", FormatNDJSON, FormatDOT, FormatGraphML:
+		return compressedName(format)
+	case FormatMattermost:
+		return compressedName("json")
+	case FormatJUnit:
+		return compressedName("xml")
+	}
+	return compressedName("txt")
+}
+
+// openOutput creates the file at path, falling back to stdout (with a
+// warning) when path is empty or cannot be written. With --compress the
+// output is compressed either way.
+func openOutput(path string) (io.Writer, func()) {
+	var out io.Writer = os.Stdout
+	closeFile := func() {}
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			logger.Printf("Warning: unable to write to %q: %v — writing to stdout instead", path, err)
+		} else {
+			out, closeFile = f, func() { f.Close() }
+		}
+	}
+	w, finish := compressWriter(out)
+	return w, func() {
+		if err := finish(); err != nil {
+			logger.Printf("Warning: unable to finish compressed output %q: %v", path, err)
+		}
+		closeFile()
+	}
+}
+
+// writeMetadataCSV writes the run metadata as field,value rows. Without
+// metadata (a report from an older version) only the header is written.
+func writeMetadataCSV(w io.Writer, result *AuditResult) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	if err := cw.Write([]string{"field", "value"}); err != nil {
+		return err
+	}
+	if result.Metadata == nil {
+		return nil
+	}
+	for _, f := range result.Metadata.Fields() {
+		if err := cw.Write(f[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMetadataHeader writes the run metadata above the table.
+func writeMetadataHeader(w io.Writer, result *AuditResult) {
+	m := result.Metadata
+	server := m.ServerURL
+	if m.ServerVersion != "" {
+		server += " (version " + m.ServerVersion + ")"
+	}
+	filters := FormatFilters(m.Filters, ", ")
+	if filters == "" {
+		filters = "none"
+	}
+	fmt.Fprintf(w, "Server:       %s\n", server)
+	fmt.Fprintf(w, "Run by:       %s\n", m.RunBy)
+	fmt.Fprintf(w, "Tool version: %s\n", m.ToolVersion)
+	fmt.Fprintf(w, "Started:      %s\n", result.TimeFormat.Display(&m.StartedAt))
+	fmt.Fprintf(w, "Finished:     %s\n", result.TimeFormat.Display(&m.FinishedAt))
+	fmt.Fprintf(w, "API calls:    %d\n", m.APICalls)
+	fmt.Fprintf(w, "Filters:      %s\n\n", filters)
+}
+
 func writeTable(w io.Writer, result *AuditResult) error {
+	if result.Metadata != nil {
+		writeMetadataHeader(w, result)
+	}
 	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
 
-	// Header
-	fmt.Fprintln(tw, "USERNAME\tDISPLAY NAME\tEMAIL\tTEAMS\tCHANNELS\tLAST LOGIN\tLAST POST\tSTATUS")
+	// Header, with the server in a --servers report, the last channel view
+	// when it was looked up and the account age when it is checked
+	showServer := hasServers(result)
+	showViewed := slices.ContainsFunc(result.Guests, func(g GuestRecord) bool { return g.LastViewed != nil || g.ViewUnknown })
+	showAudited := slices.ContainsFunc(result.Guests, func(g GuestRecord) bool { return g.LastAuditedAt != nil })
+	header := "USERNAME\tDISPLAY NAME\tEMAIL\tTEAMS\tCHANNELS\tLAST LOGIN\tLAST POST\tSTATUS"
+	if showServer {
+		header = "SERVER\t" + header
+	}
+	if showViewed {
+		header += "\tLAST VIEWED"
+	}
+	if showAudited {
+		header += "\tLAST AUDITED"
+	}
+	if result.MaxGuestAge > 0 {
+		header += "\tAGE (DAYS)"
+	}
+	if result.MaxPasswordAge > 0 {
+		header += "\tPASSWORD AGE (DAYS)"
+	}
+	for _, name := range result.ProfileFields {
+		header += "\t" + strings.ToUpper(name)
+	}
+	fmt.Fprintln(tw, header)
 
 	for _, g := range result.Guests {
 		teams := formatTeamNames(g.Teams)
 		channels := formatChannelNamesTable(g.Channels)
 		status := guestStatus(g)
 
-		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+		if showServer {
+			fmt.Fprintf(tw, "%s\t", g.Server)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s",
 			g.Username,
 			g.DisplayName,
 			g.Email,
 			teams,
 			channels,
-			FormatTimeDisplay(g.LastLogin),
-			FormatTimeDisplay(g.LastPost),
+			result.TimeFormat.Display(g.LastLogin),
+			result.TimeFormat.Display(g.LastPost),
 			status,
 		)
+		if showViewed {
+			fmt.Fprintf(tw, "\t%s", formatLastViewed(g, result.TimeFormat))
+		}
+		if showAudited {
+			fmt.Fprintf(tw, "\t%s", formatLastAudited(g, result.TimeFormat))
+		}
+		if result.MaxGuestAge > 0 {
+			fmt.Fprintf(tw, "\t%s", formatAgeDays(g))
+		}
+		if result.MaxPasswordAge > 0 {
+			fmt.Fprintf(tw, "\t%s", formatPasswordAgeDays(g))
+		}
+		for _, name := range result.ProfileFields {
+			fmt.Fprintf(tw, "\t%s", g.ProfileFields[name])
+		}
+		fmt.Fprintln(tw)
 	}
 
 	if err := tw.Flush(); err != nil {
@@ -75,6 +246,9 @@ func writeTable(w io.Writer, result *AuditResult) error {
 	if result.Summary.DeactivatedGuests > 0 {
 		parts = append(parts, fmt.Sprintf("%d deactivated", result.Summary.DeactivatedGuests))
 	}
+	if result.Summary.ExceptedGuests > 0 {
+		parts = append(parts, fmt.Sprintf("%d excepted", result.Summary.ExceptedGuests))
+	}
 	if result.Summary.FailedLookups > 0 {
 		parts = append(parts, fmt.Sprintf("%d failed", result.Summary.FailedLookups))
 	}
@@ -82,98 +256,682 @@ func writeTable(w io.Writer, result *AuditResult) error {
 		fmt.Fprintf(w, " — %s", strings.Join(parts, ", "))
 	}
 	fmt.Fprintln(w)
+	if result.Summary.TotalGuests > 0 {
+		fmt.Fprintln(w, formatLicenseSeats(result.Summary.License))
+	}
+	if result.Summary.RetentionGuests > 0 {
+		fmt.Fprintf(w, "%d guest(s) in channels under a data retention policy\n", result.Summary.RetentionGuests)
+	}
+	if result.Summary.GuestOnlyChannels > 0 {
+		fmt.Fprintf(w, "%d channel(s) with only guests as members\n", result.Summary.GuestOnlyChannels)
+	}
+	if result.Summary.PurgeCandidates > 0 {
+		fmt.Fprintf(w, "%d deactivated guest(s) eligible for permanent deletion\n", result.Summary.PurgeCandidates)
+	}
+	if result.Summary.ExpiredGuests > 0 {
+		fmt.Fprintf(w, "%d guest account(s) older than the maximum guest age of %d day(s)\n", result.Summary.ExpiredGuests, result.MaxGuestAge)
+	}
+	if result.Summary.ExpiredPasswords > 0 {
+		fmt.Fprintf(w, "%d guest(s) with a password older than the maximum password age of %d day(s)\n", result.Summary.ExpiredPasswords, result.MaxPasswordAge)
+	}
+	if result.Summary.OrphanedGuests > 0 {
+		fmt.Fprintf(w, "%d guest(s) with no team membership (listed below)\n", result.Summary.OrphanedGuests)
+	}
+	if result.Summary.NeverLoggedInGuests > 0 {
+		fmt.Fprintf(w, "%d guest(s) have never logged in\n", result.Summary.NeverLoggedInGuests)
+	}
+	if result.Summary.UnverifiedGuests > 0 {
+		fmt.Fprintf(w, "%d guest(s) have not verified their email\n", result.Summary.UnverifiedGuests)
+	}
+	if result.Summary.MembersShouldBeGuests > 0 {
+		fmt.Fprintf(w, "%d member(s) with an external email domain that should be guests (not counted above)\n", result.Summary.MembersShouldBeGuests)
+	}
+	if result.Summary.SharedAccountGuests > 0 {
+		fmt.Fprintf(w, "%d guest(s) with concurrent sessions from different networks (possible shared account)\n", result.Summary.SharedAccountGuests)
+	}
+	if result.Summary.BotGuests > 0 {
+		fmt.Fprintln(w, formatBotGuests(result))
+	}
+	if result.Summary.ElevatedRoleGuests > 0 {
+		fmt.Fprintf(w, "%d guest(s) holding team or channel roles beyond guest (listed below)\n", result.Summary.ElevatedRoleGuests)
+	}
+	if result.Summary.DirectMemberGuests > 0 {
+		fmt.Fprintf(w, "%d guest(s) in channels no group sync grants\n", result.Summary.DirectMemberGuests)
+	}
+	if result.Summary.IncompleteGuests > 0 {
+		fmt.Fprintf(w, "%d guest(s) reported with some lookups failed (see errors in the CSV or JSON report)\n", result.Summary.IncompleteGuests)
+	}
+	if c := result.Changes; c != nil {
+		fmt.Fprintf(w, "Since the last run (%s): %d new guest(s), %d removed (net %+d)\n", result.TimeFormat.Display(&c.Since), len(c.New), len(c.Removed), len(c.New)-len(c.Removed))
+	}
+	if len(result.Summary.FailuresByStage) > 0 {
+		fmt.Fprintf(w, "Failed lookups by stage: %s\n", formatStageCounts(result.Summary.FailuresByStage))
+	}
+	if len(result.Summary.AgeBuckets) > 0 {
+		buckets := make([]string, len(result.Summary.AgeBuckets))
+		for i, b := range result.Summary.AgeBuckets {
+			buckets[i] = fmt.Sprintf("%s: %d", b.Label, b.Guests)
+		}
+		fmt.Fprintf(w, "Last activity (active guests): %s\n", strings.Join(buckets, ", "))
+	}
+	var unsupported []string
+	for _, name := range result.UnavailableEnrichment {
+		if !slices.Contains(result.PermissionMissing, name) {
+			unsupported = append(unsupported, name)
+		}
+	}
+	if len(unsupported) > 0 {
+		fmt.Fprintf(w, "Not available on this server: %s\n", strings.Join(unsupported, ", "))
+	}
+	if len(result.PermissionMissing) > 0 {
+		fmt.Fprintf(w, "Token lacks permission for: %s\n", strings.Join(result.PermissionMissing, ", "))
+	}
+
+	if len(result.Summary.ByTeam) > 0 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TEAM\tTOTAL\tACTIVE\tINACTIVE\tDEACTIVATED\tEXCEPTED")
+		for _, name := range sortedTeamNames(result.Summary.ByTeam) {
+			t := result.Summary.ByTeam[name]
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n", name, t.TotalGuests, t.ActiveGuests, t.InactiveGuests, t.DeactivatedGuests, t.ExceptedGuests)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	// Per-owner counts, each owner's guests following in the listing above
+	if r := result.Summary.Owners; r != nil {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Guests by owner (%s):\n", r.Field)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "OWNER\tTOTAL\tACTIVE\tINACTIVE\tDEACTIVATED\tEXCEPTED")
+		for _, name := range sortedOwnerNames(r.ByOwner) {
+			t := r.ByOwner[name]
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n", name, t.TotalGuests, t.ActiveGuests, t.InactiveGuests, t.DeactivatedGuests, t.ExceptedGuests)
+		}
+		if t := r.Unowned; t.TotalGuests > 0 {
+			fmt.Fprintf(tw, "(no owner)\t%d\t%d\t%d\t%d\t%d\n", t.TotalGuests, t.ActiveGuests, t.InactiveGuests, t.DeactivatedGuests, t.ExceptedGuests)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	// New and removed guests are what a weekly alert is watching for
+	if c := result.Changes; c != nil && len(c.New) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "New guests since the last run:")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "USERNAME\tEMAIL\tCREATED\tTEAMS\tSTATUS")
+		for _, g := range result.Guests {
+			if g.New {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", g.Username, g.Email, result.TimeFormat.Display(g.CreatedAt), formatTeamNames(g.Teams), guestStatus(g))
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if c := result.Changes; c != nil && len(c.Removed) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Guests removed since the last run:")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "USERNAME\tEMAIL\tTEAMS")
+		for _, r := range c.Removed {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Username, r.Email, strings.Join(r.Teams, ", "))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	// Guests with no team do not appear in the per-team breakdown
+	if result.Summary.OrphanedGuests > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Orphaned guests (no team membership):")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "USERNAME\tEMAIL\tCREATED\tLAST LOGIN\tSTATUS")
+		for _, g := range result.Guests {
+			if g.Orphaned {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", g.Username, g.Email, result.TimeFormat.Display(g.CreatedAt), result.TimeFormat.Display(g.LastLogin), guestStatus(g))
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	// Elevated roles are a policy problem of their own, listed in full
+	if result.Summary.ElevatedRoleGuests > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Guests with elevated roles:")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "USERNAME\tEMAIL\tROLES")
+		for _, g := range result.Guests {
+			if len(g.ElevatedRoles) > 0 && !g.ShouldBeGuest && !g.Failed {
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", g.Username, g.Email, formatRoleGrants(g.ElevatedRoles, ", "))
+			}
+		}
+		return tw.Flush()
+	}
 
 	return nil
 }
 
-func writeCSV(w io.Writer, result *AuditResult) error {
+// formatBotGuests describes the guests that look automated, for the table
+// summary. They are counted apart because they are reviewed with their
+// owning team or integration rather than as people.
+func formatBotGuests(result *AuditResult) string {
+	var accounts, names int
+	for _, g := range result.Guests {
+		switch g.Bot {
+		case BotAccount:
+			accounts++
+		case BotUsername:
+			names++
+		}
+	}
+	return fmt.Sprintf("%d guest(s) look automated (%d bot account(s), %d named like a service account); review them apart from people", result.Summary.BotGuests, accounts, names)
+}
+
+// formatLicenseSeats describes the seats guests hold, for the table summary.
+func formatLicenseSeats(l LicenseSeats) string {
+	line := fmt.Sprintf("License: %d seat(s) held by guests", l.GuestSeats)
+	if l.LicensedSeats != nil {
+		line = fmt.Sprintf("License: %d of %d licensed seat(s) held by guests (%.1f%%)", l.GuestSeats, *l.LicensedSeats, 100*float64(l.GuestSeats)/float64(*l.LicensedSeats))
+	}
+	if l.FreeableSeats > 0 {
+		line += fmt.Sprintf("; deactivating the %d inactive guest(s) would free %d seat(s)", l.FreeableSeats, l.FreeableSeats)
+	}
+	return line
+}
+
+// writeTeamSummaryCSV writes one row per team with its guest counts.
+func writeTeamSummaryCSV(w io.Writer, result *AuditResult) error {
 	cw := csv.NewWriter(w)
 	defer cw.Flush()
 
-	// Header row
-	header := []string{"username", "display_name", "email", "created_at", "last_login", "last_post", "teams", "channels", "active", "inactive"}
-	if err := cw.Write(header); err != nil {
+	if err := cw.Write([]string{"team", "total_guests", "active_guests", "inactive_guests", "deactivated_guests", "excepted_guests"}); err != nil {
 		return err
 	}
-
-	for _, g := range result.Guests {
+	for _, name := range sortedTeamNames(result.Summary.ByTeam) {
+		t := result.Summary.ByTeam[name]
 		row := []string{
-			g.Username,
-			g.DisplayName,
-			g.Email,
-			FormatTimeISO(g.CreatedAt),
-			FormatTimeISO(g.LastLogin),
-			FormatTimeISO(g.LastPost),
-			formatTeamNamesCSV(g.Teams),
-			formatChannelNamesCSV(g.Channels),
-			fmt.Sprintf("%t", g.Active),
-			fmt.Sprintf("%t", g.Inactive),
+			name,
+			fmt.Sprintf("%d", t.TotalGuests),
+			fmt.Sprintf("%d", t.ActiveGuests),
+			fmt.Sprintf("%d", t.InactiveGuests),
+			fmt.Sprintf("%d", t.DeactivatedGuests),
+			fmt.Sprintf("%d", t.ExceptedGuests),
 		}
 		if err := cw.Write(row); err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+func sortedTeamNames(byTeam map[string]*TeamSummary) []string {
+	names := make([]string, 0, len(byTeam))
+	for name := range byTeam {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// csvServerColumn leads the CSV columns of a --servers report.
+const csvServerColumn = "server"
+
+// hasServers reports whether result merges several servers' guests, which
+// are then labelled with their server.
+func hasServers(result *AuditResult) bool {
+	return slices.ContainsFunc(result.Guests, func(g GuestRecord) bool { return g.Server != "" })
+}
+
+// csvHeader lists the built-in CSV columns, in order.
+var csvHeader = []string{"username", "display_name", "email", "created_at", "last_login", "last_post", "teams", "channels", "active", "inactive", "retention_channels", "excepted", "exception_justification", "nickname", "previous_usernames", "previous_emails", "last_file_upload", "file_count", "boards", "playbooks", "checksum", "exception_ticket", "private_channels", "last_mention", "post_count", "mention_count", "auth_method", "permission_missing", "possible_shared_account", "shared_session_ips", "orphaned", "should_be_guest", "elevated_roles", "errors", "locale", "timezone", "email_verified", "channel_count", "guest_only_channels", "deactivated_at", "purge_candidate", "age_days", "expired", "last_viewed", "position", "days_since_last_activity", "last_audited_action", "last_audited_at", "notified_at", "new_guest", "password_updated_at", "password_age_days", "password_expired", "bot", "groups", "direct_channels", "channel_joined_at"}
 
+func writeCSV(w io.Writer, result *AuditResult) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	showServer := hasServers(result)
+	if err := cw.Write(csvHeaderRow(result, showServer)); err != nil {
+		return err
+	}
+	for _, g := range result.Guests {
+		if err := cw.Write(csvRow(result, g, showServer)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// csvHeaderRow is the CSV header, led by the server in a --servers report
+// and followed by the --profile-fields columns and any configured extra
+// fields.
+func csvHeaderRow(result *AuditResult, showServer bool) []string {
+	var header []string
+	if showServer {
+		header = append(header, csvServerColumn)
+	}
+	header = append(header, csvHeader...)
+	for _, name := range result.ProfileFields {
+		header = append(header, profileColumn(name))
+	}
+	for _, f := range result.ExtraFields {
+		header = append(header, f.Name)
+	}
+	return header
+}
+
+// csvRow is one guest's CSV row, matching csvHeaderRow.
+func csvRow(result *AuditResult, g GuestRecord, showServer bool) []string {
+	row := []string{
+		g.Username,
+		g.DisplayName,
+		g.Email,
+		result.TimeFormat.ISO(g.CreatedAt),
+		result.TimeFormat.ISO(g.LastLogin),
+		result.TimeFormat.ISO(g.LastPost),
+		formatTeamNamesCSV(g.Teams),
+		formatChannelNamesCSV(g.Channels),
+		fmt.Sprintf("%t", g.Active),
+		fmt.Sprintf("%t", g.Inactive),
+		fmt.Sprintf("%d", g.RetentionChannels),
+		fmt.Sprintf("%t", g.Excepted),
+		g.ExceptionJustification,
+		g.Nickname,
+		strings.Join(g.PreviousUsernames, "|"),
+		strings.Join(g.PreviousEmails, "|"),
+		result.TimeFormat.ISO(g.LastFileUpload),
+		formatOptionalInt(g.FileCount),
+		formatResourcesCSV(g.Boards),
+		formatResourcesCSV(g.Playbooks),
+		g.Checksum,
+		g.ExceptionTicket,
+		fmt.Sprintf("%d", g.PrivateChannels),
+		result.TimeFormat.ISO(g.LastMention),
+		formatOptionalInt(g.PostCount),
+		formatOptionalInt(g.MentionCount),
+		g.AuthMethod,
+		strings.Join(g.PermissionMissing, "|"),
+		formatOptionalBool(g.SharedAccount),
+		strings.Join(g.SharedSessionIPs, "|"),
+		fmt.Sprintf("%t", g.Orphaned),
+		fmt.Sprintf("%t", g.ShouldBeGuest),
+		formatRoleGrants(g.ElevatedRoles, "|"),
+		formatLookupErrors(g.Errors, "|"),
+		g.Locale,
+		g.Timezone,
+		fmt.Sprintf("%t", g.EmailVerified),
+		fmt.Sprintf("%d", g.ChannelCount),
+		formatResourcesCSV(g.GuestOnlyChannels),
+		result.TimeFormat.ISO(g.DeactivatedAt),
+		fmt.Sprintf("%t", g.PurgeCandidate),
+		formatOptionalInt(g.AgeDays),
+		fmt.Sprintf("%t", g.Expired),
+		result.TimeFormat.ISO(g.LastViewed),
+		g.Position,
+		formatOptionalInt(g.DaysSinceLastActivity),
+		g.LastAuditedAction,
+		result.TimeFormat.ISO(g.LastAuditedAt),
+		result.TimeFormat.ISO(g.NotifiedAt),
+		fmt.Sprintf("%t", g.New),
+		result.TimeFormat.ISO(g.PasswordUpdatedAt),
+		formatOptionalInt(g.PasswordAgeDays),
+		fmt.Sprintf("%t", g.PasswordExpired),
+		g.Bot,
+		strings.Join(g.Groups, "|"),
+		formatResourcesCSV(g.DirectChannels),
+		formatChannelJoinsCSV(g.Channels, result.TimeFormat),
+	}
+	if showServer {
+		row = append([]string{g.Server}, row...)
+	}
+	for _, name := range result.ProfileFields {
+		row = append(row, g.ProfileFields[name])
+	}
+	for _, f := range result.ExtraFields {
+		row = append(row, f.Value)
+	}
+	return row
+}
+
 // jsonOutput is the top-level JSON structure for output.
 type jsonOutput struct {
-	Summary      AuditSummary      `json:"summary"`
-	InactiveDays int               `json:"inactive_days"`
-	Guests       []jsonGuestRecord `json:"guests"`
+	SchemaVersion    int               `json:"schema_version"`
+	Metadata         *jsonRunMetadata  `json:"metadata,omitempty"`
+	Stats            *jsonRunStats     `json:"stats,omitempty"`
+	Summary          AuditSummary      `json:"summary"`
+	InactiveDays     int               `json:"inactive_days"`
+	MaxGuestAge      int               `json:"max_guest_age,omitempty"`
+	MaxPasswordAge   int               `json:"max_password_age,omitempty"`
+	GuestRoles       []string          `json:"guest_roles,omitempty"`
+	InactivityMetric InactivityMetric  `json:"inactivity_metric,omitempty"`
+	Deployment       string            `json:"deployment,omitempty"`
+	Unavailable      []string          `json:"unavailable_enrichment,omitempty"`
+	PermissionDenied []string          `json:"permission_missing,omitempty"`
+	ProfileFields    []string          `json:"profile_fields,omitempty"`
+	Changes          *jsonGuestChanges `json:"changes,omitempty"`
+	Guests           []jsonGuestRecord `json:"guests"`
+}
+
+// jsonGuestChanges is the JSON representation of GuestChanges, present
+// only when the run was compared with a state file.
+type jsonGuestChanges struct {
+	Since         string         `json:"since" format:"date-time"`
+	NewGuests     []string       `json:"new_guests"`
+	RemovedGuests []RemovedGuest `json:"removed_guests"`
+	NetChange     int            `json:"net_change"`
+}
+
+func toJSONChanges(c *GuestChanges) *jsonGuestChanges {
+	if c == nil {
+		return nil
+	}
+	return &jsonGuestChanges{
+		Since:         FormatTimeISO(&c.Since),
+		NewGuests:     c.New,
+		RemovedGuests: c.Removed,
+		NetChange:     len(c.New) - len(c.Removed),
+	}
+}
+
+// jsonRunMetadata is the JSON representation of RunMetadata.
+type jsonRunMetadata struct {
+	ServerURL     string            `json:"server_url"`
+	ServerVersion *string           `json:"server_version"`
+	RunBy         string            `json:"run_by"`
+	ToolVersion   string            `json:"tool_version"`
+	StartedAt     string            `json:"started_at" format:"date-time"`
+	FinishedAt    string            `json:"finished_at" format:"date-time"`
+	APICalls      int64             `json:"api_calls"`
+	Filters       map[string]string `json:"filters"`
+}
+
+func toJSONMetadata(m *RunMetadata) *jsonRunMetadata {
+	if m == nil {
+		return nil
+	}
+	out := &jsonRunMetadata{
+		ServerURL:   m.ServerURL,
+		RunBy:       m.RunBy,
+		ToolVersion: m.ToolVersion,
+		StartedAt:   FormatTimeISO(&m.StartedAt),
+		FinishedAt:  FormatTimeISO(&m.FinishedAt),
+		APICalls:    m.APICalls,
+		Filters:     make(map[string]string, len(m.Filters)),
+	}
+	if m.ServerVersion != "" {
+		out.ServerVersion = &m.ServerVersion
+	}
+	for _, f := range m.Filters {
+		out.Filters[f.Name] = f.Value
+	}
+	return out
 }
 
 // jsonGuestRecord is the JSON representation of a guest, with nullable date fields.
 type jsonGuestRecord struct {
-	Username    string        `json:"username"`
-	DisplayName string        `json:"display_name"`
-	Email       string        `json:"email"`
-	CreatedAt   *string       `json:"created_at"`
-	LastLogin   *string       `json:"last_login"`
-	LastPost    *string       `json:"last_post"`
-	Teams       []string      `json:"teams"`
-	Channels    []ChannelInfo `json:"channels"`
-	Active      bool          `json:"active"`
-	Inactive    bool          `json:"inactive"`
+	// Only in a --servers report
+	Server      string  `json:"server,omitempty"`
+	Username    string  `json:"username"`
+	DisplayName string  `json:"display_name"`
+	Nickname    string  `json:"nickname"`
+	Email       string  `json:"email"`
+	Position    string  `json:"position"`
+	AuthMethod  string  `json:"auth_method"`
+	Locale      *string `json:"locale"`   // null when never set
+	Timezone    *string `json:"timezone"` // null when never set
+	CreatedAt   *string `json:"created_at" format:"date-time"`
+	LastLogin   *string `json:"last_login" format:"date-time"`
+	LastPost    *string `json:"last_post" format:"date-time"`
+	// Null unless --last-viewed was used
+	LastViewed *string `json:"last_viewed" format:"date-time"`
+	// Set when some team's channel views could not be read
+	LastViewedUnknown bool `json:"last_viewed_unknown,omitempty"`
+	// Only with --audit-log
+	LastAuditedAction string  `json:"last_audited_action,omitempty"`
+	LastAuditedAt     *string `json:"last_audited_at,omitempty" format:"date-time"`
+	// Only with --notify-guests
+	NotifiedAt *string `json:"notified_at,omitempty" format:"date-time"`
+	// Only when compared with a state file: not a guest in the previous run
+	NewGuest bool `json:"new_guest,omitempty"`
+	// File activity is null unless --file-activity was used
+	LastFileUpload *string  `json:"last_file_upload" format:"date-time"`
+	FileCount      *int     `json:"file_count"`
+	Teams          []string `json:"teams"`
+	// Only with --include-archived-teams: which of teams are archived
+	ArchivedTeams []string      `json:"archived_teams,omitempty"`
+	Channels      []ChannelInfo `json:"channels"`
+	Active        bool          `json:"active"`
+	Inactive      bool          `json:"inactive"`
+	Excepted      bool          `json:"excepted"`
+	Orphaned      bool          `json:"orphaned"`
+	ShouldBeGuest bool          `json:"should_be_guest"`
+	EmailVerified bool          `json:"email_verified"`
+	// Null while the guest is active
+	DeactivatedAt  *string `json:"deactivated_at" format:"date-time"`
+	PurgeCandidate bool    `json:"purge_candidate"`
+	// Null when the creation date is unknown
+	AgeDays *int `json:"age_days"`
+	// Null when the guest has never logged in or posted
+	DaysSinceLastActivity *int `json:"days_since_last_activity"`
+	Expired               bool `json:"expired"`
+	// Null unless the guest signs in with email
+	PasswordUpdatedAt *string `json:"password_updated_at" format:"date-time"`
+	PasswordAgeDays   *int    `json:"password_age_days"`
+	PasswordExpired   bool    `json:"password_expired"`
+	// Only for guests that look automated: "account" or "username"
+	Bot string `json:"bot,omitempty"`
+
+	ExceptionJustification string  `json:"exception_justification,omitempty"`
+	ExceptionExpires       *string `json:"exception_expires,omitempty" format:"date-time"`
+	ExceptionTicket        string  `json:"exception_ticket,omitempty"`
+
+	RetentionChannels int      `json:"retention_channels"`
+	PrivateChannels   int      `json:"private_channels"`
+	ChannelCount      int      `json:"channel_count"`
+	PreviousUsernames []string `json:"previous_usernames,omitempty"`
+	PreviousEmails    []string `json:"previous_emails,omitempty"`
+
+	Boards    []ResourceInfo `json:"boards,omitempty"`
+	Playbooks []ResourceInfo `json:"playbooks,omitempty"`
+	// Only with --guest-only-channels
+	GuestOnlyChannels []ResourceInfo `json:"guest_only_channels,omitempty"`
+
+	// Null unless mentions were searched (--mention-days, --mention-count)
+	LastMention  *string `json:"last_mention" format:"date-time"`
+	MentionCount *int    `json:"mention_count"`
+	// Null unless --post-count was used
+	PostCount *int `json:"post_count"`
+
+	// Null unless --shared-sessions was used
+	PossibleSharedAccount *bool    `json:"possible_shared_account"`
+	SharedSessionIPs      []string `json:"shared_session_ips,omitempty"`
+
+	// Only with --check-roles
+	ElevatedRoles []RoleGrant `json:"elevated_roles,omitempty"`
+
+	// Only with --groups
+	Groups         []string       `json:"groups,omitempty"`
+	DirectChannels []ResourceInfo `json:"direct_channels,omitempty"`
+
+	// Fields that could not be collected for lack of a token permission
+	PermissionMissing []string `json:"permission_missing,omitempty"`
+
+	// Only with --profile-fields, by the server's field name
+	ProfileFields map[string]string `json:"profile_fields,omitempty"`
+
+	// Set when a lookup failed the whole guest, named in errors
+	Failed bool `json:"failed"`
+	// Lookups that failed after their retries
+	Errors []LookupError `json:"errors,omitempty"`
+
+	Checksum string `json:"checksum,omitempty"`
+
+	// Constant fields from the config's extra_fields
+	ExtraFields map[string]string `json:"extra_fields,omitempty"`
 }
 
 func writeJSON(w io.Writer, result *AuditResult) error {
 	output := jsonOutput{
+		SchemaVersion: ReportSchemaVersion,
+		Metadata:      toJSONMetadata(result.Metadata),
+		Stats:         toJSONStats(result.Stats),
+
 		Summary:      result.Summary,
 		InactiveDays: result.InactiveDays,
+		MaxGuestAge:  result.MaxGuestAge,
+		GuestRoles:   result.GuestRoles,
 		Guests:       make([]jsonGuestRecord, 0, len(result.Guests)),
+
+		MaxPasswordAge:   result.MaxPasswordAge,
+		InactivityMetric: result.InactivityMetric,
+		Deployment:       result.Deployment,
+		Unavailable:      result.UnavailableEnrichment,
+		PermissionDenied: result.PermissionMissing,
+		ProfileFields:    result.ProfileFields,
+		Changes:          toJSONChanges(result.Changes),
 	}
 
+	extra := jsonExtraFields(result)
 	for _, g := range result.Guests {
-		teamNames := make([]string, 0, len(g.Teams))
-		for _, t := range g.Teams {
-			teamNames = append(teamNames, t.DisplayName)
-		}
+		output.Guests = append(output.Guests, toJSONGuest(g, extra))
+	}
 
-		channels := g.Channels
-		if channels == nil {
-			channels = []ChannelInfo{}
-		}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(output)
+}
 
-		record := jsonGuestRecord{
-			Username:    g.Username,
-			DisplayName: g.DisplayName,
-			Email:       g.Email,
-			CreatedAt:   timeToStringPtr(g.CreatedAt),
-			LastLogin:   timeToStringPtr(g.LastLogin),
-			LastPost:    timeToStringPtr(g.LastPost),
-			Teams:       teamNames,
-			Channels:    channels,
-			Active:      g.Active,
-			Inactive:    g.Inactive,
+// jsonExtraFields returns the configured extra fields by name, or nil.
+func jsonExtraFields(result *AuditResult) map[string]string {
+	if len(result.ExtraFields) == 0 {
+		return nil
+	}
+	extra := make(map[string]string, len(result.ExtraFields))
+	for _, f := range result.ExtraFields {
+		extra[f.Name] = f.Value
+	}
+	return extra
+}
+
+// toJSONGuest converts a guest for the json and ndjson formats. extra is
+// from jsonExtraFields.
+func toJSONGuest(g GuestRecord, extra map[string]string) jsonGuestRecord {
+	teamNames := make([]string, 0, len(g.Teams))
+	var archivedTeams []string
+	for _, t := range g.Teams {
+		teamNames = append(teamNames, t.DisplayName)
+		if t.Archived {
+			archivedTeams = append(archivedTeams, t.DisplayName)
 		}
-		output.Guests = append(output.Guests, record)
 	}
 
+	channels := g.Channels
+	if channels == nil {
+		channels = []ChannelInfo{}
+	}
+
+	record := jsonGuestRecord{
+		Server:            g.Server,
+		Username:          g.Username,
+		DisplayName:       g.DisplayName,
+		Nickname:          g.Nickname,
+		Email:             g.Email,
+		Position:          g.Position,
+		AuthMethod:        g.AuthMethod,
+		Locale:            stringToPtr(g.Locale),
+		Timezone:          stringToPtr(g.Timezone),
+		CreatedAt:         timeToStringPtr(g.CreatedAt),
+		LastLogin:         timeToStringPtr(g.LastLogin),
+		LastPost:          timeToStringPtr(g.LastPost),
+		LastViewed:        timeToStringPtr(g.LastViewed),
+		LastViewedUnknown: g.ViewUnknown,
+		LastAuditedAction: g.LastAuditedAction,
+		LastAuditedAt:     timeToStringPtr(g.LastAuditedAt),
+		NotifiedAt:        timeToStringPtr(g.NotifiedAt),
+		NewGuest:          g.New,
+		Teams:             teamNames,
+		ArchivedTeams:     archivedTeams,
+		Channels:          channels,
+		Active:            g.Active,
+		Inactive:          g.Inactive,
+		Excepted:          g.Excepted,
+		Orphaned:          g.Orphaned,
+
+		ShouldBeGuest:  g.ShouldBeGuest,
+		EmailVerified:  g.EmailVerified,
+		DeactivatedAt:  timeToStringPtr(g.DeactivatedAt),
+		PurgeCandidate: g.PurgeCandidate,
+		AgeDays:        g.AgeDays,
+		Expired:        g.Expired,
+
+		DaysSinceLastActivity: g.DaysSinceLastActivity,
+		LastFileUpload:        timeToStringPtr(g.LastFileUpload),
+		FileCount:             g.FileCount,
+		LastMention:           timeToStringPtr(g.LastMention),
+		PostCount:             g.PostCount,
+		MentionCount:          g.MentionCount,
+
+		ExceptionJustification: g.ExceptionJustification,
+		ExceptionExpires:       timeToStringPtr(g.ExceptionExpires),
+		ExceptionTicket:        g.ExceptionTicket,
+
+		RetentionChannels: g.RetentionChannels,
+		PrivateChannels:   g.PrivateChannels,
+		ChannelCount:      g.ChannelCount,
+		PreviousUsernames: g.PreviousUsernames,
+		PreviousEmails:    g.PreviousEmails,
+		Boards:            g.Boards,
+		Playbooks:         g.Playbooks,
+		GuestOnlyChannels: g.GuestOnlyChannels,
+		PermissionMissing: g.PermissionMissing,
+		ProfileFields:     g.ProfileFields,
+		Failed:            g.Failed,
+		Errors:            g.Errors,
+		Checksum:          g.Checksum,
+		ExtraFields:       extra,
+
+		PossibleSharedAccount: g.SharedAccount,
+		SharedSessionIPs:      g.SharedSessionIPs,
+		ElevatedRoles:         g.ElevatedRoles,
+
+		Groups:         g.Groups,
+		DirectChannels: g.DirectChannels,
+
+		PasswordUpdatedAt: timeToStringPtr(g.PasswordUpdatedAt),
+		PasswordAgeDays:   g.PasswordAgeDays,
+		PasswordExpired:   g.PasswordExpired,
+		Bot:               g.Bot,
+	}
+	return record
+}
+
+// writeNDJSON writes one JSON object per guest per line, without the
+// summary and metadata of --format json, so each line can be processed as
+// it arrives.
+func writeNDJSON(w io.Writer, result *AuditResult) error {
 	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	return enc.Encode(output)
+	extra := jsonExtraFields(result)
+	for _, g := range result.Guests {
+		if err := enc.Encode(toJSONGuest(g, extra)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stringToPtr returns nil for an empty string, which JSON writes as null.
+func stringToPtr(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
 }
 
 func timeToStringPtr(t *time.Time) *string {
@@ -184,23 +942,122 @@ func timeToStringPtr(t *time.Time) *string {
 	return &s
 }
 
+// formatResourcesCSV formats boards or playbooks as "Team/Name" joined by pipes.
+func formatResourcesCSV(resources []ResourceInfo) string {
+	names := make([]string, len(resources))
+	for i, r := range resources {
+		names[i] = r.TeamName + "/" + r.Name
+	}
+	return strings.Join(names, "|")
+}
+
+// formatStageCounts renders failure counts as "stage: n", by stage name.
+func formatStageCounts(counts map[string]int) string {
+	stages := make([]string, 0, len(counts))
+	for stage := range counts {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+	parts := make([]string, len(stages))
+	for i, stage := range stages {
+		parts[i] = fmt.Sprintf("%s: %d", stage, counts[stage])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatLookupErrors formats failed lookups with LookupError.String.
+func formatLookupErrors(errs []LookupError, sep string) string {
+	parts := make([]string, len(errs))
+	for i, e := range errs {
+		parts[i] = e.String()
+	}
+	return strings.Join(parts, sep)
+}
+
+// formatOptionalInt renders a count that may not have been collected.
+func formatOptionalInt(n *int) string {
+	if n == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d", *n)
+}
+
+// formatOptionalBool renders a flag that may not have been checked.
+func formatOptionalBool(b *bool) string {
+	if b == nil {
+		return ""
+	}
+	return fmt.Sprintf("%t", *b)
+}
+
 func guestStatus(g GuestRecord) string {
+	if g.ShouldBeGuest {
+		if !g.Active {
+			return "Member (deactivated)"
+		}
+		return "Member, should be guest"
+	}
 	if !g.Active {
 		return "Deactivated"
 	}
+	if g.Excepted {
+		return "Excepted"
+	}
 	if g.Inactive {
 		return "Inactive"
 	}
 	return "Active"
 }
 
+// formatAgeDays shows a guest's account age for the table, marking guests
+// older than --max-guest-age.
+func formatAgeDays(g GuestRecord) string {
+	if g.AgeDays == nil {
+		return ""
+	}
+	if g.Expired {
+		return fmt.Sprintf("%d (expired)", *g.AgeDays)
+	}
+	return fmt.Sprintf("%d", *g.AgeDays)
+}
+
+// formatPasswordAgeDays shows a guest's password age for the table, marking
+// passwords older than --max-password-age.
+func formatPasswordAgeDays(g GuestRecord) string {
+	if g.PasswordAgeDays == nil {
+		return ""
+	}
+	if g.PasswordExpired {
+		return fmt.Sprintf("%d (expired)", *g.PasswordAgeDays)
+	}
+	return fmt.Sprintf("%d", *g.PasswordAgeDays)
+}
+
+// formatLastAudited shows a guest's last audited action and its time for
+// the table, such as "login" followed by the date.
+func formatLastAudited(g GuestRecord, f TimeFormat) string {
+	if g.LastAuditedAt == nil {
+		return f.Display(nil)
+	}
+	return g.LastAuditedAction + " " + f.Display(g.LastAuditedAt)
+}
+
+// formatLastViewed shows a guest's last channel view for the table, which
+// is "Unknown" rather than "Never" when some team could not be read.
+func formatLastViewed(g GuestRecord, f TimeFormat) string {
+	if g.ViewUnknown && g.LastViewed == nil {
+		return "Unknown"
+	}
+	return f.Display(g.LastViewed)
+}
+
 func formatTeamNames(teams []TeamInfo) string {
 	if len(teams) == 0 {
 		return ""
 	}
 	names := make([]string, len(teams))
 	for i, t := range teams {
-		names[i] = t.DisplayName
+		names[i] = teamLabel(t)
 	}
 	return strings.Join(names, ", ")
 }
@@ -211,7 +1068,7 @@ func formatTeamNamesCSV(teams []TeamInfo) string {
 	}
 	names := make([]string, len(teams))
 	for i, t := range teams {
-		names[i] = t.DisplayName
+		names[i] = teamLabel(t)
 	}
 	return strings.Join(names, "|")
 }
@@ -226,7 +1083,7 @@ func formatChannelNamesTable(channels []ChannelInfo) string {
 		if i >= maxDisplay {
 			break
 		}
-		names = append(names, ch.ChannelName)
+		names = append(names, channelLabel(ch, ch.ChannelName))
 	}
 	result := strings.Join(names, ", ")
 	if len(channels) > maxDisplay {
@@ -241,7 +1098,36 @@ func formatChannelNamesCSV(channels []ChannelInfo) string {
 	}
 	pairs := make([]string, len(channels))
 	for i, ch := range channels {
-		pairs[i] = ch.TeamName + "/" + ch.ChannelName
+		pairs[i] = channelLabel(ch, ch.TeamName+"/"+ch.ChannelName)
 	}
 	return strings.Join(pairs, "|")
 }
+
+// formatChannelJoinsCSV lists the channels with a known join time as
+// Team/Channel=time, in the order of the channels column.
+func formatChannelJoinsCSV(channels []ChannelInfo, f TimeFormat) string {
+	var joins []string
+	for _, ch := range channels {
+		if ch.JoinedAt != nil {
+			joins = append(joins, ch.TeamName+"/"+ch.ChannelName+"="+f.ISO(ch.JoinedAt))
+		}
+	}
+	return strings.Join(joins, "|")
+}
+
+// teamLabel returns the team's name, marked "[archived]" for an archived
+// team, as channelLabel does for channels.
+func teamLabel(t TeamInfo) string {
+	if t.Archived {
+		return t.DisplayName + " [archived]"
+	}
+	return t.DisplayName
+}
+
+// channelLabel returns name, marked "[archived]" for an archived channel.
+func channelLabel(ch ChannelInfo, name string) string {
+	if ch.Archived {
+		return name + " [archived]"
+	}
+	return name
+}
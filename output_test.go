@@ -4,6 +4,10 @@ import (
 	"bytes"
 	"encoding/csv"
 	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -21,6 +25,8 @@ func sampleResult() *AuditResult {
 				Username:    "jane.doe",
 				DisplayName: "Jane Doe",
 				Email:       "jane.doe@external.com",
+				Locale:      "de",
+				Timezone:    "Europe/Berlin",
 				CreatedAt:   &created,
 				LastLogin:   &login,
 				LastPost:    &post,
@@ -35,6 +41,8 @@ func sampleResult() *AuditResult {
 				},
 				Active:   true,
 				Inactive: false,
+
+				EmailVerified: true,
 			},
 			{
 				Username:    "bob.contractor",
@@ -57,6 +65,10 @@ func sampleResult() *AuditResult {
 			TotalGuests:    2,
 			ActiveGuests:   1,
 			InactiveGuests: 1,
+			ByTeam: map[string]*TeamSummary{
+				"Engineering": {TotalGuests: 2, ActiveGuests: 1, InactiveGuests: 1},
+				"Sales":       {TotalGuests: 1, ActiveGuests: 1},
+			},
 		},
 	}
 }
@@ -151,6 +163,62 @@ func TestFormatCSV_EmptyResult(t *testing.T) {
 	}
 }
 
+func TestFormatCSV_ExtraFields(t *testing.T) {
+	result := sampleResult()
+	result.ExtraFields = []ExtraField{{"environment", "prod"}, {"region", "eu"}}
+
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, result); err != nil {
+		t.Fatalf("writeCSV error: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV parse error: %v", err)
+	}
+
+	n := len(csvHeader)
+	if got := strings.Join(records[0][n:], ","); got != "environment,region" {
+		t.Errorf("extra header columns = %q, want environment,region", got)
+	}
+	for i, row := range records[1:] {
+		if got := strings.Join(row[n:], ","); got != "prod,eu" {
+			t.Errorf("row %d extra values = %q, want prod,eu", i+1, got)
+		}
+	}
+}
+
+func TestFormatCSV_ProfileFields(t *testing.T) {
+	result := sampleResult()
+	result.ProfileFields = []string{"Company", "Cost Centre"}
+	result.ExtraFields = []ExtraField{{"environment", "prod"}}
+	result.Guests[0].Position = "Consultant"
+	result.Guests[0].ProfileFields = map[string]string{"Company": "Acme Ltd", "Cost Centre": "CC-12"}
+
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, result); err != nil {
+		t.Fatalf("writeCSV error: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV parse error: %v", err)
+	}
+
+	// Profile columns follow the built-in ones, before any extra fields
+	n := len(csvHeader)
+	if got := strings.Join(records[0][n:], ","); got != "profile_company,profile_cost_centre,environment" {
+		t.Errorf("header tail = %q", got)
+	}
+	if got := strings.Join(records[1][n:], ","); got != "Acme Ltd,CC-12,prod" {
+		t.Errorf("row 1 tail = %q", got)
+	}
+	if got := strings.Join(records[2][n:], ","); got != ",,prod" {
+		t.Errorf("row 2 tail = %q, want empty profile values", got)
+	}
+	if i := slices.Index(csvHeader, "position"); records[1][i] != "Consultant" {
+		t.Errorf("position = %q, want Consultant", records[1][i])
+	}
+}
+
 func TestFormatJSON(t *testing.T) {
 	result := sampleResult()
 	var buf bytes.Buffer
@@ -196,6 +264,33 @@ func TestFormatJSON(t *testing.T) {
 	}
 }
 
+func TestFormatJSON_ExtraFields(t *testing.T) {
+	result := sampleResult()
+	result.ExtraFields = []ExtraField{{"environment", "prod"}}
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, result); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+	var output jsonOutput
+	if err := json.Unmarshal(buf.Bytes(), &output); err != nil {
+		t.Fatalf("JSON parse error: %v", err)
+	}
+	for _, g := range output.Guests {
+		if g.ExtraFields["environment"] != "prod" {
+			t.Errorf("%s extra_fields = %v, want environment=prod", g.Username, g.ExtraFields)
+		}
+	}
+
+	buf.Reset()
+	if err := writeJSON(&buf, sampleResult()); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+	if strings.Contains(buf.String(), "extra_fields") {
+		t.Error("extra_fields should be omitted when none are configured")
+	}
+}
+
 func TestFormatJSON_NilDates(t *testing.T) {
 	result := sampleResult()
 	var buf bytes.Buffer
@@ -224,6 +319,14 @@ func TestFormatJSON_NilDates(t *testing.T) {
 	if !strings.Contains(raw, `"last_login": null`) {
 		t.Error("expected JSON null for last_login, not found in raw output")
 	}
+
+	// Unset locale and timezone are null too
+	if g.Locale != nil || g.Timezone != nil {
+		t.Errorf("locale and timezone should be null, got %v and %v", g.Locale, g.Timezone)
+	}
+	if first := output.Guests[0]; first.Timezone == nil || *first.Timezone != "Europe/Berlin" {
+		t.Errorf("timezone = %v, want Europe/Berlin", first.Timezone)
+	}
 }
 
 func TestFormatTable(t *testing.T) {
@@ -271,6 +374,203 @@ func TestFormatTable(t *testing.T) {
 	}
 }
 
+func TestFormatTable_LastViewedColumn(t *testing.T) {
+	result := sampleResult()
+	var buf bytes.Buffer
+	if err := writeTable(&buf, result); err != nil {
+		t.Fatalf("writeTable error: %v", err)
+	}
+	if strings.Contains(buf.String(), "LAST VIEWED") {
+		t.Errorf("last viewed column shown without --last-viewed:\n%s", buf.String())
+	}
+
+	viewed := time.Date(2024, 11, 18, 16, 5, 0, 0, time.UTC)
+	result.Guests[0].LastViewed = &viewed
+	buf.Reset()
+	if err := writeTable(&buf, result); err != nil {
+		t.Fatalf("writeTable error: %v", err)
+	}
+	for _, want := range []string{"LAST VIEWED", "2024-11-18 16:05"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("table missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestFormatTable_UnavailableEnrichment(t *testing.T) {
+	result := sampleResult()
+	result.UnavailableEnrichment = []string{EnrichRetention, EnrichIdentityHistory}
+	result.PermissionMissing = []string{EnrichIdentityHistory}
+
+	var buf bytes.Buffer
+	if err := writeTable(&buf, result); err != nil {
+		t.Fatalf("writeTable error: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"Not available on this server: retention_policies\n",
+		"Token lacks permission for: identity_history\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("table output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestFormatTable_OrphanedGuests(t *testing.T) {
+	result := sampleResult()
+	result.Guests = append(result.Guests, GuestRecord{Username: "left.behind", Email: "lb@vendor.io", Active: true, Orphaned: true})
+	summarize(result, nil, time.Now())
+
+	var buf bytes.Buffer
+	if err := writeTable(&buf, result); err != nil {
+		t.Fatalf("writeTable error: %v", err)
+	}
+	out := buf.String()
+	_, section, ok := strings.Cut(out, "Orphaned guests (no team membership):\n")
+	if !ok {
+		t.Fatalf("table output has no orphaned section:\n%s", out)
+	}
+	if !strings.Contains(section, "left.behind") || strings.Contains(section, "jane.doe") {
+		t.Errorf("orphaned section should list only left.behind:\n%s", section)
+	}
+	if !strings.Contains(out, "1 guest(s) with no team membership") {
+		t.Errorf("summary missing orphan count:\n%s", out)
+	}
+}
+
+func TestFormatOutput_GuestChanges(t *testing.T) {
+	result := sampleResult()
+	result.Guests[0].New = true
+	result.Changes = &GuestChanges{
+		Since:   time.Date(2026, 10, 9, 8, 0, 0, 0, time.UTC),
+		New:     []string{"jane.doe"},
+		Removed: []RemovedGuest{{Username: "gone", Email: "gone@vendor.io", Teams: []string{"Sales"}}, {Username: "left"}},
+	}
+	summarize(result, nil, time.Now())
+
+	var buf bytes.Buffer
+	if err := writeTable(&buf, result); err != nil {
+		t.Fatalf("writeTable error: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "Since the last run (2026-10-09 08:00): 1 new guest(s), 2 removed (net -1)") {
+		t.Errorf("summary missing the changes:\n%s", out)
+	}
+	_, section, ok := strings.Cut(out, "New guests since the last run:\n")
+	if !ok || !strings.Contains(section, "jane.doe") {
+		t.Fatalf("table output has no new guests section:\n%s", out)
+	}
+	newGuests, removed, ok := strings.Cut(section, "Guests removed since the last run:\n")
+	if !ok || strings.Contains(newGuests, result.Guests[1].Username) || !strings.Contains(removed, "gone@vendor.io  Sales") {
+		t.Errorf("unexpected sections:\n%s", section)
+	}
+
+	buf.Reset()
+	if err := writeJSON(&buf, result); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+	var parsed struct {
+		Changes struct {
+			Since         string         `json:"since"`
+			NewGuests     []string       `json:"new_guests"`
+			RemovedGuests []RemovedGuest `json:"removed_guests"`
+			NetChange     int            `json:"net_change"`
+		} `json:"changes"`
+		Guests []struct {
+			NewGuest bool `json:"new_guest"`
+		} `json:"guests"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &parsed); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if c := parsed.Changes; c.Since != "2026-10-09T08:00:00Z" || len(c.NewGuests) != 1 || len(c.RemovedGuests) != 2 || c.NetChange != -1 {
+		t.Errorf("changes = %+v", c)
+	}
+	if !parsed.Guests[0].NewGuest || parsed.Guests[1].NewGuest {
+		t.Errorf("new_guest not set on the right records: %s", buf.String())
+	}
+
+	// No state compared, no changes block
+	result.Changes = nil
+	buf.Reset()
+	if err := writeJSON(&buf, result); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+	if strings.Contains(buf.String(), `"changes"`) {
+		t.Errorf("changes written without a state file:\n%s", buf.String())
+	}
+}
+
+func TestFormatTable_MembersShouldBeGuests(t *testing.T) {
+	result := sampleResult()
+	result.Guests = append(result.Guests, GuestRecord{Username: "alice", Email: "alice@partner.com", Active: true, ShouldBeGuest: true})
+	summarize(result, nil, time.Now())
+
+	var buf bytes.Buffer
+	if err := writeTable(&buf, result); err != nil {
+		t.Fatalf("writeTable error: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "Member, should be guest") {
+		t.Errorf("alice should be marked as a member who should be a guest:\n%s", out)
+	}
+	if !strings.Contains(out, "Total: 2 guest(s)") || !strings.Contains(out, "1 member(s) with an external email domain") {
+		t.Errorf("summary should count alice as a member, not a guest:\n%s", out)
+	}
+
+	buf.Reset()
+	if err := writeCSV(&buf, result); err != nil {
+		t.Fatalf("writeCSV error: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV parse error: %v", err)
+	}
+	col := slices.Index(records[0], "should_be_guest")
+	if col < 0 {
+		t.Fatalf("CSV header has no should_be_guest column: %v", records[0])
+	}
+	if records[1][col] != "false" || records[3][col] != "true" {
+		t.Errorf("should_be_guest = %q, %q, want false, true", records[1][col], records[3][col])
+	}
+}
+
+func TestFormatTable_ElevatedRoles(t *testing.T) {
+	result := sampleResult()
+	result.Guests[1].ElevatedRoles = []RoleGrant{{TeamName: "Engineering", Role: "team_admin"}, {TeamName: "Engineering", ChannelName: "General", Role: "channel_admin"}}
+	summarize(result, nil, time.Now())
+
+	var buf bytes.Buffer
+	if err := writeTable(&buf, result); err != nil {
+		t.Fatalf("writeTable error: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "1 guest(s) holding team or channel roles beyond guest") {
+		t.Errorf("summary missing elevated role count:\n%s", out)
+	}
+	_, section, ok := strings.Cut(out, "Guests with elevated roles:\n")
+	if !ok {
+		t.Fatalf("table output has no elevated roles section:\n%s", out)
+	}
+	if !strings.Contains(section, "Engineering:team_admin, Engineering/General:channel_admin") || strings.Contains(section, "jane.doe") {
+		t.Errorf("elevated roles section should list only bob.contractor's roles:\n%s", section)
+	}
+
+	buf.Reset()
+	if err := writeCSV(&buf, result); err != nil {
+		t.Fatalf("writeCSV error: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV parse error: %v", err)
+	}
+	col := slices.Index(records[0], "elevated_roles")
+	if col < 0 || records[2][col] != "Engineering:team_admin|Engineering/General:channel_admin" {
+		t.Errorf("elevated_roles column missing or wrong: %v", records)
+	}
+}
+
 func TestFormatTable_ChannelTruncation(t *testing.T) {
 	result := &AuditResult{
 		Guests: []GuestRecord{
@@ -297,3 +597,288 @@ func TestFormatTable_ChannelTruncation(t *testing.T) {
 		t.Errorf("expected (+3 more) truncation, got:\n%s", output)
 	}
 }
+
+func TestFormatTable_ByTeam(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeTable(&buf, sampleResult()); err != nil {
+		t.Fatalf("writeTable error: %v", err)
+	}
+	output := buf.String()
+
+	if !strings.Contains(output, "TEAM") || !strings.Contains(output, "DEACTIVATED") {
+		t.Errorf("table missing per-team header, got:\n%s", output)
+	}
+	eng := strings.Index(output, "Engineering  2")
+	sales := strings.Index(output, "Sales        1")
+	if eng < 0 || sales < 0 || sales < eng {
+		t.Errorf("expected per-team rows sorted by name, got:\n%s", output)
+	}
+}
+
+func TestFormatJSON_ByTeam(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, sampleResult()); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+
+	var parsed struct {
+		Summary struct {
+			ByTeam map[string]TeamSummary `json:"by_team"`
+		} `json:"summary"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &parsed); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if got := parsed.Summary.ByTeam["Engineering"]; got.TotalGuests != 2 || got.InactiveGuests != 1 {
+		t.Errorf("by_team.Engineering = %+v, want 2 total, 1 inactive", got)
+	}
+}
+
+func TestWriteOutputDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "report")
+	if err := WriteOutputDir(sampleResult(), "csv", dir); err != nil {
+		t.Fatalf("WriteOutputDir error: %v", err)
+	}
+
+	guests, err := os.ReadFile(filepath.Join(dir, "guests.csv"))
+	if err != nil {
+		t.Fatalf("guests.csv not written: %v", err)
+	}
+	if !strings.Contains(string(guests), "jane.doe") {
+		t.Error("guests.csv missing guest rows")
+	}
+
+	teams, err := os.ReadFile(filepath.Join(dir, "teams.csv"))
+	if err != nil {
+		t.Fatalf("teams.csv not written: %v", err)
+	}
+	records, err := csv.NewReader(bytes.NewReader(teams)).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid teams.csv: %v", err)
+	}
+	want := [][]string{
+		{"team", "total_guests", "active_guests", "inactive_guests", "deactivated_guests", "excepted_guests"},
+		{"Engineering", "2", "1", "1", "0", "0"},
+		{"Sales", "1", "1", "0", "0", "0"},
+	}
+	if fmt.Sprint(records) != fmt.Sprint(want) {
+		t.Errorf("teams.csv = %v, want %v", records, want)
+	}
+
+	// JSON carries the breakdown inline, so no teams.csv is added
+	jsonDir := filepath.Join(t.TempDir(), "json")
+	if err := WriteOutputDir(sampleResult(), "json", jsonDir); err != nil {
+		t.Fatalf("WriteOutputDir error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(jsonDir, "guests.json")); err != nil {
+		t.Errorf("guests.json not written: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(jsonDir, "teams.csv")); err == nil {
+		t.Error("teams.csv should only be written for CSV output")
+	}
+}
+
+func TestParseTimeFormat(t *testing.T) {
+	tests := []struct {
+		timezone, layout string
+		wantLayout       string
+		wantErr          bool
+	}{
+		{"", "", "", false},
+		{"Europe/London", "", "", false},
+		{"", "date", "2006-01-02", false},
+		{"", "EU", "02/01/2006 15:04", false},
+		{"", "02 Jan 2006", "02 Jan 2006", false},
+		{"Mars/Olympus", "", "", true},
+		{"", "yyyy-mm-dd", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.timezone+"|"+tt.layout, func(t *testing.T) {
+			f, err := ParseTimeFormat(tt.timezone, tt.layout)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTimeFormat error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && f.Layout != tt.wantLayout {
+				t.Errorf("layout = %q, want %q", f.Layout, tt.wantLayout)
+			}
+		})
+	}
+}
+
+func TestTimeFormat(t *testing.T) {
+	summer := time.Date(2024, 7, 1, 12, 30, 0, 0, time.UTC)
+	london, err := ParseTimeFormat("Europe/London", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom, err := ParseTimeFormat("Europe/London", "eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		format      TimeFormat
+		wantISO     string
+		wantDisplay string
+	}{
+		{"default", TimeFormat{}, "2024-07-01T12:30:00Z", "2024-07-01 12:30"},
+		{"timezone", london, "2024-07-01T13:30:00+01:00", "2024-07-01 13:30 BST"},
+		{"timezone and layout", custom, "2024-07-01T13:30:00+01:00", "01/07/2024 13:30"}, // CSV stays ISO 8601
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.format.ISO(&summer); got != tt.wantISO {
+				t.Errorf("ISO = %q, want %q", got, tt.wantISO)
+			}
+			if got := tt.format.Display(&summer); got != tt.wantDisplay {
+				t.Errorf("Display = %q, want %q", got, tt.wantDisplay)
+			}
+			if tt.format.ISO(nil) != "" || tt.format.Display(nil) != "Never" {
+				t.Error("nil times should render as empty / Never")
+			}
+		})
+	}
+}
+
+func TestFormatCSV_TimeFormat(t *testing.T) {
+	result := sampleResult()
+	result.TimeFormat, _ = ParseTimeFormat("America/New_York", "")
+
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, result); err != nil {
+		t.Fatalf("writeCSV error: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	// 2024-11-15T08:32:00Z is 03:32 EST
+	if got := records[1][4]; got != "2024-11-15T03:32:00-05:00" {
+		t.Errorf("last_login = %q, want 2024-11-15T03:32:00-05:00", got)
+	}
+
+	// JSON always stays in UTC
+	var js bytes.Buffer
+	if err := writeJSON(&js, result); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(js.String(), `"last_login": "2024-11-15T08:32:00Z"`) {
+		t.Error("JSON dates should not be affected by --timezone")
+	}
+}
+
+func TestFormatLicenseSeats(t *testing.T) {
+	seats := 500
+	tests := []struct {
+		name    string
+		license LicenseSeats
+		want    string
+	}{
+		{"licensed", LicenseSeats{LicensedSeats: &seats, GuestSeats: 42, FreeableSeats: 7}, "License: 42 of 500 licensed seat(s) held by guests (8.4%); deactivating the 7 inactive guest(s) would free 7 seat(s)"},
+		{"nothing to free", LicenseSeats{LicensedSeats: &seats, GuestSeats: 42}, "License: 42 of 500 licensed seat(s) held by guests (8.4%)"},
+		{"unknown license", LicenseSeats{GuestSeats: 42, FreeableSeats: 7}, "License: 42 seat(s) held by guests; deactivating the 7 inactive guest(s) would free 7 seat(s)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatLicenseSeats(tt.license); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatOutput_LookupErrors(t *testing.T) {
+	result := sampleResult()
+	result.Guests[0].Errors = []LookupError{{Stage: LookupChannels, Team: "Sales", Message: "HTTP 500", HTTPStatus: 500}, {Stage: LookupLastPost, Message: "HTTP 503", HTTPStatus: 503}}
+	summarize(result, nil, time.Now())
+
+	var buf bytes.Buffer
+	if err := writeTable(&buf, result); err != nil {
+		t.Fatalf("writeTable error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "1 guest(s) reported with some lookups failed") {
+		t.Errorf("summary missing incomplete guest count:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := writeCSV(&buf, result); err != nil {
+		t.Fatalf("writeCSV error: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV parse error: %v", err)
+	}
+	col := slices.Index(records[0], "errors")
+	if col < 0 || records[1][col] != "channels (Sales): HTTP 500|last_post: HTTP 503" || records[2][col] != "" {
+		t.Errorf("unexpected errors column: %v", records)
+	}
+
+	buf.Reset()
+	if err := writeJSON(&buf, result); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+	for _, want := range []string{`"stage": "channels",
+          "team": "Sales",`, `"http_status": 500`, `"incomplete_guests": 1`, `"failures_by_stage": {
+      "channels": 1,
+      "last_post": 1
+    }`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("JSON missing %s:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestWriteTable_AgeColumn(t *testing.T) {
+	age, expiredAge := 30, 400
+	result := &AuditResult{
+		MaxGuestAge: 365,
+		Guests: []GuestRecord{
+			{Username: "new", Active: true, AgeDays: &age},
+			{Username: "old", Active: true, AgeDays: &expiredAge, Expired: true},
+		},
+		Summary: AuditSummary{TotalGuests: 2, ActiveGuests: 2, ExpiredGuests: 1},
+	}
+	var buf bytes.Buffer
+	if err := writeTable(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"AGE (DAYS)", "400 (expired)", "1 guest account(s) older than the maximum guest age of 365 day(s)"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("table missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	result.MaxGuestAge = 0
+	buf.Reset()
+	if err := writeTable(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "AGE (DAYS)") {
+		t.Errorf("age column shown without --max-guest-age:\n%s", buf.String())
+	}
+}
+
+func TestWriteTable_PasswordAgeColumn(t *testing.T) {
+	recent, stale := 30, 400
+	result := &AuditResult{
+		MaxPasswordAge: 180,
+		Guests: []GuestRecord{
+			{Username: "recent", Active: true, PasswordAgeDays: &recent},
+			{Username: "stale", Active: true, PasswordAgeDays: &stale, PasswordExpired: true},
+			{Username: "sso", Active: true, AuthMethod: "saml"},
+		},
+		Summary: AuditSummary{TotalGuests: 3, ActiveGuests: 3, ExpiredPasswords: 1},
+	}
+	var buf bytes.Buffer
+	if err := writeTable(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"PASSWORD AGE (DAYS)", "400 (expired)", "1 guest(s) with a password older than the maximum password age of 180 day(s)"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("table missing %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// maxPostLength is the longest message --notify-owners posts, a little under
+// Mattermost's 16,383 character limit; a longer batch is split.
+const maxPostLength = 16000
+
+// OwnerGuest is one flagged guest in an owner's batch, with the channels
+// they share that the owner administers.
+type OwnerGuest struct {
+	Username    string
+	DisplayName string
+	Email       string
+	LastLogin   *time.Time
+	Channels    []string // "Team/Channel"
+}
+
+// OwnerRequest is the batch of flagged guests one channel admin is asked
+// about, sent as a single message so nobody gets one per guest.
+type OwnerRequest struct {
+	OwnerID string
+	Owner   string // username
+	Guests  []OwnerGuest
+}
+
+// OwnerSummary counts what --notify-owners did.
+type OwnerSummary struct {
+	Sent    int // owners messaged
+	Failed  int // owners whose message failed
+	Guests  int // guests in at least one batch
+	Unowned int // guests with no channel admin to ask
+}
+
+// PlanOwnerRequests groups the guests PlanNotifications would message by
+// the admins of their channels, looking up each channel's admins once.
+// DMs, group messages and archived channels have no admins to ask, and
+// admins who are themselves guests in the report are skipped. A failed
+// lookup is reported and leaves that channel out; the exit code is then
+// ExitPartialFailure. Batches are ordered by owner, and each batch by guest.
+func PlanOwnerRequests(client MattermostClient, result *AuditResult, retry RetryPolicy, verbose bool) ([]OwnerRequest, OwnerSummary, int) {
+	var s OwnerSummary
+	exitCode := ExitSuccess
+	guestIDs := make(map[string]bool, len(result.Guests))
+	for _, g := range result.Guests {
+		guestIDs[g.UserID] = true
+	}
+
+	admins := make(map[string][]*model.User) // channel ID → admins
+	byOwner := make(map[string]*OwnerRequest)
+	for _, g := range result.Guests {
+		if !notifiable(g) {
+			continue
+		}
+		guest := make(map[string]*OwnerGuest) // owner ID → this guest in their batch
+		for _, ch := range g.Channels {
+			if ch.Type == ChannelTypeDirect || ch.Type == ChannelTypeGroup || ch.Archived || ch.ID == "" {
+				continue
+			}
+			owners, ok := admins[ch.ID]
+			if !ok {
+				op := fmt.Sprintf("listing the admins of %s/%s", ch.TeamName, ch.ChannelName)
+				var users []*model.User
+				err := retry.Do(op, verbose, func() (err error) {
+					users, err = client.GetChannelAdmins(ch.ID)
+					return err
+				})
+				if err != nil {
+					logger.Printf("Warning: %s failed: %v", op, err)
+					exitCode = ExitPartialFailure
+				}
+				for _, u := range users {
+					if !guestIDs[u.Id] {
+						owners = append(owners, u)
+					}
+				}
+				admins[ch.ID] = owners
+			}
+			for _, o := range owners {
+				if guest[o.Id] == nil {
+					guest[o.Id] = &OwnerGuest{Username: g.Username, DisplayName: g.DisplayName, Email: g.Email, LastLogin: g.LastLogin}
+				}
+				guest[o.Id].Channels = append(guest[o.Id].Channels, ch.TeamName+"/"+ch.ChannelName)
+				if byOwner[o.Id] == nil {
+					byOwner[o.Id] = &OwnerRequest{OwnerID: o.Id, Owner: o.Username}
+				}
+			}
+		}
+		if len(guest) == 0 {
+			s.Unowned++
+			continue
+		}
+		s.Guests++
+		for id, og := range guest {
+			byOwner[id].Guests = append(byOwner[id].Guests, *og)
+		}
+	}
+
+	requests := make([]OwnerRequest, 0, len(byOwner))
+	for _, r := range byOwner {
+		slices.SortFunc(r.Guests, func(a, b OwnerGuest) int { return cmp.Compare(a.Username, b.Username) })
+		requests = append(requests, *r)
+	}
+	slices.SortFunc(requests, func(a, b OwnerRequest) int { return cmp.Compare(a.Owner, b.Owner) })
+	return requests, s, exitCode
+}
+
+// OwnerMessages renders an owner's batch as one or more direct messages,
+// each a table of guests under the same request, split so none is longer
+// than maxPostLength.
+func OwnerMessages(r OwnerRequest, inactiveDays int) []string {
+	intro := fmt.Sprintf("**Guest access review**\n\nThese guests have not been active for %d days and are in channels you administer. Please confirm whether each still needs access, or remove them from your channels.\n\n| Guest | Email | Last login | Your channels |\n|---|---|---|---|\n", inactiveDays)
+	var messages []string
+	var b strings.Builder
+	for _, g := range r.Guests {
+		name := "@" + g.Username
+		if g.DisplayName != "" {
+			name += " (" + g.DisplayName + ")"
+		}
+		row := fmt.Sprintf("| %s | %s | %s | %s |\n", escapeTableCell(name), escapeTableCell(g.Email), FormatTimeDisplay(g.LastLogin), escapeTableCell(strings.Join(g.Channels, ", ")))
+		if b.Len() > 0 && b.Len()+len(row) > maxPostLength {
+			messages = append(messages, b.String())
+			b.Reset()
+		}
+		if b.Len() == 0 {
+			b.WriteString(intro)
+		}
+		b.WriteString(row)
+	}
+	if b.Len() > 0 {
+		messages = append(messages, b.String())
+	}
+	return messages
+}
+
+// escapeTableCell keeps a value from breaking a Markdown table row.
+func escapeTableCell(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "|", `\|`), "\n", " ")
+}
+
+// SendOwnerRequests sends each owner their batch from client, which should
+// be authenticated as a bot. A failed message is reported and the rest
+// carry on; the exit code is ExitPartialFailure if any failed.
+func SendOwnerRequests(client MattermostClient, requests []OwnerRequest, inactiveDays int, s *OwnerSummary, retry RetryPolicy, verbose bool) int {
+	exitCode := ExitSuccess
+	for _, r := range requests {
+		op := fmt.Sprintf("messaging owner %q", r.Owner)
+		var err error
+		for _, text := range OwnerMessages(r, inactiveDays) {
+			if err = retry.Do(op, verbose, func() error { return client.SendDirectMessage(r.OwnerID, text) }); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			logger.Printf("Warning: %s failed: %v", op, err)
+			s.Failed++
+			exitCode = ExitPartialFailure
+			continue
+		}
+		s.Sent++
+	}
+	return exitCode
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func ownersResult() *AuditResult {
+	partners := ChannelInfo{ID: "ch1", TeamName: "Engineering", ChannelName: "Partners", Type: ChannelTypePrivate}
+	deals := ChannelInfo{ID: "ch2", TeamName: "Sales", ChannelName: "Deals", Type: ChannelTypePublic}
+	dm := ChannelInfo{ID: "dm1", ChannelName: "dm", Type: ChannelTypeDirect}
+	return &AuditResult{Guests: []GuestRecord{
+		{UserID: "user0", Username: "bob", Active: true, Inactive: true, Channels: []ChannelInfo{partners, deals, dm}},
+		{UserID: "user1", Username: "ann", DisplayName: "Ann | Partner", Active: true, Inactive: true, Channels: []ChannelInfo{partners}},
+		{UserID: "user2", Username: "lonely", Active: true, Inactive: true, Channels: []ChannelInfo{dm}},
+		{UserID: "user3", Username: "active", Active: true, Channels: []ChannelInfo{partners}},
+	}}
+}
+
+func TestPlanOwnerRequests(t *testing.T) {
+	client := &mockClient{channelAdmins: map[string][]*model.User{
+		"ch1": {{Id: "admin1", Username: "carol"}, {Id: "user3", Username: "active"}},
+		"ch2": {{Id: "admin1", Username: "carol"}, {Id: "admin2", Username: "dave"}},
+	}}
+	requests, s, exitCode := PlanOwnerRequests(client, ownersResult(), RetryPolicy{}, false)
+	if exitCode != ExitSuccess {
+		t.Errorf("exit code = %d", exitCode)
+	}
+	if client.adminCalls != 2 {
+		t.Errorf("admins looked up %d times, want once per channel", client.adminCalls)
+	}
+	if s != (OwnerSummary{Guests: 2, Unowned: 1}) {
+		t.Errorf("summary = %+v", s)
+	}
+	// One batch per owner; the guest who is a channel admin is not asked
+	if len(requests) != 2 || requests[0].Owner != "carol" || requests[1].Owner != "dave" {
+		t.Fatalf("unexpected requests: %+v", requests)
+	}
+	carol := requests[0].Guests
+	if len(carol) != 2 || carol[0].Username != "ann" || carol[1].Username != "bob" || strings.Join(carol[1].Channels, ",") != "Engineering/Partners,Sales/Deals" {
+		t.Errorf("carol's batch: %+v", carol)
+	}
+	if dave := requests[1].Guests; len(dave) != 1 || dave[0].Username != "bob" || len(dave[0].Channels) != 1 {
+		t.Errorf("dave's batch: %+v", dave)
+	}
+
+	// A channel whose admins cannot be read is left out
+	client = &mockClient{channelAdminsErr: map[string]error{"ch1": &APIError{StatusCode: 403, Message: "forbidden"}}}
+	if _, s, exitCode := PlanOwnerRequests(client, ownersResult(), RetryPolicy{}, false); exitCode != ExitPartialFailure || s.Unowned != 3 {
+		t.Errorf("failed lookup: exit code %d, summary %+v", exitCode, s)
+	}
+}
+
+func TestSendOwnerRequests(t *testing.T) {
+	requests := []OwnerRequest{
+		{OwnerID: "admin1", Owner: "carol", Guests: []OwnerGuest{{Username: "ann", DisplayName: "Ann | Partner", Channels: []string{"Engineering/Partners"}}}},
+		{OwnerID: "admin2", Owner: "dave", Guests: []OwnerGuest{{Username: "bob"}}},
+	}
+	client := &mockClient{messageErr: map[string]error{"admin2": &APIError{StatusCode: 403, Message: "forbidden"}}}
+	var s OwnerSummary
+	if exitCode := SendOwnerRequests(client, requests, 90, &s, RetryPolicy{}, false); exitCode != ExitPartialFailure {
+		t.Errorf("exit code = %d", exitCode)
+	}
+	if s.Sent != 1 || s.Failed != 1 || len(client.messages) != 1 {
+		t.Fatalf("summary %+v, messages %q", s, client.messages)
+	}
+	msg := client.messages[0]
+	if !strings.HasPrefix(msg, "admin1:**Guest access review**") || !strings.Contains(msg, "not been active for 90 days") || !strings.Contains(msg, `| @ann (Ann \| Partner) |  | Never | Engineering/Partners |`) {
+		t.Errorf("unexpected message:\n%s", msg)
+	}
+}
+
+func TestOwnerMessages_Split(t *testing.T) {
+	r := OwnerRequest{Owner: "carol"}
+	for range 400 {
+		r.Guests = append(r.Guests, OwnerGuest{Username: "guest", Email: strings.Repeat("x", 60) + "@partner.com"})
+	}
+	messages := OwnerMessages(r, 90)
+	if len(messages) < 2 {
+		t.Fatalf("expected the batch to be split, got %d message(s)", len(messages))
+	}
+	rows := 0
+	for _, m := range messages {
+		if len(m) > maxPostLength+len("| @guest |") || !strings.HasPrefix(m, "**Guest access review**") {
+			t.Errorf("message of %d bytes: %.60q", len(m), m)
+		}
+		rows += strings.Count(m, "| @guest |")
+	}
+	if rows != 400 {
+		t.Errorf("%d rows across the messages, want 400", rows)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "fmt"
+
+// ExitPolicy turns audit findings into a failing exit code, so a scheduled
+// pipeline goes red when guest hygiene regresses.
+type ExitPolicy struct {
+	FailOnInactive   bool
+	FailOnViolations bool
+	// Threshold is how many findings are tolerated before the run fails.
+	Threshold int
+}
+
+// Enabled reports whether any finding can fail the run.
+func (p ExitPolicy) Enabled() bool {
+	return p.FailOnInactive || p.FailOnViolations
+}
+
+// Violations counts the guest hygiene violations in a summary: guests
+// holding elevated roles, possibly shared accounts, accounts older than the
+// maximum guest age, passwords older than the maximum password age, and
+// members who should be guests. Each is only found when its check is
+// enabled.
+func Violations(s AuditSummary) int {
+	return s.ElevatedRoleGuests + s.SharedAccountGuests + s.ExpiredGuests + s.ExpiredPasswords + s.MembersShouldBeGuests
+}
+
+// Check returns ExitPolicyViolations or ExitInactiveGuests, with a message
+// for stderr, when the summary has more findings of an enabled kind than
+// Threshold. Violations are checked first. Otherwise it returns ExitSuccess.
+func (p ExitPolicy) Check(s AuditSummary) (int, string) {
+	if n := Violations(s); p.FailOnViolations && n > p.Threshold {
+		return ExitPolicyViolations, fmt.Sprintf("%d guest hygiene violation(s) found (%d with elevated roles, %d possibly shared, %d expired, %d with expired passwords, %d member(s) that should be guests), more than the %d allowed", n, s.ElevatedRoleGuests, s.SharedAccountGuests, s.ExpiredGuests, s.ExpiredPasswords, s.MembersShouldBeGuests, p.Threshold)
+	}
+	if n := s.InactiveGuests; p.FailOnInactive && n > p.Threshold {
+		return ExitInactiveGuests, fmt.Sprintf("%d inactive guest(s) found, more than the %d allowed", n, p.Threshold)
+	}
+	return ExitSuccess, ""
+}
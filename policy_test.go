@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExitPolicyCheck(t *testing.T) {
+	summary := AuditSummary{InactiveGuests: 3, ElevatedRoleGuests: 1, ExpiredGuests: 1}
+	tests := []struct {
+		name   string
+		policy ExitPolicy
+		want   int
+	}{
+		{"disabled", ExitPolicy{}, ExitSuccess},
+		{"inactive", ExitPolicy{FailOnInactive: true}, ExitInactiveGuests},
+		{"inactive within threshold", ExitPolicy{FailOnInactive: true, Threshold: 3}, ExitSuccess},
+		{"violations", ExitPolicy{FailOnViolations: true}, ExitPolicyViolations},
+		{"violations within threshold", ExitPolicy{FailOnViolations: true, Threshold: 2}, ExitSuccess},
+		{"violations before inactive", ExitPolicy{FailOnInactive: true, FailOnViolations: true}, ExitPolicyViolations},
+		{"inactive over shared threshold", ExitPolicy{FailOnInactive: true, FailOnViolations: true, Threshold: 2}, ExitInactiveGuests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, msg := tt.policy.Check(summary)
+			if code != tt.want {
+				t.Errorf("Check = %d (%q), want %d", code, msg, tt.want)
+			}
+			if (msg == "") != (code == ExitSuccess) {
+				t.Errorf("message %q with exit code %d", msg, code)
+			}
+		})
+	}
+
+	_, msg := ExitPolicy{FailOnViolations: true}.Check(summary)
+	if !strings.Contains(msg, "2 guest hygiene violation(s) found (1 with elevated roles, 0 possibly shared, 1 expired, 0 with expired passwords, 0 member(s) that should be guests)") {
+		t.Errorf("message = %q", msg)
+	}
+	if n := Violations(AuditSummary{ExpiredPasswords: 2, SharedAccountGuests: 1}); n != 3 {
+		t.Errorf("Violations = %d, want 3", n)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ProfileField is a custom profile attribute defined on the server, such as
+// Department or Company.
+type ProfileField struct {
+	ID   string
+	Name string
+	// Options maps the option IDs of a select or multiselect field to their
+	// names; values of those fields hold option IDs.
+	Options map[string]string
+}
+
+// csvProfilePrefix starts the CSV column of each --profile-fields field.
+const csvProfilePrefix = "profile_"
+
+// ParseProfileFields parses the comma-separated --profile-fields value.
+// Names are matched against the server's fields case-insensitively, so they
+// are only trimmed here.
+func ParseProfileFields(s string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(n, name) }) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("error: --profile-fields needs at least one field name, e.g. department,company")
+	}
+	return names, nil
+}
+
+// SelectProfileFields returns the server's fields named in names, in the
+// order given. A name the server does not define is an error listing those
+// it does, since a typo would otherwise leave the column silently empty.
+func SelectProfileFields(fields []ProfileField, names []string) ([]ProfileField, error) {
+	selected := make([]ProfileField, 0, len(names))
+	for _, name := range names {
+		i := slices.IndexFunc(fields, func(f ProfileField) bool { return strings.EqualFold(f.Name, name) })
+		if i < 0 {
+			available := make([]string, len(fields))
+			for j, f := range fields {
+				available[j] = f.Name
+			}
+			if len(available) == 0 {
+				return nil, fmt.Errorf("error: unknown profile field %q. The server defines no custom profile attributes", name)
+			}
+			return nil, fmt.Errorf("error: unknown profile field %q. Use one or more of: %s", name, strings.Join(available, ", "))
+		}
+		selected = append(selected, fields[i])
+	}
+	return selected, nil
+}
+
+// ProfileFieldValue renders a guest's raw attribute value for the report:
+// text as is, and select and multiselect options by name, several joined
+// with ", ". An option the field no longer defines keeps its ID.
+func ProfileFieldValue(field ProfileField, raw json.RawMessage) string {
+	var values []string
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		values = []string{s}
+	} else if err := json.Unmarshal(raw, &values); err != nil {
+		return strings.TrimSpace(string(raw))
+	}
+	for i, v := range values {
+		if name, ok := field.Options[v]; ok {
+			values[i] = name
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+// profileValues returns the selected fields' values for one guest, keyed by
+// field name. Fields the guest has not filled in are empty.
+func profileValues(fields []ProfileField, attrs map[string]json.RawMessage) map[string]string {
+	values := make(map[string]string, len(fields))
+	for _, f := range fields {
+		if raw, ok := attrs[f.ID]; ok {
+			values[f.Name] = ProfileFieldValue(f, raw)
+		} else {
+			values[f.Name] = ""
+		}
+	}
+	return values
+}
+
+// profileColumn is the CSV column of a profile field: Cost Centre becomes
+// profile_cost_centre.
+func profileColumn(name string) string {
+	return csvProfilePrefix + strings.Join(strings.Fields(strings.ToLower(name)), "_")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseProfileFields(t *testing.T) {
+	names, err := ParseProfileFields(" department, Company,,company ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names, []string{"department", "Company"}) {
+		t.Errorf("got %v", names)
+	}
+	if _, err := ParseProfileFields(" , "); err == nil {
+		t.Error("expected an error for no field names")
+	}
+}
+
+func TestSelectProfileFields(t *testing.T) {
+	fields := []ProfileField{{ID: "f1", Name: "Department"}, {ID: "f2", Name: "Company"}}
+	selected, err := SelectProfileFields(fields, []string{"company", "DEPARTMENT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 2 || selected[0].ID != "f2" || selected[1].ID != "f1" {
+		t.Errorf("got %+v", selected)
+	}
+	_, err = SelectProfileFields(fields, []string{"employer"})
+	if err == nil || !strings.Contains(err.Error(), "Use one or more of: Department, Company") {
+		t.Errorf("unknown field: got %v", err)
+	}
+	_, err = SelectProfileFields(nil, []string{"employer"})
+	if err == nil || !strings.Contains(err.Error(), "defines no custom profile attributes") {
+		t.Errorf("no fields: got %v", err)
+	}
+}
+
+func TestProfileFieldValue(t *testing.T) {
+	field := ProfileField{Options: map[string]string{"o1": "Acme Ltd", "o2": "Globex"}}
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{`"Delivery"`, "Delivery"},
+		{`"o1"`, "Acme Ltd"},
+		{`["o1", "o2"]`, "Acme Ltd, Globex"},
+		{`["o1", "gone"]`, "Acme Ltd, gone"}, // a removed option keeps its ID
+		{`null`, ""},
+		{`42`, "42"},
+	}
+	for _, tt := range tests {
+		if got := ProfileFieldValue(field, json.RawMessage(tt.raw)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestProfileColumn(t *testing.T) {
+	for name, want := range map[string]string{"Department": "profile_department", " Cost  Centre ": "profile_cost_centre"} {
+		if got := profileColumn(name); got != want {
+			t.Errorf("profileColumn(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Progress reports phase-level progress (listing, enrichment, output) to
+// stderr. A nil *Progress is valid and reports nothing, so callers never
+// need to check whether progress is enabled.
+type Progress struct {
+	w        io.Writer
+	tty      bool
+	interval time.Duration
+
+	phase   string
+	total   int
+	done    int
+	started time.Time
+	last    time.Time
+}
+
+// NewProgress returns a reporter writing to w. When tty is true, updates
+// overwrite a single line; otherwise a line is printed at most once per interval.
+func NewProgress(w io.Writer, tty bool) *Progress {
+	return &Progress{w: w, tty: tty, interval: 5 * time.Second}
+}
+
+// Start begins a new phase. A total of zero or less means the total is unknown.
+func (p *Progress) Start(phase string, total int) {
+	if p == nil {
+		return
+	}
+	p.phase = phase
+	p.total = total
+	p.done = 0
+	p.started = time.Now()
+	p.last = time.Time{}
+	p.print()
+}
+
+// Update records the number of items completed in the current phase.
+func (p *Progress) Update(done int) {
+	if p == nil {
+		return
+	}
+	p.done = done
+	if !p.tty && time.Since(p.last) < p.interval {
+		return
+	}
+	p.print()
+}
+
+// Finish ends the current phase and reports its duration.
+func (p *Progress) Finish() {
+	if p == nil {
+		return
+	}
+	if p.tty {
+		fmt.Fprint(p.w, "\r\033[K")
+	}
+	fmt.Fprintf(p.w, "%s: done (%d in %s)\n", p.phase, p.done, time.Since(p.started).Round(time.Millisecond))
+}
+
+func (p *Progress) print() {
+	p.last = time.Now()
+	line := fmt.Sprintf("%s: %d", p.phase, p.done)
+	if p.total > 0 {
+		pct := p.done * 100 / p.total
+		if pct > 100 {
+			pct = 100 // the up-front total is an estimate
+		}
+		line = fmt.Sprintf("%s: %d/%d (%d%%)", p.phase, p.done, p.total, pct)
+	}
+	if p.tty {
+		fmt.Fprintf(p.w, "\r\033[K%s", line)
+		return
+	}
+	fmt.Fprintln(p.w, line)
+}
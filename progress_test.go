@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProgress_NonTTY(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProgress(&buf, false)
+
+	p.Start("Listing guests", 400)
+	p.Update(200) // suppressed: within the print interval
+	p.Update(400)
+	p.Finish()
+
+	output := buf.String()
+	if !strings.Contains(output, "Listing guests: 0/400 (0%)") {
+		t.Errorf("expected initial progress line, got:\n%s", output)
+	}
+	if strings.Contains(output, "200/400") {
+		t.Errorf("expected intermediate update to be throttled, got:\n%s", output)
+	}
+	if !strings.Contains(output, "Listing guests: done (400 in") {
+		t.Errorf("expected completion line, got:\n%s", output)
+	}
+}
+
+func TestProgress_UnknownTotal(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProgress(&buf, true)
+
+	p.Start("Listing guests", 0)
+	p.Update(200)
+
+	if !strings.Contains(buf.String(), "Listing guests: 200") {
+		t.Errorf("expected count without total, got %q", buf.String())
+	}
+	if strings.Contains(buf.String(), "%") {
+		t.Errorf("expected no percentage for unknown total, got %q", buf.String())
+	}
+}
+
+func TestProgress_Nil(t *testing.T) {
+	var p *Progress
+	// Must not panic
+	p.Start("Listing guests", 10)
+	p.Update(5)
+	p.Finish()
+}
+
+func TestRunAudit_Progress(t *testing.T) {
+	var buf bytes.Buffer
+	client := &mockClient{guests: sampleGuests(3)}
+
+	RunAudit(client, AuditOptions{Progress: NewProgress(&buf, false)})
+
+	output := buf.String()
+	for _, want := range []string{"Listing guests: 0/3", "Listing guests: done (3", "Enriching guests: 0/3", "Enriching guests: done (3"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in progress output, got:\n%s", want, output)
+		}
+	}
+}
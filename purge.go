@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Status of a GuestPurge.
+const (
+	PurgePlanned = "planned" // --dry-run: would be deleted
+	PurgeDeleted = "deleted"
+	PurgeFailed  = "failed"
+	PurgeSkipped = "skipped" // not deleted, see Reason
+)
+
+// purgeConfirmation is what must be typed to go ahead with --purge.
+const purgeConfirmation = "purge"
+
+// GuestPurge is one deactivated guest account that --purge permanently
+// deletes, or would delete with --dry-run.
+type GuestPurge struct {
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	DeactivatedAt string `json:"deactivated_at"` // ISO 8601
+	Status        string `json:"status"`
+	Reason        string `json:"reason,omitempty"` // why the deletion was skipped or failed, or that the account was already gone
+
+	userID string
+}
+
+// PurgeSummary counts purges by status.
+type PurgeSummary struct {
+	Planned int `json:"planned"`
+	Deleted int `json:"deleted"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+}
+
+// PlanPurges lists the purge candidates: guests deactivated for longer than
+// --deactivated-older-than. Allowlisted guests are listed as skipped, so an
+// exception also protects a deactivated account. Full members listed by
+// --include-members-with-domain are never planned.
+func PlanPurges(result *AuditResult) []GuestPurge {
+	var plans []GuestPurge
+	for _, g := range result.Guests {
+		if g.Failed || !g.PurgeCandidate || g.ShouldBeGuest {
+			continue
+		}
+		p := GuestPurge{
+			Username:      g.Username,
+			Email:         g.Email,
+			DeactivatedAt: FormatTimeISO(g.DeactivatedAt),
+			Status:        PurgePlanned,
+			userID:        g.UserID,
+		}
+		if g.Excepted {
+			p.Status = PurgeSkipped
+			p.Reason = "allowlist exception"
+		}
+		plans = append(plans, p)
+	}
+	return plans
+}
+
+// ConfirmPurge asks on w for the purge of n accounts to be confirmed by
+// typing purgeConfirmation, and reads the answer from r; anything else,
+// including no input, declines. A scheduled run has nobody to type it and
+// passes --yes instead (see Confirmation).
+func ConfirmPurge(r io.Reader, w io.Writer, n, days int) bool {
+	fmt.Fprintf(w, "About to permanently delete %d guest account(s) deactivated more than %d day(s) ago, with all their posts and files.\n", n, days)
+	fmt.Fprintf(w, "This cannot be undone; run with --dry-run first to list them.\n")
+	fmt.Fprintf(w, "Type %q to continue: ", purgeConfirmation)
+	line, _ := bufio.NewReader(r).ReadString('\n')
+	fmt.Fprintln(w)
+	return strings.TrimSpace(line) == purgeConfirmation
+}
+
+// ApplyPurges permanently deletes each planned account. A failed deletion is
+// recorded and the rest carry on, unless the server refuses deletion
+// altogether (403 or 501, typically EnableAPIUserDeletion being off), in
+// which case the remaining accounts fail with the same reason. An account
+// the server no longer has (404) is counted as deleted: a retried deletion
+// that had already gone through, or a concurrent one, ends the same way. The
+// exit code is ExitPartialFailure if any failed.
+func ApplyPurges(client MattermostClient, purges []GuestPurge, retry RetryPolicy, verbose bool) int {
+	exitCode := ExitSuccess
+	var refused error
+	for i := range purges {
+		p := &purges[i]
+		if p.Status != PurgePlanned {
+			continue
+		}
+		op := fmt.Sprintf("permanently deleting %q", p.Username)
+		err := refused
+		if err == nil {
+			err = retry.Do(op, verbose, func() error {
+				return client.PermanentDeleteUser(p.userID)
+			})
+		}
+		if err != nil && refused == nil && IsNotFound(err) {
+			p.Status = PurgeDeleted
+			p.Reason = "already deleted"
+			if verbose {
+				logger.Printf("%q was already deleted", p.Username)
+			}
+			continue
+		}
+		if err != nil {
+			p.Status = PurgeFailed
+			p.Reason = err.Error()
+			exitCode = ExitPartialFailure
+			switch {
+			case refused != nil:
+				// Not attempted; reported once below
+			case IsUnsupported(err): // 403 or 501, as 404 is handled above
+				refused = err
+				logger.Printf("Warning: no further deletions were attempted; permanent deletion through the API needs a system admin token and ServiceSettings.EnableAPIUserDeletion. The server refused to delete %q: %v", p.Username, err)
+			case verbose:
+				logger.Printf("Warning: %s failed: %v", op, err)
+			}
+			continue
+		}
+		p.Status = PurgeDeleted
+		if verbose {
+			logger.Printf("Permanently deleted %q", p.Username)
+		}
+	}
+	return exitCode
+}
+
+// SummarizePurges counts purges by status.
+func SummarizePurges(purges []GuestPurge) PurgeSummary {
+	var s PurgeSummary
+	for _, p := range purges {
+		switch p.Status {
+		case PurgePlanned:
+			s.Planned++
+		case PurgeDeleted:
+			s.Deleted++
+		case PurgeFailed:
+			s.Failed++
+		case PurgeSkipped:
+			s.Skipped++
+		}
+	}
+	return s
+}
+
+// WritePurges writes the purge plan or outcome in the given format, with the
+// usual stdout fallback when the output file cannot be written.
+func WritePurges(purges []GuestPurge, dryRun bool, op Operator, format, outputPath string) error {
+	w, closeOutput := openOutput(outputPath)
+	defer closeOutput()
+
+	switch format {
+	case "csv":
+		return writePurgesCSV(w, purges, op)
+	case "json":
+		return writePurgesJSON(w, purges, dryRun, op)
+	default:
+		return writePurgesTable(w, purges, dryRun, op)
+	}
+}
+
+func writePurgesTable(w io.Writer, purges []GuestPurge, dryRun bool, op Operator) error {
+	if dryRun {
+		fmt.Fprintln(w, "⚠  DRY RUN — no changes have been made to your Mattermost instance.")
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Operator: %s\n\n", op)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "USERNAME\tEMAIL\tDEACTIVATED\tSTATUS\tREASON")
+	for _, p := range purges {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", p.Username, p.Email, p.DeactivatedAt, p.Status, p.Reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	s := SummarizePurges(purges)
+	fmt.Fprintln(w)
+	var err error
+	if dryRun {
+		_, err = fmt.Fprintf(w, "%d account(s) would be permanently deleted, %d skipped\n", s.Planned, s.Skipped)
+	} else {
+		_, err = fmt.Fprintf(w, "%d account(s) permanently deleted, %d failed, %d skipped\n", s.Deleted, s.Failed, s.Skipped)
+	}
+	return err
+}
+
+func writePurgesCSV(w io.Writer, purges []GuestPurge, op Operator) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	if err := cw.Write([]string{"username", "email", "deactivated_at", "status", "reason", "operator_id", "operator"}); err != nil {
+		return err
+	}
+	for _, p := range purges {
+		if err := cw.Write([]string{p.Username, p.Email, p.DeactivatedAt, p.Status, p.Reason, op.UserID, op.Username}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writePurgesJSON(w io.Writer, purges []GuestPurge, dryRun bool, op Operator) error {
+	if purges == nil {
+		purges = []GuestPurge{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		DryRun   bool         `json:"dry_run"`
+		Operator Operator     `json:"operator"`
+		Summary  PurgeSummary `json:"summary"`
+		Purges   []GuestPurge `json:"purges"`
+	}{dryRun, op, SummarizePurges(purges), purges})
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsPurgeCandidate(t *testing.T) {
+	now := time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(d int) *time.Time {
+		t := now.AddDate(0, 0, -d)
+		return &t
+	}
+	tests := []struct {
+		name          string
+		deactivatedAt *time.Time
+		days          int
+		want          bool
+	}{
+		{"long deactivated", daysAgo(200), 180, true},
+		{"recently deactivated", daysAgo(30), 180, false},
+		{"active", nil, 180, false},
+		{"check off", daysAgo(200), 0, false},
+	}
+	for _, tt := range tests {
+		if got := IsPurgeCandidate(tt.deactivatedAt, tt.days, now); got != tt.want {
+			t.Errorf("%s: IsPurgeCandidate = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRunAudit_DeactivatedOlderThan(t *testing.T) {
+	guests := sampleGuests(3)
+	guests[1].DeleteAt = time.Now().AddDate(0, 0, -200).UnixMilli()
+	guests[2].DeleteAt = time.Now().AddDate(0, 0, -10).UnixMilli()
+	client := &mockClient{guests: guests}
+
+	result, exitCode := RunAudit(client, AuditOptions{DeactivatedDays: 180})
+	if exitCode != ExitSuccess {
+		t.Fatalf("exit code = %d, want %d", exitCode, ExitSuccess)
+	}
+	for i, want := range []bool{false, true, false} {
+		if g := result.Guests[i]; g.PurgeCandidate != want {
+			t.Errorf("%s: purge_candidate = %v, want %v", g.Username, g.PurgeCandidate, want)
+		}
+	}
+	if g := result.Guests[2]; g.DeactivatedAt == nil || g.Active {
+		t.Errorf("%s: deactivated_at = %v, active = %v; want the deactivation time", g.Username, g.DeactivatedAt, g.Active)
+	}
+	if result.Guests[0].DeactivatedAt != nil {
+		t.Errorf("active guest has deactivated_at %v", result.Guests[0].DeactivatedAt)
+	}
+	if result.Summary.PurgeCandidates != 1 || result.Summary.DeactivatedGuests != 2 {
+		t.Errorf("summary = %d purge candidate(s), %d deactivated; want 1 and 2", result.Summary.PurgeCandidates, result.Summary.DeactivatedGuests)
+	}
+}
+
+func purgeResult() *AuditResult {
+	deactivated := time.Date(2024, 11, 2, 9, 30, 0, 0, time.UTC)
+	return &AuditResult{Guests: []GuestRecord{
+		{Username: "old", Email: "old@example.com", UserID: "user0", DeactivatedAt: &deactivated, PurgeCandidate: true},
+		{Username: "recent", UserID: "user1", DeactivatedAt: &deactivated},
+		{Username: "excepted", UserID: "user2", DeactivatedAt: &deactivated, PurgeCandidate: true, Excepted: true},
+		{Username: "member", UserID: "user3", DeactivatedAt: &deactivated, PurgeCandidate: true, ShouldBeGuest: true},
+		{Username: "other", UserID: "user4", DeactivatedAt: &deactivated, PurgeCandidate: true},
+		{Username: "failed", Failed: true, PurgeCandidate: true},
+	}}
+}
+
+func TestPlanPurges(t *testing.T) {
+	plans := PlanPurges(purgeResult())
+	if len(plans) != 3 {
+		t.Fatalf("expected 3 purges, got %d: %+v", len(plans), plans)
+	}
+	if p := plans[0]; p.Username != "old" || p.Status != PurgePlanned || p.userID != "user0" || p.DeactivatedAt != "2024-11-02T09:30:00Z" {
+		t.Errorf("unexpected purge: %+v", p)
+	}
+	if p := plans[1]; p.Username != "excepted" || p.Status != PurgeSkipped || p.Reason != "allowlist exception" {
+		t.Errorf("excepted guest should be skipped: %+v", p)
+	}
+}
+
+func TestApplyPurges(t *testing.T) {
+	client := &mockClient{deleteErr: map[string]error{"user4": &APIError{StatusCode: 400, Message: "bad request"}}}
+	purges := PlanPurges(purgeResult())
+	if exitCode := ApplyPurges(client, purges, RetryPolicy{}, false); exitCode != ExitPartialFailure {
+		t.Errorf("exit code = %d, want %d", exitCode, ExitPartialFailure)
+	}
+	if len(client.deleted) != 1 || client.deleted[0] != "user0" {
+		t.Errorf("deleted = %v, want [user0]", client.deleted)
+	}
+	want := PurgeSummary{Deleted: 1, Failed: 1, Skipped: 1}
+	if got := SummarizePurges(purges); got != want {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+}
+
+func TestApplyPurges_DeletionDisabled(t *testing.T) {
+	// With EnableAPIUserDeletion off every deletion fails the same way, so
+	// only the first is attempted
+	refused := &APIError{StatusCode: 501, Message: "Permanent user deletion feature is not enabled."}
+	client := &mockClient{deleteErr: map[string]error{"user0": refused, "user4": refused}}
+
+	purges := PlanPurges(purgeResult())
+	ApplyPurges(client, purges, RetryPolicy{}, false)
+	if client.deleteCalls != 1 {
+		t.Errorf("PermanentDeleteUser called %d times, want 1", client.deleteCalls)
+	}
+	if s := SummarizePurges(purges); s.Failed != 2 {
+		t.Errorf("failed = %d, want 2", s.Failed)
+	}
+	if !strings.Contains(purges[2].Reason, "not enabled") {
+		t.Errorf("unattempted purge reason = %q, want the server's refusal", purges[2].Reason)
+	}
+}
+
+func TestApplyPurges_AlreadyDeleted(t *testing.T) {
+	// A 404 is one account gone, not deletion being disabled, so the rest
+	// are still deleted
+	client := &mockClient{deleteErr: map[string]error{"user0": &APIError{StatusCode: 404, Message: "not found"}}}
+
+	purges := PlanPurges(purgeResult())
+	if exitCode := ApplyPurges(client, purges, RetryPolicy{}, false); exitCode != ExitSuccess {
+		t.Errorf("exit code = %d, want %d", exitCode, ExitSuccess)
+	}
+	if len(client.deleted) != 1 || client.deleted[0] != "user4" {
+		t.Errorf("deleted = %v, want [user4]", client.deleted)
+	}
+	if p := purges[0]; p.Status != PurgeDeleted || p.Reason != "already deleted" {
+		t.Errorf("missing account should count as deleted: %+v", p)
+	}
+	want := PurgeSummary{Deleted: 2, Skipped: 1}
+	if got := SummarizePurges(purges); got != want {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+}
+
+func TestConfirmPurge(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"purge\n", true},
+		{"  purge  \n", true},
+		{"purge", true}, // piped without a newline
+		{"yes\n", false},
+		{"PURGE\n", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		var prompt bytes.Buffer
+		if got := ConfirmPurge(strings.NewReader(tt.input), &prompt, 2, 180); got != tt.want {
+			t.Errorf("ConfirmPurge(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+		if !strings.Contains(prompt.String(), "permanently delete 2 guest account(s) deactivated more than 180 day(s) ago") {
+			t.Errorf("prompt = %q", prompt.String())
+		}
+	}
+}
+
+func TestWritePurges(t *testing.T) {
+	purges := PlanPurges(purgeResult())
+
+	var buf bytes.Buffer
+	if err := writePurgesTable(&buf, purges, true, testOperator); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "⚠  DRY RUN — no changes have been made to your Mattermost instance.") {
+		t.Errorf("dry run table missing the banner:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "2 account(s) would be permanently deleted, 1 skipped") {
+		t.Errorf("dry run table missing the summary:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := writePurgesCSV(&buf, purges[:1], testOperator); err != nil {
+		t.Fatal(err)
+	}
+	want := "username,email,deactivated_at,status,reason,operator_id,operator\nold,old@example.com,2024-11-02T09:30:00Z,planned,,admin1,admin\n"
+	if buf.String() != want {
+		t.Errorf("CSV =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := writePurgesJSON(&buf, nil, true, testOperator); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		DryRun   bool         `json:"dry_run"`
+		Operator Operator     `json:"operator"`
+		Purges   []GuestPurge `json:"purges"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if !out.DryRun || out.Operator != testOperator || out.Purges == nil || !strings.Contains(buf.String(), `"purges": []`) {
+		t.Errorf("JSON = %s, want dry_run true and an empty purges list", buf.String())
+	}
+}
+
+func TestRunOffline_DeactivatedOlderThan(t *testing.T) {
+	deactivated := time.Now().AddDate(0, 0, -400)
+	snapshot := &AuditResult{Guests: []GuestRecord{
+		{Username: "gone", DeactivatedAt: &deactivated},
+		{Username: "here", Active: true},
+	}}
+	result, _ := RunOffline(snapshot, AuditOptions{DeactivatedDays: 365})
+	if !result.Guests[0].PurgeCandidate || result.Guests[1].PurgeCandidate {
+		t.Errorf("purge candidates = %v, %v; want true, false", result.Guests[0].PurgeCandidate, result.Guests[1].PurgeCandidate)
+	}
+	if result.Summary.PurgeCandidates != 1 {
+		t.Errorf("purge_candidates = %d, want 1", result.Summary.PurgeCandidates)
+	}
+}
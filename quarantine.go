@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Changes made by --quarantine-team and reversed by its undo.
+const (
+	QuarantineJoin   = "join team"
+	QuarantineLeave  = "leave team"
+	QuarantineRejoin = "add to channel" // undo only: a channel the guest left with its team
+	QuarantineDone   = "done"
+)
+
+// QuarantineChange is one team or channel membership change that
+// --quarantine-team makes, or its undo. Planned, failed and skipped changes
+// use the RemovalPlanned, RemovalFailed and RemovalSkipped statuses.
+type QuarantineChange struct {
+	Username string `json:"username"`
+	Change   string `json:"change"`
+	Team     string `json:"team"`
+	Channel  string `json:"channel,omitempty"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"` // why the change was skipped or failed
+
+	userID    string
+	teamID    string
+	channelID string
+	// channels are the guest's channels in the team being left, which
+	// leaving removes too and undo adds back
+	channels []ChannelInfo
+}
+
+// QuarantineSummary counts changes by status.
+type QuarantineSummary struct {
+	Planned int `json:"planned"`
+	Done    int `json:"done"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+}
+
+// PlanQuarantine lists, for each guest PlanChannelRemovals would act on,
+// joining the quarantine team and then leaving every other team, which
+// removes the guest from that team's channels too. A guest already in the
+// quarantine team keeps that membership and its channels. Archived teams
+// take no membership changes and are left alone, as are guests whose teams
+// or channels are unknown.
+func PlanQuarantine(result *AuditResult, quarantine TeamInfo) []QuarantineChange {
+	var changes []QuarantineChange
+	for _, g := range result.Guests {
+		if g.Failed || !g.Active || g.Excepted || !g.Inactive {
+			continue
+		}
+		join := QuarantineChange{
+			Username: g.Username,
+			Change:   QuarantineJoin,
+			Team:     quarantine.DisplayName,
+			Status:   RemovalPlanned,
+			userID:   g.UserID,
+			teamID:   quarantine.ID,
+		}
+		if len(g.PermissionMissing) > 0 || len(g.Errors) > 0 {
+			join.Status = RemovalSkipped
+			join.Reason = "teams or channels unknown"
+			changes = append(changes, join)
+			continue
+		}
+		for _, t := range g.Teams {
+			if t.ID == quarantine.ID {
+				join.Status = RemovalSkipped
+				join.Reason = "already a member"
+			}
+		}
+		changes = append(changes, join)
+		for _, t := range g.Teams {
+			if t.ID == quarantine.ID || t.Archived {
+				continue
+			}
+			leave := QuarantineChange{
+				Username: g.Username,
+				Change:   QuarantineLeave,
+				Team:     t.DisplayName,
+				Status:   RemovalPlanned,
+				userID:   g.UserID,
+				teamID:   t.ID,
+			}
+			for _, ch := range g.Channels {
+				if ch.TeamName == t.DisplayName && ch.Type != ChannelTypeDirect && ch.Type != ChannelTypeGroup && !ch.Archived {
+					leave.channels = append(leave.channels, ch)
+				}
+			}
+			changes = append(changes, leave)
+		}
+	}
+	return changes
+}
+
+// ApplyQuarantine makes each planned change in order. A guest whose join
+// failed leaves no team, so nobody is left without one; likewise undo does
+// not take a guest out of the quarantine team unless they rejoined every
+// team first. A failed change is recorded and the rest carry on; the exit
+// code is ExitPartialFailure if any failed.
+func ApplyQuarantine(client MattermostClient, changes []QuarantineChange, retry RetryPolicy, verbose bool) int {
+	exitCode := ExitSuccess
+	joinFailed := make(map[string]bool)
+	for i := range changes {
+		c := &changes[i]
+		if c.Status != RemovalPlanned {
+			continue
+		}
+		if c.Change == QuarantineLeave && joinFailed[c.userID] {
+			c.Status = RemovalSkipped
+			c.Reason = "an earlier team join failed"
+			continue
+		}
+		var op string
+		var call func() error
+		switch c.Change {
+		case QuarantineJoin:
+			op = fmt.Sprintf("adding %q to team %s", c.Username, c.Team)
+			call = func() error { return client.AddUserToTeam(c.teamID, c.userID) }
+		case QuarantineLeave:
+			op = fmt.Sprintf("removing %q from team %s", c.Username, c.Team)
+			call = func() error { return client.RemoveUserFromTeam(c.teamID, c.userID) }
+		default:
+			op = fmt.Sprintf("adding %q back to %s/%s", c.Username, c.Team, c.Channel)
+			call = func() error { return client.AddUserToChannel(c.channelID, c.userID) }
+		}
+		if err := retry.Do(op, verbose, call); err != nil {
+			c.Status = RemovalFailed
+			c.Reason = err.Error()
+			exitCode = ExitPartialFailure
+			if c.Change == QuarantineJoin {
+				joinFailed[c.userID] = true
+			}
+			if verbose {
+				logger.Printf("Warning: %s failed: %v", op, err)
+			}
+			continue
+		}
+		c.Status = QuarantineDone
+		if verbose {
+			logger.Printf("Done %s", op)
+		}
+	}
+	return exitCode
+}
+
+// NewQuarantinePlan builds the undo plan for changes made on server by op,
+// with includePlanned as for NewUndoPlan: rejoining a team that was never
+// left, or leaving the quarantine team before joining it, is harmless.
+func NewQuarantinePlan(server string, op Operator, changes []QuarantineChange, includePlanned bool, now time.Time) *UndoPlan {
+	plan := &UndoPlan{
+		Server:      normalizeServerURL(server),
+		CreatedAt:   now.UTC().Format(time.RFC3339),
+		Action:      UndoActionQuarantine,
+		Operator:    op,
+		Memberships: []UndoMembership{},
+		LeftTeams:   []UndoTeamMembership{},
+		JoinedTeams: []UndoTeamMembership{},
+	}
+	for _, c := range changes {
+		if c.Status != QuarantineDone && !(includePlanned && c.Status == RemovalPlanned) {
+			continue
+		}
+		m := UndoTeamMembership{UserID: c.userID, Username: c.Username, TeamID: c.teamID, Team: c.Team}
+		if c.Change == QuarantineJoin {
+			plan.JoinedTeams = append(plan.JoinedTeams, m)
+			continue
+		}
+		plan.LeftTeams = append(plan.LeftTeams, m)
+		for _, ch := range c.channels {
+			plan.Memberships = append(plan.Memberships, UndoMembership{
+				UserID:    c.userID,
+				Username:  c.Username,
+				ChannelID: ch.ID,
+				Team:      ch.TeamName,
+				Channel:   ch.ChannelName,
+				Type:      ch.Type,
+			})
+		}
+	}
+	return plan
+}
+
+// PlanQuarantineUndo lists the changes that reverse a quarantine plan, all
+// planned: rejoining each team left, adding back each channel left with
+// it, and only then leaving the quarantine team.
+func PlanQuarantineUndo(plan *UndoPlan) []QuarantineChange {
+	var changes []QuarantineChange
+	for _, t := range plan.LeftTeams {
+		changes = append(changes, QuarantineChange{Username: t.Username, Change: QuarantineJoin, Team: t.Team, Status: RemovalPlanned, userID: t.UserID, teamID: t.TeamID})
+	}
+	for _, m := range plan.Memberships {
+		changes = append(changes, QuarantineChange{Username: m.Username, Change: QuarantineRejoin, Team: m.Team, Channel: m.Channel, Status: RemovalPlanned, userID: m.UserID, channelID: m.ChannelID})
+	}
+	for _, t := range plan.JoinedTeams {
+		changes = append(changes, QuarantineChange{Username: t.Username, Change: QuarantineLeave, Team: t.Team, Status: RemovalPlanned, userID: t.UserID, teamID: t.TeamID})
+	}
+	return changes
+}
+
+// DescribeQuarantine is the plan --quarantine-team asks to confirm: how many
+// guests would be moved into quarantine, and the team joins, team leaves
+// and channel memberships that takes.
+func DescribeQuarantine(changes []QuarantineChange, quarantine TeamInfo) string {
+	guests := make(map[string]bool)
+	var joins, leaves, channels int
+	for _, c := range changes {
+		if c.Status != RemovalPlanned {
+			continue
+		}
+		guests[c.userID] = true
+		if c.Change == QuarantineJoin {
+			joins++
+			continue
+		}
+		leaves++
+		channels += len(c.channels)
+	}
+	return fmt.Sprintf("About to quarantine %d guest(s) in %s: %d team join(s) and %d team leave(s), removing them from %d channel(s).\nAn undo plan will be written; run with --dry-run first to list the changes.", len(guests), quarantine.DisplayName, joins, leaves, channels)
+}
+
+// SummarizeQuarantine counts changes by status.
+func SummarizeQuarantine(changes []QuarantineChange) QuarantineSummary {
+	var s QuarantineSummary
+	for _, c := range changes {
+		switch c.Status {
+		case RemovalPlanned:
+			s.Planned++
+		case QuarantineDone:
+			s.Done++
+		case RemovalFailed:
+			s.Failed++
+		case RemovalSkipped:
+			s.Skipped++
+		}
+	}
+	return s
+}
+
+// WriteQuarantine writes the quarantine changes, or those of its undo, in
+// the given format, with the usual stdout fallback when the output file
+// cannot be written.
+func WriteQuarantine(changes []QuarantineChange, dryRun bool, op Operator, format, outputPath string) error {
+	w, closeOutput := openOutput(outputPath)
+	defer closeOutput()
+
+	switch format {
+	case "csv":
+		return writeQuarantineCSV(w, changes, op)
+	case "json":
+		return writeQuarantineJSON(w, changes, dryRun, op)
+	default:
+		return writeQuarantineTable(w, changes, dryRun, op)
+	}
+}
+
+func writeQuarantineTable(w io.Writer, changes []QuarantineChange, dryRun bool, op Operator) error {
+	if dryRun {
+		fmt.Fprintln(w, "⚠  DRY RUN — no changes have been made to your Mattermost instance.")
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Operator: %s\n\n", op)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "USERNAME\tCHANGE\tTEAM\tCHANNEL\tSTATUS\tREASON")
+	for _, c := range changes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Username, c.Change, c.Team, c.Channel, c.Status, c.Reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	s := SummarizeQuarantine(changes)
+	fmt.Fprintln(w)
+	var err error
+	if dryRun {
+		_, err = fmt.Fprintf(w, "%d change(s) would be made, %d skipped\n", s.Planned, s.Skipped)
+	} else {
+		_, err = fmt.Fprintf(w, "%d change(s) made, %d failed, %d skipped\n", s.Done, s.Failed, s.Skipped)
+	}
+	return err
+}
+
+func writeQuarantineCSV(w io.Writer, changes []QuarantineChange, op Operator) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	if err := cw.Write([]string{"username", "change", "team", "channel", "status", "reason", "operator_id", "operator"}); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if err := cw.Write([]string{c.Username, c.Change, c.Team, c.Channel, c.Status, c.Reason, op.UserID, op.Username}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeQuarantineJSON(w io.Writer, changes []QuarantineChange, dryRun bool, op Operator) error {
+	if changes == nil {
+		changes = []QuarantineChange{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		DryRun   bool               `json:"dry_run"`
+		Operator Operator           `json:"operator"`
+		Summary  QuarantineSummary  `json:"summary"`
+		Changes  []QuarantineChange `json:"changes"`
+	}{dryRun, op, SummarizeQuarantine(changes), changes})
+}
+
+// runQuarantineUndo reverses a quarantine plan, or with dryRun lists the
+// changes, and writes the outcome in place of a report.
+func runQuarantineUndo(client MattermostClient, plan *UndoPlan, dryRun bool, format, output string, retry RetryPolicy, verbose bool) int {
+	changes := PlanQuarantineUndo(plan)
+	exitCode := ExitSuccess
+	if !dryRun {
+		exitCode = ApplyQuarantine(client, changes, retry, verbose)
+	}
+	if err := WriteQuarantine(changes, dryRun, client.ServerInfo().Operator(), format, output); err != nil {
+		logger.Printf("error: failed to write output: %v", err)
+		return ExitOutputError
+	}
+	s := SummarizeQuarantine(changes)
+	if dryRun {
+		logger.Printf("Dry run: %d change(s) would be made to release the guests, nothing was changed.", s.Planned)
+	} else {
+		logger.Printf("Released guests from quarantine: %d change(s) made, %d failed, %d skipped.", s.Done, s.Failed, s.Skipped)
+	}
+	return exitCode
+}
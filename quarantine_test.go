@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testQuarantineTeam = TeamInfo{ID: "hold", DisplayName: "Guest Quarantine"}
+
+// quarantineResult is removalResult with the inactive guest in two live
+// teams and an archived one.
+func quarantineResult() *AuditResult {
+	result := removalResult()
+	g := &result.Guests[0]
+	g.Teams = []TeamInfo{
+		{ID: "team1", DisplayName: "Engineering"},
+		{ID: "team2", DisplayName: "Sales"},
+		{ID: "team3", DisplayName: "Old Projects", Archived: true},
+	}
+	g.Channels = append(g.Channels, ChannelInfo{ID: "ch3", TeamName: "Sales", ChannelName: "Deals", Type: ChannelTypePublic})
+	return result
+}
+
+func TestPlanQuarantine(t *testing.T) {
+	changes := PlanQuarantine(quarantineResult(), testQuarantineTeam)
+	want := []struct{ change, team string }{
+		{QuarantineJoin, "Guest Quarantine"},
+		{QuarantineLeave, "Engineering"},
+		{QuarantineLeave, "Sales"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("expected %d changes, got %+v", len(want), changes)
+	}
+	for i, w := range want {
+		if c := changes[i]; c.Change != w.change || c.Team != w.team || c.Status != RemovalPlanned || c.userID != "user0" {
+			t.Errorf("change %d = %+v, want %s %s", i, c, w.change, w.team)
+		}
+	}
+	// Leaving a team takes its channels, which undo adds back; DMs stay
+	if n := len(changes[1].channels); n != 2 {
+		t.Errorf("Engineering carries %d channel(s), want 2", n)
+	}
+
+	// A guest already in the quarantine team only leaves the others
+	result := quarantineResult()
+	result.Guests[0].Teams = append(result.Guests[0].Teams, testQuarantineTeam)
+	changes = PlanQuarantine(result, testQuarantineTeam)
+	if c := changes[0]; c.Status != RemovalSkipped || c.Reason != "already a member" || len(changes) != 3 {
+		t.Errorf("already quarantined: %+v", changes)
+	}
+
+	// Unknown teams cannot be left
+	result = quarantineResult()
+	result.Guests[0].Errors = []LookupError{{Stage: LookupTeams, Message: "timeout"}}
+	changes = PlanQuarantine(result, testQuarantineTeam)
+	if len(changes) != 1 || changes[0].Status != RemovalSkipped {
+		t.Errorf("unknown teams: %+v", changes)
+	}
+}
+
+func TestApplyQuarantine(t *testing.T) {
+	client := &mockClient{leaveTeamErr: map[string]error{"team2": &APIError{StatusCode: 403, Message: "forbidden"}}}
+	changes := PlanQuarantine(quarantineResult(), testQuarantineTeam)
+	if exitCode := ApplyQuarantine(client, changes, RetryPolicy{}, false); exitCode != ExitPartialFailure {
+		t.Errorf("exit code = %d, want %d", exitCode, ExitPartialFailure)
+	}
+	if len(client.joinedTeams) != 1 || client.joinedTeams[0] != "hold:user0" || len(client.leftTeams) != 1 || client.leftTeams[0] != "team1:user0" {
+		t.Errorf("joined %v, left %v", client.joinedTeams, client.leftTeams)
+	}
+	want := QuarantineSummary{Done: 2, Failed: 1}
+	if got := SummarizeQuarantine(changes); got != want {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+
+	// A guest who could not join the quarantine team keeps their teams
+	client = &mockClient{joinTeamErr: map[string]error{"hold": &APIError{StatusCode: 403, Message: "forbidden"}}}
+	changes = PlanQuarantine(quarantineResult(), testQuarantineTeam)
+	ApplyQuarantine(client, changes, RetryPolicy{}, false)
+	if len(client.leftTeams) != 0 || changes[1].Status != RemovalSkipped {
+		t.Errorf("left %v after a failed join: %+v", client.leftTeams, changes)
+	}
+}
+
+func TestQuarantineUndo(t *testing.T) {
+	client := &mockClient{leaveTeamErr: map[string]error{"team2": &APIError{StatusCode: 403, Message: "forbidden"}}}
+	changes := PlanQuarantine(quarantineResult(), testQuarantineTeam)
+	ApplyQuarantine(client, changes, RetryPolicy{}, false)
+
+	// Only what changed is recorded: Sales was never left
+	plan := NewQuarantinePlan("https://chat.example.com/", testOperator, changes, false, time.Now())
+	if plan.Action != UndoActionQuarantine || len(plan.JoinedTeams) != 1 || len(plan.LeftTeams) != 1 || len(plan.Memberships) != 2 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	if planned := NewQuarantinePlan("https://chat.example.com/", testOperator, PlanQuarantine(quarantineResult(), testQuarantineTeam), true, time.Now()); len(planned.LeftTeams) != 2 {
+		t.Errorf("planned changes not included: %+v", planned)
+	}
+
+	// Undo rejoins the teams and channels before leaving quarantine
+	client = &mockClient{}
+	undo := PlanQuarantineUndo(plan)
+	if exitCode := ApplyQuarantine(client, undo, RetryPolicy{}, false); exitCode != ExitSuccess {
+		t.Errorf("exit code = %d", exitCode)
+	}
+	if len(client.joinedTeams) != 1 || client.joinedTeams[0] != "team1:user0" || len(client.added) != 2 || len(client.leftTeams) != 1 || client.leftTeams[0] != "hold:user0" {
+		t.Errorf("joined %v, added %v, left %v", client.joinedTeams, client.added, client.leftTeams)
+	}
+
+	// A guest who could not rejoin a team stays in quarantine
+	client = &mockClient{joinTeamErr: map[string]error{"team1": &APIError{StatusCode: 404, Message: "not found"}}}
+	undo = PlanQuarantineUndo(plan)
+	ApplyQuarantine(client, undo, RetryPolicy{}, false)
+	if len(client.leftTeams) != 0 {
+		t.Errorf("left %v after a failed rejoin", client.leftTeams)
+	}
+}
+
+func TestWriteQuarantine_DryRun(t *testing.T) {
+	changes := PlanQuarantine(quarantineResult(), testQuarantineTeam)
+
+	var buf bytes.Buffer
+	if err := writeQuarantineTable(&buf, changes, true, testOperator); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "⚠  DRY RUN") || !strings.Contains(out, "Operator: admin (admin1)") || !strings.Contains(out, "3 change(s) would be made, 0 skipped") {
+		t.Errorf("unexpected table output:\n%s", out)
+	}
+
+	buf.Reset()
+	if err := writeQuarantineJSON(&buf, changes, true, testOperator); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		DryRun  bool               `json:"dry_run"`
+		Summary QuarantineSummary  `json:"summary"`
+		Changes []QuarantineChange `json:"changes"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if !got.DryRun || got.Summary.Planned != 3 || len(got.Changes) != 3 || got.Changes[1].Change != QuarantineLeave {
+		t.Errorf("unexpected JSON: %s", buf.String())
+	}
+}
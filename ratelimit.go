@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket allowing a sustained number of requests per
+// second, with bursts of up to one second's worth of requests.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	now   func() time.Time                                 // overridden in tests
+	sleep func(ctx context.Context, d time.Duration) error // overridden in tests
+}
+
+// NewRateLimiter returns a limiter for perSecond requests per second, or nil
+// (no limiting) if perSecond is not positive.
+func NewRateLimiter(perSecond float64) *RateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	burst := perSecond
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   perSecond,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  sleepContext,
+	}
+}
+
+// Wait blocks until a request may be made or ctx is done. A nil limiter never blocks.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	// Reserve a token now, going into debt if necessary, so concurrent
+	// callers queue up rather than all waking at once.
+	l.mu.Lock()
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	return l.sleep(ctx, wait)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitedTransport waits on the limiter before every HTTP request, so all
+// API calls made through model.Client4 are covered.
+type rateLimitedTransport struct {
+	limiter *RateLimiter
+	next    http.RoundTripper
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
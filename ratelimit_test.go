@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeClock drives a RateLimiter without real sleeping.
+type fakeClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+func (c *fakeClock) install(l *RateLimiter) {
+	l.last = c.now
+	l.now = func() time.Time { return c.now }
+	l.sleep = func(_ context.Context, d time.Duration) error {
+		c.slept = append(c.slept, d)
+		return nil
+	}
+}
+
+func TestNewRateLimiter_Disabled(t *testing.T) {
+	if NewRateLimiter(0) != nil {
+		t.Error("expected nil limiter for rate 0")
+	}
+	var l *RateLimiter
+	if err := l.Wait(context.Background()); err != nil {
+		t.Errorf("nil limiter Wait returned %v", err)
+	}
+}
+
+func TestRateLimiter_Burst(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 11, 1, 9, 0, 0, 0, time.UTC)}
+	l := NewRateLimiter(5)
+	clock.install(l)
+
+	// One second's worth of requests goes straight through
+	for i := 0; i < 5; i++ {
+		l.Wait(context.Background())
+	}
+	if len(clock.slept) != 0 {
+		t.Fatalf("expected no waits within burst, got %v", clock.slept)
+	}
+
+	// The next two must wait 200ms and 400ms respectively (tokens reserved in debt)
+	l.Wait(context.Background())
+	l.Wait(context.Background())
+	want := []time.Duration{200 * time.Millisecond, 400 * time.Millisecond}
+	if len(clock.slept) != 2 || clock.slept[0] != want[0] || clock.slept[1] != want[1] {
+		t.Errorf("waits = %v, want %v", clock.slept, want)
+	}
+}
+
+func TestRateLimiter_Refill(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 11, 1, 9, 0, 0, 0, time.UTC)}
+	l := NewRateLimiter(2)
+	clock.install(l)
+
+	l.Wait(context.Background())
+	l.Wait(context.Background())
+	clock.now = clock.now.Add(time.Second) // refills 2 tokens
+	l.Wait(context.Background())
+	l.Wait(context.Background())
+
+	if len(clock.slept) != 0 {
+		t.Errorf("expected no waits after refill, got %v", clock.slept)
+	}
+}
+
+func TestRateLimiter_ContextCancelled(t *testing.T) {
+	l := NewRateLimiter(1)
+	l.Wait(context.Background()) // use the only token
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx); err == nil {
+		t.Error("expected error from cancelled context")
+	}
+}
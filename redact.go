@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// Fields --redact can mask.
+const (
+	RedactEmail       = "email"        // email and previous_emails
+	RedactDisplayName = "display_name" // display_name
+	RedactNickname    = "nickname"     // nickname
+	RedactIP          = "ip"           // shared_session_ips
+)
+
+var redactFields = []string{RedactEmail, RedactDisplayName, RedactNickname, RedactIP}
+
+// ParseRedactFields parses the comma-separated --redact value.
+func ParseRedactFields(s string) ([]string, error) {
+	var fields []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" {
+			continue
+		}
+		if !slices.Contains(redactFields, f) {
+			return nil, fmt.Errorf("error: invalid --redact field %q. Use %s", f, strings.Join(redactFields, ", "))
+		}
+		if !slices.Contains(fields, f) {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("error: --redact needs at least one of %s", strings.Join(redactFields, ", "))
+	}
+	return fields, nil
+}
+
+// Redactor masks selected personal fields of a report while keeping
+// usernames and IDs, so it can be shared without a manual scrub but still
+// matched against the server. Unlike Anonymizer, a masked value keeps
+// enough of its shape (j***@example.com) to be recognised by someone who
+// already knows the guest.
+type Redactor struct {
+	fields map[string]bool
+}
+
+// NewRedactor returns a Redactor for fields, as returned by
+// ParseRedactFields.
+func NewRedactor(fields []string) *Redactor {
+	r := &Redactor{fields: make(map[string]bool, len(fields))}
+	for _, f := range fields {
+		r.fields[f] = true
+	}
+	return r
+}
+
+// Result masks the selected fields of every guest in place, including the
+// guests removed since the last run.
+func (r *Redactor) Result(result *AuditResult) {
+	for i := range result.Guests {
+		r.Guest(&result.Guests[i])
+	}
+	if result.Changes == nil {
+		return
+	}
+	for i := range result.Changes.Removed {
+		g := &result.Changes.Removed[i]
+		if r.fields[RedactEmail] {
+			g.Email = MaskEmail(g.Email)
+		}
+		if r.fields[RedactDisplayName] {
+			g.DisplayName = MaskName(g.DisplayName)
+		}
+	}
+}
+
+// Guest masks the selected fields of one guest in place. The checksum is
+// recomputed from the masked record, so it does not confirm a guessed value.
+func (r *Redactor) Guest(g *GuestRecord) {
+	if r.fields[RedactEmail] {
+		g.Email = MaskEmail(g.Email)
+		for j, e := range g.PreviousEmails {
+			g.PreviousEmails[j] = MaskEmail(e)
+		}
+		// Lookup errors are free text and may quote an address
+		for j := range g.Errors {
+			g.Errors[j].Message = redactEmail.ReplaceAllStringFunc(g.Errors[j].Message, MaskEmail)
+		}
+	}
+	if r.fields[RedactDisplayName] {
+		g.DisplayName = MaskName(g.DisplayName)
+	}
+	if r.fields[RedactNickname] {
+		g.Nickname = MaskName(g.Nickname)
+	}
+	if r.fields[RedactIP] {
+		for j, ip := range g.SharedSessionIPs {
+			g.SharedSessionIPs[j] = MaskIP(ip)
+		}
+	}
+	g.Checksum = GuestChecksum(*g)
+}
+
+// MaskEmail keeps the first character of the local part and the domain:
+// jane.doe@example.com becomes j***@example.com.
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return maskWord(email)
+	}
+	return maskWord(local) + "@" + domain
+}
+
+// MaskName keeps the first character of each word: Jane Doe becomes
+// J*** D***.
+func MaskName(name string) string {
+	words := strings.Fields(name)
+	for i, w := range words {
+		words[i] = maskWord(w)
+	}
+	return strings.Join(words, " ")
+}
+
+// MaskIP keeps the network part of an address: the first three octets of
+// IPv4, the first two groups of IPv6. Anything else is masked whole.
+func MaskIP(s string) string {
+	ip := net.ParseIP(s)
+	switch {
+	case ip == nil:
+		return maskWord(s)
+	case ip.To4() != nil:
+		return s[:strings.LastIndex(s, ".")] + ".***"
+	default:
+		groups := strings.SplitN(s, ":", 3)
+		return groups[0] + ":" + groups[1] + ":***"
+	}
+}
+
+// maskWord keeps the first character of s. Empty values stay empty.
+func maskWord(s string) string {
+	for _, c := range s {
+		return string(c) + "***"
+	}
+	return ""
+}
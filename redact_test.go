@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseRedactFields(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"email", "email", false},
+		{" Email , display_name,email", "email,display_name", false},
+		{"email,ip,nickname", "email,ip,nickname", false},
+		{"username", "", true}, // usernames are kept so the report can be matched
+		{" , ", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRedactFields(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRedactFields(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("ParseRedactFields(%q) = %v, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMaskValues(t *testing.T) {
+	tests := []struct {
+		name, got, want string
+	}{
+		{"email", MaskEmail("jane.doe@example.com"), "j***@example.com"},
+		{"email without @", MaskEmail("jane"), "j***"},
+		{"empty email", MaskEmail(""), ""},
+		{"name", MaskName("Jane  Doe"), "J*** D***"},
+		{"non-ASCII name", MaskName("Élodie Martin"), "É*** M***"},
+		{"IPv4", MaskIP("203.0.113.7"), "203.0.113.***"},
+		{"IPv6", MaskIP("2001:db8::1"), "2001:db8:***"},
+		{"not an address", MaskIP("unknown"), "u***"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestRedactor_Result(t *testing.T) {
+	result := anonymizeResult()
+	before := result.Guests[0].Checksum
+	NewRedactor([]string{RedactEmail, RedactDisplayName}).Result(result)
+
+	g := result.Guests[0]
+	if g.Email != "J***@Partner.com" || g.PreviousEmails[0] != "j***@old-partner.com" || g.DisplayName != "J*** S***" {
+		t.Errorf("fields not masked: %+v", g)
+	}
+	// Usernames, IDs, unselected fields and structure are kept
+	if g.Username != "john.contractor" || g.UserID != "abc" || g.Nickname != "JS" || g.SharedSessionIPs[0] != "203.0.113.7" || g.Teams[0].DisplayName != "Engineering" {
+		t.Errorf("unselected fields changed: %+v", g)
+	}
+	if got := result.Guests[1].Errors[0].Message; got != "j***@partner.com forbidden" {
+		t.Errorf("error message not masked: %q", got)
+	}
+	if g.Checksum == before || g.Checksum != GuestChecksum(g) {
+		t.Error("checksum should be recomputed")
+	}
+
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"John@Partner.com", "jane@partner.com", "John Smith", "Jane Doe"} {
+		if strings.Contains(buf.String(), s) {
+			t.Errorf("CSV report still contains %q", s)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Status of a ChannelRemoval.
+const (
+	RemovalPlanned = "planned" // --dry-run: would be removed
+	RemovalRemoved = "removed"
+	RemovalFailed  = "failed"
+	RemovalSkipped = "skipped" // cannot be removed, see Reason
+)
+
+// ChannelRemoval is one channel membership of a flagged guest that
+// --remove-from-channels removes, or would remove with --dry-run.
+type ChannelRemoval struct {
+	Username string `json:"username"`
+	Team     string `json:"team"`
+	Channel  string `json:"channel"`
+	Type     string `json:"type"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"` // why the removal was skipped or failed
+
+	userID    string
+	channelID string
+}
+
+// RemovalSummary counts removals by status.
+type RemovalSummary struct {
+	Planned int `json:"planned"`
+	Removed int `json:"removed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+}
+
+// PlanChannelRemovals lists the team channel memberships of each active,
+// inactive guest who is not excepted — the guests --preview would notify.
+// With --channel, a guest's channels are already limited to that one. DMs and
+// group messages are not team channels and are left alone, as are archived
+// channels, which take no membership changes; members cannot
+// leave a team's default channel, so those memberships are listed as skipped.
+// Guests whose channels are unknown cannot be planned and are skipped whole.
+func PlanChannelRemovals(result *AuditResult) []ChannelRemoval {
+	var plans []ChannelRemoval
+	for _, g := range result.Guests {
+		if g.Failed || !g.Active || g.Excepted || !g.Inactive {
+			continue
+		}
+		plans = append(plans, planGuestRemovals(g)...)
+	}
+	return plans
+}
+
+// planGuestRemovals lists one guest's team channel memberships as removals,
+// skipping DMs, group messages and archived channels and marking the team's
+// default channels skipped, as described for PlanChannelRemovals.
+func planGuestRemovals(g GuestRecord) []ChannelRemoval {
+	var plans []ChannelRemoval
+	for _, ch := range g.Channels {
+		if ch.Type == ChannelTypeDirect || ch.Type == ChannelTypeGroup || ch.Archived {
+			continue
+		}
+		r := ChannelRemoval{
+			Username:  g.Username,
+			Team:      ch.TeamName,
+			Channel:   ch.ChannelName,
+			Type:      ch.Type,
+			Status:    RemovalPlanned,
+			userID:    g.UserID,
+			channelID: ch.ID,
+		}
+		if ch.Default {
+			r.Status = RemovalSkipped
+			r.Reason = "default channel"
+		}
+		plans = append(plans, r)
+	}
+	return plans
+}
+
+// ApplyChannelRemovals removes each planned membership, leaving the guest's
+// account and team memberships in place. A failed removal is recorded and
+// the rest carry on; the exit code is ExitPartialFailure if any failed.
+func ApplyChannelRemovals(client MattermostClient, removals []ChannelRemoval, retry RetryPolicy, verbose bool) int {
+	exitCode := ExitSuccess
+	for i := range removals {
+		r := &removals[i]
+		if r.Status != RemovalPlanned {
+			continue
+		}
+		op := fmt.Sprintf("removing %q from %s/%s", r.Username, r.Team, r.Channel)
+		err := retry.Do(op, verbose, func() error {
+			return client.RemoveUserFromChannel(r.channelID, r.userID)
+		})
+		if err != nil {
+			r.Status = RemovalFailed
+			r.Reason = err.Error()
+			exitCode = ExitPartialFailure
+			if verbose {
+				logger.Printf("Warning: %s failed: %v", op, err)
+			}
+			continue
+		}
+		r.Status = RemovalRemoved
+		if verbose {
+			logger.Printf("Removed %q from %s/%s", r.Username, r.Team, r.Channel)
+		}
+	}
+	return exitCode
+}
+
+// DescribeRemovals is the plan --remove-from-channels asks to confirm: how
+// many memberships of how many guests would be removed.
+func DescribeRemovals(removals []ChannelRemoval) string {
+	guests := make(map[string]bool)
+	for _, r := range removals {
+		if r.Status == RemovalPlanned {
+			guests[r.userID] = true
+		}
+	}
+	return fmt.Sprintf("About to remove %d channel membership(s) of %d guest(s), keeping their accounts.\nAn undo plan will be written; run with --dry-run first to list them.", SummarizeRemovals(removals).Planned, len(guests))
+}
+
+// SummarizeRemovals counts removals by status.
+func SummarizeRemovals(removals []ChannelRemoval) RemovalSummary {
+	var s RemovalSummary
+	for _, r := range removals {
+		switch r.Status {
+		case RemovalPlanned:
+			s.Planned++
+		case RemovalRemoved:
+			s.Removed++
+		case RemovalFailed:
+			s.Failed++
+		case RemovalSkipped:
+			s.Skipped++
+		}
+	}
+	return s
+}
+
+// WriteRemovals writes the removal plan or outcome in the given format, with
+// the usual stdout fallback when the output file cannot be written.
+func WriteRemovals(removals []ChannelRemoval, dryRun bool, op Operator, format, outputPath string) error {
+	w, closeOutput := openOutput(outputPath)
+	defer closeOutput()
+
+	switch format {
+	case "csv":
+		return writeRemovalsCSV(w, removals, op)
+	case "json":
+		return writeRemovalsJSON(w, removals, dryRun, op)
+	default:
+		return writeRemovalsTable(w, removals, dryRun, op)
+	}
+}
+
+func writeRemovalsTable(w io.Writer, removals []ChannelRemoval, dryRun bool, op Operator) error {
+	if dryRun {
+		fmt.Fprintln(w, "⚠  DRY RUN — no changes have been made to your Mattermost instance.")
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Operator: %s\n\n", op)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "USERNAME\tTEAM\tCHANNEL\tTYPE\tSTATUS\tREASON")
+	for _, r := range removals {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Username, r.Team, r.Channel, r.Type, r.Status, r.Reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	s := SummarizeRemovals(removals)
+	fmt.Fprintln(w)
+	var err error
+	if dryRun {
+		_, err = fmt.Fprintf(w, "%d membership(s) would be removed, %d skipped\n", s.Planned, s.Skipped)
+	} else {
+		_, err = fmt.Fprintf(w, "%d membership(s) removed, %d failed, %d skipped\n", s.Removed, s.Failed, s.Skipped)
+	}
+	return err
+}
+
+func writeRemovalsCSV(w io.Writer, removals []ChannelRemoval, op Operator) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	if err := cw.Write([]string{"username", "team", "channel", "type", "status", "reason", "operator_id", "operator"}); err != nil {
+		return err
+	}
+	for _, r := range removals {
+		if err := cw.Write([]string{r.Username, r.Team, r.Channel, r.Type, r.Status, r.Reason, op.UserID, op.Username}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeRemovalsJSON(w io.Writer, removals []ChannelRemoval, dryRun bool, op Operator) error {
+	if removals == nil {
+		removals = []ChannelRemoval{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		DryRun   bool             `json:"dry_run"`
+		Operator Operator         `json:"operator"`
+		Summary  RemovalSummary   `json:"summary"`
+		Removals []ChannelRemoval `json:"removals"`
+	}{dryRun, op, SummarizeRemovals(removals), removals})
+}
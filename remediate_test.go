@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// testOperator is the account action reports and undo plans are written as.
+var testOperator = Operator{UserID: "admin1", Username: "admin"}
+
+func removalResult() *AuditResult {
+	channels := []ChannelInfo{
+		{ID: "ch1", TeamName: "Engineering", ChannelName: "Town Square", Type: ChannelTypePublic, Default: true},
+		{ID: "ch2", TeamName: "Engineering", ChannelName: "Partners", Type: ChannelTypePrivate},
+		{ID: "dm1", TeamName: "Engineering", ChannelName: "dm", Type: ChannelTypeDirect},
+	}
+	return &AuditResult{Guests: []GuestRecord{
+		{Username: "inactive", UserID: "user0", Active: true, Inactive: true, Channels: channels},
+		{Username: "active", UserID: "user1", Active: true, Channels: channels},
+		{Username: "excepted", UserID: "user2", Active: true, Inactive: true, Excepted: true, Channels: channels},
+		{Username: "deactivated", UserID: "user3", Inactive: true, Channels: channels},
+		{Username: "failed", Active: true, Inactive: true, Failed: true},
+	}}
+}
+
+func TestPlanChannelRemovals(t *testing.T) {
+	plans := PlanChannelRemovals(removalResult())
+	if len(plans) != 2 {
+		t.Fatalf("expected 2 removals, got %d: %+v", len(plans), plans)
+	}
+	if p := plans[0]; p.Channel != "Town Square" || p.Status != RemovalSkipped || p.Reason != "default channel" {
+		t.Errorf("default channel should be skipped: %+v", p)
+	}
+	if p := plans[1]; p.Username != "inactive" || p.Channel != "Partners" || p.Status != RemovalPlanned || p.channelID != "ch2" || p.userID != "user0" {
+		t.Errorf("unexpected removal: %+v", p)
+	}
+
+	// Archived channels take no membership changes
+	result := removalResult()
+	result.Guests[0].Channels = append(result.Guests[0].Channels, ChannelInfo{ID: "ch3", TeamName: "Sales", ChannelName: "Old Deals", Type: ChannelTypePublic, Archived: true})
+	if plans := PlanChannelRemovals(result); len(plans) != 2 {
+		t.Errorf("archived channel planned: %+v", plans)
+	}
+}
+
+func TestApplyChannelRemovals(t *testing.T) {
+	result := removalResult()
+	result.Guests[0].Channels = append(result.Guests[0].Channels, ChannelInfo{ID: "ch3", TeamName: "Sales", ChannelName: "Deals", Type: ChannelTypePublic})
+	client := &mockClient{removeErr: map[string]error{"ch3": &APIError{StatusCode: 403, Message: "forbidden"}}}
+
+	removals := PlanChannelRemovals(result)
+	exitCode := ApplyChannelRemovals(client, removals, RetryPolicy{}, false)
+	if exitCode != ExitPartialFailure {
+		t.Errorf("expected exit code %d, got %d", ExitPartialFailure, exitCode)
+	}
+	if len(client.removed) != 1 || client.removed[0] != "ch2:user0" {
+		t.Errorf("removed = %v, want [ch2:user0]", client.removed)
+	}
+	want := RemovalSummary{Removed: 1, Failed: 1, Skipped: 1}
+	if got := SummarizeRemovals(removals); got != want {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+	if removals[2].Status != RemovalFailed || !strings.Contains(removals[2].Reason, "forbidden") {
+		t.Errorf("unexpected failed removal: %+v", removals[2])
+	}
+}
+
+func TestRunAudit_RemovalPlanUsesIDs(t *testing.T) {
+	client := &mockClient{
+		guests: sampleGuests(1),
+		teams:  map[string][]*model.Team{"user0": {{Id: "team1", DisplayName: "Engineering"}}},
+		channels: map[string][]*model.Channel{
+			"team1:user0": {
+				{Id: "ch1", Name: model.DefaultChannelName, DisplayName: "Town Square", Type: model.ChannelTypeOpen},
+				{Id: "ch2", Name: "partners", DisplayName: "Partners", Type: model.ChannelTypePrivate},
+			},
+		},
+	}
+	result, _ := RunAudit(client, AuditOptions{InactiveDays: 90})
+
+	removals := PlanChannelRemovals(result)
+	if exitCode := ApplyChannelRemovals(client, removals, RetryPolicy{}, false); exitCode != ExitSuccess {
+		t.Fatalf("expected exit code %d, got %d", ExitSuccess, exitCode)
+	}
+	if len(client.removed) != 1 || client.removed[0] != "ch2:user0" {
+		t.Errorf("removed = %v, want [ch2:user0]", client.removed)
+	}
+}
+
+func TestWriteRemovals_DryRun(t *testing.T) {
+	removals := PlanChannelRemovals(removalResult())
+
+	var buf bytes.Buffer
+	if err := writeRemovalsTable(&buf, removals, true, testOperator); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "⚠  DRY RUN") || !strings.Contains(out, "Operator: admin (admin1)") || !strings.Contains(out, "1 membership(s) would be removed, 1 skipped") {
+		t.Errorf("unexpected table output:\n%s", out)
+	}
+
+	buf.Reset()
+	if err := writeRemovalsJSON(&buf, removals, true, testOperator); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		DryRun   bool             `json:"dry_run"`
+		Operator Operator         `json:"operator"`
+		Summary  RemovalSummary   `json:"summary"`
+		Removals []ChannelRemoval `json:"removals"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if !got.DryRun || got.Operator != testOperator || got.Summary.Planned != 1 || len(got.Removals) != 2 {
+		t.Errorf("unexpected JSON: %s", buf.String())
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// RetryPolicy controls how transient API failures (see IsTransient) are retried.
+// The zero value performs a single attempt with no retries.
+type RetryPolicy struct {
+	MaxRetries int           // retries after the first attempt
+	BaseDelay  time.Duration // delay before the first retry, doubled each time
+	MaxDelay   time.Duration // upper bound on a single delay
+
+	Log Logger // where retries are logged, with the guest's prefix in an enrichment
+
+	sleep func(time.Duration) // overridden in tests
+}
+
+// DefaultRetryPolicy returns the policy used unless overridden by flags.
+func DefaultRetryPolicy(maxRetries int) RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: maxRetries,
+		BaseDelay:  time.Second,
+		MaxDelay:   30 * time.Second,
+	}
+}
+
+// Do calls fn until it succeeds, fails with a non-transient error, or the
+// retry budget is spent. op describes the call for verbose logging.
+func (p RetryPolicy) Do(op string, verbose bool, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !IsTransient(err) || attempt >= p.MaxRetries {
+			return err
+		}
+		delay := p.Backoff(attempt, err)
+		if verbose {
+			p.Log.Printf("Warning: %s failed (attempt %d of %d), retrying in %s: %v", op, attempt+1, p.MaxRetries+1, delay, err)
+		}
+		if p.sleep != nil {
+			p.sleep(delay)
+		} else {
+			time.Sleep(delay)
+		}
+	}
+}
+
+// Backoff returns the delay before retry number attempt+1. A Retry-After
+// value sent by the server takes precedence over exponential backoff.
+func (p RetryPolicy) Backoff(attempt int, err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+	delay := p.BaseDelay << attempt
+	if p.MaxDelay > 0 && (delay > p.MaxDelay || delay <= 0) {
+		delay = p.MaxDelay
+	}
+	return delay
+}
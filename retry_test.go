@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"connection failure", &APIError{StatusCode: 0}, true},
+		{"rate limited", &APIError{StatusCode: 429}, true},
+		{"server error", &APIError{StatusCode: 502}, true},
+		{"forbidden", &APIError{StatusCode: 403}, false},
+		{"not found", &APIError{StatusCode: 404}, false},
+		{"wrapped server error", fmt.Errorf("failed to get teams: %w", &APIError{StatusCode: 500}), true},
+		{"plain error", fmt.Errorf("something else"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransient(tt.err); got != tt.expected {
+				t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsUnsupported(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"forbidden", &APIError{StatusCode: 403}, true},
+		{"not found", &APIError{StatusCode: 404}, true},
+		{"not implemented", &APIError{StatusCode: 501}, true},
+		{"server error", &APIError{StatusCode: 500}, false},
+		{"connection failure", &APIError{StatusCode: 0}, false},
+		{"wrapped", fmt.Errorf("failed: %w", &APIError{StatusCode: 403}), true},
+		{"plain error", fmt.Errorf("something else"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsUnsupported(tt.err); got != tt.expected {
+				t.Errorf("IsUnsupported(%v) = %v, want %v", tt.err, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	transient := &APIError{StatusCode: 503, Message: "unavailable"}
+	permanent := &APIError{StatusCode: 403, Message: "forbidden"}
+
+	tests := []struct {
+		name      string
+		failures  []error // errors returned before success
+		maxRetry  int
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success first time", nil, 3, 1, false},
+		{"recovers after transient failures", []error{transient, transient}, 3, 3, false},
+		{"budget exhausted", []error{transient, transient, transient}, 2, 3, true},
+		{"permanent error not retried", []error{permanent}, 3, 1, true},
+		{"zero retries", []error{transient}, 0, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var slept []time.Duration
+			p := RetryPolicy{MaxRetries: tt.maxRetry, BaseDelay: time.Second, MaxDelay: 4 * time.Second, sleep: func(d time.Duration) { slept = append(slept, d) }}
+			calls := 0
+			err := p.Do("test call", false, func() error {
+				calls++
+				if calls <= len(tt.failures) {
+					return tt.failures[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(slept) != calls-1 {
+				t.Errorf("slept %d times, want %d", len(slept), calls-1)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	transient := &APIError{StatusCode: 502}
+
+	tests := []struct {
+		name     string
+		attempt  int
+		err      error
+		expected time.Duration
+	}{
+		{"first retry", 0, transient, time.Second},
+		{"doubles", 2, transient, 4 * time.Second},
+		{"capped at max", 3, transient, 5 * time.Second},
+		{"retry-after wins", 0, &APIError{StatusCode: 429, RetryAfter: 10 * time.Second}, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Backoff(tt.attempt, tt.err); got != tt.expected {
+				t.Errorf("Backoff(%d) = %s, want %s", tt.attempt, got, tt.expected)
+			}
+		})
+	}
+}
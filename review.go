@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Actions of the review subcommand.
+const (
+	ReviewExport = "export" // write the attestation sheet
+	ReviewApply  = "apply"  // act on the completed sheet
+)
+
+// Decisions a reviewer can enter in the sheet's decision column.
+const (
+	DecisionKeep       = "keep"
+	DecisionRemove     = "remove"     // remove from every team channel, keeping the account
+	DecisionDeactivate = "deactivate" // deactivate the account
+)
+
+var reviewDecisions = []string{DecisionKeep, DecisionRemove, DecisionDeactivate}
+
+// Status of a ReviewOutcome besides the removal and deactivation statuses.
+const (
+	ReviewKept    = "kept"
+	ReviewSkipped = "skipped" // nothing done, see Reason
+)
+
+// Columns of the review sheet that apply reads; the others are there for
+// the reviewer and may be moved, removed or added to.
+const (
+	reviewUserIDColumn        = "user_id"
+	reviewUsernameColumn      = "username"
+	reviewDecisionColumn      = "decision"
+	reviewJustificationColumn = "justification"
+)
+
+// ParseReviewArgs splits the arguments after `review` into the action, the
+// decisions file of `apply <file>`, and the flags that follow.
+func ParseReviewArgs(args []string) (action, path string, rest []string, err error) {
+	if len(args) == 0 {
+		return "", "", nil, errors.New("error: review needs an action: mm-guest-audit review export, or mm-guest-audit review apply decisions.csv")
+	}
+	switch args[0] {
+	case ReviewExport:
+		return ReviewExport, "", args[1:], nil
+	case ReviewApply:
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			return "", "", nil, errors.New("error: review apply needs the completed sheet first: mm-guest-audit review apply decisions.csv [flags]")
+		}
+		return ReviewApply, args[1], args[2:], nil
+	}
+	return "", "", nil, fmt.Errorf("error: unknown review action %q. Use export or apply", args[0])
+}
+
+// WriteReviewSheet writes the access review sheet: one row per guest with
+// what a reviewer needs to decide, then empty decision and justification
+// columns to fill in. The user ID lets apply find the account even if it is
+// renamed before the sheet comes back.
+func WriteReviewSheet(result *AuditResult, outputPath string) error {
+	w, closeOutput := openOutput(outputPath)
+	defer closeOutput()
+	return writeReviewSheet(w, result)
+}
+
+func writeReviewSheet(w io.Writer, result *AuditResult) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	header := []string{reviewUserIDColumn, reviewUsernameColumn, "display_name", "email", "position", "teams", "channels", "created_at", "last_login", "last_post", "status"}
+	for _, name := range result.ProfileFields {
+		header = append(header, profileColumn(name))
+	}
+	header = append(header, reviewDecisionColumn, reviewJustificationColumn)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, g := range result.Guests {
+		row := []string{
+			g.UserID,
+			g.Username,
+			g.DisplayName,
+			g.Email,
+			g.Position,
+			formatTeamNamesCSV(g.Teams),
+			formatChannelNamesCSV(g.Channels),
+			result.TimeFormat.ISO(g.CreatedAt),
+			result.TimeFormat.ISO(g.LastLogin),
+			result.TimeFormat.ISO(g.LastPost),
+			guestStatus(g),
+		}
+		for _, name := range result.ProfileFields {
+			row = append(row, g.ProfileFields[name])
+		}
+		row = append(row, "", "")
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReviewDecision is one row of a completed review sheet.
+type ReviewDecision struct {
+	Line          int // line in the sheet, for messages
+	UserID        string
+	Username      string
+	Decision      string // one of reviewDecisions, or empty if undecided
+	Justification string
+}
+
+// LoadReviewDecisions reads and validates a completed review sheet.
+func LoadReviewDecisions(path string) ([]ReviewDecision, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseReviewDecisions(f)
+}
+
+// ParseReviewDecisions parses a completed review sheet. Columns are found by
+// name, so the reviewer may reorder them. Every row is checked before
+// anything is applied: an unknown decision or a guest listed twice is an
+// error, since acting on part of a sheet would be hard to explain.
+func ParseReviewDecisions(r io.Reader) ([]ReviewDecision, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("the sheet is empty")
+	}
+	// Spreadsheets may save the sheet with a byte order mark
+	col := make(map[string]int)
+	for i, name := range records[0] {
+		col[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range []string{reviewUserIDColumn, reviewUsernameColumn, reviewDecisionColumn} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("no %s column; start from a sheet written by review export", name)
+		}
+	}
+	field := func(rec []string, name string) string {
+		i, ok := col[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	var decisions []ReviewDecision
+	seen := make(map[string]int)
+	for i, rec := range records[1:] {
+		d := ReviewDecision{
+			Line:          i + 2,
+			UserID:        field(rec, reviewUserIDColumn),
+			Username:      field(rec, reviewUsernameColumn),
+			Decision:      strings.ToLower(field(rec, reviewDecisionColumn)),
+			Justification: field(rec, reviewJustificationColumn),
+		}
+		if d.UserID == "" && d.Username == "" && d.Decision == "" {
+			continue // blank line
+		}
+		if d.UserID == "" {
+			return nil, fmt.Errorf("line %d: no user_id", d.Line)
+		}
+		if d.Decision != "" && !slices.Contains(reviewDecisions, d.Decision) {
+			return nil, fmt.Errorf("line %d: invalid decision %q for %s. Use %s, or leave it empty", d.Line, d.Decision, d.Username, strings.Join(reviewDecisions, ", "))
+		}
+		if prev, ok := seen[d.UserID]; ok {
+			return nil, fmt.Errorf("line %d: %s is already listed on line %d", d.Line, d.Username, prev)
+		}
+		seen[d.UserID] = d.Line
+		decisions = append(decisions, d)
+	}
+	return decisions, nil
+}
+
+// ReviewOutcome is what review apply did, or would do with --dry-run, for
+// one row of the sheet.
+type ReviewOutcome struct {
+	Username      string `json:"username"`
+	Decision      string `json:"decision"`
+	Justification string `json:"justification,omitempty"`
+	// Status is ReviewKept or ReviewSkipped, a RemovalPlanned,
+	// RemovalRemoved or RemovalFailed for remove, or a Deactivation status
+	// for deactivate.
+	Status string `json:"status"`
+	// Memberships counts the channel memberships removed, or to be removed,
+	// for a remove decision.
+	Memberships int    `json:"memberships,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+
+	userID string
+}
+
+// ReviewSummary counts the outcomes of a review by status.
+type ReviewSummary struct {
+	Kept        int `json:"kept"`
+	Planned     int `json:"planned"`
+	Removed     int `json:"removed"`
+	Deactivated int `json:"deactivated"`
+	Failed      int `json:"failed"`
+	Skipped     int `json:"skipped"`
+}
+
+// ReviewPlan is the work of review apply: the channel removals and
+// deactivations the decisions call for, and an outcome per decision.
+type ReviewPlan struct {
+	Outcomes      []ReviewOutcome
+	Removals      []ChannelRemoval
+	Deactivations []GuestDeactivation
+}
+
+// PlanReview matches decisions to the guests of a fresh audit by user ID, so
+// the current channel memberships are removed rather than those at export
+// time. Decisions are the reviewer's, so allowlist exceptions and the
+// inactivity flag are not consulted. A guest missing from the audit, or
+// whose channels could not all be read, is skipped.
+func PlanReview(result *AuditResult, decisions []ReviewDecision) *ReviewPlan {
+	guests := make(map[string]GuestRecord, len(result.Guests))
+	for _, g := range result.Guests {
+		guests[g.UserID] = g
+	}
+	plan := &ReviewPlan{}
+	for _, d := range decisions {
+		o := ReviewOutcome{Username: d.Username, Decision: d.Decision, Justification: d.Justification, userID: d.UserID}
+		g, found := guests[d.UserID]
+		if found {
+			o.Username = g.Username // the current name, if renamed since the export
+		}
+		switch {
+		case d.Decision == "":
+			o.Status, o.Reason = ReviewSkipped, "no decision"
+		case d.Decision == DecisionKeep:
+			o.Status = ReviewKept
+		case !found:
+			o.Status, o.Reason = ReviewSkipped, "not in this audit; use the same scope flags as the export"
+		case d.Decision == DecisionRemove && (g.Failed || slices.ContainsFunc(g.Errors, func(e LookupError) bool { return e.Stage == LookupChannels })):
+			o.Status, o.Reason = ReviewSkipped, "the guest's channels could not be read"
+		case d.Decision == DecisionRemove:
+			o.Status = RemovalPlanned
+			plan.Removals = append(plan.Removals, planGuestRemovals(g)...)
+		case d.Decision == DecisionDeactivate:
+			deactivation := planGuestDeactivation(g)
+			if !g.Active {
+				deactivation.Status = DeactivationSkipped
+				deactivation.Reason = "already deactivated"
+			}
+			plan.Deactivations = append(plan.Deactivations, deactivation)
+		}
+		plan.Outcomes = append(plan.Outcomes, o)
+	}
+	plan.update()
+	return plan
+}
+
+// update sets the status of each remove and deactivate outcome from its
+// removals or deactivation. A remove with no membership left to remove,
+// e.g. only the default channels, is skipped.
+func (p *ReviewPlan) update() {
+	for i := range p.Outcomes {
+		o := &p.Outcomes[i]
+		switch o.Decision {
+		case DecisionRemove:
+			if o.Status == ReviewSkipped {
+				continue
+			}
+			var s RemovalSummary
+			o.Reason = ""
+			for _, r := range p.Removals {
+				if r.userID != o.userID {
+					continue
+				}
+				switch r.Status {
+				case RemovalPlanned:
+					s.Planned++
+				case RemovalRemoved:
+					s.Removed++
+				case RemovalFailed:
+					s.Failed++
+					if o.Reason == "" {
+						o.Reason = r.Team + "/" + r.Channel + ": " + r.Reason
+					}
+				}
+			}
+			switch {
+			case s.Failed > 0:
+				o.Status, o.Memberships = RemovalFailed, s.Removed
+			case s.Removed > 0:
+				o.Status, o.Memberships = RemovalRemoved, s.Removed
+			case s.Planned > 0:
+				o.Status, o.Memberships = RemovalPlanned, s.Planned
+			default:
+				o.Status, o.Reason = ReviewSkipped, "no channel memberships to remove"
+			}
+		case DecisionDeactivate:
+			if i := slices.IndexFunc(p.Deactivations, func(d GuestDeactivation) bool { return d.userID == o.userID }); i >= 0 {
+				o.Status, o.Reason = p.Deactivations[i].Status, p.Deactivations[i].Reason
+			}
+		}
+	}
+}
+
+// Pending reports how many guests the plan would change: those with
+// memberships to remove or an account to deactivate.
+func (p *ReviewPlan) Pending() (remove, deactivate int) {
+	for _, o := range p.Outcomes {
+		if o.Status == RemovalPlanned && o.Decision == DecisionRemove {
+			remove++
+		}
+		if o.Status == DeactivationPlanned && o.Decision == DecisionDeactivate {
+			deactivate++
+		}
+	}
+	return remove, deactivate
+}
+
+// Apply removes the planned memberships and deactivates the planned
+// accounts, then updates the outcomes. Failures are recorded and the rest
+// carry on; the exit code is ExitPartialFailure if any failed.
+func (p *ReviewPlan) Apply(client MattermostClient, retry RetryPolicy, verbose bool) int {
+	exitCode := ApplyChannelRemovals(client, p.Removals, retry, verbose)
+	if code := ApplyDeactivations(client, p.Deactivations, retry, verbose); code != ExitSuccess {
+		exitCode = code
+	}
+	p.update()
+	return exitCode
+}
+
+// reviewConfirmation is what must be typed to go ahead with review apply.
+const reviewConfirmation = "apply"
+
+// ConfirmReview asks on w for the review's changes to be confirmed by
+// typing reviewConfirmation, and reads the answer from r, like
+// ConfirmDeactivation.
+func ConfirmReview(r io.Reader, w io.Writer, remove, deactivate int) bool {
+	fmt.Fprintf(w, "About to remove %d guest(s) from their channels and deactivate %d guest account(s), as decided in the review.\n", remove, deactivate)
+	fmt.Fprintf(w, "Undo plans will be written; run with --dry-run first to list the changes.\n")
+	fmt.Fprintf(w, "Type %q to continue: ", reviewConfirmation)
+	line, _ := bufio.NewReader(r).ReadString('\n')
+	fmt.Fprintln(w)
+	return strings.TrimSpace(line) == reviewConfirmation
+}
+
+// ReviewUndoFiles names the two undo plans review apply writes, one per
+// action, from --undo-file or the default undo file name: undo.json becomes
+// undo-channels.json and undo-accounts.json.
+func ReviewUndoFiles(path string, now time.Time) (channels, accounts string) {
+	if path == "" {
+		path = DefaultUndoFile(now)
+	}
+	base := strings.TrimSuffix(path, ".json")
+	return base + "-channels.json", base + "-accounts.json"
+}
+
+// SummarizeReview counts outcomes by status.
+func SummarizeReview(outcomes []ReviewOutcome) ReviewSummary {
+	var s ReviewSummary
+	for _, o := range outcomes {
+		switch o.Status {
+		case ReviewKept:
+			s.Kept++
+		case RemovalPlanned:
+			s.Planned++
+		case RemovalRemoved:
+			s.Removed++
+		case DeactivationDeactivated:
+			s.Deactivated++
+		case RemovalFailed:
+			s.Failed++
+		case ReviewSkipped:
+			s.Skipped++
+		}
+	}
+	return s
+}
+
+// WriteReviewOutcomes writes the outcome of review apply in the given
+// format, with the usual stdout fallback when the output file cannot be
+// written.
+func WriteReviewOutcomes(outcomes []ReviewOutcome, dryRun bool, op Operator, format, outputPath string) error {
+	w, closeOutput := openOutput(outputPath)
+	defer closeOutput()
+
+	switch format {
+	case "csv":
+		return writeReviewOutcomesCSV(w, outcomes, op)
+	case "json":
+		return writeReviewOutcomesJSON(w, outcomes, dryRun, op)
+	default:
+		return writeReviewOutcomesTable(w, outcomes, dryRun, op)
+	}
+}
+
+func writeReviewOutcomesTable(w io.Writer, outcomes []ReviewOutcome, dryRun bool, op Operator) error {
+	if dryRun {
+		fmt.Fprintln(w, "⚠  DRY RUN — no changes have been made to your Mattermost instance.")
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Operator: %s\n\n", op)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "USERNAME\tDECISION\tSTATUS\tMEMBERSHIPS\tJUSTIFICATION\tREASON")
+	for _, o := range outcomes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", o.Username, o.Decision, o.Status, formatMemberships(o), o.Justification, o.Reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	s := SummarizeReview(outcomes)
+	fmt.Fprintln(w)
+	var err error
+	if dryRun {
+		_, err = fmt.Fprintf(w, "%d guest(s) kept, %d would be changed, %d skipped\n", s.Kept, s.Planned, s.Skipped)
+	} else {
+		_, err = fmt.Fprintf(w, "%d guest(s) kept, %d removed from channels, %d deactivated, %d failed, %d skipped\n", s.Kept, s.Removed, s.Deactivated, s.Failed, s.Skipped)
+	}
+	return err
+}
+
+// formatMemberships shows the membership count of a remove decision, and
+// nothing for the others.
+func formatMemberships(o ReviewOutcome) string {
+	if o.Decision != DecisionRemove || o.Status == ReviewSkipped {
+		return ""
+	}
+	return fmt.Sprintf("%d", o.Memberships)
+}
+
+func writeReviewOutcomesCSV(w io.Writer, outcomes []ReviewOutcome, op Operator) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	if err := cw.Write([]string{"username", "decision", "justification", "status", "memberships", "reason", "operator_id", "operator"}); err != nil {
+		return err
+	}
+	for _, o := range outcomes {
+		if err := cw.Write([]string{o.Username, o.Decision, o.Justification, o.Status, formatMemberships(o), o.Reason, op.UserID, op.Username}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeReviewOutcomesJSON(w io.Writer, outcomes []ReviewOutcome, dryRun bool, op Operator) error {
+	if outcomes == nil {
+		outcomes = []ReviewOutcome{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		DryRun   bool            `json:"dry_run"`
+		Operator Operator        `json:"operator"`
+		Summary  ReviewSummary   `json:"summary"`
+		Outcomes []ReviewOutcome `json:"outcomes"`
+	}{dryRun, op, SummarizeReview(outcomes), outcomes})
+}
+
+// runReviewApply carries out the plan of a review: after confirmation it
+// writes an undo plan per action, applies the decisions and narrows the
+// undo plans to what was done, then writes the outcomes in place of a
+// report. With dryRun it only lists them.
+func runReviewApply(client MattermostClient, plan *ReviewPlan, server, undoFile string, dryRun bool, confirm Confirmation, format, output string, retry RetryPolicy, verbose bool) int {
+	op := client.ServerInfo().Operator()
+	exitCode := ExitSuccess
+	remove, deactivate := plan.Pending()
+	if !dryRun && remove+deactivate > 0 {
+		if !confirm.Typed(func(r io.Reader, w io.Writer) bool { return ConfirmReview(r, w, remove, deactivate) }) {
+			logger.Printf("error: review not confirmed. Nothing was changed.")
+			return ExitConfigError
+		}
+		// Like --remove-from-channels and --deactivate-expired, the undo
+		// plans are written before anything changes
+		now := time.Now()
+		channelsPath, accountsPath := ReviewUndoFiles(undoFile, now)
+		if remove > 0 {
+			if err := WriteUndoPlan(NewUndoPlan(server, op, plan.Removals, true, now), channelsPath); err != nil {
+				logger.Printf("error: unable to write undo plan %q: %v. Nothing was changed.", channelsPath, err)
+				return ExitOutputError
+			}
+		}
+		if deactivate > 0 {
+			if err := WriteUndoPlan(NewReactivationPlan(server, op, plan.Deactivations, true, now), accountsPath); err != nil {
+				logger.Printf("error: unable to write undo plan %q: %v. Nothing was changed.", accountsPath, err)
+				return ExitOutputError
+			}
+		}
+		exitCode = plan.Apply(client, retry, verbose)
+		if remove > 0 {
+			if err := WriteUndoPlan(NewUndoPlan(server, op, plan.Removals, false, time.Now()), channelsPath); err != nil {
+				logger.Printf("Warning: unable to update undo plan %q: %v — it still lists every planned removal", channelsPath, err)
+			}
+			logger.Printf("Undo plan written to %s. To add the memberships back: mm-guest-audit undo --plan %s", channelsPath, channelsPath)
+		}
+		if deactivate > 0 {
+			if err := WriteUndoPlan(NewReactivationPlan(server, op, plan.Deactivations, false, time.Now()), accountsPath); err != nil {
+				logger.Printf("Warning: unable to update undo plan %q: %v — it still lists every planned deactivation", accountsPath, err)
+			}
+			logger.Printf("Undo plan written to %s. To reactivate the accounts: mm-guest-audit undo --plan %s", accountsPath, accountsPath)
+		}
+	}
+	if err := WriteReviewOutcomes(plan.Outcomes, dryRun, op, format, output); err != nil {
+		logger.Printf("error: failed to write output: %v", err)
+		return ExitOutputError
+	}
+	s := SummarizeReview(plan.Outcomes)
+	if dryRun {
+		logger.Printf("Dry run: %d guest(s) would be changed, nothing was changed.", s.Planned)
+	} else {
+		logger.Printf("Removed %d guest(s) from their channels, deactivated %d, %d failed.", s.Removed, s.Deactivated, s.Failed)
+	}
+	return exitCode
+}
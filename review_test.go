@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReviewArgs(t *testing.T) {
+	action, path, rest, err := ParseReviewArgs([]string{"apply", "decisions.csv", "--dry-run"})
+	if err != nil || action != ReviewApply || path != "decisions.csv" || len(rest) != 1 || rest[0] != "--dry-run" {
+		t.Errorf("apply: got %q %q %v %v", action, path, rest, err)
+	}
+	if action, _, rest, err := ParseReviewArgs([]string{"export", "--format", "csv"}); err != nil || action != ReviewExport || len(rest) != 2 {
+		t.Errorf("export: got %q %v %v", action, rest, err)
+	}
+	for _, args := range [][]string{nil, {"apply"}, {"apply", "--dry-run"}, {"import"}} {
+		if _, _, _, err := ParseReviewArgs(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestWriteReviewSheet(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	result := &AuditResult{
+		ProfileFields: []string{"Company"},
+		Guests: []GuestRecord{{
+			UserID: "user0", Username: "alice", Email: "alice@partner.com", Position: "Consultant",
+			CreatedAt: &created, Active: true,
+			Teams:         []TeamInfo{{ID: "team1", DisplayName: "Engineering"}},
+			ProfileFields: map[string]string{"Company": "Partner Ltd"},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := writeReviewSheet(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := strings.Join(records[0], ",")
+	if !strings.HasPrefix(header, "user_id,username,") || !strings.HasSuffix(header, ",profile_company,decision,justification") {
+		t.Errorf("unexpected header: %s", header)
+	}
+	row := records[1]
+	if row[0] != "user0" || row[4] != "Consultant" || row[7] != "2024-03-01T12:00:00Z" || row[len(row)-3] != "Partner Ltd" || row[len(row)-2] != "" {
+		t.Errorf("unexpected row: %v", row)
+	}
+
+	// The written sheet reads back as undecided
+	buf.Reset()
+	writeReviewSheet(&buf, result)
+	decisions, err := ParseReviewDecisions(&buf)
+	if err != nil || len(decisions) != 1 || decisions[0].UserID != "user0" || decisions[0].Decision != "" {
+		t.Errorf("round trip: got %+v, %v", decisions, err)
+	}
+}
+
+func TestParseReviewDecisions(t *testing.T) {
+	sheet := "\ufeffUsername,User_ID,email,Decision,Justification\n" +
+		"alice,user0,a@x.com,Keep,Contract runs to 2027\n" +
+		"bob,user1,b@x.com,remove,\n" +
+		",,,,\n" +
+		"carol,user2,c@x.com,,\n"
+	decisions, err := ParseReviewDecisions(strings.NewReader(sheet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decisions) != 3 {
+		t.Fatalf("expected 3 decisions, got %+v", decisions)
+	}
+	if d := decisions[0]; d.UserID != "user0" || d.Decision != DecisionKeep || d.Justification != "Contract runs to 2027" || d.Line != 2 {
+		t.Errorf("unexpected first decision: %+v", d)
+	}
+	if d := decisions[2]; d.Username != "carol" || d.Decision != "" || d.Line != 5 {
+		t.Errorf("undecided row: %+v", d)
+	}
+
+	invalid := map[string]string{
+		"empty":          "",
+		"no decision":    "user_id,username\nuser0,alice\n",
+		"bad decision":   "user_id,username,decision\nuser0,alice,delete\n",
+		"no user id":     "user_id,username,decision\n,alice,keep\n",
+		"listed twice":   "user_id,username,decision\nuser0,alice,keep\nuser0,alice,remove\n",
+		"not a csv file": "user_id,username,decision\n\"user0,alice,keep\n",
+	}
+	for name, data := range invalid {
+		if _, err := ParseReviewDecisions(strings.NewReader(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	_, err = ParseReviewDecisions(strings.NewReader("user_id,username,decision\nuser0,alice,delete\n"))
+	if err == nil || !strings.Contains(err.Error(), `line 2: invalid decision "delete" for alice`) {
+		t.Errorf("bad decision: got %v", err)
+	}
+}
+
+func sampleReviewDecisions() []ReviewDecision {
+	return []ReviewDecision{
+		{UserID: "user0", Username: "inactive", Decision: DecisionRemove},
+		{UserID: "user1", Username: "active", Decision: DecisionKeep, Justification: "Still on the project"},
+		{UserID: "user2", Username: "excepted", Decision: DecisionDeactivate},
+		{UserID: "user3", Username: "deactivated", Decision: DecisionDeactivate},
+		{UserID: "user9", Username: "gone", Decision: DecisionRemove},
+		{UserID: "user4", Username: "undecided"},
+	}
+}
+
+func TestPlanReview(t *testing.T) {
+	plan := PlanReview(removalResult(), sampleReviewDecisions())
+	want := []struct{ status, reason string }{
+		{RemovalPlanned, ""},
+		{ReviewKept, ""},
+		{DeactivationPlanned, ""},
+		{DeactivationSkipped, "already deactivated"},
+		{ReviewSkipped, "not in this audit; use the same scope flags as the export"},
+		{ReviewSkipped, "no decision"},
+	}
+	for i, w := range want {
+		if o := plan.Outcomes[i]; o.Status != w.status || o.Reason != w.reason {
+			t.Errorf("%s: got %s %q, want %s %q", o.Username, o.Status, o.Reason, w.status, w.reason)
+		}
+	}
+	// The default channel is skipped and DMs are left alone, as for
+	// --remove-from-channels
+	if o := plan.Outcomes[0]; o.Memberships != 1 || len(plan.Removals) != 2 {
+		t.Errorf("remove: %d membership(s), removals %+v", o.Memberships, plan.Removals)
+	}
+	if remove, deactivate := plan.Pending(); remove != 1 || deactivate != 1 {
+		t.Errorf("pending = %d, %d; want 1, 1", remove, deactivate)
+	}
+
+	// Unreadable channels cannot be removed
+	result := removalResult()
+	result.Guests[0].Errors = []LookupError{{Stage: LookupChannels, Message: "timeout"}}
+	plan = PlanReview(result, sampleReviewDecisions()[:1])
+	if o := plan.Outcomes[0]; o.Status != ReviewSkipped || len(plan.Removals) != 0 {
+		t.Errorf("unreadable channels: %+v", o)
+	}
+}
+
+func TestReviewPlan_Apply(t *testing.T) {
+	client := &mockClient{deactivateErr: map[string]error{"user2": &APIError{StatusCode: 403, Message: "forbidden"}}}
+	plan := PlanReview(removalResult(), sampleReviewDecisions())
+	if exitCode := plan.Apply(client, RetryPolicy{}, false); exitCode != ExitPartialFailure {
+		t.Errorf("exit code = %d, want %d", exitCode, ExitPartialFailure)
+	}
+	if len(client.removed) != 1 || client.removed[0] != "ch2:user0" || len(client.deactivated) != 0 {
+		t.Errorf("removed %v, deactivated %v", client.removed, client.deactivated)
+	}
+	want := ReviewSummary{Kept: 1, Removed: 1, Failed: 1, Skipped: 3}
+	if got := SummarizeReview(plan.Outcomes); got != want {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+	if o := plan.Outcomes[2]; o.Status != DeactivationFailed || o.Reason != "forbidden" {
+		t.Errorf("failed deactivation: %+v", o)
+	}
+}
+
+func TestConfirmReview(t *testing.T) {
+	var prompt bytes.Buffer
+	if !ConfirmReview(strings.NewReader("apply\n"), &prompt, 2, 1) {
+		t.Error("apply should confirm")
+	}
+	if ConfirmReview(strings.NewReader("yes\n"), &prompt, 2, 1) {
+		t.Error("yes should not confirm")
+	}
+	if !strings.Contains(prompt.String(), "remove 2 guest(s) from their channels and deactivate 1 guest account(s)") {
+		t.Errorf("prompt = %q", prompt.String())
+	}
+}
+
+func TestReviewUndoFiles(t *testing.T) {
+	channels, accounts := ReviewUndoFiles("review-undo.json", time.Now())
+	if channels != "review-undo-channels.json" || accounts != "review-undo-accounts.json" {
+		t.Errorf("got %q, %q", channels, accounts)
+	}
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	if channels, _ := ReviewUndoFiles("", now); channels != "undo-20250601-090000-channels.json" {
+		t.Errorf("default: got %q", channels)
+	}
+}
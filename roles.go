@@ -0,0 +1,104 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// RoleGrant is a team or channel role a guest holds beyond the guest role,
+// such as channel_admin. Channel is empty for a team role.
+type RoleGrant struct {
+	TeamName    string `json:"team"`
+	ChannelName string `json:"channel,omitempty"`
+	Role        string `json:"role"`
+}
+
+// String renders the grant as team:role or team/channel:role.
+func (r RoleGrant) String() string {
+	if r.ChannelName == "" {
+		return r.TeamName + ":" + r.Role
+	}
+	return r.TeamName + "/" + r.ChannelName + ":" + r.Role
+}
+
+// extraRoles returns the roles in a membership other than guestRole, sorted.
+// The scheme flags are folded in, since a server may report scheme roles
+// only through them: a guest with SchemeUser set holds the member role.
+func extraRoles(roles string, schemeUser, schemeAdmin bool, guestRole, userRole, adminRole string) []string {
+	var extra []string
+	add := func(role string) {
+		if role != guestRole && !slices.Contains(extra, role) {
+			extra = append(extra, role)
+		}
+	}
+	for _, role := range strings.Fields(roles) {
+		add(role)
+	}
+	if schemeUser {
+		add(userRole)
+	}
+	if schemeAdmin {
+		add(adminRole)
+	}
+	sort.Strings(extra)
+	return extra
+}
+
+// ElevatedTeamRoles lists the roles beyond team_guest in the given team
+// memberships, for the teams named in teamNames (team ID → display name).
+// Memberships of other teams, and ones the guest has left, are ignored.
+func ElevatedTeamRoles(members []*model.TeamMember, teamNames map[string]string) []RoleGrant {
+	var grants []RoleGrant
+	for _, m := range members {
+		name, ok := teamNames[m.TeamId]
+		if !ok || m.DeleteAt != 0 {
+			continue
+		}
+		for _, role := range extraRoles(m.Roles, m.SchemeUser, m.SchemeAdmin, model.TeamGuestRoleId, model.TeamUserRoleId, model.TeamAdminRoleId) {
+			grants = append(grants, RoleGrant{TeamName: name, Role: role})
+		}
+	}
+	return grants
+}
+
+// ElevatedChannelRoles lists the roles beyond channel_guest in the given
+// channel memberships, for the channels in channels. Memberships of other
+// channels (outside a --channel filter, say) are ignored.
+func ElevatedChannelRoles(members []model.ChannelMember, channels []ChannelInfo) []RoleGrant {
+	byID := make(map[string]ChannelInfo, len(channels))
+	for _, ch := range channels {
+		byID[ch.ID] = ch
+	}
+	var grants []RoleGrant
+	for _, m := range members {
+		ch, ok := byID[m.ChannelId]
+		if !ok {
+			continue
+		}
+		for _, role := range extraRoles(m.Roles, m.SchemeUser, m.SchemeAdmin, model.ChannelGuestRoleId, model.ChannelUserRoleId, model.ChannelAdminRoleId) {
+			grants = append(grants, RoleGrant{TeamName: ch.TeamName, ChannelName: ch.ChannelName, Role: role})
+		}
+	}
+	return grants
+}
+
+func formatRoleGrants(grants []RoleGrant, sep string) string {
+	names := make([]string, len(grants))
+	for i, g := range grants {
+		names[i] = g.String()
+	}
+	return strings.Join(names, sep)
+}
+
+func filterRoleGrants(grants []RoleGrant, team string) []RoleGrant {
+	var out []RoleGrant
+	for _, g := range grants {
+		if strings.EqualFold(g.TeamName, team) {
+			out = append(out, g)
+		}
+	}
+	return out
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestElevatedTeamRoles(t *testing.T) {
+	teamNames := map[string]string{"team1": "Engineering", "team2": "Sales"}
+	tests := []struct {
+		name   string
+		member *model.TeamMember
+		want   string
+	}{
+		{"guest only", &model.TeamMember{TeamId: "team1", Roles: "team_guest", SchemeGuest: true}, ""},
+		{"team admin", &model.TeamMember{TeamId: "team1", Roles: "team_guest team_admin"}, "Engineering:team_admin"},
+		{"scheme user", &model.TeamMember{TeamId: "team2", SchemeGuest: true, SchemeUser: true}, "Sales:team_user"},
+		{"custom role", &model.TeamMember{TeamId: "team1", Roles: "team_guest partner_lead", SchemeAdmin: true}, "Engineering:partner_lead|Engineering:team_admin"},
+		{"other team", &model.TeamMember{TeamId: "team3", Roles: "team_admin"}, ""},
+		{"left team", &model.TeamMember{TeamId: "team1", Roles: "team_admin", DeleteAt: 1}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatRoleGrants(ElevatedTeamRoles([]*model.TeamMember{tt.member}, teamNames), "|")
+			if got != tt.want {
+				t.Errorf("ElevatedTeamRoles = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestElevatedChannelRoles(t *testing.T) {
+	channels := []ChannelInfo{
+		{ID: "ch1", TeamName: "Engineering", ChannelName: "Partners"},
+		{ID: "ch2", TeamName: "Engineering", ChannelName: "Town Square"},
+	}
+	members := []model.ChannelMember{
+		{ChannelId: "ch1", Roles: "channel_guest channel_admin"},
+		{ChannelId: "ch2", Roles: "channel_guest", SchemeGuest: true},
+		{ChannelId: "ch3", Roles: "channel_admin"}, // outside the reported channels
+	}
+	got := formatRoleGrants(ElevatedChannelRoles(members, channels), "|")
+	if got != "Engineering/Partners:channel_admin" {
+		t.Errorf("ElevatedChannelRoles = %q, want Engineering/Partners:channel_admin", got)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGroupBy(t *testing.T) {
+	for _, s := range []string{"", "owner"} {
+		if _, err := ParseGroupBy(s); err != nil {
+			t.Errorf("ParseGroupBy(%q): %v", s, err)
+		}
+	}
+	if _, err := ParseGroupBy("team"); err == nil {
+		t.Error("expected an error for --group-by team")
+	}
+}
+
+func TestWithOwnerField(t *testing.T) {
+	if got := WithOwnerField([]string{"company"}, "Sponsor"); strings.Join(got, ",") != "company,Sponsor" {
+		t.Errorf("added: %v", got)
+	}
+	if got := WithOwnerField([]string{"sponsor", "company"}, "Sponsor"); len(got) != 2 {
+		t.Errorf("already listed: %v", got)
+	}
+	if got := WithOwnerField(nil, ""); got != nil {
+		t.Errorf("no owner field: %v", got)
+	}
+}
+
+func ownedGuests() []GuestRecord {
+	owned := func(username, owner string, active, inactive bool) GuestRecord {
+		g := GuestRecord{Username: username, Active: active, Inactive: inactive}
+		if owner != "" {
+			g.ProfileFields = map[string]string{"Sponsor": owner}
+		}
+		return g
+	}
+	return []GuestRecord{
+		owned("ann", "zoe@example.com", true, false),
+		owned("bob", "", true, true),
+		owned("cat", "Mike@example.com", true, true),
+		owned("dan", "zoe@example.com", false, false),
+		owned("eve", "mike@example.com", true, false),
+		{Username: "member", Active: true, ShouldBeGuest: true, ProfileFields: map[string]string{"Sponsor": "zoe@example.com"}},
+	}
+}
+
+func TestGroupGuestsByOwner(t *testing.T) {
+	guests := ownedGuests()
+	GroupGuestsByOwner(guests, "sponsor")
+	var order []string
+	for _, g := range guests {
+		order = append(order, g.Username)
+	}
+	// Owners without regard to case, each keeping its guests' order, then
+	// the unowned
+	if got := strings.Join(order, ","); got != "cat,eve,ann,dan,member,bob" {
+		t.Errorf("order = %s", got)
+	}
+
+	guests = ownedGuests()
+	GroupGuestsByOwner(guests, "")
+	if guests[0].Username != "ann" || guests[1].Username != "bob" {
+		t.Error("guests reordered without an owner field")
+	}
+}
+
+func TestSummarize_Owners(t *testing.T) {
+	result := &AuditResult{Guests: ownedGuests(), OwnerField: "sponsor"}
+	summarize(result, nil, time.Now())
+	r := result.Summary.Owners
+	if r == nil || r.Field != "sponsor" {
+		t.Fatalf("owners = %+v", r)
+	}
+	zoe := r.ByOwner["zoe@example.com"]
+	if zoe == nil || *zoe != (TeamSummary{TotalGuests: 2, ActiveGuests: 1, DeactivatedGuests: 1}) {
+		t.Errorf("zoe = %+v, want 2 guests, the member left out", zoe)
+	}
+	if len(r.ByOwner) != 3 || r.Unowned != (TeamSummary{TotalGuests: 1, InactiveGuests: 1}) {
+		t.Errorf("by owner = %d owners, unowned %+v", len(r.ByOwner), r.Unowned)
+	}
+
+	var table strings.Builder
+	if err := writeTable(&table, result); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Guests by owner (sponsor):", "Mike@example.com", "(no owner)"} {
+		if !strings.Contains(table.String(), want) {
+			t.Errorf("table lacks %q:\n%s", want, table.String())
+		}
+	}
+
+	// Without --group-by owner there is no breakdown
+	result.OwnerField = ""
+	summarize(result, nil, time.Now())
+	if result.Summary.Owners != nil {
+		t.Errorf("owners without a field: %+v", result.Summary.Owners)
+	}
+}
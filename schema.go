@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// ReportSchemaVersion is the layout version of the JSON report, written as
+// schema_version. Reports without it predate versioning and are version 1.
+// Bump it when a field is removed, renamed or changes type; new optional
+// fields do not need a bump.
+const ReportSchemaVersion = 3
+
+// schemaEnums lists the allowed values of string types with a fixed set.
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(MetricLogin): {string(MetricLogin), string(MetricPost), string(MetricAny), string(MetricAll), string(MetricView)},
+}
+
+// ReportSchema returns a JSON Schema (draft 2020-12) for the --format json
+// report. It is generated from jsonOutput, so it cannot drift from what
+// writeJSON produces. Pointer, slice and map fields without omitempty may be
+// null; fields with omitempty are optional.
+func ReportSchema() map[string]any {
+	schema := schemaFor(reflect.TypeOf(jsonOutput{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "mm-guest-audit report"
+	props := schema["properties"].(map[string]any)
+	props["schema_version"] = map[string]any{"type": "integer", "const": ReportSchemaVersion}
+	return schema
+}
+
+// WriteSchema writes ReportSchema as indented JSON.
+func WriteSchema(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ReportSchema())
+}
+
+func schemaFor(t reflect.Type) map[string]any {
+	if values, ok := schemaEnums[t]; ok {
+		return map[string]any{"type": "string", "enum": values}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(schemaFor(t.Elem()))
+	case reflect.Struct:
+		return structSchema(t)
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
+
+func structSchema(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		omitempty := strings.Contains(","+opts+",", ",omitempty,")
+
+		prop := schemaFor(f.Type)
+		if format := f.Tag.Get("format"); format != "" {
+			if f.Type.Kind() == reflect.Pointer {
+				prop["anyOf"].([]any)[0].(map[string]any)["format"] = format
+			} else {
+				prop["format"] = format
+			}
+		}
+		switch f.Type.Kind() {
+		case reflect.Slice, reflect.Map:
+			if !omitempty {
+				prop = nullable(prop)
+			}
+		}
+		props[name] = prop
+		if !omitempty {
+			required = append(required, name)
+		}
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// nullable allows null in place of the value s describes.
+func nullable(s map[string]any) map[string]any {
+	return map[string]any{"anyOf": []any{s, map[string]any{"type": "null"}}}
+}
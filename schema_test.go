@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// validateSchema checks v against the subset of JSON Schema that ReportSchema
+// uses, returning the first violation.
+func validateSchema(schema map[string]any, v any, path string) error {
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		for _, s := range anyOf {
+			if validateSchema(s.(map[string]any), v, path) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: %v matches no alternative", path, v)
+	}
+	if c, ok := schema["const"]; ok && fmt.Sprint(c) != fmt.Sprint(v) {
+		return fmt.Errorf("%s: %v, want %v", path, v, c)
+	}
+	switch schema["type"] {
+	case "null":
+		if v != nil {
+			return fmt.Errorf("%s: %v, want null", path, v)
+		}
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: %v, want string", path, v)
+		}
+		if enum, ok := schema["enum"].([]string); ok && !slices.Contains(enum, s) {
+			return fmt.Errorf("%s: %q not in %v", path, s, enum)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: %v, want boolean", path, v)
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != float64(int64(n)) {
+			return fmt.Errorf("%s: %v, want integer", path, v)
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: %v, want array", path, v)
+		}
+		for i, item := range items {
+			if err := validateSchema(schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %v, want object", path, v)
+		}
+		required, _ := schema["required"].([]string)
+		for _, name := range required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required %q", path, name)
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for name, value := range obj {
+			prop, ok := props[name].(map[string]any)
+			if !ok {
+				additional, ok := schema["additionalProperties"].(map[string]any)
+				if !ok {
+					return fmt.Errorf("%s: unexpected property %q", path, name)
+				}
+				prop = additional
+			}
+			if err := validateSchema(prop, value, path+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestReportSchema_ValidatesReports(t *testing.T) {
+	full := sampleResult()
+	expires := time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)
+	fileCount, postCount := 3, 12
+	full.InactivityMetric = MetricAny
+	full.Deployment = DeploymentCloud
+	full.UnavailableEnrichment = []string{EnrichFileActivity}
+	full.PermissionMissing = []string{EnrichIdentityHistory}
+	full.ExtraFields = []ExtraField{{"environment", "prod"}}
+	full.Summary.AgeBuckets = NewAgeBuckets(DefaultAgeBuckets)
+	g := &full.Guests[0]
+	g.ExceptionJustification = "Support contract"
+	g.ExceptionExpires = &expires
+	g.LastFileUpload = &expires
+	g.FileCount = &fileCount
+	g.PostCount = &postCount
+	g.Boards = []ResourceInfo{{TeamName: "Engineering", Name: "Roadmap"}}
+	g.PermissionMissing = []string{"previous_usernames"}
+
+	tests := []struct {
+		name   string
+		result *AuditResult
+	}{
+		{"sample", sampleResult()},
+		{"every optional field", full},
+		{"no guests", &AuditResult{}},
+	}
+	schema := ReportSchema()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeJSON(&buf, tt.result); err != nil {
+				t.Fatalf("writeJSON error: %v", err)
+			}
+			var report any
+			if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if err := validateSchema(schema, report, "$"); err != nil {
+				t.Errorf("report does not match schema: %v", err)
+			}
+		})
+	}
+}
+
+func TestReportSchema_RejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		report string
+	}{
+		{"wrong version", `{"schema_version": 1, "summary": {}, "inactive_days": 0, "guests": []}`},
+		{"unknown field", `{"schema_version": 2, "summary": {}, "inactive_days": 0, "guests": [], "extra": 1}`},
+		{"missing guests", `{"schema_version": 2, "summary": {}, "inactive_days": 0}`},
+		{"bad metric", `{"schema_version": 2, "summary": {}, "inactive_days": 0, "guests": [], "inactivity_metric": "never"}`},
+	}
+	schema := ReportSchema()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var report any
+			if err := json.Unmarshal([]byte(tt.report), &report); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if validateSchema(schema, report, "$") == nil {
+				t.Error("expected a schema violation")
+			}
+		})
+	}
+}
+
+func TestWriteSchema(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSchema(&buf); err != nil {
+		t.Fatalf("WriteSchema error: %v", err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	version := schema["properties"].(map[string]any)["schema_version"].(map[string]any)
+	if version["const"] != float64(ReportSchemaVersion) {
+		t.Errorf("schema_version const = %v, want %d", version["const"], ReportSchemaVersion)
+	}
+}
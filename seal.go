@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// SealOptions describes how finished report files are sealed so that later
+// changes can be detected.
+type SealOptions struct {
+	// Checksum writes <file>.sha256 in sha256sum format.
+	Checksum bool
+	// SignKey, when set, writes an ASCII-armoured detached signature
+	// <file>.asc using gpg and this key ID.
+	SignKey string
+}
+
+// Enabled reports whether any sealing was requested.
+func (s SealOptions) Enabled() bool {
+	return s.Checksum || s.SignKey != ""
+}
+
+// Seal checksums and/or signs each file in paths.
+func (s SealOptions) Seal(paths []string) error {
+	for _, path := range paths {
+		if s.Checksum {
+			if err := WriteChecksumFile(path); err != nil {
+				return err
+			}
+		}
+		if s.SignKey != "" {
+			if err := SignFile(path, s.SignKey); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteChecksumFile writes the SHA-256 of path to path.sha256. The file
+// names path by its base name, so `sha256sum -c` works from its directory.
+func WriteChecksumFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("checksum %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("checksum %s: %w", path, err)
+	}
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(h.Sum(nil)), filepath.Base(path))
+	if err := os.WriteFile(path+".sha256", []byte(line), 0o644); err != nil {
+		return fmt.Errorf("checksum %s: %w", path, err)
+	}
+	return nil
+}
+
+// gpgCommand is the signing program; tests replace it.
+var gpgCommand = "gpg"
+
+// SignFile writes an ASCII-armoured detached signature of path to path.asc,
+// signed with keyID. The key must be usable without a passphrase prompt,
+// e.g. through gpg-agent.
+func SignFile(path, keyID string) error {
+	cmd := exec.Command(gpgCommand, "--batch", "--yes", "--armor", "--local-user", keyID,
+		"--output", path+".asc", "--detach-sign", path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("sign %s: %w: %s", path, err, msg)
+		}
+		return fmt.Errorf("sign %s: %w", path, err)
+	}
+	return nil
+}
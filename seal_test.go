@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWriteChecksumFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "guests.csv")
+	content := []byte("username\njane.doe\n")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteChecksumFile(path); err != nil {
+		t.Fatalf("WriteChecksumFile error: %v", err)
+	}
+	got, err := os.ReadFile(path + ".sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	want := hex.EncodeToString(sum[:]) + "  guests.csv\n"
+	if string(got) != want {
+		t.Errorf("sum file = %q, want %q", got, want)
+	}
+
+	if err := WriteChecksumFile(filepath.Join(dir, "missing.csv")); err == nil {
+		t.Error("expected error for a missing file")
+	}
+}
+
+func TestSealOptions_Sign(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script in place of gpg")
+	}
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	fakeGPG := filepath.Join(dir, "gpg")
+	script := "#!/bin/sh\necho \"$@\" > " + argsFile + "\n"
+	if err := os.WriteFile(fakeGPG, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(orig string) { gpgCommand = orig }(gpgCommand)
+	gpgCommand = fakeGPG
+
+	report := filepath.Join(dir, "guests.json")
+	if err := os.WriteFile(report, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	seal := SealOptions{Checksum: true, SignKey: "audit@example.com"}
+	if err := seal.Seal([]string{report}); err != nil {
+		t.Fatalf("Seal error: %v", err)
+	}
+
+	if _, err := os.Stat(report + ".sha256"); err != nil {
+		t.Errorf("sum file not written: %v", err)
+	}
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("gpg was not run: %v", err)
+	}
+	for _, want := range []string{"--local-user audit@example.com", "--output " + report + ".asc", "--detach-sign " + report} {
+		if !strings.Contains(string(args), want) {
+			t.Errorf("gpg args %q missing %q", args, want)
+		}
+	}
+
+	gpgCommand = "false"
+	if err := SignFile(report, "audit@example.com"); err == nil {
+		t.Error("expected error when gpg fails")
+	}
+}
+
+func TestOutputDirFiles(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"csv", "out/guests.csv,out/teams.csv,out/metadata.csv"},
+		{"json", "out/guests.json"},
+		{"table", "out/guests.txt"},
+	}
+	for _, tt := range tests {
+		got := filepath.ToSlash(strings.Join(OutputDirFiles(tt.format, "out"), ","))
+		if got != tt.want {
+			t.Errorf("OutputDirFiles(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server exposes the audit over HTTP for `mm-guest-audit serve`. Only one
+// audit runs at a time; a request arriving while one is in progress gets 409.
+type Server struct {
+	client MattermostClient
+	opts   AuditOptions
+	token  string
+
+	running sync.Mutex
+
+	mu           sync.Mutex // guards the fields below
+	runs         int
+	failures     int
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastExitCode int
+	last         *AuditResult
+}
+
+// NewServer returns a server that audits with client and opts. Requests to
+// /audit and /metrics must carry "Authorization: Bearer <token>".
+func NewServer(client MattermostClient, opts AuditOptions, token string) *Server {
+	return &Server{client: client, opts: opts, token: token}
+}
+
+// Handler returns the HTTP routes: /healthz (unauthenticated), /audit and /metrics.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/audit", s.requireToken(s.handleAudit))
+	mux.HandleFunc("/metrics", s.requireToken(s.handleMetrics))
+	return mux
+}
+
+func (s *Server) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleAudit runs a fresh audit and returns it in the --format json layout.
+// The audit's exit code is reported in the X-Audit-Exit-Code header, so a
+// partial failure still returns 200 with the guests that could be audited.
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET or POST")
+		return
+	}
+	if !s.running.TryLock() {
+		writeJSONError(w, http.StatusConflict, "an audit is already running")
+		return
+	}
+	defer s.running.Unlock()
+
+	// Guests unchanged since the last successful run keep their records
+	opts := s.opts
+	s.mu.Lock()
+	opts.Previous = s.last
+	s.mu.Unlock()
+
+	started := time.Now()
+	result, exitCode := RunAudit(s.client, opts)
+	s.record(started, time.Since(started), result, exitCode)
+
+	w.Header().Set("X-Audit-Exit-Code", fmt.Sprintf("%d", exitCode))
+	if result == nil {
+		writeJSONError(w, http.StatusBadGateway, fmt.Sprintf("audit failed (exit code %d)", exitCode))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, result); err != nil {
+		logger.Printf("serve: failed to write /audit response: %v", err)
+	}
+}
+
+func (s *Server) record(started time.Time, took time.Duration, result *AuditResult, exitCode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs++
+	if result == nil {
+		s.failures++
+	} else {
+		s.last = result
+	}
+	s.lastRun = started
+	s.lastDuration = took
+	s.lastExitCode = exitCode
+}
+
+// handleMetrics writes counters and the last successful run's summary in the
+// Prometheus text exposition format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metric := func(name, help, kind string, value any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+	metric("mm_guest_audit_runs_total", "Audits run since the server started.", "counter", s.runs)
+	metric("mm_guest_audit_run_failures_total", "Audits that returned no result.", "counter", s.failures)
+	if s.runs == 0 {
+		return
+	}
+	metric("mm_guest_audit_last_run_timestamp_seconds", "Start time of the last audit.", "gauge", s.lastRun.Unix())
+	metric("mm_guest_audit_last_run_duration_seconds", "Duration of the last audit.", "gauge", s.lastDuration.Seconds())
+	metric("mm_guest_audit_last_exit_code", "Exit code of the last audit.", "gauge", s.lastExitCode)
+	if s.last == nil {
+		return
+	}
+
+	sum := s.last.Summary
+	fmt.Fprintln(w, "# HELP mm_guest_audit_guests Guests by status in the last successful audit.")
+	fmt.Fprintln(w, "# TYPE mm_guest_audit_guests gauge")
+	for _, m := range []struct {
+		status string
+		count  int
+	}{
+		{"active", sum.ActiveGuests},
+		{"inactive", sum.InactiveGuests},
+		{"deactivated", sum.DeactivatedGuests},
+		{"excepted", sum.ExceptedGuests},
+	} {
+		fmt.Fprintf(w, "mm_guest_audit_guests{status=%q} %d\n", m.status, m.count)
+	}
+	metric("mm_guest_audit_guests_total", "Guests in the last successful audit.", "gauge", sum.TotalGuests)
+	metric("mm_guest_audit_failed_lookups", "Guests whose lookup failed in the last successful audit.", "gauge", sum.FailedLookups)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
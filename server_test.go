@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func newTestServer(client *mockClient) *httptest.Server {
+	return httptest.NewServer(NewServer(client, AuditOptions{InactiveDays: 30}, "s3cret").Handler())
+}
+
+func serverGet(t *testing.T, url, token string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func readMetrics(t *testing.T, baseURL string) string {
+	t.Helper()
+	data, err := io.ReadAll(serverGet(t, baseURL+"/metrics", "s3cret").Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestServer_Auth(t *testing.T) {
+	ts := newTestServer(&mockClient{})
+	defer ts.Close()
+
+	tests := []struct {
+		path  string
+		token string
+		want  int
+	}{
+		{"/healthz", "", http.StatusOK},
+		{"/audit", "", http.StatusUnauthorized},
+		{"/audit", "wrong", http.StatusUnauthorized},
+		{"/metrics", "", http.StatusUnauthorized},
+		{"/metrics", "s3cret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path+"/"+tt.token, func(t *testing.T) {
+			if got := serverGet(t, ts.URL+tt.path, tt.token).StatusCode; got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Audit(t *testing.T) {
+	now := time.Now()
+	client := &mockClient{
+		guests: []*model.User{
+			{Id: "user1", Username: "jane.doe", CreateAt: 1709280000000, LastActivityAt: now.AddDate(0, 0, -2).UnixMilli()},
+			{Id: "user2", Username: "bob.contractor", CreateAt: 1709280000000},
+		},
+	}
+	ts := newTestServer(client)
+	defer ts.Close()
+
+	resp := serverGet(t, ts.URL+"/audit", "s3cret")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-Audit-Exit-Code"); got != "0" {
+		t.Errorf("X-Audit-Exit-Code = %q, want 0", got)
+	}
+	var out jsonOutput
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out.Summary.TotalGuests != 2 || out.Summary.InactiveGuests != 1 {
+		t.Errorf("summary = %+v, want 2 guests, 1 inactive", out.Summary)
+	}
+
+	body := readMetrics(t, ts.URL)
+	for _, want := range []string{
+		"mm_guest_audit_runs_total 1\n",
+		"mm_guest_audit_guests{status=\"inactive\"} 1\n",
+		"mm_guest_audit_guests_total 2\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestServer_AuditFailure(t *testing.T) {
+	ts := newTestServer(&mockClient{guestsErr: errors.New("connection refused")})
+	defer ts.Close()
+
+	resp := serverGet(t, ts.URL+"/audit", "s3cret")
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadGateway)
+	}
+	if got := resp.Header.Get("X-Audit-Exit-Code"); got != "2" {
+		t.Errorf("X-Audit-Exit-Code = %q, want 2", got)
+	}
+
+	if body := readMetrics(t, ts.URL); !strings.Contains(body, "mm_guest_audit_run_failures_total 1\n") {
+		t.Errorf("metrics should count the failed run:\n%s", body)
+	}
+}
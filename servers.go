@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ServerEntry is one Mattermost server in a --servers file.
+type ServerEntry struct {
+	// Name labels the server's guests in the server column and names its
+	// report with --split-by server. It defaults to the URL's host name.
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Token is the server's personal access token. TokenEnv instead names
+	// an environment variable holding it, keeping tokens out of the file.
+	// With neither, the token saved for URL by login is used.
+	Token    string `yaml:"token"`
+	TokenEnv string `yaml:"token_env"`
+}
+
+// serversFile is the layout of a --servers file.
+type serversFile struct {
+	Servers []ServerEntry `yaml:"servers"`
+}
+
+// LoadServers reads and validates a --servers file.
+func LoadServers(path string) ([]ServerEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseServers(data)
+}
+
+// ParseServers parses --servers YAML (or JSON) content. Names and URLs must
+// be unique, so no server is audited twice or hidden behind another's label.
+func ParseServers(data []byte) ([]ServerEntry, error) {
+	var f serversFile
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, err
+	}
+	if len(f.Servers) == 0 {
+		return nil, errors.New("no servers listed")
+	}
+	names := make(map[string]int)
+	urls := make(map[string]int)
+	for i := range f.Servers {
+		s := &f.Servers[i]
+		s.URL = NormalizeURL(strings.TrimSpace(s.URL))
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("server %d: invalid url %q (use an http or https URL)", i+1, s.URL)
+		}
+		if s.Token != "" && s.TokenEnv != "" {
+			return nil, fmt.Errorf("server %d: set token or token_env, not both", i+1)
+		}
+		s.Name = strings.TrimSpace(s.Name)
+		if s.Name == "" {
+			s.Name = u.Hostname()
+		}
+		if j, ok := names[strings.ToLower(s.Name)]; ok {
+			return nil, fmt.Errorf("server %d: name %q is already used by server %d", i+1, s.Name, j)
+		}
+		if j, ok := urls[strings.ToLower(s.URL)]; ok {
+			return nil, fmt.Errorf("server %d: url %q is already listed as server %d", i+1, s.URL, j)
+		}
+		names[strings.ToLower(s.Name)] = i + 1
+		urls[strings.ToLower(s.URL)] = i + 1
+	}
+	return f.Servers, nil
+}
+
+// ResolveToken returns the server's token: Token, the TokenEnv variable, or
+// the token saved for the URL by login, in that order.
+func (s ServerEntry) ResolveToken() (string, error) {
+	switch {
+	case s.Token != "":
+		return s.Token, nil
+	case s.TokenEnv != "":
+		if token := os.Getenv(s.TokenEnv); token != "" {
+			return token, nil
+		}
+		return "", fmt.Errorf("error: %s: environment variable %s is not set", s.Name, s.TokenEnv)
+	}
+	token, err := KeyringGet(s.URL)
+	if errors.Is(err, errNoStoredToken) {
+		return "", fmt.Errorf("error: %s: no token. Set token or token_env in the servers file, or save one with mm-guest-audit login --url %s", s.Name, s.URL)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error: %s: %w", s.Name, err)
+	}
+	return token, nil
+}
+
+// ServerRun is the outcome of auditing one server of a --servers run.
+// Result is nil when the server could not be reached or audited.
+type ServerRun struct {
+	Server   ServerEntry
+	Result   *AuditResult
+	ExitCode int
+}
+
+// RunServers audits each server in turn with the same options. connect
+// opens the client for a server. A server that cannot be connected to or
+// audited is reported and skipped, so one unreachable cluster does not
+// lose the others' reports; its exit code is kept in its run.
+func RunServers(servers []ServerEntry, opts AuditOptions, connect func(ServerEntry) (MattermostClient, error)) []ServerRun {
+	runs := make([]ServerRun, 0, len(servers))
+	for _, s := range servers {
+		if opts.Verbose {
+			logger.Printf("Auditing %s (%s)", s.Name, s.URL)
+		}
+		client, err := connect(s)
+		if err != nil {
+			logger.Printf("%v", err)
+			logger.Printf("Warning: skipping %s; its guests are not in the report", s.Name)
+			runs = append(runs, ServerRun{Server: s, ExitCode: ExitConfigError})
+			continue
+		}
+		result, code := RunAudit(client, opts)
+		if result == nil {
+			logger.Printf("Warning: the audit of %s failed (exit code %d); its guests are not in the report", s.Name, code)
+		}
+		runs = append(runs, ServerRun{Server: s, Result: result, ExitCode: code})
+	}
+	return runs
+}
+
+// AuditedServer is one server in a merged --servers report.
+type AuditedServer struct {
+	Name     string
+	Metadata *RunMetadata
+}
+
+// ServersExitCode is the exit code of a --servers run: the first failing
+// server's code, or ExitSuccess when every server succeeded.
+func ServersExitCode(runs []ServerRun) int {
+	for _, r := range runs {
+		if r.ExitCode != ExitSuccess {
+			return r.ExitCode
+		}
+	}
+	return ExitSuccess
+}
+
+// MergeResults combines the reports of a --servers run into one, with each
+// guest's Server set to its server's name. Guests keep the order of the
+// servers file unless opts.Sort orders them, and the summary is recounted
+// over every server. It returns nil if no server was audited.
+func MergeResults(runs []ServerRun, opts AuditOptions, now time.Time) *AuditResult {
+	var merged *AuditResult
+	licensed := true
+	for _, r := range runs {
+		if r.Result == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &AuditResult{
+				InactiveDays:     r.Result.InactiveDays,
+				GuestRoles:       r.Result.GuestRoles,
+				MaxGuestAge:      r.Result.MaxGuestAge,
+				MaxPasswordAge:   r.Result.MaxPasswordAge,
+				InactivityMetric: r.Result.InactivityMetric,
+				Deployment:       r.Result.Deployment,
+				ExtraFields:      r.Result.ExtraFields,
+				ProfileFields:    r.Result.ProfileFields,
+			}
+		}
+		if merged.Deployment != r.Result.Deployment {
+			merged.Deployment = ""
+		}
+		for _, name := range r.Result.UnavailableEnrichment {
+			if !slices.Contains(merged.UnavailableEnrichment, name) {
+				merged.UnavailableEnrichment = append(merged.UnavailableEnrichment, name)
+			}
+		}
+		for _, name := range r.Result.PermissionMissing {
+			if !slices.Contains(merged.PermissionMissing, name) {
+				merged.PermissionMissing = append(merged.PermissionMissing, name)
+			}
+		}
+		// Seats are only totalled when every server reported its license
+		if r.Result.LicensedSeats == 0 {
+			licensed = false
+		}
+		merged.LicensedSeats += r.Result.LicensedSeats
+		for _, g := range r.Result.Guests {
+			g.Server = r.Server.Name
+			merged.Guests = append(merged.Guests, g)
+		}
+		merged.Servers = append(merged.Servers, AuditedServer{Name: r.Server.Name, Metadata: r.Result.Metadata})
+	}
+	if merged == nil {
+		return nil
+	}
+	if !licensed {
+		merged.LicensedSeats = 0
+	}
+	merged.Metadata = mergeMetadata(runs)
+	SortGuests(merged.Guests, opts.Sort)
+	GroupGuestsByOwner(merged.Guests, opts.OwnerField)
+	merged.OwnerField = opts.OwnerField
+	summarize(merged, opts.AgeBuckets, now)
+	return merged
+}
+
+// mergeMetadata describes a --servers run: every audited server's URL and
+// account, the earliest start and latest finish, and the total API calls.
+// Filters are the same for every server. A version is only given when every
+// server runs the same one.
+func mergeMetadata(runs []ServerRun) *RunMetadata {
+	var m *RunMetadata
+	var urls, accounts, versions []string
+	for _, r := range runs {
+		if r.Result == nil || r.Result.Metadata == nil {
+			continue
+		}
+		rm := r.Result.Metadata
+		if m == nil {
+			m = &RunMetadata{ToolVersion: rm.ToolVersion, StartedAt: rm.StartedAt, FinishedAt: rm.FinishedAt, Filters: rm.Filters}
+		}
+		if rm.StartedAt.Before(m.StartedAt) {
+			m.StartedAt = rm.StartedAt
+		}
+		if rm.FinishedAt.After(m.FinishedAt) {
+			m.FinishedAt = rm.FinishedAt
+		}
+		m.APICalls += rm.APICalls
+		urls = append(urls, rm.ServerURL)
+		if !slices.Contains(accounts, rm.RunBy) {
+			accounts = append(accounts, rm.RunBy)
+		}
+		if !slices.Contains(versions, rm.ServerVersion) {
+			versions = append(versions, rm.ServerVersion)
+		}
+	}
+	if m == nil {
+		return nil
+	}
+	m.ServerURL = strings.Join(urls, ", ")
+	m.RunBy = strings.Join(accounts, ", ")
+	if len(versions) == 1 {
+		m.ServerVersion = versions[0]
+	}
+	return m
+}
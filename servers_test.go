@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseServers(t *testing.T) {
+	servers, err := ParseServers([]byte(`
+servers:
+  - name: prod
+    url: https://chat.example.com/
+    token_env: MM_PROD_TOKEN
+  - url: https://staging.example.com:8065
+    token: abc
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("expected 2 servers, got %d", len(servers))
+	}
+	if servers[0].Name != "prod" || servers[0].URL != "https://chat.example.com" || servers[0].TokenEnv != "MM_PROD_TOKEN" {
+		t.Errorf("unexpected first server: %+v", servers[0])
+	}
+	// The name defaults to the host name, without the port
+	if servers[1].Name != "staging.example.com" || servers[1].Token != "abc" {
+		t.Errorf("unexpected second server: %+v", servers[1])
+	}
+
+	invalid := map[string]string{
+		"empty":          `servers: []`,
+		"unknown field":  "servers:\n  - url: https://a.example.com\n    password: x",
+		"no url":         "servers:\n  - name: a",
+		"not http":       "servers:\n  - url: ftp://a.example.com",
+		"both tokens":    "servers:\n  - url: https://a.example.com\n    token: x\n    token_env: Y",
+		"duplicate name": "servers:\n  - {name: Prod, url: https://a.example.com}\n  - {name: prod, url: https://b.example.com}",
+		"duplicate url":  "servers:\n  - {name: a, url: https://a.example.com}\n  - {name: b, url: https://a.example.com/}",
+	}
+	for name, data := range invalid {
+		if _, err := ParseServers([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestServerEntry_ResolveToken(t *testing.T) {
+	t.Setenv("MM_TEST_SERVER_TOKEN", "from-env")
+
+	if got, err := (ServerEntry{Token: "direct"}).ResolveToken(); err != nil || got != "direct" {
+		t.Errorf("token: got %q, %v", got, err)
+	}
+	if got, err := (ServerEntry{TokenEnv: "MM_TEST_SERVER_TOKEN"}).ResolveToken(); err != nil || got != "from-env" {
+		t.Errorf("token_env: got %q, %v", got, err)
+	}
+	_, err := (ServerEntry{Name: "prod", TokenEnv: "MM_TEST_SERVER_TOKEN_UNSET"}).ResolveToken()
+	if err == nil || !strings.Contains(err.Error(), "MM_TEST_SERVER_TOKEN_UNSET is not set") {
+		t.Errorf("unset token_env: got %v", err)
+	}
+}
+
+func TestRunServers_Merge(t *testing.T) {
+	started := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	clients := map[string]*mockClient{
+		"prod":    {guests: sampleGuests(2), serverInfo: ServerInfo{URL: "https://prod.example.com", Username: "admin", Version: "9.11.0", LicensedSeats: 100}},
+		"partner": {guests: sampleGuests(1), serverInfo: ServerInfo{URL: "https://partner.example.com", Username: "audit-bot", Version: "10.0.0", LicensedSeats: 50}},
+	}
+	servers := []ServerEntry{{Name: "prod"}, {Name: "staging"}, {Name: "partner"}}
+	connect := func(s ServerEntry) (MattermostClient, error) {
+		if c, ok := clients[s.Name]; ok {
+			return c, nil
+		}
+		return nil, errors.New("error: unable to connect")
+	}
+
+	runs := RunServers(servers, AuditOptions{}, connect)
+	if len(runs) != 3 || runs[1].Result != nil || runs[1].ExitCode != ExitConfigError {
+		t.Fatalf("unreachable staging should be kept as a failed run: %+v", runs)
+	}
+	if code := ServersExitCode(runs); code != ExitConfigError {
+		t.Errorf("exit code = %d, want %d", code, ExitConfigError)
+	}
+	for i := range runs {
+		if m := runs[i].Result; m != nil {
+			m.Metadata.StartedAt = started.Add(time.Duration(i) * time.Minute)
+			m.Metadata.FinishedAt = m.Metadata.StartedAt.Add(30 * time.Second)
+		}
+	}
+
+	merged := MergeResults(runs, AuditOptions{}, time.Now())
+	if len(merged.Guests) != 3 || merged.Summary.TotalGuests != 3 {
+		t.Fatalf("expected 3 guests across servers, got %d", len(merged.Guests))
+	}
+	if merged.Guests[0].Server != "prod" || merged.Guests[1].Server != "prod" || merged.Guests[2].Server != "partner" {
+		t.Errorf("guests not labelled in server order: %q %q %q", merged.Guests[0].Server, merged.Guests[1].Server, merged.Guests[2].Server)
+	}
+	if merged.LicensedSeats != 150 || *merged.Summary.License.LicensedSeats != 150 {
+		t.Errorf("licensed seats = %d, want 150", merged.LicensedSeats)
+	}
+
+	m := merged.Metadata
+	if m.ServerURL != "https://prod.example.com, https://partner.example.com" || m.RunBy != "admin, audit-bot" || m.ServerVersion != "" {
+		t.Errorf("unexpected merged metadata: %+v", m)
+	}
+	if !m.StartedAt.Equal(started) || !m.FinishedAt.Equal(started.Add(2*time.Minute+30*time.Second)) {
+		t.Errorf("merged run spans %s to %s", m.StartedAt, m.FinishedAt)
+	}
+	if len(merged.Servers) != 2 || merged.Servers[1].Metadata.ServerURL != "https://partner.example.com" {
+		t.Errorf("unexpected servers: %+v", merged.Servers)
+	}
+
+	// The merged CSV leads with the server column; a single-server report has none
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, merged); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records[0][0] != "server" || records[1][0] != "prod" || records[3][0] != "partner" || records[3][1] != "guest0" {
+		t.Errorf("unexpected merged CSV: %v", records)
+	}
+	buf.Reset()
+	if err := writeCSV(&buf, runs[0].Result); err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasPrefix(buf.String(), "server,") {
+		t.Error("a single-server report should have no server column")
+	}
+}
+
+func TestMergeResults_NoServers(t *testing.T) {
+	runs := []ServerRun{{Server: ServerEntry{Name: "prod"}, ExitCode: ExitAPIError}}
+	if merged := MergeResults(runs, AuditOptions{}, time.Now()); merged != nil {
+		t.Errorf("expected no report when every server failed, got %+v", merged)
+	}
+}
+
+func TestMergeResults_UnknownSeats(t *testing.T) {
+	runs := []ServerRun{
+		{Server: ServerEntry{Name: "a"}, Result: &AuditResult{LicensedSeats: 100}},
+		{Server: ServerEntry{Name: "b"}, Result: &AuditResult{}}, // no license
+	}
+	merged := MergeResults(runs, AuditOptions{}, time.Now())
+	if merged.LicensedSeats != 0 || merged.Summary.License.LicensedSeats != nil {
+		t.Errorf("seats should be unknown when a server has no license, got %d", merged.LicensedSeats)
+	}
+}
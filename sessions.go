@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Networks are compared at these prefix lengths: two addresses in the same
+// /16 (IPv4) or /32 (IPv6) are treated as the same site or provider.
+const (
+	sharedSessionIPv4Prefix = 16
+	sharedSessionIPv6Prefix = 32
+)
+
+// SharedSessionIPs returns the IP addresses of a guest's sessions that
+// suggest the account is shared: two sign-ins in use at the same time from
+// different networks on the same kind of device (two browsers or two phones).
+// A phone and a laptop on different networks are one person's normal use and
+// are not counted. Sessions are matched to an IP through the audit records
+// made in them; sessions with no recorded IP, expired sessions and
+// integration sessions (access tokens, OAuth apps) are ignored. The result
+// is sorted, and empty when nothing suggests sharing.
+func SharedSessionIPs(sessions []*model.Session, audits []model.Audit, now time.Time) []string {
+	// The newest audit record carrying a session's IP wins
+	ips := make(map[string]string)
+	latest := make(map[string]int64)
+	for _, a := range audits {
+		if a.SessionId == "" || net.ParseIP(a.IpAddress) == nil {
+			continue
+		}
+		if t, ok := latest[a.SessionId]; !ok || a.CreateAt > t {
+			ips[a.SessionId] = a.IpAddress
+			latest[a.SessionId] = a.CreateAt
+		}
+	}
+
+	type session struct {
+		ip       string
+		network  string
+		mobile   bool
+		from, to int64
+	}
+	var live []session
+	for _, s := range sessions {
+		if s.ExpiresAt != 0 && s.ExpiresAt < now.UnixMilli() {
+			continue
+		}
+		if s.IsIntegration() {
+			continue
+		}
+		ip, ok := ips[s.Id]
+		if !ok {
+			continue
+		}
+		live = append(live, session{ip, ipNetwork(ip), s.IsMobileApp(), s.CreateAt, max(s.CreateAt, s.LastActivityAt)})
+	}
+
+	seen := make(map[string]bool)
+	for i, a := range live {
+		for _, b := range live[i+1:] {
+			overlap := a.from <= b.to && b.from <= a.to
+			if overlap && a.mobile == b.mobile && a.network != b.network {
+				seen[a.ip] = true
+				seen[b.ip] = true
+			}
+		}
+	}
+	shared := make([]string, 0, len(seen))
+	for ip := range seen {
+		shared = append(shared, ip)
+	}
+	sort.Strings(shared)
+	return shared
+}
+
+// ipNetwork returns the network prefix ip is compared by.
+func ipNetwork(ip string) string {
+	parsed := net.ParseIP(ip)
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(sharedSessionIPv4Prefix, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(sharedSessionIPv6Prefix, 128)).String()
+}
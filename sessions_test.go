@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestSharedSessionIPs(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(hoursAgo int) int64 { return now.Add(-time.Duration(hoursAgo) * time.Hour).UnixMilli() }
+	session := func(id string, from, to int, props map[string]string) *model.Session {
+		return &model.Session{Id: id, CreateAt: at(from), LastActivityAt: at(to), ExpiresAt: now.Add(24 * time.Hour).UnixMilli(), Props: props}
+	}
+	mobile := map[string]string{model.UserAuthServiceIsMobile: "true"}
+	token := map[string]string{model.SessionPropType: model.SessionTypeUserAccessToken}
+	audits := []model.Audit{
+		{SessionId: "s1", IpAddress: "203.0.113.10", CreateAt: at(5)},
+		{SessionId: "s2", IpAddress: "198.51.100.7", CreateAt: at(4)},
+		{SessionId: "s3", IpAddress: "203.0.200.20", CreateAt: at(4)}, // same /16 as s1
+		{SessionId: "s4", IpAddress: "192.0.2.44", CreateAt: at(4)},
+		{SessionId: "s5", IpAddress: "2001:db8::1", CreateAt: at(4)},
+		{SessionId: "s6", IpAddress: "2001:db9::1", CreateAt: at(4)},
+		{SessionId: "s1", IpAddress: "not-an-ip", CreateAt: at(1)},
+	}
+
+	tests := []struct {
+		name     string
+		sessions []*model.Session
+		want     string
+	}{
+		{
+			name:     "overlapping browsers on different networks",
+			sessions: []*model.Session{session("s1", 6, 1, nil), session("s2", 5, 2, nil)},
+			want:     "198.51.100.7,203.0.113.10",
+		},
+		{
+			name:     "same network",
+			sessions: []*model.Session{session("s1", 6, 1, nil), session("s3", 5, 2, nil)},
+		},
+		{
+			name:     "one after the other",
+			sessions: []*model.Session{session("s1", 10, 8, nil), session("s2", 5, 2, nil)},
+		},
+		{
+			name:     "phone and laptop",
+			sessions: []*model.Session{session("s1", 6, 1, nil), session("s2", 5, 2, mobile)},
+		},
+		{
+			name:     "two phones",
+			sessions: []*model.Session{session("s1", 6, 1, mobile), session("s2", 5, 2, mobile)},
+			want:     "198.51.100.7,203.0.113.10",
+		},
+		{
+			name:     "access token ignored",
+			sessions: []*model.Session{session("s1", 6, 1, nil), session("s2", 5, 2, token)},
+		},
+		{
+			name: "expired session ignored",
+			sessions: []*model.Session{session("s1", 6, 1, nil),
+				{Id: "s2", CreateAt: at(5), LastActivityAt: at(2), ExpiresAt: at(1)}},
+		},
+		{
+			name:     "session without a recorded IP",
+			sessions: []*model.Session{session("s1", 6, 1, nil), session("s9", 5, 2, nil)},
+		},
+		{
+			name:     "third session from another network",
+			sessions: []*model.Session{session("s1", 6, 1, nil), session("s3", 5, 2, nil), session("s4", 3, 0, nil)},
+			want:     "192.0.2.44,203.0.113.10,203.0.200.20",
+		},
+		{
+			name:     "IPv6 networks",
+			sessions: []*model.Session{session("s5", 6, 1, nil), session("s6", 5, 2, nil)},
+			want:     "2001:db8::1,2001:db9::1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SharedSessionIPs(tt.sessions, audits, now)
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("SharedSessionIPs = %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// LoadSnapshot reads a report previously written with --format json,
+// compressed or not.
+func LoadSnapshot(path string) (*AuditResult, error) {
+	data, err := readReportFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSnapshot(data)
+}
+
+// ParseSnapshot converts a JSON report back into an AuditResult. Team and
+// channel IDs are not part of the report, so only names are restored.
+func ParseSnapshot(data []byte) (*AuditResult, error) {
+	var in jsonOutput
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, fmt.Errorf("not a JSON report: %w", err)
+	}
+	if in.SchemaVersion > ReportSchemaVersion {
+		return nil, fmt.Errorf("report schema version %d is newer than this version of mm-guest-audit supports (%d); upgrade mm-guest-audit", in.SchemaVersion, ReportSchemaVersion)
+	}
+
+	result := &AuditResult{
+		Summary:      in.Summary,
+		InactiveDays: in.InactiveDays,
+		GuestRoles:   in.GuestRoles,
+		MaxGuestAge:  in.MaxGuestAge,
+
+		MaxPasswordAge: in.MaxPasswordAge,
+
+		InactivityMetric:      in.InactivityMetric,
+		Deployment:            in.Deployment,
+		UnavailableEnrichment: in.Unavailable,
+		PermissionMissing:     in.PermissionDenied,
+		ProfileFields:         in.ProfileFields,
+	}
+	if seats := in.Summary.License.LicensedSeats; seats != nil {
+		result.LicensedSeats = *seats
+	}
+	for i, g := range in.Guests {
+		var times [11]*time.Time
+		for j, s := range []*string{g.CreatedAt, g.LastLogin, g.LastPost, g.LastFileUpload, g.ExceptionExpires, g.LastMention, g.DeactivatedAt, g.LastViewed, g.LastAuditedAt, g.NotifiedAt, g.PasswordUpdatedAt} {
+			t, err := parseSnapshotTime(s)
+			if err != nil {
+				return nil, fmt.Errorf("guest %d (%s): %w", i+1, g.Username, err)
+			}
+			times[j] = t
+		}
+
+		teams := make([]TeamInfo, len(g.Teams))
+		for j, name := range g.Teams {
+			teams[j] = TeamInfo{DisplayName: name, Archived: slices.Contains(g.ArchivedTeams, name)}
+		}
+
+		result.Guests = append(result.Guests, GuestRecord{
+			Server:      g.Server,
+			Username:    g.Username,
+			DisplayName: g.DisplayName,
+			Nickname:    g.Nickname,
+			Email:       g.Email,
+			Position:    g.Position,
+			AuthMethod:  g.AuthMethod,
+			Locale:      ptrToString(g.Locale),
+			Timezone:    ptrToString(g.Timezone),
+			CreatedAt:   times[0],
+			LastLogin:   times[1],
+			LastPost:    times[2],
+			LastViewed:  times[7],
+			ViewUnknown: g.LastViewedUnknown,
+
+			LastAuditedAction: g.LastAuditedAction,
+			LastAuditedAt:     times[8],
+			NotifiedAt:        times[9],
+			New:               g.NewGuest,
+			Teams:             teams,
+			Channels:          g.Channels,
+			Active:            g.Active,
+			Inactive:          g.Inactive,
+			Excepted:          g.Excepted,
+			Orphaned:          g.Orphaned,
+
+			ShouldBeGuest:  g.ShouldBeGuest,
+			EmailVerified:  g.EmailVerified,
+			DeactivatedAt:  times[6],
+			PurgeCandidate: g.PurgeCandidate,
+			AgeDays:        g.AgeDays,
+			Expired:        g.Expired,
+
+			DaysSinceLastActivity: g.DaysSinceLastActivity,
+
+			PasswordUpdatedAt: times[10],
+			PasswordAgeDays:   g.PasswordAgeDays,
+			PasswordExpired:   g.PasswordExpired,
+			Bot:               g.Bot,
+
+			ExceptionJustification: g.ExceptionJustification,
+			ExceptionExpires:       times[4],
+			ExceptionTicket:        g.ExceptionTicket,
+
+			RetentionChannels: g.RetentionChannels,
+			PrivateChannels:   g.PrivateChannels,
+			ChannelCount:      g.ChannelCount,
+			PreviousUsernames: g.PreviousUsernames,
+			PreviousEmails:    g.PreviousEmails,
+			LastFileUpload:    times[3],
+			FileCount:         g.FileCount,
+			LastMention:       times[5],
+			PostCount:         g.PostCount,
+			MentionCount:      g.MentionCount,
+			Boards:            g.Boards,
+			Playbooks:         g.Playbooks,
+			GuestOnlyChannels: g.GuestOnlyChannels,
+			PermissionMissing: g.PermissionMissing,
+			ProfileFields:     g.ProfileFields,
+			SharedAccount:     g.PossibleSharedAccount,
+			SharedSessionIPs:  g.SharedSessionIPs,
+			ElevatedRoles:     g.ElevatedRoles,
+			Groups:            g.Groups,
+			DirectChannels:    g.DirectChannels,
+			Failed:            g.Failed,
+			Errors:            g.Errors,
+			Checksum:          g.Checksum,
+		})
+	}
+	if len(in.Guests) > 0 {
+		result.ExtraFields = extraFieldsFromMap(in.Guests[0].ExtraFields)
+	}
+	if in.Metadata != nil {
+		m, err := metadataFromJSON(in.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("metadata: %w", err)
+		}
+		result.Metadata = m
+	}
+	return result, nil
+}
+
+// metadataFromJSON restores run metadata, with filters in the order
+// AppliedFilters lists them.
+func metadataFromJSON(in *jsonRunMetadata) (*RunMetadata, error) {
+	m := &RunMetadata{
+		ServerURL:   in.ServerURL,
+		RunBy:       in.RunBy,
+		ToolVersion: in.ToolVersion,
+		APICalls:    in.APICalls,
+	}
+	if in.ServerVersion != nil {
+		m.ServerVersion = *in.ServerVersion
+	}
+	started, err := parseSnapshotTime(&in.StartedAt)
+	if err != nil {
+		return nil, err
+	}
+	finished, err := parseSnapshotTime(&in.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if started != nil {
+		m.StartedAt = *started
+	}
+	if finished != nil {
+		m.FinishedAt = *finished
+	}
+
+	names := make([]string, 0, len(in.Filters))
+	for name := range in.Filters {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		if ra, rb := filterRank(a), filterRank(b); ra != rb {
+			return ra - rb
+		}
+		return strings.Compare(a, b)
+	})
+	for _, name := range names {
+		m.Filters = append(m.Filters, Filter{name, in.Filters[name]})
+	}
+	return m, nil
+}
+
+// extraFieldsFromMap restores a record's extra fields in name order.
+func extraFieldsFromMap(m map[string]string) []ExtraField {
+	return (&Config{ExtraFields: m}).ResolveExtraFields()
+}
+
+// ptrToString returns the empty string for null.
+func ptrToString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func parseSnapshotTime(s *string) (*time.Time, error) {
+	if s == nil || *s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, *s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q", *s)
+	}
+	return &t, nil
+}
+
+// RunOffline re-evaluates a snapshot without contacting the server. Team and
+// channel filters match display names. Inactivity is recomputed only when
+// opts.InactiveDays is set, purge candidates only when opts.DeactivatedDays
+// is, account age only when opts.MaxGuestAge is, password age only when
+// opts.MaxPasswordAge is, and exceptions only when an allowlist is given;
+// otherwise the snapshot's values are kept, so plain re-formatting is lossless.
+// The metadata stays that of the run that collected the data.
+func RunOffline(snapshot *AuditResult, opts AuditOptions) (*AuditResult, int) {
+	result := &AuditResult{
+		InactiveDays: snapshot.InactiveDays,
+		GuestRoles:   snapshot.GuestRoles,
+		MaxGuestAge:  snapshot.MaxGuestAge,
+
+		MaxPasswordAge:        snapshot.MaxPasswordAge,
+		InactivityMetric:      snapshot.InactivityMetric,
+		Deployment:            snapshot.Deployment,
+		UnavailableEnrichment: snapshot.UnavailableEnrichment,
+		PermissionMissing:     snapshot.PermissionMissing,
+		ExtraFields:           snapshot.ExtraFields,
+		ProfileFields:         snapshot.ProfileFields,
+		Metadata:              snapshot.Metadata,
+		LicensedSeats:         snapshot.LicensedSeats,
+	}
+	if len(opts.ExtraFields) > 0 {
+		result.ExtraFields = opts.ExtraFields
+	}
+	if opts.MaxGuestAge > 0 {
+		result.MaxGuestAge = opts.MaxGuestAge
+	}
+	if opts.MaxPasswordAge > 0 {
+		result.MaxPasswordAge = opts.MaxPasswordAge
+	}
+	// A snapshot taken without --last-viewed has no view dates at all, which
+	// says nothing about whether its guests viewed anything
+	viewsTaken := slices.ContainsFunc(snapshot.Guests, func(g GuestRecord) bool { return g.LastViewed != nil || g.ViewUnknown })
+	if opts.InactiveDays > 0 {
+		result.InactiveDays = opts.InactiveDays
+		result.InactivityMetric = opts.InactivityMetric
+		if result.InactivityMetric == "" {
+			result.InactivityMetric = MetricLogin
+		}
+		if result.InactivityMetric == MetricView && !viewsTaken {
+			logger.Printf("Warning: the snapshot has no last_viewed dates, so no guest can be judged inactive. Take it with --last-viewed to use --inactivity-metric view.")
+		}
+	}
+	now := time.Now()
+
+	for _, g := range snapshot.Guests {
+		if opts.Sample > 0 && len(result.Guests) >= opts.Sample {
+			break
+		}
+		if !CreatedInRange(g.CreatedAt, opts.CreatedAfter, opts.CreatedBefore) {
+			continue
+		}
+		if opts.TeamFilter != "" {
+			teams := filterTeams(g.Teams, opts.TeamFilter)
+			if len(teams) == 0 {
+				continue
+			}
+			g.Teams = teams
+		}
+		if !opts.IncludeArchivedTeams {
+			g.Teams = liveTeams(g.Teams)
+		}
+		if !opts.IncludeArchived {
+			g.Channels = liveChannels(g.Channels)
+			g.PrivateChannels = countPrivateChannels(g.Channels)
+		}
+		if opts.TeamFilter != "" || opts.ChannelFilter != "" {
+			g.Channels = filterChannels(g.Channels, cmp.Or(opts.TeamFilter, opts.ChannelTeam), ParseChannelFilter(opts.ChannelFilter))
+			if opts.ChannelFilter != "" && len(g.Channels) == 0 {
+				continue
+			}
+			g.PrivateChannels = countPrivateChannels(g.Channels)
+		}
+		// Recounted always, as snapshots older than channel_count lack it
+		g.ChannelCount = countChannels(g.Channels)
+		if opts.PrivateOnly && g.PrivateChannels == 0 {
+			continue
+		}
+		if !ChannelCountInRange(g.ChannelCount, opts.MinChannels, opts.MaxChannels) {
+			continue
+		}
+		if opts.OrphansOnly && !g.Orphaned {
+			continue
+		}
+		if opts.NeverLoggedIn && g.LastLogin != nil {
+			continue
+		}
+		if opts.UnverifiedOnly && g.EmailVerified {
+			continue
+		}
+		// Both bounds are measured from now, not from when the snapshot was taken
+		if !OldEnough(AccountAgeDays(g.CreatedAt, now), opts.MinAgeDays) || !IdleEnough(DaysSinceActivity(g.LastLogin, g.LastPost, now), opts.MinIdleDays) {
+			continue
+		}
+		if len(opts.AuthMethods) > 0 && !slices.Contains(opts.AuthMethods, g.AuthMethod) {
+			continue
+		}
+		if !MatchesGuest(opts.Match, g.Username, g.Email, g.DisplayName) {
+			continue
+		}
+
+		if opts.InactiveDays > 0 || g.DaysSinceLastActivity == nil {
+			// Also filled in for snapshots older than the field
+			g.DaysSinceLastActivity = DaysSinceActivity(g.LastLogin, g.LastPost, now)
+		}
+		if opts.InactiveDays > 0 {
+			g.Inactive = IsInactiveByMetric(result.InactivityMetric, g.LastLogin, g.LastPost, g.LastViewed, viewSignal(viewsTaken, g.ViewUnknown), opts.InactiveDays, now) &&
+				!MentionedWithin(g.LastMention, opts.MentionDays, now)
+		}
+		if opts.DeactivatedDays > 0 {
+			g.PurgeCandidate = IsPurgeCandidate(g.DeactivatedAt, opts.DeactivatedDays, now)
+		}
+		if opts.MaxGuestAge > 0 {
+			g.AgeDays = AccountAgeDays(g.CreatedAt, now)
+			g.Expired = IsExpired(g.CreatedAt, g.Active, opts.MaxGuestAge, now)
+		}
+		if opts.MaxPasswordAge > 0 {
+			setPasswordAge(&g, opts.MaxPasswordAge, now)
+		}
+		if opts.Allowlist != nil {
+			g.Excepted = false
+			g.ExceptionJustification = ""
+			g.ExceptionExpires = nil
+			g.ExceptionTicket = ""
+			applyAllowlist(&g, opts.Allowlist, now, opts.Verbose)
+		}
+		g.Checksum = GuestChecksum(g)
+		result.Guests = append(result.Guests, g)
+	}
+
+	SortGuests(result.Guests, opts.Sort)
+	GroupGuestsByOwner(result.Guests, opts.OwnerField)
+	result.OwnerField = opts.OwnerField
+	summarize(result, opts.AgeBuckets, now)
+
+	if opts.Verbose {
+		logger.Printf("Loaded %d guest(s) from snapshot, %d after filtering", len(snapshot.Guests), len(result.Guests))
+	}
+	return result, ExitSuccess
+}
+
+func filterTeams(teams []TeamInfo, name string) []TeamInfo {
+	var out []TeamInfo
+	for _, t := range teams {
+		if strings.EqualFold(t.DisplayName, name) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// filterChannels keeps the channels in team, if set, and named in names, if
+// any. Both match display names.
+func filterChannels(channels []ChannelInfo, team string, names []string) []ChannelInfo {
+	var out []ChannelInfo
+	for _, ch := range channels {
+		if team != "" && !strings.EqualFold(ch.TeamName, team) {
+			continue
+		}
+		if len(names) > 0 && !slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(ch.ChannelName, n) }) {
+			continue
+		}
+		out = append(out, ch)
+	}
+	return out
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseSnapshot_RoundTrip(t *testing.T) {
+	result := sampleResult()
+	result.Guests[0].ViewUnknown = true
+	result.Guests[0].Position = "Consultant"
+	result.ProfileFields = []string{"Company"}
+	result.Guests[0].ProfileFields = map[string]string{"Company": "Acme Ltd"}
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, result); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+
+	snapshot, err := ParseSnapshot(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseSnapshot error: %v", err)
+	}
+
+	var again bytes.Buffer
+	if err := writeJSON(&again, snapshot); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+	if buf.String() != again.String() {
+		t.Errorf("round trip changed the report:\n%s\nvs\n%s", buf.String(), again.String())
+	}
+}
+
+func TestParseSnapshot_LookupErrors(t *testing.T) {
+	// Schema version 2 named the stage "lookup" and had no failed flag
+	legacy := `{"schema_version": 2, "guests": [
+		{"username": "x", "errors": [{"lookup": "channels", "team": "Sales", "message": "HTTP 500"}]}
+	]}`
+	snapshot, err := ParseSnapshot([]byte(legacy))
+	if err != nil {
+		t.Fatalf("ParseSnapshot error: %v", err)
+	}
+	if got := snapshot.Guests[0].Errors; len(got) != 1 || got[0] != (LookupError{Stage: LookupChannels, Team: "Sales", Message: "HTTP 500"}) {
+		t.Errorf("legacy errors = %+v", got)
+	}
+
+	result := sampleResult()
+	result.Guests[1].Failed = true
+	result.Guests[1].Errors = []LookupError{{Stage: LookupTeams, Message: "forbidden", HTTPStatus: 403}}
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot, err = ParseSnapshot(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	offline, _ := RunOffline(snapshot, AuditOptions{})
+	if g := offline.Guests[1]; !g.Failed || g.Errors[0].HTTPStatus != 403 {
+		t.Errorf("failed guest after round trip = %+v", g)
+	}
+	if offline.Summary.FailedLookups != 1 || offline.Summary.FailuresByStage[LookupTeams] != 1 {
+		t.Errorf("summary after round trip = %+v", offline.Summary)
+	}
+}
+
+func TestParseSnapshot_LicenseSeats(t *testing.T) {
+	result := sampleResult()
+	result.LicensedSeats = 500
+	summarize(result, nil, time.Now())
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := ParseSnapshot(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Re-evaluating offline recounts the seats against the same license
+	offline, _ := RunOffline(snapshot, AuditOptions{})
+	if l := offline.Summary.License; l.LicensedSeats == nil || *l.LicensedSeats != 500 || l.GuestSeats != 2 {
+		t.Errorf("license after round trip = %+v", l)
+	}
+}
+
+func TestParseSnapshot_ExtraFields(t *testing.T) {
+	result := sampleResult()
+	result.ExtraFields = []ExtraField{{"environment", "prod"}, {"region", "eu"}}
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, result); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+
+	snapshot, err := ParseSnapshot(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseSnapshot error: %v", err)
+	}
+	kept, _ := RunOffline(snapshot, AuditOptions{})
+	if got := fmt.Sprint(kept.ExtraFields); got != "[{environment prod} {region eu}]" {
+		t.Errorf("snapshot extra fields = %s", got)
+	}
+
+	replaced, _ := RunOffline(snapshot, AuditOptions{ExtraFields: []ExtraField{{"region", "us"}}})
+	if got := fmt.Sprint(replaced.ExtraFields); got != "[{region us}]" {
+		t.Errorf("config should replace snapshot extra fields, got %s", got)
+	}
+}
+
+func TestParseSnapshot_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"not JSON", "username,email\n"},
+		{"bad date", `{"guests": [{"username": "x", "last_login": "yesterday"}]}`},
+		{"newer schema", `{"schema_version": 4, "guests": []}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSnapshot([]byte(tt.input)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestRunOffline(t *testing.T) {
+	// sampleResult: jane.doe (Engineering, Sales; recent login) and
+	// bob.contractor (Engineering; never logged in, flagged inactive)
+	tests := []struct {
+		name          string
+		opts          AuditOptions
+		wantUsers     []string
+		wantInactive  int
+		wantExcepted  int
+		wantChannels0 int
+	}{
+		{
+			name:          "no options keeps snapshot values",
+			opts:          AuditOptions{},
+			wantUsers:     []string{"jane.doe", "bob.contractor"},
+			wantInactive:  1,
+			wantChannels0: 3,
+		},
+		{
+			name:          "team filter matches display name",
+			opts:          AuditOptions{TeamFilter: "sales"},
+			wantUsers:     []string{"jane.doe"},
+			wantChannels0: 1,
+		},
+		{
+			name:          "channel filter",
+			opts:          AuditOptions{TeamFilter: "Engineering", ChannelFilter: "Dev Backend"},
+			wantUsers:     []string{"jane.doe"},
+			wantChannels0: 1,
+		},
+		{
+			name:          "channel filter without team",
+			opts:          AuditOptions{ChannelFilter: "general, partner updates"},
+			wantUsers:     []string{"jane.doe", "bob.contractor"},
+			wantInactive:  1,
+			wantChannels0: 2,
+		},
+		{
+			name:          "inactivity recomputed with post metric",
+			opts:          AuditOptions{InactiveDays: 30, InactivityMetric: MetricPost},
+			wantUsers:     []string{"jane.doe", "bob.contractor"},
+			wantInactive:  2, // jane's last post in the fixture is long past
+			wantChannels0: 3,
+		},
+		{
+			name:          "view metric without views in the snapshot",
+			opts:          AuditOptions{InactiveDays: 30, InactivityMetric: MetricView},
+			wantUsers:     []string{"jane.doe", "bob.contractor"},
+			wantInactive:  0, // unknown, not never viewed
+			wantChannels0: 3,
+		},
+		{
+			name: "allowlist applied",
+			opts: AuditOptions{Allowlist: &Allowlist{Entries: []AllowlistEntry{
+				{Username: "bob.contractor", Justification: "Support contract"},
+			}}},
+			wantUsers:     []string{"jane.doe", "bob.contractor"},
+			wantExcepted:  1,
+			wantChannels0: 3,
+		},
+		{
+			name:          "sorted",
+			opts:          AuditOptions{Sort: SortSpec{Field: "username"}},
+			wantUsers:     []string{"bob.contractor", "jane.doe"},
+			wantInactive:  1,
+			wantChannels0: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, exitCode := RunOffline(sampleResult(), tt.opts)
+			if exitCode != ExitSuccess {
+				t.Fatalf("exit code = %d, want %d", exitCode, ExitSuccess)
+			}
+			if len(result.Guests) != len(tt.wantUsers) {
+				t.Fatalf("got %d guests, want %d", len(result.Guests), len(tt.wantUsers))
+			}
+			for i, u := range tt.wantUsers {
+				if result.Guests[i].Username != u {
+					t.Errorf("guest %d = %s, want %s", i, result.Guests[i].Username, u)
+				}
+			}
+			if result.Summary.InactiveGuests != tt.wantInactive {
+				t.Errorf("inactive = %d, want %d", result.Summary.InactiveGuests, tt.wantInactive)
+			}
+			if result.Summary.ExceptedGuests != tt.wantExcepted {
+				t.Errorf("excepted = %d, want %d", result.Summary.ExceptedGuests, tt.wantExcepted)
+			}
+			if got := len(result.Guests[0].Channels); got != tt.wantChannels0 {
+				t.Errorf("first guest has %d channels, want %d", got, tt.wantChannels0)
+			}
+		})
+	}
+}
+
+func TestRunOffline_PrivateOnly(t *testing.T) {
+	snapshot := sampleResult()
+	// jane.doe's Sales channel is private
+	for i, ch := range snapshot.Guests[0].Channels {
+		if ch.TeamName == "Sales" {
+			snapshot.Guests[0].Channels[i].Type = ChannelTypePrivate
+		}
+	}
+	snapshot.Guests[0].PrivateChannels = 1
+
+	result, _ := RunOffline(snapshot, AuditOptions{PrivateOnly: true})
+	if len(result.Guests) != 1 || result.Guests[0].Username != "jane.doe" {
+		t.Fatalf("got %d guests, want only jane.doe", len(result.Guests))
+	}
+
+	// The count is recomputed after the team filter drops the Sales channel
+	result, _ = RunOffline(snapshot, AuditOptions{PrivateOnly: true, TeamFilter: "Engineering"})
+	if len(result.Guests) != 0 {
+		t.Errorf("got %d guests, want none with private channels in Engineering", len(result.Guests))
+	}
+}
+
+func TestRunOffline_AuthMethod(t *testing.T) {
+	snapshot := sampleResult()
+	snapshot.Guests[0].AuthMethod = AuthMethodSAML
+	snapshot.Guests[1].AuthMethod = AuthMethodEmail
+
+	result, _ := RunOffline(snapshot, AuditOptions{AuthMethods: []string{AuthMethodEmail}})
+	if len(result.Guests) != 1 || result.Guests[0].Username != "bob.contractor" {
+		t.Fatalf("got %+v, want only bob.contractor", result.Guests)
+	}
+}
+
+func TestRunOffline_Match(t *testing.T) {
+	result, _ := RunOffline(sampleResult(), AuditOptions{Match: regexp.MustCompile(`^Jane `)})
+	if len(result.Guests) != 1 || result.Guests[0].Username != "jane.doe" {
+		t.Fatalf("got %+v, want only jane.doe", result.Guests)
+	}
+}
+
+func TestRunOffline_IncludeArchived(t *testing.T) {
+	snapshot := sampleResult()
+	snapshot.Guests[0].Channels = append(snapshot.Guests[0].Channels, ChannelInfo{TeamName: "Sales", ChannelName: "Old Deals", Type: ChannelTypePrivate, Archived: true})
+	snapshot.Guests[0].PrivateChannels = countPrivateChannels(snapshot.Guests[0].Channels)
+
+	result, _ := RunOffline(snapshot, AuditOptions{})
+	if g := result.Guests[0]; slices.ContainsFunc(g.Channels, func(ch ChannelInfo) bool { return ch.Archived }) || g.PrivateChannels != 0 {
+		t.Errorf("archived channel kept without IncludeArchived: %+v, %d private", g.Channels, g.PrivateChannels)
+	}
+
+	result, _ = RunOffline(snapshot, AuditOptions{IncludeArchived: true})
+	if g := result.Guests[0]; len(g.Channels) != len(snapshot.Guests[0].Channels) || g.PrivateChannels != 1 {
+		t.Errorf("with IncludeArchived: %+v, %d private", g.Channels, g.PrivateChannels)
+	}
+}
+
+func TestRunOffline_OrphansOnly(t *testing.T) {
+	snapshot := sampleResult()
+	snapshot.Guests[1].Teams = nil
+	snapshot.Guests[1].Channels = nil
+	snapshot.Guests[1].Orphaned = true
+
+	result, _ := RunOffline(snapshot, AuditOptions{OrphansOnly: true})
+	if len(result.Guests) != 1 || result.Guests[0].Username != "bob.contractor" {
+		t.Fatalf("got %+v, want only bob.contractor", result.Guests)
+	}
+	if result.Summary.OrphanedGuests != 1 {
+		t.Errorf("orphaned guests = %d, want 1", result.Summary.OrphanedGuests)
+	}
+}
+
+func TestRunOffline_NeverLoggedIn(t *testing.T) {
+	// bob.contractor has no login; he is listed without --inactive-days
+	result, _ := RunOffline(sampleResult(), AuditOptions{NeverLoggedIn: true})
+	if len(result.Guests) != 1 || result.Guests[0].Username != "bob.contractor" {
+		t.Fatalf("got %+v, want only bob.contractor", result.Guests)
+	}
+	if result.Summary.NeverLoggedInGuests != 1 {
+		t.Errorf("never logged in = %d, want 1", result.Summary.NeverLoggedInGuests)
+	}
+}
+
+func TestRunOffline_UnverifiedOnly(t *testing.T) {
+	result, _ := RunOffline(sampleResult(), AuditOptions{UnverifiedOnly: true})
+	if len(result.Guests) != 1 || result.Guests[0].Username != "bob.contractor" {
+		t.Fatalf("got %+v, want only bob.contractor", result.Guests)
+	}
+	if result.Summary.UnverifiedGuests != 1 {
+		t.Errorf("unverified = %d, want 1", result.Summary.UnverifiedGuests)
+	}
+}
+
+func TestRunOffline_ChannelCountRange(t *testing.T) {
+	// jane.doe is in three channels and bob.contractor in one; the
+	// snapshot's channel_count is recounted from its channels
+	snapshot := sampleResult()
+	snapshot.Guests[0].ChannelCount = 0
+	result, _ := RunOffline(snapshot, AuditOptions{MinChannels: 2})
+	if len(result.Guests) != 1 || result.Guests[0].Username != "jane.doe" {
+		t.Fatalf("got %+v, want only jane.doe", result.Guests)
+	}
+	if got := result.Guests[0].ChannelCount; got != 3 {
+		t.Errorf("channel_count = %d, want 3", got)
+	}
+
+	one := 1
+	result, _ = RunOffline(sampleResult(), AuditOptions{MaxChannels: &one})
+	if len(result.Guests) != 1 || result.Guests[0].Username != "bob.contractor" {
+		t.Errorf("got %+v, want only bob.contractor", result.Guests)
+	}
+}
+
+func TestRunOffline_DoesNotModifySnapshot(t *testing.T) {
+	snapshot := sampleResult()
+	RunOffline(snapshot, AuditOptions{InactiveDays: 1, TeamFilter: "Sales"})
+
+	if len(snapshot.Guests[0].Teams) != 2 {
+		t.Error("team filter should not modify the snapshot")
+	}
+	if snapshot.Guests[0].Inactive {
+		t.Error("inactivity recomputation should not modify the snapshot")
+	}
+}
+
+func TestRunOffline_MaxGuestAge(t *testing.T) {
+	created := time.Now().AddDate(0, 0, -500)
+	snapshot := &AuditResult{Guests: []GuestRecord{
+		{Username: "old", Active: true, CreatedAt: &created},
+		{Username: "gone", CreatedAt: &created},
+	}}
+	result, _ := RunOffline(snapshot, AuditOptions{MaxGuestAge: 365})
+	if !result.Guests[0].Expired || result.Guests[1].Expired {
+		t.Errorf("expired = %v, %v; want true, false", result.Guests[0].Expired, result.Guests[1].Expired)
+	}
+	if result.Summary.ExpiredGuests != 1 || result.MaxGuestAge != 365 {
+		t.Errorf("expired_guests = %d, max_guest_age = %d", result.Summary.ExpiredGuests, result.MaxGuestAge)
+	}
+
+	// Without the flag the snapshot's values are kept
+	result, _ = RunOffline(snapshot, AuditOptions{})
+	if result.Guests[0].AgeDays != nil || result.Guests[0].Expired {
+		t.Errorf("age_days = %v, expired = %v; want the snapshot's", result.Guests[0].AgeDays, result.Guests[0].Expired)
+	}
+}
+
+func TestRunOffline_MaxPasswordAge(t *testing.T) {
+	updated := time.Now().AddDate(0, 0, -200)
+	snapshot := &AuditResult{Guests: []GuestRecord{
+		{Username: "stale", Active: true, PasswordUpdatedAt: &updated},
+		{Username: "sso", Active: true},
+	}}
+	result, _ := RunOffline(snapshot, AuditOptions{MaxPasswordAge: 90})
+	if g := result.Guests[0]; !g.PasswordExpired || g.PasswordAgeDays == nil || *g.PasswordAgeDays != 200 || result.Guests[1].PasswordExpired {
+		t.Errorf("unexpected password ages: %+v", result.Guests)
+	}
+	if result.Summary.ExpiredPasswords != 1 || result.MaxPasswordAge != 90 {
+		t.Errorf("expired_passwords = %d, max_password_age = %d", result.Summary.ExpiredPasswords, result.MaxPasswordAge)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    SortSpec
+		wantErr bool
+	}{
+		{"", SortSpec{}, false},
+		{"username", SortSpec{Field: "username"}, false},
+		{"-last_file_upload", SortSpec{Field: "last_file_upload", Desc: true}, false},
+		{"file_count", SortSpec{Field: "file_count"}, false},
+		{"post_count", SortSpec{Field: "post_count"}, false},
+		{"-mention_count", SortSpec{Field: "mention_count", Desc: true}, false},
+		{"-", SortSpec{}, true},
+		{"email", SortSpec{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseSort(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSort(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSort(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortGuests(t *testing.T) {
+	now := time.Now()
+	intPtr := func(n int) *int { return &n }
+
+	guests := func() []GuestRecord {
+		return []GuestRecord{
+			{Username: "bob", LastFileUpload: timePtr(now.AddDate(0, 0, -1)), FileCount: intPtr(2)},
+			{Username: "Alice", FileCount: intPtr(0)},
+			{Username: "carol", LastFileUpload: timePtr(now.AddDate(0, 0, -30)), FileCount: intPtr(9)},
+			{Username: "dave"},
+		}
+	}
+
+	tests := []struct {
+		spec SortSpec
+		want []string
+	}{
+		{SortSpec{}, []string{"bob", "Alice", "carol", "dave"}},
+		{SortSpec{Field: "username"}, []string{"Alice", "bob", "carol", "dave"}},
+		{SortSpec{Field: "username", Desc: true}, []string{"dave", "carol", "bob", "Alice"}},
+		// Never-uploaded guests sort first ascending; ties keep input order
+		{SortSpec{Field: "last_file_upload"}, []string{"Alice", "dave", "carol", "bob"}},
+		{SortSpec{Field: "last_file_upload", Desc: true}, []string{"bob", "carol", "Alice", "dave"}},
+		{SortSpec{Field: "file_count", Desc: true}, []string{"carol", "bob", "Alice", "dave"}},
+	}
+
+	for _, tt := range tests {
+		g := guests()
+		SortGuests(g, tt.spec)
+		for i, name := range tt.want {
+			if g[i].Username != name {
+				t.Errorf("SortGuests(%+v)[%d] = %s, want %s", tt.spec, i, g[i].Username, name)
+			}
+		}
+	}
+}
+
+func TestSortGuests_DaysSinceLastActivity(t *testing.T) {
+	now := time.Now()
+	guests := []GuestRecord{
+		{Username: "alice"},
+		{Username: "bob", LastLogin: timePtr(now.AddDate(0, 0, -1))},
+		{Username: "carol", LastPost: timePtr(now.AddDate(0, 0, -30))},
+		{Username: "dave", LastLogin: timePtr(now.AddDate(0, 0, -5)), LastPost: timePtr(now.AddDate(0, 0, -2))},
+	}
+	// A guest who was never active is the most idle
+	SortGuests(guests, SortSpec{Field: "days_since_last_activity", Desc: true})
+	for i, name := range []string{"alice", "carol", "dave", "bob"} {
+		if guests[i].Username != name {
+			t.Errorf("[%d] = %s, want %s", i, guests[i].Username, name)
+		}
+	}
+}
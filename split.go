@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+)
+
+// --split-by values.
+const (
+	SplitByTeam   = "team"   // one report per team
+	SplitByServer = "server" // one report per server of a --servers run
+)
+
+// ParseSplitBy validates the --split-by flag value.
+func ParseSplitBy(s string) (string, error) {
+	switch s {
+	case "", SplitByTeam, SplitByServer:
+		return s, nil
+	}
+	return "", fmt.Errorf("error: invalid --split-by %q: use team or server", s)
+}
+
+// SplitReport is one team's or server's part of a split report.
+type SplitReport struct {
+	Name   string // team display name, empty for guests with no team; or server name
+	File   string // base name within the output directory
+	Result *AuditResult
+}
+
+// SplitResult divides result into one report per team or server, as by
+// says.
+func SplitResult(result *AuditResult, by, format string) []SplitReport {
+	if by == SplitByServer {
+		return SplitResultByServer(result, format)
+	}
+	return SplitResultByTeam(result, format)
+}
+
+// SplitResultByTeam divides result into one report per team, in team name
+// order, followed by one for guests with no team if there are any. A guest
+// in several teams appears in each team's report, but only with that team's
+// memberships, channels, boards and playbooks, so a team owner sees nothing
+// of other teams.
+func SplitResultByTeam(result *AuditResult, format string) []SplitReport {
+	guestsByTeam := make(map[string][]GuestRecord)
+	var noTeam []GuestRecord
+	for _, g := range result.Guests {
+		if len(g.Teams) == 0 {
+			noTeam = append(noTeam, g)
+			continue
+		}
+		for _, t := range g.Teams {
+			guestsByTeam[t.DisplayName] = append(guestsByTeam[t.DisplayName], guestInTeam(g, t.DisplayName))
+		}
+	}
+	teams := make([]string, 0, len(guestsByTeam))
+	for name := range guestsByTeam {
+		teams = append(teams, name)
+	}
+	sort.Strings(teams)
+
+	ext := outputExt(format)
+	used := make(map[string]bool)
+	var reports []SplitReport
+	for _, name := range teams {
+		reports = append(reports, SplitReport{
+			Name:   name,
+			File:   uniqueFileName(used, "team-"+fileSlug(name), ext),
+			Result: subsetResult(result, guestsByTeam[name]),
+		})
+	}
+	if len(noTeam) > 0 {
+		reports = append(reports, SplitReport{
+			File:   "no-team." + ext,
+			Result: subsetResult(result, noTeam),
+		})
+	}
+	return reports
+}
+
+// SplitResultByServer divides a --servers report into one report per
+// server, in the order of the servers file. Each keeps its server's own
+// metadata, so it reads as if that server had been audited alone. A server
+// with no guests still gets a report.
+func SplitResultByServer(result *AuditResult, format string) []SplitReport {
+	var servers []string
+	metadata := make(map[string]*RunMetadata)
+	for _, s := range result.Servers {
+		servers = append(servers, s.Name)
+		metadata[s.Name] = s.Metadata
+	}
+	guestsByServer := make(map[string][]GuestRecord)
+	for _, g := range result.Guests {
+		// A report loaded with --from-file has no server list
+		if !slices.Contains(servers, g.Server) {
+			servers = append(servers, g.Server)
+		}
+		guestsByServer[g.Server] = append(guestsByServer[g.Server], g)
+	}
+
+	ext := outputExt(format)
+	used := make(map[string]bool)
+	reports := make([]SplitReport, 0, len(servers))
+	for _, name := range servers {
+		sub := subsetResult(result, guestsByServer[name])
+		if m := metadata[name]; m != nil {
+			sub.Metadata = m
+		}
+		reports = append(reports, SplitReport{
+			Name:   name,
+			File:   uniqueFileName(used, "server-"+fileSlug(name), ext),
+			Result: sub,
+		})
+	}
+	return reports
+}
+
+// guestInTeam returns g restricted to the team named team.
+func guestInTeam(g GuestRecord, team string) GuestRecord {
+	g.Teams = filterTeams(g.Teams, team)
+	g.Channels = filterChannels(g.Channels, team, nil)
+	g.PrivateChannels = countPrivateChannels(g.Channels)
+	g.ChannelCount = countChannels(g.Channels)
+	g.Boards = filterResources(g.Boards, team)
+	g.Playbooks = filterResources(g.Playbooks, team)
+	g.GuestOnlyChannels = filterResources(g.GuestOnlyChannels, team)
+	g.ElevatedRoles = filterRoleGrants(g.ElevatedRoles, team)
+	g.DirectChannels = filterResources(g.DirectChannels, team)
+	g.Errors = filterLookupErrors(g.Errors, team)
+	g.Checksum = GuestChecksum(g)
+	return g
+}
+
+// filterLookupErrors keeps the errors of lookups made for team, and of
+// lookups not made per team.
+func filterLookupErrors(errs []LookupError, team string) []LookupError {
+	var out []LookupError
+	for _, e := range errs {
+		if e.Team == "" || strings.EqualFold(e.Team, team) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func filterResources(resources []ResourceInfo, team string) []ResourceInfo {
+	var out []ResourceInfo
+	for _, r := range resources {
+		if strings.EqualFold(r.TeamName, team) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// subsetResult copies result with only guests, and the summary recounted
+// for them.
+func subsetResult(result *AuditResult, guests []GuestRecord) *AuditResult {
+	sub := *result
+	sub.Guests = guests
+	var bounds []int
+	for _, b := range result.Summary.AgeBuckets {
+		if b.MaxDays != nil {
+			bounds = append(bounds, *b.MaxDays)
+		}
+	}
+	summarize(&sub, bounds, time.Now())
+	return &sub
+}
+
+// fileSlug turns a team name into a file name fragment: lower case letters
+// and digits, with anything else collapsed to a single hyphen.
+func fileSlug(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			hyphen = false
+		} else if !hyphen && b.Len() > 0 {
+			b.WriteByte('-')
+			hyphen = true
+		}
+	}
+	slug := strings.TrimSuffix(b.String(), "-")
+	if slug == "" {
+		slug = "unnamed"
+	}
+	return slug
+}
+
+// uniqueFileName returns base.ext, or base-2.ext and so on if two team
+// names reduce to the same slug.
+func uniqueFileName(used map[string]bool, base, ext string) string {
+	name := base + "." + ext
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s-%d.%s", base, i, ext)
+	}
+	used[name] = true
+	return name
+}
+
+// WriteSplitOutputDir writes one report per team or server, as by says,
+// into dir, plus index.<ext> listing each report's file and counts. CSV
+// reports also get metadata.csv, as with WriteOutputDir.
+func WriteSplitOutputDir(result *AuditResult, by, format, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		logger.Printf("Warning: unable to create %q: %v — writing to stdout instead", dir, err)
+		return WriteOutput(result, format, "")
+	}
+
+	reports := SplitResult(result, by, format)
+	for _, r := range reports {
+		if err := WriteOutput(r.Result, format, filepath.Join(dir, r.File)); err != nil {
+			return err
+		}
+	}
+
+	writeIndex := func(w io.Writer, result *AuditResult) error {
+		return writeSplitIndex(w, result, reports, by, format)
+	}
+	if err := writeFileWith(filepath.Join(dir, "index."+outputExt(format)), result, writeIndex); err != nil {
+		return err
+	}
+	if format == "csv" {
+		return writeFileWith(filepath.Join(dir, compressedName("metadata.csv")), result, writeMetadataCSV)
+	}
+	return nil
+}
+
+// SplitOutputDirFiles lists the files WriteSplitOutputDir writes for
+// result, with the index first.
+func SplitOutputDirFiles(result *AuditResult, by, format, dir string) []string {
+	files := []string{filepath.Join(dir, "index."+outputExt(format))}
+	for _, r := range SplitResult(result, by, format) {
+		files = append(files, filepath.Join(dir, r.File))
+	}
+	if format == "csv" {
+		files = append(files, filepath.Join(dir, compressedName("metadata.csv")))
+	}
+	return files
+}
+
+// writeSplitIndex writes the index of a split report: one entry per team
+// or server file with its guest counts.
+func writeSplitIndex(w io.Writer, result *AuditResult, reports []SplitReport, by, format string) error {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		defer cw.Flush()
+		if err := cw.Write([]string{by, "file", "total_guests", "active_guests", "inactive_guests", "deactivated_guests", "excepted_guests", "failed_lookups"}); err != nil {
+			return err
+		}
+		for _, r := range reports {
+			s := r.Result.Summary
+			row := []string{r.Name, r.File, fmt.Sprint(s.TotalGuests), fmt.Sprint(s.ActiveGuests), fmt.Sprint(s.InactiveGuests), fmt.Sprint(s.DeactivatedGuests), fmt.Sprint(s.ExceptedGuests), fmt.Sprint(s.FailedLookups)}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "json":
+		type jsonIndexCounts struct {
+			File string `json:"file"`
+			TeamSummary
+			FailedLookups int `json:"failed_lookups"`
+		}
+		type jsonTeamEntry struct {
+			Team *string `json:"team"` // null for guests with no team
+			jsonIndexCounts
+		}
+		type jsonServerEntry struct {
+			Server string `json:"server"`
+			jsonIndexCounts
+		}
+		type jsonIndexHeader struct {
+			Metadata *jsonRunMetadata `json:"metadata,omitempty"`
+			Summary  AuditSummary     `json:"summary"`
+		}
+		header := jsonIndexHeader{Metadata: toJSONMetadata(result.Metadata), Summary: result.Summary}
+		teams, servers := []jsonTeamEntry{}, []jsonServerEntry{}
+		for _, r := range reports {
+			s := r.Result.Summary
+			c := jsonIndexCounts{File: r.File, FailedLookups: s.FailedLookups, TeamSummary: TeamSummary{
+				TotalGuests:       s.TotalGuests,
+				ActiveGuests:      s.ActiveGuests,
+				InactiveGuests:    s.InactiveGuests,
+				DeactivatedGuests: s.DeactivatedGuests,
+				ExceptedGuests:    s.ExceptedGuests,
+			}}
+			if by == SplitByServer {
+				servers = append(servers, jsonServerEntry{Server: r.Name, jsonIndexCounts: c})
+				continue
+			}
+			e := jsonTeamEntry{jsonIndexCounts: c}
+			if r.Name != "" {
+				e.Team = &r.Name
+			}
+			teams = append(teams, e)
+		}
+		var out any = struct {
+			jsonIndexHeader
+			Teams []jsonTeamEntry `json:"teams"`
+		}{header, teams}
+		if by == SplitByServer {
+			out = struct {
+				jsonIndexHeader
+				Servers []jsonServerEntry `json:"servers"`
+			}{header, servers}
+		}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+
+	default:
+		if result.Metadata != nil {
+			writeMetadataHeader(w, result)
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "%s\tFILE\tTOTAL\tACTIVE\tINACTIVE\tDEACTIVATED\tEXCEPTED\tFAILED\n", strings.ToUpper(by))
+		for _, r := range reports {
+			name := r.Name
+			if name == "" && by == SplitByTeam {
+				name = "(no team)"
+			}
+			s := r.Result.Summary
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\n", name, r.File, s.TotalGuests, s.ActiveGuests, s.InactiveGuests, s.DeactivatedGuests, s.ExceptedGuests, s.FailedLookups)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if by == SplitByServer {
+			fmt.Fprintf(w, "\nTotal: %d guest(s) in %d file(s)\n", result.Summary.TotalGuests, len(reports))
+			return nil
+		}
+		fmt.Fprintf(w, "\nTotal: %d guest(s) in %d file(s); a guest in several teams is listed in each\n", result.Summary.TotalGuests, len(reports))
+		return nil
+	}
+}
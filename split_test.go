@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func splitSampleResult() *AuditResult {
+	result := sampleResult()
+	result.Guests = append(result.Guests, GuestRecord{Username: "orphan", Active: true, Orphaned: true})
+	summarize(result, nil, result.Guests[0].LastLogin.AddDate(0, 0, 1))
+	return result
+}
+
+func TestSplitResultByTeam(t *testing.T) {
+	reports := SplitResultByTeam(splitSampleResult(), "csv")
+	if len(reports) != 3 {
+		t.Fatalf("expected Engineering, Sales and no-team reports, got %d", len(reports))
+	}
+	eng, sales, noTeam := reports[0], reports[1], reports[2]
+	if eng.Name != "Engineering" || eng.File != "team-engineering.csv" || eng.Result.Summary.TotalGuests != 2 || eng.Result.Summary.InactiveGuests != 1 {
+		t.Errorf("unexpected Engineering report: %s %s %+v", eng.Name, eng.File, eng.Result.Summary)
+	}
+	if noTeam.Name != "" || noTeam.File != "no-team.csv" || len(noTeam.Result.Guests) != 1 {
+		t.Errorf("unexpected no-team report: %+v", noTeam)
+	}
+
+	// A guest in several teams only shows the team the report is for
+	g := sales.Result.Guests[0]
+	if len(sales.Result.Guests) != 1 || len(g.Teams) != 1 || len(g.Channels) != 1 || g.Channels[0].ChannelName != "Partner Updates" {
+		t.Errorf("Sales report leaks other teams: %+v", sales.Result.Guests)
+	}
+	if g.Checksum != GuestChecksum(g) {
+		t.Error("checksum should match the restricted record")
+	}
+	if _, ok := sales.Result.Summary.ByTeam["Engineering"]; ok {
+		t.Error("Sales summary should only count Sales")
+	}
+}
+
+func TestFileSlug(t *testing.T) {
+	tests := map[string]string{
+		"Engineering":      "engineering",
+		"R&D / Platform":   "r-d-platform",
+		"  Über Team!  ":   "über-team",
+		"../../etc/passwd": "etc-passwd",
+		"***":              "unnamed",
+	}
+	for in, want := range tests {
+		if got := fileSlug(in); got != want {
+			t.Errorf("fileSlug(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	used := make(map[string]bool)
+	if a, b := uniqueFileName(used, "team-r-d", "csv"), uniqueFileName(used, "team-r-d", "csv"); a != "team-r-d.csv" || b != "team-r-d-2.csv" {
+		t.Errorf("colliding slugs gave %q and %q", a, b)
+	}
+}
+
+func TestWriteSplitOutputDir(t *testing.T) {
+	tests := []struct {
+		format string
+		files  []string
+	}{
+		{"csv", []string{"index.csv", "team-engineering.csv", "team-sales.csv", "no-team.csv", "metadata.csv"}},
+		{"json", []string{"index.json", "team-engineering.json", "team-sales.json", "no-team.json"}},
+		{"table", []string{"index.txt", "team-engineering.txt", "team-sales.txt", "no-team.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			dir := t.TempDir()
+			result := splitSampleResult()
+			if err := WriteSplitOutputDir(result, SplitByTeam, tt.format, dir); err != nil {
+				t.Fatal(err)
+			}
+			files := SplitOutputDirFiles(result, SplitByTeam, tt.format, dir)
+			entries, _ := os.ReadDir(dir)
+			if len(files) != len(tt.files) || len(entries) != len(tt.files) {
+				t.Fatalf("files = %v (%d on disk), want %v", files, len(entries), tt.files)
+			}
+			for i, want := range tt.files {
+				if filepath.Base(files[i]) != want {
+					t.Errorf("file %d = %s, want %s", i, filepath.Base(files[i]), want)
+				}
+				if _, err := os.Stat(files[i]); err != nil {
+					t.Errorf("%s not written: %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitIndex(t *testing.T) {
+	result := splitSampleResult()
+	reports := SplitResultByTeam(result, "csv")
+
+	var buf bytes.Buffer
+	if err := writeSplitIndex(&buf, result, reports, SplitByTeam, "csv"); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"team", "file", "total_guests", "active_guests", "inactive_guests", "deactivated_guests", "excepted_guests", "failed_lookups"},
+		{"Engineering", "team-engineering.csv", "2", "1", "1", "0", "0", "0"},
+		{"Sales", "team-sales.csv", "1", "1", "0", "0", "0", "0"},
+		{"", "no-team.csv", "1", "1", "0", "0", "0", "0"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("index = %v, want %v", records, want)
+	}
+	for i := range want {
+		if strings.Join(records[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("index row %d = %v, want %v", i, records[i], want[i])
+		}
+	}
+
+	buf.Reset()
+	if err := writeSplitIndex(&buf, result, SplitResultByTeam(result, "json"), SplitByTeam, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var index struct {
+		Summary AuditSummary `json:"summary"`
+		Teams   []struct {
+			Team        *string `json:"team"`
+			File        string  `json:"file"`
+			TotalGuests int     `json:"total_guests"`
+		} `json:"teams"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &index); err != nil {
+		t.Fatalf("invalid JSON index: %v", err)
+	}
+	if index.Summary.TotalGuests != 3 || len(index.Teams) != 3 || *index.Teams[0].Team != "Engineering" || index.Teams[2].Team != nil || index.Teams[2].File != "no-team.json" {
+		t.Errorf("unexpected JSON index:\n%s", buf.String())
+	}
+}
+
+func TestSplitResultByServer(t *testing.T) {
+	result := splitSampleResult()
+	for i := range result.Guests {
+		result.Guests[i].Server = "prod"
+	}
+	result.Guests[1].Server = "partner"
+	prod := &RunMetadata{ServerURL: "https://prod.example.com"}
+	result.Servers = []AuditedServer{{Name: "prod", Metadata: prod}, {Name: "staging"}, {Name: "partner"}}
+
+	reports := SplitResultByServer(result, "json")
+	if len(reports) != 3 {
+		t.Fatalf("expected prod, staging and partner reports, got %d", len(reports))
+	}
+	names := []string{reports[0].Name, reports[1].Name, reports[2].Name}
+	if strings.Join(names, ",") != "prod,staging,partner" || reports[0].File != "server-prod.json" {
+		t.Errorf("reports not in servers file order: %v, %s", names, reports[0].File)
+	}
+	if reports[0].Result.Summary.TotalGuests != 2 || reports[0].Result.Metadata != prod {
+		t.Errorf("prod report should have its 2 guests and own metadata: %+v", reports[0].Result.Summary)
+	}
+	// A server with no guests is listed, with the merged metadata
+	if len(reports[1].Result.Guests) != 0 || reports[1].Result.Metadata != result.Metadata {
+		t.Errorf("unexpected staging report: %+v", reports[1].Result)
+	}
+
+	var buf bytes.Buffer
+	if err := writeSplitIndex(&buf, result, reports, SplitByServer, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var index struct {
+		Servers []struct {
+			Server      string `json:"server"`
+			File        string `json:"file"`
+			TotalGuests int    `json:"total_guests"`
+		} `json:"servers"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &index); err != nil {
+		t.Fatalf("invalid JSON index: %v", err)
+	}
+	if len(index.Servers) != 3 || index.Servers[2].Server != "partner" || index.Servers[2].File != "server-partner.json" || index.Servers[2].TotalGuests != 1 {
+		t.Errorf("unexpected JSON index:\n%s", buf.String())
+	}
+}
+
+func TestParseSplitBy(t *testing.T) {
+	for _, s := range []string{"", "team", "server"} {
+		if _, err := ParseSplitBy(s); err != nil {
+			t.Errorf("ParseSplitBy(%q): %v", s, err)
+		}
+	}
+	if _, err := ParseSplitBy("channel"); err == nil {
+		t.Error("expected an error for an unknown split")
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqliteSchema creates the normalized history tables (schema version 1).
+// Every execution adds a row to runs; all other rows reference it by run_id.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS runs (
+	run_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	run_at TEXT NOT NULL,
+	inactive_days INTEGER NOT NULL,
+	inactivity_metric TEXT,
+	total_guests INTEGER NOT NULL,
+	active_guests INTEGER NOT NULL,
+	inactive_guests INTEGER NOT NULL,
+	deactivated_guests INTEGER NOT NULL,
+	excepted_guests INTEGER NOT NULL,
+	failed_lookups INTEGER NOT NULL,
+	retention_policy_guests INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS guests (
+	run_id INTEGER NOT NULL REFERENCES runs(run_id),
+	username TEXT NOT NULL,
+	display_name TEXT,
+	nickname TEXT,
+	email TEXT,
+	created_at TEXT,
+	last_login TEXT,
+	last_post TEXT,
+	active INTEGER NOT NULL,
+	inactive INTEGER NOT NULL,
+	excepted INTEGER NOT NULL,
+	retention_channels INTEGER NOT NULL,
+	error TEXT,
+	PRIMARY KEY (run_id, username)
+);
+CREATE TABLE IF NOT EXISTS guest_teams (
+	run_id INTEGER NOT NULL REFERENCES runs(run_id),
+	username TEXT NOT NULL,
+	team TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS guest_channels (
+	run_id INTEGER NOT NULL REFERENCES runs(run_id),
+	username TEXT NOT NULL,
+	team TEXT NOT NULL,
+	channel TEXT NOT NULL,
+	retention_policy INTEGER NOT NULL
+);
+`
+
+// sqliteMigrations upgrade the history schema one version at a time: entry i
+// takes a database from PRAGMA user_version i to i+1. Append new entries;
+// never edit released ones, as databases in the field have already run them.
+var sqliteMigrations = []string{
+	// 1: IF NOT EXISTS also adopts databases created before the schema was
+	// versioned, which report user_version 0.
+	sqliteSchema,
+	// 2: report fields added since, and an index for per-guest history.
+	`ALTER TABLE guests ADD COLUMN auth_method TEXT;
+ALTER TABLE guests ADD COLUMN private_channels INTEGER;
+ALTER TABLE guest_channels ADD COLUMN channel_type TEXT;
+CREATE INDEX IF NOT EXISTS guests_username ON guests(username);
+`,
+	// 3: run metadata (provenance). Filters are "name=value" pairs joined by pipes.
+	`ALTER TABLE runs ADD COLUMN server_url TEXT;
+ALTER TABLE runs ADD COLUMN server_version TEXT;
+ALTER TABLE runs ADD COLUMN run_by TEXT;
+ALTER TABLE runs ADD COLUMN tool_version TEXT;
+ALTER TABLE runs ADD COLUMN started_at TEXT;
+ALTER TABLE runs ADD COLUMN finished_at TEXT;
+ALTER TABLE runs ADD COLUMN api_calls INTEGER;
+ALTER TABLE runs ADD COLUMN filters TEXT;
+`,
+	// 4: license seats held by guests; licensed_seats is NULL when unknown.
+	`ALTER TABLE runs ADD COLUMN licensed_seats INTEGER;
+ALTER TABLE runs ADD COLUMN guest_seats INTEGER;
+ALTER TABLE runs ADD COLUMN freeable_seats INTEGER;
+`,
+	// 5: language and timezone settings; NULL when never set.
+	`ALTER TABLE guests ADD COLUMN locale TEXT;
+ALTER TABLE guests ADD COLUMN timezone TEXT;
+`,
+	// 6: archived channels, listed with --include-archived.
+	`ALTER TABLE guest_channels ADD COLUMN archived INTEGER NOT NULL DEFAULT 0;
+`,
+	// 7: last channel view, NULL unless --last-viewed was used.
+	`ALTER TABLE guests ADD COLUMN last_viewed TEXT;
+`,
+	// 8: job title from the profile; NULL when never set.
+	`ALTER TABLE guests ADD COLUMN position TEXT;
+`,
+}
+
+// sqliteBusyTimeout is how long a run waits for another run sharing the
+// database to finish its write before giving up.
+const sqliteBusyTimeout = 30 * time.Second
+
+// sqliteVersionConstraint names the check that fails when another run
+// migrated the database between reading its version and writing.
+const sqliteVersionConstraint = "schema_version_unchanged"
+
+// writeSQLite appends the result to the SQLite database at dbPath using the
+// sqlite3 command-line tool, which keeps the binary free of cgo and
+// third-party drivers. If sqlite3 is unavailable or fails, the SQL script is
+// written to stdout instead so the data is not lost.
+//
+// Several runs may share one database: each migrates and inserts in a single
+// write transaction, waiting up to sqliteBusyTimeout for the others.
+func writeSQLite(dbPath string, result *AuditResult) error {
+	runAt := time.Now()
+	var script bytes.Buffer
+	var stderr bytes.Buffer
+	for attempt := 0; ; attempt++ {
+		version, err := sqliteSchemaVersion(dbPath)
+		if err != nil {
+			version = 0 // unreadable; the write below reports the real problem
+		}
+		if version > len(sqliteMigrations) {
+			return fmt.Errorf("%s has history schema version %d, but this version of mm-guest-audit only knows up to %d; upgrade mm-guest-audit", dbPath, version, len(sqliteMigrations))
+		}
+
+		script.Reset()
+		if err := writeSQLScript(&script, result, runAt, version); err != nil {
+			return err
+		}
+
+		cmd := exec.Command("sqlite3", "-bail", dbPath)
+		cmd.Stdin = bytes.NewReader(script.Bytes())
+		stderr.Reset()
+		cmd.Stderr = &stderr
+		started := time.Now()
+		err = cmd.Run()
+		if err == nil {
+			return nil
+		}
+		// Another run upgraded the schema first; start again from its version
+		if strings.Contains(stderr.String(), sqliteVersionConstraint) && attempt < 2 {
+			continue
+		}
+		// Switching a new database to WAL fails at once, without waiting for
+		// the busy timeout, while another run holds it. Nothing was written.
+		if strings.Contains(stderr.String(), "database is locked") && time.Since(started) < sqliteBusyTimeout && attempt < 5 {
+			time.Sleep(time.Duration(attempt+1) * 50 * time.Millisecond)
+			continue
+		}
+
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		logger.Printf("Warning: unable to write to %q with sqlite3: %s — writing SQL to stdout instead", dbPath, msg)
+		_, err = os.Stdout.Write(script.Bytes())
+		return err
+	}
+}
+
+// sqliteSchemaVersion reads the database's PRAGMA user_version. A missing
+// database reports 0.
+func sqliteSchemaVersion(dbPath string) (int, error) {
+	out, err := exec.Command("sqlite3", "-cmd", sqliteTimeoutCommand(), dbPath, "PRAGMA user_version;").Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(out)))
+}
+
+func sqliteTimeoutCommand() string {
+	return fmt.Sprintf(".timeout %d", sqliteBusyTimeout.Milliseconds())
+}
+
+// writeSQLScript renders a script that migrates a database at schema version
+// fromVersion to the latest and inserts one run, in a single transaction.
+// The transaction aborts if the database is no longer at fromVersion.
+func writeSQLScript(w io.Writer, result *AuditResult, runAt time.Time, fromVersion int) error {
+	var b strings.Builder
+	b.WriteString(sqliteTimeoutCommand() + "\n")
+	// WAL lets reports be read while a run is writing
+	b.WriteString("PRAGMA journal_mode=WAL;\n")
+	// IMMEDIATE takes the write lock up front, so concurrent runs queue on
+	// the busy timeout instead of failing part-way through
+	b.WriteString("BEGIN IMMEDIATE;\n")
+	fmt.Fprintf(&b, "CREATE TEMP TABLE schema_version_check (version INTEGER CONSTRAINT %s CHECK (version = %d));\n", sqliteVersionConstraint, fromVersion)
+	b.WriteString("INSERT INTO schema_version_check SELECT user_version FROM pragma_user_version;\n")
+	b.WriteString("DROP TABLE schema_version_check;\n")
+	if fromVersion < len(sqliteMigrations) {
+		for _, m := range sqliteMigrations[fromVersion:] {
+			b.WriteString(m)
+		}
+		fmt.Fprintf(&b, "PRAGMA user_version = %d;\n", len(sqliteMigrations))
+	}
+
+	s := result.Summary
+	meta := []string{"NULL", "NULL", "NULL", "NULL", "NULL", "NULL", "NULL", "NULL"}
+	if m := result.Metadata; m != nil {
+		meta = []string{sqlNullString(m.ServerURL), sqlNullString(m.ServerVersion), sqlNullString(m.RunBy), sqlNullString(m.ToolVersion),
+			sqlTime(&m.StartedAt), sqlTime(&m.FinishedAt), strconv.FormatInt(m.APICalls, 10), sqlNullString(FormatFilters(m.Filters, "|"))}
+	}
+	fmt.Fprintf(&b, "INSERT INTO runs (run_at, inactive_days, inactivity_metric, total_guests, active_guests, inactive_guests, deactivated_guests, excepted_guests, failed_lookups, retention_policy_guests, server_url, server_version, run_by, tool_version, started_at, finished_at, api_calls, filters, licensed_seats, guest_seats, freeable_seats) VALUES (%s, %d, %s, %d, %d, %d, %d, %d, %d, %d, %s, %s, %d, %d);\n",
+		sqlString(runAt.UTC().Format(time.RFC3339)), result.InactiveDays, sqlString(string(result.InactivityMetric)),
+		s.TotalGuests, s.ActiveGuests, s.InactiveGuests, s.DeactivatedGuests, s.ExceptedGuests, s.FailedLookups, s.RetentionGuests,
+		strings.Join(meta, ", "), sqlNullInt(s.License.LicensedSeats), s.License.GuestSeats, s.License.FreeableSeats)
+	b.WriteString("CREATE TEMP TABLE current_run AS SELECT last_insert_rowid() AS run_id;\n")
+	const runID = "(SELECT run_id FROM current_run)"
+
+	for _, g := range result.Guests {
+		// error holds the lookup that failed the whole guest
+		var failure string
+		if g.Failed {
+			failure = formatLookupErrors(g.Errors, "|")
+		}
+		fmt.Fprintf(&b, "INSERT INTO guests (run_id, username, display_name, nickname, email, created_at, last_login, last_post, active, inactive, excepted, retention_channels, error, auth_method, private_channels, locale, timezone, last_viewed, position) VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %d, %d, %d, %d, %s, %s, %d, %s, %s, %s, %s);\n",
+			runID, sqlString(g.Username), sqlString(g.DisplayName), sqlString(g.Nickname), sqlString(g.Email),
+			sqlTime(g.CreatedAt), sqlTime(g.LastLogin), sqlTime(g.LastPost),
+			sqlBool(g.Active), sqlBool(g.Inactive), sqlBool(g.Excepted), g.RetentionChannels, sqlNullString(failure),
+			sqlNullString(g.AuthMethod), g.PrivateChannels, sqlNullString(g.Locale), sqlNullString(g.Timezone), sqlTime(g.LastViewed), sqlNullString(g.Position))
+		for _, t := range g.Teams {
+			fmt.Fprintf(&b, "INSERT INTO guest_teams (run_id, username, team) VALUES (%s, %s, %s);\n", runID, sqlString(g.Username), sqlString(t.DisplayName))
+		}
+		for _, ch := range g.Channels {
+			fmt.Fprintf(&b, "INSERT INTO guest_channels (run_id, username, team, channel, retention_policy, channel_type, archived) VALUES (%s, %s, %s, %s, %d, %s, %d);\n",
+				runID, sqlString(g.Username), sqlString(ch.TeamName), sqlString(ch.ChannelName), sqlBool(ch.RetentionPolicy), sqlNullString(ch.Type), sqlBool(ch.Archived))
+		}
+	}
+
+	b.WriteString("DROP TABLE current_run;\n")
+	b.WriteString("COMMIT;\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func sqlNullString(s string) string {
+	if s == "" {
+		return "NULL"
+	}
+	return sqlString(s)
+}
+
+func sqlNullInt(n *int) string {
+	if n == nil {
+		return "NULL"
+	}
+	return strconv.Itoa(*n)
+}
+
+func sqlTime(t *time.Time) string {
+	if t == nil {
+		return "NULL"
+	}
+	return sqlString(FormatTimeISO(t))
+}
+
+func sqlBool(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
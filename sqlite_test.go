@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteSQLScript(t *testing.T) {
+	result := sampleResult()
+	result.Guests[1].DisplayName = "Bob O'Contractor"
+
+	var buf bytes.Buffer
+	if err := writeSQLScript(&buf, result, time.Date(2024, 11, 20, 9, 0, 0, 0, time.UTC), 0); err != nil {
+		t.Fatalf("writeSQLScript error: %v", err)
+	}
+	script := buf.String()
+
+	for _, want := range []string{
+		"CREATE TABLE IF NOT EXISTS runs",
+		"BEGIN IMMEDIATE;",
+		"CHECK (version = 0)",
+		"PRAGMA user_version = 8;",
+		"'2024-11-20T09:00:00Z', 30",
+		"'Bob O''Contractor'",                                     // quotes escaped
+		"'bob@contractor.io', '2024-03-01T10:00:00Z', NULL, NULL", // nil dates as NULL
+		"VALUES ((SELECT run_id FROM current_run), 'jane.doe', 'Sales', 'Partner Updates', 0, NULL, 0);",
+		"COMMIT;",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q", want)
+		}
+	}
+}
+
+func TestWriteSQLScript_UpToDate(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeSQLScript(&buf, sampleResult(), time.Now(), len(sqliteMigrations)); err != nil {
+		t.Fatalf("writeSQLScript error: %v", err)
+	}
+	script := buf.String()
+	if strings.Contains(script, "CREATE TABLE") || strings.Contains(script, "PRAGMA user_version =") {
+		t.Errorf("script for a current database should not migrate:\n%s", script)
+	}
+	if !strings.Contains(script, fmt.Sprintf("CHECK (version = %d)", len(sqliteMigrations))) {
+		t.Error("script should still check the schema version")
+	}
+}
+
+func TestWriteSQLite_AppendsRuns(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	dbPath := filepath.Join(t.TempDir(), "audit.db")
+
+	for i := 0; i < 2; i++ {
+		if err := writeSQLite(dbPath, sampleResult()); err != nil {
+			t.Fatalf("writeSQLite run %d error: %v", i+1, err)
+		}
+	}
+
+	out, err := exec.Command("sqlite3", dbPath, "SELECT COUNT(*) FROM runs; SELECT COUNT(*) FROM guests WHERE run_id = 2; SELECT COUNT(*) FROM guest_channels;").Output()
+	if err != nil {
+		t.Fatalf("sqlite3 query error: %v", err)
+	}
+	if got := strings.Fields(string(out)); strings.Join(got, ",") != "2,2,8" {
+		t.Errorf("counts = %v, want [2 2 8]", got)
+	}
+}
+
+func TestWriteSQLite_LicenseSeats(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	dbPath := filepath.Join(t.TempDir(), "audit.db")
+	licensed := sampleResult()
+	licensed.LicensedSeats = 500
+	summarize(licensed, nil, time.Now())
+	for _, result := range []*AuditResult{licensed, sampleResult()} {
+		if err := writeSQLite(dbPath, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := sqliteQuery(t, dbPath, "SELECT IFNULL(licensed_seats, 'null'), guest_seats, freeable_seats FROM runs ORDER BY run_id;")
+	if want := "500|2|1,null|0|0"; got != want {
+		t.Errorf("seats = %q, want %q", got, want)
+	}
+}
+
+func sqliteQuery(t *testing.T, dbPath, query string) string {
+	t.Helper()
+	out, err := exec.Command("sqlite3", dbPath, query).Output()
+	if err != nil {
+		t.Fatalf("sqlite3 query error: %v", err)
+	}
+	return strings.Join(strings.Fields(string(out)), ",")
+}
+
+func TestWriteSQLite_Concurrent(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	dbPath := filepath.Join(t.TempDir(), "audit.db")
+
+	const runs = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, runs)
+	for i := 0; i < runs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- writeSQLite(dbPath, sampleResult())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("writeSQLite error: %v", err)
+		}
+	}
+
+	// Every run is complete: 2 guests each, none attributed to another run
+	got := sqliteQuery(t, dbPath, "SELECT COUNT(*) FROM runs; SELECT COUNT(*) FROM guests; SELECT COUNT(DISTINCT run_id) FROM guests; PRAGMA user_version;")
+	if want := fmt.Sprintf("%d,%d,%d,%d", runs, 2*runs, runs, len(sqliteMigrations)); got != want {
+		t.Errorf("runs,guests,guest runs,version = %s, want %s", got, want)
+	}
+}
+
+func TestWriteSQLite_MigratesUnversionedDatabase(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	dbPath := filepath.Join(t.TempDir(), "audit.db")
+	legacy := sqliteSchema + "INSERT INTO runs VALUES (1, '2024-01-01T00:00:00Z', 30, 'login', 1, 1, 0, 0, 0, 0, 0);\n" +
+		"INSERT INTO guests VALUES (1, 'old.guest', '', '', '', NULL, NULL, NULL, 1, 0, 0, 0, NULL);\n"
+	cmd := exec.Command("sqlite3", "-bail", dbPath)
+	cmd.Stdin = strings.NewReader(legacy)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("creating legacy database: %v: %s", err, out)
+	}
+
+	result := sampleResult()
+	result.Guests[0].AuthMethod = AuthMethodSAML
+	if err := writeSQLite(dbPath, result); err != nil {
+		t.Fatalf("writeSQLite error: %v", err)
+	}
+
+	got := sqliteQuery(t, dbPath, "PRAGMA user_version; SELECT COUNT(*) FROM runs; SELECT auth_method FROM guests WHERE username = 'jane.doe';")
+	if want := fmt.Sprintf("%d,2,saml", len(sqliteMigrations)); got != want {
+		t.Errorf("version,runs,auth_method = %s, want %s", got, want)
+	}
+}
+
+func TestWriteSQLite_NewerSchema(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	dbPath := filepath.Join(t.TempDir(), "audit.db")
+	sqliteQuery(t, dbPath, fmt.Sprintf("PRAGMA user_version = %d;", len(sqliteMigrations)+1))
+
+	err := writeSQLite(dbPath, sampleResult())
+	if err == nil || !strings.Contains(err.Error(), "upgrade mm-guest-audit") {
+		t.Errorf("error = %v, want a newer-schema error", err)
+	}
+}
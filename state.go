@@ -0,0 +1,263 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultStateFile is where --since-last-run and --notify-guests keep their
+// state when --state-file is not set.
+const DefaultStateFile = "mm-guest-audit-state.json"
+
+// auditStateVersion is bumped whenever the state file changes shape; a
+// state file of another version is ignored and the run is a full audit.
+const auditStateVersion = 7
+
+// AuditState is what --since-last-run keeps between runs: the previous
+// run's guest records together with the account state and IDs they were
+// built from, which the report leaves out. It is a cache, not a report:
+// it holds emails and IDs, and is written readable by the owner only.
+type AuditState struct {
+	Version           int               `json:"version"`
+	Server            string            `json:"server"`
+	CreatedAt         string            `json:"created_at"`
+	Options           map[string]string `json:"options"`
+	Unavailable       []string          `json:"unavailable_enrichment"`
+	PermissionMissing []string          `json:"permission_missing"`
+	// Sampled is set when the run stopped at --sample, so the state does
+	// not hold every guest and cannot tell who has gone.
+	Sampled bool         `json:"sampled,omitempty"`
+	Guests  []stateGuest `json:"guests"`
+}
+
+// stateGuest is a GuestRecord with the fields the report leaves out. The
+// outer fields take precedence over the record's own in JSON.
+type stateGuest struct {
+	GuestRecord
+	UserID     string         `json:"user_id"`
+	UpdateAt   int64          `json:"update_at"`
+	ActivityAt int64          `json:"last_activity_at"`
+	Channels   []stateChannel `json:"channels"`
+}
+
+type stateChannel struct {
+	ChannelInfo
+	ID      string `json:"id"`
+	Default bool   `json:"default"`
+}
+
+// stateOptions names the options that shape each guest record. A state
+// file written with other options is not reused: a record built for one
+// --team, or without --check-roles, is wrong for another run. Options that
+// are reapplied to reused records (--inactive-days, --deactivated-older-than,
+// --max-guest-age, --allowlist, --sort) are left out.
+func stateOptions(opts AuditOptions) map[string]string {
+	options := make(map[string]string)
+	for _, f := range AppliedFilters(opts) {
+		if f.Name != "sample" {
+			options[f.Name] = f.Value
+		}
+	}
+	flags := map[string]bool{
+		"identity_history": opts.IdentityHistory,
+		"file_activity":    opts.FileActivity,
+		"plugin_access":    opts.PluginAccess,
+		"shared_sessions":  opts.SharedSessions,
+		"check_roles":      opts.CheckRoles,
+		"guest_only":       opts.GuestOnly,
+		"groups":           opts.Groups,
+		"bulk_channels":    opts.BulkChannels,
+		"last_viewed":      opts.LastViewed,
+		"audit_log":        opts.AuditLog,
+	}
+	for name, set := range flags {
+		if set {
+			options[name] = strconv.FormatBool(set)
+		}
+	}
+	if len(opts.GuestRoles) > 0 {
+		options["guest_roles"] = strings.Join(opts.GuestRoles, "|")
+	}
+	return options
+}
+
+// NewAuditState records result, audited on server with opts, for the next
+// --since-last-run.
+func NewAuditState(server string, result *AuditResult, opts AuditOptions, now time.Time) *AuditState {
+	state := &AuditState{
+		Version:           auditStateVersion,
+		Server:            normalizeServerURL(server),
+		CreatedAt:         now.UTC().Format(time.RFC3339),
+		Options:           stateOptions(opts),
+		Unavailable:       result.UnavailableEnrichment,
+		PermissionMissing: result.PermissionMissing,
+		Sampled:           opts.Sample > 0,
+		Guests:            make([]stateGuest, 0, len(result.Guests)),
+	}
+	for _, g := range result.Guests {
+		channels := make([]stateChannel, len(g.Channels))
+		for i, ch := range g.Channels {
+			channels[i] = stateChannel{ChannelInfo: ch, ID: ch.ID, Default: ch.Default}
+		}
+		state.Guests = append(state.Guests, stateGuest{
+			GuestRecord: g,
+			UserID:      g.UserID,
+			UpdateAt:    g.UpdateAt,
+			ActivityAt:  g.ActivityAt,
+			Channels:    channels,
+		})
+	}
+	return state
+}
+
+// WriteAuditState writes state to path, replacing it atomically so an
+// interrupted run never leaves a truncated state behind.
+func WriteAuditState(state *AuditState, path string) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, append(data, '\n'))
+}
+
+// LoadAuditState reads a state file written by WriteAuditState. A missing
+// file is not an error: it returns nil, and the run is a full audit.
+func LoadAuditState(path string) (*AuditState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state AuditState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("not a state file: %v", err)
+	}
+	return &state, nil
+}
+
+// Previous returns the recorded result for use as AuditOptions.Previous, or
+// nil and the reason when the state cannot be reused for a run on server
+// with opts.
+func (s *AuditState) Previous(server string, opts AuditOptions) (*AuditResult, string) {
+	switch {
+	case s.Version != auditStateVersion:
+		return nil, fmt.Sprintf("it was written by another version of mm-guest-audit (state version %d)", s.Version)
+	case s.Server != normalizeServerURL(server):
+		return nil, "it was written for " + s.Server
+	case !maps.Equal(s.Options, stateOptions(opts)):
+		return nil, "it was written with different filters or enrichment flags"
+	}
+	result := &AuditResult{
+		UnavailableEnrichment: s.Unavailable,
+		PermissionMissing:     s.PermissionMissing,
+		Guests:                make([]GuestRecord, len(s.Guests)),
+	}
+	for i, sg := range s.Guests {
+		g := sg.GuestRecord
+		g.UserID = sg.UserID
+		g.UpdateAt = sg.UpdateAt
+		g.ActivityAt = sg.ActivityAt
+		g.Channels = make([]ChannelInfo, len(sg.Channels))
+		for j, ch := range sg.Channels {
+			g.Channels[j] = ch.ChannelInfo
+			g.Channels[j].ID = ch.ID
+			g.Channels[j].Default = ch.Default
+		}
+		result.Guests[i] = g
+	}
+	return result, ""
+}
+
+// GuestChanges lists who became or stopped being a guest between the run
+// that wrote a state file and the current one.
+type GuestChanges struct {
+	Since   time.Time      // when the state file was written
+	New     []string       // usernames of the guests marked New, sorted
+	Removed []RemovedGuest // sorted by username
+}
+
+// RemovedGuest is a guest in the state file who is no longer reported:
+// deleted, made a member, or moved out of the filters' reach.
+type RemovedGuest struct {
+	Username    string   `json:"username"`
+	DisplayName string   `json:"display_name"`
+	Email       string   `json:"email"`
+	Teams       []string `json:"teams"`
+}
+
+// guestSetOptions keeps the stateOptions that decide who is reported at
+// all, leaving out the enrichment flags, which only shape the records.
+func guestSetOptions(options map[string]string) map[string]string {
+	set := make(map[string]string)
+	for name, value := range options {
+		if name == "guest_roles" || filterRank(name) < len(appliedFilters) {
+			set[name] = value
+		}
+	}
+	return set
+}
+
+// Changes compares result, audited on server with opts, with the guests
+// recorded in the state, matching them by user ID so a renamed guest is
+// not counted as new. It marks the new guests in result. It returns nil
+// and the reason when the two runs cannot be compared: a state for
+// another server, version or set of filters, or either run a --sample.
+// Members listed with --include-members-with-domain are left out.
+func (s *AuditState) Changes(server string, result *AuditResult, opts AuditOptions) (*GuestChanges, string) {
+	switch {
+	case s.Version != auditStateVersion:
+		return nil, fmt.Sprintf("it was written by another version of mm-guest-audit (state version %d)", s.Version)
+	case s.Server != normalizeServerURL(server):
+		return nil, "it was written for " + s.Server
+	case s.Sampled || opts.Sample > 0:
+		return nil, "a run with --sample does not see every guest"
+	case !maps.Equal(guestSetOptions(s.Options), guestSetOptions(stateOptions(opts))):
+		return nil, "it was written with different filters"
+	}
+	since, err := time.Parse(time.RFC3339, s.CreatedAt)
+	if err != nil {
+		return nil, fmt.Sprintf("its creation time %q is not valid", s.CreatedAt)
+	}
+
+	before := make(map[string]bool, len(s.Guests))
+	for _, sg := range s.Guests {
+		if !sg.ShouldBeGuest {
+			before[sg.UserID] = true
+		}
+	}
+	changes := &GuestChanges{Since: since, New: []string{}, Removed: []RemovedGuest{}}
+	seen := make(map[string]bool, len(result.Guests))
+	for i := range result.Guests {
+		g := &result.Guests[i]
+		if g.ShouldBeGuest {
+			continue
+		}
+		seen[g.UserID] = true
+		if !before[g.UserID] {
+			g.New = true
+			changes.New = append(changes.New, g.Username)
+		}
+	}
+	for _, sg := range s.Guests {
+		if sg.ShouldBeGuest || seen[sg.UserID] {
+			continue
+		}
+		teams := make([]string, len(sg.Teams))
+		for i, t := range sg.Teams {
+			teams[i] = t.DisplayName
+		}
+		changes.Removed = append(changes.Removed, RemovedGuest{Username: sg.Username, DisplayName: sg.DisplayName, Email: sg.Email, Teams: teams})
+	}
+	slices.Sort(changes.New)
+	slices.SortFunc(changes.Removed, func(a, b RemovedGuest) int { return cmp.Compare(a.Username, b.Username) })
+	return changes, ""
+}
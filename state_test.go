@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestAuditState_RoundTrip(t *testing.T) {
+	guests := sampleGuests(2)
+	client := &mockClient{
+		guests: guests,
+		teams: map[string][]*model.Team{
+			"user0": {{Id: "team1", DisplayName: "Engineering"}},
+			"user1": {{Id: "team1", DisplayName: "Engineering"}},
+		},
+		channels: map[string][]*model.Channel{
+			"team1:user0": {{Id: "ch1", Name: "town-square", DisplayName: "Town Square", Type: model.ChannelTypeOpen}},
+		},
+	}
+	opts := AuditOptions{InactiveDays: 90, CheckRoles: true}
+	first, _ := RunAudit(client, opts)
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := WriteAuditState(NewAuditState("https://chat.example.com", first, opts, time.Now()), path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("state file mode = %o, want 600", perm)
+	}
+	state, err := LoadAuditState(path)
+	if err != nil {
+		t.Fatalf("LoadAuditState: %v", err)
+	}
+	prev, reason := state.Previous("https://CHAT.example.com/", opts)
+	if prev == nil {
+		t.Fatalf("state not reused: %s", reason)
+	}
+
+	// The fields the report leaves out survive the round trip
+	g := prev.Guests[0]
+	if g.UserID != "user0" || g.UpdateAt != guests[0].UpdateAt || len(g.Channels) != 1 || g.Channels[0].ID != "ch1" || g.Channels[0].Default != first.Guests[0].Channels[0].Default {
+		t.Errorf("unexpected reloaded record: %+v", g)
+	}
+
+	// An unchanged guest is reused from the reloaded state
+	guests[1].UpdateAt = 1730000000000
+	client.channelCalls = 0
+	opts.Previous = prev
+	second, _ := RunAudit(client, opts)
+	if client.channelCalls != 1 {
+		t.Errorf("channel calls = %d, want 1", client.channelCalls)
+	}
+	if second.Guests[0].Checksum != first.Guests[0].Checksum {
+		t.Error("reused record checksum changed")
+	}
+}
+
+func TestAuditState_Previous(t *testing.T) {
+	opts := AuditOptions{TeamFilter: "Engineering", CheckRoles: true}
+	state := NewAuditState("https://chat.example.com", &AuditResult{}, opts, time.Now())
+
+	tests := []struct {
+		name   string
+		server string
+		opts   AuditOptions
+		reused bool
+	}{
+		{"same options", "https://chat.example.com", opts, true},
+		{"reapplied options ignored", "https://chat.example.com", AuditOptions{TeamFilter: "Engineering", CheckRoles: true, InactiveDays: 30, Sample: 5}, true},
+		{"other server", "https://staging.example.com", opts, false},
+		{"other team", "https://chat.example.com", AuditOptions{TeamFilter: "Sales", CheckRoles: true}, false},
+		{"enrichment dropped", "https://chat.example.com", AuditOptions{TeamFilter: "Engineering"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev, reason := state.Previous(tt.server, tt.opts)
+			if (prev != nil) != tt.reused {
+				t.Errorf("reused = %t (%s), want %t", prev != nil, reason, tt.reused)
+			}
+		})
+	}
+
+	state.Version = auditStateVersion + 1
+	if prev, _ := state.Previous("https://chat.example.com", opts); prev != nil {
+		t.Error("expected a newer state version to be ignored")
+	}
+}
+
+func TestLoadAuditState(t *testing.T) {
+	dir := t.TempDir()
+	state, err := LoadAuditState(filepath.Join(dir, "missing.json"))
+	if state != nil || err != nil {
+		t.Errorf("missing file = %v, %v; want nil, nil", state, err)
+	}
+
+	path := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAuditState(path); err == nil {
+		t.Error("expected an error for a corrupt state file")
+	}
+}
+
+func TestAuditState_Changes(t *testing.T) {
+	opts := AuditOptions{TeamFilter: "Engineering"}
+	prev := &AuditResult{Guests: []GuestRecord{
+		{UserID: "user0", Username: "ann"},
+		{UserID: "user1", Username: "bob", Email: "bob@vendor.io", Teams: []TeamInfo{{DisplayName: "Engineering"}}},
+		{UserID: "user9", Username: "member", ShouldBeGuest: true},
+	}}
+	written := time.Date(2026, 10, 9, 8, 0, 0, 0, time.UTC)
+	state := NewAuditState("https://chat.example.com", prev, opts, written)
+
+	// Ann was renamed, Bob has gone and Cara is new; the member is not a guest
+	result := &AuditResult{Guests: []GuestRecord{
+		{UserID: "user2", Username: "cara"},
+		{UserID: "user0", Username: "ann.smith"},
+		{UserID: "user8", Username: "member2", ShouldBeGuest: true},
+	}}
+	changes, reason := state.Changes("https://chat.example.com/", result, AuditOptions{TeamFilter: "Engineering", CheckRoles: true, InactiveDays: 30})
+	if changes == nil {
+		t.Fatalf("not compared: %s", reason)
+	}
+	if !changes.Since.Equal(written) || len(changes.New) != 1 || changes.New[0] != "cara" {
+		t.Errorf("unexpected changes: %+v", changes)
+	}
+	if len(changes.Removed) != 1 || changes.Removed[0].Username != "bob" || changes.Removed[0].Teams[0] != "Engineering" {
+		t.Errorf("removed = %+v", changes.Removed)
+	}
+	if !result.Guests[0].New || result.Guests[1].New || result.Guests[2].New {
+		t.Errorf("new guests not marked: %+v", result.Guests)
+	}
+
+	tests := []struct {
+		name   string
+		state  *AuditState
+		server string
+		opts   AuditOptions
+	}{
+		{"other server", state, "https://staging.example.com", opts},
+		{"other team", state, "https://chat.example.com", AuditOptions{TeamFilter: "Sales"}},
+		{"sampled now", state, "https://chat.example.com", AuditOptions{TeamFilter: "Engineering", Sample: 5}},
+		{"sampled before", NewAuditState("https://chat.example.com", prev, AuditOptions{TeamFilter: "Engineering", Sample: 5}, written), "https://chat.example.com", opts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if changes, _ := tt.state.Changes(tt.server, &AuditResult{}, tt.opts); changes != nil {
+				t.Errorf("expected no comparison, got %+v", changes)
+			}
+		})
+	}
+}
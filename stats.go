@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// StepListing is the stats stage for listing guests and members, before any
+// per-guest lookup.
+const StepListing = "listing"
+
+// RunStats describes the load an audit put on the server, for --stats: how
+// long it ran, how many API requests it sent, how much data came back and
+// how many lookups were answered from a cache instead.
+type RunStats struct {
+	Elapsed       time.Duration
+	APICalls      int64
+	BytesReceived int64
+	CacheHits     int
+	ReusedGuests  int // unchanged records reused with --since-last-run
+	Stages        []StageStats
+}
+
+// StageStats is one stage of a run: the guest listing or an enrichment step.
+// Calls made outside any stage (resolving --team and --channel) count only
+// towards the run's totals. Authentication comes before the audit starts and
+// is not counted, so the totals match the metadata's api_calls.
+type StageStats struct {
+	Stage         string
+	Guests        int // guests listed, or guests the step ran for
+	APICalls      int64
+	BytesReceived int64
+	CacheHits     int
+	Elapsed       time.Duration
+}
+
+// Write prints the stats block.
+func (s *RunStats) Write(w io.Writer) {
+	if s == nil {
+		return
+	}
+	fmt.Fprintln(w, "API usage:")
+	rate := 0.0
+	if s.Elapsed > 0 {
+		rate = float64(s.APICalls) / s.Elapsed.Seconds()
+	}
+	fmt.Fprintf(w, "  Elapsed:        %s\n", s.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "  API calls:      %d (%.1f per second)\n", s.APICalls, rate)
+	fmt.Fprintf(w, "  Data received:  %s\n", formatBytes(s.BytesReceived))
+	fmt.Fprintf(w, "  Cache hits:     %d\n", s.CacheHits)
+	if s.ReusedGuests > 0 {
+		fmt.Fprintf(w, "  Reused guests:  %d\n", s.ReusedGuests)
+	}
+	if len(s.Stages) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  STAGE\tGUESTS\tAPI CALLS\tRECEIVED\tCACHE HITS\tTIME")
+	for _, st := range s.Stages {
+		fmt.Fprintf(tw, "  %s\t%d\t%d\t%s\t%d\t%s\n", st.Stage, st.Guests, st.APICalls, formatBytes(st.BytesReceived), st.CacheHits, st.Elapsed.Round(time.Millisecond))
+	}
+	tw.Flush()
+}
+
+// formatBytes renders n bytes with a decimal unit, e.g. "1.5 MB".
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
+
+// jsonRunStats is the JSON representation of RunStats, with durations in
+// milliseconds.
+type jsonRunStats struct {
+	ElapsedMs     int64            `json:"elapsed_ms"`
+	APICalls      int64            `json:"api_calls"`
+	BytesReceived int64            `json:"bytes_received"`
+	CacheHits     int              `json:"cache_hits"`
+	ReusedGuests  int              `json:"reused_guests"`
+	Stages        []jsonStageStats `json:"stages"`
+}
+
+type jsonStageStats struct {
+	Stage         string `json:"stage"`
+	Guests        int    `json:"guests"`
+	APICalls      int64  `json:"api_calls"`
+	BytesReceived int64  `json:"bytes_received"`
+	CacheHits     int    `json:"cache_hits"`
+	ElapsedMs     int64  `json:"elapsed_ms"`
+}
+
+func toJSONStats(s *RunStats) *jsonRunStats {
+	if s == nil {
+		return nil
+	}
+	out := &jsonRunStats{
+		ElapsedMs:     s.Elapsed.Milliseconds(),
+		APICalls:      s.APICalls,
+		BytesReceived: s.BytesReceived,
+		CacheHits:     s.CacheHits,
+		ReusedGuests:  s.ReusedGuests,
+		Stages:        []jsonStageStats{},
+	}
+	for _, st := range s.Stages {
+		out.Stages = append(out.Stages, jsonStageStats{
+			Stage:         st.Stage,
+			Guests:        st.Guests,
+			APICalls:      st.APICalls,
+			BytesReceived: st.BytesReceived,
+			CacheHits:     st.CacheHits,
+			ElapsedMs:     st.Elapsed.Milliseconds(),
+		})
+	}
+	return out
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{999, "999 B"},
+		{1500, "1.5 kB"},
+		{2_300_000, "2.3 MB"},
+		{4_000_000_000, "4.0 GB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestRunStatsWrite(t *testing.T) {
+	stats := &RunStats{
+		Elapsed:       4 * time.Second,
+		APICalls:      120,
+		BytesReceived: 2_300_000,
+		CacheHits:     7,
+		Stages: []StageStats{
+			{Stage: StepListing, Guests: 40, APICalls: 2, BytesReceived: 90_000, Elapsed: 300 * time.Millisecond},
+			{Stage: StepPostCount, Guests: 40, APICalls: 12, CacheHits: 7, Elapsed: time.Second},
+		},
+	}
+	var buf bytes.Buffer
+	stats.Write(&buf)
+	out := buf.String()
+	for _, want := range []string{"API calls:      120 (30.0 per second)", "Data received:  2.3 MB", "Cache hits:     7", "listing", "post count"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("stats missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "Reused guests") {
+		t.Errorf("reused guests shown without --since-last-run:\n%s", out)
+	}
+}
+
+func TestRunAudit_Stats(t *testing.T) {
+	general := &model.Channel{Id: "ch1", DisplayName: "General", Type: model.ChannelTypeOpen}
+	team := &model.Team{Id: "team1", DisplayName: "Engineering"}
+	client := &mockClient{
+		guests:       sampleGuests(2),
+		teams:        map[string][]*model.Team{"user0": {team}, "user1": {team}},
+		channels:     map[string][]*model.Channel{"team1:user0": {general}, "team1:user1": {general}},
+		channelPosts: map[string]map[string]int{"ch1": {"user0": 3}},
+	}
+
+	result, _ := RunAudit(client, AuditOptions{})
+	if result.Stats != nil {
+		t.Errorf("stats collected without Stats: %+v", result.Stats)
+	}
+
+	result, _ = RunAudit(client, AuditOptions{Stats: true, PostCount: true})
+	s := result.Stats
+	if s == nil {
+		t.Fatal("no stats with Stats")
+	}
+	if s.Stages[0].Stage != StepListing || s.Stages[0].Guests != 2 {
+		t.Errorf("first stage = %+v, want the listing of 2 guests", s.Stages[0])
+	}
+	// The channel is read for the first guest and cached for the second
+	if s.CacheHits != 1 {
+		t.Errorf("cache hits = %d, want 1", s.CacheHits)
+	}
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Stats *struct {
+			CacheHits int `json:"cache_hits"`
+			Stages    []struct {
+				Stage string `json:"stage"`
+			} `json:"stages"`
+		} `json:"stats"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Stats == nil || out.Stats.CacheHits != 1 || out.Stats.Stages[0].Stage != StepListing {
+		t.Errorf("JSON stats = %+v", out.Stats)
+	}
+}
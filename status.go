@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RunStatus is the machine-readable outcome of one run, written with
+// --status-file whatever the report format.
+type RunStatus struct {
+	ExitCode        int     `json:"exit_code"`
+	Status          string  `json:"status"` // ExitCodeName(ExitCode)
+	StartedAt       string  `json:"started_at"`
+	FinishedAt      string  `json:"finished_at"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Format          string  `json:"format"`
+	// ReportFiles are the paths the report was written to; null when it
+	// went to stdout or no report was written.
+	ReportFiles []string `json:"report_files"`
+	// Counts is null when the run ended before an audit result existed.
+	Counts *StatusCounts `json:"counts"`
+}
+
+// StatusCounts is the subset of AuditSummary that wrapper scripts act on.
+type StatusCounts struct {
+	TotalGuests       int `json:"total_guests"`
+	ActiveGuests      int `json:"active_guests"`
+	InactiveGuests    int `json:"inactive_guests"`
+	DeactivatedGuests int `json:"deactivated_guests"`
+	ExceptedGuests    int `json:"excepted_guests"`
+	FailedLookups     int `json:"failed_lookups"`
+}
+
+// SetResult records the counts from an audit result.
+func (s *RunStatus) SetResult(result *AuditResult) {
+	sum := result.Summary
+	s.Counts = &StatusCounts{
+		TotalGuests:       sum.TotalGuests,
+		ActiveGuests:      sum.ActiveGuests,
+		InactiveGuests:    sum.InactiveGuests,
+		DeactivatedGuests: sum.DeactivatedGuests,
+		ExceptedGuests:    sum.ExceptedGuests,
+		FailedLookups:     sum.FailedLookups,
+	}
+}
+
+// Finish records the exit code and timing of a run.
+func (s *RunStatus) Finish(exitCode int, started, finished time.Time) {
+	s.ExitCode = exitCode
+	s.Status = ExitCodeName(exitCode)
+	s.StartedAt = FormatTimeISO(&started)
+	s.FinishedAt = FormatTimeISO(&finished)
+	s.DurationSeconds = finished.Sub(started).Round(time.Millisecond).Seconds()
+}
+
+// WriteStatusFile writes s to path as indented JSON. The file is written
+// under a temporary name and renamed into place, so a reader never sees a
+// partial status.
+func WriteStatusFile(path string, s *RunStatus) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteStatusFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "status.json")
+
+	status := &RunStatus{Format: "csv", ReportFiles: []string{"guests.csv"}}
+	status.SetResult(sampleResult())
+	started := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	status.Finish(ExitPartialFailure, started, started.Add(1500*time.Millisecond))
+
+	if err := WriteStatusFile(path, status); err != nil {
+		t.Fatalf("WriteStatusFile error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got RunStatus
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("status is not JSON: %v\n%s", err, data)
+	}
+
+	if got.ExitCode != 3 || got.Status != "partial_failure" {
+		t.Errorf("exit = %d %q, want 3 partial_failure", got.ExitCode, got.Status)
+	}
+	if got.StartedAt != "2026-03-01T09:00:00Z" || got.DurationSeconds != 1.5 {
+		t.Errorf("started_at = %q, duration = %v", got.StartedAt, got.DurationSeconds)
+	}
+	if got.Counts == nil || got.Counts.TotalGuests != 2 {
+		t.Errorf("counts = %+v, want 2 guests", got.Counts)
+	}
+
+	// Only the status file remains; the temporary file was renamed
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want 1", len(entries))
+	}
+}
+
+func TestWriteStatusFile_NoResult(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	status := &RunStatus{Format: "table"}
+	status.Finish(ExitConfigError, time.Now(), time.Now())
+	if err := WriteStatusFile(path, status); err != nil {
+		t.Fatalf("WriteStatusFile error: %v", err)
+	}
+
+	var got map[string]any
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"counts", "report_files"} {
+		if v, ok := got[key]; !ok || v != nil {
+			t.Errorf("%s = %v, want null", key, v)
+		}
+	}
+}
+
+func TestExitCodeName(t *testing.T) {
+	tests := map[int]string{
+		ExitSuccess:          "success",
+		ExitAPIError:         "api_error",
+		ExitOutputError:      "output_error",
+		ExitPolicyViolations: "policy_violations",
+		99:                   "unknown",
+	}
+	for code, want := range tests {
+		if got := ExitCodeName(code); got != want {
+			t.Errorf("ExitCodeName(%d) = %q, want %q", code, got, want)
+		}
+	}
+}
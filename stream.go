@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+)
+
+// FormatNDJSON writes one JSON object per guest per line. It is the JSON
+// format --stream can write, as --format json wraps every guest in one
+// document.
+const FormatNDJSON = "ndjson"
+
+// GuestStream writes guests to a csv or ndjson report as RunAudit completes
+// them, for --stream: memory then holds the summary counts rather than
+// every record, however many guests there are. Rows come in server order,
+// since sorting would need them all.
+type GuestStream struct {
+	format      string
+	timeFormat  TimeFormat
+	redactor    *Redactor // --redact, applied to each record before it is written
+	w           io.Writer
+	closeOutput func()
+	cw          *csv.Writer
+	enc         *json.Encoder
+	layout      *AuditResult // the columns, from Begin
+	extra       map[string]string
+	closed      bool
+}
+
+// OpenGuestStream opens the report at outputPath, with the usual stdout
+// fallback, for a streamed audit in format, csv or ndjson.
+func OpenGuestStream(format, outputPath string, timeFormat TimeFormat, redactor *Redactor) *GuestStream {
+	w, closeOutput := openOutput(outputPath)
+	return &GuestStream{format: format, timeFormat: timeFormat, redactor: redactor, w: w, closeOutput: closeOutput}
+}
+
+// Begin is called by RunAudit once the report's columns are known, before
+// the first guest: the CSV header depends on --profile-fields and the
+// configured extra fields.
+func (s *GuestStream) Begin(result *AuditResult) error {
+	s.layout = &AuditResult{ProfileFields: result.ProfileFields, ExtraFields: result.ExtraFields, TimeFormat: s.timeFormat}
+	if s.format == FormatNDJSON {
+		s.enc = json.NewEncoder(s.w)
+		s.extra = jsonExtraFields(s.layout)
+		return nil
+	}
+	s.cw = csv.NewWriter(s.w)
+	return s.cw.Write(csvHeaderRow(s.layout, false))
+}
+
+// Write writes one guest. CSV rows are flushed as they are written, so a
+// reader following the file sees each guest as it completes.
+func (s *GuestStream) Write(g GuestRecord) error {
+	if s.redactor != nil {
+		s.redactor.Guest(&g)
+	}
+	if s.enc != nil {
+		return s.enc.Encode(toJSONGuest(g, s.extra))
+	}
+	if err := s.cw.Write(csvRow(s.layout, g, false)); err != nil {
+		return err
+	}
+	s.cw.Flush()
+	return s.cw.Error()
+}
+
+// Close closes the report. It may be called more than once.
+func (s *GuestStream) Close() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.closeOutput()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRunAudit_Stream(t *testing.T) {
+	extra := []ExtraField{{Name: "environment", Value: "prod"}}
+	full, _ := RunAudit(&mockClient{guests: sampleGuests(3)}, AuditOptions{InactiveDays: 30, ExtraFields: extra})
+
+	path := filepath.Join(t.TempDir(), "guests.csv")
+	stream := OpenGuestStream("csv", path, TimeFormat{}, nil)
+	streamed, exitCode := RunAudit(&mockClient{guests: sampleGuests(3)}, AuditOptions{InactiveDays: 30, ExtraFields: extra, Stream: stream})
+	stream.Close()
+	if exitCode != ExitSuccess {
+		t.Fatalf("exit code = %d", exitCode)
+	}
+	if len(streamed.Guests) != 0 {
+		t.Errorf("streamed guests should not be kept, got %d", len(streamed.Guests))
+	}
+	if !reflect.DeepEqual(streamed.Summary, full.Summary) {
+		t.Errorf("summary = %+v, want %+v", streamed.Summary, full.Summary)
+	}
+
+	// The streamed file matches the report written at the end
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	if err := writeCSV(&want, full); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want.String() {
+		t.Errorf("streamed CSV:\n%s\nwant:\n%s", got, want.String())
+	}
+}
+
+func TestRunAudit_StreamNDJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guests.ndjson")
+	stream := OpenGuestStream(FormatNDJSON, path, TimeFormat{}, NewRedactor([]string{RedactEmail}))
+	result, _ := RunAudit(&mockClient{guests: sampleGuests(5)}, AuditOptions{Sample: 2, Stream: stream})
+	stream.Close()
+	if result.Summary.TotalGuests != 2 {
+		t.Errorf("total guests = %d, want the sample of 2", result.Summary.TotalGuests)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []jsonGuestRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var g jsonGuestRecord
+		if err := json.Unmarshal(scanner.Bytes(), &g); err != nil {
+			t.Fatalf("line %d: %v", len(lines)+1, err)
+		}
+		lines = append(lines, g)
+	}
+	if len(lines) != 2 || lines[0].Username != "guest0" || lines[1].Username != "guest1" {
+		t.Fatalf("unexpected lines: %+v", lines)
+	}
+	if lines[0].Email != "g***@partner.example.com" {
+		t.Errorf("--redact not applied to the stream: %q", lines[0].Email)
+	}
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	result := &AuditResult{Guests: []GuestRecord{{Username: "a"}, {Username: "b"}}}
+	var buf bytes.Buffer
+	if err := writeNDJSON(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(buf.Bytes(), []byte("\n")); n != 2 {
+		t.Errorf("expected one line per guest, got %d:\n%s", n, buf.String())
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// maxTeamSuggestions caps the "did you mean" list for an unknown --team.
+const maxTeamSuggestions = 3
+
+// ResolveTeam finds the team a --team value refers to: its URL name, display
+// name or ID. Names match case-insensitively. When nothing matches, the error
+// suggests the closest team names.
+func ResolveTeam(client MattermostClient, value string) (*model.Team, error) {
+	team, err := client.GetTeamByName(value)
+	if err == nil {
+		return team, nil
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 404 {
+		return nil, err
+	}
+
+	// Not a URL name; list the teams to try IDs and display names
+	teams, listErr := client.GetAllTeams()
+	if listErr != nil {
+		return nil, err
+	}
+	matches := matchTeams(teams, value)
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		if names := suggestTeams(teams, value); len(names) > 0 {
+			return nil, fmt.Errorf("error: team %q not found. Did you mean %s?", value, quoteJoin(names, " or "))
+		}
+		return nil, fmt.Errorf("error: team %q not found. Use the team's name, display name or ID", value)
+	default:
+		names := make([]string, len(matches))
+		for i, t := range matches {
+			names[i] = t.Name
+		}
+		return nil, fmt.Errorf("error: %d teams are displayed as %q. Use the team name instead: %s", len(matches), value, quoteJoin(names, ", "))
+	}
+}
+
+// matchTeams returns the team whose ID or URL name is value, or failing that
+// every team whose display name is value, ignoring case.
+func matchTeams(teams []*model.Team, value string) []*model.Team {
+	for _, t := range teams {
+		if t.Id == value || strings.EqualFold(t.Name, value) {
+			return []*model.Team{t}
+		}
+	}
+	var matches []*model.Team
+	for _, t := range teams {
+		if strings.EqualFold(t.DisplayName, value) {
+			matches = append(matches, t)
+		}
+	}
+	return matches
+}
+
+// suggestTeams returns the URL names of the teams closest to value, nearest
+// first. Names and display names are compared ignoring case and punctuation,
+// and a team qualifies if either is within a few edits of value or contains it.
+func suggestTeams(teams []*model.Team, value string) []string {
+	want := normalizeTeamName(value)
+	if want == "" {
+		return nil
+	}
+	maxDist := max(2, len(want)/4)
+
+	type candidate struct {
+		name string
+		dist int
+	}
+	var candidates []candidate
+	for _, t := range teams {
+		best := -1
+		for _, s := range []string{t.Name, t.DisplayName} {
+			got := normalizeTeamName(s)
+			d := editDistance(want, got)
+			if got != "" && (strings.Contains(got, want) || strings.Contains(want, got)) {
+				d = min(d, 1)
+			}
+			if d <= maxDist && (best < 0 || d < best) {
+				best = d
+			}
+		}
+		if best >= 0 {
+			candidates = append(candidates, candidate{t.Name, best})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].dist != candidates[j].dist {
+			return candidates[i].dist < candidates[j].dist
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	var names []string
+	for _, c := range candidates {
+		if len(names) == maxTeamSuggestions {
+			break
+		}
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// normalizeTeamName lowercases s and drops everything but letters and
+// digits, so "Sales EMEA", "sales-emea" and "sales_emea" compare equal.
+func normalizeTeamName(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// editDistance is the Levenshtein distance between a and b, by rune.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func quoteJoin(names []string, sep string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = fmt.Sprintf("%q", n)
+	}
+	return strings.Join(quoted, sep)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestResolveTeam(t *testing.T) {
+	emea := &model.Team{Id: "abcdefghijklmnopqrstuvwxyz", Name: "sales-emea", DisplayName: "Sales EMEA"}
+	apac := &model.Team{Id: "bcdefghijklmnopqrstuvwxyza", Name: "sales-apac", DisplayName: "Sales APAC"}
+	eng := &model.Team{Id: "cdefghijklmnopqrstuvwxyzab", Name: "engineering", DisplayName: "Engineering"}
+	client := &mockClient{
+		teamByName: map[string]*model.Team{"sales-emea": emea, "sales-apac": apac, "engineering": eng},
+		allTeams:   []*model.Team{emea, apac, eng},
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string // team name, or a substring of the error
+		wantErr bool
+	}{
+		{"URL name", "sales-emea", "sales-emea", false},
+		{"display name", "Sales EMEA", "sales-emea", false},
+		{"display name ignores case", "sales emea", "sales-emea", false},
+		{"stray space suggests", "engineering ", `Did you mean "engineering"?`, true},
+		{"ID", "bcdefghijklmnopqrstuvwxyza", "sales-apac", false},
+		{"name ignores case", "Sales-APAC", "sales-apac", false},
+		{"typo suggests", "sales-emae", `Did you mean "sales-emea"?`, true},
+		{"partial name suggests both", "sales", `Did you mean "sales-apac" or "sales-emea"?`, true},
+		{"no close match", "marketing", "Use the team's name, display name or ID", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			team, err := ResolveTeam(client, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got team %q", team.Name)
+				}
+				if !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("error = %q, want it to contain %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if team.Name != tt.want {
+				t.Errorf("team = %q, want %q", team.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveTeam_AmbiguousDisplayName(t *testing.T) {
+	client := &mockClient{allTeams: []*model.Team{
+		{Id: "t1", Name: "partners-a", DisplayName: "Partners"},
+		{Id: "t2", Name: "partners-b", DisplayName: "Partners"},
+	}}
+	_, err := ResolveTeam(client, "partners")
+	if err == nil || !strings.Contains(err.Error(), `"partners-a", "partners-b"`) {
+		t.Errorf("error = %v, want both team names listed", err)
+	}
+}
+
+func TestResolveTeam_OtherErrors(t *testing.T) {
+	denied := &APIError{StatusCode: 403, Message: "error: permission denied"}
+	client := &mockClient{teamByNameErr: map[string]error{"sales": denied}}
+	if _, err := ResolveTeam(client, "sales"); !errors.Is(err, denied) {
+		t.Errorf("error = %v, want the lookup error unchanged", err)
+	}
+
+	// If teams cannot be listed, the original not-found error is kept
+	client = &mockClient{allTeamsErr: errors.New("forbidden")}
+	if _, err := ResolveTeam(client, "sales"); err == nil || !strings.Contains(err.Error(), `team "sales" not found`) {
+		t.Errorf("error = %v, want the not-found error", err)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"sales", "", 5},
+		{"salesemea", "salesemae", 2},
+		{"kitten", "sitting", 3},
+		{"zürich", "zurich", 1},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
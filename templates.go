@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultLocale is used when a recipient's locale has no template.
+const DefaultLocale = "en"
+
+// Message is a rendered notification.
+type Message struct {
+	Subject string
+	Body    string
+}
+
+// MessageTemplates holds notification templates keyed by name and locale,
+// loaded from files named <name>.<locale>.tmpl (e.g. inactive.de.tmpl). A
+// template may start with a "Subject: ..." line, used for email.
+type MessageTemplates struct {
+	templates map[string]map[string]*template.Template // name → locale → template
+}
+
+// templateFuncs are available to every message template.
+var templateFuncs = template.FuncMap{
+	"date": func(t *time.Time) string { return FormatTimeDisplay(t) },
+	"join": strings.Join,
+}
+
+// LoadMessageTemplates parses every *.tmpl file in dir.
+func LoadMessageTemplates(dir string) (*MessageTemplates, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.tmpl files found in %q", dir)
+	}
+
+	mt := &MessageTemplates{templates: make(map[string]map[string]*template.Template)}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.Split(strings.TrimSuffix(filepath.Base(path), ".tmpl"), ".")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("%s: template files must be named <name>.<locale>.tmpl", filepath.Base(path))
+		}
+		if err := mt.Add(parts[0], parts[1], string(data)); err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+	}
+	return mt, nil
+}
+
+// Add parses and registers a template for name and locale.
+func (mt *MessageTemplates) Add(name, locale, text string) error {
+	tmpl, err := template.New(name + "." + locale).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return err
+	}
+	if mt.templates == nil {
+		mt.templates = make(map[string]map[string]*template.Template)
+	}
+	if mt.templates[name] == nil {
+		mt.templates[name] = make(map[string]*template.Template)
+	}
+	mt.templates[name][normalizeLocale(locale)] = tmpl
+	return nil
+}
+
+// Render renders template name for a recipient with the given locale. The
+// closest available locale is used: exact match ("pt-br"), then the base
+// language ("pt"), then DefaultLocale. It returns the locale actually used.
+func (mt *MessageTemplates) Render(name, locale string, data any) (Message, string, error) {
+	byLocale, ok := mt.templates[name]
+	if !ok {
+		return Message{}, "", fmt.Errorf("no template named %q", name)
+	}
+
+	used := ""
+	for _, candidate := range localeCandidates(locale) {
+		if _, ok := byLocale[candidate]; ok {
+			used = candidate
+			break
+		}
+	}
+	if used == "" {
+		return Message{}, "", fmt.Errorf("template %q has no %q or %q version", name, locale, DefaultLocale)
+	}
+
+	var buf bytes.Buffer
+	if err := byLocale[used].Execute(&buf, data); err != nil {
+		return Message{}, "", err
+	}
+	return parseMessage(buf.String()), used, nil
+}
+
+// localeCandidates lists the locales to try for a recipient, most specific first.
+func localeCandidates(locale string) []string {
+	locale = normalizeLocale(locale)
+	var candidates []string
+	if locale != "" {
+		candidates = append(candidates, locale)
+		if base, _, found := strings.Cut(locale, "-"); found {
+			candidates = append(candidates, base)
+		}
+	}
+	return append(candidates, DefaultLocale)
+}
+
+// normalizeLocale lower-cases a locale and uses "-" as the separator, so
+// Mattermost's "pt-BR" and file names like "pt_br" agree.
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// parseMessage splits an optional leading "Subject:" line from the body.
+func parseMessage(text string) Message {
+	if rest, ok := strings.CutPrefix(text, "Subject:"); ok {
+		subject, body, _ := strings.Cut(rest, "\n")
+		return Message{Subject: strings.TrimSpace(subject), Body: strings.TrimLeft(body, "\n")}
+	}
+	return Message{Body: text}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMessageTemplates_Render(t *testing.T) {
+	mt := &MessageTemplates{}
+	templates := map[string]string{
+		"en":    "Subject: Your guest access\n\nHello {{.DisplayName}}, your access will be reviewed.",
+		"de":    "Subject: Ihr Gastzugang\n\nHallo {{.DisplayName}}, Ihr Zugang wird überprüft.",
+		"pt-br": "Olá {{.DisplayName}}, seu acesso será revisado.",
+	}
+	for locale, text := range templates {
+		if err := mt.Add("inactive", locale, text); err != nil {
+			t.Fatalf("Add(%s) error: %v", locale, err)
+		}
+	}
+
+	guest := GuestRecord{DisplayName: "Jane Doe"}
+	tests := []struct {
+		locale      string
+		wantLocale  string
+		wantSubject string
+		wantBody    string
+	}{
+		{"de", "de", "Ihr Gastzugang", "Hallo Jane Doe, Ihr Zugang wird überprüft."},
+		{"de-AT", "de", "Ihr Gastzugang", "Hallo Jane Doe, Ihr Zugang wird überprüft."},
+		{"pt-BR", "pt-br", "", "Olá Jane Doe, seu acesso será revisado."},
+		{"pt_BR", "pt-br", "", "Olá Jane Doe, seu acesso será revisado."},
+		{"fr", "en", "Your guest access", "Hello Jane Doe, your access will be reviewed."},
+		{"", "en", "Your guest access", "Hello Jane Doe, your access will be reviewed."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			msg, used, err := mt.Render("inactive", tt.locale, guest)
+			if err != nil {
+				t.Fatalf("Render error: %v", err)
+			}
+			if used != tt.wantLocale {
+				t.Errorf("locale = %q, want %q", used, tt.wantLocale)
+			}
+			if msg.Subject != tt.wantSubject {
+				t.Errorf("subject = %q, want %q", msg.Subject, tt.wantSubject)
+			}
+			if msg.Body != tt.wantBody {
+				t.Errorf("body = %q, want %q", msg.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMessageTemplates_RenderErrors(t *testing.T) {
+	mt := &MessageTemplates{}
+	if err := mt.Add("inactive", "de", "Hallo {{.Missing}}"); err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+
+	if _, _, err := mt.Render("expiry", "de", GuestRecord{}); err == nil {
+		t.Error("expected error for unknown template")
+	}
+	if _, _, err := mt.Render("inactive", "fr", GuestRecord{}); err == nil {
+		t.Error("expected error when neither the locale nor the default exists")
+	}
+	if _, _, err := mt.Render("inactive", "de", GuestRecord{}); err == nil {
+		t.Error("expected error for a missing field")
+	}
+}
+
+func TestLoadMessageTemplates(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("inactive.en.tmpl", "Hello {{.Username}}")
+	write("inactive.fr.tmpl", "Bonjour {{.Username}}")
+	write("README.md", "ignored")
+
+	mt, err := LoadMessageTemplates(dir)
+	if err != nil {
+		t.Fatalf("LoadMessageTemplates error: %v", err)
+	}
+	msg, _, err := mt.Render("inactive", "fr", GuestRecord{Username: "jdoe"})
+	if err != nil || msg.Body != "Bonjour jdoe" {
+		t.Errorf("Render = %q, %v; want Bonjour jdoe", msg.Body, err)
+	}
+
+	write("badname.tmpl", "x")
+	if _, err := LoadMessageTemplates(dir); err == nil {
+		t.Error("expected error for a file without a locale")
+	}
+
+	if _, err := LoadMessageTemplates(t.TempDir()); err == nil {
+		t.Error("expected error for a directory without templates")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Enrichment steps timed per guest and reported with --verbose.
+const (
+	StepTeams      = "teams"
+	StepChannels   = "channels"
+	StepRetention  = "retention"
+	StepPosts      = "posts"
+	StepFiles      = "files"
+	StepAudits     = "audit records"
+	StepPlugins    = "boards/playbooks"
+	StepMentions   = "mentions"
+	StepPostCount  = "post count"
+	StepSessions   = "sessions"
+	StepRoles      = "roles"
+	StepGuestOnly  = "guest-only channels"
+	StepLastViewed = "last viewed"
+	StepProfile    = "profile attributes"
+	StepGroups     = "groups"
+)
+
+// StepTimings accumulates wall-clock time per enrichment step over a run,
+// and, when usage is set, the API calls and bytes each step cost. A nil
+// *StepTimings is valid and records nothing.
+type StepTimings struct {
+	order []string
+	steps map[string]*stepTiming
+
+	// usage returns the client's API calls and bytes received so far.
+	usage func() (calls, bytes int64)
+}
+
+type stepTiming struct {
+	guests    int
+	total     time.Duration
+	calls     int64
+	bytes     int64
+	cacheHits int
+}
+
+// NewStepTimings returns an empty set of step timings.
+func NewStepTimings() *StepTimings {
+	return &StepTimings{steps: make(map[string]*stepTiming)}
+}
+
+// Start begins timing one guest's run of step; call the returned function
+// when the step is done.
+func (t *StepTimings) Start(step string) func() {
+	if t == nil {
+		return func() {}
+	}
+	started := time.Now()
+	calls, bytes := t.used()
+	return func() {
+		t.Add(step, time.Since(started))
+		s := t.steps[step]
+		nowCalls, nowBytes := t.used()
+		s.calls += nowCalls - calls
+		s.bytes += nowBytes - bytes
+	}
+}
+
+func (t *StepTimings) used() (calls, bytes int64) {
+	if t.usage == nil {
+		return 0, 0
+	}
+	return t.usage()
+}
+
+// step returns the accumulator for step, adding it on first use.
+func (t *StepTimings) step(step string) *stepTiming {
+	s, ok := t.steps[step]
+	if !ok {
+		s = &stepTiming{}
+		t.steps[step] = s
+		t.order = append(t.order, step)
+	}
+	return s
+}
+
+// Add records d against step.
+func (t *StepTimings) Add(step string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	s := t.step(step)
+	s.guests++
+	s.total += d
+}
+
+// CacheHit records a lookup during step that was answered from a cache
+// filled earlier in the run, saving an API call.
+func (t *StepTimings) CacheHit(step string) {
+	if t == nil {
+		return
+	}
+	t.step(step).cacheHits++
+}
+
+// Stages returns the recorded steps in the order they were first seen.
+func (t *StepTimings) Stages() []StageStats {
+	if t == nil {
+		return nil
+	}
+	stages := make([]StageStats, 0, len(t.order))
+	for _, step := range t.order {
+		s := t.steps[step]
+		stages = append(stages, StageStats{Stage: step, Guests: s.guests, APICalls: s.calls, BytesReceived: s.bytes, CacheHits: s.cacheHits, Elapsed: s.total})
+	}
+	return stages
+}
+
+// Total returns the accumulated time for step.
+func (t *StepTimings) Total(step string) time.Duration {
+	if t == nil || t.steps[step] == nil {
+		return 0
+	}
+	return t.steps[step].total
+}
+
+// Write prints one line per step, in the order steps were first seen.
+func (t *StepTimings) Write(w io.Writer) {
+	if t == nil || len(t.order) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Time per enrichment step:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, step := range t.order {
+		s := t.steps[step]
+		if s.guests == 0 {
+			continue
+		}
+		avg := s.total / time.Duration(s.guests)
+		fmt.Fprintf(tw, "  %s\t%s\t(%d guest(s), avg %s)\n", step, s.total.Round(time.Millisecond), s.guests, avg.Round(time.Millisecond))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStepTimings(t *testing.T) {
+	timings := NewStepTimings()
+	timings.Add(StepTeams, 100*time.Millisecond)
+	timings.Add(StepPosts, 3*time.Second)
+	timings.Add(StepTeams, 300*time.Millisecond)
+
+	if got := timings.Total(StepTeams); got != 400*time.Millisecond {
+		t.Errorf("Total(teams) = %s, want 400ms", got)
+	}
+
+	var buf bytes.Buffer
+	timings.Write(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, "teams  400ms  (2 guest(s), avg 200ms)") {
+		t.Errorf("missing teams line, got:\n%s", out)
+	}
+	if strings.Index(out, "teams") > strings.Index(out, "posts") {
+		t.Errorf("steps should be listed in first-seen order, got:\n%s", out)
+	}
+}
+
+func TestStepTimings_Usage(t *testing.T) {
+	var calls, bytes int64
+	timings := NewStepTimings()
+	timings.usage = func() (int64, int64) { return calls, bytes }
+
+	stop := timings.Start(StepPostCount)
+	calls, bytes = 3, 4000
+	timings.CacheHit(StepPostCount)
+	stop()
+	stop = timings.Start(StepPostCount)
+	timings.CacheHit(StepPostCount)
+	stop()
+
+	stages := timings.Stages()
+	if len(stages) != 1 {
+		t.Fatalf("stages = %+v", stages)
+	}
+	if s := stages[0]; s.Stage != StepPostCount || s.Guests != 2 || s.APICalls != 3 || s.BytesReceived != 4000 || s.CacheHits != 2 {
+		t.Errorf("stage = %+v, want 2 guests, 3 calls, 4000 bytes, 2 cache hits", s)
+	}
+}
+
+func TestStepTimings_Nil(t *testing.T) {
+	var timings *StepTimings
+	timings.Start(StepTeams)()
+	timings.Add(StepTeams, time.Second)
+	if timings.Total(StepTeams) != 0 {
+		t.Error("nil StepTimings should record nothing")
+	}
+
+	var buf bytes.Buffer
+	timings.Write(&buf)
+	if buf.Len() != 0 {
+		t.Errorf("nil StepTimings should print nothing, got %q", buf.String())
+	}
+}
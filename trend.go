@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// trendReport is one saved JSON report read by the trend subcommand.
+type trendReport struct {
+	Path   string // relative to the trend directory
+	Date   time.Time
+	Result *AuditResult
+}
+
+// TrendPoint is one month of a trend: the counts in the month's last report,
+// and their change since the previous month that has a report.
+type TrendPoint struct {
+	Month          string    `json:"month"` // YYYY-MM, in UTC
+	ReportDate     time.Time `json:"report_date"`
+	Report         string    `json:"report"`
+	InactiveDays   int       `json:"inactive_days"`
+	TotalGuests    int       `json:"total_guests"`
+	ActiveGuests   int       `json:"active_guests"`
+	InactiveGuests int       `json:"inactive_guests"`
+	// Null for the first month
+	TotalChange    *int     `json:"total_change"`
+	ActiveChange   *int     `json:"active_change"`
+	InactiveChange *int     `json:"inactive_change"`
+	TotalChangePct *float64 `json:"total_change_pct"` // also null when the previous month had no guests
+}
+
+// TrendSummary describes the whole trend.
+type TrendSummary struct {
+	Reports    int    `json:"reports"` // reports read, several per month included
+	Skipped    int    `json:"skipped"` // reports with no date
+	Months     int    `json:"months"`
+	FirstMonth string `json:"first_month"`
+	LastMonth  string `json:"last_month"`
+	// Change in total guests from the first month to the last; null with
+	// fewer than two months
+	TotalChange *int `json:"total_change"`
+}
+
+// trendDatePattern finds a run date in a report's path: a YYYY-MM-DD date,
+// or a --watch run directory (WatchDirLayout).
+var trendDatePattern = regexp.MustCompile(`\d{8}T\d{6}Z|\d{4}-\d{2}-\d{2}`)
+
+// reportDate returns when a report was taken: the start of the run from its
+// metadata, or else a date in its path. Reports written before run metadata
+// existed only have the path.
+func reportDate(path string, result *AuditResult) (time.Time, bool) {
+	if result.Metadata != nil && !result.Metadata.StartedAt.IsZero() {
+		return result.Metadata.StartedAt.UTC(), true
+	}
+	matches := trendDatePattern.FindAllString(filepath.ToSlash(path), -1)
+	for i := len(matches) - 1; i >= 0; i-- {
+		for _, layout := range []string{WatchDirLayout, time.DateOnly} {
+			if t, err := time.Parse(layout, matches[i]); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// isReport reports whether data is a JSON audit report rather than one of
+// the other JSON files the tool writes (undo plans, badges, status files).
+func isReport(data []byte) bool {
+	var keys map[string]json.RawMessage
+	if json.Unmarshal(data, &keys) != nil {
+		return false
+	}
+	_, summary := keys["summary"]
+	_, guests := keys["guests"]
+	return summary && guests
+}
+
+// LoadTrendReports reads every JSON report under dir, gzipped or not,
+// including the run directories --watch writes, sorted by date. Other JSON
+// files are ignored. A report with no date is skipped with a warning and
+// counted in skipped.
+func LoadTrendReports(dir string, verbose bool) (reports []trendReport, skipped int, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := strings.ToLower(d.Name())
+		if d.IsDir() || !strings.HasSuffix(name, ".json") && !strings.HasSuffix(name, ".json.gz") {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		data, err := readReportFile(path)
+		if err != nil {
+			return err
+		}
+		if !isReport(data) {
+			if verbose {
+				logger.Printf("Skipping %s: not a JSON report", rel)
+			}
+			return nil
+		}
+		result, err := ParseSnapshot(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		date, ok := reportDate(rel, result)
+		if !ok {
+			logger.Printf("Warning: skipping %s: no run date in its metadata or file name", rel)
+			skipped++
+			return nil
+		}
+		reports = append(reports, trendReport{Path: rel, Date: date, Result: result})
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	slices.SortStableFunc(reports, func(a, b trendReport) int { return a.Date.Compare(b.Date) })
+	return reports, skipped, nil
+}
+
+// CheckTrendReports rejects reports from more than one server, whose counts
+// cannot be compared, and warns on w when the reports were scoped or
+// flagged differently.
+func CheckTrendReports(reports []trendReport, w io.Writer) error {
+	var servers []string
+	filters := make(map[string]bool)
+	inactiveDays := make(map[int]bool)
+	for _, r := range reports {
+		inactiveDays[r.Result.InactiveDays] = true
+		if m := r.Result.Metadata; m != nil {
+			if m.ServerURL != "" && !slices.Contains(servers, m.ServerURL) {
+				servers = append(servers, m.ServerURL)
+			}
+			filters[formatFilters(m.Filters)] = true
+		}
+	}
+	if len(servers) > 1 {
+		return fmt.Errorf("error: the reports come from %d servers (%s). Keep each server's reports in its own directory", len(servers), strings.Join(servers, ", "))
+	}
+	if len(filters) > 1 {
+		fmt.Fprintln(w, "Warning: the reports were run with different filters, so their counts may not be comparable")
+	}
+	if len(inactiveDays) > 1 {
+		fmt.Fprintln(w, "Warning: the reports use different --inactive-days, so their active and inactive counts may not be comparable (see inactive_days)")
+	}
+	return nil
+}
+
+// formatFilters joins filters into one comparable string.
+func formatFilters(filters []Filter) string {
+	parts := make([]string, len(filters))
+	for i, f := range filters {
+		parts[i] = f.Name + "=" + f.Value
+	}
+	return strings.Join(parts, ";")
+}
+
+// BuildTrend reduces reports, sorted by date, to one point per calendar
+// month (UTC), from the month's last report.
+func BuildTrend(reports []trendReport) []TrendPoint {
+	var points []TrendPoint
+	for _, r := range reports {
+		s := r.Result.Summary
+		p := TrendPoint{
+			Month:          r.Date.UTC().Format("2006-01"),
+			ReportDate:     r.Date,
+			Report:         filepath.ToSlash(r.Path),
+			InactiveDays:   r.Result.InactiveDays,
+			TotalGuests:    s.TotalGuests,
+			ActiveGuests:   s.ActiveGuests,
+			InactiveGuests: s.InactiveGuests,
+		}
+		if n := len(points); n > 0 && points[n-1].Month == p.Month {
+			points[n-1] = p
+		} else {
+			points = append(points, p)
+		}
+	}
+	for i := 1; i < len(points); i++ {
+		prev, p := points[i-1], &points[i]
+		p.TotalChange = intPtr(p.TotalGuests - prev.TotalGuests)
+		p.ActiveChange = intPtr(p.ActiveGuests - prev.ActiveGuests)
+		p.InactiveChange = intPtr(p.InactiveGuests - prev.InactiveGuests)
+		if prev.TotalGuests > 0 {
+			pct := math.Round(float64(*p.TotalChange)*1000/float64(prev.TotalGuests)) / 10
+			p.TotalChangePct = &pct
+		}
+	}
+	return points
+}
+
+// SummarizeTrend describes points built from reports, skipped more.
+func SummarizeTrend(points []TrendPoint, reports, skipped int) TrendSummary {
+	s := TrendSummary{Reports: reports, Skipped: skipped, Months: len(points)}
+	if len(points) > 0 {
+		first, last := points[0], points[len(points)-1]
+		s.FirstMonth, s.LastMonth = first.Month, last.Month
+		if len(points) > 1 {
+			s.TotalChange = intPtr(last.TotalGuests - first.TotalGuests)
+		}
+	}
+	return s
+}
+
+func intPtr(n int) *int { return &n }
+
+// WriteTrend writes the trend in format (table, csv or json) to outputPath,
+// or stdout if it is empty.
+func WriteTrend(points []TrendPoint, summary TrendSummary, format, outputPath string) error {
+	w, closeOutput := openOutput(outputPath)
+	defer closeOutput()
+
+	switch format {
+	case "csv":
+		return writeTrendCSV(w, points)
+	case "json":
+		return writeTrendJSON(w, points, summary)
+	default:
+		return writeTrendTable(w, points, summary)
+	}
+}
+
+// formatChange formats a change with its sign, or "" if there is none.
+func formatChange(n *int) string {
+	if n == nil {
+		return ""
+	}
+	return fmt.Sprintf("%+d", *n)
+}
+
+func formatOptionalFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+func writeTrendTable(w io.Writer, points []TrendPoint, s TrendSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MONTH\tREPORT DATE\tTOTAL\tACTIVE\tINACTIVE\tTOTAL CHANGE\tACTIVE CHANGE\tINACTIVE CHANGE")
+	for _, p := range points {
+		total := formatChange(p.TotalChange)
+		if p.TotalChangePct != nil {
+			total += fmt.Sprintf(" (%+.1f%%)", *p.TotalChangePct)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\t%s\t%s\n", p.Month, p.ReportDate.Format(time.DateOnly),
+			p.TotalGuests, p.ActiveGuests, p.InactiveGuests, total, formatChange(p.ActiveChange), formatChange(p.InactiveChange))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%d report(s) over %d month(s)", s.Reports, s.Months)
+	if s.Skipped > 0 {
+		fmt.Fprintf(w, ", %d skipped with no date", s.Skipped)
+	}
+	fmt.Fprintln(w)
+	var err error
+	if s.TotalChange != nil {
+		_, err = fmt.Fprintf(w, "Guests %s to %s: %s\n", s.FirstMonth, s.LastMonth, formatChange(s.TotalChange))
+	}
+	return err
+}
+
+func writeTrendCSV(w io.Writer, points []TrendPoint) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	header := []string{"month", "report_date", "report", "inactive_days", "total_guests", "active_guests", "inactive_guests",
+		"total_change", "active_change", "inactive_change", "total_change_pct"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, p := range points {
+		row := []string{
+			p.Month,
+			FormatTimeISO(&p.ReportDate),
+			p.Report,
+			strconv.Itoa(p.InactiveDays),
+			strconv.Itoa(p.TotalGuests),
+			strconv.Itoa(p.ActiveGuests),
+			strconv.Itoa(p.InactiveGuests),
+			formatOptionalInt(p.TotalChange),
+			formatOptionalInt(p.ActiveChange),
+			formatOptionalInt(p.InactiveChange),
+			formatOptionalFloat(p.TotalChangePct),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeTrendJSON(w io.Writer, points []TrendPoint, s TrendSummary) error {
+	if points == nil {
+		points = []TrendPoint{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Summary TrendSummary `json:"summary"`
+		Months  []TrendPoint `json:"months"`
+	}{s, points})
+}
+
+// runTrend reads the reports under dir and writes their monthly trend.
+func runTrend(dir, format, output string, verbose bool) int {
+	reports, skipped, err := LoadTrendReports(dir, verbose)
+	if err != nil {
+		logger.Printf("error: failed to read reports in %q: %v", dir, err)
+		return ExitConfigError
+	}
+	if len(reports) == 0 {
+		logger.Printf("error: no dated JSON reports found in %q. Save reports with --format json (or --watch with --output-dir) first.", dir)
+		return ExitConfigError
+	}
+	if err := CheckTrendReports(reports, logger); err != nil {
+		logger.Printf("%v", err)
+		return ExitConfigError
+	}
+	points := BuildTrend(reports)
+	if err := WriteTrend(points, SummarizeTrend(points, len(reports), skipped), format, output); err != nil {
+		logger.Printf("error: failed to write output: %v", err)
+		return ExitOutputError
+	}
+	return ExitSuccess
+}
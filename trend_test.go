@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// trendResult is a report with the given counts, taken at the given time.
+func trendResult(taken time.Time, total, active, inactive int) *AuditResult {
+	r := &AuditResult{InactiveDays: 30, Summary: AuditSummary{TotalGuests: total, ActiveGuests: active, InactiveGuests: inactive}}
+	if !taken.IsZero() {
+		r.Metadata = &RunMetadata{ServerURL: "https://mm.example.com", StartedAt: taken, FinishedAt: taken}
+	}
+	return r
+}
+
+func TestReportDate(t *testing.T) {
+	taken := time.Date(2025, 3, 4, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		path   string
+		result *AuditResult
+		want   string
+	}{
+		{"metadata", "guests-2025-01-01.json", trendResult(taken, 0, 0, 0), "2025-03-04"},
+		{"dated file name", "guests-2025-01-31.json", trendResult(time.Time{}, 0, 0, 0), "2025-01-31"},
+		{"watch directory", "20250215T080000Z/guests.json", trendResult(time.Time{}, 0, 0, 0), "2025-02-15"},
+		{"no date", "guests.json", trendResult(time.Time{}, 0, 0, 0), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			date, ok := reportDate(tt.path, tt.result)
+			got := ""
+			if ok {
+				got = date.Format(time.DateOnly)
+			}
+			if got != tt.want {
+				t.Errorf("reportDate(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildTrend(t *testing.T) {
+	day := func(m time.Month, d int) time.Time { return time.Date(2025, m, d, 12, 0, 0, 0, time.UTC) }
+	reports := []trendReport{
+		{Path: "jan-a.json", Date: day(1, 5), Result: trendResult(day(1, 5), 90, 80, 10)},
+		{Path: "jan-b.json", Date: day(1, 28), Result: trendResult(day(1, 28), 100, 80, 20)},
+		{Path: "feb.json", Date: day(2, 27), Result: trendResult(day(2, 27), 110, 95, 15)},
+		{Path: "apr.json", Date: day(4, 30), Result: trendResult(day(4, 30), 99, 90, 9)},
+	}
+	points := BuildTrend(reports)
+
+	// The last report of each month is used; a month without one is skipped
+	var months []string
+	for _, p := range points {
+		months = append(months, p.Month+"="+p.Report)
+	}
+	if got := strings.Join(months, ","); got != "2025-01=jan-b.json,2025-02=feb.json,2025-04=apr.json" {
+		t.Fatalf("months = %s", got)
+	}
+	if p := points[0]; p.TotalChange != nil || p.TotalChangePct != nil {
+		t.Errorf("first month change = %v, %v; want nulls", p.TotalChange, p.TotalChangePct)
+	}
+	feb := points[1]
+	if *feb.TotalChange != 10 || *feb.ActiveChange != 15 || *feb.InactiveChange != -5 || *feb.TotalChangePct != 10 {
+		t.Errorf("February change = %d/%d/%d (%v%%), want +10/+15/-5 (10%%)", *feb.TotalChange, *feb.ActiveChange, *feb.InactiveChange, *feb.TotalChangePct)
+	}
+	if pct := *points[2].TotalChangePct; pct != -10 {
+		t.Errorf("April change = %v%%, want -10%%", pct)
+	}
+
+	s := SummarizeTrend(points, len(reports), 1)
+	if s.Months != 3 || s.FirstMonth != "2025-01" || s.LastMonth != "2025-04" || *s.TotalChange != -1 || s.Skipped != 1 {
+		t.Errorf("summary = %+v", s)
+	}
+}
+
+func TestLoadTrendReports(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, result *AuditResult) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteOutput(result, "json", path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("march.json", trendResult(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), 3, 3, 0))
+	write("20250101T000000Z/guests.json", trendResult(time.Time{}, 1, 1, 0))
+	write("undated.json", trendResult(time.Time{}, 2, 2, 0))
+	// Other JSON files the tool writes are not reports
+	if err := os.WriteFile(filepath.Join(dir, "badge.json"), []byte(`{"schemaVersion": 1, "label": "guests"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reports, skipped, err := LoadTrendReports(dir, false)
+	if err != nil {
+		t.Fatalf("LoadTrendReports error: %v", err)
+	}
+	if skipped != 1 {
+		t.Errorf("skipped = %d, want 1 (undated.json)", skipped)
+	}
+	if len(reports) != 2 || reports[0].Result.Summary.TotalGuests != 1 || reports[1].Result.Summary.TotalGuests != 3 {
+		t.Errorf("reports = %+v, want the watch run then march.json", reports)
+	}
+}
+
+func TestCheckTrendReports(t *testing.T) {
+	taken := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	other := trendResult(taken, 1, 1, 0)
+	other.Metadata.ServerURL = "https://other.example.com"
+	if err := CheckTrendReports([]trendReport{{Result: trendResult(taken, 1, 1, 0)}, {Result: other}}, &bytes.Buffer{}); err == nil {
+		t.Error("expected an error for reports from two servers")
+	}
+
+	filtered := trendResult(taken, 1, 1, 0)
+	filtered.Metadata.Filters = []Filter{{"team", "Sales"}}
+	filtered.InactiveDays = 60
+	var w bytes.Buffer
+	if err := CheckTrendReports([]trendReport{{Result: trendResult(taken, 1, 1, 0)}, {Result: filtered}}, &w); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(w.String(), "different filters") || !strings.Contains(w.String(), "different --inactive-days") {
+		t.Errorf("warnings = %q, want filters and --inactive-days", w.String())
+	}
+}
+
+func TestWriteTrend(t *testing.T) {
+	day := func(m time.Month) time.Time { return time.Date(2025, m, 10, 0, 0, 0, 0, time.UTC) }
+	points := BuildTrend([]trendReport{
+		{Path: "jan.json", Date: day(1), Result: trendResult(day(1), 100, 80, 20)},
+		{Path: "feb.json", Date: day(2), Result: trendResult(day(2), 95, 85, 10)},
+	})
+
+	var buf bytes.Buffer
+	if err := writeTrendCSV(&buf, points); err != nil {
+		t.Fatal(err)
+	}
+	want := "month,report_date,report,inactive_days,total_guests,active_guests,inactive_guests,total_change,active_change,inactive_change,total_change_pct\n" +
+		"2025-01,2025-01-10T00:00:00Z,jan.json,30,100,80,20,,,,\n" +
+		"2025-02,2025-02-10T00:00:00Z,feb.json,30,95,85,10,-5,5,-10,-5\n"
+	if buf.String() != want {
+		t.Errorf("CSV =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := writeTrendJSON(&buf, points, SummarizeTrend(points, 2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"total_change": null`, `"total_change_pct": -5`, `"first_month": "2025-01"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("JSON missing %s:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := writeTrendTable(&buf, points, SummarizeTrend(points, 2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"-5 (-5.0%)", "Guests 2025-01 to 2025-02: -5"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("table missing %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Actions recorded in undo plans. UndoActionRemoveFromChannels plans are
+// written by --remove-from-channels and add memberships back;
+// UndoActionReactivate plans are written by --deactivate-expired and
+// reactivate accounts; UndoActionQuarantine plans are written by
+// --quarantine-team and put guests back in the teams and channels they left.
+// A --purge deletes accounts for good and writes no
+// undo plan.
+const (
+	UndoActionRemoveFromChannels = "remove_from_channels"
+	UndoActionReactivate         = "reactivate"
+	UndoActionQuarantine         = "quarantine"
+)
+
+// RestoreRestored is the status of a membership undo added back. Planned
+// and failed restorations use RemovalPlanned and RemovalFailed.
+const RestoreRestored = "restored"
+
+// Status of a Reactivation that undo reactivated or skipped. Planned and
+// failed reactivations use DeactivationPlanned and DeactivationFailed.
+const (
+	ReactivationReactivated = "reactivated"
+	ReactivationSkipped     = "skipped" // already deactivated before the run, see Reason
+)
+
+// UndoPlan records what an action run changed, for `mm-guest-audit undo
+// --plan <file>` to reverse: the channel memberships --remove-from-channels
+// removed, the accounts --deactivate-expired deactivated, or the teams
+// --quarantine-team moved guests between. Unlike the
+// action reports it holds user and channel IDs, so it can be replayed even
+// after a rename.
+type UndoPlan struct {
+	Server      string           `json:"server"`
+	CreatedAt   string           `json:"created_at"`
+	Action      string           `json:"action"`
+	Operator    Operator         `json:"operator"` // who ran the action
+	Memberships []UndoMembership `json:"memberships,omitempty"`
+	Users       []UndoUser       `json:"users,omitempty"`
+
+	// LeftTeams are the teams a quarantined guest was removed from, with
+	// the channels that went with them in Memberships, and JoinedTeams the
+	// quarantine team memberships that were added.
+	LeftTeams   []UndoTeamMembership `json:"left_teams,omitempty"`
+	JoinedTeams []UndoTeamMembership `json:"joined_teams,omitempty"`
+}
+
+// UndoTeamMembership is one team membership a quarantine added or removed.
+type UndoTeamMembership struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	TeamID   string `json:"team_id"`
+	Team     string `json:"team"`
+}
+
+// UndoMembership is one removed channel membership.
+type UndoMembership struct {
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	ChannelID string `json:"channel_id"`
+	Team      string `json:"team"`
+	Channel   string `json:"channel"`
+	Type      string `json:"type"`
+}
+
+// UndoUser is one deactivated account and its state before the run.
+type UndoUser struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	// PriorDeleteAt is the account's DeleteAt before the run, in Unix
+	// milliseconds: 0 for an active account. Undo only reactivates
+	// accounts that were active.
+	PriorDeleteAt int64 `json:"prior_delete_at"`
+}
+
+// NewUndoPlan builds the undo plan for removals made on server by op. With
+// includePlanned, memberships still to be removed are included too: the plan
+// is written before anything is removed, so an interrupted run can still be
+// undone, and adding back a membership that was never removed is harmless.
+func NewUndoPlan(server string, op Operator, removals []ChannelRemoval, includePlanned bool, now time.Time) *UndoPlan {
+	plan := &UndoPlan{
+		Server:      normalizeServerURL(server),
+		CreatedAt:   now.UTC().Format(time.RFC3339),
+		Action:      UndoActionRemoveFromChannels,
+		Operator:    op,
+		Memberships: []UndoMembership{},
+	}
+	for _, r := range removals {
+		if r.Status != RemovalRemoved && !(includePlanned && r.Status == RemovalPlanned) {
+			continue
+		}
+		plan.Memberships = append(plan.Memberships, UndoMembership{
+			UserID:    r.userID,
+			Username:  r.Username,
+			ChannelID: r.channelID,
+			Team:      r.Team,
+			Channel:   r.Channel,
+			Type:      r.Type,
+		})
+	}
+	return plan
+}
+
+// NewReactivationPlan builds the undo plan for deactivations made on server
+// by op,
+// with includePlanned as for NewUndoPlan: reactivating an account that was
+// never deactivated is harmless.
+func NewReactivationPlan(server string, op Operator, deactivations []GuestDeactivation, includePlanned bool, now time.Time) *UndoPlan {
+	plan := &UndoPlan{
+		Server:    normalizeServerURL(server),
+		CreatedAt: now.UTC().Format(time.RFC3339),
+		Action:    UndoActionReactivate,
+		Operator:  op,
+		Users:     []UndoUser{},
+	}
+	for _, d := range deactivations {
+		if d.Status != DeactivationDeactivated && !(includePlanned && d.Status == DeactivationPlanned) {
+			continue
+		}
+		plan.Users = append(plan.Users, UndoUser{
+			UserID:        d.userID,
+			Username:      d.Username,
+			PriorDeleteAt: d.priorDeleteAt,
+		})
+	}
+	return plan
+}
+
+// DefaultUndoFile names the undo plan written when --undo-file is not set.
+func DefaultUndoFile(now time.Time) string {
+	return "undo-" + now.UTC().Format("20060102-150405") + ".json"
+}
+
+// WriteUndoPlan writes plan to path, replacing it atomically so a crash
+// never leaves a truncated plan. There is no stdout fallback: a run that
+// cannot record how to undo itself should not change anything.
+func WriteUndoPlan(plan *UndoPlan, path string) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, append(data, '\n'))
+}
+
+// LoadUndoPlan reads and validates an undo plan.
+func LoadUndoPlan(path string) (*UndoPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan UndoPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("not an undo plan: %v", err)
+	}
+	switch plan.Action {
+	case UndoActionRemoveFromChannels:
+		for i, m := range plan.Memberships {
+			if m.UserID == "" || m.ChannelID == "" {
+				return nil, fmt.Errorf("membership %d has no user_id or channel_id", i+1)
+			}
+		}
+	case UndoActionReactivate:
+		for i, u := range plan.Users {
+			if u.UserID == "" {
+				return nil, fmt.Errorf("user %d has no user_id", i+1)
+			}
+		}
+	case UndoActionQuarantine:
+		for i, m := range plan.Memberships {
+			if m.UserID == "" || m.ChannelID == "" {
+				return nil, fmt.Errorf("membership %d has no user_id or channel_id", i+1)
+			}
+		}
+		for i, t := range append(plan.LeftTeams, plan.JoinedTeams...) {
+			if t.UserID == "" || t.TeamID == "" {
+				return nil, fmt.Errorf("team membership %d has no user_id or team_id", i+1)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported action %q", plan.Action)
+	}
+	return &plan, nil
+}
+
+// CheckUndoServer returns an error unless plan was written for server, so a
+// plan is never replayed against the wrong instance.
+func CheckUndoServer(plan *UndoPlan, server string) error {
+	if normalizeServerURL(plan.Server) != normalizeServerURL(server) {
+		return fmt.Errorf("error: the undo plan is for %s, not %s. Use --url %s", plan.Server, server, plan.Server)
+	}
+	return nil
+}
+
+func normalizeServerURL(s string) string {
+	return strings.TrimRight(strings.ToLower(strings.TrimSpace(s)), "/")
+}
+
+// ChannelRestoration is one membership of an undo plan and its outcome.
+type ChannelRestoration struct {
+	Username string `json:"username"`
+	Team     string `json:"team"`
+	Channel  string `json:"channel"`
+	Type     string `json:"type"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"` // why the restoration failed
+
+	userID    string
+	channelID string
+}
+
+// RestoreSummary counts restorations by status.
+type RestoreSummary struct {
+	Planned  int `json:"planned"`
+	Restored int `json:"restored"`
+	Failed   int `json:"failed"`
+}
+
+// PlanRestorations lists the memberships in plan, all planned.
+func PlanRestorations(plan *UndoPlan) []ChannelRestoration {
+	restorations := make([]ChannelRestoration, len(plan.Memberships))
+	for i, m := range plan.Memberships {
+		restorations[i] = ChannelRestoration{
+			Username:  m.Username,
+			Team:      m.Team,
+			Channel:   m.Channel,
+			Type:      m.Type,
+			Status:    RemovalPlanned,
+			userID:    m.UserID,
+			channelID: m.ChannelID,
+		}
+	}
+	return restorations
+}
+
+// ApplyRestorations adds each planned membership back. Guests already back
+// in a channel are added again without error. A failed restoration is
+// recorded and the rest carry on; the exit code is ExitPartialFailure if
+// any failed.
+func ApplyRestorations(client MattermostClient, restorations []ChannelRestoration, retry RetryPolicy, verbose bool) int {
+	exitCode := ExitSuccess
+	for i := range restorations {
+		r := &restorations[i]
+		if r.Status != RemovalPlanned {
+			continue
+		}
+		op := fmt.Sprintf("adding %q back to %s/%s", r.Username, r.Team, r.Channel)
+		err := retry.Do(op, verbose, func() error {
+			return client.AddUserToChannel(r.channelID, r.userID)
+		})
+		if err != nil {
+			r.Status = RemovalFailed
+			r.Reason = err.Error()
+			exitCode = ExitPartialFailure
+			if verbose {
+				logger.Printf("Warning: %s failed: %v", op, err)
+			}
+			continue
+		}
+		r.Status = RestoreRestored
+		if verbose {
+			logger.Printf("Added %q back to %s/%s", r.Username, r.Team, r.Channel)
+		}
+	}
+	return exitCode
+}
+
+// SummarizeRestorations counts restorations by status.
+func SummarizeRestorations(restorations []ChannelRestoration) RestoreSummary {
+	var s RestoreSummary
+	for _, r := range restorations {
+		switch r.Status {
+		case RemovalPlanned:
+			s.Planned++
+		case RestoreRestored:
+			s.Restored++
+		case RemovalFailed:
+			s.Failed++
+		}
+	}
+	return s
+}
+
+// WriteRestorations writes the restoration plan or outcome in the given
+// format, with the usual stdout fallback when the output file cannot be
+// written.
+func WriteRestorations(restorations []ChannelRestoration, dryRun bool, op Operator, format, outputPath string) error {
+	w, closeOutput := openOutput(outputPath)
+	defer closeOutput()
+
+	switch format {
+	case "csv":
+		return writeRestorationsCSV(w, restorations, op)
+	case "json":
+		return writeRestorationsJSON(w, restorations, dryRun, op)
+	default:
+		return writeRestorationsTable(w, restorations, dryRun, op)
+	}
+}
+
+func writeRestorationsTable(w io.Writer, restorations []ChannelRestoration, dryRun bool, op Operator) error {
+	if dryRun {
+		fmt.Fprintln(w, "⚠  DRY RUN — no changes have been made to your Mattermost instance.")
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Operator: %s\n\n", op)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "USERNAME\tTEAM\tCHANNEL\tTYPE\tSTATUS\tREASON")
+	for _, r := range restorations {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Username, r.Team, r.Channel, r.Type, r.Status, r.Reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	s := SummarizeRestorations(restorations)
+	fmt.Fprintln(w)
+	var err error
+	if dryRun {
+		_, err = fmt.Fprintf(w, "%d membership(s) would be restored\n", s.Planned)
+	} else {
+		_, err = fmt.Fprintf(w, "%d membership(s) restored, %d failed\n", s.Restored, s.Failed)
+	}
+	return err
+}
+
+func writeRestorationsCSV(w io.Writer, restorations []ChannelRestoration, op Operator) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	if err := cw.Write([]string{"username", "team", "channel", "type", "status", "reason", "operator_id", "operator"}); err != nil {
+		return err
+	}
+	for _, r := range restorations {
+		if err := cw.Write([]string{r.Username, r.Team, r.Channel, r.Type, r.Status, r.Reason, op.UserID, op.Username}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeRestorationsJSON(w io.Writer, restorations []ChannelRestoration, dryRun bool, op Operator) error {
+	if restorations == nil {
+		restorations = []ChannelRestoration{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		DryRun       bool                 `json:"dry_run"`
+		Operator     Operator             `json:"operator"`
+		Summary      RestoreSummary       `json:"summary"`
+		Restorations []ChannelRestoration `json:"restorations"`
+	}{dryRun, op, SummarizeRestorations(restorations), restorations})
+}
+
+// Reactivation is one account of a reactivate undo plan and its outcome.
+type Reactivation struct {
+	Username string `json:"username"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"` // why the reactivation was skipped or failed
+
+	userID string
+}
+
+// ReactivationSummary counts reactivations by status.
+type ReactivationSummary struct {
+	Planned     int `json:"planned"`
+	Reactivated int `json:"reactivated"`
+	Failed      int `json:"failed"`
+	Skipped     int `json:"skipped"`
+}
+
+// PlanReactivations lists the accounts in plan. Accounts that were already
+// deactivated before the run are skipped, so undo never reactivates an
+// account someone else deactivated.
+func PlanReactivations(plan *UndoPlan) []Reactivation {
+	reactivations := make([]Reactivation, len(plan.Users))
+	for i, u := range plan.Users {
+		reactivations[i] = Reactivation{Username: u.Username, Status: DeactivationPlanned, userID: u.UserID}
+		if u.PriorDeleteAt != 0 {
+			reactivations[i].Status = ReactivationSkipped
+			reactivations[i].Reason = "deactivated before the run"
+		}
+	}
+	return reactivations
+}
+
+// ApplyReactivations reactivates each planned account. Accounts already
+// active are reactivated again without error. A failed reactivation is
+// recorded and the rest carry on; the exit code is ExitPartialFailure if
+// any failed.
+func ApplyReactivations(client MattermostClient, reactivations []Reactivation, retry RetryPolicy, verbose bool) int {
+	exitCode := ExitSuccess
+	for i := range reactivations {
+		r := &reactivations[i]
+		if r.Status != DeactivationPlanned {
+			continue
+		}
+		op := fmt.Sprintf("reactivating %q", r.Username)
+		err := retry.Do(op, verbose, func() error {
+			return client.ReactivateUser(r.userID)
+		})
+		if err != nil {
+			r.Status = DeactivationFailed
+			r.Reason = err.Error()
+			exitCode = ExitPartialFailure
+			if verbose {
+				logger.Printf("Warning: %s failed: %v", op, err)
+			}
+			continue
+		}
+		r.Status = ReactivationReactivated
+		if verbose {
+			logger.Printf("Reactivated %q", r.Username)
+		}
+	}
+	return exitCode
+}
+
+// SummarizeReactivations counts reactivations by status.
+func SummarizeReactivations(reactivations []Reactivation) ReactivationSummary {
+	var s ReactivationSummary
+	for _, r := range reactivations {
+		switch r.Status {
+		case DeactivationPlanned:
+			s.Planned++
+		case ReactivationReactivated:
+			s.Reactivated++
+		case DeactivationFailed:
+			s.Failed++
+		case ReactivationSkipped:
+			s.Skipped++
+		}
+	}
+	return s
+}
+
+// WriteReactivations writes the reactivation plan or outcome in the given
+// format, with the usual stdout fallback when the output file cannot be
+// written.
+func WriteReactivations(reactivations []Reactivation, dryRun bool, op Operator, format, outputPath string) error {
+	w, closeOutput := openOutput(outputPath)
+	defer closeOutput()
+
+	switch format {
+	case "csv":
+		return writeReactivationsCSV(w, reactivations, op)
+	case "json":
+		return writeReactivationsJSON(w, reactivations, dryRun, op)
+	default:
+		return writeReactivationsTable(w, reactivations, dryRun, op)
+	}
+}
+
+func writeReactivationsTable(w io.Writer, reactivations []Reactivation, dryRun bool, op Operator) error {
+	if dryRun {
+		fmt.Fprintln(w, "⚠  DRY RUN — no changes have been made to your Mattermost instance.")
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Operator: %s\n\n", op)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "USERNAME\tSTATUS\tREASON")
+	for _, r := range reactivations {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Username, r.Status, r.Reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	s := SummarizeReactivations(reactivations)
+	fmt.Fprintln(w)
+	var err error
+	if dryRun {
+		_, err = fmt.Fprintf(w, "%d account(s) would be reactivated, %d skipped\n", s.Planned, s.Skipped)
+	} else {
+		_, err = fmt.Fprintf(w, "%d account(s) reactivated, %d failed, %d skipped\n", s.Reactivated, s.Failed, s.Skipped)
+	}
+	return err
+}
+
+func writeReactivationsCSV(w io.Writer, reactivations []Reactivation, op Operator) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	if err := cw.Write([]string{"username", "status", "reason", "operator_id", "operator"}); err != nil {
+		return err
+	}
+	for _, r := range reactivations {
+		if err := cw.Write([]string{r.Username, r.Status, r.Reason, op.UserID, op.Username}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeReactivationsJSON(w io.Writer, reactivations []Reactivation, dryRun bool, op Operator) error {
+	if reactivations == nil {
+		reactivations = []Reactivation{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		DryRun        bool                `json:"dry_run"`
+		Operator      Operator            `json:"operator"`
+		Summary       ReactivationSummary `json:"summary"`
+		Reactivations []Reactivation      `json:"reactivations"`
+	}{dryRun, op, SummarizeReactivations(reactivations), reactivations})
+}
+
+// runUndo reverses plan: it adds back the memberships of a
+// remove_from_channels plan, reactivates the accounts of a reactivate plan
+// or releases the guests of a quarantine plan, or with dryRun lists them,
+// and writes the outcome in place of a report.
+func runUndo(client MattermostClient, plan *UndoPlan, dryRun bool, format, output string, retry RetryPolicy, verbose bool) int {
+	switch plan.Action {
+	case UndoActionReactivate:
+		return runReactivate(client, plan, dryRun, format, output, retry, verbose)
+	case UndoActionQuarantine:
+		return runQuarantineUndo(client, plan, dryRun, format, output, retry, verbose)
+	}
+	restorations := PlanRestorations(plan)
+	exitCode := ExitSuccess
+	if !dryRun {
+		exitCode = ApplyRestorations(client, restorations, retry, verbose)
+	}
+	if err := WriteRestorations(restorations, dryRun, client.ServerInfo().Operator(), format, output); err != nil {
+		logger.Printf("error: failed to write output: %v", err)
+		return ExitOutputError
+	}
+	s := SummarizeRestorations(restorations)
+	if dryRun {
+		logger.Printf("Dry run: %d membership(s) would be restored, nothing was changed.", s.Planned)
+	} else {
+		logger.Printf("Restored %d membership(s), %d failed.", s.Restored, s.Failed)
+	}
+	return exitCode
+}
+
+func runReactivate(client MattermostClient, plan *UndoPlan, dryRun bool, format, output string, retry RetryPolicy, verbose bool) int {
+	reactivations := PlanReactivations(plan)
+	exitCode := ExitSuccess
+	if !dryRun {
+		exitCode = ApplyReactivations(client, reactivations, retry, verbose)
+	}
+	if err := WriteReactivations(reactivations, dryRun, client.ServerInfo().Operator(), format, output); err != nil {
+		logger.Printf("error: failed to write output: %v", err)
+		return ExitOutputError
+	}
+	s := SummarizeReactivations(reactivations)
+	if dryRun {
+		logger.Printf("Dry run: %d account(s) would be reactivated, nothing was changed.", s.Planned)
+	} else {
+		logger.Printf("Reactivated %d account(s), %d failed, %d skipped.", s.Reactivated, s.Failed, s.Skipped)
+	}
+	return exitCode
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewUndoPlan(t *testing.T) {
+	result := removalResult()
+	result.Guests[0].Channels = append(result.Guests[0].Channels, ChannelInfo{ID: "ch3", TeamName: "Sales", ChannelName: "Deals", Type: ChannelTypePublic})
+	removals := PlanChannelRemovals(result)
+	now := time.Date(2024, 11, 20, 9, 0, 0, 0, time.UTC)
+
+	// Before removal: every planned membership, never the skipped ones
+	plan := NewUndoPlan("https://Chat.example.com/", testOperator, removals, true, now)
+	if plan.Server != "https://chat.example.com" || plan.CreatedAt != "2024-11-20T09:00:00Z" || plan.Action != UndoActionRemoveFromChannels || plan.Operator != testOperator {
+		t.Errorf("unexpected plan header: %+v", plan)
+	}
+	if len(plan.Memberships) != 2 {
+		t.Fatalf("expected 2 planned memberships, got %+v", plan.Memberships)
+	}
+	if m := plan.Memberships[0]; m.UserID != "user0" || m.ChannelID != "ch2" || m.Username != "inactive" || m.Channel != "Partners" {
+		t.Errorf("unexpected membership: %+v", m)
+	}
+
+	// After removal: only what was removed
+	client := &mockClient{removeErr: map[string]error{"ch3": &APIError{StatusCode: 403, Message: "forbidden"}}}
+	ApplyChannelRemovals(client, removals, RetryPolicy{}, false)
+	plan = NewUndoPlan("https://chat.example.com", testOperator, removals, false, now)
+	if len(plan.Memberships) != 1 || plan.Memberships[0].ChannelID != "ch2" {
+		t.Errorf("expected only the ch2 removal, got %+v", plan.Memberships)
+	}
+}
+
+func TestNewReactivationPlan(t *testing.T) {
+	deactivations := PlanDeactivations(deactivationResult())
+	now := time.Date(2024, 11, 20, 9, 0, 0, 0, time.UTC)
+
+	// Before deactivation: every planned account, never the skipped ones
+	plan := NewReactivationPlan("https://chat.example.com", testOperator, deactivations, true, now)
+	if plan.Action != UndoActionReactivate || plan.Operator != testOperator || len(plan.Memberships) != 0 {
+		t.Errorf("unexpected plan header: %+v", plan)
+	}
+	if len(plan.Users) != 2 || plan.Users[0] != (UndoUser{UserID: "user0", Username: "old"}) || plan.Users[1].UserID != "user4" {
+		t.Fatalf("expected user0 and user4 with no prior DeleteAt, got %+v", plan.Users)
+	}
+
+	// After deactivation: only what was deactivated
+	client := &mockClient{deactivateErr: map[string]error{"user4": &APIError{StatusCode: 403, Message: "forbidden"}}}
+	ApplyDeactivations(client, deactivations, RetryPolicy{}, false)
+	plan = NewReactivationPlan("https://chat.example.com", testOperator, deactivations, false, now)
+	if len(plan.Users) != 1 || plan.Users[0].UserID != "user0" {
+		t.Errorf("expected only user0, got %+v", plan.Users)
+	}
+}
+
+func TestLoadUndoPlan(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "undo.json")
+	want := &UndoPlan{
+		Server:      "https://chat.example.com",
+		CreatedAt:   "2024-11-20T09:00:00Z",
+		Action:      UndoActionRemoveFromChannels,
+		Operator:    testOperator,
+		Memberships: []UndoMembership{{UserID: "user0", Username: "inactive", ChannelID: "ch2", Team: "Engineering", Channel: "Partners", Type: ChannelTypePrivate}},
+	}
+	if err := WriteUndoPlan(want, path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadUndoPlan(path)
+	if err != nil {
+		t.Fatalf("LoadUndoPlan: %v", err)
+	}
+	if got.Server != want.Server || got.Operator != want.Operator || len(got.Memberships) != 1 || got.Memberships[0] != want.Memberships[0] {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+
+	reactivate := &UndoPlan{Server: want.Server, Action: UndoActionReactivate, Users: []UndoUser{{UserID: "user0", Username: "old"}}}
+	if err := WriteUndoPlan(reactivate, path); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := LoadUndoPlan(path); err != nil || len(got.Users) != 1 || got.Users[0] != reactivate.Users[0] {
+		t.Errorf("reactivate round trip = %+v, %v", got, err)
+	}
+
+	tests := map[string]string{
+		"not json":         `{`,
+		"wrong action":     `{"action": "deactivate", "memberships": []}`,
+		"missing ids":      `{"action": "remove_from_channels", "memberships": [{"username": "inactive"}]}`,
+		"missing user ids": `{"action": "reactivate", "users": [{"username": "old"}]}`,
+		"missing team ids": `{"action": "quarantine", "left_teams": [{"user_id": "user0", "team": "Sales"}]}`,
+		"report instead":   `{"summary": {}, "guests": []}`,
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadUndoPlan(path); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestCheckUndoServer(t *testing.T) {
+	plan := &UndoPlan{Server: "https://chat.example.com"}
+	if err := CheckUndoServer(plan, "https://CHAT.example.com/"); err != nil {
+		t.Errorf("same server rejected: %v", err)
+	}
+	if err := CheckUndoServer(plan, "https://staging.example.com"); err == nil || !strings.Contains(err.Error(), "--url https://chat.example.com") {
+		t.Errorf("expected a server mismatch error, got %v", err)
+	}
+}
+
+func TestApplyRestorations(t *testing.T) {
+	plan := &UndoPlan{Memberships: []UndoMembership{
+		{UserID: "user0", Username: "inactive", ChannelID: "ch2", Team: "Engineering", Channel: "Partners"},
+		{UserID: "user0", Username: "inactive", ChannelID: "ch3", Team: "Sales", Channel: "Deals"},
+	}}
+	client := &mockClient{addErr: map[string]error{"ch3": &APIError{StatusCode: 404, Message: "channel not found"}}}
+
+	restorations := PlanRestorations(plan)
+	exitCode := ApplyRestorations(client, restorations, RetryPolicy{}, false)
+	if exitCode != ExitPartialFailure {
+		t.Errorf("expected exit code %d, got %d", ExitPartialFailure, exitCode)
+	}
+	if len(client.added) != 1 || client.added[0] != "ch2:user0" {
+		t.Errorf("added = %v, want [ch2:user0]", client.added)
+	}
+	want := RestoreSummary{Restored: 1, Failed: 1}
+	if got := SummarizeRestorations(restorations); got != want {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+	if r := restorations[1]; r.Status != RemovalFailed || !strings.Contains(r.Reason, "not found") {
+		t.Errorf("unexpected failed restoration: %+v", r)
+	}
+}
+
+func TestWriteRestorations_DryRun(t *testing.T) {
+	restorations := PlanRestorations(&UndoPlan{Memberships: []UndoMembership{
+		{UserID: "user0", Username: "inactive", ChannelID: "ch2", Team: "Engineering", Channel: "Partners"},
+	}})
+
+	var buf bytes.Buffer
+	if err := writeRestorationsTable(&buf, restorations, true, testOperator); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "⚠  DRY RUN") || !strings.Contains(out, "1 membership(s) would be restored") {
+		t.Errorf("unexpected table output:\n%s", out)
+	}
+
+	buf.Reset()
+	if err := writeRestorationsJSON(&buf, restorations, true, testOperator); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		DryRun       bool                 `json:"dry_run"`
+		Summary      RestoreSummary       `json:"summary"`
+		Restorations []ChannelRestoration `json:"restorations"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if !got.DryRun || got.Summary.Planned != 1 || len(got.Restorations) != 1 || strings.Contains(buf.String(), "user0") {
+		t.Errorf("unexpected JSON: %s", buf.String())
+	}
+}
+
+func TestApplyReactivations(t *testing.T) {
+	plan := &UndoPlan{Action: UndoActionReactivate, Users: []UndoUser{
+		{UserID: "user0", Username: "old"},
+		{UserID: "user1", Username: "gone"},
+		{UserID: "user2", Username: "earlier", PriorDeleteAt: 1700000000000},
+	}}
+	client := &mockClient{reactivateErr: map[string]error{"user1": &APIError{StatusCode: 404, Message: "user not found"}}}
+
+	reactivations := PlanReactivations(plan)
+	if exitCode := ApplyReactivations(client, reactivations, RetryPolicy{}, false); exitCode != ExitPartialFailure {
+		t.Errorf("expected exit code %d, got %d", ExitPartialFailure, exitCode)
+	}
+	// An account deactivated before the run is left alone
+	if len(client.reactivated) != 1 || client.reactivated[0] != "user0" {
+		t.Errorf("reactivated = %v, want [user0]", client.reactivated)
+	}
+	want := ReactivationSummary{Reactivated: 1, Failed: 1, Skipped: 1}
+	if got := SummarizeReactivations(reactivations); got != want {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := writeReactivationsTable(&buf, PlanReactivations(plan), true, testOperator); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.HasPrefix(out, "⚠  DRY RUN") || !strings.Contains(out, "2 account(s) would be reactivated, 1 skipped") {
+		t.Errorf("unexpected table output:\n%s", out)
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Upload copies the finished report files to an S3 bucket (--upload), so a
+// scheduled run leaves its evidence in the bucket without a separate copy
+// step. Requests are signed with AWS Signature Version 4 by hand rather
+// than through the AWS SDK, which would dwarf the rest of the tool.
+type Upload struct {
+	Bucket string
+	Prefix string // key prefix: empty, or ending in /
+	Retry  RetryPolicy
+
+	// Overridden in tests
+	client *http.Client
+	getenv func(string) string
+	now    func() time.Time
+}
+
+// Enabled reports whether --upload was given.
+func (u Upload) Enabled() bool {
+	return u.Bucket != ""
+}
+
+// ParseUpload parses the --upload URL, s3://bucket/prefix/. A prefix not
+// ending in / gets one, so files keep their own names under it. Google
+// Cloud Storage and Azure Blob Storage are not supported.
+func ParseUpload(s string) (Upload, error) {
+	if s == "" {
+		return Upload{}, nil
+	}
+	u, err := url.Parse(s)
+	switch {
+	case err != nil:
+		return Upload{}, fmt.Errorf("error: invalid --upload %q: %v", s, err)
+	case u.Scheme == "gs" || u.Scheme == "az" || strings.HasSuffix(u.Host, ".blob.core.windows.net"):
+		return Upload{}, fmt.Errorf("error: --upload supports Amazon S3 and S3-compatible storage only (s3://bucket/prefix/). For Google Cloud Storage, use its S3 interoperability with AWS_ENDPOINT_URL_S3=https://storage.googleapis.com and an HMAC key")
+	case u.Scheme != "s3" || u.Host == "":
+		return Upload{}, fmt.Errorf("error: invalid --upload %q: use s3://bucket/prefix/", s)
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return Upload{Bucket: u.Host, Prefix: prefix}, nil
+}
+
+// Files adds the checksum and signature files seal writes next to each
+// report file, which are uploaded with it.
+func (u Upload) Files(paths []string, seal SealOptions) []string {
+	var files []string
+	for _, p := range paths {
+		files = append(files, p)
+		if seal.Checksum {
+			files = append(files, p+".sha256")
+		}
+		if seal.SignKey != "" {
+			files = append(files, p+".asc")
+		}
+	}
+	return files
+}
+
+// Put uploads each file in paths under the prefix, keyed by its path
+// relative to dir, or by its name if dir is empty, and logs where it went. It stops at the first file that
+// cannot be uploaded after retries.
+func (u Upload) Put(paths []string, dir string, verbose bool) error {
+	getenv := u.getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	client := u.client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	creds, err := loadAWSCredentials(client, getenv)
+	if err != nil {
+		return fmt.Errorf("error: --upload found no AWS credentials: %w", err)
+	}
+	s := s3Target{
+		bucket:   u.Bucket,
+		region:   cmp.Or(getenv("AWS_REGION"), getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+		endpoint: cmp.Or(getenv("AWS_ENDPOINT_URL_S3"), getenv("AWS_ENDPOINT_URL")),
+		creds:    creds,
+		client:   client,
+		now:      u.now,
+	}
+	for _, path := range paths {
+		rel := filepath.Base(path)
+		if r, err := filepath.Rel(dir, path); dir != "" && err == nil && !strings.HasPrefix(r, "..") {
+			rel = r
+		}
+		key := u.Prefix + filepath.ToSlash(rel)
+		err = u.Retry.Do("uploading "+filepath.Base(path), verbose, func() error {
+			return s.put(key, path)
+		})
+		if err != nil {
+			return err
+		}
+		logger.Printf("Uploaded %s to s3://%s/%s", path, u.Bucket, key)
+	}
+	return nil
+}
+
+// s3Target is a bucket and the credentials to write to it.
+type s3Target struct {
+	bucket   string
+	region   string
+	endpoint string // an S3-compatible endpoint; empty for AWS
+	creds    awsCredentials
+	client   *http.Client
+	now      func() time.Time
+}
+
+// objectURL is the URL of key. AWS buckets are addressed by host name,
+// except those with dots, which the wildcard certificate does not cover;
+// other endpoints take the bucket in the path.
+func (s *s3Target) objectURL(key string) string {
+	path := "/" + s3EscapePath(key)
+	switch {
+	case s.endpoint != "":
+		return strings.TrimSuffix(s.endpoint, "/") + "/" + s.bucket + path
+	case strings.Contains(s.bucket, "."):
+		return "https://s3." + s.region + ".amazonaws.com/" + s.bucket + path
+	}
+	return "https://" + s.bucket + ".s3." + s.region + ".amazonaws.com" + path
+}
+
+// put uploads the file at path as key with a single PUT. A bucket in
+// another region than the one configured is retried there once, as S3
+// names the right one in its error.
+func (s *s3Target) put(key, path string) error {
+	hash, size, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPut, s.objectURL(key), f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		req.ContentLength = size
+		req.Header.Set("x-amz-content-sha256", hash)
+		now := time.Now
+		if s.now != nil {
+			now = s.now
+		}
+		signV4(req, s.creds, s.region, "s3", hash, now())
+
+		resp, err := s.client.Do(req)
+		f.Close()
+		if err != nil {
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			return &APIError{Message: fmt.Sprintf("error: unable to reach S3: %v", err)}
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			return nil
+		}
+		if region := resp.Header.Get("x-amz-bucket-region"); region != "" && region != s.region && attempt == 0 {
+			s.region = region
+			continue
+		}
+		return &APIError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("error: S3 rejected the upload of %s with HTTP %d%s", filepath.Base(path), resp.StatusCode, s3ErrorCode(body))}
+	}
+}
+
+// s3ErrorCode returns ": " and the Code of an S3 XML error body, or "".
+func s3ErrorCode(body []byte) string {
+	_, rest, ok := strings.Cut(string(body), "<Code>")
+	if !ok {
+		return ""
+	}
+	code, _, _ := strings.Cut(rest, "</Code>")
+	return ": " + code
+}
+
+// fileSHA256 returns the hex SHA-256 and size of the file at path; S3
+// needs the hash before the body is sent.
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header to req,
+// signing the host and every x-amz-*, content-type, content-md5, date and
+// range header it has. payloadHash is the hex SHA-256 of the body.
+func signV4(req *http.Request, creds awsCredentials, region, service, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("x-amz-date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		switch {
+		case strings.HasPrefix(name, "x-amz-"), name == "content-type", name == "content-md5", name == "date", name == "range":
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := now.Format("20060102") + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{now.Format("20060102"), region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath escapes an object key for the request path as SigV4
+// expects: everything but unreserved characters and the / separators.
+func s3EscapePath(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', strings.IndexByte("-_.~/", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsCredentials are the keys an upload is signed with.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // temporary credentials only
+}
+
+// imdsURL is the EC2 instance metadata service; tests replace it.
+var imdsURL = "http://169.254.169.254"
+
+// loadAWSCredentials looks for credentials where the AWS CLI does: the
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables, the
+// shared credentials file for AWS_PROFILE (default "default"), then the
+// EC2 instance profile.
+func loadAWSCredentials(client *http.Client, getenv func(string) string) (awsCredentials, error) {
+	if id, secret := getenv("AWS_ACCESS_KEY_ID"), getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	path := getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, ".aws", "credentials")
+		}
+	}
+	profile := cmp.Or(getenv("AWS_PROFILE"), "default")
+	if creds, err := readSharedCredentials(path, profile); err == nil {
+		return creds, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return awsCredentials{}, err
+	}
+
+	creds, err := instanceProfileCredentials(client)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("none in the environment or %s, and no instance profile (%v)", path, err)
+	}
+	return creds, nil
+}
+
+// readSharedCredentials reads profile from an AWS shared credentials file.
+// A missing file or profile is reported as os.ErrNotExist.
+func readSharedCredentials(path, profile string) (awsCredentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	defer f.Close()
+	var creds awsCredentials
+	var section string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section != profile {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.SessionToken = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return awsCredentials{}, err
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return awsCredentials{}, fmt.Errorf("profile %q in %s: %w", profile, path, os.ErrNotExist)
+	}
+	return creds, nil
+}
+
+// instanceProfileCredentials fetches the EC2 instance role's temporary
+// credentials through IMDSv2.
+func instanceProfileCredentials(client *http.Client) (awsCredentials, error) {
+	imds := *client
+	imds.Timeout = 2 * time.Second // off EC2 nothing answers
+	get := func(method, path string, header http.Header) (string, error) {
+		req, err := http.NewRequest(method, imdsURL+path, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header = header
+		resp, err := imds.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if err == nil && resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("instance metadata returned HTTP %d", resp.StatusCode)
+		}
+		return strings.TrimSpace(string(body)), err
+	}
+
+	token, err := get(http.MethodPut, "/latest/api/token", http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"300"}})
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	header := http.Header{"X-Aws-Ec2-Metadata-Token": {token}}
+	role, err := get(http.MethodGet, "/latest/meta-data/iam/security-credentials/", header)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	role, _, _ = strings.Cut(role, "\n")
+	body, err := get(http.MethodGet, "/latest/meta-data/iam/security-credentials/"+role, header)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	var c struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+	}
+	if err := json.Unmarshal([]byte(body), &c); err != nil {
+		return awsCredentials{}, fmt.Errorf("unreadable instance credentials: %w", err)
+	}
+	return awsCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.Token}, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MinGuestServerVersion is the release that introduced guest accounts.
+const MinGuestServerVersion = "5.16.0"
+
+// serverRequirement is an enrichment that needs a newer server than guest
+// accounts do.
+type serverRequirement struct {
+	enrichment string
+	flag       string // the flag requesting it; empty if it always runs
+	minVersion string
+	requested  func(AuditOptions) bool
+}
+
+// serverRequirements lists the enrichments whose endpoints older servers
+// lack. They are switched off up front rather than failing with a 404 on
+// the first guest.
+var serverRequirements = []serverRequirement{
+	// POST /teams/{team_id}/files/search
+	{EnrichFileActivity, "--file-activity", "5.34.0", func(o AuditOptions) bool { return o.FileActivity }},
+	// GET /users/{user_id}/channels/policies
+	{EnrichRetention, "", "5.35.0", func(AuditOptions) bool { return true }},
+	// GET /custom_profile_attributes/fields
+	{EnrichProfileFields, "--profile-fields", "10.5.0", func(o AuditOptions) bool { return len(o.ProfileFields) > 0 }},
+}
+
+// ParseServerVersion parses a major.minor.patch release number, ignoring
+// any build suffix. It reports false if version is not one.
+func ParseServerVersion(version string) ([3]int, bool) {
+	var v [3]int
+	parts := strings.SplitN(version, ".", 4)
+	if len(parts) < 3 {
+		return v, false
+	}
+	for i := range v {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+// ServerAtLeast reports whether version is min or newer. An unknown or
+// unparsable version counts as new enough, leaving the server to reject
+// what it does not support.
+func ServerAtLeast(version, min string) bool {
+	v, ok := ParseServerVersion(version)
+	m, mok := ParseServerVersion(min)
+	if !ok || !mok {
+		return true
+	}
+	for i := range v {
+		if v[i] != m[i] {
+			return v[i] > m[i]
+		}
+	}
+	return true
+}
+
+// disableForVersion switches off the requested enrichments that version is
+// too old for, warning on w about those asked for by flag.
+func (s *enrichmentState) disableForVersion(version string, opts AuditOptions, w io.Writer) {
+	if !ServerAtLeast(version, MinGuestServerVersion) {
+		fmt.Fprintf(w, "Warning: Mattermost %s predates guest accounts (added in %s); the report may be empty\n", version, MinGuestServerVersion)
+	}
+	for _, r := range serverRequirements {
+		if !r.requested(opts) || ServerAtLeast(version, r.minVersion) || !s.enabled(r.enrichment) {
+			continue
+		}
+		s.unavailable = append(s.unavailable, r.enrichment)
+		if r.flag != "" {
+			fmt.Fprintf(w, "Warning: %s needs Mattermost %s or later, but this server is %s; skipping it\n", r.flag, r.minVersion, version)
+		} else if opts.Verbose {
+			fmt.Fprintf(w, "Skipping %s, which needs Mattermost %s or later (server is %s)\n", r.enrichment, r.minVersion, version)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestServerAtLeast(t *testing.T) {
+	tests := []struct {
+		version, min string
+		want         bool
+	}{
+		{"9.11.0", "5.34.0", true},
+		{"5.34.0", "5.34.0", true},
+		{"5.33.9", "5.34.0", false},
+		{"5.9.0", "5.16.0", false},
+		{"10.0.0", "9.11.2", true},
+		{"5.35.0.123.abc.true", "5.35.0", true},
+		{"", "5.34.0", true},          // unknown: let the server decide
+		{"dev-build", "5.34.0", true}, // unparsable, likewise
+	}
+	for _, tt := range tests {
+		if got := ServerAtLeast(tt.version, tt.min); got != tt.want {
+			t.Errorf("ServerAtLeast(%q, %q) = %v, want %v", tt.version, tt.min, got, tt.want)
+		}
+	}
+}
+
+func TestDisableForVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		version     string
+		opts        AuditOptions
+		unavailable []string
+		warning     string
+	}{
+		{"current server", "9.11.0", AuditOptions{FileActivity: true}, nil, ""},
+		{"unknown version", "", AuditOptions{FileActivity: true}, nil, ""},
+		{"no file search", "5.31.0", AuditOptions{FileActivity: true}, []string{EnrichFileActivity, EnrichRetention}, "--file-activity needs Mattermost 5.34.0 or later, but this server is 5.31.0"},
+		{"not requested", "5.31.0", AuditOptions{}, []string{EnrichRetention}, ""},
+		{"no retention policies", "5.34.1", AuditOptions{FileActivity: true}, []string{EnrichRetention}, ""},
+		{"no guest accounts", "5.12.0", AuditOptions{}, []string{EnrichRetention}, "predates guest accounts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state enrichmentState
+			var w bytes.Buffer
+			state.disableForVersion(tt.version, tt.opts, &w)
+			if !slices.Equal(state.unavailable, tt.unavailable) {
+				t.Errorf("unavailable = %v, want %v", state.unavailable, tt.unavailable)
+			}
+			if tt.warning == "" && w.Len() > 0 || !strings.Contains(w.String(), tt.warning) {
+				t.Errorf("warning = %q, want %q", w.String(), tt.warning)
+			}
+		})
+	}
+}
+
+func TestRunAudit_OldServer(t *testing.T) {
+	client := &mockClient{
+		guests:      sampleGuests(1),
+		serverInfo:  ServerInfo{Version: "5.31.0"},
+		policyCount: 1,
+		fileCounts:  map[string]int{"guest0": 4},
+		teams:       map[string][]*model.Team{"user0": {{Id: "team1", DisplayName: "Engineering"}}},
+		channels:    map[string][]*model.Channel{"team1:user0": {{Id: "ch1", DisplayName: "General"}}},
+		channelPolicies: map[string][]*model.RetentionPolicyForChannel{
+			"user0": {{ChannelID: "ch1", PostDurationDays: 30}},
+		},
+	}
+
+	result, exitCode := RunAudit(client, AuditOptions{FileActivity: true})
+	if exitCode != ExitSuccess {
+		t.Fatalf("exit code = %d, want %d", exitCode, ExitSuccess)
+	}
+	if want := []string{EnrichFileActivity, EnrichRetention}; !slices.Equal(result.UnavailableEnrichment, want) {
+		t.Errorf("unavailable = %v, want %v", result.UnavailableEnrichment, want)
+	}
+	if g := result.Guests[0]; g.FileCount != nil || g.RetentionChannels != 0 {
+		t.Errorf("file_count = %v, retention_channels = %d; want neither looked up", g.FileCount, g.RetentionChannels)
+	}
+	if len(result.PermissionMissing) > 0 {
+		t.Errorf("permission_missing = %v, want none for an old server", result.PermissionMissing)
+	}
+}
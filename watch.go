@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// WatchDirLayout names each run's subdirectory under --output-dir.
+const WatchDirLayout = "20060102T150405Z"
+
+// ResultDelta lists the guests that changed between two audit results,
+// matched by username and compared by checksum.
+type ResultDelta struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// Empty reports whether the two results had identical guests.
+func (d ResultDelta) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (d ResultDelta) String() string {
+	return fmt.Sprintf("%d added, %d removed, %d changed", len(d.Added), len(d.Removed), len(d.Changed))
+}
+
+// DiffResults compares two audit results. Each list is sorted by username.
+func DiffResults(prev, cur *AuditResult) ResultDelta {
+	before := make(map[string]string, len(prev.Guests))
+	for _, g := range prev.Guests {
+		before[g.Username] = g.Checksum
+	}
+
+	var d ResultDelta
+	seen := make(map[string]bool, len(cur.Guests))
+	for _, g := range cur.Guests {
+		seen[g.Username] = true
+		checksum, ok := before[g.Username]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, g.Username)
+		case checksum != g.Checksum:
+			d.Changed = append(d.Changed, g.Username)
+		}
+	}
+	for _, g := range prev.Guests {
+		if !seen[g.Username] {
+			d.Removed = append(d.Removed, g.Username)
+		}
+	}
+
+	slices.Sort(d.Added)
+	slices.Sort(d.Removed)
+	slices.Sort(d.Changed)
+	return d
+}
+
+// WatchRunDir returns the directory for the run started at t.
+func WatchRunDir(dir string, t time.Time) string {
+	return filepath.Join(dir, t.UTC().Format(WatchDirLayout))
+}
+
+// Watch calls run immediately and then every interval until ctx is done.
+// The interval is measured from the start of one run to the next; a run
+// that takes longer than the interval is followed straight away by the next.
+func Watch(ctx context.Context, interval time.Duration, run func(started time.Time)) {
+	for {
+		started := time.Now()
+		run(started)
+
+		wait := interval - time.Since(started)
+		if wait < 0 {
+			wait = 0
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// formatDelta renders a delta for the watch log. Usernames are listed only
+// when verbose.
+func formatDelta(d ResultDelta, verbose bool) string {
+	if !verbose || d.Empty() {
+		return d.String()
+	}
+	var b strings.Builder
+	b.WriteString(d.String())
+	for _, part := range []struct {
+		label string
+		names []string
+	}{{"added", d.Added}, {"removed", d.Removed}, {"changed", d.Changed}} {
+		if len(part.names) > 0 {
+			fmt.Fprintf(&b, "\n  %s: %s", part.label, strings.Join(part.names, ", "))
+		}
+	}
+	return b.String()
+}
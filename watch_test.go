@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffResults(t *testing.T) {
+	prev := &AuditResult{Guests: []GuestRecord{
+		{Username: "alice", Checksum: "a1"},
+		{Username: "bob", Checksum: "b1"},
+		{Username: "carol", Checksum: "c1"},
+	}}
+	cur := &AuditResult{Guests: []GuestRecord{
+		{Username: "dave", Checksum: "d1"},
+		{Username: "carol", Checksum: "c2"},
+		{Username: "alice", Checksum: "a1"},
+	}}
+
+	d := DiffResults(prev, cur)
+	if !slices.Equal(d.Added, []string{"dave"}) {
+		t.Errorf("Added = %v, want [dave]", d.Added)
+	}
+	if !slices.Equal(d.Removed, []string{"bob"}) {
+		t.Errorf("Removed = %v, want [bob]", d.Removed)
+	}
+	if !slices.Equal(d.Changed, []string{"carol"}) {
+		t.Errorf("Changed = %v, want [carol]", d.Changed)
+	}
+	if d.Empty() {
+		t.Error("Empty() = true, want false")
+	}
+	if got := d.String(); got != "1 added, 1 removed, 1 changed" {
+		t.Errorf("String() = %q", got)
+	}
+
+	if d := DiffResults(prev, prev); !d.Empty() {
+		t.Errorf("DiffResults(prev, prev) = %+v, want empty", d)
+	}
+}
+
+func TestFormatDelta(t *testing.T) {
+	d := ResultDelta{Added: []string{"dave"}, Changed: []string{"alice", "carol"}}
+
+	if got := formatDelta(d, false); got != "1 added, 0 removed, 2 changed" {
+		t.Errorf("formatDelta(quiet) = %q", got)
+	}
+	got := formatDelta(d, true)
+	if !strings.Contains(got, "added: dave") || !strings.Contains(got, "changed: alice, carol") {
+		t.Errorf("formatDelta(verbose) = %q, want usernames listed", got)
+	}
+	if strings.Contains(got, "removed:") {
+		t.Errorf("formatDelta(verbose) = %q, should omit empty lists", got)
+	}
+}
+
+func TestWatchRunDir(t *testing.T) {
+	started := time.Date(2026, 3, 1, 9, 30, 0, 0, time.FixedZone("CET", 3600))
+	want := filepath.Join("reports", "20260301T083000Z")
+	if got := WatchRunDir("reports", started); got != want {
+		t.Errorf("WatchRunDir = %q, want %q", got, want)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := 0
+	done := make(chan struct{})
+	go func() {
+		Watch(ctx, time.Millisecond, func(time.Time) {
+			runs++
+			if runs == 3 {
+				cancel()
+			}
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not stop after the context was cancelled")
+	}
+	if runs != 3 {
+		t.Errorf("runs = %d, want 3", runs)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Webhook payload formats for --notify-format.
+const (
+	WebhookSlack   = "slack"   // Slack and Mattermost incoming webhooks
+	WebhookTeams   = "teams"   // Microsoft Teams workflow webhooks (Adaptive Card)
+	WebhookGeneric = "generic" // plain JSON for scripts
+
+	WebhookMattermost = FormatMattermost // Mattermost message attachment, see AuditAttachment
+)
+
+var webhookFormats = []string{WebhookSlack, WebhookTeams, WebhookGeneric, WebhookMattermost}
+
+// webhookTopInactive is how many inactive guests a notification names.
+const webhookTopInactive = 5
+
+// Webhook posts a short summary of each finished audit to a chat webhook.
+// The URL usually embeds a secret, so it is never logged or put in errors.
+type Webhook struct {
+	URL       string
+	Format    string // see webhookFormats
+	ReportURL string // link to the full report, if it is published somewhere
+	Retry     RetryPolicy
+
+	client *http.Client // overridden in tests
+}
+
+// Enabled reports whether a webhook was configured.
+func (h Webhook) Enabled() bool {
+	return h.URL != ""
+}
+
+// ParseWebhookFormat validates the --notify-format flag value.
+func ParseWebhookFormat(s string) (string, error) {
+	for _, f := range webhookFormats {
+		if s == f {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("error: invalid --notify-format %q. Use slack, teams, generic or mattermost", s)
+}
+
+// ValidateWebhookURL checks that s is an absolute http(s) URL, without
+// quoting it back: it usually embeds a secret.
+func ValidateWebhookURL(flagName, s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("error: invalid %s: use an http:// or https:// URL", flagName)
+	}
+	return nil
+}
+
+// WebhookGuest is one inactive guest named in a notification.
+type WebhookGuest struct {
+	Username     string  `json:"username"`
+	LastActivity *string `json:"last_activity"` // null if never active
+}
+
+// TopInactiveGuests returns up to n inactive guests, longest inactive
+// first. Guests who were never active come first, then by last login or
+// post. Excepted and deactivated guests, and failed lookups, are left out,
+// as they are from the inactive count.
+func TopInactiveGuests(result *AuditResult, n int) []GuestRecord {
+	var inactive []GuestRecord
+	for _, g := range result.Guests {
+		if g.Inactive && g.Active && !g.Excepted && !g.Failed && !g.ShouldBeGuest {
+			inactive = append(inactive, g)
+		}
+	}
+	sort.SliceStable(inactive, func(i, j int) bool {
+		a := LastActivity(inactive[i].LastLogin, inactive[i].LastPost)
+		b := LastActivity(inactive[j].LastLogin, inactive[j].LastPost)
+		switch {
+		case a == nil && b == nil:
+			return inactive[i].Username < inactive[j].Username
+		case a == nil || b == nil:
+			return a == nil
+		case !a.Equal(*b):
+			return a.Before(*b)
+		}
+		return inactive[i].Username < inactive[j].Username
+	})
+	if len(inactive) > n {
+		inactive = inactive[:n]
+	}
+	return inactive
+}
+
+// webhookText is the notification as plain lines, shared by the chat
+// formats.
+func webhookText(result *AuditResult, reportURL string) (title string, lines []string) {
+	s := result.Summary
+	title = "Guest audit"
+	if result.Metadata != nil && result.Metadata.ServerURL != "" {
+		title += " of " + strings.TrimPrefix(strings.TrimPrefix(result.Metadata.ServerURL, "https://"), "http://")
+	}
+	counts := fmt.Sprintf("%d guest(s): %d active, %d inactive, %d deactivated, %d excepted", s.TotalGuests, s.ActiveGuests, s.InactiveGuests, s.DeactivatedGuests, s.ExceptedGuests)
+	if s.FailedLookups > 0 {
+		counts += fmt.Sprintf(", %d failed lookup(s)", s.FailedLookups)
+	}
+	lines = append(lines, counts)
+	if s.InactiveGuests > 0 && result.InactiveDays > 0 {
+		lines = append(lines, fmt.Sprintf("Inactive means no activity in the last %d days.", result.InactiveDays))
+	}
+	if top := TopInactiveGuests(result, webhookTopInactive); len(top) > 0 {
+		names := make([]string, len(top))
+		for i, g := range top {
+			last := LastActivity(g.LastLogin, g.LastPost)
+			if last == nil {
+				names[i] = g.Username + " (never active)"
+			} else {
+				names[i] = fmt.Sprintf("%s (last active %s)", g.Username, last.UTC().Format("2006-01-02"))
+			}
+		}
+		lines = append(lines, "Longest inactive: "+strings.Join(names, ", "))
+	}
+	if reportURL != "" {
+		lines = append(lines, "Full report: "+reportURL)
+	}
+	return title, lines
+}
+
+// Payload builds the request body for the webhook's format.
+func (h Webhook) Payload(result *AuditResult) ([]byte, error) {
+	title, lines := webhookText(result, h.ReportURL)
+	var payload any
+	switch h.Format {
+	case WebhookTeams:
+		body := []map[string]any{{"type": "TextBlock", "text": title, "weight": "Bolder", "size": "Medium", "wrap": true}}
+		for _, line := range lines {
+			body = append(body, map[string]any{"type": "TextBlock", "text": line, "wrap": true})
+		}
+		payload = map[string]any{
+			"type": "message",
+			"attachments": []map[string]any{{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]any{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body":    body,
+				},
+			}},
+		}
+	case WebhookMattermost:
+		payload = AuditAttachment(result, h.ReportURL)
+	case WebhookGeneric:
+		top := TopInactiveGuests(result, webhookTopInactive)
+		guests := make([]WebhookGuest, len(top))
+		for i, g := range top {
+			guests[i] = WebhookGuest{Username: g.Username, LastActivity: timeToStringPtr(LastActivity(g.LastLogin, g.LastPost))}
+		}
+		var reportURL *string
+		if h.ReportURL != "" {
+			reportURL = &h.ReportURL
+		}
+		payload = struct {
+			Title       string           `json:"title"`
+			Text        string           `json:"text"`
+			Metadata    *jsonRunMetadata `json:"metadata,omitempty"`
+			Summary     AuditSummary     `json:"summary"`
+			TopInactive []WebhookGuest   `json:"top_inactive"`
+			ReportURL   *string          `json:"report_url"`
+		}{title, strings.Join(lines, "\n"), toJSONMetadata(result.Metadata), result.Summary, guests, reportURL}
+	default:
+		payload = map[string]string{"text": title + "\n" + strings.Join(lines, "\n")}
+	}
+	return json.MarshalIndent(payload, "", "  ")
+}
+
+// Post sends the summary of result to the webhook, retrying transient
+// failures like API calls.
+func (h Webhook) Post(result *AuditResult, verbose bool) error {
+	body, err := h.Payload(result)
+	if err != nil {
+		return err
+	}
+	client := h.client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return h.Retry.Do("posting to --notify-webhook", verbose, func() error {
+		resp, err := client.Post(h.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			// url.Error quotes the URL; keep only the cause
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			return &APIError{Message: fmt.Sprintf("error: unable to reach the notification webhook: %v", err)}
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return &APIError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("error: the notification webhook returned HTTP %d", resp.StatusCode)}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func webhookResult() *AuditResult {
+	result := sampleResult()
+	old := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	result.Guests = append(result.Guests,
+		GuestRecord{Username: "amy.old", Active: true, Inactive: true, LastLogin: &old},
+		GuestRecord{Username: "ex.cepted", Active: true, Inactive: true, Excepted: true},
+		GuestRecord{Username: "gone", Inactive: true},
+	)
+	result.Metadata = &RunMetadata{ServerURL: "https://chat.example.com"}
+	summarize(result, nil, time.Now())
+	return result
+}
+
+func TestTopInactiveGuests(t *testing.T) {
+	var names []string
+	for _, g := range TopInactiveGuests(webhookResult(), 5) {
+		names = append(names, g.Username)
+	}
+	if got := strings.Join(names, ","); got != "bob.contractor,amy.old" {
+		t.Errorf("top inactive = %q, want never-active bob.contractor, then amy.old", got)
+	}
+	if n := len(TopInactiveGuests(webhookResult(), 1)); n != 1 {
+		t.Errorf("expected the list cut to 1, got %d", n)
+	}
+}
+
+func TestWebhookPayload(t *testing.T) {
+	result := webhookResult()
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{WebhookSlack, []string{`"text"`, "Guest audit of chat.example.com", "5 guest(s): 1 active, 2 inactive, 1 deactivated, 1 excepted", "bob.contractor (never active), amy.old (last active 2024-05-01)", "Full report: https://reports.example.com/latest"}},
+		{WebhookTeams, []string{`"AdaptiveCard"`, `"application/vnd.microsoft.card.adaptive"`, "Guest audit of chat.example.com"}},
+		{WebhookGeneric, []string{`"summary"`, `"top_inactive"`, `"last_activity": null`, `"report_url": "https://reports.example.com/latest"`}},
+		{WebhookMattermost, []string{`"attachments"`, `"color": "#ffbc1f"`, `"title_link": "https://reports.example.com/latest"`, "bob.contractor (never active)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			h := Webhook{Format: tt.format, ReportURL: "https://reports.example.com/latest"}
+			data, err := h.Payload(result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !json.Valid(data) {
+				t.Fatalf("invalid JSON: %s", data)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(data), want) {
+					t.Errorf("payload missing %q:\n%s", want, data)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookPost(t *testing.T) {
+	var bodies []string
+	failures := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+	}))
+	defer srv.Close()
+
+	// A transient failure is retried
+	retry := RetryPolicy{MaxRetries: 2, sleep: func(time.Duration) {}}
+	h := Webhook{URL: srv.URL + "/hooks/secret-key", Format: WebhookSlack, Retry: retry}
+	if err := h.Post(webhookResult(), false); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	if len(bodies) != 1 || !strings.Contains(bodies[0], "Guest audit") {
+		t.Errorf("unexpected posts: %v", bodies)
+	}
+
+	// Errors never quote the URL, which holds the webhook's secret
+	h.URL = "http://127.0.0.1:1/hooks/secret-key"
+	h.Retry = RetryPolicy{}
+	err := h.Post(webhookResult(), false)
+	if err == nil || strings.Contains(err.Error(), "secret-key") {
+		t.Errorf("expected an error without the URL, got %v", err)
+	}
+}
+
+func TestParseWebhookFormat(t *testing.T) {
+	for _, f := range []string{"slack", "teams", "generic"} {
+		if _, err := ParseWebhookFormat(f); err != nil {
+			t.Errorf("ParseWebhookFormat(%q): %v", f, err)
+		}
+	}
+	if _, err := ParseWebhookFormat("discord"); err == nil {
+		t.Error("expected an error for discord")
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	if err := ValidateWebhookURL("--notify-webhook", "https://hooks.slack.com/services/T0/B0/xyz"); err != nil {
+		t.Errorf("valid URL rejected: %v", err)
+	}
+	err := ValidateWebhookURL("--notify-webhook", "hooks.slack.com/services/T0/B0/xyz")
+	if err == nil || strings.Contains(err.Error(), "xyz") {
+		t.Errorf("expected an error without the URL, got %v", err)
+	}
+}
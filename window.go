@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OperationsWindow is a daily time range, in local time, during which API
+// calls are allowed. A window whose end is before its start runs overnight
+// (e.g. 22:00-06:00).
+type OperationsWindow struct {
+	Start, End time.Duration // offsets from local midnight
+}
+
+// ParseWindow parses "HH:MM-HH:MM".
+func ParseWindow(s string) (*OperationsWindow, error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(s), "-")
+	if !ok {
+		return nil, fmt.Errorf("error: invalid window %q: expected HH:MM-HH:MM", s)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid window %q: %v", s, err)
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid window %q: %v", s, err)
+	}
+	if start == end {
+		return nil, fmt.Errorf("error: invalid window %q: start and end are the same", s)
+	}
+	return &OperationsWindow{Start: start, End: end}, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a 24-hour HH:MM time", strings.TrimSpace(s))
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// String formats the window as "HH:MM-HH:MM".
+func (w *OperationsWindow) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return clock(w.Start) + "-" + clock(w.End)
+}
+
+// Contains reports whether t falls inside the window. The start is
+// inclusive and the end exclusive.
+func (w *OperationsWindow) Contains(t time.Time) bool {
+	offset := t.Sub(midnight(t))
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// NextOpen returns the next time at or after t when the window opens.
+func (w *OperationsWindow) NextOpen(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	open := midnight(t).Add(w.Start)
+	if open.Before(t) {
+		y, m, d := t.Date()
+		open = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()).Add(w.Start)
+	}
+	return open
+}
+
+// midnight returns the start of t's day in t's location.
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// windowGate holds API calls while the operations window is closed. Calls
+// already in flight finish; new ones wait until the window reopens.
+type windowGate struct {
+	window *OperationsWindow
+	log    io.Writer
+
+	mu     sync.Mutex
+	paused bool
+
+	now   func() time.Time                                 // overridden in tests
+	sleep func(ctx context.Context, d time.Duration) error // overridden in tests
+}
+
+func newWindowGate(w *OperationsWindow, log io.Writer) *windowGate {
+	return &windowGate{window: w, log: log, now: time.Now, sleep: sleepContext}
+}
+
+// Wait blocks until the window is open or ctx is done. A nil gate never blocks.
+func (g *windowGate) Wait(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	for {
+		now := g.now()
+		if g.window.Contains(now) {
+			g.mu.Lock()
+			if g.paused {
+				g.paused = false
+				fmt.Fprintf(g.log, "Operations window %s open, resuming\n", g.window)
+			}
+			g.mu.Unlock()
+			return nil
+		}
+		open := g.window.NextOpen(now)
+		g.mu.Lock()
+		if !g.paused {
+			g.paused = true
+			fmt.Fprintf(g.log, "Outside operations window %s, pausing until %s\n", g.window, open.Format("2006-01-02 15:04 MST"))
+		}
+		g.mu.Unlock()
+		if err := g.sleep(ctx, open.Sub(now)); err != nil {
+			return err
+		}
+	}
+}
+
+// windowTransport waits on the gate before every HTTP request.
+type windowTransport struct {
+	gate *windowGate
+	next http.RoundTripper
+}
+
+func (t *windowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.gate.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"08:00-18:00", "08:00-18:00", false},
+		{" 8:30 - 17:45 ", "08:30-17:45", false},
+		{"22:00-06:00", "22:00-06:00", false},
+		{"08:00", "", true},
+		{"08:00-25:00", "", true},
+		{"8am-6pm", "", true},
+		{"09:00-09:00", "", true},
+	}
+	for _, tt := range tests {
+		w, err := ParseWindow(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseWindow(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && w.String() != tt.want {
+			t.Errorf("ParseWindow(%q) = %s, want %s", tt.in, w, tt.want)
+		}
+	}
+}
+
+func TestOperationsWindow_ContainsAndNextOpen(t *testing.T) {
+	day := func(h, m int) time.Time { return time.Date(2024, 11, 1, h, m, 0, 0, time.UTC) }
+	next := func(h, m int) time.Time { return time.Date(2024, 11, 2, h, m, 0, 0, time.UTC) }
+
+	office, _ := ParseWindow("08:00-18:00")
+	overnight, _ := ParseWindow("22:00-06:00")
+	tests := []struct {
+		name     string
+		window   *OperationsWindow
+		at       time.Time
+		contains bool
+		nextOpen time.Time
+	}{
+		{"before office hours", office, day(7, 59), false, day(8, 0)},
+		{"at opening", office, day(8, 0), true, day(8, 0)},
+		{"during office hours", office, day(12, 0), true, day(12, 0)},
+		{"at closing", office, day(18, 0), false, next(8, 0)},
+		{"evening", office, day(23, 0), false, next(8, 0)},
+		{"overnight late", overnight, day(23, 0), true, day(23, 0)},
+		{"overnight early", overnight, day(5, 59), true, day(5, 59)},
+		{"overnight closed", overnight, day(6, 0), false, day(22, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Contains(tt.at); got != tt.contains {
+				t.Errorf("Contains = %v, want %v", got, tt.contains)
+			}
+			if got := tt.window.NextOpen(tt.at); !got.Equal(tt.nextOpen) {
+				t.Errorf("NextOpen = %v, want %v", got, tt.nextOpen)
+			}
+		})
+	}
+}
+
+func TestWindowGate_PausesAndResumes(t *testing.T) {
+	w, _ := ParseWindow("08:00-18:00")
+	var log bytes.Buffer
+	g := newWindowGate(w, &log)
+	now := time.Date(2024, 11, 1, 19, 30, 0, 0, time.UTC)
+	var slept []time.Duration
+	g.now = func() time.Time { return now }
+	g.sleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		now = now.Add(d)
+		return nil
+	}
+
+	if err := g.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if len(slept) != 1 || slept[0] != 12*time.Hour+30*time.Minute {
+		t.Errorf("slept %v, want one 12h30m pause", slept)
+	}
+	out := log.String()
+	if !strings.Contains(out, "pausing until 2024-11-02 08:00") || !strings.Contains(out, "resuming") {
+		t.Errorf("unexpected log output:\n%s", out)
+	}
+
+	// Inside the window calls go straight through, without more logging
+	log.Reset()
+	if err := g.Wait(context.Background()); err != nil || len(slept) != 1 || log.Len() != 0 {
+		t.Errorf("Wait inside window: err=%v slept=%v log=%q", err, slept, log.String())
+	}
+}
+
+func TestWindowGate_Cancelled(t *testing.T) {
+	w, _ := ParseWindow("08:00-18:00")
+	g := newWindowGate(w, &bytes.Buffer{})
+	g.now = func() time.Time { return time.Date(2024, 11, 1, 20, 0, 0, 0, time.UTC) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.Wait(ctx); err == nil {
+		t.Error("expected error from cancelled context")
+	}
+
+	var nilGate *windowGate
+	if err := nilGate.Wait(context.Background()); err != nil {
+		t.Errorf("nil gate Wait returned %v", err)
+	}
+}